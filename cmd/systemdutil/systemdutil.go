@@ -219,6 +219,7 @@ func (r *RequestProcessor) CreateAndRun(c *CLIRequest) error {
 		PUType:             common.LinuxProcessPU,
 		Name:               c.ServiceName,
 		Tags:               c.Labels,
+		Executable:         c.Executable,
 		PID:                int32(os.Getpid()),
 		EventType:          common.EventStart,
 		Services:           c.Services,
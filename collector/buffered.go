@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// bufferedDropReportInterval is the interval of the reports of the events
+// dropped because the buffer was full.
+const bufferedDropReportInterval = time.Minute
+
+// BufferedCollector reports the events to a collector in the background, so
+// that a slow collector degrades the observability rather than the callers.
+// The events are dropped and counted when the buffer is full.
+type BufferedCollector struct {
+	collector EventCollector
+	events    chan func()
+	dropped   uint64
+}
+
+// NewBufferedCollector returns a collector that buffers up to size events
+// for the given collector. The events are only reported once Run is called.
+func NewBufferedCollector(c EventCollector, size int) *BufferedCollector {
+
+	return &BufferedCollector{
+		collector: c,
+		events:    make(chan func(), size),
+	}
+}
+
+// Run reports the buffered events until the context is cancelled.
+func (b *BufferedCollector) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(bufferedDropReportInterval)
+	defer ticker.Stop()
+
+	reported := uint64(0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case report := <-b.events:
+			report()
+		case <-ticker.C:
+			dropped := b.Dropped()
+			if dropped != reported {
+				zap.L().Warn("Events dropped because the collector is too slow",
+					zap.Uint64("dropped", dropped-reported),
+					zap.Uint64("total", dropped),
+				)
+				reported = dropped
+			}
+		}
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (b *BufferedCollector) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// enqueue buffers the report of an event, or drops it if the buffer is full.
+func (b *BufferedCollector) enqueue(report func()) {
+
+	select {
+	case b.events <- report:
+	default:
+		atomic.AddUint64(&b.dropped, 1)
+	}
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (b *BufferedCollector) CollectFlowEvent(record *FlowRecord) {
+	b.enqueue(func() { b.collector.CollectFlowEvent(record) })
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (b *BufferedCollector) CollectContainerEvent(record *ContainerRecord) {
+	b.enqueue(func() { b.collector.CollectContainerEvent(record) })
+}
+
+// CollectUserEvent is part of the EventCollector interface.
+func (b *BufferedCollector) CollectUserEvent(record *UserRecord) {
+	b.enqueue(func() { b.collector.CollectUserEvent(record) })
+}
+
+// CollectAllocatorEvent is part of the EventCollector interface.
+func (b *BufferedCollector) CollectAllocatorEvent(record *AllocatorRecord) {
+	b.enqueue(func() { b.collector.CollectAllocatorEvent(record) })
+}
+
+// CollectDNSEvent is part of the EventCollector interface.
+func (b *BufferedCollector) CollectDNSEvent(record *DNSRecord) {
+	b.enqueue(func() { b.collector.CollectDNSEvent(record) })
+}
+
+// CollectHealthEvent is part of the EventCollector interface.
+func (b *BufferedCollector) CollectHealthEvent(record *HealthRecord) {
+	b.enqueue(func() { b.collector.CollectHealthEvent(record) })
+}
@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// blockingCollector blocks the reports of the flows until it is released.
+type blockingCollector struct {
+	DefaultCollector
+	release chan struct{}
+	flows   int32
+}
+
+func (c *blockingCollector) CollectFlowEvent(record *FlowRecord) {
+	<-c.release
+	atomic.AddInt32(&c.flows, 1)
+}
+
+func TestBufferedCollector(t *testing.T) {
+
+	Convey("Given a buffered collector for a collector that blocks", t, func() {
+		blocking := &blockingCollector{release: make(chan struct{})}
+		buffered := NewBufferedCollector(blocking, 2)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go buffered.Run(ctx)
+
+		Convey("When the flows are reported faster than the collector accepts them", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 10; i++ {
+					buffered.CollectFlowEvent(&FlowRecord{Count: 1})
+				}
+			}()
+
+			Convey("Then the reports should not block and the extra reports should be dropped", func() {
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("the reports are blocked by the collector")
+				}
+				So(buffered.Dropped(), ShouldBeGreaterThan, 0)
+
+				close(blocking.release)
+				time.Sleep(100 * time.Millisecond)
+				So(atomic.LoadInt32(&blocking.flows), ShouldBeGreaterThan, 0)
+				So(atomic.LoadInt32(&blocking.flows), ShouldEqual, 10-int32(buffered.Dropped()))
+			})
+		})
+	})
+
+	Convey("Given a buffered collector that is not running", t, func() {
+		blocking := &blockingCollector{release: make(chan struct{})}
+		buffered := NewBufferedCollector(blocking, 2)
+
+		Convey("When more events than its size are reported, they should be dropped", func() {
+			buffered.CollectFlowEvent(&FlowRecord{})
+			buffered.CollectDNSEvent(&DNSRecord{})
+			buffered.CollectHealthEvent(&HealthRecord{})
+			So(buffered.Dropped(), ShouldEqual, 1)
+		})
+	})
+}
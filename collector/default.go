@@ -26,17 +26,77 @@ func (d *DefaultCollector) CollectContainerEvent(record *ContainerRecord) {}
 // CollectUserEvent is part of the EventCollector interface.
 func (d *DefaultCollector) CollectUserEvent(record *UserRecord) {}
 
-// StatsFlowHash is a hash function to hash flows
+// CollectAllocatorEvent is part of the EventCollector interface.
+func (d *DefaultCollector) CollectAllocatorEvent(record *AllocatorRecord) {}
+
+// CollectDNSEvent is part of the EventCollector interface.
+func (d *DefaultCollector) CollectDNSEvent(record *DNSRecord) {}
+
+// CollectHealthEvent is part of the EventCollector interface.
+func (d *DefaultCollector) CollectHealthEvent(record *HealthRecord) {}
+
+// FlowHashFunc returns the key of a flow record. The records of the same key
+// are aggregated in a single record.
+type FlowHashFunc func(r *FlowRecord) string
+
+// The names of the built-in flow hash functions.
+const (
+	// FlowHashDefault selects StatsFlowHash.
+	FlowHashDefault = "default"
+	// FlowHashCollapseSourcePort selects CollapseSourcePortFlowHash.
+	FlowHashCollapseSourcePort = "collapse-source-port"
+)
+
+// FlowHashByName returns the built-in flow hash function of the given name.
+// StatsFlowHash is returned for an empty name.
+func FlowHashByName(name string) (FlowHashFunc, error) {
+
+	switch name {
+	case "", FlowHashDefault:
+		return StatsFlowHash, nil
+	case FlowHashCollapseSourcePort:
+		return CollapseSourcePortFlowHash, nil
+	default:
+		return nil, fmt.Errorf("unknown flow hash %s", name)
+	}
+}
+
+// StatsFlowHash is a hash function to hash flows. The flows of the same
+// tuple are hashed separately for every action and reason, so that the
+// aggregated counts remain per reason.
 func StatsFlowHash(r *FlowRecord) string {
 	hash := xxhash.New()
 	hash.Write([]byte(r.Source.ID))      // nolint errcheck
 	hash.Write([]byte(r.Destination.ID)) // nolint errcheck
 	port := make([]byte, 2)
 	binary.BigEndian.PutUint16(port, r.Destination.Port)
-	hash.Write(port)                      // nolint errcheck
-	hash.Write([]byte(r.Action.String())) // nolint errcheck
-	hash.Write([]byte(r.DropReason))      // nolint errcheck
-	hash.Write([]byte(r.Destination.URI)) // nolint errcheck
+	hash.Write(port) // nolint errcheck
+	// The strings are delimited so that the reason can't run into the
+	// neighbouring fields.
+	for _, field := range []string{r.Action.String(), r.DropReason, r.Destination.URI} {
+		hash.Write([]byte(field)) // nolint errcheck
+		hash.Write([]byte{0})     // nolint errcheck
+	}
+	if r.RelaxedMutualAuth {
+		hash.Write([]byte{1}) // nolint errcheck
+	}
+
+	return fmt.Sprintf("%d", hash.Sum64())
+}
+
+// CollapseSourcePortFlowHash is a hash function to hash flows by their
+// addresses, protocol and destination port. The flows that only differ by
+// their source port are aggregated together, as well as with StatsFlowHash,
+// but the flows between different addresses of the same PUs are not.
+func CollapseSourcePortFlowHash(r *FlowRecord) string {
+	hash := xxhash.New()
+	hash.Write([]byte(StatsFlowHash(r))) // nolint errcheck
+	hash.Write([]byte{0})                // nolint errcheck
+	for _, field := range []string{r.Source.IP, r.Destination.IP} {
+		hash.Write([]byte(field)) // nolint errcheck
+		hash.Write([]byte{0})     // nolint errcheck
+	}
+	hash.Write([]byte{r.L4Protocol}) // nolint errcheck
 
 	return fmt.Sprintf("%d", hash.Sum64())
 }
@@ -36,6 +36,35 @@ const (
 	APIPolicyDrop = "api"
 	// UnableToDial indicates that the proxy cannot dial out the connection
 	UnableToDial = "dial"
+	// SocketWriteFailed indicates that a handshake packet could not be transmitted
+	SocketWriteFailed = "socketwrite"
+	// InvalidNATEntry indicates that the original destination of a flow could not be parsed
+	InvalidNATEntry = "natentry"
+	// MissingNATEntry indicates that the original destination of a flow had
+	// expired, and that the handshake was completed with the address the
+	// reply came from
+	MissingNATEntry = "nonatentry"
+	// RateLimited indicates that the connection exceeded the rate limit of the PU
+	RateLimited = "ratelimit"
+	// PUDraining indicates that the connection was dropped because the PU
+	// is drained before it is unenforced
+	PUDraining = "draining"
+	// ResourceExhausted indicates that the connection was dropped because the
+	// enforcer or the PU has too many connections in the handshake
+	ResourceExhausted = "resourceexhausted"
+	// UnenforcedSource indicates that the packet was dropped because the
+	// process that sent or receives it is not part of a PU
+	UnenforcedSource = "unenforced"
+	// ServiceError indicates that the packet was rejected by the service
+	// processor of the datapath
+	ServiceError = "service"
+	// ExcludedPort indicates that the flow bypassed the enforcement because
+	// its destination port is excluded
+	ExcludedPort = "excludedport"
+	// HandshakeTimeout indicates that the handshake of the flow did not
+	// complete before the connection expired, and that the packets queued
+	// by the application during the handshake were discarded
+	HandshakeTimeout = "handshaketimeout"
 )
 
 // Container event description
@@ -58,6 +87,12 @@ const (
 	ContainerDeleteUnknown = "unknowncontainer"
 )
 
+// Allocator names
+const (
+	// ProxyPortAllocator is the allocator of the application proxy ports
+	ProxyPortAllocator = "proxyport"
+)
+
 const (
 	// PolicyValid Normal flow accept
 	PolicyValid = "V"
@@ -78,6 +113,15 @@ type EventCollector interface {
 
 	// CollectUserEvent  collects a user event
 	CollectUserEvent(record *UserRecord)
+
+	// CollectAllocatorEvent collects the usage of an allocator
+	CollectAllocatorEvent(record *AllocatorRecord)
+
+	// CollectDNSEvent collects the activity of the rules learned from DNS
+	CollectDNSEvent(record *DNSRecord)
+
+	// CollectHealthEvent collects a change of the health of an enforcer
+	CollectHealthEvent(record *HealthRecord)
 }
 
 // EndPointType is the type of an endpoint (PU or an external IP address )
@@ -117,21 +161,62 @@ type EndPoint struct {
 	Port       uint16
 }
 
+// FlowDirection is the direction of a flow as seen by the PU that reports it.
+type FlowDirection int
+
+const (
+	// FlowDirectionUnknown indicates that the direction of the flow is not known.
+	FlowDirectionUnknown FlowDirection = iota
+	// FlowDirectionIncoming indicates a flow received by the PU.
+	FlowDirectionIncoming
+	// FlowDirectionOutgoing indicates a flow initiated by the PU.
+	FlowDirectionOutgoing
+)
+
+func (d FlowDirection) String() string {
+
+	switch d {
+	case FlowDirectionIncoming:
+		return "incoming"
+	case FlowDirectionOutgoing:
+		return "outgoing"
+	}
+
+	return "unknown"
+}
+
 // FlowRecord describes a flow record for statistis
 type FlowRecord struct {
-	ContextID        string
-	Source           *EndPoint
-	Destination      *EndPoint
-	Tags             *policy.TagStore
-	DropReason       string
-	PolicyID         string
-	ObservedPolicyID string
-	ServiceType      policy.ServiceType
-	ServiceID        string
-	Count            int
-	Action           policy.ActionType
-	ObservedAction   policy.ActionType
-	L4Protocol       uint8
+	ContextID          string
+	Source             *EndPoint
+	Destination        *EndPoint
+	Tags               *policy.TagStore
+	DropReason         string
+	PolicyID           string
+	ObservedPolicyID   string
+	SelectorID         string
+	ObservedSelectorID string
+	ServiceType        policy.ServiceType
+	ServiceID          string
+	Count              int
+	Action             policy.ActionType
+	ObservedAction     policy.ActionType
+	L4Protocol         uint8
+	Direction          FlowDirection
+	Pod                *PodMetadata
+	// RelaxedMutualAuth indicates a flow that is accepted only because the
+	// mutual authorization is disabled. PolicyID is then the transmit rule
+	// that would reject the flow if it were enforced.
+	RelaxedMutualAuth bool
+	// DroppedPackets and DroppedBytes are the application packets queued
+	// during the handshake of the flow and then discarded.
+	DroppedPackets int
+	DroppedBytes   int
+	// BytesSent and BytesReceived are the payload bytes that the source of
+	// the flow sent to the destination and received from it. A record
+	// without a count only reports the bytes of a flow counted before.
+	BytesSent     int
+	BytesReceived int
 }
 
 func (f *FlowRecord) String() string {
@@ -148,6 +233,21 @@ func (f *FlowRecord) String() string {
 	)
 }
 
+// PodMetadata identifies the Kubernetes pod of a PU.
+type PodMetadata struct {
+	Namespace string
+	Name      string
+	UID       string
+}
+
+// PodResolver returns the Kubernetes pod of a PU.
+type PodResolver interface {
+
+	// PodByPUID returns the pod of the PU, or nil if the PU is not part of a
+	// known pod.
+	PodByPUID(puID string) *PodMetadata
+}
+
 // ContainerRecord is a statistics record for a container
 type ContainerRecord struct {
 	ContextID string
@@ -156,6 +256,34 @@ type ContainerRecord struct {
 	Event     string
 }
 
+// AllocatorRecord reports the usage of an allocator of resources such as
+// the proxy ports.
+type AllocatorRecord struct {
+	Name  string
+	Total int
+	InUse int
+	Free  int
+}
+
+// DNSRecord reports the activity of the rules that a PU learned from DNS
+// resolutions since the previous record.
+type DNSRecord struct {
+	ContextID    string
+	Lookups      int
+	Unresolved   int
+	RulesAdded   int
+	RulesExpired int
+}
+
+// HealthRecord reports a change of the health of a component of an
+// enforcer. Critical is set when the enforcement of the enforcer is
+// affected, and cleared when the component recovers.
+type HealthRecord struct {
+	Component string
+	Critical  bool
+	Message   string
+}
+
 // UserRecord reports a new user access. These will be reported
 // periodically.
 type UserRecord struct {
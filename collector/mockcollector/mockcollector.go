@@ -73,3 +73,39 @@ func (m *MockEventCollector) CollectUserEvent(record *collector.UserRecord) {
 func (mr *MockEventCollectorMockRecorder) CollectUserEvent(record interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectUserEvent", reflect.TypeOf((*MockEventCollector)(nil).CollectUserEvent), record)
 }
+
+// CollectAllocatorEvent mocks base method
+// nolint
+func (m *MockEventCollector) CollectAllocatorEvent(record *collector.AllocatorRecord) {
+	m.ctrl.Call(m, "CollectAllocatorEvent", record)
+}
+
+// CollectAllocatorEvent indicates an expected call of CollectAllocatorEvent
+// nolint
+func (mr *MockEventCollectorMockRecorder) CollectAllocatorEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectAllocatorEvent", reflect.TypeOf((*MockEventCollector)(nil).CollectAllocatorEvent), record)
+}
+
+// CollectDNSEvent mocks base method
+// nolint
+func (m *MockEventCollector) CollectDNSEvent(record *collector.DNSRecord) {
+	m.ctrl.Call(m, "CollectDNSEvent", record)
+}
+
+// CollectDNSEvent indicates an expected call of CollectDNSEvent
+// nolint
+func (mr *MockEventCollectorMockRecorder) CollectDNSEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectDNSEvent", reflect.TypeOf((*MockEventCollector)(nil).CollectDNSEvent), record)
+}
+
+// CollectHealthEvent mocks base method
+// nolint
+func (m *MockEventCollector) CollectHealthEvent(record *collector.HealthRecord) {
+	m.ctrl.Call(m, "CollectHealthEvent", record)
+}
+
+// CollectHealthEvent indicates an expected call of CollectHealthEvent
+// nolint
+func (mr *MockEventCollectorMockRecorder) CollectHealthEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectHealthEvent", reflect.TypeOf((*MockEventCollector)(nil).CollectHealthEvent), record)
+}
@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"sync"
+)
+
+// PodMetadataCollector is an event collector that adds the Kubernetes pod of
+// the PU to the flow records, before they are passed to another collector.
+// The pods are resolved by a PodResolver. The Kubernetes monitor sets itself
+// as the resolver when it is given this collector, so the same collector
+// should be given to the controller and to the monitors. The records are
+// passed unchanged until a resolver is set.
+type PodMetadataCollector struct {
+	EventCollector
+
+	resolver PodResolver
+	sync.RWMutex
+}
+
+// NewPodMetadataCollector returns a collector that adds the pod metadata to
+// the flow records collected by c.
+func NewPodMetadataCollector(c EventCollector) *PodMetadataCollector {
+
+	return &PodMetadataCollector{
+		EventCollector: c,
+	}
+}
+
+// SetPodResolver sets the resolver of the pods of the PUs.
+func (p *PodMetadataCollector) SetPodResolver(resolver PodResolver) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.resolver = resolver
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (p *PodMetadataCollector) CollectFlowEvent(record *FlowRecord) {
+
+	p.RLock()
+	resolver := p.resolver
+	p.RUnlock()
+
+	if resolver != nil && record.Pod == nil {
+		record.Pod = resolver.PodByPUID(record.ContextID)
+	}
+
+	p.EventCollector.CollectFlowEvent(record)
+}
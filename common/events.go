@@ -49,6 +49,10 @@ type EventInfo struct {
 	// Tags represents the set of MetadataTags associated with this PUID.
 	Tags []string `json:"tags,omitempty"`
 
+	// Executable is the path of the executable that a Linux process is about
+	// to execute. The monitor only uses it to wait for the execution.
+	Executable string `json:"executable,omitempty"`
+
 	// The path for the Network Namespace.
 	NS string `json:"namespace,omitempty"`
 
@@ -30,6 +30,10 @@ type config struct {
 	service   packetprocessor.PacketProcessor
 	secret    secrets.Secrets
 
+	// collectorBuffer is the number of events buffered for the collector.
+	// The events are reported synchronously if it is 0.
+	collectorBuffer int
+
 	// Configurations for fine tuning internal components.
 	mode                   constants.ModeType
 	fq                     *fqconfig.FilterQueue
@@ -37,10 +41,36 @@ type config struct {
 	mutualAuth             bool
 	packetLogs             bool
 	validity               time.Duration
+	clockSkew              time.Duration
 	procMountPoint         string
 	externalIPcacheTimeout time.Duration
 	targetNetworks         []string
+	interfaces             []string
+	reportExcludedPorts    bool
+	encryptStats           bool
 	proxyPort              int
+	proxyPortWarning       int
+	connMark               uint32
+	enforcerSelectors      map[string]constants.ModeType
+	markResolver           MarkResolver
+	implicitAllowNetworks  []string
+	udpStateDir            string
+	udpHandshakeLimits     bool
+	udpHandshakeLimit      int
+	udpHandshakeLimitPerPU int
+	statsFlowHash          string
+	auditDir               string
+	reverseDNS             bool
+	reverseDNSPolicy       bool
+	protocolHelpers        []string
+	serverNameInspection   bool
+	udpHandshakeTimeout    *time.Duration
+	udpKeyRotationInterval time.Duration
+
+	// Enforcers and supervisors used instead of the ones created for the
+	// mode. They are only provided by tests.
+	enforcers   map[constants.ModeType]enforcer.Enforcer
+	supervisors map[constants.ModeType]supervisor.Supervisor
 }
 
 // Option is provided using functional arguments.
@@ -53,6 +83,16 @@ func OptionCollector(c collector.EventCollector) Option {
 	}
 }
 
+// OptionCollectorBuffer is an option to report the events to the collector
+// in the background with a buffer of the given number of events. The events
+// are dropped when the buffer is full, so that a slow collector does not
+// stall the enforcement. The events are reported synchronously by default.
+func OptionCollectorBuffer(size int) Option {
+	return func(cfg *config) {
+		cfg.collectorBuffer = size
+	}
+}
+
 // OptionDatapathService is an option to provide an external datapath service implementation.
 func OptionDatapathService(s packetprocessor.PacketProcessor) Option {
 	return func(cfg *config) {
@@ -81,6 +121,35 @@ func OptionEnforceFqConfig(f *fqconfig.FilterQueue) Option {
 	}
 }
 
+// OptionConnMark is an option to provide the conntrack mark of the flows
+// released to the kernel. It overrides the mark of the filter queue config.
+func OptionConnMark(mark uint32) Option {
+	return func(cfg *config) {
+		cfg.connMark = mark
+	}
+}
+
+// OptionMarkResolver is an option to resolve the marks that the processing
+// units don't own, such as the marks set by other classifiers, to processing
+// units. It only applies to the enforcers that run in the controller.
+func OptionMarkResolver(r MarkResolver) Option {
+	return func(cfg *config) {
+		cfg.markResolver = r
+	}
+}
+
+// OptionEnforcerSelector is an option to route the PUs with the given
+// enforcer selector in their runtime options to the enforcer of the mode.
+// It allows PUs of the same type to be served by different enforcers.
+func OptionEnforcerSelector(selector string, mode constants.ModeType) Option {
+	return func(cfg *config) {
+		if cfg.enforcerSelectors == nil {
+			cfg.enforcerSelectors = map[string]constants.ModeType{}
+		}
+		cfg.enforcerSelectors[selector] = mode
+	}
+}
+
 // OptionDisableMutualAuth is an option to disable MutualAuth (enabled by default)
 func OptionDisableMutualAuth() Option {
 	return func(cfg *config) {
@@ -95,6 +164,131 @@ func OptionTargetNetworks(n []string) Option {
 	}
 }
 
+// OptionImplicitAllowNetworks is an option to accept the traffic of the PUs
+// to and from the given networks when no ACL matches, such as the loopback
+// and link-local networks. Explicit ACLs take precedence. Only IPv4 networks
+// are supported, and no network is implicitly allowed by default.
+func OptionImplicitAllowNetworks(networks []string) Option {
+	return func(cfg *config) {
+		cfg.implicitAllowNetworks = networks
+	}
+}
+
+// OptionUDPStateDir is an option to persist the established UDP connections
+// of the enforcers in files of the directory, so that they survive a restart
+// of the enforcers. The connections are not persisted by default.
+func OptionUDPStateDir(dir string) Option {
+	return func(cfg *config) {
+		cfg.udpStateDir = dir
+	}
+}
+
+// OptionAuditDir is an option to append the records of the policy decisions
+// of the enforcers to files of the directory. The decisions are not audited
+// by default.
+func OptionAuditDir(dir string) Option {
+	return func(cfg *config) {
+		cfg.auditDir = dir
+	}
+}
+
+// OptionReverseDNS is an option to tag the accepted external flows of the
+// enforcers with the reverse DNS name of the address. The names are also
+// matched against the DNS rules if matchPolicy is set. The addresses are
+// not resolved by default.
+func OptionReverseDNS(matchPolicy bool) Option {
+	return func(cfg *config) {
+		cfg.reverseDNS = true
+		cfg.reverseDNSPolicy = matchPolicy
+	}
+}
+
+// OptionProtocolHelpers is an option to enable the protocol helpers with the
+// given names, such as ftp, in the enforcers. The related connections that
+// are announced on the control connections of the protocols are accepted. No
+// helper is enabled by default.
+func OptionProtocolHelpers(names []string) Option {
+	return func(cfg *config) {
+		cfg.protocolHelpers = names
+	}
+}
+
+// OptionServerNameInspection is an option to match the server name of the
+// ClientHello of the external TLS flows that no ACL accepts against the DNS
+// rules of the enforcers. The server names are not inspected by default.
+func OptionServerNameInspection() Option {
+	return func(cfg *config) {
+		cfg.serverNameInspection = true
+	}
+}
+
+// OptionUDPHandshakeTimeout is an option to set the time after which the UDP
+// handshakes of the enforcers that did not complete are reported and torn
+// down. A timeout of 0 disables it, and the defaults of the enforcers are
+// used if the option is not set.
+func OptionUDPHandshakeTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.udpHandshakeTimeout = &timeout
+	}
+}
+
+// OptionUDPKeyRotationInterval is an option to rotate the keys of the UDP
+// connections of the enforcers after the given interval. The key rotation
+// packets are not understood by the enforcers that do not support it, so the
+// keys are not rotated by default.
+func OptionUDPKeyRotationInterval(interval time.Duration) Option {
+	return func(cfg *config) {
+		cfg.udpKeyRotationInterval = interval
+	}
+}
+
+// OptionUDPHandshakeLimits is an option to set the maximum number of half
+// open UDP connections of the enforcers and of every PU. The connections
+// above the limits are dropped. A limit of 0 disables the check, and the
+// defaults of the enforcers are used if the option is not set.
+func OptionUDPHandshakeLimits(total, perPU int) Option {
+	return func(cfg *config) {
+		cfg.udpHandshakeLimits = true
+		cfg.udpHandshakeLimit = total
+		cfg.udpHandshakeLimitPerPU = perPU
+	}
+}
+
+// OptionEnforcedInterfaces is an option to enforce the policy only on the
+// given network interfaces of the host. The traffic of the other interfaces
+// bypasses the datapath. All the interfaces are enforced by default.
+func OptionEnforcedInterfaces(interfaces []string) Option {
+	return func(cfg *config) {
+		cfg.interfaces = interfaces
+	}
+}
+
+// OptionReportExcludedPorts is an option to report the flows to the excluded
+// ports as accepted. They are not reported by default.
+func OptionReportExcludedPorts() Option {
+	return func(cfg *config) {
+		cfg.reportExcludedPorts = true
+	}
+}
+
+// OptionEncryptStats is an option to encrypt the stats sent by the remote
+// enforcers with the secrets. They are sent in clear by default.
+func OptionEncryptStats() Option {
+	return func(cfg *config) {
+		cfg.encryptStats = true
+	}
+}
+
+// OptionStatsFlowHash is an option to aggregate the flows of the remote
+// enforcers with the built-in flow hash function of the given name, such as
+// collector.FlowHashCollapseSourcePort, before they are reported. The flows
+// are aggregated with collector.StatsFlowHash by default.
+func OptionStatsFlowHash(name string) Option {
+	return func(cfg *config) {
+		cfg.statsFlowHash = name
+	}
+}
+
 // OptionApplicationProxyPort is an option provide starting proxy port for application proxy
 func OptionApplicationProxyPort(proxyPort int) Option {
 	return func(cfg *config) {
@@ -102,6 +296,14 @@ func OptionApplicationProxyPort(proxyPort int) Option {
 	}
 }
 
+// OptionProxyPortWarningThreshold is an option to provide the number of free
+// proxy ports below which a warning is logged.
+func OptionProxyPortWarningThreshold(n int) Option {
+	return func(cfg *config) {
+		cfg.proxyPortWarning = n
+	}
+}
+
 // OptionProcMountPoint is an option to provide proc mount point.
 func OptionProcMountPoint(p string) Option {
 	return func(cfg *config) {
@@ -109,6 +311,14 @@ func OptionProcMountPoint(p string) Option {
 	}
 }
 
+// OptionClockSkewTolerance is an option to provide the tolerance applied to the
+// expiry and not before times of received tokens.
+func OptionClockSkewTolerance(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.clockSkew = d
+	}
+}
+
 // OptionPacketLogs is an option to enable packet level logging.
 func OptionPacketLogs() Option {
 	return func(cfg *config) {
@@ -116,6 +326,28 @@ func OptionPacketLogs() Option {
 	}
 }
 
+// optionEnforcer is an option to provide the enforcer of a mode. If any
+// enforcer is provided, no enforcers are created.
+func optionEnforcer(mode constants.ModeType, e enforcer.Enforcer) Option {
+	return func(cfg *config) {
+		if cfg.enforcers == nil {
+			cfg.enforcers = map[constants.ModeType]enforcer.Enforcer{}
+		}
+		cfg.enforcers[mode] = e
+	}
+}
+
+// optionSupervisor is an option to provide the supervisor of a mode. If any
+// supervisor is provided, no supervisors are created.
+func optionSupervisor(mode constants.ModeType, s supervisor.Supervisor) Option {
+	return func(cfg *config) {
+		if cfg.supervisors == nil {
+			cfg.supervisors = map[constants.ModeType]supervisor.Supervisor{}
+		}
+		cfg.supervisors[mode] = s
+	}
+}
+
 func (t *trireme) newEnforcers() error {
 	zap.L().Debug("LinuxProcessSupport", zap.Bool("Status", t.config.linuxProcess))
 	var err error
@@ -128,6 +360,7 @@ func (t *trireme) newEnforcers() error {
 			t.config.secret,
 			t.config.serverID,
 			t.config.validity,
+			t.config.clockSkew,
 			constants.LocalServer,
 			t.config.procMountPoint,
 			t.config.externalIPcacheTimeout,
@@ -149,12 +382,14 @@ func (t *trireme) newEnforcers() error {
 			t.config.secret,
 			t.config.serverID,
 			t.config.validity,
+			t.config.clockSkew,
 			t.rpchdl,
 			"enforce",
 			t.config.procMountPoint,
 			t.config.externalIPcacheTimeout,
 			t.config.packetLogs,
 			t.config.targetNetworks,
+			t.config.encryptStats,
 		)
 	}
 
@@ -168,6 +403,7 @@ func (t *trireme) newEnforcers() error {
 			t.config.secret,
 			t.config.serverID,
 			t.config.validity,
+			t.config.clockSkew,
 			constants.Sidecar,
 			t.config.procMountPoint,
 			t.config.externalIPcacheTimeout,
@@ -190,6 +426,7 @@ func (t *trireme) newSupervisors() error {
 			t.enforcers[constants.LocalServer],
 			constants.LocalServer,
 			t.config.targetNetworks,
+			t.config.interfaces,
 			t.config.service,
 		)
 		if err != nil {
@@ -203,6 +440,7 @@ func (t *trireme) newSupervisors() error {
 			t.config.collector,
 			t.enforcers[constants.RemoteContainer],
 			t.rpchdl,
+			t.config.targetNetworks,
 		)
 		if err != nil {
 			zap.L().Error("Unable to create proxy Supervisor:: Returned Error ", zap.Error(err))
@@ -217,6 +455,7 @@ func (t *trireme) newSupervisors() error {
 			t.enforcers[constants.Sidecar],
 			constants.Sidecar,
 			t.config.targetNetworks,
+			t.config.interfaces,
 			t.config.service,
 		)
 		if err != nil {
@@ -233,6 +472,10 @@ func newTrireme(c *config) TriremeController {
 
 	var err error
 
+	if c.connMark != 0 {
+		c.fq.ConnMark = c.connMark
+	}
+
 	t := &trireme{
 		config:               c,
 		port:                 allocator.New(c.proxyPort, 100),
@@ -243,16 +486,125 @@ func newTrireme(c *config) TriremeController {
 		locks:                sync.Map{},
 	}
 
-	zap.L().Debug("Creating Enforcers")
-	if err = t.newEnforcers(); err != nil {
-		zap.L().Error("Unable to create datapath enforcers", zap.Error(err))
-		return nil
+	if len(c.enforcers) > 0 {
+		for mode, e := range c.enforcers {
+			t.enforcers[mode] = e
+		}
+	} else {
+		zap.L().Debug("Creating Enforcers")
+		if err = t.newEnforcers(); err != nil {
+			zap.L().Error("Unable to create datapath enforcers", zap.Error(err))
+			return nil
+		}
+	}
+
+	if c.markResolver != nil {
+		for _, e := range t.enforcers {
+			if r, ok := e.(enforcer.MarkResolverSetter); ok {
+				r.SetMarkResolver(c.markResolver)
+			}
+		}
 	}
 
-	zap.L().Debug("Creating Supervisors")
-	if err = t.newSupervisors(); err != nil {
-		zap.L().Error("Unable to start datapath supervisor", zap.Error(err))
-		return nil
+	if len(c.implicitAllowNetworks) > 0 {
+		for _, e := range t.enforcers {
+			if i, ok := e.(enforcer.ImplicitAllowSetter); ok {
+				if err = i.SetImplicitAllowNetworks(c.implicitAllowNetworks); err != nil {
+					zap.L().Error("Unable to set the implicitly allowed networks", zap.Error(err))
+					return nil
+				}
+			}
+		}
+	}
+
+	if c.udpStateDir != "" {
+		for _, e := range t.enforcers {
+			if s, ok := e.(enforcer.UDPStateDirSetter); ok {
+				s.SetUDPStateDir(c.udpStateDir)
+			}
+		}
+	}
+
+	if c.auditDir != "" {
+		for _, e := range t.enforcers {
+			if s, ok := e.(enforcer.AuditDirSetter); ok {
+				s.SetAuditDir(c.auditDir)
+			}
+		}
+	}
+
+	if c.reverseDNS {
+		for _, e := range t.enforcers {
+			if r, ok := e.(enforcer.ReverseDNSEnricher); ok {
+				r.EnableReverseDNS(c.reverseDNSPolicy)
+			}
+		}
+	}
+
+	if len(c.protocolHelpers) > 0 {
+		for _, e := range t.enforcers {
+			if h, ok := e.(enforcer.ProtocolHelperEnabler); ok {
+				if err = h.EnableProtocolHelpers(c.protocolHelpers); err != nil {
+					zap.L().Error("Unable to enable the protocol helpers", zap.Error(err))
+					return nil
+				}
+			}
+		}
+	}
+
+	if c.serverNameInspection {
+		for _, e := range t.enforcers {
+			if i, ok := e.(enforcer.ServerNameInspector); ok {
+				i.EnableServerNameInspection()
+			}
+		}
+	}
+
+	if c.udpHandshakeLimits {
+		for _, e := range t.enforcers {
+			if l, ok := e.(enforcer.UDPHandshakeLimiter); ok {
+				l.SetUDPHandshakeLimits(c.udpHandshakeLimit, c.udpHandshakeLimitPerPU)
+			}
+		}
+	}
+
+	if c.statsFlowHash != "" {
+		for _, e := range t.enforcers {
+			if s, ok := e.(enforcer.StatsFlowHashSetter); ok {
+				if err = s.SetStatsFlowHash(c.statsFlowHash); err != nil {
+					zap.L().Error("Unable to set the stats flow hash", zap.Error(err))
+					return nil
+				}
+			}
+		}
+	}
+
+	if c.udpHandshakeTimeout != nil {
+		for _, e := range t.enforcers {
+			if s, ok := e.(enforcer.UDPHandshakeTimeoutSetter); ok {
+				s.SetUDPHandshakeTimeout(*c.udpHandshakeTimeout)
+			}
+		}
+	}
+
+	if c.udpKeyRotationInterval > 0 {
+		for _, e := range t.enforcers {
+			if s, ok := e.(enforcer.UDPKeyRotationSetter); ok {
+				s.SetUDPKeyRotationInterval(c.udpKeyRotationInterval)
+			}
+		}
+	}
+
+	if len(c.supervisors) > 0 {
+		for mode, s := range c.supervisors {
+			t.supervisors[mode] = s
+		}
+	} else {
+		zap.L().Debug("Creating Supervisors")
+		if err = t.newSupervisors(); err != nil {
+			zap.L().Error("Unable to start datapath supervisor", zap.Error(err))
+			return nil
+		}
 	}
 
 	if c.linuxProcess {
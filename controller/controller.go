@@ -1,8 +1,11 @@
 package controller
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -10,11 +13,11 @@ import (
 	"go.aporeto.io/trireme-lib/common"
 	"go.aporeto.io/trireme-lib/controller/constants"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer"
-	"go.aporeto.io/trireme-lib/controller/internal/enforcer/proxy"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
 	"go.aporeto.io/trireme-lib/controller/internal/supervisor"
 	"go.aporeto.io/trireme-lib/controller/pkg/fqconfig"
 	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
 	"go.aporeto.io/trireme-lib/policy"
 	"go.aporeto.io/trireme-lib/utils/allocator"
 
@@ -32,6 +35,17 @@ type trireme struct {
 	port                 allocator.Allocator
 	rpchdl               rpcwrapper.RPCClient
 	locks                sync.Map
+	// puModes holds the enforcer mode of every active PU.
+	puModes sync.Map
+	// applied holds the policy and the runtime last applied to every
+	// active PU.
+	applied sync.Map
+}
+
+// appliedPolicy is the policy and the runtime applied to a PU.
+type appliedPolicy struct {
+	policy  *policy.PUPolicy
+	runtime []byte
 }
 
 // New returns a trireme interface implementation based on configuration provided.
@@ -44,15 +58,21 @@ func New(serverID string, mode constants.ModeType, opts ...Option) TriremeContro
 		fq:                     fqconfig.NewFilterQueueWithDefaults(),
 		mutualAuth:             true,
 		validity:               time.Hour * 8760,
+		clockSkew:              tokens.DefaultClockSkew,
 		procMountPoint:         constants.DefaultProcMountPoint,
 		externalIPcacheTimeout: -1,
 		proxyPort:              5000,
+		proxyPortWarning:       10,
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
 
+	if c.collectorBuffer > 0 {
+		c.collector = collector.NewBufferedCollector(c.collector, c.collectorBuffer)
+	}
+
 	zap.L().Debug("Trireme configuration", zap.String("configuration", fmt.Sprintf("%+v", c)))
 
 	return newTrireme(c)
@@ -62,6 +82,10 @@ func New(serverID string, mode constants.ModeType, opts ...Option) TriremeContro
 // up if something went wrong. It will be up to the caller to decide what to do.
 func (t *trireme) Run(ctx context.Context) error {
 
+	if b, ok := t.config.collector.(*collector.BufferedCollector); ok {
+		go b.Run(ctx)
+	}
+
 	// Start all the supervisors.
 	for _, s := range t.supervisors {
 		if err := s.Run(ctx); err != nil {
@@ -77,6 +101,53 @@ func (t *trireme) Run(ctx context.Context) error {
 		}
 	}
 
+	// Persist the UDP connections of the enforcers that run in the
+	// controller. The remote enforcers persist their own.
+	if t.config.udpStateDir != "" {
+		for mode, e := range t.enforcers {
+			if p, ok := e.(enforcer.UDPConnectionPersister); ok {
+				path := enforcerFile(t.config.udpStateDir, mode, ".udp")
+				if err := p.PersistUDPConnections(ctx, path); err != nil {
+					zap.L().Warn("Unable to persist udp connections", zap.String("path", path), zap.Error(err))
+				}
+			}
+		}
+	}
+
+	// Audit the policy decisions of the enforcers that run in the
+	// controller. The remote enforcers audit their own.
+	if t.config.auditDir != "" {
+		for mode, e := range t.enforcers {
+			if a, ok := e.(enforcer.PolicyAuditor); ok {
+				path := enforcerFile(t.config.auditDir, mode, ".audit")
+				if err := a.AuditPolicyDecisions(ctx, path); err != nil {
+					zap.L().Warn("Unable to audit policy decisions", zap.String("path", path), zap.Error(err))
+				}
+			}
+		}
+	}
+
+	// Wait for all the enforcers to be ready to process packets.
+	for _, e := range t.enforcers {
+		select {
+		case <-e.Ready():
+		case <-ctx.Done():
+			return fmt.Errorf("enforcer not ready: %s", ctx.Err())
+		}
+	}
+
+	return nil
+}
+
+// Healthy returns an error if any of the enforcers is not ready or has degraded.
+func (t *trireme) Healthy() error {
+
+	for mode, e := range t.enforcers {
+		if err := e.Healthy(); err != nil {
+			return fmt.Errorf("enforcer %d is not healthy: %s", mode, err)
+		}
+	}
+
 	return nil
 }
 
@@ -119,6 +190,169 @@ func (t *trireme) UpdatePolicy(ctx context.Context, puID string, plc *policy.PUP
 	return t.doUpdatePolicy(puID, plc, runtime)
 }
 
+// PolicyVersion returns the version of the rules that are installed for the PU.
+// It returns an error if the PU is not supervised, including when its last
+// policy update failed.
+func (t *trireme) PolicyVersion(puID string) (int, error) {
+
+	if lock, ok := t.locks.Load(puID); ok {
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+	}
+
+	for _, s := range t.supervisors {
+		if version, err := s.PolicyVersion(puID); err == nil {
+			return version, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no policy installed for pu %s", puID)
+}
+
+// Validate checks that the policy can be enforced on a processing unit without
+// enforcing it. It returns the first problem that Enforce would hit.
+func (t *trireme) Validate(ctx context.Context, puID string, policy *policy.PUPolicy, runtime *policy.PURuntime) error {
+
+	if lock, ok := t.locks.Load(puID); ok {
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+	}
+
+	return t.doValidate(puID, policy, runtime)
+}
+
+// ExportPUState returns the policy applied to the PU and the state of the PU
+// in its enforcer as JSON. It returns an error if the PU is not supervised.
+func (t *trireme) ExportPUState(puID string) ([]byte, error) {
+
+	if lock, ok := t.locks.Load(puID); ok {
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+	}
+
+	a, ok := t.applied.Load(puID)
+	if !ok {
+		return nil, fmt.Errorf("no policy applied to pu %s", puID)
+	}
+
+	mode, ok := t.puModes.Load(puID)
+	if !ok {
+		return nil, fmt.Errorf("no enforcer for pu %s", puID)
+	}
+
+	exporter, ok := t.enforcers[mode.(constants.ModeType)].(enforcer.PUStateExporter)
+	if !ok {
+		return nil, fmt.Errorf("enforcer of pu %s cannot export its state", puID)
+	}
+
+	state, err := exporter.ExportPUState(puID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to export state of pu %s: %s", puID, err)
+	}
+
+	// The private key of the services is never exported.
+	plc := a.(*appliedPolicy).policy.ToPublicPolicy()
+	plc.ServicesPrivateKey = ""
+
+	return json.Marshal(&PUState{
+		ContextID: puID,
+		Policy:    plc,
+		Enforcer:  state,
+	})
+}
+
+// ExportConntrackUpdates returns the recent conntrack mark updates issued by
+// the enforcer of the PU as JSON. It returns an error if the PU is not
+// supervised.
+func (t *trireme) ExportConntrackUpdates(puID string) ([]byte, error) {
+
+	if lock, ok := t.locks.Load(puID); ok {
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+	}
+
+	mode, ok := t.puModes.Load(puID)
+	if !ok {
+		return nil, fmt.Errorf("no enforcer for pu %s", puID)
+	}
+
+	exporter, ok := t.enforcers[mode.(constants.ModeType)].(enforcer.ConntrackExporter)
+	if !ok {
+		return nil, fmt.Errorf("enforcer of pu %s cannot export its conntrack updates", puID)
+	}
+
+	updates, err := exporter.ExportConntrackUpdates(puID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to export conntrack updates of pu %s: %s", puID, err)
+	}
+
+	return updates, nil
+}
+
+// Drain stops the PU from accepting new connections and unenforces it after
+// the grace period, so that its established connections can finish. The
+// connections released to the kernel are not visible to the enforcers, so
+// the whole grace period is waited. If the context is cancelled first, the
+// PU is not unenforced but keeps rejecting new connections.
+func (t *trireme) Drain(ctx context.Context, puID string, gracePeriod time.Duration) error {
+
+	if err := t.drainPU(puID); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(gracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("drain of pu %s interrupted: %s", puID, ctx.Err())
+	case <-timer.C:
+	}
+
+	a, ok := t.applied.Load(puID)
+	if !ok {
+		// The PU was unenforced during the grace period.
+		return nil
+	}
+
+	applied := a.(*appliedPolicy)
+	runtime := policy.NewPURuntimeWithDefaults()
+	if err := json.Unmarshal(applied.runtime, runtime); err != nil {
+		return fmt.Errorf("invalid runtime of pu %s: %s", puID, err)
+	}
+
+	return t.UnEnforce(ctx, puID, applied.policy, runtime)
+}
+
+// drainPU stops the PU from accepting new connections in its enforcer.
+func (t *trireme) drainPU(puID string) error {
+
+	if lock, ok := t.locks.Load(puID); ok {
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+	}
+
+	if _, ok := t.applied.Load(puID); !ok {
+		return fmt.Errorf("no policy applied to pu %s", puID)
+	}
+
+	mode, ok := t.puModes.Load(puID)
+	if !ok {
+		return fmt.Errorf("no enforcer for pu %s", puID)
+	}
+
+	drainer, ok := t.enforcers[mode.(constants.ModeType)].(enforcer.PUDrainer)
+	if !ok {
+		return fmt.Errorf("enforcer of pu %s cannot drain it", puID)
+	}
+
+	if err := drainer.DrainPU(puID); err != nil {
+		return fmt.Errorf("unable to drain pu %s: %s", puID, err)
+	}
+
+	return nil
+}
+
 // UpdateSecrets updates the secrets of the controllers.
 func (t *trireme) UpdateSecrets(secrets secrets.Secrets) error {
 	for _, enforcer := range t.enforcers {
@@ -158,6 +392,64 @@ func (t *trireme) UpdateConfiguration(networks []string) error {
 	return nil
 }
 
+// UpdateExcludedPorts updates the ports whose flows bypass the enforcement.
+// The enforcers validate the ports before the supervisors install them.
+func (t *trireme) UpdateExcludedPorts(ports []string) error {
+
+	for _, e := range t.enforcers {
+		if err := e.SetExcludedPorts(ports, t.config.reportExcludedPorts); err != nil {
+			return fmt.Errorf("unable to update excluded ports in enforcer: %s", err)
+		}
+	}
+
+	for _, s := range t.supervisors {
+		if err := s.SetExcludedPorts(ports); err != nil {
+			return fmt.Errorf("unable to update excluded ports in supervisor: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// UpdateAddressSet sets the addresses of an address set referenced by the
+// ACLs of the PUs. The enforcers validate the addresses before the
+// supervisors install them.
+func (t *trireme) UpdateAddressSet(name string, addresses []string) error {
+
+	for _, e := range t.enforcers {
+		if err := e.UpdateAddressSet(name, addresses); err != nil {
+			return fmt.Errorf("unable to update address set in enforcer: %s", err)
+		}
+	}
+
+	for _, s := range t.supervisors {
+		if err := s.UpdateAddressSet(name, addresses); err != nil {
+			return fmt.Errorf("unable to update address set in supervisor: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// RemoveAddressSet removes the addresses of an address set. The ACLs that
+// reference it no longer match any address.
+func (t *trireme) RemoveAddressSet(name string) error {
+
+	for _, e := range t.enforcers {
+		if err := e.RemoveAddressSet(name); err != nil {
+			return fmt.Errorf("unable to remove address set in enforcer: %s", err)
+		}
+	}
+
+	for _, s := range t.supervisors {
+		if err := s.RemoveAddressSet(name); err != nil {
+			return fmt.Errorf("unable to remove address set in supervisor: %s", err)
+		}
+	}
+
+	return nil
+}
+
 // doHandleCreate is the detailed implementation of the create event.
 func (t *trireme) doHandleCreate(contextID string, policyInfo *policy.PUPolicy, runtimeInfo *policy.PURuntime) error {
 
@@ -165,6 +457,7 @@ func (t *trireme) doHandleCreate(contextID string, policyInfo *policy.PUPolicy,
 	newOptions := containerInfo.Runtime.Options()
 	newOptions.ProxyPort = t.port.Allocate()
 	containerInfo.Runtime.SetOptions(newOptions)
+	t.reportProxyPorts()
 
 	logEvent := &collector.ContainerRecord{
 		ContextID: contextID,
@@ -178,18 +471,25 @@ func (t *trireme) doHandleCreate(contextID string, policyInfo *policy.PUPolicy,
 	}()
 
 	addTransmitterLabel(contextID, containerInfo)
+	addExecutableTag(containerInfo)
 	if !mustEnforce(contextID, containerInfo) {
 		logEvent.Event = collector.ContainerIgnored
 		return nil
 	}
 
-	if err := t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Enforce(contextID, containerInfo); err != nil {
+	mode, err := t.enforcerMode(contextID, containerInfo.Runtime)
+	if err != nil {
+		logEvent.Event = collector.ContainerFailed
+		return err
+	}
+
+	if err := t.enforcers[mode].Enforce(contextID, containerInfo); err != nil {
 		logEvent.Event = collector.ContainerFailed
 		return fmt.Errorf("unable to setup enforcer: %s", err)
 	}
 
-	if err := t.supervisors[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Supervise(contextID, containerInfo); err != nil {
-		if werr := t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Unenforce(contextID); werr != nil {
+	if err := t.supervisors[mode].Supervise(contextID, containerInfo); err != nil {
+		if werr := t.enforcers[mode].Unenforce(contextID); werr != nil {
 			zap.L().Warn("Failed to clean up state after failures",
 				zap.String("contextID", contextID),
 				zap.Error(werr),
@@ -200,9 +500,115 @@ func (t *trireme) doHandleCreate(contextID string, policyInfo *policy.PUPolicy,
 		return fmt.Errorf("unable to setup supervisor: %s", err)
 	}
 
+	t.puModes.Store(contextID, mode)
+	t.storeApplied(contextID, containerInfo)
+
 	return nil
 }
 
+// doValidate is the dry run of doHandleCreate. The rules are built by the
+// supervisor of the PU, but nothing is enforced or supervised.
+func (t *trireme) doValidate(contextID string, policyInfo *policy.PUPolicy, runtimeInfo *policy.PURuntime) error {
+
+	if policyInfo == nil || runtimeInfo == nil {
+		return fmt.Errorf("invalid policy or runtime for pu %s", contextID)
+	}
+
+	// The transmitter label and the proxy port are set on copies of the
+	// policy and the runtime.
+	containerInfo := policy.PUInfoFromPolicyAndRuntime(contextID, policyInfo.Clone(), runtimeInfo.Clone())
+
+	// A proxy port is not allocated. The rules are built with the first port
+	// of the range instead.
+	if _, _, free := t.port.Stats(); free == 0 {
+		return fmt.Errorf("no proxy port available for pu %s", contextID)
+	}
+	newOptions := containerInfo.Runtime.Options()
+	newOptions.ProxyPort = strconv.Itoa(t.config.proxyPort)
+	containerInfo.Runtime.SetOptions(newOptions)
+
+	addTransmitterLabel(contextID, containerInfo)
+	addExecutableTag(containerInfo)
+	if !mustEnforce(contextID, containerInfo) {
+		return nil
+	}
+
+	mode, err := t.enforcerMode(contextID, containerInfo.Runtime)
+	if err != nil {
+		return err
+	}
+
+	s, ok := t.supervisors[mode]
+	if !ok {
+		return fmt.Errorf("no supervisor for pu %s", contextID)
+	}
+
+	if err := s.Validate(contextID, containerInfo); err != nil {
+		return fmt.Errorf("invalid rules for pu %s: %s", contextID, err)
+	}
+
+	return nil
+}
+
+// storeApplied records the policy and the runtime applied to the PU.
+func (t *trireme) storeApplied(contextID string, containerInfo *policy.PUInfo) {
+
+	runtime, err := json.Marshal(containerInfo.Runtime)
+	if err != nil {
+		t.applied.Delete(contextID)
+		return
+	}
+
+	t.applied.Store(contextID, &appliedPolicy{
+		policy:  containerInfo.Policy.Clone(),
+		runtime: runtime,
+	})
+}
+
+// isApplied returns true if the policy and the runtime are the ones last
+// applied to the PU.
+func (t *trireme) isApplied(contextID string, containerInfo *policy.PUInfo) bool {
+
+	a, ok := t.applied.Load(contextID)
+	if !ok {
+		return false
+	}
+	applied := a.(*appliedPolicy)
+
+	runtime, err := json.Marshal(containerInfo.Runtime)
+	if err != nil || !bytes.Equal(runtime, applied.runtime) {
+		return false
+	}
+
+	return applied.policy.Equal(containerInfo.Policy)
+}
+
+// enforcerMode returns the mode of the enforcer of the PU. The mode of an
+// active PU is the one it was created with. Otherwise it is selected by the
+// enforcer selector of the runtime options or by the PU type.
+func (t *trireme) enforcerMode(contextID string, runtime *policy.PURuntime) (constants.ModeType, error) {
+
+	if mode, ok := t.puModes.Load(contextID); ok {
+		return mode.(constants.ModeType), nil
+	}
+
+	selector := runtime.Options().EnforcerSelector
+	if selector == "" {
+		return t.puTypeToEnforcerType[runtime.PUType()], nil
+	}
+
+	mode, ok := t.config.enforcerSelectors[selector]
+	if !ok {
+		return mode, fmt.Errorf("unknown enforcer selector %s for pu %s", selector, contextID)
+	}
+
+	if _, ok := t.enforcers[mode]; !ok {
+		return mode, fmt.Errorf("no enforcer for selector %s of pu %s", selector, contextID)
+	}
+
+	return mode, nil
+}
+
 // doHandleDelete is the detailed implementation of the delete event.
 func (t *trireme) doHandleDelete(contextID string, policy *policy.PUPolicy, runtime *policy.PURuntime) error {
 
@@ -213,10 +619,18 @@ func (t *trireme) doHandleDelete(contextID string, policy *policy.PUPolicy, runt
 		Event:     collector.ContainerDelete,
 	})
 
-	errS := t.supervisors[t.puTypeToEnforcerType[runtime.PUType()]].Unsupervise(contextID)
-	errE := t.enforcers[t.puTypeToEnforcerType[runtime.PUType()]].Unenforce(contextID)
+	mode, err := t.enforcerMode(contextID, runtime)
+	if err != nil {
+		return err
+	}
+	t.puModes.Delete(contextID)
+	t.applied.Delete(contextID)
+
+	errS := t.supervisors[mode].Unsupervise(contextID)
+	errE := t.enforcers[mode].Unenforce(contextID)
 	if runtime.Options().ProxyPort != "" {
 		t.port.Release(runtime.Options().ProxyPort)
+		t.reportProxyPorts()
 	}
 
 	if errS != nil || errE != nil {
@@ -226,32 +640,68 @@ func (t *trireme) doHandleDelete(contextID string, policy *policy.PUPolicy, runt
 	return nil
 }
 
+// reportProxyPorts reports the usage of the proxy ports to the collector and
+// warns when the free ports drop below the configured threshold.
+func (t *trireme) reportProxyPorts() {
+
+	total, inUse, free := t.port.Stats()
+
+	t.config.collector.CollectAllocatorEvent(&collector.AllocatorRecord{
+		Name:  collector.ProxyPortAllocator,
+		Total: total,
+		InUse: inUse,
+		Free:  free,
+	})
+
+	if free < t.config.proxyPortWarning {
+		zap.L().Warn("Proxy ports are running low",
+			zap.Int("total", total),
+			zap.Int("inUse", inUse),
+			zap.Int("free", free),
+		)
+	}
+}
+
 // doUpdatePolicy is the detailed implementation of the update policy event.
 func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy, runtime *policy.PURuntime) error {
 
 	containerInfo := policy.PUInfoFromPolicyAndRuntime(contextID, newPolicy, runtime)
 
 	addTransmitterLabel(contextID, containerInfo)
+	addExecutableTag(containerInfo)
 
 	if !mustEnforce(contextID, containerInfo) {
 		return nil
 	}
 
-	if err := t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Enforce(contextID, containerInfo); err != nil {
+	// The enforcers are not updated again with the policy they have.
+	if t.isApplied(contextID, containerInfo) {
+		zap.L().Debug("Policy of the pu is unchanged", zap.String("contextID", contextID))
+		return nil
+	}
+	t.applied.Delete(contextID)
+
+	mode, err := t.enforcerMode(contextID, containerInfo.Runtime)
+	if err != nil {
+		return err
+	}
+
+	if err := t.enforcers[mode].Enforce(contextID, containerInfo); err != nil {
 		//We lost communication with the remote and killed it lets restart it here by feeding a create event in the request channel
 		zap.L().Warn("Re-initializing enforcers - connection lost", zap.Error(err))
 
-		isSidecar := t.puTypeToEnforcerType[containerInfo.Runtime.PUType()] == constants.Sidecar
+		isSidecar := mode == constants.Sidecar
 		if containerInfo.Runtime.PUType() == common.ContainerPU && !isSidecar {
 			//The unsupervise and unenforce functions just make changes to the proxy structures
-			//and do not depend on the remote instance running and can be called here
-			switch t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].(type) {
-			case *enforcerproxy.ProxyInfo:
-				if lerr := t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Unenforce(contextID); lerr != nil {
+			//and do not depend on the remote instance running and can be called here.
+			//Only remote enforcers are restarted. The PU keeps its mode.
+			switch mode {
+			case constants.RemoteContainer:
+				if lerr := t.enforcers[mode].Unenforce(contextID); lerr != nil {
 					return lerr
 				}
 
-				if lerr := t.supervisors[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Unsupervise(contextID); lerr != nil {
+				if lerr := t.supervisors[mode].Unsupervise(contextID); lerr != nil {
 					return lerr
 				}
 
@@ -267,8 +717,8 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy, r
 		return fmt.Errorf("enforcer failed to update policy for pu %s: %s", contextID, err)
 	}
 
-	if err := t.supervisors[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Supervise(contextID, containerInfo); err != nil {
-		if werr := t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Unenforce(contextID); werr != nil {
+	if err := t.supervisors[mode].Supervise(contextID, containerInfo); err != nil {
+		if werr := t.enforcers[mode].Unenforce(contextID); werr != nil {
 			zap.L().Warn("Failed to clean up after enforcerments failures",
 				zap.String("contextID", contextID),
 				zap.Error(werr),
@@ -284,5 +734,7 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy, r
 		Event:     collector.ContainerUpdate,
 	})
 
+	t.storeApplied(contextID, containerInfo)
+
 	return nil
 }
@@ -0,0 +1,622 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/mockenforcer"
+	"go.aporeto.io/trireme-lib/controller/internal/supervisor/mocksupervisor"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/allocator"
+)
+
+func newTestPolicy() *policy.PUPolicy {
+	return policy.NewPUPolicy("pu", policy.Police, nil, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+}
+
+func newUpdatedTestPolicy() *policy.PUPolicy {
+	rules := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "1"},
+		},
+	}
+	return policy.NewPUPolicy("pu", policy.Police, rules, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+}
+
+func TestControllerLifecycle(t *testing.T) {
+
+	Convey("Given a controller with a fake remote enforcer and supervisor", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		e := mockenforcer.NewMockEnforcer(ctrl)
+		s := mocksupervisor.NewMockSupervisor(ctrl)
+
+		c := New("serverID", constants.RemoteContainer,
+			optionEnforcer(constants.RemoteContainer, e),
+			optionSupervisor(constants.RemoteContainer, s),
+		)
+		So(c, ShouldNotBeNil)
+
+		runtime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+
+		Convey("When a pu is created, it should be enforced before it is supervised", func() {
+			gomock.InOrder(
+				e.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+				s.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+			)
+			So(c.Enforce(context.Background(), "pu", newTestPolicy(), runtime), ShouldBeNil)
+
+			Convey("When the policy is updated, it should be enforced before it is supervised", func() {
+				gomock.InOrder(
+					e.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+					s.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+				)
+				So(c.UpdatePolicy(context.Background(), "pu", newUpdatedTestPolicy(), runtime), ShouldBeNil)
+
+				Convey("When the same policy is updated again, the enforcers should not be updated", func() {
+					So(c.UpdatePolicy(context.Background(), "pu", newUpdatedTestPolicy(), runtime), ShouldBeNil)
+				})
+			})
+
+			Convey("When the policy is updated with the same policy, the enforcers should not be updated", func() {
+				So(c.UpdatePolicy(context.Background(), "pu", newTestPolicy(), runtime), ShouldBeNil)
+			})
+
+			Convey("When the observe action of a rule changes, the policy should be updated", func() {
+				gomock.InOrder(
+					e.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+					s.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+				)
+				So(c.UpdatePolicy(context.Background(), "pu", newUpdatedTestPolicy(), runtime), ShouldBeNil)
+
+				rules := newUpdatedTestPolicy().ApplicationACLs()
+				rules[0].Policy.ObserveAction = policy.ObserveContinue
+				plc := policy.NewPUPolicy("pu", policy.Police, rules, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+				gomock.InOrder(
+					e.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+					s.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+				)
+				So(c.UpdatePolicy(context.Background(), "pu", plc, runtime), ShouldBeNil)
+			})
+
+			Convey("When the runtime of the pu changes, the policy should be updated", func() {
+				gomock.InOrder(
+					e.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+					s.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+				)
+				updated := policy.NewPURuntime("", 0, "", nil, policy.ExtendedMap{policy.DefaultNamespace: "10.1.1.1"}, common.ContainerPU, nil)
+				So(c.UpdatePolicy(context.Background(), "pu", newTestPolicy(), updated), ShouldBeNil)
+			})
+
+			Convey("When the connection to the remote enforcer is lost, the pu should be created again", func() {
+				gomock.InOrder(
+					e.EXPECT().Enforce("pu", gomock.Any()).Return(errors.New("connection lost")),
+					e.EXPECT().Unenforce("pu").Return(nil),
+					s.EXPECT().Unsupervise("pu").Return(nil),
+					e.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+					s.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+				)
+				So(c.UpdatePolicy(context.Background(), "pu", newUpdatedTestPolicy(), runtime), ShouldBeNil)
+			})
+
+			Convey("When the pu is deleted, it should be unsupervised before it is unenforced", func() {
+				gomock.InOrder(
+					s.EXPECT().Unsupervise("pu").Return(nil),
+					e.EXPECT().Unenforce("pu").Return(nil),
+				)
+				So(c.UnEnforce(context.Background(), "pu", newTestPolicy(), runtime), ShouldBeNil)
+			})
+		})
+
+		Convey("When the supervisor fails on create, the pu should be unenforced", func() {
+			gomock.InOrder(
+				e.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+				s.EXPECT().Supervise("pu", gomock.Any()).Return(errors.New("failed")),
+				e.EXPECT().Unenforce("pu").Return(nil),
+			)
+			So(c.Enforce(context.Background(), "pu", newTestPolicy(), runtime), ShouldNotBeNil)
+		})
+
+		Convey("When the policy allows all traffic, the pu should not be enforced", func() {
+			plc := newTestPolicy()
+			plc.SetTriremeAction(policy.AllowAll)
+			So(c.Enforce(context.Background(), "pu", plc, runtime), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a controller with a fake local enforcer and supervisor", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		e := mockenforcer.NewMockEnforcer(ctrl)
+		s := mocksupervisor.NewMockSupervisor(ctrl)
+
+		c := New("serverID", constants.LocalServer,
+			OptionEnforceLinuxProcess(),
+			optionEnforcer(constants.LocalServer, e),
+			optionSupervisor(constants.LocalServer, s),
+		)
+		So(c, ShouldNotBeNil)
+
+		runtime := policy.NewPURuntime("", 0, "", nil, nil, common.LinuxProcessPU, nil)
+
+		gomock.InOrder(
+			e.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+			s.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+		)
+		So(c.Enforce(context.Background(), "pu", newTestPolicy(), runtime), ShouldBeNil)
+
+		Convey("When the enforcer fails on update, the pu should not be created again", func() {
+			e.EXPECT().Enforce("pu", gomock.Any()).Return(errors.New("failed"))
+			So(c.UpdatePolicy(context.Background(), "pu", newUpdatedTestPolicy(), runtime), ShouldNotBeNil)
+
+			Convey("When the same policy is updated again, it should be enforced again", func() {
+				gomock.InOrder(
+					e.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+					s.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+				)
+				So(c.UpdatePolicy(context.Background(), "pu", newUpdatedTestPolicy(), runtime), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestControllerEnforcerSelector(t *testing.T) {
+
+	Convey("Given a controller with a local and a remote enforcer", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		local := mockenforcer.NewMockEnforcer(ctrl)
+		localSupervisor := mocksupervisor.NewMockSupervisor(ctrl)
+		remote := mockenforcer.NewMockEnforcer(ctrl)
+		remoteSupervisor := mocksupervisor.NewMockSupervisor(ctrl)
+
+		c := New("serverID", constants.RemoteContainer,
+			OptionEnforceLinuxProcess(),
+			OptionEnforcerSelector("local", constants.LocalServer),
+			OptionEnforcerSelector("sidecar", constants.Sidecar),
+			optionEnforcer(constants.LocalServer, local),
+			optionSupervisor(constants.LocalServer, localSupervisor),
+			optionEnforcer(constants.RemoteContainer, remote),
+			optionSupervisor(constants.RemoteContainer, remoteSupervisor),
+		)
+		So(c, ShouldNotBeNil)
+
+		selected := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, &policy.OptionsType{EnforcerSelector: "local"})
+		unselected := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+
+		Convey("When a container pu selects the local enforcer, it should be created on the local enforcer", func() {
+			gomock.InOrder(
+				local.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+				localSupervisor.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+			)
+			So(c.Enforce(context.Background(), "pu", newTestPolicy(), selected), ShouldBeNil)
+
+			Convey("Then updates without the selector should use the same enforcer", func() {
+				gomock.InOrder(
+					local.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+					localSupervisor.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+				)
+				So(c.UpdatePolicy(context.Background(), "pu", newTestPolicy(), unselected), ShouldBeNil)
+			})
+
+			Convey("Then the pu should be deleted from the same enforcer", func() {
+				gomock.InOrder(
+					localSupervisor.EXPECT().Unsupervise("pu").Return(nil),
+					local.EXPECT().Unenforce("pu").Return(nil),
+				)
+				So(c.UnEnforce(context.Background(), "pu", newTestPolicy(), unselected), ShouldBeNil)
+
+				Convey("Then a new pu with the same id should use the enforcer of its type", func() {
+					gomock.InOrder(
+						remote.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+						remoteSupervisor.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+					)
+					So(c.Enforce(context.Background(), "pu", newTestPolicy(), unselected), ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("When a container pu has no selector, it should be created on the remote enforcer", func() {
+			gomock.InOrder(
+				remote.EXPECT().Enforce("pu", gomock.Any()).Return(nil),
+				remoteSupervisor.EXPECT().Supervise("pu", gomock.Any()).Return(nil),
+			)
+			So(c.Enforce(context.Background(), "pu", newTestPolicy(), unselected), ShouldBeNil)
+		})
+
+		Convey("When a pu has an unknown selector, it should not be created", func() {
+			runtime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, &policy.OptionsType{EnforcerSelector: "unknown"})
+			So(c.Enforce(context.Background(), "pu", newTestPolicy(), runtime), ShouldNotBeNil)
+		})
+
+		Convey("When a pu selects a mode without an enforcer, it should not be created", func() {
+			runtime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, &policy.OptionsType{EnforcerSelector: "sidecar"})
+			So(c.Enforce(context.Background(), "pu", newTestPolicy(), runtime), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestControllerValidate(t *testing.T) {
+
+	Convey("Given a controller with a fake enforcer and supervisor", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		e := mockenforcer.NewMockEnforcer(ctrl)
+		s := mocksupervisor.NewMockSupervisor(ctrl)
+
+		c := New("serverID", constants.RemoteContainer,
+			OptionEnforcerSelector("sidecar", constants.Sidecar),
+			optionEnforcer(constants.RemoteContainer, e),
+			optionSupervisor(constants.RemoteContainer, s),
+		)
+		So(c, ShouldNotBeNil)
+
+		runtime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+
+		Convey("When the policy is valid, the rules should be built without enforcing the pu", func() {
+			plc := newTestPolicy()
+			var validated *policy.PUInfo
+			s.EXPECT().Validate("pu", gomock.Any()).Do(func(contextID string, puInfo *policy.PUInfo) {
+				validated = puInfo
+			}).Return(nil)
+			So(c.Validate(context.Background(), "pu", plc, runtime), ShouldBeNil)
+
+			So(validated.Runtime.Options().ProxyPort, ShouldEqual, "5000")
+			label, ok := validated.Policy.Identity().Get(enforcerconstants.TransmitterLabel)
+			So(ok, ShouldBeTrue)
+			So(label, ShouldEqual, "pu")
+			_, ok = plc.Identity().Get(enforcerconstants.TransmitterLabel)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When the policy or the runtime is missing, I should get an error", func() {
+			So(c.Validate(context.Background(), "pu", nil, runtime), ShouldNotBeNil)
+			So(c.Validate(context.Background(), "pu", newTestPolicy(), nil), ShouldNotBeNil)
+		})
+
+		Convey("When no proxy port is available, I should get an error", func() {
+			c.(*trireme).port = allocator.New(5000, 0)
+			So(c.Validate(context.Background(), "pu", newTestPolicy(), runtime), ShouldNotBeNil)
+		})
+
+		Convey("When the pu selects an unknown enforcer, I should get an error", func() {
+			unknown := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, &policy.OptionsType{EnforcerSelector: "unknown"})
+			So(c.Validate(context.Background(), "pu", newTestPolicy(), unknown), ShouldNotBeNil)
+
+			sidecar := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, &policy.OptionsType{EnforcerSelector: "sidecar"})
+			So(c.Validate(context.Background(), "pu", newTestPolicy(), sidecar), ShouldNotBeNil)
+		})
+
+		Convey("When the rules can't be built, I should get an error", func() {
+			s.EXPECT().Validate("pu", gomock.Any()).Return(errors.New("invalid rule"))
+			So(c.Validate(context.Background(), "pu", newTestPolicy(), runtime), ShouldNotBeNil)
+		})
+
+		Convey("When the policy allows all traffic, the rules should not be built", func() {
+			plc := newTestPolicy()
+			plc.SetTriremeAction(policy.AllowAll)
+			So(c.Validate(context.Background(), "pu", plc, runtime), ShouldBeNil)
+		})
+	})
+}
+
+// exportingEnforcer is a fake enforcer that exports the state of the PU it
+// compiles from the last policy it enforced.
+type exportingEnforcer struct {
+	*mockenforcer.MockEnforcer
+	enforced *policy.PUInfo
+}
+
+func (e *exportingEnforcer) ExportPUState(contextID string) ([]byte, error) {
+
+	if e.enforced == nil {
+		return nil, errors.New("not enforced")
+	}
+
+	pu, err := pucontext.NewPU(contextID, e.enforced, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(pu.State())
+}
+
+func TestControllerExportPUState(t *testing.T) {
+
+	Convey("Given a controller with an enforcer that exports the state of its pus", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		e := &exportingEnforcer{MockEnforcer: mockenforcer.NewMockEnforcer(ctrl)}
+		s := mocksupervisor.NewMockSupervisor(ctrl)
+
+		c := New("serverID", constants.RemoteContainer,
+			optionEnforcer(constants.RemoteContainer, e),
+			optionSupervisor(constants.RemoteContainer, s),
+		)
+		So(c, ShouldNotBeNil)
+
+		runtime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+
+		Convey("When the pu is not enforced, I should get an error", func() {
+			_, err := c.ExportPUState("pu")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When a pu is enforced", func() {
+			selector := policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{Key: "app", Value: []string{"web"}, Operator: policy.Equal},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web"},
+				ID:     "selector",
+			}
+			plc := newUpdatedTestPolicy()
+			plc.AddTransmitterRules(selector)
+			plc.UpdateServiceCertificates("cert", "key")
+
+			e.MockEnforcer.EXPECT().Enforce("pu", gomock.Any()).Do(func(contextID string, puInfo *policy.PUInfo) {
+				e.enforced = puInfo
+			}).Return(nil)
+			s.EXPECT().Supervise("pu", gomock.Any()).Return(nil)
+			So(c.Enforce(context.Background(), "pu", plc, runtime), ShouldBeNil)
+
+			data, err := c.ExportPUState("pu")
+			So(err, ShouldBeNil)
+
+			state := &PUState{}
+			So(json.Unmarshal(data, state), ShouldBeNil)
+			enforced := &pucontext.State{}
+			So(json.Unmarshal(state.Enforcer, enforced), ShouldBeNil)
+
+			Convey("The export should contain the applied policy", func() {
+				So(state.ContextID, ShouldEqual, "pu")
+				So(len(state.Policy.ApplicationACLs), ShouldEqual, 1)
+				So(state.Policy.ApplicationACLs[0].Address, ShouldEqual, "10.0.0.0/8")
+				So(len(state.Policy.TransmitterRules), ShouldEqual, 1)
+				So(state.Policy.TransmitterRules[0].ID, ShouldEqual, "selector")
+			})
+
+			Convey("The export should contain the compiled rules and selectors of the enforcer", func() {
+				So(enforced.ContextID, ShouldEqual, "pu")
+				So(len(enforced.ApplicationACLs.Accept), ShouldEqual, 1)
+				So(enforced.ApplicationACLs.Accept[0].Address, ShouldEqual, "10.0.0.0/8")
+				So(enforced.ApplicationACLs.Accept[0].Port, ShouldEqual, "80")
+				So(enforced.ApplicationACLs.Accept[0].Policy.PolicyID, ShouldEqual, "1")
+				So(len(enforced.Transmitter.Accept), ShouldEqual, 1)
+				So(enforced.Transmitter.Accept[0].ID, ShouldEqual, "selector")
+				So(enforced.Transmitter.Accept[0].Policy.PolicyID, ShouldEqual, "web")
+			})
+
+			Convey("The private key of the services should not be exported", func() {
+				So(state.Policy.ServicesCertificate, ShouldEqual, "cert")
+				So(state.Policy.ServicesPrivateKey, ShouldBeEmpty)
+				So(plc.ToPublicPolicy().ServicesPrivateKey, ShouldEqual, "key")
+			})
+
+			Convey("When the pu is deleted, I should get an error", func() {
+				s.EXPECT().Unsupervise("pu").Return(nil)
+				e.MockEnforcer.EXPECT().Unenforce("pu").Return(nil)
+				So(c.UnEnforce(context.Background(), "pu", plc, runtime), ShouldBeNil)
+
+				_, err := c.ExportPUState("pu")
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+// conntrackEnforcer is a fake enforcer that exports a conntrack update.
+type conntrackEnforcer struct {
+	*mockenforcer.MockEnforcer
+}
+
+func (e *conntrackEnforcer) ExportConntrackUpdates(contextID string) ([]byte, error) {
+	return json.Marshal([]connection.ConntrackUpdate{{SourceIP: "10.1.1.1", SourcePort: 53}})
+}
+
+func TestControllerExportConntrackUpdates(t *testing.T) {
+
+	Convey("Given a controller with an enforcer that exports its conntrack updates", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		e := &conntrackEnforcer{MockEnforcer: mockenforcer.NewMockEnforcer(ctrl)}
+		s := mocksupervisor.NewMockSupervisor(ctrl)
+
+		c := New("serverID", constants.RemoteContainer,
+			optionEnforcer(constants.RemoteContainer, e),
+			optionSupervisor(constants.RemoteContainer, s),
+		)
+		So(c, ShouldNotBeNil)
+
+		Convey("When the pu is not enforced, I should get an error", func() {
+			_, err := c.ExportConntrackUpdates("pu")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When a pu is enforced, I should get the updates of its enforcer", func() {
+			e.MockEnforcer.EXPECT().Enforce("pu", gomock.Any()).Return(nil)
+			s.EXPECT().Supervise("pu", gomock.Any()).Return(nil)
+			runtime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+			So(c.Enforce(context.Background(), "pu", newTestPolicy(), runtime), ShouldBeNil)
+
+			data, err := c.ExportConntrackUpdates("pu")
+			So(err, ShouldBeNil)
+
+			updates := []connection.ConntrackUpdate{}
+			So(json.Unmarshal(data, &updates), ShouldBeNil)
+			So(len(updates), ShouldEqual, 1)
+			So(updates[0].SourceIP, ShouldEqual, "10.1.1.1")
+			So(updates[0].SourcePort, ShouldEqual, 53)
+		})
+	})
+}
+
+// drainingEnforcer is a fake enforcer that records the PUs it drains.
+type drainingEnforcer struct {
+	*mockenforcer.MockEnforcer
+	drained chan string
+}
+
+func (e *drainingEnforcer) DrainPU(contextID string) error {
+	e.drained <- contextID
+	return nil
+}
+
+func TestControllerDrain(t *testing.T) {
+
+	Convey("Given a controller with an enforcer that drains its pus", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		e := &drainingEnforcer{MockEnforcer: mockenforcer.NewMockEnforcer(ctrl), drained: make(chan string, 1)}
+		s := mocksupervisor.NewMockSupervisor(ctrl)
+
+		c := New("serverID", constants.RemoteContainer,
+			optionEnforcer(constants.RemoteContainer, e),
+			optionSupervisor(constants.RemoteContainer, s),
+		)
+		So(c, ShouldNotBeNil)
+
+		runtime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+		plc := newTestPolicy()
+
+		Convey("When the pu is not enforced, I should get an error", func() {
+			So(c.Drain(context.Background(), "pu", time.Millisecond), ShouldNotBeNil)
+			So(len(e.drained), ShouldEqual, 0)
+		})
+
+		Convey("When a pu with active flows is enforced", func() {
+			e.MockEnforcer.EXPECT().Enforce("pu", gomock.Any()).Return(nil)
+			s.EXPECT().Supervise("pu", gomock.Any()).Return(nil)
+			So(c.Enforce(context.Background(), "pu", plc, runtime), ShouldBeNil)
+
+			Convey("When I drain it, it should be unenforced only after the grace period", func() {
+				s.EXPECT().Unsupervise("pu").Return(nil)
+				e.MockEnforcer.EXPECT().Unenforce("pu").Return(nil)
+
+				start := time.Now()
+				done := make(chan error, 1)
+				go func() {
+					done <- c.Drain(context.Background(), "pu", 200*time.Millisecond)
+				}()
+
+				So(<-e.drained, ShouldEqual, "pu")
+				So(<-done, ShouldBeNil)
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 200*time.Millisecond)
+
+				So(c.Drain(context.Background(), "pu", time.Millisecond), ShouldNotBeNil)
+			})
+
+			Convey("When the drain is cancelled, the pu should not be unenforced", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() {
+					done <- c.Drain(ctx, "pu", time.Hour)
+				}()
+
+				So(<-e.drained, ShouldEqual, "pu")
+				cancel()
+				So(<-done, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the enforcer of the pu cannot drain it, I should get an error", func() {
+			m := mockenforcer.NewMockEnforcer(ctrl)
+			c := New("serverID", constants.RemoteContainer,
+				optionEnforcer(constants.RemoteContainer, m),
+				optionSupervisor(constants.RemoteContainer, s),
+			)
+			m.EXPECT().Enforce("pu", gomock.Any()).Return(nil)
+			s.EXPECT().Supervise("pu", gomock.Any()).Return(nil)
+			So(c.Enforce(context.Background(), "pu", plc, runtime), ShouldBeNil)
+
+			So(c.Drain(context.Background(), "pu", time.Millisecond), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestControllerExecutableTag(t *testing.T) {
+
+	Convey("Given a controller with a fake enforcer and supervisor", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		e := mockenforcer.NewMockEnforcer(ctrl)
+		s := mocksupervisor.NewMockSupervisor(ctrl)
+
+		c := New("serverID", constants.LocalServer,
+			OptionEnforceLinuxProcess(),
+			optionEnforcer(constants.LocalServer, e),
+			optionSupervisor(constants.LocalServer, s),
+		)
+		So(c, ShouldNotBeNil)
+
+		tags := policy.NewTagStore()
+		tags.AppendKeyValue(policy.ExecutableRuntimeTag, "/usr/bin/curl")
+		runtime := policy.NewPURuntime("", 0, "", tags, nil, common.LinuxProcessPU, nil)
+
+		var enforced *policy.PUInfo
+		enforce := func() {
+			e.EXPECT().Enforce("pu", gomock.Any()).Do(func(contextID string, puInfo *policy.PUInfo) {
+				enforced = puInfo
+			}).Return(nil)
+			s.EXPECT().Supervise("pu", gomock.Any()).Return(nil)
+		}
+
+		Convey("When a pu of an executable is created, the executable should be in its identity", func() {
+			enforce()
+			So(c.Enforce(context.Background(), "pu", newTestPolicy(), runtime), ShouldBeNil)
+
+			executable, ok := enforced.Policy.Identity().Get(policy.ExecutableTag)
+			So(ok, ShouldBeTrue)
+			So(executable, ShouldEqual, "/usr/bin/curl")
+
+			Convey("When the policy is updated, the executable should still be in its identity", func() {
+				enforce()
+				So(c.UpdatePolicy(context.Background(), "pu", newUpdatedTestPolicy(), runtime), ShouldBeNil)
+
+				executable, ok := enforced.Policy.Identity().Get(policy.ExecutableTag)
+				So(ok, ShouldBeTrue)
+				So(executable, ShouldEqual, "/usr/bin/curl")
+			})
+		})
+
+		Convey("When the policy sets the executable, it should be kept", func() {
+			plc := newTestPolicy()
+			plc.AddIdentityTag(policy.ExecutableTag, "/bin/true")
+
+			enforce()
+			So(c.Enforce(context.Background(), "pu", plc, runtime), ShouldBeNil)
+
+			So(enforced.Policy.Identity().GetSlice(), ShouldContain, policy.ExecutableTag+"=/bin/true")
+			So(enforced.Policy.Identity().GetSlice(), ShouldNotContain, policy.ExecutableTag+"=/usr/bin/curl")
+		})
+
+		Convey("When the pu has no executable, its identity should not have one", func() {
+			enforce()
+			So(c.Enforce(context.Background(), "pu", newTestPolicy(), policy.NewPURuntime("", 0, "", nil, nil, common.LinuxProcessPU, nil)), ShouldBeNil)
+
+			_, ok := enforced.Policy.Identity().Get(policy.ExecutableTag)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"os"
+	"path/filepath"
 
 	"go.aporeto.io/trireme-lib/controller/constants"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
@@ -63,13 +64,28 @@ func LaunchRemoteEnforcer(service packetprocessor.PacketProcessor) error {
 // CleanOldState ensures all state in trireme is cleaned up.
 func CleanOldState() {
 
-	ipt, _ := iptablesctrl.NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, nil)
+	ipt, _ := iptablesctrl.NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, nil, nil)
 
 	if err := ipt.CleanAllSynAckPacketCaptures(); err != nil {
 		zap.L().Fatal("Unable to clean all syn/ack captures", zap.Error(err))
 	}
 }
 
+// enforcerFile returns the path of the file with the given extension of the
+// enforcer of the mode in the directory.
+func enforcerFile(dir string, mode constants.ModeType, ext string) string {
+
+	name := "enforcer"
+	switch mode {
+	case constants.LocalServer:
+		name = "server"
+	case constants.Sidecar:
+		name = "sidecar"
+	}
+
+	return filepath.Join(dir, name+ext)
+}
+
 // addTransmitterLabel adds the enforcerconstants.TransmitterLabel as a fixed label in the policy.
 // The ManagementID part of the policy is used as the enforcerconstants.TransmitterLabel.
 // If the Policy didn't set the ManagementID, we use the Local contextID as the
@@ -83,6 +99,22 @@ func addTransmitterLabel(contextID string, containerInfo *policy.PUInfo) {
 	}
 }
 
+// addExecutableTag adds the executable of the PU found by the monitor to the
+// identity of the PU. An executable set by the policy is kept.
+func addExecutableTag(containerInfo *policy.PUInfo) {
+
+	executable, ok := containerInfo.Runtime.Tag(policy.ExecutableRuntimeTag)
+	if !ok || executable == "" {
+		return
+	}
+
+	if _, ok := containerInfo.Policy.Identity().Get(policy.ExecutableTag); ok {
+		return
+	}
+
+	containerInfo.Policy.AddIdentityTag(policy.ExecutableTag, executable)
+}
+
 // MustEnforce returns true if the Policy should go Through the Enforcer/internal/supervisor.
 // Return false if:
 //   - PU is in host namespace.
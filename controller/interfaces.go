@@ -2,6 +2,8 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
 	"go.aporeto.io/trireme-lib/policy"
@@ -30,4 +32,59 @@ type TriremeController interface {
 	// UpdateConfiguration updates the configuration of the controller. Only specific configuration
 	// parameters can be updated during run time.
 	UpdateConfiguration(networks []string) error
+
+	// UpdateExcludedPorts updates the ports whose flows bypass the enforcement. The ports
+	// are single ports or ranges such as 8000:8100.
+	UpdateExcludedPorts(ports []string) error
+
+	// UpdateAddressSet sets the addresses of an address set referenced by the ACLs. The
+	// addresses are IPv4 addresses or networks.
+	UpdateAddressSet(name string, addresses []string) error
+
+	// RemoveAddressSet removes the addresses of an address set referenced by the ACLs.
+	RemoveAddressSet(name string) error
+
+	// Healthy returns an error if any of the enforcers is not ready or has degraded.
+	Healthy() error
+
+	// PolicyVersion returns the version of the rules installed for a processing unit.
+	PolicyVersion(puID string) (int, error)
+
+	// Validate checks that the policy can be enforced on a processing unit without
+	// enforcing it, and returns the first problem.
+	Validate(ctx context.Context, puID string, policy *policy.PUPolicy, runtime *policy.PURuntime) error
+
+	// ExportPUState returns everything the controller and the enforcer of a
+	// processing unit know about it as the JSON of a PUState.
+	ExportPUState(puID string) ([]byte, error)
+
+	// ExportConntrackUpdates returns the recent conntrack mark updates issued
+	// by the enforcer of a processing unit as the JSON of a list of
+	// connection.ConntrackUpdate. The processing units that share an enforcer
+	// share their updates.
+	ExportConntrackUpdates(puID string) ([]byte, error)
+
+	// Drain stops a processing unit from accepting new connections and
+	// unenforces it once the grace period of its established connections
+	// is over, or returns an error if the context is cancelled first.
+	Drain(ctx context.Context, puID string, gracePeriod time.Duration) error
+}
+
+// PUState is the state of a processing unit, for debugging.
+type PUState struct {
+	ContextID string
+	// Policy is the policy last applied to the processing unit.
+	Policy *policy.PUPolicyPublic
+	// Enforcer is what the enforcer of the processing unit enforces: the
+	// compiled ACLs, the selectors of the policy and the rules learned from DNS.
+	Enforcer json.RawMessage
+}
+
+// MarkResolver maps the marks that other tools, such as external
+// classifiers, set on the packets to the processing units.
+type MarkResolver interface {
+
+	// ContextID returns the ID of the processing unit of the packets with
+	// the mark, or false if the mark is unknown.
+	ContextID(mark string) (string, bool)
 }
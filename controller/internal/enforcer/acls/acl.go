@@ -16,6 +16,7 @@ func newACL() *acl {
 	return &acl{
 		sortedPrefixLens: make([]int, 0),
 		prefixLenMap:     make(map[int]*prefixRules),
+		setRules:         make(map[string]portActionList),
 	}
 }
 
@@ -23,11 +24,19 @@ func newACL() *acl {
 type acl struct {
 	sortedPrefixLens []int
 	prefixLenMap     map[int]*prefixRules
+	// setRules are the rules that reference an address set, by set name.
+	// They are looked up after the rules of the networks, in the order of
+	// the names.
+	setRules   map[string]portActionList
+	sortedSets []string
+	// sets are the address sets of the cache of the acl, by name
+	sets map[string]*addressSet
 }
 
 func (a *acl) reverseSort() {
 
 	// Get reverse sorted prefix lengths for reject rules
+	a.sortedPrefixLens = a.sortedPrefixLens[:0]
 	for k := range a.prefixLenMap {
 		a.sortedPrefixLens = append(a.sortedPrefixLens, k)
 	}
@@ -36,42 +45,21 @@ func (a *acl) reverseSort() {
 
 func (a *acl) addRule(rule policy.IPRule) (err error) {
 
-	var subnet, mask uint32
-
-	if strings.ToLower(rule.Protocol) != "tcp" {
+	if strings.ToLower(rule.Protocol) != "tcp" && !strings.EqualFold(rule.Protocol, policy.AnyProtocol) {
 		return nil
 	}
 
-	parts := strings.Split(rule.Address, "/")
-
-	subnetSlice := net.ParseIP(parts[0])
-	if subnetSlice == nil {
-		return fmt.Errorf("invalid ip address: %s", parts[0])
+	if rule.AddressSet != "" {
+		return a.addSetRule(rule)
 	}
 
-	subnet = binary.BigEndian.Uint32(subnetSlice.To4())
-
-	maskValue := 0
-
-	switch len(parts) {
-	case 1:
-		mask = 0xFFFFFFFF
-		maskValue = 32
-
-	case 2:
-		maskValue, err = strconv.Atoi(parts[1])
-		if err != nil {
-			return fmt.Errorf("invalid address: %s", err)
-		}
+	subnet, maskValue, err := parseNetwork(rule.Address)
+	if err != nil {
+		return err
+	}
 
-		if mask > 32 {
-			return fmt.Errorf("invalid mask value: %d", mask)
-		}
-		mask = binary.BigEndian.Uint32(net.CIDRMask(maskValue, 32))
+	mask := binary.BigEndian.Uint32(net.CIDRMask(maskValue, 32))
 
-	default:
-		return fmt.Errorf("invalid address: %s", rule.Address)
-	}
 	plenRules, ok := a.prefixLenMap[maskValue]
 	if !ok {
 		plenRules = &prefixRules{
@@ -86,13 +74,68 @@ func (a *acl) addRule(rule policy.IPRule) (err error) {
 		return fmt.Errorf("unable to create port action: %s", err)
 	}
 
-	subnet = subnet & mask
-	plenRules.rules[subnet] = append(plenRules.rules[subnet], r)
+	actions := plenRules.rules[subnet]
+	actions.insert(r)
+	plenRules.rules[subnet] = actions
+	return nil
+}
+
+// addSetRule adds a rule that references an address set. The set does not
+// have to exist yet.
+func (a *acl) addSetRule(rule policy.IPRule) error {
+
+	r, err := newPortAction(rule)
+	if err != nil {
+		return fmt.Errorf("unable to create port action: %s", err)
+	}
+
+	actions, ok := a.setRules[rule.AddressSet]
+	if !ok {
+		a.sortedSets = append(a.sortedSets, rule.AddressSet)
+		sort.Strings(a.sortedSets)
+	}
+
+	actions.insert(r)
+	a.setRules[rule.AddressSet] = actions
 	return nil
 }
 
+// parseNetwork parses an IPv4 address or network and returns the network
+// and its prefix length. An address is a network of prefix length 32.
+func parseNetwork(address string) (uint32, int, error) {
+
+	parts := strings.Split(address, "/")
+
+	subnetSlice := net.ParseIP(parts[0])
+	if subnetSlice == nil || subnetSlice.To4() == nil {
+		return 0, 0, fmt.Errorf("invalid ip address: %s", parts[0])
+	}
+
+	subnet := binary.BigEndian.Uint32(subnetSlice.To4())
+
+	switch len(parts) {
+	case 1:
+		return subnet, 32, nil
+
+	case 2:
+		maskValue, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid address: %s", err)
+		}
+
+		if maskValue < 0 || maskValue > 32 {
+			return 0, 0, fmt.Errorf("invalid mask value: %d", maskValue)
+		}
+
+		return subnet & binary.BigEndian.Uint32(net.CIDRMask(maskValue, 32)), maskValue, nil
+
+	default:
+		return 0, 0, fmt.Errorf("invalid address: %s", address)
+	}
+}
+
 // getMatchingAction does lookup in acl in a common way for accept/reject rules.
-func (a *acl) getMatchingAction(ip []byte, port uint16, preReport *policy.FlowPolicy) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
+func (a *acl) getMatchingAction(ip []byte, port uint16, sourcePort uint16, preReport *policy.FlowPolicy) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
 
 	report = preReport
 
@@ -112,7 +155,21 @@ func (a *acl) getMatchingAction(ip []byte, port uint16, preReport *policy.FlowPo
 			continue
 		}
 
-		report, packet, err = actionList.lookup(port, report)
+		report, packet, err = actionList.lookup(port, sourcePort, report)
+		if err == nil {
+			return
+		}
+	}
+
+	for _, name := range a.sortedSets {
+
+		set, ok := a.sets[name]
+		if !ok || !set.contains(addr) {
+			continue
+		}
+
+		actionList := a.setRules[name]
+		report, packet, err = actionList.lookup(port, sourcePort, report)
 		if err == nil {
 			return
 		}
@@ -120,3 +177,45 @@ func (a *acl) getMatchingAction(ip []byte, port uint16, preReport *policy.FlowPo
 
 	return report, packet, errors.New("No match")
 }
+
+// dump returns the rules of the acl in lookup order: from the longest to the
+// shortest prefix, and then in the order of the ports. The rules of the
+// address sets follow in the order of the names of the sets.
+func (a *acl) dump() policy.IPRuleList {
+
+	rules := policy.IPRuleList{}
+
+	for _, plen := range a.sortedPrefixLens {
+
+		prefix, ok := a.prefixLenMap[plen]
+		if !ok {
+			continue
+		}
+
+		subnets := make([]uint32, 0, len(prefix.rules))
+		for subnet := range prefix.rules {
+			subnets = append(subnets, subnet)
+		}
+		sort.Slice(subnets, func(i, j int) bool { return subnets[i] < subnets[j] })
+
+		for _, subnet := range subnets {
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, subnet)
+			address := ip.String() + "/" + strconv.Itoa(plen)
+
+			for _, action := range prefix.rules[subnet] {
+				rules = append(rules, action.rule(address))
+			}
+		}
+	}
+
+	for _, name := range a.sortedSets {
+		for _, action := range a.setRules[name] {
+			rule := action.rule("")
+			rule.AddressSet = name
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
@@ -77,7 +77,7 @@ func TestLookup(t *testing.T) {
 		Convey("When I lookup for a matching address and a port range, I should get the right action", func() {
 			ip := net.ParseIP("172.17.0.1")
 			port := uint16(401)
-			r, p, err := a.getMatchingAction(ip.To4(), port, nil)
+			r, p, err := a.getMatchingAction(ip.To4(), port, 0, nil)
 			So(err, ShouldBeNil)
 			So(p.Action, ShouldEqual, policy.Accept)
 			So(p.PolicyID, ShouldEqual, "tcp172.17/16")
@@ -88,7 +88,7 @@ func TestLookup(t *testing.T) {
 		Convey("When I lookup for a matching address with less specific match and a port range, I should get the right action", func() {
 			ip := net.ParseIP("172.16.0.1")
 			port := uint16(401)
-			r, p, err := a.getMatchingAction(ip.To4(), port, nil)
+			r, p, err := a.getMatchingAction(ip.To4(), port, 0, nil)
 			So(err, ShouldBeNil)
 			So(p.Action, ShouldEqual, policy.Accept)
 			So(p.PolicyID, ShouldEqual, "tcp172/8")
@@ -99,7 +99,7 @@ func TestLookup(t *testing.T) {
 		Convey("When I lookup for a matching address exact port, I should get the right action", func() {
 			ip := net.ParseIP("192.168.100.1")
 			port := uint16(80)
-			r, p, err := a.getMatchingAction(ip.To4(), port, nil)
+			r, p, err := a.getMatchingAction(ip.To4(), port, 0, nil)
 			So(err, ShouldBeNil)
 			So(p.Action, ShouldEqual, policy.Accept)
 			So(p.PolicyID, ShouldEqual, "tcp192.168.100/24")
@@ -110,7 +110,7 @@ func TestLookup(t *testing.T) {
 		Convey("When I lookup for a non matching address . I should get reject", func() {
 			ip := net.ParseIP("192.168.200.1")
 			port := uint16(80)
-			r, p, err := a.getMatchingAction(ip.To4(), port, nil)
+			r, p, err := a.getMatchingAction(ip.To4(), port, 0, nil)
 			So(err, ShouldNotBeNil)
 			So(p, ShouldBeNil)
 			So(r, ShouldBeNil)
@@ -119,7 +119,7 @@ func TestLookup(t *testing.T) {
 		Convey("When I lookup for a matching address but failed port, I should get reject", func() {
 			ip := net.ParseIP("192.168.100.1")
 			port := uint16(600)
-			r, p, err := a.getMatchingAction(ip.To4(), port, nil)
+			r, p, err := a.getMatchingAction(ip.To4(), port, 0, nil)
 			So(err, ShouldNotBeNil)
 			So(p, ShouldBeNil)
 			So(r, ShouldBeNil)
@@ -128,7 +128,7 @@ func TestLookup(t *testing.T) {
 		Convey("When I lookup for a matching exact address exact port, I should get the right action", func() {
 			ip := net.ParseIP("10.1.1.1")
 			port := uint16(80)
-			r, p, err := a.getMatchingAction(ip.To4(), port, nil)
+			r, p, err := a.getMatchingAction(ip.To4(), port, 0, nil)
 			So(err, ShouldBeNil)
 			So(p.Action, ShouldEqual, policy.Accept)
 			So(p.PolicyID, ShouldEqual, "tcp10.1.1.1")
@@ -186,7 +186,7 @@ func TestObservedLookup(t *testing.T) {
 		Convey("When I lookup for a matching address and a port range, I should get the right action and observed action", func() {
 			ip := net.ParseIP("200.17.0.1")
 			port := uint16(401)
-			r, p, err := a.getMatchingAction(ip.To4(), port, nil)
+			r, p, err := a.getMatchingAction(ip.To4(), port, 0, nil)
 			So(err, ShouldBeNil)
 			So(p.Action, ShouldEqual, policy.Accept)
 			So(p.PolicyID, ShouldEqual, "tcp200/9")
@@ -197,7 +197,7 @@ func TestObservedLookup(t *testing.T) {
 		Convey("When I lookup for a matching address and a port range, I should get the observed action as applied", func() {
 			ip := net.ParseIP("200.18.0.1")
 			port := uint16(401)
-			r, p, err := a.getMatchingAction(ip.To4(), port, nil)
+			r, p, err := a.getMatchingAction(ip.To4(), port, 0, nil)
 			So(err, ShouldBeNil)
 			So(p.Action, ShouldEqual, policy.Accept)
 			So(p.PolicyID, ShouldEqual, "observed-applied-tcp200.18/17")
@@ -212,7 +212,7 @@ func TestObservedLookup(t *testing.T) {
 				Action:   policy.Reject,
 				PolicyID: "preReportedPolicyID",
 			}
-			r, p, err := a.getMatchingAction(ip.To4(), port, preReported)
+			r, p, err := a.getMatchingAction(ip.To4(), port, 0, preReported)
 			So(err, ShouldBeNil)
 			So(p.Action, ShouldEqual, policy.Accept)
 			So(p.PolicyID, ShouldEqual, "tcp200/9")
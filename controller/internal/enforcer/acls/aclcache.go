@@ -2,18 +2,45 @@ package acls
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 
 	"go.aporeto.io/trireme-lib/policy"
 )
 
 var catchAllPolicy = &policy.FlowPolicy{Action: policy.Reject, PolicyID: "default", ServiceID: "default"}
 
+// ErrNoMatch is returned by GetMatchingAction when no rule matches and the
+// default policy of the cache is returned.
+var ErrNoMatch = errors.New("no match")
+
 // ACLCache holds all the ACLS in an internal DB
 // map[prefixes][subnets] -> list of ports with their actions
+//
+// Rules can be added while lookups are running, for example when rules are
+// learned from DNS responses. To replace all the rules, build a new cache and
+// swap it instead, so that lookups never see a partial set of rules.
 type ACLCache struct {
+	sync.RWMutex
 	reject  *acl
 	accept  *acl
 	observe *acl
+	// implicit rules are applied when no explicit rule matches
+	implicit *acl
+	// defaultPolicy is applied when no rule matches
+	defaultPolicy *policy.FlowPolicy
+	// sets are the address sets that the rules can reference, by name
+	sets map[string]*addressSet
+}
+
+// ACLDump is the content of an ACL cache. The rules of every action are in
+// lookup order, and the actions are looked up in the order of the fields.
+type ACLDump struct {
+	Reject   policy.IPRuleList
+	Accept   policy.IPRuleList
+	Observe  policy.IPRuleList
+	Implicit policy.IPRuleList
+	Default  *policy.FlowPolicy
 }
 
 type prefixRules struct {
@@ -21,69 +48,214 @@ type prefixRules struct {
 	rules map[uint32]portActionList
 }
 
-// NewACLCache creates a new ACL cache
+// NewACLCache creates a new ACL cache that rejects traffic that doesn't
+// match any rule.
 func NewACLCache() *ACLCache {
-	return &ACLCache{
-		reject:  newACL(),
-		accept:  newACL(),
-		observe: newACL(),
+	return newACLCache(catchAllPolicy)
+}
+
+// NewACLCacheWithDefault creates a new ACL cache that applies the given
+// policy to traffic that doesn't match any rule.
+func NewACLCacheWithDefault(action policy.FlowPolicy) *ACLCache {
+	return newACLCache(&action)
+}
+
+// newACLCache creates a new ACL cache whose acls share its address sets.
+func newACLCache(defaultPolicy *policy.FlowPolicy) *ACLCache {
+
+	c := &ACLCache{
+		reject:        newACL(),
+		accept:        newACL(),
+		observe:       newACL(),
+		defaultPolicy: defaultPolicy,
+		sets:          map[string]*addressSet{},
 	}
+
+	c.reject.sets = c.sets
+	c.accept.sets = c.sets
+	c.observe.sets = c.sets
+
+	return c
 }
 
 // AddRule adds a single rule to the ACL Cache
 func (c *ACLCache) AddRule(rule policy.IPRule) (err error) {
 
-	if rule.Policy.ObserveAction.ObserveApply() {
-		return c.observe.addRule(rule)
+	c.Lock()
+	defer c.Unlock()
+
+	a, err := c.addRule(rule)
+	if err != nil {
+		return err
 	}
 
-	if rule.Policy.Action.Accepted() {
-		return c.accept.addRule(rule)
+	a.reverseSort()
+	return nil
+}
+
+// addRule adds a rule to the acl of its action and returns the acl, which
+// must be sorted before it is used.
+func (c *ACLCache) addRule(rule policy.IPRule) (*acl, error) {
+
+	a := c.reject
+	if rule.Policy.ObserveAction.ObserveApply() {
+		a = c.observe
+	} else if rule.Policy.Action.Accepted() {
+		a = c.accept
 	}
 
-	return c.reject.addRule(rule)
+	return a, a.addRule(rule)
 }
 
 // AddRuleList adds a list of rules to the cache
 func (c *ACLCache) AddRuleList(rules policy.IPRuleList) (err error) {
 
+	c.Lock()
+	defer c.Unlock()
+
+	// The acls are sorted once all the rules are added.
+	defer func() {
+		c.reject.reverseSort()
+		c.accept.reverseSort()
+		c.observe.reverseSort()
+	}()
+
 	for _, rule := range rules {
-		if err = c.AddRule(rule); err != nil {
+		if _, err = c.addRule(rule); err != nil {
 			return
 		}
 	}
 
-	c.reject.reverseSort()
-	c.accept.reverseSort()
-	c.observe.reverseSort()
 	return
 }
 
-// GetMatchingAction gets the matching action
-func (c *ACLCache) GetMatchingAction(ip []byte, port uint16) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
+// UpdateAddressSet replaces the addresses of a set, and creates the set if
+// it does not exist. The addresses are IPv4 addresses or networks. The rules
+// that reference the set apply to the new addresses once it returns.
+func (c *ACLCache) UpdateAddressSet(name string, addresses []string) error {
+
+	set, err := newAddressSet(addresses)
+	if err != nil {
+		return fmt.Errorf("invalid address set %s: %s", name, err)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.sets[name] = set
+	return nil
+}
+
+// AddToAddressSet adds addresses to a set, and creates the set if it does
+// not exist. The set is not changed if an address is invalid.
+func (c *ACLCache) AddToAddressSet(name string, addresses []string) error {
+
+	c.Lock()
+	defer c.Unlock()
+
+	set, ok := c.sets[name]
+	if !ok {
+		set, err := newAddressSet(addresses)
+		if err != nil {
+			return fmt.Errorf("invalid address set %s: %s", name, err)
+		}
+		c.sets[name] = set
+		return nil
+	}
+
+	if err := set.add(addresses); err != nil {
+		return fmt.Errorf("invalid address set %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// RemoveFromAddressSet removes addresses from a set. The addresses are
+// removed as they were added: removing an address does not split a network
+// of the set that contains it.
+func (c *ACLCache) RemoveFromAddressSet(name string, addresses []string) error {
+
+	c.Lock()
+	defer c.Unlock()
+
+	set, ok := c.sets[name]
+	if !ok {
+		return fmt.Errorf("unknown address set %s", name)
+	}
+
+	return set.remove(addresses)
+}
+
+// RemoveAddressSet removes a set. The rules that reference it no longer
+// match any address, until a set with the same name is added again.
+func (c *ACLCache) RemoveAddressSet(name string) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.sets, name)
+}
+
+// GetMatchingAction gets the matching action for the destination port and the
+// source port. Rules without a source port match any source port. If no rule
+// matches, the implicit rules are applied, and then the default policy of the
+// cache is returned with ErrNoMatch.
+func (c *ACLCache) GetMatchingAction(ip []byte, port uint16, sourcePort uint16) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
+
+	c.RLock()
+	defer c.RUnlock()
 
-	report, packet, err = c.reject.getMatchingAction(ip, port, report)
+	report, packet, err = c.reject.getMatchingAction(ip, port, sourcePort, report)
 	if err == nil {
 		return
 	}
 
-	report, packet, err = c.accept.getMatchingAction(ip, port, report)
+	report, packet, err = c.accept.getMatchingAction(ip, port, sourcePort, report)
 	if err == nil {
 		return
 	}
 
-	report, packet, err = c.observe.getMatchingAction(ip, port, report)
+	report, packet, err = c.observe.getMatchingAction(ip, port, sourcePort, report)
 	if err == nil {
 		return
 	}
 
+	if c.implicit != nil {
+		report, packet, err = c.implicit.getMatchingAction(ip, port, sourcePort, report)
+		if err == nil {
+			return
+		}
+	}
+
 	if report == nil {
-		report = catchAllPolicy
+		report = c.defaultPolicy
 	}
 
 	if packet == nil {
-		packet = catchAllPolicy
+		packet = c.defaultPolicy
+	}
+
+	return report, packet, ErrNoMatch
+}
+
+// Dump returns the rules of the cache, for example to export them for
+// debugging. The address of a rule is its network, and its protocol is
+// either tcp or any.
+func (c *ACLCache) Dump() *ACLDump {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	dump := &ACLDump{
+		Reject:  c.reject.dump(),
+		Accept:  c.accept.dump(),
+		Observe: c.observe.dump(),
+		Default: c.defaultPolicy,
+	}
+
+	if c.implicit != nil {
+		dump.Implicit = c.implicit.dump()
 	}
 
-	return report, packet, errors.New("no match")
+	return dump
 }
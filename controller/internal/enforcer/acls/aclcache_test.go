@@ -1,7 +1,10 @@
 package acls
 
 import (
+	"fmt"
 	"net"
+	"strconv"
+	"sync"
 	"testing"
 
 	"go.aporeto.io/trireme-lib/policy"
@@ -16,8 +19,8 @@ func TestEmptyACLCacheLookup(t *testing.T) {
 		Convey("When I lookup for a matching address but failed port, I should get reject", func() {
 			ip := net.ParseIP("192.168.100.1")
 			port := uint16(600)
-			a, p, err := c.GetMatchingAction(ip.To4(), port)
-			So(err, ShouldNotBeNil)
+			a, p, err := c.GetMatchingAction(ip.To4(), port, 0)
+			So(err, ShouldEqual, ErrNoMatch)
 			So(a.Action, ShouldEqual, policy.Reject)
 			So(a.PolicyID, ShouldEqual, "default")
 			So(p.Action, ShouldEqual, policy.Reject)
@@ -26,6 +29,54 @@ func TestEmptyACLCacheLookup(t *testing.T) {
 	})
 }
 
+func TestDefaultActionCacheLookup(t *testing.T) {
+
+	rules := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "172.0.0.0/8",
+			Port:     "1",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Reject,
+				PolicyID: "tcp172/8"},
+		},
+	}
+
+	Convey("Given an ACL Cache with an accept default action", t, func() {
+		c := NewACLCacheWithDefault(policy.FlowPolicy{Action: policy.Accept, PolicyID: "permissive", ServiceID: "default"})
+		So(c.AddRuleList(rules), ShouldBeNil)
+
+		Convey("When I lookup an address that matches no rule, I should get the default action", func() {
+			a, p, err := c.GetMatchingAction(net.ParseIP("192.168.100.1").To4(), 1, 0)
+			So(err, ShouldEqual, ErrNoMatch)
+			So(a.Action, ShouldEqual, policy.Accept)
+			So(a.PolicyID, ShouldEqual, "permissive")
+			So(p.Action, ShouldEqual, policy.Accept)
+			So(p.PolicyID, ShouldEqual, "permissive")
+		})
+
+		Convey("When I lookup an address that matches a rule, I should get the rule action", func() {
+			a, p, err := c.GetMatchingAction(net.ParseIP("172.1.1.1").To4(), 1, 0)
+			So(err, ShouldBeNil)
+			So(a.Action, ShouldEqual, policy.Reject)
+			So(p.PolicyID, ShouldEqual, "tcp172/8")
+		})
+	})
+
+	Convey("Given an ACL Cache with the reject default action", t, func() {
+		c := NewACLCache()
+		So(c.AddRuleList(rules), ShouldBeNil)
+
+		Convey("When I lookup an address that matches no rule, I should get reject", func() {
+			a, p, err := c.GetMatchingAction(net.ParseIP("192.168.100.1").To4(), 1, 0)
+			So(err, ShouldEqual, ErrNoMatch)
+			So(a.Action, ShouldEqual, policy.Reject)
+			So(p.Action, ShouldEqual, policy.Reject)
+			So(p.PolicyID, ShouldEqual, "default")
+		})
+	})
+}
+
 func TestRejectPrioritizedOverAcceptCacheLookup(t *testing.T) {
 
 	rules = policy.IPRuleList{
@@ -56,7 +107,7 @@ func TestRejectPrioritizedOverAcceptCacheLookup(t *testing.T) {
 		Convey("When I lookup for a matching address to both accept and reject rule, I should get reject", func() {
 			ip := net.ParseIP("172.1.1.1")
 			port := uint16(1)
-			a, p, err := c.GetMatchingAction(ip.To4(), port)
+			a, p, err := c.GetMatchingAction(ip.To4(), port, 0)
 			So(err, ShouldBeNil)
 			So(a.Action, ShouldEqual, policy.Reject)
 			So(a.PolicyID, ShouldEqual, "catchAllDrop")
@@ -89,7 +140,7 @@ func TestEmptyACLWithObserveContinueCacheLookup(t *testing.T) {
 		Convey("When I lookup for a matching address, I should get accept", func() {
 			ip := net.ParseIP("192.168.100.1")
 			port := uint16(1)
-			a, p, err := c.GetMatchingAction(ip.To4(), port)
+			a, p, err := c.GetMatchingAction(ip.To4(), port, 0)
 			So(err, ShouldNotBeNil)
 			So(a.Action, ShouldEqual, policy.Accept)
 			So(a.PolicyID, ShouldEqual, "ObserveAcceptContinue")
@@ -122,7 +173,7 @@ func TestEmptyACLWithObserveApplyCacheLookup(t *testing.T) {
 		Convey("When I lookup for a matching address, I should get accept", func() {
 			ip := net.ParseIP("192.168.100.1")
 			port := uint16(1)
-			a, p, err := c.GetMatchingAction(ip.To4(), port)
+			a, p, err := c.GetMatchingAction(ip.To4(), port, 0)
 			So(err, ShouldBeNil)
 			So(a.Action, ShouldEqual, policy.Accept)
 			So(a.PolicyID, ShouldEqual, "observeAcceptApply")
@@ -181,7 +232,7 @@ func TestObserveContinueApplyCacheLookup(t *testing.T) {
 		Convey("When I lookup for a matching address to /16, I should get report reject and packet accept and ignore observe-apply rule", func() {
 			ip := net.ParseIP("172.1.1.1")
 			port := uint16(1)
-			a, p, err := c.GetMatchingAction(ip.To4(), port)
+			a, p, err := c.GetMatchingAction(ip.To4(), port, 0)
 			So(err, ShouldBeNil)
 			So(a.Action, ShouldEqual, policy.Reject)
 			So(a.PolicyID, ShouldEqual, "observeRejectContinue-172.1/16")
@@ -192,7 +243,7 @@ func TestObserveContinueApplyCacheLookup(t *testing.T) {
 		Convey("When I lookup for a matching address to /8, I should get report reject and packet accept and ignore observe-apply rule", func() {
 			ip := net.ParseIP("172.2.1.1")
 			port := uint16(1)
-			a, p, err := c.GetMatchingAction(ip.To4(), port)
+			a, p, err := c.GetMatchingAction(ip.To4(), port, 0)
 			So(err, ShouldBeNil)
 			So(a.Action, ShouldEqual, policy.Reject)
 			So(a.PolicyID, ShouldEqual, "observeRejectContinue")
@@ -201,3 +252,396 @@ func TestObserveContinueApplyCacheLookup(t *testing.T) {
 		})
 	})
 }
+
+func TestAnyProtocolCacheLookup(t *testing.T) {
+
+	rules = policy.IPRuleList{
+		policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Protocol: policy.AnyProtocol,
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "any10/8"},
+		},
+		policy.IPRule{
+			Address:  "10.1.1.0/24",
+			Port:     "443",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Reject,
+				PolicyID: "tcp10.1.1/24"},
+		},
+		policy.IPRule{
+			Address:  "10.2.0.0/16",
+			Protocol: policy.AnyProtocol,
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "any10.2/16"},
+		},
+		policy.IPRule{
+			Address:  "10.2.0.0/16",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "tcp10.2/16"},
+		},
+	}
+
+	Convey("Given an ACL Cache with a wildcard accept rule and a more specific reject rule", t, func() {
+		c := NewACLCache()
+		So(c, ShouldNotBeNil)
+		err := c.AddRuleList(rules)
+		So(err, ShouldBeNil)
+
+		Convey("When I lookup for any port in the wildcard subnet, I should get accept", func() {
+			ip := net.ParseIP("10.1.1.1")
+			port := uint16(8080)
+			a, p, err := c.GetMatchingAction(ip.To4(), port, 0)
+			So(err, ShouldBeNil)
+			So(a.Action, ShouldEqual, policy.Accept)
+			So(a.PolicyID, ShouldEqual, "any10/8")
+			So(p.Action, ShouldEqual, policy.Accept)
+			So(p.PolicyID, ShouldEqual, "any10/8")
+		})
+
+		Convey("When I lookup for the rejected port, I should get reject", func() {
+			ip := net.ParseIP("10.1.1.1")
+			port := uint16(443)
+			a, p, err := c.GetMatchingAction(ip.To4(), port, 0)
+			So(err, ShouldBeNil)
+			So(a.Action, ShouldEqual, policy.Reject)
+			So(a.PolicyID, ShouldEqual, "tcp10.1.1/24")
+			So(p.Action, ShouldEqual, policy.Reject)
+			So(p.PolicyID, ShouldEqual, "tcp10.1.1/24")
+		})
+
+		Convey("When I lookup for a port with a specific rule on the same prefix, I should get the specific rule", func() {
+			ip := net.ParseIP("10.2.1.1")
+			port := uint16(80)
+			a, p, err := c.GetMatchingAction(ip.To4(), port, 0)
+			So(err, ShouldBeNil)
+			So(a.PolicyID, ShouldEqual, "tcp10.2/16")
+			So(p.PolicyID, ShouldEqual, "tcp10.2/16")
+		})
+
+		Convey("When I lookup for an address outside the wildcard subnet, I should get the catch all reject", func() {
+			ip := net.ParseIP("11.1.1.1")
+			port := uint16(8080)
+			_, p, err := c.GetMatchingAction(ip.To4(), port, 0)
+			So(err, ShouldNotBeNil)
+			So(p.Action, ShouldEqual, policy.Reject)
+			So(p.PolicyID, ShouldEqual, "default")
+		})
+	})
+}
+
+func TestSourcePortCacheLookup(t *testing.T) {
+
+	rules := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "anySource"},
+		},
+		policy.IPRule{
+			Address:    "10.0.0.0/8",
+			Port:       "80",
+			Protocol:   "tcp",
+			SourcePort: "1:1023",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "privilegedSource"},
+		},
+		policy.IPRule{
+			Address:    "20.0.0.0/8",
+			Port:       "443",
+			Protocol:   "tcp",
+			SourcePort: "5000",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Reject,
+				PolicyID: "rejectSource"},
+		},
+		policy.IPRule{
+			Address:  "20.0.0.0/8",
+			Port:     "443",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "acceptAny"},
+		},
+	}
+
+	Convey("Given an ACL Cache with rules that differ only in source port", t, func() {
+		c := NewACLCache()
+		So(c.AddRuleList(rules), ShouldBeNil)
+
+		Convey("When I lookup from a source port in the range, I should get the source port rule", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 80, 1000)
+			So(err, ShouldBeNil)
+			So(p.PolicyID, ShouldEqual, "privilegedSource")
+		})
+
+		Convey("When I lookup from a source port outside the range, I should get the rule without source port", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 80, 40000)
+			So(err, ShouldBeNil)
+			So(p.PolicyID, ShouldEqual, "anySource")
+		})
+
+		Convey("When I lookup from the rejected source port, I should get reject", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("20.1.1.1").To4(), 443, 5000)
+			So(err, ShouldBeNil)
+			So(p.Action, ShouldEqual, policy.Reject)
+			So(p.PolicyID, ShouldEqual, "rejectSource")
+		})
+
+		Convey("When I lookup from another source port, I should get accept", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("20.1.1.1").To4(), 443, 5001)
+			So(err, ShouldBeNil)
+			So(p.Action, ShouldEqual, policy.Accept)
+			So(p.PolicyID, ShouldEqual, "acceptAny")
+		})
+
+		Convey("When I lookup with an unknown source port, I should only get the rules without source port", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("20.1.1.1").To4(), 443, 0)
+			So(err, ShouldBeNil)
+			So(p.PolicyID, ShouldEqual, "acceptAny")
+
+			c := NewACLCache()
+			So(c.AddRule(policy.IPRule{
+				Address:    "10.0.0.0/8",
+				Port:       "80",
+				Protocol:   "tcp",
+				SourcePort: "0:1023",
+				Policy:     &policy.FlowPolicy{Action: policy.Reject, PolicyID: "fromZero"},
+			}), ShouldBeNil)
+			_, _, err = c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 80, 0)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a rule with an invalid source port", t, func() {
+		c := NewACLCache()
+		err := c.AddRuleList(policy.IPRuleList{
+			policy.IPRule{
+				Address:    "10.0.0.0/8",
+				Port:       "80",
+				Protocol:   "tcp",
+				SourcePort: "1023:1",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "invalid"},
+			},
+		})
+
+		Convey("I should get an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// benchmarkRules returns n rules with several prefix lengths and actions.
+func benchmarkRules(n int) policy.IPRuleList {
+
+	rules := make(policy.IPRuleList, 0, n)
+	for i := 0; i < n; i++ {
+		action := policy.Accept
+		if i%3 == 0 {
+			action = policy.Reject
+		}
+		rules = append(rules, policy.IPRule{
+			Address:  fmt.Sprintf("10.%d.%d.0/%d", (i/256)%256, i%256, 24+i%9),
+			Port:     strconv.Itoa(1 + i%1000),
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   action,
+				PolicyID: strconv.Itoa(i)},
+		})
+	}
+
+	return rules
+}
+
+func TestAddRuleAndAddRuleListCacheLookup(t *testing.T) {
+
+	Convey("Given the same rules added one by one and as a list", t, func() {
+		rules := benchmarkRules(2000)
+
+		single := NewACLCache()
+		for _, rule := range rules {
+			So(single.AddRule(rule), ShouldBeNil)
+		}
+
+		list := NewACLCache()
+		So(list.AddRuleList(rules), ShouldBeNil)
+
+		Convey("The prefix lengths should be sorted once", func() {
+			So(list.accept.sortedPrefixLens, ShouldResemble, single.accept.sortedPrefixLens)
+			So(len(list.accept.sortedPrefixLens), ShouldEqual, 6)
+
+			So(list.AddRuleList(rules[:10]), ShouldBeNil)
+			So(len(list.accept.sortedPrefixLens), ShouldEqual, 6)
+		})
+
+		Convey("The lookups should return the same results", func() {
+			for i := 0; i < 3000; i++ {
+				ip := net.IPv4(10, byte((i/256)%256), byte(i%256), byte(i%7)).To4()
+				port := uint16(1 + i%1000)
+
+				sr, sp, serr := single.GetMatchingAction(ip, port, 0)
+				lr, lp, lerr := list.GetMatchingAction(ip, port, 0)
+				So(lerr, ShouldEqual, serr)
+				So(lr, ShouldEqual, sr)
+				So(lp, ShouldEqual, sp)
+			}
+		})
+	})
+}
+
+func BenchmarkAddRule(b *testing.B) {
+
+	rules := benchmarkRules(10000)
+
+	for i := 0; i < b.N; i++ {
+		c := NewACLCache()
+		for _, rule := range rules {
+			c.AddRule(rule) // nolint errcheck
+		}
+	}
+}
+
+func BenchmarkAddRuleList(b *testing.B) {
+
+	rules := benchmarkRules(10000)
+
+	for i := 0; i < b.N; i++ {
+		c := NewACLCache()
+		c.AddRuleList(rules) // nolint errcheck
+	}
+}
+
+func TestConcurrentLookupsAndUpdates(t *testing.T) {
+
+	Convey("Given an ACL cache that is updated while it is looked up", t, func() {
+		rules := benchmarkRules(1000)
+		c := NewACLCache()
+		So(c.SetImplicitAllowNetworks([]string{"127.0.0.0/8", "169.254.0.0/16"}), ShouldBeNil)
+
+		var wg sync.WaitGroup
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			for _, rule := range rules[:500] {
+				c.AddRule(rule) // nolint errcheck
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for i := 500; i < len(rules); i += 50 {
+				c.AddRuleList(rules[i : i+50]) // nolint errcheck
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				ip := net.IPv4(10, byte((i/256)%256), byte(i%256), 1).To4()
+				c.GetMatchingAction(ip, uint16(1+i%1000), 0) // nolint errcheck
+			}
+		}()
+
+		wg.Wait()
+
+		Convey("All the rules should be visible once the updates are done", func() {
+			expected := NewACLCache()
+			So(expected.AddRuleList(rules), ShouldBeNil)
+
+			for i := 0; i < 1000; i++ {
+				ip := net.IPv4(10, byte((i/256)%256), byte(i%256), 0).To4()
+				port := uint16(1 + i%1000)
+
+				er, ep, eerr := expected.GetMatchingAction(ip, port, 0)
+				r, p, err := c.GetMatchingAction(ip, port, 0)
+				So(err, ShouldEqual, eerr)
+				So(r, ShouldEqual, er)
+				So(p, ShouldEqual, ep)
+			}
+		})
+	})
+}
+
+func TestDumpACLCache(t *testing.T) {
+
+	Convey("Given an ACL Cache with rules of every action", t, func() {
+		c := NewACLCache()
+		So(c.SetImplicitAllowNetworks([]string{"127.0.0.0/8", "169.254.0.0/16"}), ShouldBeNil)
+		So(c.AddRuleList(policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.1.1.1/8",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "wide"},
+			},
+			policy.IPRule{
+				Address:    "10.1.0.0/16",
+				Port:       "443:445",
+				Protocol:   "tcp",
+				SourcePort: "1:1023",
+				Policy:     &policy.FlowPolicy{Action: policy.Accept, PolicyID: "narrow"},
+			},
+			policy.IPRule{
+				Address:  "20.0.0.1",
+				Protocol: policy.AnyProtocol,
+				Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"},
+			},
+			policy.IPRule{
+				Address:  "30.0.0.0/8",
+				Port:     "53",
+				Protocol: "udp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "udp"},
+			},
+			policy.IPRule{
+				Address:  "40.0.0.0/8",
+				Port:     "22",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, ObserveAction: policy.ObserveApply, PolicyID: "observe"},
+			},
+		}), ShouldBeNil)
+
+		Convey("When I dump it, I should get its rules in lookup order", func() {
+			dump := c.Dump()
+
+			So(dump.Accept, ShouldResemble, policy.IPRuleList{
+				{Address: "10.1.0.0/16", Port: "443:445", Protocol: "tcp", SourcePort: "1:1023", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "narrow"}},
+				{Address: "10.0.0.0/8", Port: "80", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "wide"}},
+			})
+			So(dump.Reject, ShouldResemble, policy.IPRuleList{
+				{Address: "20.0.0.1/32", Port: "0:65535", Protocol: policy.AnyProtocol, Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"}},
+			})
+			So(len(dump.Observe), ShouldEqual, 1)
+			So(dump.Observe[0].Policy.PolicyID, ShouldEqual, "observe")
+			So(len(dump.Implicit), ShouldEqual, 2)
+			So(dump.Default, ShouldEqual, catchAllPolicy)
+		})
+
+		Convey("When I add the dumped rules to a new cache, I should get the same dump", func() {
+			dump := c.Dump()
+
+			n := NewACLCache()
+			So(n.AddRuleList(dump.Reject), ShouldBeNil)
+			So(n.AddRuleList(dump.Accept), ShouldBeNil)
+			So(n.AddRuleList(dump.Observe), ShouldBeNil)
+
+			ndump := n.Dump()
+			So(ndump.Reject, ShouldResemble, dump.Reject)
+			So(ndump.Accept, ShouldResemble, dump.Accept)
+			So(ndump.Observe, ShouldResemble, dump.Observe)
+			So(ndump.Implicit, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,105 @@
+package acls
+
+import "sort"
+
+// addressSet is a named set of IPv4 networks that the rules can reference,
+// like an ipset. The networks are stored by prefix length, so that the
+// membership of an address is checked with one lookup per prefix length
+// regardless of the size of the set.
+type addressSet struct {
+	sortedPrefixLens []int
+	networks         map[int]map[uint32]struct{}
+}
+
+// newAddressSet returns a set of the given addresses or networks.
+func newAddressSet(addresses []string) (*addressSet, error) {
+
+	s := &addressSet{
+		networks: map[int]map[uint32]struct{}{},
+	}
+
+	if err := s.add(addresses); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// add adds addresses or networks to the set. The set is not changed if one
+// of them is invalid.
+func (s *addressSet) add(addresses []string) error {
+
+	type network struct {
+		subnet uint32
+		plen   int
+	}
+
+	parsed := make([]network, 0, len(addresses))
+	for _, address := range addresses {
+		subnet, plen, err := parseNetwork(address)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, network{subnet: subnet, plen: plen})
+	}
+
+	for _, n := range parsed {
+		subnets, ok := s.networks[n.plen]
+		if !ok {
+			subnets = map[uint32]struct{}{}
+			s.networks[n.plen] = subnets
+			s.sortPrefixLens()
+		}
+		subnets[n.subnet] = struct{}{}
+	}
+
+	return nil
+}
+
+// remove removes addresses or networks from the set. The networks are
+// removed as they were added: removing an address does not split a network
+// that contains it.
+func (s *addressSet) remove(addresses []string) error {
+
+	for _, address := range addresses {
+		subnet, plen, err := parseNetwork(address)
+		if err != nil {
+			return err
+		}
+
+		subnets, ok := s.networks[plen]
+		if !ok {
+			continue
+		}
+
+		delete(subnets, subnet)
+		if len(subnets) == 0 {
+			delete(s.networks, plen)
+			s.sortPrefixLens()
+		}
+	}
+
+	return nil
+}
+
+// contains returns true if a network of the set contains the address.
+func (s *addressSet) contains(addr uint32) bool {
+
+	for _, plen := range s.sortedPrefixLens {
+		mask := ^uint32(0) << uint(32-plen)
+		if _, ok := s.networks[plen][addr&mask]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *addressSet) sortPrefixLens() {
+
+	s.sortedPrefixLens = s.sortedPrefixLens[:0]
+	for plen := range s.networks {
+		s.sortedPrefixLens = append(s.sortedPrefixLens, plen)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(s.sortedPrefixLens)))
+}
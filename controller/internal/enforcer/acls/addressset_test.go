@@ -0,0 +1,203 @@
+package acls
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/policy"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// feedAddresses returns n distinct addresses, such as the addresses of a
+// threat feed.
+func feedAddresses(n int) []string {
+
+	addresses := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		addresses = append(addresses, fmt.Sprintf("20.%d.%d.%d", (i>>16)&0xFF, (i>>8)&0xFF, i&0xFF))
+	}
+
+	return addresses
+}
+
+var feedPolicy = &policy.FlowPolicy{Action: policy.Reject, PolicyID: "feed"}
+
+func TestAddressSetRules(t *testing.T) {
+
+	Convey("Given an ACL cache with a rule that references an address set", t, func() {
+		c := NewACLCacheWithDefault(policy.FlowPolicy{Action: policy.Accept, PolicyID: "default"})
+		So(c.AddRuleList(policy.IPRuleList{
+			{
+				AddressSet: "feed",
+				Protocol:   policy.AnyProtocol,
+				Policy:     feedPolicy,
+			},
+			{
+				Address:  "30.0.0.0/8",
+				Port:     "443",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject, PolicyID: "network"},
+			},
+		}), ShouldBeNil)
+
+		Convey("When the set does not exist, the rule should match no address", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("20.0.0.1").To4(), 443, 0)
+			So(err, ShouldEqual, ErrNoMatch)
+			So(p.PolicyID, ShouldEqual, "default")
+		})
+
+		Convey("When the set is added", func() {
+			So(c.UpdateAddressSet("feed", []string{"20.0.0.1", "20.1.0.0/16"}), ShouldBeNil)
+
+			Convey("Then the rule should match the addresses and networks of the set", func() {
+				for _, ip := range []string{"20.0.0.1", "20.1.2.3"} {
+					_, p, err := c.GetMatchingAction(net.ParseIP(ip).To4(), 80, 0)
+					So(err, ShouldBeNil)
+					So(p.PolicyID, ShouldEqual, "feed")
+				}
+
+				_, p, err := c.GetMatchingAction(net.ParseIP("20.0.0.2").To4(), 80, 0)
+				So(err, ShouldEqual, ErrNoMatch)
+				So(p.PolicyID, ShouldEqual, "default")
+			})
+
+			Convey("Then the rules of the networks should be matched first", func() {
+				So(c.AddToAddressSet("feed", []string{"30.1.1.1"}), ShouldBeNil)
+
+				_, p, err := c.GetMatchingAction(net.ParseIP("30.1.1.1").To4(), 443, 0)
+				So(err, ShouldBeNil)
+				So(p.PolicyID, ShouldEqual, "network")
+
+				_, p, err = c.GetMatchingAction(net.ParseIP("30.1.1.1").To4(), 80, 0)
+				So(err, ShouldBeNil)
+				So(p.PolicyID, ShouldEqual, "feed")
+			})
+
+			Convey("Then the addresses added to the set should be matched", func() {
+				So(c.AddToAddressSet("feed", []string{"20.0.0.2"}), ShouldBeNil)
+
+				_, p, err := c.GetMatchingAction(net.ParseIP("20.0.0.2").To4(), 80, 0)
+				So(err, ShouldBeNil)
+				So(p.PolicyID, ShouldEqual, "feed")
+			})
+
+			Convey("Then the addresses removed from the set should not be matched", func() {
+				So(c.RemoveFromAddressSet("feed", []string{"20.0.0.1", "20.1.0.0/16"}), ShouldBeNil)
+
+				for _, ip := range []string{"20.0.0.1", "20.1.2.3"} {
+					_, _, err := c.GetMatchingAction(net.ParseIP(ip).To4(), 80, 0)
+					So(err, ShouldEqual, ErrNoMatch)
+				}
+			})
+
+			Convey("Then removing an address should not split a network of the set", func() {
+				So(c.RemoveFromAddressSet("feed", []string{"20.1.2.3"}), ShouldBeNil)
+
+				_, _, err := c.GetMatchingAction(net.ParseIP("20.1.2.3").To4(), 80, 0)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the addresses of an updated set should replace its addresses", func() {
+				So(c.UpdateAddressSet("feed", []string{"20.0.0.2"}), ShouldBeNil)
+
+				_, _, err := c.GetMatchingAction(net.ParseIP("20.0.0.1").To4(), 80, 0)
+				So(err, ShouldEqual, ErrNoMatch)
+				_, _, err = c.GetMatchingAction(net.ParseIP("20.0.0.2").To4(), 80, 0)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the rule should match no address once the set is removed", func() {
+				c.RemoveAddressSet("feed")
+
+				_, _, err := c.GetMatchingAction(net.ParseIP("20.0.0.1").To4(), 80, 0)
+				So(err, ShouldEqual, ErrNoMatch)
+			})
+
+			Convey("Then the rule should be dumped with the name of the set", func() {
+				dump := c.Dump()
+				So(dump.Reject[len(dump.Reject)-1], ShouldResemble, policy.IPRule{
+					AddressSet: "feed",
+					Port:       "0:65535",
+					Protocol:   policy.AnyProtocol,
+					Policy:     feedPolicy,
+				})
+			})
+		})
+
+		Convey("When invalid addresses are added to a set, it should not be changed", func() {
+			So(c.UpdateAddressSet("feed", []string{"20.0.0.1"}), ShouldBeNil)
+
+			So(c.UpdateAddressSet("feed", []string{"20.0.0.2", "invalid"}), ShouldNotBeNil)
+			So(c.AddToAddressSet("feed", []string{"20.0.0.3", "20.0.0.0/33"}), ShouldNotBeNil)
+			So(c.AddToAddressSet("other", []string{"2001:db8::1"}), ShouldNotBeNil)
+
+			_, _, err := c.GetMatchingAction(net.ParseIP("20.0.0.1").To4(), 80, 0)
+			So(err, ShouldBeNil)
+			for _, ip := range []string{"20.0.0.2", "20.0.0.3"} {
+				_, _, err := c.GetMatchingAction(net.ParseIP(ip).To4(), 80, 0)
+				So(err, ShouldEqual, ErrNoMatch)
+			}
+		})
+
+		Convey("When addresses are removed from a set that does not exist, I should get an error", func() {
+			So(c.RemoveFromAddressSet("unknown", []string{"20.0.0.1"}), ShouldNotBeNil)
+		})
+	})
+}
+
+func benchmarkFeedLookup(b *testing.B, c *ACLCache, addresses []string) {
+
+	ips := make([][]byte, 0, len(addresses))
+	for _, address := range addresses {
+		ips = append(ips, net.ParseIP(address).To4())
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c.GetMatchingAction(ips[i%len(ips)], 80, 0) // nolint errcheck
+	}
+}
+
+func BenchmarkFeedLookupIndividualRules(b *testing.B) {
+
+	addresses := feedAddresses(100000)
+
+	rules := make(policy.IPRuleList, 0, len(addresses))
+	for _, address := range addresses {
+		rules = append(rules, policy.IPRule{
+			Address:  address,
+			Protocol: policy.AnyProtocol,
+			Policy:   feedPolicy,
+		})
+	}
+
+	c := NewACLCache()
+	if err := c.AddRuleList(rules); err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkFeedLookup(b, c, addresses)
+}
+
+func BenchmarkFeedLookupAddressSet(b *testing.B) {
+
+	addresses := feedAddresses(100000)
+
+	c := NewACLCache()
+	if err := c.AddRule(policy.IPRule{
+		AddressSet: "feed",
+		Protocol:   policy.AnyProtocol,
+		Policy:     feedPolicy,
+	}); err != nil {
+		b.Fatal(err)
+	}
+
+	if err := c.UpdateAddressSet("feed", addresses); err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkFeedLookup(b, c, addresses)
+}
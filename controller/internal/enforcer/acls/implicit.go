@@ -0,0 +1,61 @@
+package acls
+
+import (
+	"fmt"
+	"net"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+var implicitAllowPolicy = &policy.FlowPolicy{Action: policy.Accept, PolicyID: "implicit", ServiceID: "implicit"}
+
+// ValidateImplicitAllowNetworks returns an error if one of the networks
+// cannot be implicitly allowed. Only IPv4 networks are supported.
+func ValidateImplicitAllowNetworks(networks []string) error {
+
+	for _, network := range networks {
+		ip, _, err := net.ParseCIDR(network)
+		if err != nil {
+			return fmt.Errorf("invalid network %s: %s", network, err)
+		}
+		if ip.To4() == nil {
+			return fmt.Errorf("only ipv4 networks can be implicitly allowed: %s", network)
+		}
+	}
+
+	return nil
+}
+
+// SetImplicitAllowNetworks sets the networks whose traffic the cache accepts
+// when no rule matches, such as the loopback and link-local networks.
+// Explicit rules take precedence over the implicit ones. An empty list
+// removes the implicit rules.
+func (c *ACLCache) SetImplicitAllowNetworks(networks []string) error {
+
+	if err := ValidateImplicitAllowNetworks(networks); err != nil {
+		return err
+	}
+
+	var implicit *acl
+	if len(networks) > 0 {
+		implicit = newACL()
+		for _, network := range networks {
+			if err := implicit.addRule(policy.IPRule{
+				Address:  network,
+				Port:     "0:65535",
+				Protocol: policy.AnyProtocol,
+				Policy:   implicitAllowPolicy,
+			}); err != nil {
+				return fmt.Errorf("unable to allow network %s: %s", network, err)
+			}
+		}
+		implicit.reverseSort()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.implicit = implicit
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package acls
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestImplicitAllowCacheLookup(t *testing.T) {
+
+	Convey("Given a cache with implicit rules for the loopback and link-local networks", t, func() {
+		c := NewACLCache()
+		So(c.SetImplicitAllowNetworks([]string{"127.0.0.0/8", "169.254.0.0/16"}), ShouldBeNil)
+
+		Convey("Loopback, link-local and metadata traffic should be accepted", func() {
+			for _, ip := range []string{"127.0.0.1", "127.1.2.3", "169.254.1.1", "169.254.169.254"} {
+				_, p, err := c.GetMatchingAction(net.ParseIP(ip).To4(), 80, 0)
+				So(err, ShouldBeNil)
+				So(p.Action, ShouldEqual, policy.Accept)
+				So(p.PolicyID, ShouldEqual, "implicit")
+			}
+		})
+
+		Convey("Other traffic should be rejected by the catch all rule", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 80, 0)
+			So(err, ShouldEqual, ErrNoMatch)
+			So(p.Action, ShouldEqual, policy.Reject)
+		})
+
+		Convey("Explicit rules should take precedence over the implicit rules", func() {
+			err := c.AddRuleList(policy.IPRuleList{
+				policy.IPRule{
+					Address:  "169.254.169.254/32",
+					Port:     "80",
+					Protocol: "tcp",
+					Policy: &policy.FlowPolicy{
+						Action:   policy.Reject,
+						PolicyID: "metadata"},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			_, p, err := c.GetMatchingAction(net.ParseIP("169.254.169.254").To4(), 80, 0)
+			So(err, ShouldBeNil)
+			So(p.Action, ShouldEqual, policy.Reject)
+			So(p.PolicyID, ShouldEqual, "metadata")
+		})
+
+		Convey("When I set other networks, only they should be accepted", func() {
+			So(c.SetImplicitAllowNetworks([]string{"192.168.0.0/16"}), ShouldBeNil)
+
+			_, p, err := c.GetMatchingAction(net.ParseIP("192.168.1.1").To4(), 80, 0)
+			So(err, ShouldBeNil)
+			So(p.Action, ShouldEqual, policy.Accept)
+
+			_, p, err = c.GetMatchingAction(net.ParseIP("169.254.169.254").To4(), 80, 0)
+			So(err, ShouldEqual, ErrNoMatch)
+			So(p.Action, ShouldEqual, policy.Reject)
+		})
+
+		Convey("When I remove the networks, loopback traffic should be rejected", func() {
+			So(c.SetImplicitAllowNetworks(nil), ShouldBeNil)
+
+			_, p, err := c.GetMatchingAction(net.ParseIP("127.0.0.1").To4(), 80, 0)
+			So(err, ShouldEqual, ErrNoMatch)
+			So(p.Action, ShouldEqual, policy.Reject)
+		})
+
+		Convey("When I set invalid or ipv6 networks, it should fail and keep the networks", func() {
+			So(c.SetImplicitAllowNetworks([]string{"127.0.0.1"}), ShouldNotBeNil)
+			So(c.SetImplicitAllowNetworks([]string{"::1/128"}), ShouldNotBeNil)
+
+			_, p, err := c.GetMatchingAction(net.ParseIP("127.0.0.1").To4(), 80, 0)
+			So(err, ShouldBeNil)
+			So(p.Action, ShouldEqual, policy.Accept)
+		})
+	})
+
+	Convey("Given a cache without implicit rules", t, func() {
+		c := NewACLCache()
+
+		Convey("Loopback traffic should be rejected", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("127.0.0.1").To4(), 80, 0)
+			So(err, ShouldEqual, ErrNoMatch)
+			So(p.Action, ShouldEqual, policy.Reject)
+		})
+	})
+}
@@ -11,9 +11,14 @@ import (
 
 // portAction captures the minimum and maximum ports for an action
 type portAction struct {
-	min    uint16
-	max    uint16
-	policy *policy.FlowPolicy
+	min      uint16
+	max      uint16
+	wildcard bool
+	// srcMin and srcMax constrain the source port when sourcePort is set
+	srcMin     uint16
+	srcMax     uint16
+	sourcePort bool
+	policy     *policy.FlowPolicy
 }
 
 // portActionList is a list of Port Actions
@@ -22,51 +27,162 @@ type portActionList []*portAction
 // newPortAction parses a port spec and creates the action
 func newPortAction(rule policy.IPRule) (*portAction, error) {
 
-	p := &portAction{}
-	if strings.Contains(rule.Port, ":") {
-		parts := strings.Split(rule.Port, ":")
+	p := &portAction{
+		policy: rule.Policy,
+	}
+
+	if rule.SourcePort != "" {
+		min, max, err := parsePortRange(rule.SourcePort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source port: %s", err)
+		}
+		p.srcMin = min
+		p.srcMax = max
+		p.sourcePort = true
+	}
+
+	if strings.EqualFold(rule.Protocol, policy.AnyProtocol) {
+		p.min = 0
+		p.max = 65535
+		p.wildcard = true
+		return p, nil
+	}
+
+	min, max, err := parsePortRange(rule.Port)
+	if err != nil {
+		return nil, err
+	}
+	p.min = min
+	p.max = max
+
+	return p, nil
+}
+
+// parsePortRange parses a single port or a min:max port range.
+func parsePortRange(spec string) (uint16, uint16, error) {
+
+	var min, max uint16
+
+	if strings.Contains(spec, ":") {
+		parts := strings.Split(spec, ":")
 		if len(parts) != 2 {
-			return nil, fmt.Errorf("invalid port: %s", rule.Port)
+			return 0, 0, fmt.Errorf("invalid port: %s", spec)
 		}
 
 		port, err := strconv.Atoi(parts[0])
 		if err != nil {
-			return nil, err
+			return 0, 0, err
 		}
-		p.min = uint16(port)
+		min = uint16(port)
 
 		port, err = strconv.Atoi(parts[1])
 		if err != nil {
-			return nil, err
+			return 0, 0, err
 		}
-		p.max = uint16(port)
+		max = uint16(port)
 
 	} else {
-		port, err := strconv.Atoi(rule.Port)
+		port, err := strconv.Atoi(spec)
 		if err != nil {
-			return nil, err
+			return 0, 0, err
 		}
 
-		p.min = uint16(port)
-		p.max = p.min
+		min = uint16(port)
+		max = min
 	}
 
-	if p.min > p.max {
-		return nil, errors.New("min port is greater than max port")
+	if min > max {
+		return 0, 0, errors.New("min port is greater than max port")
 	}
 
-	p.policy = rule.Policy
+	return min, max, nil
+}
 
-	return p, nil
+// rule returns the rule of the port action for the given address.
+func (p *portAction) rule(address string) policy.IPRule {
+
+	rule := policy.IPRule{
+		Address:  address,
+		Port:     portRange(p.min, p.max),
+		Protocol: "tcp",
+		Policy:   p.policy,
+	}
+
+	if p.wildcard {
+		rule.Protocol = policy.AnyProtocol
+	}
+
+	if p.sourcePort {
+		rule.SourcePort = portRange(p.srcMin, p.srcMax)
+	}
+
+	return rule
+}
+
+// portRange formats a port range as parsed by parsePortRange.
+func portRange(min, max uint16) string {
+
+	if min == max {
+		return strconv.Itoa(int(min))
+	}
+
+	return strconv.Itoa(int(min)) + ":" + strconv.Itoa(int(max))
+}
+
+// rank orders the port actions from the most to the least specific. Actions
+// on specific ports come before wildcard actions, and among them actions that
+// constrain the source port come first.
+func (p *portAction) rank() int {
+
+	r := 0
+	if p.wildcard {
+		r += 2
+	}
+	if !p.sourcePort {
+		r++
+	}
+
+	return r
+}
+
+// matches returns true if the ports match the action. The source port 0 is
+// never used by a flow and stands for an unknown source port, which matches
+// no action that constrains the source port.
+func (p *portAction) matches(port uint16, sourcePort uint16) bool {
+
+	if port < p.min || port > p.max {
+		return false
+	}
+
+	if p.sourcePort && (sourcePort == 0 || sourcePort < p.srcMin || sourcePort > p.srcMax) {
+		return false
+	}
+
+	return true
+}
+
+// insert adds a port action to the list. The list is kept ordered by rank
+// so that the most specific action is matched first. Actions of the same rank
+// keep their insertion order.
+func (p *portActionList) insert(r *portAction) {
+
+	i := len(*p)
+	for i > 0 && (*p)[i-1].rank() > r.rank() {
+		i--
+	}
+
+	*p = append(*p, nil)
+	copy((*p)[i+1:], (*p)[i:])
+	(*p)[i] = r
 }
 
-func (p *portActionList) lookup(port uint16, preReported *policy.FlowPolicy) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
+func (p *portActionList) lookup(port uint16, sourcePort uint16, preReported *policy.FlowPolicy) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
 
 	report = preReported
 
 	// Scan the ports - TODO: better algorithm needed here
 	for _, pa := range *p {
-		if port >= pa.min && port <= pa.max {
+		if pa.matches(port, sourcePort) {
 
 			// Check observed policies.
 			if pa.policy.ObserveAction.Observed() {
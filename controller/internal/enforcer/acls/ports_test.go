@@ -14,7 +14,7 @@ func TestEmptyPortListLookup(t *testing.T) {
 		pl := &portActionList{}
 
 		Convey("When I lookup for a matching port, I should not get any result", func() {
-			r, p, err := pl.lookup(10, nil)
+			r, p, err := pl.lookup(10, 0, nil)
 			So(err, ShouldNotBeNil)
 			So(r, ShouldBeNil)
 			So(p, ShouldBeNil)
@@ -41,7 +41,7 @@ func TestPortListLookup(t *testing.T) {
 		pl := &portActionList{pa}
 
 		Convey("When I lookup for a matching port, I should get accept", func() {
-			r, p, err := pl.lookup(10, nil)
+			r, p, err := pl.lookup(10, 0, nil)
 			So(err, ShouldBeNil)
 			So(r.Action, ShouldEqual, policy.Accept)
 			So(r.PolicyID, ShouldEqual, "portMatch")
@@ -50,14 +50,14 @@ func TestPortListLookup(t *testing.T) {
 		})
 
 		Convey("When I lookup for a non matching port, I should get error", func() {
-			r, p, err := pl.lookup(0, nil)
+			r, p, err := pl.lookup(0, 0, nil)
 			So(err, ShouldNotBeNil)
 			So(r, ShouldBeNil)
 			So(p, ShouldBeNil)
 		})
 
 		Convey("When I lookup for a non matching port, I should get error but get the unmodified reported flow input", func() {
-			r, p, err := pl.lookup(0, &policy.FlowPolicy{
+			r, p, err := pl.lookup(0, 0, &policy.FlowPolicy{
 				Action:   policy.Accept,
 				PolicyID: "portPreMatch"},
 			)
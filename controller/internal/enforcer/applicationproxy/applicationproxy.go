@@ -13,6 +13,7 @@ import (
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/applicationproxy/markedconn"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/applicationproxy/protomux"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/applicationproxy/tcp"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/applicationproxy/uds"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
 	"go.aporeto.io/trireme-lib/controller/internal/portset"
 	"go.aporeto.io/trireme-lib/controller/pkg/auth"
@@ -94,6 +95,12 @@ func (p *AppProxy) Run(ctx context.Context) error {
 	return nil
 }
 
+// RunUnixSocketProcessor applies the policy to the unix domain socket
+// connections captured by the interceptor until the context is cancelled.
+func (p *AppProxy) RunUnixSocketProcessor(ctx context.Context, i uds.Interceptor, resolver uds.ContextResolver) error {
+	return uds.NewProcessor(p.puFromID, p.collector, resolver).Run(ctx, i)
+}
+
 // Enforce implements enforcer.Enforcer interface. It will create the necessary
 // proxies for the particular PU. Enforce can be called multiple times, once
 // for every policy update.
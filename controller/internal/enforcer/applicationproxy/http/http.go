@@ -330,7 +330,7 @@ func (p *Config) processAppRequest(w http.ResponseWriter, r *http.Request) {
 		Count:       1,
 	}
 
-	_, netaction, noNetAccesPolicy := puContext.ApplicationACLPolicyFromAddr(originalDestination.IP.To4(), uint16(originalDestination.Port))
+	_, netaction, noNetAccesPolicy := puContext.ApplicationACLPolicyFromAddr(originalDestination.IP.To4(), uint16(originalDestination.Port), sourcePort(r))
 	if noNetAccesPolicy == nil && netaction.Action.Rejected() {
 		http.Error(w, fmt.Sprintf("Unauthorized Service - Rejected Outgoing Request by Network Policies"), http.StatusNetworkAuthenticationRequired)
 		record.PolicyID = netaction.PolicyID
@@ -451,7 +451,7 @@ func (p *Config) processNetRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check for network access rules that might require a drop.
-	_, aclPolicy, noNetAccessPolicy := puContext.NetworkACLPolicyFromAddr(sourceAddress.IP.To4(), uint16(originalDestination.Port))
+	_, aclPolicy, noNetAccessPolicy := puContext.NetworkACLPolicyFromAddr(sourceAddress.IP.To4(), uint16(originalDestination.Port), uint16(sourceAddress.Port))
 	record.PolicyID = aclPolicy.PolicyID
 	record.Source.ID = aclPolicy.ServiceID
 	if noNetAccessPolicy == nil && aclPolicy.Action.Rejected() {
@@ -556,7 +556,11 @@ func (p *Config) processNetRequest(w http.ResponseWriter, r *http.Request) {
 	// host. Check of network rules that allow this transfer and report the corresponding
 	// flows.
 	if _, ok := p.localIPs[originalDestination.IP.String()]; !ok {
-		_, action, err := puContext.ApplicationACLPolicyFromAddr(originalDestination.IP.To4(), uint16(originalDestination.Port))
+		// The forwarded connection is dialed from an ephemeral port that is not
+		// known here, and the source port of the client is not the source port
+		// of that connection. The unknown source port matches no rule that has
+		// a source port.
+		_, action, err := puContext.ApplicationACLPolicyFromAddr(originalDestination.IP.To4(), uint16(originalDestination.Port), 0)
 		if err != nil || action.Action.Rejected() {
 			defer p.collector.CollectFlowEvent(reportDownStream(record, action))
 			http.Error(w, fmt.Sprintf("Access denied by network policy"), http.StatusNetworkAuthenticationRequired)
@@ -623,6 +627,15 @@ func (p *Config) isSecretsRequest(w http.ResponseWriter, r *http.Request) bool {
 	return true
 }
 
+// sourcePort returns the source port of the request or 0 if it is unknown.
+func sourcePort(r *http.Request) uint16 {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return 0
+	}
+	return uint16(addr.Port)
+}
+
 func appendDefaultPort(address string) string {
 	if !strings.Contains(address, ":") {
 		return address + ":80"
@@ -0,0 +1,149 @@
+// Package uds applies the network policy to connections between processes
+// over unix domain sockets. The connections are captured by an Interceptor,
+// such as a preloaded library or a proxy listener, and the processor decides
+// if they are allowed based on the identity of the PUs at both ends.
+package uds
+
+import (
+	"context"
+	"fmt"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/cache"
+	"go.uber.org/zap"
+)
+
+// PeerCredentials are the credentials of a process at one end of a unix
+// domain socket as reported by SO_PEERCRED.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// Request is an intercepted connection over a unix domain socket.
+type Request struct {
+	// Path is the path of the socket. Abstract sockets start with '@'.
+	Path string
+	// Client is the process that connects to the socket
+	Client PeerCredentials
+	// Server is the process that listens on the socket
+	Server PeerCredentials
+}
+
+// Handler returns true if the connection is allowed.
+type Handler func(r *Request) bool
+
+// Interceptor captures the connections over unix domain sockets.
+type Interceptor interface {
+	// Run captures connections and calls the handler for every connection
+	// before it is established, until the context is cancelled.
+	Run(ctx context.Context, h Handler) error
+}
+
+// ContextResolver returns the context ID of the PU of a process. It returns
+// an error if the process does not belong to a PU.
+type ContextResolver func(creds PeerCredentials) (string, error)
+
+// Processor evaluates the policy of the connections.
+type Processor struct {
+	puFromID  cache.DataStore
+	collector collector.EventCollector
+	resolver  ContextResolver
+}
+
+// NewProcessor returns a processor that looks up the PUs in the given cache.
+func NewProcessor(puFromID cache.DataStore, c collector.EventCollector, resolver ContextResolver) *Processor {
+	return &Processor{
+		puFromID:  puFromID,
+		collector: c,
+		resolver:  resolver,
+	}
+}
+
+// Run starts the interceptor with the processor as the handler.
+func (p *Processor) Run(ctx context.Context, i Interceptor) error {
+	return i.Run(ctx, p.Authorize)
+}
+
+// Authorize applies the policy to the connection and reports the flow. The
+// receive rules of the server are searched with the identity of the client and
+// the transmit rules of the client with the identity of the server. Connections
+// to a process that is not part of a PU are not policed.
+func (p *Processor) Authorize(r *Request) bool {
+
+	server, err := p.puFromCredentials(r.Server)
+	if err != nil {
+		zap.L().Debug("Unix socket server is not a PU", zap.String("path", r.Path), zap.Error(err))
+		return true
+	}
+
+	record := &collector.FlowRecord{
+		ContextID: server.ID(),
+		Source: &collector.EndPoint{
+			ID:   collector.DefaultEndPoint,
+			Type: collector.EndPointTypeExternalIP,
+		},
+		Destination: &collector.EndPoint{
+			ID:   server.ManagementID(),
+			URI:  r.Path,
+			Type: collector.EnpointTypePU,
+		},
+		Tags:        server.Annotations(),
+		ServiceType: policy.ServiceUnixSocket,
+		Count:       1,
+	}
+
+	defer p.collector.CollectFlowEvent(record)
+
+	clientTags := policy.NewTagStore()
+	client, err := p.puFromCredentials(r.Client)
+	if err == nil {
+		clientTags = client.Identity()
+		record.Source.ID = client.ManagementID()
+		record.Source.Type = collector.EnpointTypePU
+	}
+
+	report, action := server.SearchRcvRules(clientTags)
+	if action.Action.Accepted() && client != nil {
+		// The flow is reported with the policy of the server, unless the
+		// policy of the client rejects it.
+		if txtReport, txtAction := client.SearchTxtRules(server.Identity(), false); txtAction.Action.Rejected() {
+			report, action = txtReport, txtAction
+		}
+	}
+
+	record.PolicyID = report.PolicyID
+	record.SelectorID = report.SelectorID
+	record.Action = report.Action
+	if report.ObserveAction.Observed() {
+		record.ObservedAction = action.Action
+		record.ObservedPolicyID = action.PolicyID
+		record.ObservedSelectorID = action.SelectorID
+	}
+
+	if action.Action.Rejected() {
+		record.DropReason = collector.PolicyDrop
+		return false
+	}
+
+	return true
+}
+
+// puFromCredentials returns the PU of the process.
+func (p *Processor) puFromCredentials(creds PeerCredentials) (*pucontext.PUContext, error) {
+
+	contextID, err := p.resolver(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	pu, err := p.puFromID.Get(contextID)
+	if err != nil {
+		return nil, fmt.Errorf("unknown pu %s: %s", contextID, err)
+	}
+
+	return pu.(*pucontext.PUContext), nil
+}
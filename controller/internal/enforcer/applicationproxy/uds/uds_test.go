@@ -0,0 +1,122 @@
+package uds
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/cache"
+)
+
+type flowCollector struct {
+	collector.DefaultCollector
+	flows []*collector.FlowRecord
+}
+
+func (c *flowCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.flows = append(c.flows, record)
+}
+
+func selector(key, value string, action policy.ActionType) policy.TagSelectorList {
+	return policy.TagSelectorList{
+		policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{
+					Key:      key,
+					Value:    []string{value},
+					Operator: policy.Equal,
+				},
+			},
+			Policy: &policy.FlowPolicy{Action: action, PolicyID: key + "=" + value},
+			ID:     "selector:" + key + "=" + value,
+		},
+	}
+}
+
+func newTestPU(contextID string, identity map[string]string, txt, rcv policy.TagSelectorList) *pucontext.PUContext {
+
+	plc := policy.NewPUPolicy(contextID, policy.AllowAll, nil, nil, nil, txt, rcv, policy.NewTagStoreFromMap(identity), nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+	runtime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+
+	pu, err := pucontext.NewPU(contextID, policy.PUInfoFromPolicyAndRuntime(contextID, plc, runtime), time.Second)
+	So(err, ShouldBeNil)
+
+	return pu
+}
+
+func TestAuthorize(t *testing.T) {
+
+	Convey("Given a processor with a client and a server pu", t, func() {
+
+		server := newTestPU("server", map[string]string{"app": "server"}, nil, selector("app", "client", policy.Accept))
+		client := newTestPU("client", map[string]string{"app": "client"}, selector("app", "server", policy.Accept), nil)
+		other := newTestPU("other", map[string]string{"app": "other"}, nil, selector("app", "client", policy.Accept))
+		intruder := newTestPU("intruder", map[string]string{"app": "intruder"}, selector("app", "server", policy.Accept), nil)
+
+		puFromID := cache.NewCache("pu")
+		puFromID.AddOrUpdate("server", server)
+		puFromID.AddOrUpdate("client", client)
+		puFromID.AddOrUpdate("other", other)
+		puFromID.AddOrUpdate("intruder", intruder)
+
+		pids := map[int32]string{1: "server", 2: "client", 3: "other", 4: "intruder", 5: "unknown"}
+		resolver := func(creds PeerCredentials) (string, error) {
+			if id, ok := pids[creds.PID]; ok {
+				return id, nil
+			}
+			return "", errors.New("not a pu")
+		}
+
+		c := &flowCollector{}
+		p := NewProcessor(puFromID, c, resolver)
+
+		request := func(client, server int32) *Request {
+			return &Request{
+				Path:   "@server.sock",
+				Client: PeerCredentials{PID: client},
+				Server: PeerCredentials{PID: server},
+			}
+		}
+
+		Convey("When the policy allows the client, the connection should be accepted and reported", func() {
+			So(p.Authorize(request(2, 1)), ShouldBeTrue)
+			So(len(c.flows), ShouldEqual, 1)
+			So(c.flows[0].ContextID, ShouldEqual, "server")
+			So(c.flows[0].Source.Type, ShouldEqual, collector.EnpointTypePU)
+			So(c.flows[0].Destination.URI, ShouldEqual, "@server.sock")
+			So(c.flows[0].ServiceType, ShouldEqual, policy.ServiceUnixSocket)
+			So(c.flows[0].Action.Accepted(), ShouldBeTrue)
+			So(c.flows[0].PolicyID, ShouldEqual, "app=client")
+			So(c.flows[0].SelectorID, ShouldEqual, "selector:app=client")
+		})
+
+		Convey("When the policy of the server does not allow the client, the connection should be rejected", func() {
+			So(p.Authorize(request(4, 1)), ShouldBeFalse)
+			So(len(c.flows), ShouldEqual, 1)
+			So(c.flows[0].Action.Rejected(), ShouldBeTrue)
+			So(c.flows[0].DropReason, ShouldEqual, collector.PolicyDrop)
+		})
+
+		Convey("When the policy of the client does not allow the server, the connection should be rejected", func() {
+			So(p.Authorize(request(2, 3)), ShouldBeFalse)
+			So(len(c.flows), ShouldEqual, 1)
+			So(c.flows[0].Action.Rejected(), ShouldBeTrue)
+		})
+
+		Convey("When the client is not a pu, the connection should be rejected", func() {
+			So(p.Authorize(request(6, 1)), ShouldBeFalse)
+			So(len(c.flows), ShouldEqual, 1)
+			So(c.flows[0].Source.ID, ShouldEqual, collector.DefaultEndPoint)
+		})
+
+		Convey("When the server is not a pu, the connection should not be policed", func() {
+			So(p.Authorize(request(2, 5)), ShouldBeTrue)
+			So(len(c.flows), ShouldEqual, 0)
+		})
+	})
+}
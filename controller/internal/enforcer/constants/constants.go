@@ -7,6 +7,9 @@ const (
 	TCPAuthenticationOptionAckLen = 20
 	// PortNumberLabelString is the label to use for port numbers
 	PortNumberLabelString = "$sys:port"
+	// ReverseDNSLabelString is the label of the flows to external addresses
+	// with the reverse DNS name of the address
+	ReverseDNSLabelString = "$sys:ptr"
 	// TransmitterLabel is the name of the label used to identify the Transmitter Context
 	TransmitterLabel = "AporetoContextID"
 	// DefaultNetwork to be used
@@ -2,7 +2,9 @@ package enforcer
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"time"
 
 	"go.aporeto.io/trireme-lib/collector"
@@ -42,6 +44,167 @@ type Enforcer interface {
 	UpdateSecrets(secrets secrets.Secrets) error
 
 	SetTargetNetworks(networks []string) error
+
+	// SetExcludedPorts sets the destination ports of the flows that bypass
+	// the enforcement. The bypassed flows are reported if report is set.
+	SetExcludedPorts(ports []string, report bool) error
+
+	// UpdateAddressSet sets the addresses of an address set of the ACLs.
+	UpdateAddressSet(name string, addresses []string) error
+
+	// RemoveAddressSet removes an address set of the ACLs.
+	RemoveAddressSet(name string) error
+
+	// Ready returns a channel that is closed when the enforcer is ready to process packets.
+	Ready() <-chan struct{}
+
+	// Healthy returns an error if the enforcer is not ready or has degraded.
+	Healthy() error
+}
+
+// UDPConnectionPersister is implemented by enforcers that can persist their
+// established UDP connections across restarts.
+type UDPConnectionPersister interface {
+
+	// PersistUDPConnections restores the connections saved in the file and
+	// saves the established connections to it until the context is cancelled.
+	PersistUDPConnections(ctx context.Context, path string) error
+}
+
+// UDPStateDirSetter is implemented by enforcers that start other enforcers,
+// which persist their established UDP connections in a directory.
+type UDPStateDirSetter interface {
+
+	// SetUDPStateDir sets the directory where the enforcers started
+	// afterwards persist their established UDP connections.
+	SetUDPStateDir(dir string)
+}
+
+// StatsFlowHashSetter is implemented by enforcers that start other
+// enforcers, which aggregate their flows before they report them.
+type StatsFlowHashSetter interface {
+
+	// SetStatsFlowHash sets the name of the built-in flow hash function with
+	// which the enforcers started afterwards aggregate their flows.
+	SetStatsFlowHash(name string) error
+}
+
+// PolicyAuditor is implemented by enforcers that can audit their policy
+// decisions.
+type PolicyAuditor interface {
+
+	// AuditPolicyDecisions appends the records of the policy decisions to the
+	// file as JSON until the context is cancelled.
+	AuditPolicyDecisions(ctx context.Context, path string) error
+}
+
+// AuditDirSetter is implemented by enforcers that start other enforcers,
+// which audit their policy decisions in a directory.
+type AuditDirSetter interface {
+
+	// SetAuditDir sets the directory where the enforcers started afterwards
+	// append the records of their policy decisions.
+	SetAuditDir(dir string)
+}
+
+// ReverseDNSEnricher is implemented by enforcers that can resolve the names
+// of the external addresses.
+type ReverseDNSEnricher interface {
+
+	// EnableReverseDNS tags the accepted external flows with the reverse DNS
+	// name of the address, and matches it against the DNS rules if
+	// matchPolicy is set.
+	EnableReverseDNS(matchPolicy bool)
+}
+
+// ServerNameInspector is implemented by enforcers that can match the TLS
+// flows to the external services by their server name.
+type ServerNameInspector interface {
+
+	// EnableServerNameInspection matches the server name of the ClientHello
+	// of the external TLS flows that no ACL accepts against the DNS rules.
+	// The flows are only accepted if their server name resolves to their
+	// destination.
+	EnableServerNameInspection()
+}
+
+// PUStateExporter is implemented by enforcers that can export what they
+// enforce for a PU.
+type PUStateExporter interface {
+
+	// ExportPUState returns the state of the PU in the enforcer as JSON.
+	ExportPUState(contextID string) ([]byte, error)
+}
+
+// ConntrackExporter is implemented by enforcers that can export the
+// conntrack mark updates issued by their datapath.
+type ConntrackExporter interface {
+
+	// ExportConntrackUpdates returns the recent conntrack mark updates of the
+	// datapath that enforces the PU as JSON.
+	ExportConntrackUpdates(contextID string) ([]byte, error)
+}
+
+// PUDrainer is implemented by enforcers that can stop a PU from accepting
+// new connections before it is unenforced.
+type PUDrainer interface {
+
+	// DrainPU stops the PU from accepting new connections. The established
+	// connections are not affected.
+	DrainPU(contextID string) error
+}
+
+// UDPHandshakeLimiter is implemented by enforcers that limit the number of
+// half open UDP connections.
+type UDPHandshakeLimiter interface {
+
+	// SetUDPHandshakeLimits sets the maximum number of half open UDP
+	// connections of the enforcer and of every PU. A limit of 0 disables the check.
+	SetUDPHandshakeLimits(total, perPU int)
+}
+
+// UDPHandshakeTimeoutSetter is implemented by enforcers that expire the UDP
+// handshakes that do not complete.
+type UDPHandshakeTimeoutSetter interface {
+
+	// SetUDPHandshakeTimeout sets the time after which a UDP handshake that
+	// did not complete is reported and torn down. A timeout of 0 disables it.
+	SetUDPHandshakeTimeout(timeout time.Duration)
+}
+
+// UDPKeyRotationSetter is implemented by enforcers that rotate the keys of
+// the long-lived UDP connections.
+type UDPKeyRotationSetter interface {
+
+	// SetUDPKeyRotationInterval sets the lifetime of the keys of the UDP
+	// connections. An interval of 0 disables the rotation.
+	SetUDPKeyRotationInterval(interval time.Duration)
+}
+
+// ProtocolHelperEnabler is implemented by enforcers that can accept the
+// related connections announced on the control connections of protocols.
+type ProtocolHelperEnabler interface {
+
+	// EnableProtocolHelpers enables the protocol helpers with the given
+	// names, such as ftp.
+	EnableProtocolHelpers(names []string) error
+}
+
+// ImplicitAllowSetter is implemented by enforcers whose ACLs can accept the
+// traffic of some networks when no rule matches.
+type ImplicitAllowSetter interface {
+
+	// SetImplicitAllowNetworks sets the networks that the ACLs of the PUs
+	// enforced afterwards accept when no rule matches.
+	SetImplicitAllowNetworks(networks []string) error
+}
+
+// MarkResolverSetter is implemented by enforcers that can map the marks set
+// by other tools to the contexts of the PUs.
+type MarkResolverSetter interface {
+
+	// SetMarkResolver sets the resolver of the marks that the PUs don't own.
+	SetMarkResolver(r nfqdatapath.MarkResolver)
 }
 
 // enforcer holds all the active implementations of the enforcer
@@ -119,6 +282,21 @@ func (e *enforcer) SetTargetNetworks(networks []string) error {
 	return e.transport.SetTargetNetworks(networks)
 }
 
+// SetExcludedPorts sets the excluded ports of the transport datapath.
+func (e *enforcer) SetExcludedPorts(ports []string, report bool) error {
+	return e.transport.SetExcludedPorts(ports, report)
+}
+
+// UpdateAddressSet sets the address set in the transport datapath.
+func (e *enforcer) UpdateAddressSet(name string, addresses []string) error {
+	return e.transport.UpdateAddressSet(name, addresses)
+}
+
+// RemoveAddressSet removes the address set from the transport datapath.
+func (e *enforcer) RemoveAddressSet(name string) error {
+	return e.transport.RemoveAddressSet(name)
+}
+
 // Updatesecrets updates the secrets of the enforcers
 func (e *enforcer) UpdateSecrets(secrets secrets.Secrets) error {
 	if e.proxy != nil {
@@ -136,6 +314,98 @@ func (e *enforcer) UpdateSecrets(secrets secrets.Secrets) error {
 	return nil
 }
 
+// Ready returns the readiness channel of the transport datapath.
+func (e *enforcer) Ready() <-chan struct{} {
+	return e.transport.Ready()
+}
+
+// Healthy returns the health of the transport datapath.
+func (e *enforcer) Healthy() error {
+	return e.transport.Healthy()
+}
+
+// PersistUDPConnections persists the established UDP connections of the transport datapath.
+func (e *enforcer) PersistUDPConnections(ctx context.Context, path string) error {
+	return e.transport.SetUDPConnectionStore(ctx, nfqdatapath.NewUDPFileStore(path))
+}
+
+// AuditPolicyDecisions audits the policy decisions of the transport datapath.
+func (e *enforcer) AuditPolicyDecisions(ctx context.Context, path string) error {
+
+	sink, err := nfqdatapath.NewAuditFileSink(path)
+	if err != nil {
+		return err
+	}
+
+	e.transport.SetAuditSink(sink)
+
+	go func() {
+		<-ctx.Done()
+		e.transport.SetAuditSink(nil)
+		if err := sink.Close(); err != nil {
+			zap.L().Warn("Unable to close the audit log", zap.String("path", path), zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// EnableReverseDNS enables the reverse DNS lookups of the transport datapath.
+func (e *enforcer) EnableReverseDNS(matchPolicy bool) {
+	e.transport.SetReverseDNS(net.DefaultResolver, matchPolicy)
+}
+
+// EnableServerNameInspection enables the server name inspection of the transport datapath.
+func (e *enforcer) EnableServerNameInspection() {
+	e.transport.SetServerNameInspection(true)
+}
+
+// ExportPUState exports the state of the PU in the transport datapath.
+func (e *enforcer) ExportPUState(contextID string) ([]byte, error) {
+	return e.transport.ExportPUState(contextID)
+}
+
+// ExportConntrackUpdates exports the conntrack mark updates of the transport
+// datapath. The datapath is shared by all the PUs of the enforcer.
+func (e *enforcer) ExportConntrackUpdates(contextID string) ([]byte, error) {
+	return json.Marshal(e.transport.ConntrackUpdates())
+}
+
+// DrainPU drains the PU in the transport datapath.
+func (e *enforcer) DrainPU(contextID string) error {
+	return e.transport.DrainPU(contextID)
+}
+
+// EnableProtocolHelpers enables the protocol helpers of the transport datapath.
+func (e *enforcer) EnableProtocolHelpers(names []string) error {
+	return e.transport.SetProtocolHelpers(names)
+}
+
+// SetImplicitAllowNetworks sets the implicitly allowed networks of the transport datapath.
+func (e *enforcer) SetImplicitAllowNetworks(networks []string) error {
+	return e.transport.SetImplicitAllowNetworks(networks)
+}
+
+// SetMarkResolver sets the mark resolver of the transport datapath.
+func (e *enforcer) SetMarkResolver(r nfqdatapath.MarkResolver) {
+	e.transport.SetMarkResolver(r)
+}
+
+// SetUDPHandshakeLimits sets the limits of half open UDP connections of the transport datapath.
+func (e *enforcer) SetUDPHandshakeLimits(total, perPU int) {
+	e.transport.SetUDPHandshakeLimits(total, perPU)
+}
+
+// SetUDPHandshakeTimeout sets the timeout of the UDP handshakes of the transport datapath.
+func (e *enforcer) SetUDPHandshakeTimeout(timeout time.Duration) {
+	e.transport.SetUDPHandshakeTimeout(timeout)
+}
+
+// SetUDPKeyRotationInterval sets the key rotation interval of the UDP connections of the transport datapath.
+func (e *enforcer) SetUDPKeyRotationInterval(interval time.Duration) {
+	e.transport.SetUDPKeyRotationInterval(interval)
+}
+
 // GetFilterQueue returns the current FilterQueueConfig of the transport path.
 func (e *enforcer) GetFilterQueue() *fqconfig.FilterQueue {
 	return e.transport.GetFilterQueue()
@@ -155,6 +425,7 @@ func New(
 	secrets secrets.Secrets,
 	serverID string,
 	validity time.Duration,
+	clockSkew time.Duration,
 	mode constants.ModeType,
 	procMountPoint string,
 	externalIPCacheTimeout time.Duration,
@@ -162,7 +433,7 @@ func New(
 	targetNetworks []string,
 ) (Enforcer, error) {
 
-	tokenAccessor, err := tokenaccessor.New(serverID, validity, secrets)
+	tokenAccessor, err := tokenaccessor.New(serverID, validity, clockSkew, secrets)
 	if err != nil {
 		zap.L().Fatal("Cannot create a token engine")
 	}
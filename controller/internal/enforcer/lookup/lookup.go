@@ -2,7 +2,10 @@ package lookup
 
 import (
 	"fmt"
+	"regexp"
 	"sort"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -11,10 +14,42 @@ import (
 
 // ForwardingPolicy is an instance of the forwarding policy
 type ForwardingPolicy struct {
-	tags    []policy.KeyValueOperator
-	count   int
-	index   int
-	actions interface{}
+	tags     []policy.KeyValueOperator
+	count    int
+	index    int
+	priority int
+	actions  interface{}
+	window   *policy.TimeWindow
+	location *time.Location
+}
+
+// timeNow returns the time the policies with a time window are evaluated at.
+// It is a variable so that tests can change the time.
+var timeNow = time.Now
+
+// ConflictResolution defines how Search chooses between policies with
+// conflicting actions that match the same tags.
+type ConflictResolution int
+
+const (
+	// FirstMatch returns the first matching policy in priority order.
+	FirstMatch ConflictResolution = iota
+	// DenyWins returns the first matching reject policy in priority order,
+	// even if accept policies were added before it.
+	DenyWins
+	// AllowWins returns the first matching accept policy in priority order,
+	// even if reject policies were added before it.
+	AllowWins
+)
+
+// maxPatternLength is the maximum length of the patterns of the Matches
+// operator.
+const maxPatternLength = 256
+
+// regexPolicy is a policy with a Matches clause on a key.
+type regexPolicy struct {
+	patterns []*regexp.Regexp
+	policy   *ForwardingPolicy
 }
 
 // intList is a list of integeres
@@ -29,7 +64,14 @@ type PolicyDB struct {
 	equalIDMapTable        map[string][]*ForwardingPolicy
 	notEqualMapTable       map[string]map[string][]*ForwardingPolicy
 	notStarTable           map[string][]*ForwardingPolicy
+	regexTable             map[string][]*regexPolicy
+	withinMapTable         map[string]map[string][]*ForwardingPolicy
 	defaultNotExistsPolicy *ForwardingPolicy
+	selectorIDs            map[int]string
+	selectors              policy.TagSelectorList
+	prioritized            bool
+	windowed               bool
+	conflictResolution     ConflictResolution
 }
 
 //NewPolicyDB creates a new PolicyDB for efficient search of policies
@@ -42,12 +84,27 @@ func NewPolicyDB() (m *PolicyDB) {
 		equalIDMapTable:        map[string][]*ForwardingPolicy{},
 		notEqualMapTable:       map[string]map[string][]*ForwardingPolicy{},
 		notStarTable:           map[string][]*ForwardingPolicy{},
+		regexTable:             map[string][]*regexPolicy{},
+		withinMapTable:         map[string]map[string][]*ForwardingPolicy{},
 		defaultNotExistsPolicy: nil,
+		selectorIDs:            map[int]string{},
+		conflictResolution:     FirstMatch,
 	}
 
 	return m
 }
 
+// SetConflictResolution sets how Search chooses between matching policies
+// with conflicting actions. Only the policies that are *policy.FlowPolicy
+// are considered as rejects or accepts. Observed policies never override
+// other policies, since they do not enforce their action: they are only
+// returned when no enforced policy with the winning action matches, in
+// which case the first match is returned.
+func (m *PolicyDB) SetConflictResolution(mode ConflictResolution) {
+
+	m.conflictResolution = mode
+}
+
 func (array intList) sortedInsert(value int) intList {
 	l := len(array)
 	if l == 0 {
@@ -80,9 +137,27 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 
 	// Create a new policy object
 	e := ForwardingPolicy{
-		count:   0,
-		tags:    selector.Clause,
-		actions: selector.Policy,
+		count:    0,
+		tags:     selector.Clause,
+		priority: selector.Priority,
+		actions:  selector.Policy,
+	}
+
+	// As soon as a selector has a priority, the search must look at all
+	// the matching policies instead of returning the first one.
+	if selector.Priority != 0 {
+		m.prioritized = true
+	}
+
+	// A policy with an invalid time window never matches.
+	if selector.Window != nil {
+		m.windowed = true
+		e.window = selector.Window
+		loc, err := selector.Window.TimeLocation()
+		if err != nil {
+			zap.L().Error("Invalid time window in tag selector", zap.String("selector", selectorID(selector)), zap.Error(err))
+		}
+		e.location = loc
 	}
 
 	// For each tag of the incoming policy add a mapping between the map tables
@@ -125,6 +200,25 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 			}
 			e.count++
 
+		case policy.Within:
+			if _, ok := m.withinMapTable[keyValueOp.Key]; !ok {
+				m.withinMapTable[keyValueOp.Key] = map[string][]*ForwardingPolicy{}
+			}
+			for _, v := range outermostPaths(keyValueOp.Value) {
+				m.withinMapTable[keyValueOp.Key][v] = append(m.withinMapTable[keyValueOp.Key][v], &e)
+			}
+			e.count++
+
+		case policy.Matches:
+			// A clause with an invalid pattern is counted but never hit, so
+			// that the policy never matches.
+			if patterns, err := compilePatterns(keyValueOp.Value); err != nil {
+				zap.L().Error("Invalid pattern in tag selector", zap.String("key", keyValueOp.Key), zap.Error(err))
+			} else {
+				m.regexTable[keyValueOp.Key] = append(m.regexTable[keyValueOp.Key], &regexPolicy{patterns: patterns, policy: &e})
+			}
+			e.count++
+
 		default: // policy.NotEqual
 			if _, ok := m.notEqualMapTable[keyValueOp.Key]; !ok {
 				m.notEqualMapTable[keyValueOp.Key] = map[string][]*ForwardingPolicy{}
@@ -142,11 +236,117 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 	// Give the policy an index
 	e.index = m.numberOfPolicies
 
+	m.selectorIDs[e.index] = selectorID(selector)
+	m.selectors = append(m.selectors, selector)
+
 	// Return the ID
 	return e.index
 
 }
 
+// cleanPath removes the trailing separators of a path.
+func cleanPath(path string) string {
+
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" || path == "" {
+		return trimmed
+	}
+
+	return "/"
+}
+
+// isWithin returns true if the path is the parent path or a path under it.
+func isWithin(path, parent string) bool {
+
+	return path == parent || parent == "/" || strings.HasPrefix(path, parent+"/")
+}
+
+// outermostPaths returns the cleaned paths of a Within clause without the
+// paths that are under another path of the clause, so that a tag value is
+// within at most one of them and the clause is hit only once.
+func outermostPaths(values []string) []string {
+
+	paths := make([]string, 0, len(values))
+	for _, v := range values {
+		paths = append(paths, cleanPath(v))
+	}
+
+	// Parents sort before the paths under them.
+	sort.Strings(paths)
+
+	outermost := []string{}
+	for _, p := range paths {
+		within := false
+		for _, parent := range outermost {
+			if isWithin(p, parent) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			outermost = append(outermost, p)
+		}
+	}
+
+	return outermost
+}
+
+// compilePatterns compiles the patterns of a Matches clause. The patterns
+// are anchored to match the whole value. Go regular expressions run in
+// linear time and cannot backtrack, but the length of the patterns is
+// limited to bound the cost of the evaluation.
+func compilePatterns(values []string) ([]*regexp.Regexp, error) {
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no pattern")
+	}
+
+	patterns := make([]*regexp.Regexp, 0, len(values))
+	for _, v := range values {
+		if len(v) > maxPatternLength {
+			return nil, fmt.Errorf("pattern is longer than %d characters: %s", maxPatternLength, v)
+		}
+
+		re, err := regexp.Compile("^(?:" + v + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %s", v, err)
+		}
+		patterns = append(patterns, re)
+	}
+
+	return patterns, nil
+}
+
+// SelectorID returns the ID of the selector of the policy returned by Search.
+func (m *PolicyDB) SelectorID(index int) string {
+
+	return m.selectorIDs[index]
+}
+
+// Selectors returns the selectors of the policies in the order they were
+// added. The index of a policy returned by Search is its position plus one.
+func (m *PolicyDB) Selectors() policy.TagSelectorList {
+
+	return append(policy.TagSelectorList{}, m.selectors...)
+}
+
+// selectorID returns the ID of the selector or the IDs of its clause if
+// the selector has no ID.
+func selectorID(selector policy.TagSelector) string {
+
+	if selector.ID != "" {
+		return selector.ID
+	}
+
+	ids := []string{}
+	for _, keyValueOp := range selector.Clause {
+		if keyValueOp.ID != "" {
+			ids = append(ids, keyValueOp.ID)
+		}
+	}
+
+	return strings.Join(ids, ",")
+}
+
 // Custom implementation for splitting strings. Gives significant performance
 // improvement. Do not allocate new strings
 func (m *PolicyDB) tagSplit(tag string, k *string, v *string) error {
@@ -173,9 +373,104 @@ func (m *PolicyDB) tagSplit(tag string, k *string, v *string) error {
 	return fmt.Errorf("Invalid tag: missing equal symbol '%s'", tag)
 }
 
-// Search searches for a set of tags in the database to find a policy match
+// Search searches for a set of tags in the database to find a policy match.
+// If the selectors have priorities, the matching policy with the highest
+// priority is returned, and the first inserted one among equal priorities.
+// In the DenyWins and AllowWins modes, the policy is chosen among all the
+// matching policies as described by ConflictResolution.
 func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 
+	if m.conflictResolution != FirstMatch {
+		return m.resolveConflicts(m.searchAll(tags))
+	}
+
+	var best *ForwardingPolicy
+
+	m.search(tags, func(p *ForwardingPolicy) bool {
+		if best == nil || p.before(best) {
+			best = p
+		}
+		return !m.prioritized
+	})
+
+	if best != nil {
+		return best.index, best.actions
+	}
+
+	return -1, nil
+}
+
+// SearchAll returns the indexes and actions of all the policies that match
+// the tags, ordered by priority and then by insertion.
+func (m *PolicyDB) SearchAll(tags *policy.TagStore) ([]int, []interface{}) {
+
+	matches := m.searchAll(tags)
+
+	indexes := make([]int, len(matches))
+	actions := make([]interface{}, len(matches))
+	for i, p := range matches {
+		indexes[i] = p.index
+		actions[i] = p.actions
+	}
+
+	return indexes, actions
+}
+
+// searchAll returns all the policies that match the tags, ordered by
+// priority and then by insertion.
+func (m *PolicyDB) searchAll(tags *policy.TagStore) []*ForwardingPolicy {
+
+	matches := []*ForwardingPolicy{}
+
+	m.search(tags, func(p *ForwardingPolicy) bool {
+		matches = append(matches, p)
+		return false
+	})
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].before(matches[j])
+	})
+
+	return matches
+}
+
+// resolveConflicts returns the policy that wins among the ordered matches
+// according to the conflict resolution mode of the database.
+func (m *PolicyDB) resolveConflicts(matches []*ForwardingPolicy) (int, interface{}) {
+
+	if len(matches) == 0 {
+		return -1, nil
+	}
+
+	for _, p := range matches {
+		plc, ok := p.actions.(*policy.FlowPolicy)
+		if !ok || plc.ObserveAction.Observed() {
+			continue
+		}
+		if m.conflictResolution == DenyWins && plc.Action.Rejected() {
+			return p.index, p.actions
+		}
+		if m.conflictResolution == AllowWins && plc.Action.Accepted() {
+			return p.index, p.actions
+		}
+	}
+
+	return matches[0].index, matches[0].actions
+}
+
+// search calls found for every policy that matches the tags, until found
+// returns true. The policies with a time window are only found while the
+// current time is in their window.
+func (m *PolicyDB) search(tags *policy.TagStore, found func(*ForwardingPolicy) bool) {
+
+	if m.windowed {
+		now := timeNow()
+		inWindow := found
+		found = func(p *ForwardingPolicy) bool {
+			return p.active(now) && inWindow(p)
+		}
+	}
+
 	count := make([]int, m.numberOfPolicies+1)
 
 	skip := make([]bool, m.numberOfPolicies+1)
@@ -197,8 +492,8 @@ func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 	for _, t := range copiedTags {
 
 		// Search for matches of t (tag id)
-		if index, action := searchInMapTable(m.equalIDMapTable[t], count, skip); index >= 0 {
-			return index, action
+		if searchInMapTable(m.equalIDMapTable[t], count, skip, found) {
+			return
 		}
 
 		if err := m.tagSplit(t, &k, &v); err != nil {
@@ -206,15 +501,15 @@ func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 		}
 
 		// Search for matches of k=v
-		if index, action := searchInMapTable(m.equalMapTable[k][v], count, skip); index >= 0 {
-			return index, action
+		if searchInMapTable(m.equalMapTable[k][v], count, skip, found) {
+			return
 		}
 
 		// Search for matches in prefixes
 		for _, i := range m.equalPrefixes[k] {
 			if i <= len(v) {
-				if index, action := searchInMapTable(m.equalMapTable[k][v[:i]], count, skip); index >= 0 {
-					return index, action
+				if searchInMapTable(m.equalMapTable[k][v[:i]], count, skip, found) {
+					return
 				}
 			}
 		}
@@ -226,38 +521,99 @@ func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 				continue
 			}
 
-			if index, action := searchInMapTable(policies, count, skip); index >= 0 {
-				return index, action
+			if searchInMapTable(policies, count, skip, found) {
+				return
+			}
+		}
+
+		// Search for the value and its parent paths in the path hierarchies
+		if paths, ok := m.withinMapTable[k]; ok {
+			p := cleanPath(v)
+			for {
+				if searchInMapTable(paths[p], count, skip, found) {
+					return
+				}
+
+				i := strings.LastIndex(p, "/")
+				if i < 0 || p == "/" {
+					break
+				}
+				if i == 0 {
+					p = "/"
+				} else {
+					p = p[:i]
+				}
+			}
+		}
+
+		// Evaluate the regular expressions last, since they can't be looked up
+		for _, rp := range m.regexTable[k] {
+			for _, re := range rp.patterns {
+				if re.MatchString(v) {
+					if hit(rp.policy, count, skip, found) {
+						return
+					}
+					break
+				}
 			}
 		}
 	}
 
 	if m.defaultNotExistsPolicy != nil && !skip[m.defaultNotExistsPolicy.index] {
-		return m.defaultNotExistsPolicy.index, m.defaultNotExistsPolicy.actions
+		found(m.defaultNotExistsPolicy)
 	}
+}
 
-	return -1, nil
+// active returns true if the policy applies at the given time.
+func (p *ForwardingPolicy) active(now time.Time) bool {
+
+	if p.window == nil {
+		return true
+	}
+
+	return p.location != nil && p.window.Contains(now, p.location)
 }
 
-func searchInMapTable(table []*ForwardingPolicy, count []int, skip []bool) (int, interface{}) {
-	for _, policy := range table {
+// before returns true if the policy takes precedence over the other policy.
+func (p *ForwardingPolicy) before(other *ForwardingPolicy) bool {
 
-		// Skip the policy if we have marked it
-		if skip[policy.index] {
-			continue
-		}
+	if p.priority != other.priority {
+		return p.priority > other.priority
+	}
 
-		// Since a policy is hit, the count of remaining tags is reduced by one
-		count[policy.index]++
+	return p.index < other.index
+}
 
-		// If all tags of the policy have been hit, there is a match
-		if count[policy.index] == policy.count {
-			return policy.index, policy.actions
+// searchInMapTable calls found for the policies of the table that match, and
+// returns true as soon as found returns true.
+func searchInMapTable(table []*ForwardingPolicy, count []int, skip []bool, found func(*ForwardingPolicy) bool) bool {
+	for _, policy := range table {
+		if hit(policy, count, skip, found) {
+			return true
 		}
+	}
+
+	return false
+}
 
+// hit counts a hit of a tag of the policy and calls found if all the tags of
+// the policy have been hit. It returns true if found returns true.
+func hit(policy *ForwardingPolicy, count []int, skip []bool, found func(*ForwardingPolicy) bool) bool {
+
+	// Skip the policy if we have marked it
+	if skip[policy.index] {
+		return false
 	}
 
-	return -1, nil
+	// Since a policy is hit, the count of remaining tags is reduced by one
+	count[policy.index]++
+
+	// If all tags of the policy have been hit, there is a match
+	if count[policy.index] == policy.count {
+		return found(policy)
+	}
+
+	return false
 }
 
 // PrintPolicyDB is a debugging function to dump the map
@@ -298,4 +654,16 @@ func (m *PolicyDB) PrintPolicyDB() {
 		}
 	}
 
+	zap.L().Debug("Print Policy DB - regex table")
+
+	for key, values := range m.regexTable {
+		for _, rp := range values {
+			zap.L().Debug("Print Policy DB",
+				zap.String("policies", fmt.Sprintf("%#v", rp.policy)),
+				zap.String("key", key),
+				zap.String("patterns", fmt.Sprintf("%v", rp.patterns)),
+			)
+		}
+	}
+
 }
@@ -1,7 +1,10 @@
 package lookup
 
 import (
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"go.aporeto.io/trireme-lib/policy"
 
@@ -413,6 +416,455 @@ func TestFuncSearch(t *testing.T) {
 	})
 }
 
+// TestFuncSelectorID tests that the ID of the winning selector is reported
+func TestFuncSelectorID(t *testing.T) {
+
+	Convey("Given a policy DB with selectors with and without IDs", t, func() {
+		policyDB := NewPolicyDB()
+
+		webDemo := appEqWebAndenvEqDemo
+		webDemo.ID = "web-demo"
+		vuln := vulnTagPolicy
+		vuln.ID = "vuln"
+
+		index1 := policyDB.AddPolicy(webDemo)
+		index2 := policyDB.AddPolicy(policylangNotJava)
+		index3 := policyDB.AddPolicy(vuln)
+		index4 := policyDB.AddPolicy(appEqWebAndEnvEqDemoOrQa)
+
+		Convey("The ID of the matched selector should be reported", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("env", "demo")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, index1)
+			So(policyDB.SelectorID(index), ShouldEqual, "web-demo")
+		})
+
+		Convey("The ID of the selector that wins over other matching selectors should be reported", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("vulnerability", "high")
+			tags.AppendKeyValue("lang", "go")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, index3)
+			So(policyDB.SelectorID(index), ShouldEqual, "vuln")
+		})
+
+		Convey("A selector without an ID should be reported with the IDs of its clause", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("env", "qa")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, index4)
+			So(policyDB.SelectorID(index), ShouldEqual, "1,3")
+		})
+
+		Convey("A selector without IDs should be reported with an empty ID", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("lang", "go")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, index2)
+			So(policyDB.SelectorID(index), ShouldBeEmpty)
+		})
+
+		Convey("No ID should be reported if there is no match", func() {
+			So(policyDB.SelectorID(-1), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestFuncSearchWithPriorities(t *testing.T) {
+
+	Convey("Given a policy DB with an allow selector added before a deny selector", t, func() {
+		policyDB := NewPolicyDB()
+
+		allow := policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		}
+		deny := policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb, envEqDemo},
+			Policy: &policy.FlowPolicy{Action: policy.Reject},
+		}
+
+		tags := policy.NewTagStore()
+		tags.AppendKeyValue("app", "web")
+		tags.AppendKeyValue("env", "demo")
+
+		Convey("When the priorities are equal, the first inserted selector should match", func() {
+			allowIndex := policyDB.AddPolicy(allow)
+			policyDB.AddPolicy(deny)
+
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, allowIndex)
+			So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Accept)
+		})
+
+		Convey("When the deny selector has a higher priority, it should match", func() {
+			deny.Priority = 10
+			policyDB.AddPolicy(allow)
+			denyIndex := policyDB.AddPolicy(deny)
+
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, denyIndex)
+			So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Reject)
+
+			Convey("Tags that only match the allow selector should still match it", func() {
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("app", "web")
+
+				_, action := policyDB.Search(tags)
+				So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Accept)
+			})
+		})
+
+		Convey("When selectors with a priority have equal priorities, the first inserted one should match", func() {
+			allow.Priority = 5
+			deny.Priority = 5
+			allowIndex := policyDB.AddPolicy(allow)
+			policyDB.AddPolicy(deny)
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, allowIndex)
+		})
+
+		Convey("When a key exists selector has a higher priority, it should match", func() {
+			dcExists := policy.TagSelector{
+				Clause:   []policy.KeyValueOperator{dcKeyExists},
+				Policy:   &policy.FlowPolicy{Action: policy.Reject},
+				Priority: 1,
+			}
+			policyDB.AddPolicy(allow)
+			dcExistsIndex := policyDB.AddPolicy(dcExists)
+
+			tags.AppendKeyValue("dc", "east")
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, dcExistsIndex)
+		})
+
+		Convey("When no selector matches, nothing should be returned", func() {
+			deny.Priority = 10
+			policyDB.AddPolicy(deny)
+
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "db")
+
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, -1)
+			So(action, ShouldBeNil)
+		})
+	})
+}
+
+func TestFuncSearchAll(t *testing.T) {
+
+	Convey("Given a policy DB with several selectors", t, func() {
+		policyDB := NewPolicyDB()
+
+		webDemo := appEqWebAndenvEqDemo
+		webDemo.Priority = 1
+
+		index1 := policyDB.AddPolicy(policylangNotJava)
+		index2 := policyDB.AddPolicy(appEqWebAndEnvEqDemoOrQa)
+		index3 := policyDB.AddPolicy(webDemo)
+
+		Convey("All the matching policies should be returned by priority and insertion order", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("env", "demo")
+			tags.AppendKeyValue("lang", "go")
+
+			indexes, actions := policyDB.SearchAll(tags)
+			So(indexes, ShouldResemble, []int{index3, index1, index2})
+			So(len(actions), ShouldEqual, 3)
+		})
+
+		Convey("No policy should be returned if there is no match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("lang", "java")
+
+			indexes, actions := policyDB.SearchAll(tags)
+			So(indexes, ShouldBeEmpty)
+			So(actions, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestFuncSearchWithConflictResolution(t *testing.T) {
+
+	Convey("Given a policy DB with an accept selector added before conflicting selectors", t, func() {
+		policyDB := NewPolicyDB()
+
+		acceptIndex := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+		observedRejectIndex := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{envEqDemo},
+			Policy: &policy.FlowPolicy{Action: policy.Reject, ObserveAction: policy.ObserveContinue},
+		})
+		rejectIndex := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb, envEqDemo},
+			Policy: &policy.FlowPolicy{Action: policy.Reject},
+		})
+
+		tags := policy.NewTagStore()
+		tags.AppendKeyValue("app", "web")
+		tags.AppendKeyValue("env", "demo")
+
+		Convey("In FirstMatch mode, the first inserted selector should match", func() {
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, acceptIndex)
+		})
+
+		Convey("In DenyWins mode, the reject selector should match", func() {
+			policyDB.SetConflictResolution(DenyWins)
+
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, rejectIndex)
+			So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Reject)
+
+			Convey("Tags that only match the accept selector should still match it", func() {
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("app", "web")
+
+				index, _ := policyDB.Search(tags)
+				So(index, ShouldEqual, acceptIndex)
+			})
+
+			Convey("An observed reject should not override an accept", func() {
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("app", "web")
+				tags.AppendKeyValue("env", "demo")
+
+				policyDB := NewPolicyDB()
+				policyDB.SetConflictResolution(DenyWins)
+				acceptIndex := policyDB.AddPolicy(policy.TagSelector{
+					Clause: []policy.KeyValueOperator{appEqWeb},
+					Policy: &policy.FlowPolicy{Action: policy.Accept},
+				})
+				policyDB.AddPolicy(policy.TagSelector{
+					Clause: []policy.KeyValueOperator{envEqDemo},
+					Policy: &policy.FlowPolicy{Action: policy.Reject, ObserveAction: policy.ObserveContinue},
+				})
+
+				index, _ := policyDB.Search(tags)
+				So(index, ShouldEqual, acceptIndex)
+			})
+		})
+
+		Convey("In AllowWins mode, the accept selector should match", func() {
+			policyDB.SetConflictResolution(AllowWins)
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, acceptIndex)
+
+			Convey("Tags that only match reject selectors should match the first of them", func() {
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("env", "demo")
+
+				index, _ := policyDB.Search(tags)
+				So(index, ShouldEqual, observedRejectIndex)
+			})
+
+			Convey("No policy should be returned if there is no match", func() {
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("app", "db")
+
+				index, action := policyDB.Search(tags)
+				So(index, ShouldEqual, -1)
+				So(action, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestFuncSearchWithPatterns(t *testing.T) {
+
+	Convey("Given a policy DB with selectors using patterns", t, func() {
+		policyDB := NewPolicyDB()
+
+		versionMatches := policy.KeyValueOperator{
+			Key:      "version",
+			Value:    []string{`1\.[0-9]+\.[0-9]+`, "2.0"},
+			Operator: policy.Matches,
+		}
+
+		index1 := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb, versionMatches},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		Convey("Tags with a value matching a pattern should match", func() {
+			for _, version := range []string{"1.2.3", "1.10.0", "2.0"} {
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("app", "web")
+				tags.AppendKeyValue("version", version)
+
+				index, _ := policyDB.Search(tags)
+				So(index, ShouldEqual, index1)
+			}
+		})
+
+		Convey("Patterns should match the whole value", func() {
+			for _, version := range []string{"1.2", "21.2.3", "1.2.3-rc1", "2.01"} {
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("app", "web")
+				tags.AppendKeyValue("version", version)
+
+				index, _ := policyDB.Search(tags)
+				So(index, ShouldEqual, -1)
+			}
+		})
+
+		Convey("Tags that only match the pattern should not match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("version", "1.2.3")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("Selectors with invalid patterns should never match", func() {
+			for _, pattern := range []string{"1.(", strings.Repeat("a", maxPatternLength+1)} {
+				policyDB := NewPolicyDB()
+				policyDB.AddPolicy(policy.TagSelector{
+					Clause: []policy.KeyValueOperator{{Key: "version", Value: []string{pattern}, Operator: policy.Matches}},
+					Policy: &policy.FlowPolicy{Action: policy.Accept},
+				})
+
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("version", pattern)
+
+				index, _ := policyDB.Search(tags)
+				So(index, ShouldEqual, -1)
+			}
+		})
+	})
+}
+
+func TestFuncSearchWithinPaths(t *testing.T) {
+
+	Convey("Given a policy DB with a selector on a namespace hierarchy", t, func() {
+		policyDB := NewPolicyDB()
+
+		index1 := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				appEqWeb,
+				{Key: "namespace", Value: []string{"/a/b", "/a/b/c", "/x/"}, Operator: policy.Within},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		search := func(namespace string) int {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("namespace", namespace)
+
+			index, _ := policyDB.Search(tags)
+			return index
+		}
+
+		Convey("The path and the paths under it should match", func() {
+			So(search("/a/b"), ShouldEqual, index1)
+			So(search("/a/b/"), ShouldEqual, index1)
+			So(search("/a/b/c"), ShouldEqual, index1)
+			So(search("/a/b/c/d"), ShouldEqual, index1)
+			So(search("/x/y"), ShouldEqual, index1)
+		})
+
+		Convey("Paths that only share a prefix should not match", func() {
+			So(search("/a/bc"), ShouldEqual, -1)
+			So(search("/a"), ShouldEqual, -1)
+			So(search("/"), ShouldEqual, -1)
+			So(search("/xy"), ShouldEqual, -1)
+		})
+
+		Convey("A namespace under nested paths of the selector should not match without the other tags", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("namespace", "/a/b/c/d")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("A selector on the root path should match all the paths", func() {
+			index2 := policyDB.AddPolicy(policy.TagSelector{
+				Clause: []policy.KeyValueOperator{{Key: "namespace", Value: []string{"/"}, Operator: policy.Within}},
+				Policy: &policy.FlowPolicy{Action: policy.Accept},
+			})
+
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("namespace", "/z")
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, index2)
+		})
+	})
+}
+
+func TestOutermostPaths(t *testing.T) {
+
+	Convey("Nested and duplicate paths should be removed", t, func() {
+		So(outermostPaths([]string{"/a/b/c", "/a/b-x", "/a/b/", "/a/b", "/d"}), ShouldResemble, []string{"/a/b", "/a/b-x", "/d"})
+		So(outermostPaths([]string{"/a", "/"}), ShouldResemble, []string{"/"})
+	})
+}
+
+func benchmarkPolicyDB(withPatterns bool) *PolicyDB {
+
+	policyDB := NewPolicyDB()
+
+	for i := 0; i < 100; i++ {
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "app", Value: []string{"app" + strconv.Itoa(i)}, Operator: policy.Equal},
+				envEqDemo,
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+		if withPatterns {
+			policyDB.AddPolicy(policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{Key: "version", Value: []string{"1\\." + strconv.Itoa(i) + "\\.[0-9]+"}, Operator: policy.Matches},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept},
+			})
+		}
+	}
+
+	return policyDB
+}
+
+func benchmarkSearch(b *testing.B, withPatterns bool) {
+
+	policyDB := benchmarkPolicyDB(withPatterns)
+
+	tags := policy.NewTagStore()
+	tags.AppendKeyValue("app", "app99")
+	tags.AppendKeyValue("env", "demo")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		policyDB.Search(tags)
+	}
+}
+
+// BenchmarkSearch measures the search of equal tags.
+func BenchmarkSearch(b *testing.B) {
+	benchmarkSearch(b, false)
+}
+
+// BenchmarkSearchWithPatterns measures the search of equal tags when the
+// database also has patterns on other keys, which must not slow it down.
+func BenchmarkSearchWithPatterns(b *testing.B) {
+	benchmarkSearch(b, true)
+}
+
 // TestFuncDumbDB is a mock test for the print function
 func TestFuncDumpDB(t *testing.T) {
 	Convey("Given an empty policy DB", t, func() {
@@ -429,3 +881,161 @@ func TestFuncDumpDB(t *testing.T) {
 		})
 	})
 }
+
+func TestFuncSelectors(t *testing.T) {
+	Convey("Given an empty policy DB", t, func() {
+		policyDB := NewPolicyDB()
+
+		Convey("It should have no selectors", func() {
+			So(policyDB.Selectors(), ShouldBeEmpty)
+		})
+
+		Convey("Given that I add two policy rules, I should get their selectors by index", func() {
+			index1 := policyDB.AddPolicy(appEqWebAndenvEqDemo)
+			index2 := policyDB.AddPolicy(policylangNotJava)
+
+			selectors := policyDB.Selectors()
+			So(selectors, ShouldResemble, policy.TagSelectorList{appEqWebAndenvEqDemo, policylangNotJava})
+			So(selectors[index1-1], ShouldResemble, appEqWebAndenvEqDemo)
+			So(selectors[index2-1], ShouldResemble, policylangNotJava)
+
+			Convey("The selectors should not change when the list is modified", func() {
+				selectors[0] = policylangNotJava
+				So(policyDB.Selectors()[0], ShouldResemble, appEqWebAndenvEqDemo)
+			})
+		})
+	})
+}
+
+func TestFuncSearchExecutable(t *testing.T) {
+
+	Convey("Given a policy DB with selectors on executables", t, func() {
+		policyDB := NewPolicyDB()
+
+		curl := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: policy.ExecutableTag, Value: []string{"/usr/bin/curl"}, Operator: policy.Equal},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+		local := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: policy.ExecutableTag, Value: []string{"/usr/local/bin"}, Operator: policy.Within},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		search := func(executable string) int {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			if executable != "" {
+				tags.AppendKeyValue(policy.ExecutableTag, executable)
+			}
+
+			index, _ := policyDB.Search(tags)
+			return index
+		}
+
+		Convey("The flows of the executable should match its selector", func() {
+			So(search("/usr/bin/curl"), ShouldEqual, curl)
+			So(search("/usr/local/bin/tool"), ShouldEqual, local)
+		})
+
+		Convey("The flows of other executables should not match", func() {
+			So(search("/usr/bin/wget"), ShouldEqual, -1)
+			So(search("/usr/bin/curl2"), ShouldEqual, -1)
+			So(search("/tmp/usr/bin/curl"), ShouldEqual, -1)
+			So(search("/usr/local/binary"), ShouldEqual, -1)
+			So(search(""), ShouldEqual, -1)
+		})
+	})
+}
+
+func TestFuncSearchWithTimeWindow(t *testing.T) {
+
+	Convey("Given a policy DB with an allow selector during a maintenance window before a deny selector", t, func() {
+		policyDB := NewPolicyDB()
+
+		prevTimeNow := timeNow
+		defer func() {
+			timeNow = prevTimeNow
+		}()
+
+		maintenance := policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+			Window: &policy.TimeWindow{
+				Days:     []time.Weekday{time.Saturday},
+				Start:    22 * time.Hour,
+				End:      2 * time.Hour,
+				Location: "Europe/Paris",
+			},
+		}
+		deny := policy.TagSelector{
+			Clause: []policy.KeyValueOperator{appEqWeb},
+			Policy: &policy.FlowPolicy{Action: policy.Reject},
+		}
+
+		tags := policy.NewTagStore()
+		tags.AppendKeyValue("app", "web")
+
+		paris, err := time.LoadLocation("Europe/Paris")
+		So(err, ShouldBeNil)
+
+		Convey("When the connection is established in the window, the allow selector should match", func() {
+			maintenanceIndex := policyDB.AddPolicy(maintenance)
+			policyDB.AddPolicy(deny)
+
+			// Sunday 01:30 in Paris is in the window that started on Saturday.
+			timeNow = func() time.Time { return time.Date(2020, 6, 7, 1, 30, 0, 0, paris) }
+
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, maintenanceIndex)
+			So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Accept)
+
+			Convey("The window should be evaluated in its time zone", func() {
+				timeNow = func() time.Time { return time.Date(2020, 6, 6, 20, 30, 0, 0, time.UTC) }
+
+				index, _ := policyDB.Search(tags)
+				So(index, ShouldEqual, maintenanceIndex)
+			})
+		})
+
+		Convey("When the connection is established outside the window, the next selector should match", func() {
+			policyDB.AddPolicy(maintenance)
+			denyIndex := policyDB.AddPolicy(deny)
+
+			for _, now := range []time.Time{
+				time.Date(2020, 6, 6, 21, 59, 0, 0, paris),
+				time.Date(2020, 6, 7, 2, 0, 0, 0, paris),
+				time.Date(2020, 6, 5, 23, 0, 0, 0, paris),
+			} {
+				now := now
+				timeNow = func() time.Time { return now }
+
+				index, action := policyDB.Search(tags)
+				So(index, ShouldEqual, denyIndex)
+				So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Reject)
+			}
+		})
+
+		Convey("When the window is the only selector, nothing should match outside it", func() {
+			policyDB.AddPolicy(maintenance)
+			timeNow = func() time.Time { return time.Date(2020, 6, 8, 12, 0, 0, 0, paris) }
+
+			index, action := policyDB.Search(tags)
+			So(index, ShouldEqual, -1)
+			So(action, ShouldBeNil)
+		})
+
+		Convey("When the time zone of the window is invalid, the selector should never match", func() {
+			maintenance.Window.Location = "Nowhere/Invalid"
+			policyDB.AddPolicy(maintenance)
+			denyIndex := policyDB.AddPolicy(deny)
+			timeNow = func() time.Time { return time.Date(2020, 6, 7, 1, 30, 0, 0, paris) }
+
+			index, _ := policyDB.Search(tags)
+			So(index, ShouldEqual, denyIndex)
+		})
+	})
+}
@@ -139,3 +139,73 @@ func (m *MockEnforcer) SetTargetNetworks(networks []string) error {
 func (mr *MockEnforcerMockRecorder) SetTargetNetworks(networks interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTargetNetworks", reflect.TypeOf((*MockEnforcer)(nil).SetTargetNetworks), networks)
 }
+
+// SetExcludedPorts mocks base method
+// nolint
+func (m *MockEnforcer) SetExcludedPorts(ports []string, report bool) error {
+	ret := m.ctrl.Call(m, "SetExcludedPorts", ports, report)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetExcludedPorts indicates an expected call of SetExcludedPorts
+// nolint
+func (mr *MockEnforcerMockRecorder) SetExcludedPorts(ports, report interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetExcludedPorts", reflect.TypeOf((*MockEnforcer)(nil).SetExcludedPorts), ports, report)
+}
+
+// UpdateAddressSet mocks base method
+// nolint
+func (m *MockEnforcer) UpdateAddressSet(name string, addresses []string) error {
+	ret := m.ctrl.Call(m, "UpdateAddressSet", name, addresses)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateAddressSet indicates an expected call of UpdateAddressSet
+// nolint
+func (mr *MockEnforcerMockRecorder) UpdateAddressSet(name, addresses interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAddressSet", reflect.TypeOf((*MockEnforcer)(nil).UpdateAddressSet), name, addresses)
+}
+
+// RemoveAddressSet mocks base method
+// nolint
+func (m *MockEnforcer) RemoveAddressSet(name string) error {
+	ret := m.ctrl.Call(m, "RemoveAddressSet", name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveAddressSet indicates an expected call of RemoveAddressSet
+// nolint
+func (mr *MockEnforcerMockRecorder) RemoveAddressSet(name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveAddressSet", reflect.TypeOf((*MockEnforcer)(nil).RemoveAddressSet), name)
+}
+
+// Ready mocks base method
+// nolint
+func (m *MockEnforcer) Ready() <-chan struct{} {
+	ret := m.ctrl.Call(m, "Ready")
+	ret0, _ := ret[0].(<-chan struct{})
+	return ret0
+}
+
+// Ready indicates an expected call of Ready
+// nolint
+func (mr *MockEnforcerMockRecorder) Ready() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ready", reflect.TypeOf((*MockEnforcer)(nil).Ready))
+}
+
+// Healthy mocks base method
+// nolint
+func (m *MockEnforcer) Healthy() error {
+	ret := m.ctrl.Call(m, "Healthy")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Healthy indicates an expected call of Healthy
+// nolint
+func (mr *MockEnforcerMockRecorder) Healthy() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Healthy", reflect.TypeOf((*MockEnforcer)(nil).Healthy))
+}
@@ -0,0 +1,65 @@
+package nfqdatapath
+
+import (
+	"errors"
+
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/acls"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+)
+
+// UpdateAddressSet sets the addresses of an address set of the ACLs of all
+// the PUs. The addresses are IPv4 addresses or networks. The PUs enforced
+// later get them too.
+func (d *Datapath) UpdateAddressSet(name string, addresses []string) error {
+
+	if name == "" {
+		return errors.New("address set name cannot be empty")
+	}
+
+	// The addresses are validated before any PU is changed.
+	if err := acls.NewACLCache().UpdateAddressSet(name, addresses); err != nil {
+		return err
+	}
+
+	d.addressSetsLock.Lock()
+	defer d.addressSetsLock.Unlock()
+
+	if d.addressSets == nil {
+		d.addressSets = map[string][]string{}
+	}
+	d.addressSets[name] = addresses
+
+	for _, contextID := range d.puFromContextID.KeyList() {
+		data, err := d.puFromContextID.Get(contextID)
+		if err != nil {
+			continue
+		}
+
+		if err := data.(*pucontext.PUContext).UpdateAddressSet(name, addresses); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveAddressSet removes an address set of the ACLs of all the PUs. The
+// rules that reference it no longer match any address.
+func (d *Datapath) RemoveAddressSet(name string) error {
+
+	d.addressSetsLock.Lock()
+	defer d.addressSetsLock.Unlock()
+
+	delete(d.addressSets, name)
+
+	for _, contextID := range d.puFromContextID.KeyList() {
+		data, err := d.puFromContextID.Get(contextID)
+		if err != nil {
+			continue
+		}
+
+		data.(*pucontext.PUContext).RemoveAddressSet(name)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package nfqdatapath
+
+import (
+	"net"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func addressSetTestPUInfo(contextID string) *policy.PUInfo {
+
+	appACLs := policy.IPRuleList{
+		{
+			AddressSet: "feed",
+			Protocol:   policy.AnyProtocol,
+			Policy:     &policy.FlowPolicy{Action: policy.Reject, PolicyID: "feed"},
+		},
+	}
+	plc := policy.NewPUPolicy(contextID, policy.Police, appACLs, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+
+	return policy.PUInfoFromPolicyAndRuntime(contextID, plc, policy.NewPURuntimeWithDefaults())
+}
+
+func TestAddressSets(t *testing.T) {
+
+	Convey("Given I have an enforcer with a PU whose ACLs reference an address set", t, func() {
+		enforcer := newFailureTestEnforcer(&collector.DefaultCollector{}, constants.RemoteContainer)
+		So(enforcer.Enforce("pu1", addressSetTestPUInfo("pu1")), ShouldBeNil)
+
+		matches := func(contextID string, address string) bool {
+			item, err := enforcer.puFromContextID.Get(contextID)
+			So(err, ShouldBeNil)
+			_, action, err := item.(*pucontext.PUContext).ApplicationACLPolicyFromAddr(net.ParseIP(address).To4(), 443, 5000)
+			return err == nil && action.PolicyID == "feed"
+		}
+
+		Convey("When the address set is not set, the ACL should match no address", func() {
+			So(matches("pu1", "10.1.1.1"), ShouldBeFalse)
+		})
+
+		Convey("When I update the address set", func() {
+			So(enforcer.UpdateAddressSet("feed", []string{"10.1.1.0/24", "192.168.1.1"}), ShouldBeNil)
+
+			Convey("Then the ACL of the PU should match its addresses", func() {
+				So(matches("pu1", "10.1.1.1"), ShouldBeTrue)
+				So(matches("pu1", "192.168.1.1"), ShouldBeTrue)
+				So(matches("pu1", "10.1.2.1"), ShouldBeFalse)
+			})
+
+			Convey("Then a PU enforced later should get its addresses", func() {
+				So(enforcer.Enforce("pu2", addressSetTestPUInfo("pu2")), ShouldBeNil)
+				So(matches("pu2", "10.1.1.1"), ShouldBeTrue)
+			})
+
+			Convey("Then a policy update of the PU should keep its addresses", func() {
+				So(enforcer.Enforce("pu1", addressSetTestPUInfo("pu1")), ShouldBeNil)
+				So(matches("pu1", "10.1.1.1"), ShouldBeTrue)
+			})
+
+			Convey("When I remove the address set, the ACLs should match no address", func() {
+				So(enforcer.RemoveAddressSet("feed"), ShouldBeNil)
+				So(matches("pu1", "10.1.1.1"), ShouldBeFalse)
+
+				So(enforcer.Enforce("pu2", addressSetTestPUInfo("pu2")), ShouldBeNil)
+				So(matches("pu2", "10.1.1.1"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When I update the address set with an invalid address, I should get an error and the PU should not change", func() {
+			So(enforcer.UpdateAddressSet("feed", []string{"10.1.1.0/24"}), ShouldBeNil)
+			So(enforcer.UpdateAddressSet("feed", []string{"10.1.2.0/24", "example.com"}), ShouldNotBeNil)
+			So(matches("pu1", "10.1.1.1"), ShouldBeTrue)
+			So(matches("pu1", "10.1.2.1"), ShouldBeFalse)
+		})
+
+		Convey("When I update an address set without a name, I should get an error", func() {
+			So(enforcer.UpdateAddressSet("", []string{"10.1.1.0/24"}), ShouldNotBeNil)
+		})
+	})
+}
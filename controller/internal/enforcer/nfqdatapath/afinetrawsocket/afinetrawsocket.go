@@ -5,11 +5,13 @@ package afinetrawsocket
 import (
 	"fmt"
 	"syscall"
+
+	"go.uber.org/zap"
 )
 
 type rawsocket struct {
-	fd     int
-	insock *syscall.SockaddrInet4
+	fd  int
+	fd6 int
 }
 
 const (
@@ -21,6 +23,9 @@ const (
 	//ApplicationRawSocketMark is the mark on packet egressing
 	//the raw socket coming from application
 	ApplicationRawSocketMark = 0x40000062
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
 )
 
 // SocketWriter interface exposes an interface to write and close sockets
@@ -29,20 +34,53 @@ type SocketWriter interface {
 	CloseSocket() error
 }
 
-// CreateSocket returns a handle to SocketWriter interface
+// sendto is the function used to send the packets. It is useful to mock the
+// sockets in unit tests.
+var sendto = syscall.Sendto
+
+// CreateSocket returns a handle to SocketWriter interface. The IPv4 socket is
+// required. IPv6 packets can not be written if the IPv6 socket can not be
+// created.
 func CreateSocket(mark int, deviceName string) (SocketWriter, error) {
-	fd, _ := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_RAW)
-	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
-		syscall.Close(fd) // nolint
-		return nil, fmt.Errorf("Received error %s while setting socket Option SO_MARK", err)
+
+	fd, err := createSocket(syscall.AF_INET, mark)
+	if err != nil {
+		return nil, err
+	}
+
+	fd6, err := createSocket(syscall.AF_INET6, mark)
+	if err != nil {
+		zap.L().Warn("Unable to create ipv6 raw socket", zap.Error(err))
+		fd6 = -1
+	}
+
+	return &rawsocket{
+		fd:  fd,
+		fd6: fd6,
+	}, nil
+}
+
+// createSocket creates a raw socket of the given family that sends packets
+// with their IP header.
+func createSocket(family int, mark int) (int, error) {
+
+	// Raw IPv6 sockets of the IPPROTO_RAW protocol always include the
+	// IP header.
+	fd, err := syscall.Socket(family, syscall.SOCK_RAW, syscall.IPPROTO_RAW)
+	if err != nil {
+		return -1, fmt.Errorf("Received error %s while creating raw socket", err)
 	}
 
-	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_HDRINCL, 1); err != nil {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
 		syscall.Close(fd) // nolint
-		return nil, fmt.Errorf("Received error %s while setting socket Option IP_HDRINCL", err)
+		return -1, fmt.Errorf("Received error %s while setting socket Option SO_MARK", err)
 	}
-	insock := &syscall.SockaddrInet4{
-		Port: 0,
+
+	if family == syscall.AF_INET {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_HDRINCL, 1); err != nil {
+			syscall.Close(fd) // nolint
+			return -1, fmt.Errorf("Received error %s while setting socket Option IP_HDRINCL", err)
+		}
 	}
 
 	// TODO: Make this a const
@@ -50,11 +88,11 @@ func CreateSocket(mark int, deviceName string) (SocketWriter, error) {
 	sockrcvbuf := 500 * int(NfnlBuffSize)
 	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, sockrcvbuf); err != nil {
 		syscall.Close(fd) // nolint
-		return nil, fmt.Errorf("Received error %s while setting socket Option SO_RCVBUF", err)
+		return -1, fmt.Errorf("Received error %s while setting socket Option SO_RCVBUF", err)
 	}
 	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, sockrcvbuf); err != nil {
 		syscall.Close(fd) // nolint
-		return nil, fmt.Errorf("Received error %s while setting socket Option SO_SNDBUF", err)
+		return -1, fmt.Errorf("Received error %s while setting socket Option SO_SNDBUF", err)
 	}
 	lingerconf := &syscall.Linger{
 		Onoff:  1,
@@ -62,25 +100,57 @@ func CreateSocket(mark int, deviceName string) (SocketWriter, error) {
 	}
 	if err := syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, lingerconf); err != nil {
 		syscall.Close(fd) // nolint
-		return nil, fmt.Errorf("Received error %s while setting socket Option SO_SNDBUF", err)
+		return -1, fmt.Errorf("Received error %s while setting socket Option SO_LINGER", err)
 	}
 
-	return &rawsocket{
-		fd:     fd,
-		insock: insock,
-	}, nil
-
+	return fd, nil
 }
 
+// WriteSocket writes the packet on the socket of its IP version.
 func (sock *rawsocket) WriteSocket(buf []byte) error {
-	//This is an IP frame dest address at byte[16]
-	copy(sock.insock.Addr[:], buf[16:])
-	if err := syscall.Sendto(sock.fd, buf[:], 0, sock.insock); err != nil {
+
+	if len(buf) == 0 {
+		return fmt.Errorf("empty packet")
+	}
+
+	var fd int
+	var to syscall.Sockaddr
+
+	switch buf[0] >> 4 {
+	case 4:
+		if len(buf) < ipv4HeaderLen {
+			return fmt.Errorf("ipv4 packet too short: %d", len(buf))
+		}
+		//This is an IP frame dest address at byte[16]
+		addr := &syscall.SockaddrInet4{}
+		copy(addr.Addr[:], buf[16:20])
+		fd, to = sock.fd, addr
+	case 6:
+		if len(buf) < ipv6HeaderLen {
+			return fmt.Errorf("ipv6 packet too short: %d", len(buf))
+		}
+		if sock.fd6 < 0 {
+			return fmt.Errorf("no ipv6 raw socket")
+		}
+		// The destination address of an IPv6 frame is at byte[24]
+		addr := &syscall.SockaddrInet6{}
+		copy(addr.Addr[:], buf[24:40])
+		fd, to = sock.fd6, addr
+	default:
+		return fmt.Errorf("unknown ip version %d", buf[0]>>4)
+	}
+
+	if err := sendto(fd, buf[:], 0, to); err != nil {
 		return fmt.Errorf("received error %s while sending to socket", err)
 	}
 	return nil
 }
 
 func (sock *rawsocket) CloseSocket() error {
+
+	if sock.fd6 >= 0 {
+		syscall.Close(sock.fd6) // nolint
+	}
+
 	return syscall.Close(sock.fd)
 }
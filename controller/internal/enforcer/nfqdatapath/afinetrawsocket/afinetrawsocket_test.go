@@ -0,0 +1,68 @@
+// +build linux
+
+package afinetrawsocket
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteSocket(t *testing.T) {
+
+	Convey("Given a raw socket with ipv4 and ipv6 sockets", t, func() {
+		origSendto := sendto
+		defer func() {
+			sendto = origSendto
+		}()
+
+		var sentFd int
+		var sentTo syscall.Sockaddr
+		sendto = func(fd int, p []byte, flags int, to syscall.Sockaddr) error {
+			sentFd = fd
+			sentTo = to
+			return nil
+		}
+
+		sock := &rawsocket{fd: 3, fd6: 4}
+
+		Convey("When I write an ipv4 packet, it should be sent on the ipv4 socket", func() {
+			buf := make([]byte, ipv4HeaderLen)
+			buf[0] = 0x45
+			copy(buf[16:20], net.ParseIP("10.1.1.2").To4())
+
+			So(sock.WriteSocket(buf), ShouldBeNil)
+			So(sentFd, ShouldEqual, 3)
+			So(sentTo, ShouldResemble, &syscall.SockaddrInet4{Addr: [4]byte{10, 1, 1, 2}})
+		})
+
+		Convey("When I write an ipv6 packet, it should be sent on the ipv6 socket", func() {
+			buf := make([]byte, ipv6HeaderLen)
+			buf[0] = 0x60
+			copy(buf[24:40], net.ParseIP("2001:db8::2"))
+
+			So(sock.WriteSocket(buf), ShouldBeNil)
+			So(sentFd, ShouldEqual, 4)
+
+			addr, ok := sentTo.(*syscall.SockaddrInet6)
+			So(ok, ShouldBeTrue)
+			So(net.IP(addr.Addr[:]).Equal(net.ParseIP("2001:db8::2")), ShouldBeTrue)
+		})
+
+		Convey("When there is no ipv6 socket, ipv6 packets should fail", func() {
+			sock.fd6 = -1
+			buf := make([]byte, ipv6HeaderLen)
+			buf[0] = 0x60
+
+			So(sock.WriteSocket(buf), ShouldNotBeNil)
+		})
+
+		Convey("When I write a truncated or unknown packet, it should fail", func() {
+			So(sock.WriteSocket(nil), ShouldNotBeNil)
+			So(sock.WriteSocket([]byte{0x45, 0}), ShouldNotBeNil)
+			So(sock.WriteSocket(make([]byte, ipv6HeaderLen)), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,152 @@
+package nfqdatapath
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.uber.org/zap"
+)
+
+// Stages of the policy decisions in the audit records
+const (
+	AuditStageUDPSyn    = "udpsyn"
+	AuditStageUDPSynAck = "udpsynack"
+	AuditStageUDPAck    = "udpack"
+)
+
+// AuditRecord is the record of a policy decision of the datapath.
+type AuditRecord struct {
+	// Timestamp is the time of the decision
+	Timestamp time.Time
+	// Stage is the packet of the handshake the decision was taken on
+	Stage string
+	// ContextID and PUID are the PU involved
+	ContextID string
+	PUID      string
+	// RemotePUID is the remote PU, if it is known
+	RemotePUID string
+	// SourceIP, SourcePort, DestinationIP and DestinationPort are the flow
+	SourceIP        string
+	SourcePort      uint16
+	DestinationIP   string
+	DestinationPort uint16
+	// Action is the decision and Reason explains a reject
+	Action string
+	Reason string
+	// PolicyID and SelectorID are the policy and the selector that matched
+	PolicyID   string
+	SelectorID string
+	// Claims are the tags of the remote PU
+	Claims []string
+}
+
+// AuditSink receives the records of the policy decisions. The records are
+// passed synchronously, so the sink must not block.
+type AuditSink interface {
+	Audit(record *AuditRecord) error
+}
+
+// AuditFileSink appends the records to a file as JSON, one per line.
+type AuditFileSink struct {
+	file    *os.File
+	encoder *json.Encoder
+	sync.Mutex
+}
+
+// NewAuditFileSink returns a sink that appends the records to the given
+// file. The existing records are never modified.
+func NewAuditFileSink(path string) (*AuditFileSink, error) {
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log: %s", err)
+	}
+
+	return &AuditFileSink{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+// Audit appends a record to the file.
+func (s *AuditFileSink) Audit(record *AuditRecord) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.encoder.Encode(record)
+}
+
+// Close closes the file.
+func (s *AuditFileSink) Close() error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.file.Close()
+}
+
+// auditConfig holds the sink of the datapath. The sink is nil if the audit
+// is disabled.
+type auditConfig struct {
+	sink AuditSink
+}
+
+// SetAuditSink sets the sink of the records of the policy decisions. A nil
+// sink disables the audit, which is the default.
+func (d *Datapath) SetAuditSink(sink AuditSink) {
+
+	d.audit.Store(auditConfig{sink: sink})
+}
+
+// auditUDPDecision sends the record of the decision taken on a handshake
+// packet to the audit sink. The packet is rejected if err is set.
+func (d *Datapath) auditUDPDecision(stage string, p *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection, claims *tokens.ConnectionClaims, flowPolicy *policy.FlowPolicy, err error) {
+
+	config, ok := d.audit.Load().(auditConfig)
+	if !ok || config.sink == nil {
+		return
+	}
+
+	record := &AuditRecord{
+		Timestamp:       time.Now().UTC(),
+		Stage:           stage,
+		ContextID:       context.ID(),
+		PUID:            context.ManagementID(),
+		SourceIP:        p.SourceAddress.String(),
+		SourcePort:      p.SourcePort,
+		DestinationIP:   p.DestinationAddress.String(),
+		DestinationPort: p.DestinationPort,
+		Action:          policy.Accept.String(),
+	}
+
+	if conn != nil {
+		record.RemotePUID = conn.Auth.RemoteContextID
+	}
+
+	if err != nil {
+		record.Action = policy.Reject.String()
+		record.Reason = err.Error()
+	}
+
+	if flowPolicy != nil {
+		record.PolicyID = flowPolicy.PolicyID
+		record.SelectorID = flowPolicy.SelectorID
+	}
+
+	if claims != nil && claims.T != nil {
+		record.Claims = claims.T.GetSlice()
+	}
+
+	if err := config.sink.Audit(record); err != nil {
+		zap.L().Warn("Unable to audit policy decision", zap.String("stage", stage), zap.String("puID", record.PUID), zap.Error(err))
+	}
+}
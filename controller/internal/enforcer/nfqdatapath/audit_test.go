@@ -0,0 +1,221 @@
+package nfqdatapath
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+type capturingAuditSink struct {
+	sync.Mutex
+	audited []*AuditRecord
+	err     error
+}
+
+func (s *capturingAuditSink) Audit(record *AuditRecord) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.audited = append(s.audited, record)
+	return s.err
+}
+
+func (s *capturingAuditSink) records() []*AuditRecord {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]*AuditRecord{}, s.audited...)
+}
+
+func TestAuditUDPDecisions(t *testing.T) {
+
+	Convey("Given I have a client and a server enforcer that audit their decisions", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		writer := &capturingSocketWriter{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		client := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		client.conntrackHdl = &capturingConntrack{}
+		server := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		server.conntrackHdl = &capturingConntrack{}
+
+		clientAudit := &capturingAuditSink{}
+		client.SetAuditSink(clientAudit)
+		serverAudit := &capturingAuditSink{}
+		server.SetAuditSink(serverAudit)
+
+		newContext := func(id string, tag string) *pucontext.PUContext {
+			puInfo := policy.NewPUInfo(id, common.ContainerPU)
+			puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, id)
+			puInfo.Policy.AddIdentityTag("app", tag)
+			selector := policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      "app",
+						Value:    []string{"client", "server"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: id + "policy"},
+			}
+			puInfo.Policy.AddReceiverRules(selector)
+			puInfo.Policy.AddTransmitterRules(selector)
+			context, err := pucontext.NewPU(id, puInfo, 10*time.Second)
+			So(err, ShouldBeNil)
+			return context
+		}
+
+		clientContext := newContext("clientpu", "client")
+		serverContext := newContext("serverpu", "server")
+		clientConn := connection.NewUDPConnection(clientContext, writer)
+		serverConn := connection.NewUDPConnection(serverContext, writer)
+
+		token, err := client.tokenAccessor.CreateSynPacketToken(clientContext, &clientConn.Auth)
+		So(err, ShouldBeNil)
+		syn, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, nil)
+		So(err, ShouldBeNil)
+		syn.UDPTokenAttach(client.CreateUDPAuthMarker(packet.UDPSynMask), token)
+
+		Convey("When the handshake completes, a record should be emitted per decision", func() {
+			_, _, err := server.processNetworkUDPSynPacket(serverContext, serverConn, syn)
+			So(err, ShouldBeNil)
+
+			token, err := server.tokenAccessor.CreateSynAckPacketToken(serverContext, &serverConn.Auth)
+			So(err, ShouldBeNil)
+			synAck, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 53, 5000, nil)
+			So(err, ShouldBeNil)
+			synAck.UDPTokenAttach(server.CreateUDPAuthMarker(packet.UDPSynAckMask), token)
+
+			_, _, err = client.processNetworkUDPSynAckPacket(synAck, clientContext, clientConn)
+			So(err, ShouldBeNil)
+			So(client.sendUDPAckPacket(synAck, clientContext, clientConn), ShouldBeNil)
+
+			ack, err := packet.New(packet.PacketTypeNetwork, writer.last(), "0", true)
+			So(err, ShouldBeNil)
+			_, _, err = server.processNetworkUDPAckPacket(ack, serverContext, serverConn)
+			So(err, ShouldBeNil)
+
+			records := serverAudit.records()
+			So(len(records), ShouldEqual, 2)
+
+			So(records[0].Stage, ShouldEqual, AuditStageUDPSyn)
+			So(records[0].Action, ShouldEqual, policy.Accept.String())
+			So(records[0].ContextID, ShouldEqual, "serverpu")
+			So(records[0].RemotePUID, ShouldEqual, "clientpu")
+			So(records[0].SourceIP, ShouldEqual, "10.1.1.1")
+			So(records[0].SourcePort, ShouldEqual, 5000)
+			So(records[0].DestinationIP, ShouldEqual, "10.1.1.2")
+			So(records[0].DestinationPort, ShouldEqual, 53)
+			So(records[0].PolicyID, ShouldEqual, "serverpupolicy")
+			So(records[0].Claims, ShouldContain, "app=client")
+			So(records[0].Timestamp.IsZero(), ShouldBeFalse)
+
+			So(records[1].Stage, ShouldEqual, AuditStageUDPAck)
+			So(records[1].Action, ShouldEqual, policy.Accept.String())
+			So(records[1].PolicyID, ShouldEqual, "serverpupolicy")
+			So(records[1].Claims, ShouldBeNil)
+
+			records = clientAudit.records()
+			So(len(records), ShouldEqual, 1)
+			So(records[0].Stage, ShouldEqual, AuditStageUDPSynAck)
+			So(records[0].Action, ShouldEqual, policy.Accept.String())
+			So(records[0].RemotePUID, ShouldEqual, "serverpu")
+			So(records[0].PolicyID, ShouldEqual, "clientpupolicy")
+			So(records[0].Claims, ShouldContain, "app=server")
+		})
+
+		Convey("When the server rejects a syn, the reject should be audited with the reason", func() {
+			invalid, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, nil)
+			So(err, ShouldBeNil)
+			invalid.UDPTokenAttach(client.CreateUDPAuthMarker(packet.UDPSynMask), []byte("invalid token"))
+
+			_, _, err = server.processNetworkUDPSynPacket(serverContext, serverConn, invalid)
+			So(err, ShouldNotBeNil)
+
+			records := serverAudit.records()
+			So(len(records), ShouldEqual, 1)
+			So(records[0].Stage, ShouldEqual, AuditStageUDPSyn)
+			So(records[0].Action, ShouldEqual, policy.Reject.String())
+			So(records[0].Reason, ShouldEqual, err.Error())
+		})
+
+		Convey("When the sink fails, the decision should not change", func() {
+			serverAudit.err = errors.New("sink failure")
+			_, _, err := server.processNetworkUDPSynPacket(serverContext, serverConn, syn)
+			So(err, ShouldBeNil)
+			So(len(serverAudit.records()), ShouldEqual, 1)
+		})
+
+		Convey("When the audit is disabled, no record should be emitted", func() {
+			server.SetAuditSink(nil)
+			_, _, err := server.processNetworkUDPSynPacket(serverContext, serverConn, syn)
+			So(err, ShouldBeNil)
+			So(len(serverAudit.records()), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestAuditFileSink(t *testing.T) {
+
+	Convey("Given an audit file", t, func() {
+		dir, err := ioutil.TempDir("", "audit")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint errcheck
+
+		path := filepath.Join(dir, "pu.audit")
+
+		Convey("When records are written by two sinks in turn, they should be appended as JSON", func() {
+			for _, stage := range []string{AuditStageUDPSyn, AuditStageUDPAck} {
+				sink, err := NewAuditFileSink(path)
+				So(err, ShouldBeNil)
+				So(sink.Audit(&AuditRecord{Stage: stage, PUID: "pu", Action: policy.Accept.String()}), ShouldBeNil)
+				So(sink.Close(), ShouldBeNil)
+			}
+
+			file, err := os.Open(path)
+			So(err, ShouldBeNil)
+			defer file.Close() // nolint errcheck
+
+			stages := []string{}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				record := &AuditRecord{}
+				So(json.Unmarshal(scanner.Bytes(), record), ShouldBeNil)
+				So(record.PUID, ShouldEqual, "pu")
+				stages = append(stages, record.Stage)
+			}
+			So(stages, ShouldResemble, []string{AuditStageUDPSyn, AuditStageUDPAck})
+		})
+
+		Convey("When the directory does not exist, the sink should not be created", func() {
+			_, err := NewAuditFileSink(filepath.Join(dir, "missing", "pu.audit"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,76 @@
+package nfqdatapath
+
+import (
+	"sync"
+)
+
+const (
+	// standardFrameSize is the size class of the handshake packets of
+	// standard frames.
+	standardFrameSize = 1500
+	// jumboFrameSize is the size class of the handshake packets of jumbo
+	// frames.
+	jumboFrameSize = 9000
+)
+
+// handshakeBuffers holds the buffers of the UDP control packets created by
+// the datapath. Only the buffers that are released once the packet is written
+// are pooled. The application packets queued during the handshake are
+// retained and are never pooled.
+var handshakeBuffers = newBufferPool(standardFrameSize, jumboFrameSize)
+
+// bufferPool is a set of pools of byte buffers, one per size class.
+type bufferPool struct {
+	sizes []int
+	pools []sync.Pool
+}
+
+// newBufferPool returns a pool with the given size classes, in increasing
+// order.
+func newBufferPool(sizes ...int) *bufferPool {
+
+	b := &bufferPool{
+		sizes: sizes,
+		pools: make([]sync.Pool, len(sizes)),
+	}
+
+	for i := range sizes {
+		size := sizes[i]
+		b.pools[i].New = func() interface{} {
+			buffer := make([]byte, size)
+			return &buffer
+		}
+	}
+
+	return b
+}
+
+// get returns a buffer of the given length. Its capacity is the smallest size
+// class that fits the length, so that data can be appended in place. Buffers
+// larger than all the classes are allocated.
+func (b *bufferPool) get(length int) *[]byte {
+
+	for i, size := range b.sizes {
+		if length <= size {
+			buffer := b.pools[i].Get().(*[]byte)
+			*buffer = (*buffer)[:length]
+			return buffer
+		}
+	}
+
+	buffer := make([]byte, length)
+	return &buffer
+}
+
+// put returns a buffer to the pool of its class. The buffer must not be
+// referenced after. Buffers that don't belong to a class are dropped.
+func (b *bufferPool) put(buffer *[]byte) {
+
+	for i, size := range b.sizes {
+		if cap(*buffer) == size {
+			*buffer = (*buffer)[:size]
+			b.pools[i].Put(buffer)
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package nfqdatapath
+
+import (
+	"testing"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBufferPool(t *testing.T) {
+
+	Convey("Given I have a buffer pool", t, func() {
+		pool := newBufferPool(standardFrameSize, jumboFrameSize)
+
+		Convey("The buffers should have the capacity of the smallest class that fits them", func() {
+			for _, test := range []struct {
+				length   int
+				capacity int
+			}{
+				{packet.UDPDataPos, standardFrameSize},
+				{standardFrameSize, standardFrameSize},
+				{standardFrameSize + 1, jumboFrameSize},
+				{jumboFrameSize + 1, jumboFrameSize + 1},
+			} {
+				buffer := pool.get(test.length)
+				So(len(*buffer), ShouldEqual, test.length)
+				So(cap(*buffer), ShouldEqual, test.capacity)
+				pool.put(buffer)
+			}
+		})
+
+		Convey("A released buffer should be returned with the requested length", func() {
+			buffer := pool.get(packet.UDPDataPos)
+			*buffer = append(*buffer, make([]byte, 100)...)
+			pool.put(buffer)
+
+			buffer = pool.get(packet.UDPDataPos)
+			So(len(*buffer), ShouldEqual, packet.UDPDataPos)
+			So(cap(*buffer), ShouldEqual, standardFrameSize)
+		})
+	})
+}
+
+// lastPacketWriter keeps a copy of the last packet written in a reused
+// buffer.
+type lastPacketWriter struct {
+	packet []byte
+}
+
+func (w *lastPacketWriter) WriteSocket(buf []byte) error {
+	w.packet = append(w.packet[:0], buf...)
+	return nil
+}
+
+func (w *lastPacketWriter) CloseSocket() error {
+	return nil
+}
+
+func BenchmarkUDPHandshake(b *testing.B) {
+
+	secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+	writer := &lastPacketWriter{}
+
+	// mock the call
+	prevRawSocket := GetUDPRawSocket
+	defer func() {
+		GetUDPRawSocket = prevRawSocket
+	}()
+	GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+		return writer, nil
+	}
+
+	client := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+	client.conntrackHdl = &NoopConntrack{}
+	server := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+	clientPolicy := policy.NewPUPolicy("clientpu", policy.Police, nil, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{"0.0.0.0/0"}, []string{}, nil, nil, []string{})
+	clientRuntime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+	clientContext, err := pucontext.NewPU("client", policy.PUInfoFromPolicyAndRuntime("client", clientPolicy, clientRuntime), 10*time.Second)
+	if err != nil {
+		b.Fatal(err)
+	}
+	serverContext, err := pucontext.NewPU("server", policy.NewPUInfo("server", common.ContainerPU), 10*time.Second)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	clientConn := connection.NewUDPConnection(clientContext, writer)
+	serverConn := connection.NewUDPConnection(serverContext, writer)
+	clientConn.Auth.RemoteContext = serverConn.Auth.LocalContext
+	serverConn.Auth.RemoteContext = clientConn.Auth.LocalContext
+
+	appPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, []byte("query"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// received returns the last packet written as a packet received from the
+	// network. The buffer has room for the reply.
+	buffer := make([]byte, 0, 1500)
+	received := func() *packet.Packet {
+		buffer = append(buffer[:0], writer.packet...)
+		p, err := packet.New(packet.PacketTypeNetwork, buffer, "0", true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return p
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := client.processApplicationUDPSynPacket(appPacket, clientContext, clientConn); err != nil {
+			b.Fatal(err)
+		}
+		if err := server.sendUDPSynAckPacket(received(), serverContext, serverConn); err != nil {
+			b.Fatal(err)
+		}
+		if err := client.sendUDPAckPacket(received(), clientContext, clientConn); err != nil {
+			b.Fatal(err)
+		}
+
+		// Stop the retransmissions of the handshake.
+		clientConn.SynChannel() <- true
+		serverConn.SynAckChannel() <- true
+	}
+}
@@ -0,0 +1,123 @@
+package nfqdatapath
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.aporeto.io/netlink-go/conntrack"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+)
+
+// conntrackUpdateLifetime is how long the updates of the conntrack marks
+// are kept for the export.
+const conntrackUpdateLifetime = 10 * time.Minute
+
+// ConntrackHandle is the part of the conntrack handle used by the datapath
+// to release the flows to the kernel.
+type ConntrackHandle interface {
+	ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error
+}
+
+// GetConntrackHandle is placeholder for the function that creates the
+// conntrack handle of the datapath. It can be replaced where netlink
+// conntrack is not available, and in unit tests.
+var GetConntrackHandle = func() ConntrackHandle {
+	return conntrack.NewHandle()
+}
+
+// NoopConntrack is a conntrack handle that ignores all the updates.
+type NoopConntrack struct{}
+
+// ConntrackTableUpdateMark implements the ConntrackHandle interface.
+func (n *NoopConntrack) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
+	return nil
+}
+
+// MemoryConntrack is a conntrack handle that keeps the marks of the flows
+// in memory.
+type MemoryConntrack struct {
+	marks map[string]uint32
+	sync.Mutex
+}
+
+// NewMemoryConntrack returns an empty in memory conntrack handle.
+func NewMemoryConntrack() *MemoryConntrack {
+	return &MemoryConntrack{
+		marks: map[string]uint32{},
+	}
+}
+
+// ConntrackTableUpdateMark implements the ConntrackHandle interface.
+func (m *MemoryConntrack) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.marks[conntrackKey(ipSrc, ipDst, protonum, srcport, dstport)] = newmark
+	return nil
+}
+
+// Mark returns the mark of a flow, and false if the flow was never updated.
+func (m *MemoryConntrack) Mark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) (uint32, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	mark, ok := m.marks[conntrackKey(ipSrc, ipDst, protonum, srcport, dstport)]
+	return mark, ok
+}
+
+// Flush removes all the flows.
+func (m *MemoryConntrack) Flush() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.marks = map[string]uint32{}
+}
+
+func conntrackKey(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) string {
+	return strconv.Itoa(int(protonum)) + "/" + ipSrc + "/" + ipDst + "/" + strconv.Itoa(int(srcport)) + "/" + strconv.Itoa(int(dstport))
+}
+
+// updateConntrackMark updates the conntrack mark of a flow and keeps the
+// update for the export.
+func (d *Datapath) updateConntrackMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
+
+	err := d.conntrackHdl.ConntrackTableUpdateMark(ipSrc, ipDst, protonum, srcport, dstport, newmark)
+
+	update := &connection.ConntrackUpdate{
+		SourceIP:        ipSrc,
+		DestinationIP:   ipDst,
+		Protocol:        protonum,
+		SourcePort:      srcport,
+		DestinationPort: dstport,
+		Mark:            newmark,
+		Time:            time.Now(),
+	}
+	if err != nil {
+		update.Error = err.Error()
+	}
+
+	d.conntrackUpdates.AddOrUpdate(conntrackKey(ipSrc, ipDst, protonum, srcport, dstport), update)
+
+	return err
+}
+
+// ConntrackUpdates returns the recent updates of the conntrack marks issued
+// by the datapath, from the oldest to the newest.
+func (d *Datapath) ConntrackUpdates() []connection.ConntrackUpdate {
+
+	updates := []connection.ConntrackUpdate{}
+	for _, key := range d.conntrackUpdates.KeyList() {
+		// The update may have expired since the keys were listed.
+		if update, err := d.conntrackUpdates.Get(key); err == nil {
+			updates = append(updates, *update.(*connection.ConntrackUpdate))
+		}
+	}
+
+	sort.SliceStable(updates, func(i, j int) bool {
+		return updates[i].Time.Before(updates[j].Time)
+	})
+
+	return updates
+}
@@ -0,0 +1,157 @@
+package nfqdatapath
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestMemoryConntrack(t *testing.T) {
+
+	Convey("Given an in memory conntrack handle", t, func() {
+		c := NewMemoryConntrack()
+
+		Convey("When I update the mark of a flow, I should read it back", func() {
+			So(c.ConntrackTableUpdateMark("10.1.1.1", "10.1.1.2", packet.IPProtocolUDP, 53, 5000, 100), ShouldBeNil)
+
+			mark, ok := c.Mark("10.1.1.1", "10.1.1.2", packet.IPProtocolUDP, 53, 5000)
+			So(ok, ShouldBeTrue)
+			So(mark, ShouldEqual, 100)
+
+			Convey("Other flows should not be marked", func() {
+				_, ok := c.Mark("10.1.1.1", "10.1.1.2", packet.IPProtocolTCP, 53, 5000)
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("When I flush the table, the flow should not be marked", func() {
+				c.Flush()
+				_, ok := c.Mark("10.1.1.1", "10.1.1.2", packet.IPProtocolUDP, 53, 5000)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given a noop conntrack handle, updates should succeed", t, func() {
+		c := &NoopConntrack{}
+		So(c.ConntrackTableUpdateMark("10.1.1.1", "10.1.1.2", packet.IPProtocolUDP, 53, 5000, 100), ShouldBeNil)
+	})
+}
+
+func TestConntrackUpdates(t *testing.T) {
+
+	Convey("Given I have a client and a server enforcer in the middle of a UDP handshake", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		writer := &capturingSocketWriter{}
+
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		clientConntrack := &capturingConntrack{}
+		client := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		client.conntrackHdl = clientConntrack
+		server := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		server.conntrackHdl = &capturingConntrack{}
+
+		clientContext, err := pucontext.NewPU("client", policy.NewPUInfo("client", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+		serverPolicy := policy.NewPUPolicy("serverpu", policy.AllowAll, nil, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+		serverRuntime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+		serverContext, err := pucontext.NewPU("server", policy.PUInfoFromPolicyAndRuntime("server", serverPolicy, serverRuntime), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		clientConn := connection.NewUDPConnection(clientContext, writer)
+		serverConn := connection.NewUDPConnection(serverContext, writer)
+		clientConn.Auth.RemoteContext = serverConn.Auth.LocalContext
+		serverConn.Auth.RemoteContext = clientConn.Auth.LocalContext
+		serverConn.ReportFlowPolicy = &policy.FlowPolicy{Action: policy.Accept}
+		serverConn.PacketFlowPolicy = &policy.FlowPolicy{Action: policy.Accept}
+
+		synAck, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 53, 5000, nil)
+		So(err, ShouldBeNil)
+
+		expected := connection.ConntrackUpdate{
+			SourceIP:        "10.1.1.2",
+			DestinationIP:   "10.1.1.1",
+			Protocol:        packet.IPProtocolUDP,
+			SourcePort:      53,
+			DestinationPort: 5000,
+			Mark:            constants.DefaultConnMark,
+		}
+
+		Convey("Before any update, the exports should be empty", func() {
+			So(client.ConntrackUpdates(), ShouldBeEmpty)
+			So(server.ConntrackUpdates(), ShouldBeEmpty)
+		})
+
+		Convey("When the client sends the ack and the server accepts it", func() {
+			So(client.sendUDPAckPacket(synAck, clientContext, clientConn), ShouldBeNil)
+
+			ack, err := packet.New(packet.PacketTypeNetwork, writer.last(), "0", true)
+			So(err, ShouldBeNil)
+			_, _, err = server.processNetworkUDPAckPacket(ack, serverContext, serverConn)
+			So(err, ShouldBeNil)
+
+			Convey("Then the updates of both sides should be exported", func() {
+				for _, updates := range [][]connection.ConntrackUpdate{client.ConntrackUpdates(), server.ConntrackUpdates()} {
+					So(len(updates), ShouldEqual, 1)
+					So(updates[0].Time.IsZero(), ShouldBeFalse)
+					updates[0].Time = time.Time{}
+					So(updates[0], ShouldResemble, expected)
+				}
+			})
+
+			Convey("Then the export should be JSON", func() {
+				updates := []connection.ConntrackUpdate{}
+				So(json.Unmarshal(mustMarshal(client.ConntrackUpdates()), &updates), ShouldBeNil)
+				So(len(updates), ShouldEqual, 1)
+				So(updates[0].SourcePort, ShouldEqual, 53)
+			})
+
+			Convey("Then a new update of the flow should replace the previous one", func() {
+				// The packet was turned into the ack.
+				synAck, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 53, 5000, nil)
+				So(err, ShouldBeNil)
+
+				client.filterQueue.ConnMark = 0x1234
+				So(client.sendUDPAckPacket(synAck, clientContext, clientConn), ShouldBeNil)
+
+				updates := client.ConntrackUpdates()
+				So(len(updates), ShouldEqual, 1)
+				So(updates[0].Mark, ShouldEqual, 0x1234)
+			})
+		})
+
+		Convey("When the conntrack update fails, the failed update should be exported", func() {
+			clientConntrack.fail = true
+			So(client.sendUDPAckPacket(synAck, clientContext, clientConn), ShouldBeNil)
+
+			updates := client.ConntrackUpdates()
+			So(len(updates), ShouldEqual, 1)
+			So(updates[0].Error, ShouldContainSubstring, "conntrack update failed")
+		})
+	})
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,117 @@
+package nfqdatapath
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+)
+
+const (
+	// defaultContextCacheSize is the default number of contexts cached for
+	// the lookups of the packets.
+	defaultContextCacheSize = 4096
+	// contextCacheExpiration bounds the lifetime of the cached contexts. The
+	// ports of the UID PUs are learned and expired by the datapath without
+	// any policy change.
+	contextCacheExpiration = 5 * time.Second
+)
+
+// contextKey is the key of the context cache. Application packets are
+// looked up by mark and network packets by protocol and port.
+type contextKey struct {
+	app      bool
+	mark     string
+	port     uint16
+	protocol uint8
+}
+
+// newContextKey returns the key of the lookup. The fields that are not used
+// by the lookup are left empty so that they don't split the entries.
+func newContextKey(app bool, mark string, port uint16, protocol uint8) contextKey {
+
+	if app {
+		return contextKey{app: true, mark: mark}
+	}
+
+	return contextKey{port: port, protocol: protocol}
+}
+
+// contextCache is an LRU cache of the contexts found for the packets. Only
+// successful lookups are cached.
+type contextCache struct {
+	cache gcache.Cache
+
+	sync.RWMutex
+}
+
+// newContextCache returns a cache of the given size. A size of 0 disables the
+// cache.
+func newContextCache(size int) *contextCache {
+
+	c := &contextCache{}
+	c.setSize(size)
+
+	return c
+}
+
+// setSize replaces the cache with an empty cache of the given size.
+func (c *contextCache) setSize(size int) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.cache = nil
+	if size > 0 {
+		c.cache = gcache.New(size).LRU().Expiration(contextCacheExpiration).Build()
+	}
+}
+
+// get returns the cached context of the key.
+func (c *contextCache) get(key contextKey) (*pucontext.PUContext, bool) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.cache == nil {
+		return nil, false
+	}
+
+	pu, err := c.cache.Get(key)
+	if err != nil {
+		return nil, false
+	}
+
+	return pu.(*pucontext.PUContext), true
+}
+
+// add caches the context of the key.
+func (c *contextCache) add(key contextKey, pu *pucontext.PUContext) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.cache == nil {
+		return
+	}
+
+	c.cache.Set(key, pu) // nolint errcheck
+}
+
+// invalidate removes the entries of the context.
+func (c *contextCache) invalidate(contextID string) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.cache == nil {
+		return
+	}
+
+	for key, pu := range c.cache.GetALL(false) {
+		if pu.(*pucontext.PUContext).ID() == contextID {
+			c.cache.Remove(key)
+		}
+	}
+}
@@ -3,13 +3,16 @@ package nfqdatapath
 // Go libraries
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 
-	"go.aporeto.io/netlink-go/conntrack"
 	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/common"
 	"go.aporeto.io/trireme-lib/controller/constants"
@@ -19,13 +22,16 @@ import (
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/nflog"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/tokenaccessor"
 	"go.aporeto.io/trireme-lib/controller/internal/portset"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
 	"go.aporeto.io/trireme-lib/controller/pkg/fqconfig"
 	"go.aporeto.io/trireme-lib/controller/pkg/packet"
 	"go.aporeto.io/trireme-lib/controller/pkg/packetprocessor"
 	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
 	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
 	"go.aporeto.io/trireme-lib/policy"
 	"go.aporeto.io/trireme-lib/utils/cache"
+	"go.aporeto.io/trireme-lib/utils/logging"
 	"go.aporeto.io/trireme-lib/utils/portcache"
 	"go.aporeto.io/trireme-lib/utils/portspec"
 )
@@ -39,6 +45,11 @@ var GetUDPRawSocket = afinetrawsocket.CreateSocket
 // Datapath is the structure holding all information about a connection filter
 type Datapath struct {
 
+	// Raw socket write failure counters. They are accessed atomically
+	// and must stay at the top of the structure for alignment.
+	udpSocketWriteFailures       uint64
+	udpSocketConsecutiveFailures uint32
+
 	// Configuration parameters
 	filterQueue    *fqconfig.FilterQueue
 	collector      collector.EventCollector
@@ -57,6 +68,8 @@ type Datapath struct {
 	contextIDFromUDPPort *portcache.PortCache
 	// For remotes this is a reverse link to the context
 	puFromIP *pucontext.PUContext
+	// contextCache caches the contexts found for the packets
+	contextCache *contextCache
 
 	// Hash based on source IP/Port to capture SynAck packets with possible NAT.
 	// When a new connection is created, we has the source IP/port. A return
@@ -82,11 +95,71 @@ type Datapath struct {
 	udpNetReplyConnectionTracker cache.DataStore
 	udpNatConnectionTracker      cache.DataStore
 
+	// unenforcedFlows holds the flows initiated in a direction that their PU
+	// does not enforce.
+	unenforcedFlows cache.DataStore
+
+	// unenforcedReports rate limits the reports of the packets of processes
+	// that are not enforced
+	unenforcedReports cache.DataStore
+
+	// excludedPorts holds the destination ports of the flows that bypass
+	// the enforcement. excludedFlows holds the flows to these ports, whose
+	// replies are released. excludedFlowReports rate limits the reports of the
+	// UDP flows, since all their packets are seen until a reply.
+	excludedPorts       atomic.Value
+	excludedFlows       cache.DataStore
+	excludedFlowReports cache.DataStore
+
+	// implicitAllowNetworks holds the networks that the ACLs of the PUs
+	// accept when no rule matches.
+	implicitAllowNetworks atomic.Value
+
+	// tcpFastOpenReports rate limits the reports of the PUs whose Syn
+	// packets had the fast open disabled.
+	tcpFastOpenReports cache.DataStore
+
+	// failOpenClasses is the bitmask of the failure classes that accept the
+	// packets. failOpenFlows keeps the flows that were accepted after a
+	// failure, so that the rest of their packets are accepted.
+	failOpenClasses uint32
+	failOpenFlows   cache.DataStore
+
+	// reportRelaxedMutualAuth is set if the flows accepted only because the
+	// mutual authorization is disabled are reported.
+	reportRelaxedMutualAuth uint32
+
+	// markedFlows holds the flows between PUs that were released to the
+	// kernel, with the tags of their remote PU. revokedFlows holds the flows
+	// that the policy updates revoked, whose packets are dropped.
+	markedFlows  cache.DataStore
+	revokedFlows cache.DataStore
+
+	// serverNameInspection is set if the external TLS flows are matched by
+	// the server name of their ClientHello against the DNS rules.
+	// serverNameAddrs holds the addresses that the server names resolve to.
+	serverNameInspection uint32
+	serverNameAddrs      cache.DataStore
+
+	// addressSets holds the addresses of the address sets of the ACLs, so
+	// that the PUs enforced later get them. addressSetsLock serializes their
+	// updates with the enforcement of the PUs.
+	addressSets     map[string][]string
+	addressSetsLock sync.Mutex
+
 	// CacheTimeout used for Trireme auto-detecion
 	ExternalIPCacheTimeout time.Duration
 
 	// connctrack handle
-	conntrackHdl conntrack.Conntrack
+	conntrackHdl ConntrackHandle
+
+	// pathMTUs caches the MTUs of the paths to the destinations learned
+	// from the ICMP errors.
+	pathMTUs cache.DataStore
+
+	// conntrackUpdates keeps the recent updates of the conntrack marks, so
+	// that they can be exported for debugging.
+	conntrackUpdates cache.DataStore
 
 	// mode captures the mode of the enforcer
 	mode constants.ModeType
@@ -100,6 +173,40 @@ type Datapath struct {
 	portSetInstance portset.PortSet
 	// udp socket fd for application.
 	udpSocketWriter afinetrawsocket.SocketWriter
+	// udpKeyRotationInterval is the lifetime of the keys of a UDP connection.
+	// The keys are not rotated if it is 0.
+	udpKeyRotationInterval int64
+	// udpHandshakeTimeout is the time after which a UDP handshake that
+	// did not complete is reported and torn down. It is read atomically.
+	udpHandshakeTimeout int64
+	// udpHandshakes limits the number of half open UDP connections.
+	udpHandshakes *handshakeLimiter
+	// udpNonces holds the nonces of the recent UDP handshakes to reject
+	// the replayed ones.
+	udpNonces *nonceCache
+	// nfqProbe checks that the NFQ queues are still bound.
+	nfqProbe *nfqProbe
+	// udpConnectionStore persists the established UDP connections. The
+	// pending connections are restored when their PU is enforced.
+	udpConnectionStore    UDPConnectionStore
+	udpPendingConnections map[string][]*connection.UDPConnectionState
+	udpStoreLock          sync.Mutex
+
+	// audit holds the sink of the records of the policy decisions
+	audit atomic.Value
+
+	// reverseDNS resolves the names of the external addresses
+	reverseDNS atomic.Value
+
+	// protocolHelpers holds the protocol helpers by server port
+	protocolHelpers atomic.Value
+
+	// markResolver maps the marks set by other tools to the contexts
+	markResolver atomic.Value
+
+	// ready is closed once the interceptors are started
+	ready     chan struct{}
+	readyOnce sync.Once
 }
 
 func createPolicy(networks []string) policy.IPRuleList {
@@ -156,18 +263,18 @@ func New(
 
 		sysctlCmd, err := exec.LookPath("sysctl")
 		if err != nil {
-			zap.L().Fatal("sysctl command must be installed", zap.Error(err))
+			logging.Named("datapath").Fatal("sysctl command must be installed", zap.Error(err))
 		}
 
 		cmd := exec.Command(sysctlCmd, "-w", "net.netfilter.nf_conntrack_tcp_be_liberal=1")
 		if err := cmd.Run(); err != nil {
-			zap.L().Fatal("Failed to set conntrack options", zap.Error(err))
+			logging.Named("datapath").Fatal("Failed to set conntrack options", zap.Error(err))
 		}
 
 		if mode == constants.LocalServer {
 			cmd = exec.Command(sysctlCmd, "-w", "net.ipv4.ip_early_demux=0")
 			if err := cmd.Run(); err != nil {
-				zap.L().Fatal("Failed to set early demux options", zap.Error(err))
+				logging.Named("datapath").Fatal("Failed to set early demux options", zap.Error(err))
 			}
 		}
 	}
@@ -187,7 +294,7 @@ func New(
 
 	udpSocketWriter, err := GetUDPRawSocket(afinetrawsocket.ApplicationRawSocketMark, "udp")
 	if err != nil {
-		zap.L().Fatal("Unable to create raw socket for udp packet transmission", zap.Error(err))
+		logging.Named("datapath").Fatal("Unable to create raw socket for udp packet transmission", zap.Error(err))
 	}
 
 	d := &Datapath{
@@ -196,6 +303,7 @@ func New(
 		contextIDFromUDPPort: contextIDFromUDPPort,
 
 		puFromContextID: puFromContextID,
+		contextCache:    newContextCache(defaultContextCacheSize),
 
 		sourcePortConnectionCache:   cache.NewCacheWithExpiration("sourcePortConnectionCache", time.Second*24),
 		appOrigConnectionTracker:    cache.NewCacheWithExpiration("appOrigConnectionTracker", time.Second*24),
@@ -205,11 +313,18 @@ func New(
 		unknownSynConnectionTracker: cache.NewCacheWithExpiration("unknownSynConnectionTracker", time.Second*2),
 
 		udpSourcePortConnectionCache: cache.NewCacheWithExpiration("udpSourcePortConnectionCache", time.Second*60),
-		udpAppOrigConnectionTracker:  cache.NewCacheWithExpiration("udpAppOrigConnectionTracker", time.Second*60),
-		udpAppReplyConnectionTracker: cache.NewCacheWithExpiration("udpAppReplyConnectionTracker", time.Second*60),
 		udpNetOrigConnectionTracker:  cache.NewCacheWithExpiration("udpNetOrigConnectionTracker", time.Second*60),
 		udpNetReplyConnectionTracker: cache.NewCacheWithExpiration("udpNetReplyConnectionTracker", time.Second*60),
 		udpNatConnectionTracker:      cache.NewCacheWithExpiration("udpNatConnectionTracker", time.Second*60),
+		unenforcedFlows:              cache.NewCacheWithExpiration("unenforcedFlows", unenforcedFlowLifetime),
+		unenforcedReports:            cache.NewCacheWithExpiration("unenforcedReports", unenforcedReportInterval),
+		excludedFlows:                cache.NewCacheWithExpiration("excludedFlows", excludedFlowLifetime),
+		excludedFlowReports:          cache.NewCacheWithExpiration("excludedFlowReports", unenforcedReportInterval),
+		failOpenFlows:                cache.NewCacheWithExpiration("failOpenFlows", failOpenFlowTimeout),
+		tcpFastOpenReports:           cache.NewCacheWithExpiration("tcpFastOpenReports", tcpFastOpenReportInterval),
+		markedFlows:                  cache.NewCacheWithExpiration("markedFlows", markedFlowLifetime),
+		revokedFlows:                 cache.NewCacheWithExpiration("revokedFlows", revokedFlowLifetime),
+		serverNameAddrs:              cache.NewCacheWithExpiration("serverNameAddrs", serverNameTTL),
 
 		targetNetworks:         acls.NewACLCache(),
 		ExternalIPCacheTimeout: ExternalIPCacheTimeout,
@@ -222,19 +337,32 @@ func New(
 		ackSize:                secrets.AckSize(),
 		mode:                   mode,
 		procMountPoint:         procMountPoint,
-		conntrackHdl:           conntrack.NewHandle(),
+		conntrackHdl:           GetConntrackHandle(),
+		conntrackUpdates:       cache.NewCacheWithExpiration("conntrackUpdates", conntrackUpdateLifetime),
+		pathMTUs:               cache.NewCacheWithExpiration("pathMTUs", pathMTULifetime),
 		portSetInstance:        portSetInstance,
 		packetLogs:             packetLogs,
 		udpSocketWriter:        udpSocketWriter,
+		udpHandshakeTimeout:    int64(udpHandshakeTimeout),
+		udpHandshakes:          newHandshakeLimiter(defaultUDPHandshakeLimit, defaultUDPHandshakeLimitPerPU, udpHandshakeTimeout),
+		udpNonces:              newNonceCache(udpNonceWindow, udpNonceCapacity, udpNonceFalsePositive),
+		ready:                  make(chan struct{}),
 	}
 
+	// The application packets queued by the connections that expire during
+	// the handshake are reported, and so is the traffic of the accepted
+	// connections that expire.
+	d.udpAppOrigConnectionTracker = cache.NewCacheWithExpirationNotifier("udpAppOrigConnectionTracker", time.Second*60, d.udpConnectionExpired)
+	d.udpAppReplyConnectionTracker = cache.NewCacheWithExpirationNotifier("udpAppReplyConnectionTracker", time.Second*60, d.udpConnectionExpired)
+
 	if err = d.SetTargetNetworks(targetNetworks); err != nil {
-		zap.L().Error("Error adding target networks to the ACLs")
+		logging.Named("datapath").Error("Error adding target networks to the ACLs")
 	}
 
 	packet.PacketLogLevel = packetLogs
 
 	d.nflogger = nflog.NewNFLogger(11, 10, d.puInfoDelegate, collector)
+	d.nfqProbe = newNFQProbe(newNFQBinder(d), collector)
 
 	return d
 }
@@ -251,7 +379,7 @@ func NewWithDefaults(
 ) *Datapath {
 
 	if collector == nil {
-		zap.L().Fatal("Collector must be given to NewDefaultDatapathEnforcer")
+		logging.Named("datapath").Fatal("Collector must be given to NewDefaultDatapathEnforcer")
 	}
 
 	defaultMutualAuthorization := false
@@ -263,9 +391,9 @@ func NewWithDefaults(
 	}
 	defaultPacketLogs := false
 
-	tokenAccessor, err := tokenaccessor.New(serverID, defaultValidity, secrets)
+	tokenAccessor, err := tokenaccessor.New(serverID, defaultValidity, tokens.DefaultClockSkew, secrets)
 	if err != nil {
-		zap.L().Fatal("Cannot create a token engine")
+		logging.Named("datapath").Fatal("Cannot create a token engine")
 	}
 
 	puFromContextID := cache.NewCache("puFromContextID")
@@ -297,6 +425,10 @@ func (d *Datapath) Enforce(contextID string, puInfo *policy.PUInfo) error {
 		return fmt.Errorf("error creating new pu: %s", err)
 	}
 
+	if err := pu.SetImplicitAllowNetworks(d.ImplicitAllowNetworks()); err != nil {
+		return fmt.Errorf("error creating new pu: %s", err)
+	}
+
 	// Cache PUs for retrieval based on packet information
 	if pu.Type() == common.LinuxProcessPU || pu.Type() == common.UIDLoginPU {
 		mark, tcpPorts, udpPorts := pu.GetProcessKeys()
@@ -325,11 +457,51 @@ func (d *Datapath) Enforce(contextID string, puInfo *policy.PUInfo) error {
 	// pucontext launches a go routine to periodically
 	// lookup dns names. ctx cancel signals the go routine to exit
 	if prevPU, _ := d.puFromContextID.Get(contextID); prevPU != nil {
-		prevPU.(*pucontext.PUContext).CancelFunc()
+		prev := prevPU.(*pucontext.PUContext)
+
+		// Carry over the rules learned from DNS so that the resolved
+		// names keep working after a policy update.
+		if err := pu.InheritDNSACLs(prev); err != nil {
+			logging.Named("datapath").Warn("Unable to carry over DNS ACLs",
+				zap.String("contextID", contextID),
+				zap.Error(err),
+			)
+		}
+
+		// A policy update does not end the drain of the PU.
+		if prev.Draining() {
+			pu.Drain()
+		}
+
+		prev.CancelFunc()
+		d.reportDNSStats(prev)
 	}
 
-	// Cache PU from contextID for management and policy updates
+	// Cache PU from contextID for management and policy updates. The PU
+	// gets the address sets before, so that it misses none of the updates.
+	d.addressSetsLock.Lock()
+	for name, addresses := range d.addressSets {
+		if err := pu.UpdateAddressSet(name, addresses); err != nil {
+			logging.Named("datapath").Warn("Unable to set address set",
+				zap.String("contextID", contextID),
+				zap.String("addressSet", name),
+				zap.Error(err),
+			)
+		}
+	}
 	d.puFromContextID.AddOrUpdate(contextID, pu)
+	d.addressSetsLock.Unlock()
+
+	// Drop the contexts of the previous policy
+	d.contextCache.invalidate(contextID)
+
+	// Resume the UDP connections that were established before a restart
+	d.restoreUDPConnections(pu)
+
+	// Tear down the flows that the updated policy denies
+	if puInfo.Policy.RevokeDeniedFlows() {
+		d.revokeDeniedFlows(pu)
+	}
 
 	return nil
 }
@@ -345,9 +517,15 @@ func (d *Datapath) Unenforce(contextID string) error {
 	// Cleanup the IP based lookup
 	pu := puContext.(*pucontext.PUContext)
 
+	// Stop the DNS resolutions of the PU
+	pu.CancelFunc()
+
+	// Report the DNS activity that was not reported yet
+	d.reportDNSStats(pu)
+
 	// Cleanup the mark information
 	if err := d.puFromMark.Remove(pu.Mark()); err != nil {
-		zap.L().Debug("Unable to remove cache entry during unenforcement",
+		logging.Named("datapath").Debug("Unable to remove cache entry during unenforcement",
 			zap.String("Mark", pu.Mark()),
 			zap.Error(err),
 		)
@@ -356,7 +534,7 @@ func (d *Datapath) Unenforce(contextID string) error {
 	// Cleanup the port cache
 	for _, port := range pu.TCPPorts() {
 		if err := d.contextIDFromTCPPort.RemoveStringPorts(port); err != nil {
-			zap.L().Debug("Unable to remove cache entry during unenforcement",
+			logging.Named("datapath").Debug("Unable to remove cache entry during unenforcement",
 				zap.String("TCPPort", port),
 				zap.Error(err),
 			)
@@ -365,7 +543,7 @@ func (d *Datapath) Unenforce(contextID string) error {
 
 	for _, port := range pu.UDPPorts() {
 		if err := d.contextIDFromUDPPort.RemoveStringPorts(port); err != nil {
-			zap.L().Debug("Unable to remove cache entry during unenforcement",
+			logging.Named("datapath").Debug("Unable to remove cache entry during unenforcement",
 				zap.String("UDPPort", port),
 				zap.Error(err),
 			)
@@ -374,12 +552,17 @@ func (d *Datapath) Unenforce(contextID string) error {
 
 	// Cleanup the contextID cache
 	if err := d.puFromContextID.RemoveWithDelay(contextID, 10*time.Second); err != nil {
-		zap.L().Warn("Unable to remove context from cache",
+		logging.Named("datapath").Warn("Unable to remove context from cache",
 			zap.String("contextID", contextID),
 			zap.Error(err),
 		)
 	}
 
+	d.contextCache.invalidate(contextID)
+
+	// The flows of the PU cannot be revoked anymore
+	d.forgetMarkedFlows(contextID)
+
 	return nil
 }
 
@@ -389,9 +572,46 @@ func (d *Datapath) SetTargetNetworks(networks []string) error {
 		networks = []string{"0.0.0.0/1", "128.0.0.0/1"}
 	}
 
-	d.targetNetworks = acls.NewACLCache()
+	targetNetworks := acls.NewACLCache()
 	targetacl := createPolicy(networks)
-	return d.targetNetworks.AddRuleList(targetacl)
+	if err := targetNetworks.AddRuleList(targetacl); err != nil {
+		return err
+	}
+
+	d.targetNetworks = targetNetworks
+	return nil
+}
+
+// SetUDPHandshakeLimits sets the maximum number of half open UDP connections
+// of the enforcer and of every PU. A limit of 0 disables the check.
+func (d *Datapath) SetUDPHandshakeLimits(total, perPU int) {
+
+	d.udpHandshakes.setLimits(total, perPU)
+}
+
+// SetContextCacheSize sets the number of contexts cached for the lookups of
+// the packets. The cache is emptied. A size of 0 disables the cache.
+func (d *Datapath) SetContextCacheSize(size int) {
+
+	d.contextCache.setSize(size)
+}
+
+// SetFailureMode sets the decision of the datapath for the packets of a
+// failure class. All the classes fail closed by default.
+func (d *Datapath) SetFailureMode(class FailureClass, action FailureAction) {
+
+	bit := uint32(1) << uint(class)
+
+	for {
+		classes := atomic.LoadUint32(&d.failOpenClasses)
+		updated := classes &^ bit
+		if action == FailOpen {
+			updated = classes | bit
+		}
+		if atomic.CompareAndSwapUint32(&d.failOpenClasses, classes, updated) {
+			return
+		}
+	}
 }
 
 // GetFilterQueue returns the filter queues used by the data path
@@ -409,12 +629,71 @@ func (d *Datapath) GetPortSetInstance() portset.PortSet {
 // Run starts the application and network interceptors
 func (d *Datapath) Run(ctx context.Context) error {
 
-	zap.L().Debug("Start enforcer", zap.Int("mode", int(d.mode)))
+	logging.Named("datapath").Debug("Start enforcer", zap.Int("mode", int(d.mode)))
 
 	d.startApplicationInterceptor(ctx)
 	d.startNetworkInterceptor(ctx)
 
 	go d.nflogger.Run(ctx)
+	go d.reportDNSStatsPeriodically(ctx)
+	go d.nfqProbe.run(ctx, nfqProbeInterval)
+	go d.expireUDPHandshakesPeriodically(ctx)
+
+	d.readyOnce.Do(func() {
+		close(d.ready)
+	})
+
+	return nil
+}
+
+// Ready returns a channel that is closed when the interceptors are started
+func (d *Datapath) Ready() <-chan struct{} {
+
+	return d.ready
+}
+
+// Healthy returns an error if the datapath cannot process packets
+func (d *Datapath) Healthy() error {
+
+	select {
+	case <-d.ready:
+	default:
+		return errors.New("datapath is not ready")
+	}
+
+	if failures := atomic.LoadUint32(&d.udpSocketConsecutiveFailures); failures >= udpSocketFailureThreshold {
+		return fmt.Errorf("raw socket writes are failing: %d consecutive failures", failures)
+	}
+
+	if err := d.nfqProbe.healthy(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ExportPUState returns what the datapath enforces for the PU as JSON: its
+// compiled ACLs, the selectors of its policy and the rules learned from DNS.
+func (d *Datapath) ExportPUState(contextID string) ([]byte, error) {
+
+	item, err := d.puFromContextID.Get(contextID)
+	if err != nil {
+		return nil, fmt.Errorf("contextid not found in enforcer: %s", err)
+	}
+
+	return json.Marshal(item.(*pucontext.PUContext).State())
+}
+
+// DrainPU stops the PU from accepting new connections, so that it can be
+// unenforced once its established connections are finished.
+func (d *Datapath) DrainPU(contextID string) error {
+
+	item, err := d.puFromContextID.Get(contextID)
+	if err != nil {
+		return fmt.Errorf("contextid not found in enforcer: %s", err)
+	}
+
+	item.(*pucontext.PUContext).Drain()
 
 	return nil
 }
@@ -443,6 +722,12 @@ func (d *Datapath) puInfoDelegate(contextID string) (ID string, tags *policy.Tag
 
 func (d *Datapath) reportFlow(p *packet.Packet, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, actual *policy.FlowPolicy) {
 
+	d.collector.CollectFlowEvent(flowRecord(p, sourceID, destID, context, mode, report, actual))
+}
+
+// flowRecord returns the flow record of a packet.
+func flowRecord(p *packet.Packet, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, actual *policy.FlowPolicy) *collector.FlowRecord {
+
 	c := &collector.FlowRecord{
 		ContextID: context.ID(),
 		Source: &collector.EndPoint{
@@ -461,29 +746,92 @@ func (d *Datapath) reportFlow(p *packet.Packet, sourceID string, destID string,
 		Action:     actual.Action,
 		DropReason: mode,
 		PolicyID:   actual.PolicyID,
+		SelectorID: actual.SelectorID,
+		ServiceID:  actual.ServiceID,
 		L4Protocol: p.IPProto,
 		Count:      1,
+		Direction:  flowDirection(p),
 	}
 
 	if report.ObserveAction.Observed() {
 		c.ObservedAction = report.Action
 		c.ObservedPolicyID = report.PolicyID
+		c.ObservedSelectorID = report.SelectorID
+	}
+
+	return c
+}
+
+// flowDirection returns the direction of the flow of a packet as seen by the
+// PU that reports it. The flows of the packets received from the network are
+// incoming and the flows of the packets sent by the application are
+// outgoing, except for the syn acks, which the server of the flow sends.
+func flowDirection(p *packet.Packet) collector.FlowDirection {
+
+	var incoming bool
+	switch p.PacketType() {
+	case packet.PacketTypeNetwork:
+		incoming = true
+	case packet.PacketTypeApplication:
+		incoming = false
+	default:
+		return collector.FlowDirectionUnknown
+	}
+
+	switch p.IPProto {
+	case packet.IPProtocolTCP:
+		if p.TCPFlags&packet.TCPSynAckMask == packet.TCPSynAckMask {
+			incoming = !incoming
+		}
+	case packet.IPProtocolUDP:
+		if p.GetUDPType() == packet.UDPSynAckMask {
+			incoming = !incoming
+		}
 	}
 
-	d.collector.CollectFlowEvent(c)
+	if incoming {
+		return collector.FlowDirectionIncoming
+	}
+
+	return collector.FlowDirectionOutgoing
 }
 
 // contextFromIP returns the PU context from the default IP if remote. Otherwise
 // it returns the context from the port or mark values of the packet. Synack
 // packets are again special and the flow is reversed. If a container doesn't supply
 // its IP information, we use the default IP. This will only work with remotes
-// and Linux processes.
+// and Linux processes. The marks and ports that no PU owns are resolved with
+// the mark resolver. The contexts of the PUs are cached until their policy
+// changes. The resolved marks are not cached, since a PU enforced later may
+// own the mark.
 func (d *Datapath) contextFromIP(app bool, packetIP string, mark string, port uint16, protocol uint8) (*pucontext.PUContext, error) {
 
 	if d.puFromIP != nil {
 		return d.puFromIP, nil
 	}
 
+	key := newContextKey(app, mark, port, protocol)
+	if pu, ok := d.contextCache.get(key); ok {
+		return pu, nil
+	}
+
+	pu, err := d.lookupContext(app, mark, port, protocol)
+	if err != nil {
+		if pu, rerr := d.resolveMark(mark); rerr == nil {
+			return pu, nil
+		}
+		return nil, err
+	}
+
+	d.contextCache.add(key, pu)
+
+	return pu, nil
+}
+
+// lookupContext finds the context of a packet in the caches of the marks and
+// ports of the PUs.
+func (d *Datapath) lookupContext(app bool, mark string, port uint16, protocol uint8) (*pucontext.PUContext, error) {
+
 	if app {
 		pu, err := d.puFromMark.Get(mark)
 		if err != nil {
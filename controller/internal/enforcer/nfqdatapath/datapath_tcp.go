@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -18,25 +19,51 @@ import (
 	"go.aporeto.io/trireme-lib/policy"
 	"go.aporeto.io/trireme-lib/utils/cache"
 	"go.aporeto.io/trireme-lib/utils/cgnetcls"
+	"go.aporeto.io/trireme-lib/utils/logging"
 	"go.aporeto.io/trireme-lib/utils/portspec"
 )
 
+const (
+	// tcpFastOpenComponent is the component of the health events of the
+	// TCP fast open
+	tcpFastOpenComponent = "tcpfastopen"
+	// tcpFastOpenReportInterval is the interval between two reports of a PU
+	// whose connections had the fast open disabled
+	tcpFastOpenReportInterval = time.Minute
+)
+
 // processNetworkPackets processes packets arriving from network and are destined to the application
 func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 
 	if d.packetLogs {
-		zap.L().Debug("Processing network packet ",
+		logging.Named("datapath").Debug("Processing network packet ",
 			zap.String("flow", p.L4FlowHash()),
 			zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 		)
 
-		defer zap.L().Debug("Finished Processing network packet ",
+		defer logging.Named("datapath").Debug("Finished Processing network packet ",
 			zap.String("flow", p.L4FlowHash()),
 			zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 			zap.Error(err),
 		)
 	}
 
+	if d.bypassExcludedPort(p, false) {
+		return nil
+	}
+
+	if d.acceptFailedOpenFlow(p) {
+		return nil
+	}
+
+	if d.dropRevokedFlow(p) {
+		return errors.New("flow revoked by the policy")
+	}
+
+	defer func() {
+		err = d.failOpen(p, false, err)
+	}()
+
 	var conn *connection.TCPConnection
 
 	// Retrieve connection state of SynAck packets and
@@ -46,7 +73,7 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 		conn, err = d.netSynRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
-				zap.L().Debug("Packet rejected",
+				logging.Named("datapath").Debug("Packet rejected",
 					zap.String("flow", p.L4FlowHash()),
 					zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 					zap.Error(err),
@@ -61,11 +88,17 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 			return nil
 		}
 
+		// The flows initiated by the network are not enforced.
+		if !conn.Context.EnforcementDirection().Ingress() {
+			d.acceptUnenforcedFlow(p)
+			return nil
+		}
+
 	case packet.TCPSynAckMask:
 		conn, err = d.netSynAckRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
-				zap.L().Debug("SynAckPacket Ingored",
+				logging.Named("datapath").Debug("SynAckPacket Ingored",
 					zap.String("flow", p.L4FlowHash()),
 					zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 				)
@@ -74,10 +107,14 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 		}
 
 	default:
+		if d.unenforcedFlow(p) {
+			return nil
+		}
+
 		conn, err = d.netRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
-				zap.L().Debug("Packet rejected",
+				logging.Named("datapath").Debug("Packet rejected",
 					zap.String("flow", p.L4FlowHash()),
 					zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 					zap.Error(err),
@@ -95,7 +132,9 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 	if d.service != nil {
 		if !d.service.PreProcessTCPNetPacket(p, conn.Context, conn) {
 			p.Print(packet.PacketFailureService)
-			return errors.New("pre service processing failed for network packet")
+			f := newServiceFailure(conn.Context, false, conn.Auth.RemoteContextID, errors.New("pre service processing failed for network packet"))
+			f.authData = carriesAuthData(p, conn)
+			return f
 		}
 	}
 
@@ -106,13 +145,13 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 	if err != nil {
 		p.Print(packet.PacketFailureAuth)
 		if d.packetLogs {
-			zap.L().Debug("Rejecting packet ",
+			logging.Named("datapath").Debug("Rejecting packet ",
 				zap.String("flow", p.L4FlowHash()),
 				zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 				zap.Error(err),
 			)
 		}
-		return fmt.Errorf("packet processing failed for network packet: %s", err)
+		return wrapError("packet processing failed for network packet", err)
 	}
 
 	p.Print(packet.PacketStageService)
@@ -121,7 +160,7 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 		// PostProcessServiceInterface
 		if !d.service.PostProcessTCPNetPacket(p, action, claims, conn.Context, conn) {
 			p.Print(packet.PacketFailureService)
-			return errors.New("post service processing failed for network packet")
+			return newServiceFailure(conn.Context, false, conn.Auth.RemoteContextID, errors.New("post service processing failed for network packet"))
 		}
 
 		if conn.ServiceConnection && conn.TimeOut > 0 {
@@ -141,18 +180,34 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 
 	if d.packetLogs {
-		zap.L().Debug("Processing application packet ",
+		logging.Named("datapath").Debug("Processing application packet ",
 			zap.String("flow", p.L4FlowHash()),
 			zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 		)
 
-		defer zap.L().Debug("Finished Processing application packet ",
+		defer logging.Named("datapath").Debug("Finished Processing application packet ",
 			zap.String("flow", p.L4FlowHash()),
 			zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 			zap.Error(err),
 		)
 	}
 
+	if d.bypassExcludedPort(p, true) {
+		return nil
+	}
+
+	if d.acceptFailedOpenFlow(p) {
+		return nil
+	}
+
+	if d.dropRevokedFlow(p) {
+		return errors.New("flow revoked by the policy")
+	}
+
+	defer func() {
+		err = d.failOpen(p, true, err)
+	}()
+
 	var conn *connection.TCPConnection
 
 	switch p.TCPFlags & packet.TCPSynAckMask {
@@ -160,7 +215,7 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 		conn, err = d.appSynRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
-				zap.L().Debug("Packet rejected",
+				logging.Named("datapath").Debug("Packet rejected",
 					zap.String("flow", p.L4FlowHash()),
 					zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 					zap.Error(err),
@@ -168,16 +223,26 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 			}
 			return err
 		}
+
+		// The flows initiated by the PU are not enforced.
+		if !conn.Context.EnforcementDirection().Egress() {
+			d.acceptUnenforcedFlow(p)
+			return nil
+		}
 	case packet.TCPSynAckMask:
 		conn, err = d.appRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
-				zap.L().Debug("SynAckPacket Ignored",
+				logging.Named("datapath").Debug("SynAckPacket Ignored",
 					zap.String("flow", p.L4FlowHash()),
 					zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 				)
 			}
 
+			if d.unenforcedFlow(p) {
+				return nil
+			}
+
 			if p.Mark == strconv.Itoa(cgnetcls.Initialmarkval-1) {
 				//SYN ACK came through the global rule.
 				//This not from a process we are monitoring
@@ -187,10 +252,14 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 			return err
 		}
 	default:
+		if d.unenforcedFlow(p) {
+			return nil
+		}
+
 		conn, err = d.appRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
-				zap.L().Debug("Packet rejected",
+				logging.Named("datapath").Debug("Packet rejected",
 					zap.String("flow", p.L4FlowHash()),
 					zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 					zap.Error(err),
@@ -209,7 +278,7 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 		// PreProcessServiceInterface
 		if !d.service.PreProcessTCPAppPacket(p, conn.Context, conn) {
 			p.Print(packet.PacketFailureService)
-			return errors.New("pre service processing failed for application packet")
+			return newServiceFailure(conn.Context, true, conn.Auth.RemoteContextID, errors.New("pre service processing failed for application packet"))
 		}
 	}
 
@@ -219,7 +288,7 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 	action, err := d.processApplicationTCPPacket(p, conn.Context, conn)
 	if err != nil {
 		if d.packetLogs {
-			zap.L().Debug("Dropping packet  ",
+			logging.Named("datapath").Debug("Dropping packet  ",
 				zap.String("flow", p.L4FlowHash()),
 				zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 				zap.Error(err),
@@ -235,7 +304,7 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 		// PostProcessServiceInterface
 		if !d.service.PostProcessTCPAppPacket(p, action, conn.Context, conn) {
 			p.Print(packet.PacketFailureService)
-			return errors.New("post service processing failed for application packet")
+			return newServiceFailure(conn.Context, true, conn.Auth.RemoteContextID, errors.New("post service processing failed for application packet"))
 		}
 	}
 
@@ -273,18 +342,34 @@ func (d *Datapath) processApplicationTCPPacket(tcpPacket *packet.Packet, context
 // processApplicationSynPacket processes a single Syn Packet
 func (d *Datapath) processApplicationSynPacket(tcpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.TCPConnection) (interface{}, error) {
 
+	if context.Draining() {
+		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), collector.DefaultEndPoint, context, collector.PUDraining, nil, nil)
+		return nil, fmt.Errorf("Syn packet dropped because the PU is draining")
+	}
+
 	// If the packet is not in target networks then look into the external services application cache to
 	// make a decision whether the packet should be forwarded. For target networks with external services
 	// network syn/ack accepts the packet if it belongs to external services.
-	_, pkt, perr := d.targetNetworks.GetMatchingAction(tcpPacket.DestinationAddress.To4(), tcpPacket.DestinationPort)
+	// The target networks of the PU replace the ones of the enforcer.
+	targetNetworks := d.targetNetworks
+	if networks := context.TargetNetworks(); networks != nil {
+		targetNetworks = networks
+	}
+
+	_, pkt, perr := targetNetworks.GetMatchingAction(tcpPacket.DestinationAddress.To4(), tcpPacket.DestinationPort, tcpPacket.SourcePort)
 
 	if perr != nil {
-		report, policy, perr := context.ApplicationACLPolicyFromAddr(tcpPacket.DestinationAddress.To4(), tcpPacket.DestinationPort)
+		report, policy, perr := d.applicationACLPolicyFromAddr(context, tcpPacket.DestinationAddress.To4(), tcpPacket.DestinationPort, tcpPacket.SourcePort, "TCP")
 
 		if perr == nil && policy.Action.Accepted() {
 			return nil, nil
 		}
 
+		// The flow may still be accepted by the server name of its ClientHello.
+		if d.holdServerNameFlow(context, conn, tcpPacket) {
+			return nil, nil
+		}
+
 		d.reportExternalServiceFlow(context, report, pkt, true, tcpPacket)
 		return nil, fmt.Errorf("No acls found for external services. Dropping application syn packet %v", perr.Error())
 	}
@@ -295,6 +380,10 @@ func (d *Datapath) processApplicationSynPacket(tcpPacket *packet.Packet, context
 		return policy, nil
 	}
 
+	// The token is the data of the Syn packet, which leaves no room for the
+	// data of the fast open.
+	d.disableTCPFastOpen(context, tcpPacket)
+
 	// We are now processing as a Trireme packet that needs authorization headers
 	// Create TCP Option
 	tcpOptions := d.createTCPAuthenticationOption([]byte{})
@@ -326,15 +415,15 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 	// We can also clean up the state since we are not going to see any more
 	// packets from this connection.
 	if conn.GetState() == connection.TCPData && !conn.ServiceConnection {
-		if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		if err := d.updateConntrackMark(
 			tcpPacket.SourceAddress.String(),
 			tcpPacket.DestinationAddress.String(),
 			tcpPacket.IPProto,
 			tcpPacket.SourcePort,
 			tcpPacket.DestinationPort,
-			constants.DefaultConnMark,
+			d.filterQueue.GetConnMark(),
 		); err != nil {
-			zap.L().Error("Failed to update conntrack entry for flow",
+			logging.Named("datapath").Error("Failed to update conntrack entry for flow",
 				zap.String("context", string(conn.Auth.LocalContext)),
 				zap.String("app-conn", tcpPacket.L4ReverseFlowHash()),
 				zap.String("state", fmt.Sprintf("%d", conn.GetState())),
@@ -345,7 +434,7 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 		err2 := d.appReplyConnectionTracker.Remove(tcpPacket.L4FlowHash())
 
 		if err1 != nil || err2 != nil {
-			zap.L().Debug("Failed to remove cache entries")
+			logging.Named("datapath").Debug("Failed to remove cache entries")
 		}
 
 		return nil, nil
@@ -397,39 +486,61 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 
 		conn.SetState(connection.TCPAckSend)
 
+		// The service marks its sockets with the mark of the connection.
+		if conn.ServiceConnection {
+			conn.SetConnMark(d.filterQueue.GetConnMark())
+		}
+
 		// If its not a service connection, we release it to the kernel. Subsequent
 		// packets after the first data packet, that might be already in the queue
 		// will be transmitted through the kernel directly. Service connections are
 		// delegated to the service module
 		if !conn.ServiceConnection && tcpPacket.SourceAddress.String() != tcpPacket.DestinationAddress.String() {
-			if err := d.conntrackHdl.ConntrackTableUpdateMark(
+			if err := d.updateConntrackMark(
 				tcpPacket.SourceAddress.String(),
 				tcpPacket.DestinationAddress.String(),
 				tcpPacket.IPProto,
 				tcpPacket.SourcePort,
 				tcpPacket.DestinationPort,
-				constants.DefaultConnMark,
+				d.filterQueue.GetConnMark(),
 			); err != nil {
-				zap.L().Error("Failed to update conntrack table for flow",
+				logging.Named("datapath").Error("Failed to update conntrack table for flow",
 					zap.String("context", string(conn.Auth.LocalContext)),
 					zap.String("app-conn", tcpPacket.L4ReverseFlowHash()),
 					zap.String("state", fmt.Sprintf("%d", conn.GetState())),
 				)
 			}
+
+			d.recordMarkedFlow(
+				context,
+				conn.RemoteTags,
+				false,
+				tcpPacket.L4ReverseFlowHash(),
+				tcpPacket.SourceAddress.String(),
+				tcpPacket.DestinationAddress.String(),
+				tcpPacket.IPProto,
+				tcpPacket.SourcePort,
+				tcpPacket.DestinationPort,
+			)
 		}
 
 		return nil, nil
 	}
 
+	if conn.GetState() == connection.TCPServerNamePending {
+		return nil, d.inspectServerName(context, conn, tcpPacket)
+	}
+
 	// If we are already in the connection.TCPData connection just forward the packet
 	if conn.GetState() == connection.TCPData {
+		d.closeControlFlow(context, tcpPacket)
 		return nil, nil
 	}
 
 	if conn.GetState() == connection.UnknownState {
 		// Check if the destination is in the external servicess approved cache
 		// and if yes, allow the packet to go and release the flow.
-		_, policy, perr := context.ApplicationACLPolicyFromAddr(tcpPacket.DestinationAddress.To4(), tcpPacket.DestinationPort)
+		_, policy, perr := d.applicationACLPolicyFromAddr(context, tcpPacket.DestinationAddress.To4(), tcpPacket.DestinationPort, tcpPacket.SourcePort, "TCP")
 
 		if perr != nil {
 			err := tcpPacket.ConvertAcktoFinAck()
@@ -440,15 +551,15 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 			return nil, errors.New("Reject the packet")
 		}
 
-		if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		if err := d.updateConntrackMark(
 			tcpPacket.SourceAddress.String(),
 			tcpPacket.DestinationAddress.String(),
 			tcpPacket.IPProto,
 			tcpPacket.SourcePort,
 			tcpPacket.DestinationPort,
-			constants.DefaultConnMark,
+			d.filterQueue.GetConnMark(),
 		); err != nil {
-			zap.L().Error("Failed to update conntrack entry for flow",
+			logging.Named("datapath").Error("Failed to update conntrack entry for flow",
 				zap.String("context", string(conn.Auth.LocalContext)),
 				zap.String("app-conn", tcpPacket.L4ReverseFlowHash()),
 				zap.String("state", fmt.Sprintf("%d", conn.GetState())),
@@ -495,6 +606,17 @@ func (d *Datapath) processNetworkTCPPacket(tcpPacket *packet.Packet, context *pu
 // processNetworkSynPacket processes a syn packet arriving from the network
 func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *connection.TCPConnection, tcpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
+	// Retransmissions of an accepted syn are not counted again.
+	if conn.GetState() == connection.TCPSynSend && context.Draining() {
+		d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.PUDraining, nil, nil)
+		return nil, nil, fmt.Errorf("Syn packet dropped because the PU is draining")
+	}
+
+	if conn.GetState() == connection.TCPSynSend && !context.AllowConnection(tcpPacket.SourceAddress.String()) {
+		d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.RateLimited, nil, nil)
+		return nil, nil, fmt.Errorf("Syn packet dropped because of rate limit")
+	}
+
 	// Incoming packets that don't have our options are candidates to be processed
 	// as external services.
 	if err = tcpPacket.CheckTCPAuthenticationOption(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
@@ -519,18 +641,16 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 	// If the token signature is not valid, we must drop the connection and we drop the Syn packet.
 	// The source will retry but we have no state to maintain here.
 	if err != nil {
-		d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
-		return nil, nil, fmt.Errorf("Syn packet dropped because of invalid token: %s", err)
+		return nil, nil, d.tcpTokenFailure(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, fmt.Errorf("Syn packet dropped because of invalid token: %s", err))
 	}
 
 	// if there are no claims we must drop the connection and we drop the Syn
 	// packet. The source will retry but we have no state to maintain here.
 	if claims == nil {
-		d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
-		return nil, nil, errors.New("Syn packet dropped because of no claims")
+		return nil, nil, d.tcpTokenFailure(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, errors.New("Syn packet dropped because of no claims"))
 	}
 
-	txLabel, ok := claims.T.Get(enforcerconstants.TransmitterLabel)
+	txLabel, ok := claims.Transmitter()
 	if err := tcpPacket.CheckTCPAuthenticationOption(enforcerconstants.TCPAuthenticationOptionBaseLen); !ok || err != nil {
 		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
 		return nil, nil, fmt.Errorf("TCP authentication option not found: %s", err)
@@ -545,10 +665,8 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 
 	tcpPacket.DropDetachedBytes()
 
-	// Add the port as a label with an @ prefix. These labels are invalid otherwise
 	// If all policies are restricted by port numbers this will allow port-specific policies
-	tags := claims.T.Copy()
-	tags.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(tcpPacket.DestinationPort)))
+	tags := claims.TagsWithPort(tcpPacket.DestinationPort)
 
 	report, pkt := context.SearchRcvRules(tags)
 	if pkt.Action.Rejected() {
@@ -568,6 +686,7 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 	// Cache the action
 	conn.ReportFlowPolicy = report
 	conn.PacketFlowPolicy = pkt
+	conn.RemoteTags = tags
 
 	// Accept the connection
 	return pkt, claims, nil
@@ -585,15 +704,23 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 	// Packets with no authorization are processed as external services based on the ACLS
 	if err = tcpPacket.CheckTCPAuthenticationOption(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
 
+		// The flow waits for the server name of its ClientHello.
+		if conn.GetState() == connection.TCPServerNamePending {
+			d.acceptServerNameSynAck(conn, tcpPacket)
+			return nil, nil, nil
+		}
+
 		flowHash := tcpPacket.SourceAddress.String() + ":" + strconv.Itoa(int(tcpPacket.SourcePort))
 		if plci, plerr := context.RetrieveCachedExternalFlowPolicy(flowHash); plerr == nil {
 			plc := plci.(*policyPair)
-			d.releaseFlow(context, plc.report, plc.packet, tcpPacket)
+			if !d.holdControlFlow(context, conn, plc.report, plc.packet, tcpPacket) {
+				d.releaseFlow(context, plc.report, plc.packet, tcpPacket)
+			}
 			return plc.packet, nil, nil
 		}
 
 		// Never seen this IP before, let's parse them.
-		report, pkt, perr := context.ApplicationACLPolicyFromAddr(tcpPacket.SourceAddress.To4(), tcpPacket.SourcePort)
+		report, pkt, perr := d.applicationACLPolicyFromAddr(context, tcpPacket.SourceAddress.To4(), tcpPacket.SourcePort, tcpPacket.DestinationPort, "TCP")
 		if perr != nil || pkt.Action.Rejected() {
 			d.reportReverseExternalServiceFlow(context, report, pkt, true, tcpPacket)
 			return nil, nil, fmt.Errorf("no auth or acls: drop synack packet and connection: %s: action=%d", perr, pkt.Action)
@@ -611,7 +738,9 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 		// Set the state to Data so the other state machines ignore subsequent packets
 		conn.SetState(connection.TCPData)
 
-		d.releaseFlow(context, report, pkt, tcpPacket)
+		if !d.holdControlFlow(context, conn, report, pkt, tcpPacket) {
+			d.releaseFlow(context, report, pkt, tcpPacket)
+		}
 
 		return pkt, nil, nil
 	}
@@ -623,7 +752,8 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 	if conn.GetState() != connection.TCPSynSend {
 
 		// Revert the connmarks - dealing with retransmissions
-		if cerr := d.conntrackHdl.ConntrackTableUpdateMark(
+		d.markedFlows.Remove(tcpPacket.L4FlowHash()) // nolint
+		if cerr := d.updateConntrackMark(
 			tcpPacket.DestinationAddress.String(),
 			tcpPacket.SourceAddress.String(),
 			tcpPacket.IPProto,
@@ -631,7 +761,7 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 			tcpPacket.SourcePort,
 			0,
 		); cerr != nil {
-			zap.L().Error("Failed to update conntrack table for flow",
+			logging.Named("datapath").Error("Failed to update conntrack table for flow",
 				zap.String("context", string(conn.Auth.LocalContext)),
 				zap.String("app-conn", tcpPacket.L4ReverseFlowHash()),
 				zap.String("state", fmt.Sprintf("%d", conn.GetState())),
@@ -642,19 +772,16 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 	// Now we can process the SynAck packet with its options
 	tcpData := tcpPacket.ReadTCPData()
 	if len(tcpData) == 0 {
-		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
-		return nil, nil, errors.New("SynAck packet dropped because of missing token")
+		return nil, nil, d.tcpTokenFailure(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, errors.New("SynAck packet dropped because of missing token"))
 	}
 
 	claims, err = d.tokenAccessor.ParsePacketToken(&conn.Auth, tcpPacket.ReadTCPData())
 	if err != nil {
-		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
-		return nil, nil, fmt.Errorf("SynAck packet dropped because of bad claims: %s", err)
+		return nil, nil, d.tcpTokenFailure(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, fmt.Errorf("SynAck packet dropped because of bad claims: %s", err))
 	}
 
 	if claims == nil {
-		d.reportRejectedFlow(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
-		return nil, nil, errors.New("SynAck packet dropped because of no claims")
+		return nil, nil, d.tcpTokenFailure(tcpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, errors.New("SynAck packet dropped because of no claims"))
 	}
 
 	tcpPacket.ConnectionMetadata = &conn.Auth
@@ -672,8 +799,10 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 
 	tcpPacket.DropDetachedBytes()
 
-	if !d.mutualAuthorization {
+	mutualAuthorization := context.MutualAuthorization(d.mutualAuthorization)
+	if !mutualAuthorization {
 		// If we dont do mutual authorization, dont lookup txt rules.
+		d.reportRelaxedMutualAuthFlow(tcpPacket, context, conn.Auth.RemoteContextID, claims)
 		conn.SetState(connection.TCPSynAckReceived)
 
 		// conntrack
@@ -681,12 +810,13 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 		return nil, claims, nil
 	}
 
-	report, pkt := context.SearchTxtRules(claims.T, !d.mutualAuthorization)
+	report, pkt := context.SearchTxtRules(claims.T, !mutualAuthorization)
 	if pkt.Action.Rejected() {
 		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.PolicyDrop, report, pkt)
 		return nil, nil, fmt.Errorf("dropping because of reject rule on transmitter: %s", claims.T.String())
 	}
 
+	conn.RemoteTags = claims.T
 	conn.SetState(connection.TCPSynAckReceived)
 
 	// conntrack
@@ -698,6 +828,12 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *connection.TCPConnection, tcpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
 	if conn.GetState() == connection.TCPData || conn.GetState() == connection.TCPAckSend {
+		d.inspectControlFlow(context, tcpPacket)
+		return nil, nil, nil
+	}
+
+	// The server can't send data before the ClientHello is complete.
+	if conn.GetState() == connection.TCPServerNamePending {
 		return nil, nil, nil
 	}
 
@@ -719,15 +855,15 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 			return nil, nil, errors.New("Reject the packet")
 		}
 
-		if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		if err := d.updateConntrackMark(
 			tcpPacket.DestinationAddress.String(),
 			tcpPacket.SourceAddress.String(),
 			tcpPacket.IPProto,
 			tcpPacket.DestinationPort,
 			tcpPacket.SourcePort,
-			constants.DefaultConnMark,
+			d.filterQueue.GetConnMark(),
 		); err != nil {
-			zap.L().Error("Failed to update conntrack entry for flow",
+			logging.Named("datapath").Error("Failed to update conntrack entry for flow",
 				zap.String("context", string(conn.Auth.LocalContext)),
 				zap.String("app-conn", tcpPacket.L4ReverseFlowHash()),
 				zap.String("state", fmt.Sprintf("%d", conn.GetState())),
@@ -747,8 +883,7 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 		}
 
 		if _, err := d.tokenAccessor.ParseAckToken(&conn.Auth, tcpPacket.ReadTCPData()); err != nil {
-			d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
-			return nil, nil, fmt.Errorf("Ack packet dropped because signature validation failed: %s", err)
+			return nil, nil, d.tcpTokenFailure(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, fmt.Errorf("Ack packet dropped because signature validation failed: %s", err))
 		}
 
 		// Remove any of our data - adjust the sequence numbers
@@ -761,7 +896,7 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 
 		if conn.PacketFlowPolicy != nil && conn.PacketFlowPolicy.Action.Rejected() {
 			if !conn.PacketFlowPolicy.ObserveAction.Observed() {
-				zap.L().Error("Flow rejected but not observed", zap.String("conn", context.ManagementID()))
+				logging.Named("datapath").Error("Flow rejected but not observed", zap.String("conn", context.ManagementID()))
 			}
 			// Flow has been allowed because we are observing a deny rule's impact on the system. Packets are forwarded, reported as dropped + observed.
 			d.reportRejectedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID(), context, collector.PolicyDrop, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
@@ -772,17 +907,34 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 
 		conn.SetState(connection.TCPData)
 
+		// The service marks its sockets with the mark of the connection.
+		if conn.ServiceConnection {
+			conn.SetConnMark(d.filterQueue.GetConnMark())
+		}
+
 		if !conn.ServiceConnection {
-			if err := d.conntrackHdl.ConntrackTableUpdateMark(
+			if err := d.updateConntrackMark(
 				tcpPacket.DestinationAddress.String(),
 				tcpPacket.SourceAddress.String(),
 				tcpPacket.IPProto,
 				tcpPacket.DestinationPort,
 				tcpPacket.SourcePort,
-				constants.DefaultConnMark,
+				d.filterQueue.GetConnMark(),
 			); err != nil {
-				zap.L().Error("Failed to update conntrack table after ack packet")
+				logging.Named("datapath").Error("Failed to update conntrack table after ack packet")
 			}
+
+			d.recordMarkedFlow(
+				context,
+				conn.RemoteTags,
+				true,
+				hash,
+				tcpPacket.DestinationAddress.String(),
+				tcpPacket.SourceAddress.String(),
+				tcpPacket.IPProto,
+				tcpPacket.DestinationPort,
+				tcpPacket.SourcePort,
+			)
 		}
 
 		// Accept the packet
@@ -795,7 +947,7 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 
 	// Everything else is dropped - ACK received in the Syn state without a SynAck
 	d.reportRejectedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID(), context, collector.InvalidState, nil, nil)
-	zap.L().Error("Invalid state reached",
+	logging.Named("datapath").Error("Invalid state reached",
 		zap.String("state", fmt.Sprintf("%d", conn.GetState())),
 		zap.String("context", context.ManagementID()),
 		zap.String("net-conn", hash),
@@ -804,6 +956,33 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 	return nil, nil, fmt.Errorf("Ack packet dropped, invalid duplicate state: %d", conn.GetState())
 }
 
+// disableTCPFastOpen removes the fast open option and data of an application
+// Syn packet. The peers complete a regular handshake and the data is sent
+// again. The PUs whose connections had the fast open disabled are reported
+// once per interval.
+func (d *Datapath) disableTCPFastOpen(context *pucontext.PUContext, tcpPacket *packet.Packet) {
+
+	disabled, err := tcpPacket.DisableTCPFastOpen()
+	if err != nil {
+		zap.L().Debug("Unable to parse the tcp options of the syn packet", zap.String("flow", tcpPacket.L4FlowHash()), zap.Error(err))
+		return
+	}
+
+	if !disabled {
+		return
+	}
+
+	if err := d.tcpFastOpenReports.Add(context.ManagementID(), nil); err != nil {
+		return
+	}
+
+	zap.L().Info("TCP fast open disabled for the enforced connections of the pu", zap.String("puID", context.ManagementID()))
+	d.collector.CollectHealthEvent(&collector.HealthRecord{
+		Component: tcpFastOpenComponent,
+		Message:   fmt.Sprintf("tcp fast open disabled for the enforced connections of pu %s", context.ManagementID()),
+	})
+}
+
 // createTCPAuthenticationOption creates the TCP authentication option -
 func (d *Datapath) createTCPAuthenticationOption(token []byte) []byte {
 
@@ -823,7 +1002,7 @@ func (d *Datapath) appSynRetrieveState(p *packet.Packet) (*connection.TCPConnect
 
 	context, err := d.contextFromIP(true, p.SourceAddress.String(), p.Mark, p.SourcePort, packet.IPProtocolTCP)
 	if err != nil {
-		return nil, errors.New("No context in app processing")
+		return nil, newNoContextFailure(collector.InvalidContext, errors.New("No context in app processing"))
 	}
 
 	if conn, err := d.appOrigConnectionTracker.GetReset(p.L4FlowHash(), 0); err == nil {
@@ -885,7 +1064,7 @@ func (d *Datapath) appRetrieveState(p *packet.Packet) (*connection.TCPConnection
 				// Let's try if its an existing connection
 				context, err := d.contextFromIP(true, p.SourceAddress.String(), p.Mark, p.SourcePort, packet.IPProtocolTCP)
 				if err != nil {
-					return nil, errors.New("No context in app processing")
+					return nil, newNoContextFailure(collector.InvalidContext, errors.New("No context in app processing"))
 				}
 				conn = connection.NewTCPConnection(context)
 				conn.(*connection.TCPConnection).SetState(connection.UnknownState)
@@ -941,7 +1120,9 @@ func (d *Datapath) netSynRetrieveState(p *packet.Packet) (*connection.TCPConnect
 			return nil, nil
 		}
 
-		return nil, errors.New("no context in net processing")
+		f := newNoContextFailure(collector.InvalidContext, errors.New("no context in net processing"))
+		f.authData = true
+		return nil, f
 	}
 
 	if conn, err := d.netOrigConnectionTracker.GetReset(p.L4FlowHash(), 0); err == nil {
@@ -957,7 +1138,7 @@ func (d *Datapath) netSynAckRetrieveState(p *packet.Packet) (*connection.TCPConn
 	conn, err := d.sourcePortConnectionCache.GetReset(p.SourcePortHash(packet.PacketTypeNetwork), 0)
 	if err != nil {
 		if d.packetLogs {
-			zap.L().Debug("No connection for SynAck packet ",
+			logging.Named("datapath").Debug("No connection for SynAck packet ",
 				zap.String("flow", p.L4FlowHash()),
 			)
 		}
@@ -978,7 +1159,7 @@ func (d *Datapath) netRetrieveState(p *packet.Packet) (*connection.TCPConnection
 				// Let's try if its an existing connection
 				context, cerr := d.contextFromIP(false, p.DestinationAddress.String(), p.Mark, p.DestinationPort, packet.IPProtocolTCP)
 				if cerr != nil {
-					return nil, errors.New("No context in app processing")
+					return nil, newNoContextFailure(collector.InvalidContext, errors.New("No context in app processing"))
 				}
 				conn = connection.NewTCPConnection(context)
 				conn.(*connection.TCPConnection).SetState(connection.UnknownState)
@@ -1014,22 +1195,22 @@ func updateTimer(c cache.DataStore, hash string, conn *connection.TCPConnection)
 func (d *Datapath) releaseFlow(context *pucontext.PUContext, report *policy.FlowPolicy, action *policy.FlowPolicy, tcpPacket *packet.Packet) {
 
 	if err := d.appOrigConnectionTracker.Remove(tcpPacket.L4FlowHash()); err != nil {
-		zap.L().Debug("Failed to clean cache appOrigConnectionTracker", zap.Error(err))
+		logging.Named("datapath").Debug("Failed to clean cache appOrigConnectionTracker", zap.Error(err))
 	}
 
 	if err := d.sourcePortConnectionCache.Remove(tcpPacket.SourcePortHash(packet.PacketTypeApplication)); err != nil {
-		zap.L().Debug("Failed to clean cache sourcePortConnectionCache", zap.Error(err))
+		logging.Named("datapath").Debug("Failed to clean cache sourcePortConnectionCache", zap.Error(err))
 	}
 
-	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+	if err := d.updateConntrackMark(
 		tcpPacket.DestinationAddress.String(),
 		tcpPacket.SourceAddress.String(),
 		tcpPacket.IPProto,
 		tcpPacket.DestinationPort,
 		tcpPacket.SourcePort,
-		constants.DefaultConnMark,
+		d.filterQueue.GetConnMark(),
 	); err != nil {
-		zap.L().Error("Failed to update conntrack table", zap.Error(err))
+		logging.Named("datapath").Error("Failed to update conntrack table", zap.Error(err))
 	}
 
 	d.reportReverseExternalServiceFlow(context, report, action, true, tcpPacket)
@@ -2,9 +2,12 @@ package nfqdatapath
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"net"
+	"os"
 	"reflect"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -1477,6 +1480,66 @@ func TestDoCreatePU(t *testing.T) {
 	})
 }
 
+func TestExportPUState(t *testing.T) {
+
+	Convey("Given an initialized enforcer", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		Convey("When the pu is not enforced, I should get an error", func() {
+			_, err := enforcer.ExportPUState("pu")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When a pu is enforced, I should get its compiled rules and selectors", func() {
+			netACLs := policy.IPRuleList{
+				{
+					Address:  "10.0.0.0/8",
+					Port:     "443",
+					Protocol: "tcp",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "https"},
+				},
+			}
+			plc := policy.NewPUPolicy("pu", policy.Police, nil, netACLs, nil, nil, nil, nil, nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+			plc.AddIdentityTag("app", "web")
+			plc.AddReceiverRules(policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{Key: "app", Value: []string{"db"}, Operator: policy.Equal},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "nodb"},
+				ID:     "selector",
+			})
+			puInfo := policy.PUInfoFromPolicyAndRuntime("pu", plc, policy.NewPURuntimeWithDefaults())
+			So(enforcer.Enforce("pu", puInfo), ShouldBeNil)
+
+			data, err := enforcer.ExportPUState("pu")
+			So(err, ShouldBeNil)
+
+			state := &pucontext.State{}
+			So(json.Unmarshal(data, state), ShouldBeNil)
+			So(state.ContextID, ShouldEqual, "pu")
+			So(state.Identity, ShouldContain, "app=web")
+			So(len(state.NetworkACLs.Accept), ShouldEqual, 1)
+			So(state.NetworkACLs.Accept[0].Address, ShouldEqual, "10.0.0.0/8")
+			So(state.NetworkACLs.Accept[0].Policy.PolicyID, ShouldEqual, "https")
+			So(len(state.Receiver.Reject), ShouldEqual, 1)
+			So(state.Receiver.Reject[0].ID, ShouldEqual, "selector")
+			So(state.Transmitter.Reject, ShouldBeEmpty)
+		})
+	})
+}
+
 func TestContextFromIP(t *testing.T) {
 
 	Convey("Given an initialized enforcer for Linux Processes", t, func() {
@@ -1544,6 +1607,150 @@ func TestContextFromIP(t *testing.T) {
 	})
 }
 
+func TestContextFromIPCache(t *testing.T) {
+
+	Convey("Given an initialized enforcer for Linux Processes with a PU", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.LocalServer, "/proc", []string{"0.0.0.0/0"})
+		enforcer.mode = constants.LocalServer
+		contextID := "123"
+		puInfo := policy.NewPUInfo(contextID, common.LinuxProcessPU)
+
+		spec, _ := portspec.NewPortSpecFromString("80", nil)
+		puInfo.Runtime.SetOptions(policy.OptionsType{
+			CgroupMark: "100",
+			Services: []common.Service{
+				common.Service{
+					Protocol: uint8(6),
+					Ports:    spec,
+				},
+			},
+		})
+
+		So(enforcer.Enforce(contextID, puInfo), ShouldBeNil)
+
+		appCtx, err := enforcer.contextFromIP(true, "20.1.1.1", "100", 0, packet.IPProtocolTCP)
+		So(err, ShouldBeNil)
+		netCtx, err := enforcer.contextFromIP(false, "20.1.1.1", "", 80, packet.IPProtocolTCP)
+		So(err, ShouldBeNil)
+		So(netCtx, ShouldEqual, appCtx)
+
+		Convey("The contexts should be cached", func() {
+			pu, ok := enforcer.contextCache.get(newContextKey(true, "100", 0, packet.IPProtocolTCP))
+			So(ok, ShouldBeTrue)
+			So(pu, ShouldEqual, appCtx)
+
+			pu, ok = enforcer.contextCache.get(newContextKey(false, "", 80, packet.IPProtocolTCP))
+			So(ok, ShouldBeTrue)
+			So(pu, ShouldEqual, netCtx)
+		})
+
+		Convey("When the policy of the PU is updated", func() {
+			So(enforcer.Enforce(contextID, puInfo), ShouldBeNil)
+
+			Convey("The lookups should return the new context", func() {
+				_, ok := enforcer.contextCache.get(newContextKey(true, "100", 0, packet.IPProtocolTCP))
+				So(ok, ShouldBeFalse)
+
+				ctx, err := enforcer.contextFromIP(true, "20.1.1.1", "100", 0, packet.IPProtocolTCP)
+				So(err, ShouldBeNil)
+				So(ctx, ShouldNotEqual, appCtx)
+
+				ctx, err = enforcer.contextFromIP(false, "20.1.1.1", "", 80, packet.IPProtocolTCP)
+				So(err, ShouldBeNil)
+				So(ctx, ShouldNotEqual, netCtx)
+			})
+		})
+
+		Convey("When the PU is unenforced", func() {
+			So(enforcer.Unenforce(contextID), ShouldBeNil)
+
+			Convey("The lookups should fail", func() {
+				_, err := enforcer.contextFromIP(true, "20.1.1.1", "100", 0, packet.IPProtocolTCP)
+				So(err, ShouldNotBeNil)
+
+				_, err = enforcer.contextFromIP(false, "20.1.1.1", "", 80, packet.IPProtocolTCP)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the cache is disabled", func() {
+			enforcer.SetContextCacheSize(0)
+
+			Convey("The lookups should still succeed", func() {
+				ctx, err := enforcer.contextFromIP(false, "20.1.1.1", "", 80, packet.IPProtocolTCP)
+				So(err, ShouldBeNil)
+				So(ctx, ShouldEqual, netCtx)
+
+				_, ok := enforcer.contextCache.get(newContextKey(false, "", 80, packet.IPProtocolTCP))
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func benchmarkContextFromIP(b *testing.B, cacheSize int) {
+
+	prevRawSocket := GetUDPRawSocket
+	defer func() {
+		GetUDPRawSocket = prevRawSocket
+	}()
+	GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+		return nil, nil
+	}
+
+	secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+	enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.LocalServer, "/proc", []string{"0.0.0.0/0"})
+	enforcer.mode = constants.LocalServer
+	enforcer.SetContextCacheSize(cacheSize)
+
+	// Port ranges are searched linearly, and the port of the last PU is the
+	// worst case of the lookup.
+	for i := 0; i < 1000; i++ {
+		contextID := strconv.Itoa(i)
+		spec, _ := portspec.NewPortSpecFromString(strconv.Itoa(1000+i*10)+":"+strconv.Itoa(1009+i*10), nil)
+		puInfo := policy.NewPUInfo(contextID, common.LinuxProcessPU)
+		puInfo.Runtime.SetOptions(policy.OptionsType{
+			CgroupMark: strconv.Itoa(100 + i),
+			Services: []common.Service{
+				common.Service{
+					Protocol: uint8(6),
+					Ports:    spec,
+				},
+			},
+		})
+		if err := enforcer.Enforce(contextID, puInfo); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := enforcer.contextFromIP(false, "20.1.1.1", "", 10995, packet.IPProtocolTCP); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkContextFromIP(b *testing.B) {
+	benchmarkContextFromIP(b, defaultContextCacheSize)
+}
+
+func BenchmarkContextFromIPWithoutCache(b *testing.B) {
+	benchmarkContextFromIP(b, 0)
+}
+
 func TestInvalidPacket(t *testing.T) {
 	// collector := &collector.DefaultCollector{}
 	// secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
@@ -4936,3 +5143,1815 @@ func TestDNS(t *testing.T) {
 		So(err1, ShouldBeNil)
 	})
 }
+
+func TestDNSRulesPersistAcrossPolicyUpdate(t *testing.T) {
+	externalFQDN := "google.com"
+	var lock sync.Mutex
+
+	Convey("Given an initialized enforcer with a PU that learned a rule from DNS", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		resolve := true
+		origLookupHost := pucontext.LookupHost
+		defer func() {
+			pucontext.LookupHost = origLookupHost
+		}()
+
+		pucontext.LookupHost = func(name string) ([]string, error) {
+			defer lock.Unlock()
+			lock.Lock()
+			if resolve && name == externalFQDN {
+				return []string{"164.67.228.152"}, nil
+			}
+
+			return nil, fmt.Errorf("Error")
+		}
+
+		dnsRules := []policy.DNSRule{{
+			Name:     externalFQDN,
+			Port:     "80",
+			Protocol: "tcp",
+		}}
+
+		puID1 := "SomePU"
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"1.1.1.1/31"})
+		puInfo := policy.NewPUInfo(puID1, common.ContainerPU)
+		puInfo.Policy.UpdateDNSNetworks(dnsRules)
+
+		err := enforcer.Enforce(puID1, puInfo)
+		So(err, ShouldBeNil)
+		defer enforcer.Unenforce(puID1) // nolint errcheck
+
+		Convey("When the policy is updated and the name can no longer be resolved", func() {
+			lock.Lock()
+			resolve = false
+			lock.Unlock()
+
+			updated := policy.NewPUInfo(puID1, common.ContainerPU)
+			updated.Policy.UpdateDNSNetworks(dnsRules)
+
+			err := enforcer.Enforce(puID1, updated)
+			So(err, ShouldBeNil)
+
+			Convey("Then the learned rule should still be applied", func() {
+				item, err := enforcer.puFromContextID.Get(puID1)
+				So(err, ShouldBeNil)
+
+				_, action, err := item.(*pucontext.PUContext).ApplicationACLPolicyFromAddr(net.ParseIP("164.67.228.152").To4(), 80, 0)
+				So(err, ShouldBeNil)
+				So(action.Action.Accepted(), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestReadyAndHealthy(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		Convey("Before it is started it should not be ready or healthy", func() {
+			select {
+			case <-enforcer.Ready():
+				t.Error("enforcer must not be ready before it is started")
+			default:
+			}
+			So(enforcer.Healthy(), ShouldNotBeNil)
+		})
+
+		Convey("When it is marked ready it should be healthy", func() {
+			enforcer.readyOnce.Do(func() {
+				close(enforcer.ready)
+			})
+			<-enforcer.Ready()
+			So(enforcer.Healthy(), ShouldBeNil)
+		})
+	})
+}
+
+func TestPUTargetNetworks(t *testing.T) {
+
+	Convey("Given an enforcer with the target networks 0.0.0.0/0", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+		synPacket, err := PacketFlow.GetFirstSynPacket().ToBytes()
+		So(err, ShouldBeNil)
+		tcpPacket, err := packet.New(0, synPacket, "0", true)
+		So(err, ShouldBeNil)
+
+		newContext := func(networks []string) *pucontext.PUContext {
+			puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+			puInfo.Policy = policy.NewPUPolicy("pu", policy.Police, nil, nil, nil, nil, nil, nil, nil, nil, networks, []string{}, []string{}, nil, nil, []string{})
+			context, err := pucontext.NewPU("pu", puInfo, time.Second)
+			So(err, ShouldBeNil)
+			return context
+		}
+
+		Convey("When the PU has no target networks, the syn to any address should be authorized", func() {
+			context := newContext(nil)
+			So(context.TargetNetworks(), ShouldBeNil)
+
+			_, err := enforcer.processApplicationSynPacket(tcpPacket, context, connection.NewTCPConnection(context))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When the target networks of the PU don't include the destination, the syn should be dropped", func() {
+			context := newContext([]string{"10.0.0.0/8"})
+
+			_, err := enforcer.processApplicationSynPacket(tcpPacket, context, connection.NewTCPConnection(context))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the target networks of the PU include the destination, the syn should be authorized", func() {
+			context := newContext([]string{tcpPacket.DestinationAddress.String() + "/32"})
+
+			_, err := enforcer.processApplicationSynPacket(tcpPacket, context, connection.NewTCPConnection(context))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When the target networks of the PU are invalid, the context should not be created", func() {
+			puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+			puInfo.Policy = policy.NewPUPolicy("pu", policy.Police, nil, nil, nil, nil, nil, nil, nil, nil, []string{"invalid"}, []string{}, []string{}, nil, nil, []string{})
+			_, err := pucontext.NewPU("pu", puInfo, time.Second)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+type failingSocketWriter struct {
+	fail bool
+}
+
+func (w *failingSocketWriter) WriteSocket(buf []byte) error {
+	if w.fail {
+		return fmt.Errorf("write failed")
+	}
+	return nil
+}
+
+func (w *failingSocketWriter) CloseSocket() error {
+	return nil
+}
+
+func TestUDPSocketWriteFailures(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance with a failing raw socket", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		writer := &failingSocketWriter{fail: true}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		enforcer.readyOnce.Do(func() {
+			close(enforcer.ready)
+		})
+
+		Convey("When a write fails, the error should be returned and counted", func() {
+			So(enforcer.writeUDPSocket([]byte{}), ShouldNotBeNil)
+			So(enforcer.udpSocketWriteFailures, ShouldEqual, 1)
+			So(enforcer.udpSocketConsecutiveFailures, ShouldEqual, 1)
+			So(enforcer.Healthy(), ShouldBeNil)
+		})
+
+		Convey("When writes keep failing, the enforcer should not be healthy", func() {
+			for i := 0; i < udpSocketFailureThreshold; i++ {
+				So(enforcer.writeUDPSocket([]byte{}), ShouldNotBeNil)
+			}
+			So(enforcer.Healthy(), ShouldNotBeNil)
+
+			Convey("When a write succeeds again, the enforcer should recover", func() {
+				writer.fail = false
+				So(enforcer.writeUDPSocket([]byte{}), ShouldBeNil)
+				So(enforcer.udpSocketConsecutiveFailures, ShouldEqual, 0)
+				So(enforcer.udpSocketWriteFailures, ShouldEqual, udpSocketFailureThreshold)
+				So(enforcer.Healthy(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+type capturingSocketWriter struct {
+	sync.Mutex
+	packets [][]byte
+}
+
+func (w *capturingSocketWriter) WriteSocket(buf []byte) error {
+	w.Lock()
+	defer w.Unlock()
+
+	packet := make([]byte, len(buf))
+	copy(packet, buf)
+	w.packets = append(w.packets, packet)
+	return nil
+}
+
+func (w *capturingSocketWriter) CloseSocket() error {
+	return nil
+}
+
+func (w *capturingSocketWriter) count() int {
+	w.Lock()
+	defer w.Unlock()
+
+	return len(w.packets)
+}
+
+func (w *capturingSocketWriter) last() []byte {
+	w.Lock()
+	defer w.Unlock()
+
+	buffer := make([]byte, len(w.packets[len(w.packets)-1]))
+	copy(buffer, w.packets[len(w.packets)-1])
+	return buffer
+}
+
+type conntrackUpdate struct {
+	ipSrc    string
+	ipDst    string
+	protonum uint8
+	srcport  uint16
+	dstport  uint16
+	newmark  uint32
+}
+
+type capturingConntrack struct {
+	sync.Mutex
+	updates []conntrackUpdate
+	fail    bool
+}
+
+func (c *capturingConntrack) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.updates = append(c.updates, conntrackUpdate{
+		ipSrc:    ipSrc,
+		ipDst:    ipDst,
+		protonum: protonum,
+		srcport:  srcport,
+		dstport:  dstport,
+		newmark:  newmark,
+	})
+
+	if c.fail {
+		return fmt.Errorf("conntrack update failed")
+	}
+	return nil
+}
+
+func (c *capturingConntrack) calls() []conntrackUpdate {
+	c.Lock()
+	defer c.Unlock()
+
+	return append([]conntrackUpdate{}, c.updates...)
+}
+
+func newUDPTestPacket(src, dst string, sport, dport uint16, payload []byte) (*packet.Packet, error) {
+
+	buffer := make([]byte, packet.UDPDataPos+len(payload))
+	buffer[0] = 0x45
+	binary.BigEndian.PutUint16(buffer[2:4], uint16(len(buffer)))
+	buffer[8] = 64
+	buffer[9] = packet.IPProtocolUDP
+	copy(buffer[12:16], net.ParseIP(src).To4())
+	copy(buffer[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(buffer[20:22], sport)
+	binary.BigEndian.PutUint16(buffer[22:24], dport)
+	binary.BigEndian.PutUint16(buffer[24:26], uint16(8+len(payload)))
+	copy(buffer[packet.UDPDataPos:], payload)
+
+	return packet.New(packet.PacketTypeApplication, buffer, "0", true)
+}
+
+func TestUDPKeyRotation(t *testing.T) {
+
+	Convey("Given I have two enforcers with an established UDP connection", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		clientWriter := &capturingSocketWriter{}
+		serverWriter := &capturingSocketWriter{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return clientWriter, nil
+		}
+		client := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return serverWriter, nil
+		}
+		server := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		clientContext, err := pucontext.NewPU("client", policy.NewPUInfo("client", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+		serverContext, err := pucontext.NewPU("server", policy.NewPUInfo("server", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		clientConn := connection.NewUDPConnection(clientContext, clientWriter)
+		serverConn := connection.NewUDPConnection(serverContext, serverWriter)
+		clientConn.Auth.RemoteContext = serverConn.Auth.LocalContext
+		serverConn.Auth.RemoteContext = clientConn.Auth.LocalContext
+		clientConn.SetState(connection.UDPData)
+		serverConn.SetState(connection.UDPData)
+
+		clientLocal := clientConn.Auth.LocalContext
+		serverLocal := serverConn.Auth.LocalContext
+
+		appPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+		So(err, ShouldBeNil)
+		replyPacket, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 3000, 2000, []byte("data"))
+		So(err, ShouldBeNil)
+
+		client.udpAppOrigConnectionTracker.AddOrUpdate(appPacket.L4FlowHash(), clientConn)
+		client.udpNetReplyConnectionTracker.AddOrUpdate(replyPacket.L4FlowHash(), clientConn)
+		server.udpNetOrigConnectionTracker.AddOrUpdate(appPacket.L4FlowHash(), serverConn)
+
+		Convey("When the key rotation is not enabled, the keys should not be rotated", func() {
+			So(client.UDPKeyRotationInterval(), ShouldEqual, 0)
+			So(client.ProcessApplicationUDPPacket(appPacket), ShouldBeNil)
+			So(clientConn.PendingContext(), ShouldBeNil)
+			So(clientConn.KeyEpoch(), ShouldEqual, 0)
+		})
+
+		Convey("When the keys of the client expire", func() {
+			client.SetUDPKeyRotationInterval(time.Nanosecond)
+			err := client.ProcessApplicationUDPPacket(appPacket)
+			client.SetUDPKeyRotationInterval(time.Hour)
+
+			Convey("Then the data packet should be transmitted and a rotation requested", func() {
+				So(err, ShouldBeNil)
+				So(clientWriter.count(), ShouldBeGreaterThanOrEqualTo, 1)
+				So(clientConn.PendingContext(), ShouldNotBeNil)
+				So(clientConn.KeyEpoch(), ShouldEqual, 0)
+				So(clientConn.Auth.LocalContext, ShouldResemble, clientLocal)
+
+				request, err := packet.New(packet.PacketTypeNetwork, clientWriter.last(), "0", true)
+				So(err, ShouldBeNil)
+				So(request.GetUDPType(), ShouldEqual, packet.UDPKeyRotateMask)
+
+				Convey("When the server receives the rotation request", func() {
+					err := server.ProcessNetworkUDPPacket(request)
+
+					Convey("Then the server should switch keys and reply", func() {
+						So(err, ShouldNotBeNil)
+						So(serverConn.KeyEpoch(), ShouldEqual, 1)
+						So(serverConn.Auth.RemoteContext, ShouldResemble, clientConn.PendingContext())
+						So(serverConn.Auth.LocalContext, ShouldNotResemble, serverLocal)
+						So(serverConn.GetState(), ShouldEqual, connection.UDPData)
+						So(serverWriter.count(), ShouldEqual, 1)
+					})
+
+					Convey("Then a data packet sent with the previous keys should still be accepted", func() {
+						dataPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+						So(err, ShouldBeNil)
+						So(server.ProcessNetworkUDPPacket(dataPacket), ShouldBeNil)
+
+						previousLocal, previousRemote := serverConn.PreviousContexts()
+						So(previousLocal, ShouldResemble, serverLocal)
+						So(previousRemote, ShouldResemble, clientLocal)
+					})
+
+					Convey("When the client receives the reply", func() {
+						reply, err := packet.New(packet.PacketTypeNetwork, serverWriter.last(), "0", true)
+						So(err, ShouldBeNil)
+						pending := clientConn.PendingContext()
+						err = client.ProcessNetworkUDPPacket(reply)
+
+						Convey("Then both sides should use the same new keys", func() {
+							So(err, ShouldNotBeNil)
+							So(clientConn.KeyEpoch(), ShouldEqual, 1)
+							So(clientConn.PendingContext(), ShouldBeNil)
+							So(clientConn.Auth.LocalContext, ShouldResemble, pending)
+							So(clientConn.Auth.LocalContext, ShouldResemble, serverConn.Auth.RemoteContext)
+							So(clientConn.Auth.RemoteContext, ShouldResemble, serverConn.Auth.LocalContext)
+						})
+
+						Convey("Then a replayed reply should be rejected", func() {
+							replay, err := packet.New(packet.PacketTypeNetwork, serverWriter.last(), "0", true)
+							So(err, ShouldBeNil)
+							So(client.ProcessNetworkUDPPacket(replay), ShouldNotBeNil)
+							So(clientConn.KeyEpoch(), ShouldEqual, 1)
+						})
+
+						// newFin returns a fin of the server signed with the
+						// given contexts.
+						newFin := func(local, remote []byte) *packet.Packet {
+							token, err := server.tokenAccessor.CreateFinToken(&connection.AuthInfo{LocalContext: local, RemoteContext: remote})
+							So(err, ShouldBeNil)
+							fin, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 3000, 2000, nil)
+							So(err, ShouldBeNil)
+							fin.UDPTokenAttach(server.CreateUDPAuthMarker(packet.UDPFinMask), token)
+							return fin
+						}
+
+						Convey("Then a fin that the server signed before it switched keys should be accepted", func() {
+							So(client.ProcessNetworkUDPPacket(newFin(serverLocal, clientLocal)), ShouldNotBeNil)
+							So(clientConn.GetState(), ShouldEqual, connection.UDPClosed)
+						})
+
+						Convey("Then a fin signed with the new keys should be accepted", func() {
+							So(client.ProcessNetworkUDPPacket(newFin(serverConn.Auth.LocalContext, serverConn.Auth.RemoteContext)), ShouldNotBeNil)
+							So(clientConn.GetState(), ShouldEqual, connection.UDPClosed)
+						})
+
+						Convey("Then a fin signed with unknown contexts should be rejected", func() {
+							So(client.ProcessNetworkUDPPacket(newFin([]byte("0123456789abcdef"), clientLocal)), ShouldNotBeNil)
+							So(clientConn.GetState(), ShouldEqual, connection.UDPData)
+						})
+
+						Convey("When the keys are rotated again", func() {
+							clientConn.Lock()
+							clientConn.RotateKeys([]byte("0123456789abcdef"), []byte("fedcba9876543210"))
+							clientConn.Unlock()
+
+							Convey("Then a fin signed with the keys of two rotations ago should be rejected", func() {
+								So(client.ProcessNetworkUDPPacket(newFin(serverLocal, clientLocal)), ShouldNotBeNil)
+								So(clientConn.GetState(), ShouldEqual, connection.UDPData)
+							})
+
+							Convey("Then a fin signed with the keys of the previous rotation should be accepted", func() {
+								So(client.ProcessNetworkUDPPacket(newFin(serverConn.Auth.LocalContext, serverConn.Auth.RemoteContext)), ShouldNotBeNil)
+								So(clientConn.GetState(), ShouldEqual, connection.UDPClosed)
+							})
+						})
+					})
+
+					Convey("When the request is retransmitted", func() {
+						retransmit, err := packet.New(packet.PacketTypeNetwork, clientWriter.last(), "0", true)
+						So(err, ShouldBeNil)
+						So(server.ProcessNetworkUDPPacket(retransmit), ShouldNotBeNil)
+
+						Convey("Then the server should reply again without rotating", func() {
+							So(serverConn.KeyEpoch(), ShouldEqual, 1)
+							So(serverWriter.count(), ShouldEqual, 2)
+						})
+					})
+				})
+			})
+		})
+
+		Convey("When a rotation request has an invalid context", func() {
+			token, err := client.tokenAccessor.CreateKeyRotationToken(&clientConn.Auth, []byte("0123456789abcdef"), []byte("fedcba9876543210"))
+			So(err, ShouldBeNil)
+			request, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, nil)
+			So(err, ShouldBeNil)
+			request.UDPTokenAttach(client.CreateUDPAuthMarker(packet.UDPKeyRotateMask), token)
+
+			Convey("Then the server should not switch keys", func() {
+				So(server.ProcessNetworkUDPPacket(request), ShouldNotBeNil)
+				So(serverConn.KeyEpoch(), ShouldEqual, 0)
+				So(serverConn.Auth.LocalContext, ShouldResemble, serverLocal)
+				So(serverWriter.count(), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestUDPFin(t *testing.T) {
+
+	Convey("Given I have two enforcers with an established UDP connection", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		clientWriter := &capturingSocketWriter{}
+		serverWriter := &capturingSocketWriter{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return clientWriter, nil
+		}
+		client := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return serverWriter, nil
+		}
+		server := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		clientContext, err := pucontext.NewPU("client", policy.NewPUInfo("client", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+		serverContext, err := pucontext.NewPU("server", policy.NewPUInfo("server", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		clientConn := connection.NewUDPConnection(clientContext, clientWriter)
+		serverConn := connection.NewUDPConnection(serverContext, serverWriter)
+		clientConn.Auth.RemoteContext = serverConn.Auth.LocalContext
+		serverConn.Auth.RemoteContext = clientConn.Auth.LocalContext
+		clientConn.ServiceConnection = true
+		serverConn.ServiceConnection = true
+		clientConn.SetState(connection.UDPData)
+		serverConn.SetState(connection.UDPData)
+
+		appPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+		So(err, ShouldBeNil)
+		replyPacket, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 3000, 2000, []byte("data"))
+		So(err, ShouldBeNil)
+
+		client.udpAppOrigConnectionTracker.AddOrUpdate(appPacket.L4FlowHash(), clientConn)
+		client.udpNetReplyConnectionTracker.AddOrUpdate(replyPacket.L4FlowHash(), clientConn)
+		server.udpNetOrigConnectionTracker.AddOrUpdate(appPacket.L4FlowHash(), serverConn)
+		server.udpAppReplyConnectionTracker.AddOrUpdate(replyPacket.L4FlowHash(), serverConn)
+
+		Convey("When the client closes the connection", func() {
+			err := client.SendUDPFinPacket(appPacket)
+
+			Convey("Then the client state should be released and a fin sent", func() {
+				So(err, ShouldBeNil)
+				So(clientConn.GetState(), ShouldEqual, connection.UDPClosed)
+				_, err := client.udpAppOrigConnectionTracker.Get(appPacket.L4FlowHash())
+				So(err, ShouldNotBeNil)
+				_, err = client.udpNetReplyConnectionTracker.Get(replyPacket.L4FlowHash())
+				So(err, ShouldNotBeNil)
+				So(clientWriter.count(), ShouldEqual, 1)
+
+				fin, err := packet.New(packet.PacketTypeNetwork, clientWriter.last(), "0", true)
+				So(err, ShouldBeNil)
+				So(fin.GetUDPType(), ShouldEqual, packet.UDPFinMask)
+			})
+
+			Convey("Then packets of the flow should not use the closed connection", func() {
+				So(client.ProcessApplicationUDPPacket(appPacket), ShouldNotBeNil)
+			})
+
+			Convey("When the server receives the fin", func() {
+				fin, err := packet.New(packet.PacketTypeNetwork, clientWriter.last(), "0", true)
+				So(err, ShouldBeNil)
+				So(server.ProcessNetworkUDPPacket(fin), ShouldNotBeNil)
+
+				Convey("Then the server state should be released", func() {
+					So(serverConn.GetState(), ShouldEqual, connection.UDPClosed)
+					_, err := server.udpNetOrigConnectionTracker.Get(appPacket.L4FlowHash())
+					So(err, ShouldNotBeNil)
+					_, err = server.udpAppReplyConnectionTracker.Get(replyPacket.L4FlowHash())
+					So(err, ShouldNotBeNil)
+				})
+
+				Convey("Then in flight data packets should be dropped", func() {
+					dataPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+					So(err, ShouldBeNil)
+					So(server.ProcessNetworkUDPPacket(dataPacket), ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When the server receives a fin with an invalid token", func() {
+			clientConn.Auth.LocalContext = []byte("0123456789abcdef")
+			So(client.SendUDPFinPacket(appPacket), ShouldBeNil)
+
+			fin, err := packet.New(packet.PacketTypeNetwork, clientWriter.last(), "0", true)
+			So(err, ShouldBeNil)
+
+			Convey("Then the connection should not be torn down", func() {
+				So(server.ProcessNetworkUDPPacket(fin), ShouldNotBeNil)
+				So(serverConn.GetState(), ShouldEqual, connection.UDPData)
+				_, err := server.udpNetOrigConnectionTracker.Get(appPacket.L4FlowHash())
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestUDPFinTranslatedFlow(t *testing.T) {
+
+	Convey("Given I have two enforcers with an established UDP connection to a translated destination", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		clientWriter := &capturingSocketWriter{}
+		serverWriter := &capturingSocketWriter{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return clientWriter, nil
+		}
+		client := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		clientConntrack := &capturingConntrack{}
+		client.conntrackHdl = clientConntrack
+
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return serverWriter, nil
+		}
+		server := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		serverConntrack := &capturingConntrack{}
+		server.conntrackHdl = serverConntrack
+
+		clientContext, err := pucontext.NewPU("client", policy.NewPUInfo("client", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+		serverContext, err := pucontext.NewPU("server", policy.NewPUInfo("server", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		clientConn := connection.NewUDPConnection(clientContext, clientWriter)
+		serverConn := connection.NewUDPConnection(serverContext, serverWriter)
+		clientConn.Auth.RemoteContext = serverConn.Auth.LocalContext
+		serverConn.Auth.RemoteContext = clientConn.Auth.LocalContext
+		clientConn.SetState(connection.UDPData)
+		serverConn.SetState(connection.UDPData)
+
+		// The client sends to the service address, which is translated to
+		// the address of the server.
+		appPacket, err := newUDPTestPacket("10.1.1.1", "10.96.0.10", 2000, 53, []byte("data"))
+		So(err, ShouldBeNil)
+		netPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+		So(err, ShouldBeNil)
+		replyPacket, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 3000, 2000, []byte("data"))
+		So(err, ShouldBeNil)
+
+		clientConn.NetReplyHash = replyPacket.L4FlowHash()
+		client.udpAppOrigConnectionTracker.AddOrUpdate(appPacket.L4FlowHash(), clientConn)
+		client.udpNetReplyConnectionTracker.AddOrUpdate(replyPacket.L4FlowHash(), clientConn)
+		client.udpNatConnectionTracker.AddOrUpdate("10.1.1.1:2000", "10.96.0.10:53")
+		server.udpNetOrigConnectionTracker.AddOrUpdate(netPacket.L4FlowHash(), serverConn)
+		server.udpAppReplyConnectionTracker.AddOrUpdate(replyPacket.L4FlowHash(), serverConn)
+
+		Convey("When the client closes the connection", func() {
+			So(client.SendUDPFinPacket(appPacket), ShouldBeNil)
+
+			Convey("Then the state of the replies from the server should be released", func() {
+				_, err := client.udpAppOrigConnectionTracker.Get(appPacket.L4FlowHash())
+				So(err, ShouldNotBeNil)
+				_, err = client.udpNetReplyConnectionTracker.Get(replyPacket.L4FlowHash())
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the mark should be cleared with the tuple of the replies before translation", func() {
+				So(clientConntrack.calls(), ShouldResemble, []conntrackUpdate{
+					{ipSrc: "10.96.0.10", ipDst: "10.1.1.1", protonum: packet.IPProtocolUDP, srcport: 53, dstport: 2000, newmark: 0},
+				})
+			})
+		})
+
+		Convey("When the server closes the connection", func() {
+			So(server.SendUDPFinPacket(replyPacket), ShouldBeNil)
+
+			Convey("Then the server should clear the mark with the tuple of the replies", func() {
+				So(serverConntrack.calls(), ShouldResemble, []conntrackUpdate{
+					{ipSrc: "10.1.1.2", ipDst: "10.1.1.1", protonum: packet.IPProtocolUDP, srcport: 3000, dstport: 2000, newmark: 0},
+				})
+			})
+
+			Convey("When the client receives the fin", func() {
+				fin, err := packet.New(packet.PacketTypeNetwork, serverWriter.last(), "0", true)
+				So(err, ShouldBeNil)
+				So(client.ProcessNetworkUDPPacket(fin), ShouldNotBeNil)
+
+				Convey("Then the client state should be released with the translated flow", func() {
+					So(clientConn.GetState(), ShouldEqual, connection.UDPClosed)
+					_, err := client.udpAppOrigConnectionTracker.Get(appPacket.L4FlowHash())
+					So(err, ShouldNotBeNil)
+					_, err = client.udpNetReplyConnectionTracker.Get(replyPacket.L4FlowHash())
+					So(err, ShouldNotBeNil)
+					So(clientConntrack.calls(), ShouldResemble, []conntrackUpdate{
+						{ipSrc: "10.96.0.10", ipDst: "10.1.1.1", protonum: packet.IPProtocolUDP, srcport: 53, dstport: 2000, newmark: 0},
+					})
+				})
+
+				Convey("Then a replay of the fin should not tear down the connection again", func() {
+					clientConn.SetState(connection.UDPData)
+					client.udpNetReplyConnectionTracker.AddOrUpdate(replyPacket.L4FlowHash(), clientConn)
+
+					replay, err := packet.New(packet.PacketTypeNetwork, serverWriter.last(), "0", true)
+					So(err, ShouldBeNil)
+					So(client.ProcessNetworkUDPPacket(replay), ShouldNotBeNil)
+					So(clientConn.GetState(), ShouldEqual, connection.UDPData)
+					_, err = client.udpNetReplyConnectionTracker.Get(replyPacket.L4FlowHash())
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("When the client receives an ack token with the fin marker", func() {
+			token, err := server.tokenAccessor.CreateAckPacketToken(serverContext, &serverConn.Auth)
+			So(err, ShouldBeNil)
+
+			fin, buffer, err := server.clonePacketHeaders(replyPacket, packet.UDPSignatureLen+len(token))
+			So(err, ShouldBeNil)
+			defer handshakeBuffers.put(buffer)
+			fin.UDPTokenAttach(server.CreateUDPAuthMarker(packet.UDPFinMask), token)
+
+			netFin, err := packet.New(packet.PacketTypeNetwork, fin.Buffer, "0", true)
+			So(err, ShouldBeNil)
+
+			Convey("Then the connection should not be torn down", func() {
+				So(client.ProcessNetworkUDPPacket(netFin), ShouldNotBeNil)
+				So(clientConn.GetState(), ShouldEqual, connection.UDPData)
+				_, err := client.udpNetReplyConnectionTracker.Get(replyPacket.L4FlowHash())
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestConnectionRateLimit(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and a PU with a rate limit", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		puInfo := policy.NewPUInfo("SomePU", common.ContainerPU)
+		puInfo.Policy.SetRateLimit(policy.RateLimit{Rate: 0.001, Burst: 3})
+		context, err := pucontext.NewPU("SomePU", puInfo, 10*time.Second)
+		So(err, ShouldBeNil)
+
+		synPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+		So(err, ShouldBeNil)
+
+		Convey("When a source opens connections up to the burst", func() {
+			for i := 0; i < 3; i++ {
+				_, _, err := enforcer.processNetworkUDPSynPacket(context, connection.NewUDPConnection(context, nil), synPacket)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldNotContainSubstring, "rate limit")
+			}
+
+			Convey("Then the next connection from the source should be rate limited", func() {
+				_, _, err := enforcer.processNetworkUDPSynPacket(context, connection.NewUDPConnection(context, nil), synPacket)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "rate limit")
+			})
+
+			Convey("Then retransmissions of existing connections should not be rate limited", func() {
+				conn := connection.NewUDPConnection(context, nil)
+				conn.SetState(connection.UDPReceiverSendSynAck)
+				_, _, err := enforcer.processNetworkUDPSynPacket(context, conn, synPacket)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldNotContainSubstring, "rate limit")
+			})
+
+			Convey("Then connections from other sources should not be rate limited", func() {
+				So(context.AllowConnection("10.1.1.3"), ShouldBeTrue)
+			})
+
+			Convey("Then TCP connections from the source should be rate limited", func() {
+				PacketFlow := packetgen.NewTemplateFlow()
+				_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+				So(err, ShouldBeNil)
+				tcpSyn, err := PacketFlow.GetFirstSynPacket().ToBytes()
+				So(err, ShouldBeNil)
+				tcpPacket, err := packet.New(0, tcpSyn, "0", true)
+				So(err, ShouldBeNil)
+
+				source := tcpPacket.SourceAddress.String()
+				for i := 0; i < 3; i++ {
+					So(context.AllowConnection(source), ShouldBeTrue)
+				}
+
+				_, _, err = enforcer.processNetworkSynPacket(context, connection.NewTCPConnection(context), tcpPacket)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "rate limit")
+			})
+		})
+
+		Convey("When the PU has no rate limit", func() {
+			unlimited, err := pucontext.NewPU("OtherPU", policy.NewPUInfo("OtherPU", common.ContainerPU), 10*time.Second)
+			So(err, ShouldBeNil)
+
+			Convey("Then bursts of connections should be allowed", func() {
+				for i := 0; i < 100; i++ {
+					So(unlimited.AllowConnection("10.1.1.1"), ShouldBeTrue)
+				}
+			})
+		})
+	})
+}
+
+func TestUDPEnforcementDirection(t *testing.T) {
+
+	Convey("Given I have an enforcer", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		writer := &capturingSocketWriter{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		newContext := func(direction policy.EnforcementDirection) *pucontext.PUContext {
+			puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+			puInfo.Runtime.SetOptions(policy.OptionsType{EnforcementDirection: direction})
+			context, err := pucontext.NewPU("pu", puInfo, 10*time.Second)
+			So(err, ShouldBeNil)
+			return context
+		}
+
+		appPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+		So(err, ShouldBeNil)
+		netPacket, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 3000, 2000, []byte("data"))
+		So(err, ShouldBeNil)
+
+		Convey("When the PU only enforces ingress traffic", func() {
+			enforcer.puFromIP = newContext(policy.EnforceIngressOnly)
+
+			Convey("Then a new application flow should be accepted without a handshake", func() {
+				So(enforcer.ProcessApplicationUDPPacket(appPacket), ShouldBeNil)
+				So(writer.count(), ShouldEqual, 0)
+
+				Convey("Then the replies of the flow should be accepted", func() {
+					So(enforcer.ProcessNetworkUDPPacket(netPacket), ShouldBeNil)
+				})
+			})
+
+			Convey("Then network data of a flow that the PU did not start should be dropped", func() {
+				So(enforcer.ProcessNetworkUDPPacket(netPacket), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the PU only enforces egress traffic", func() {
+			enforcer.puFromIP = newContext(policy.EnforceEgressOnly)
+
+			Convey("Then network data without a connection should be accepted", func() {
+				So(enforcer.ProcessNetworkUDPPacket(netPacket), ShouldBeNil)
+
+				Convey("Then the replies of the PU should be accepted without a handshake", func() {
+					So(enforcer.ProcessApplicationUDPPacket(appPacket), ShouldBeNil)
+					So(writer.count(), ShouldEqual, 0)
+				})
+			})
+
+			Convey("Then a new application flow should be enforced", func() {
+				So(enforcer.ProcessApplicationUDPPacket(appPacket), ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestTCPEnforcementDirection(t *testing.T) {
+
+	Convey("Given I have an enforcer", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		newContext := func(direction policy.EnforcementDirection) *pucontext.PUContext {
+			puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+			puInfo.Runtime.SetOptions(policy.OptionsType{EnforcementDirection: direction})
+			context, err := pucontext.NewPU("pu", puInfo, 10*time.Second)
+			So(err, ShouldBeNil)
+			return context
+		}
+
+		newPacket := func(src, dst string, sport, dport uint16, flags uint8) *packet.Packet {
+			p, err := newTCPTestPacket(src, dst, sport, dport, flags, nil)
+			So(err, ShouldBeNil)
+			return p
+		}
+
+		Convey("When the PU only enforces egress traffic and a flow is initiated by the network", func() {
+			enforcer.puFromIP = newContext(policy.EnforceEgressOnly)
+			So(enforcer.processNetworkTCPPackets(newPacket("10.1.1.2", "10.1.1.1", 3000, 80, packet.TCPSynMask)), ShouldBeNil)
+
+			Convey("Then the packets of both legs of the flow should be accepted", func() {
+				So(enforcer.processApplicationTCPPackets(newPacket("10.1.1.1", "10.1.1.2", 80, 3000, packet.TCPSynAckMask)), ShouldBeNil)
+				So(enforcer.processNetworkTCPPackets(newPacket("10.1.1.2", "10.1.1.1", 3000, 80, packet.TCPAckMask)), ShouldBeNil)
+				So(enforcer.processApplicationTCPPackets(newPacket("10.1.1.1", "10.1.1.2", 80, 3000, packet.TCPAckMask)), ShouldBeNil)
+			})
+		})
+
+		Convey("When the PU only enforces ingress traffic and a flow is initiated by the PU", func() {
+			enforcer.puFromIP = newContext(policy.EnforceIngressOnly)
+			So(enforcer.processApplicationTCPPackets(newPacket("10.1.1.1", "10.1.1.2", 4000, 443, packet.TCPSynMask)), ShouldBeNil)
+
+			Convey("Then the packets of both legs of the flow should be accepted", func() {
+				So(enforcer.processNetworkTCPPackets(newPacket("10.1.1.2", "10.1.1.1", 443, 4000, packet.TCPSynAckMask)), ShouldBeNil)
+				So(enforcer.processApplicationTCPPackets(newPacket("10.1.1.1", "10.1.1.2", 4000, 443, packet.TCPAckMask)), ShouldBeNil)
+				So(enforcer.processNetworkTCPPackets(newPacket("10.1.1.2", "10.1.1.1", 443, 4000, packet.TCPAckMask)), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestSendUDPAckPacket(t *testing.T) {
+
+	Convey("Given I have an enforcer with a NATed UDP connection", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		writer := &capturingSocketWriter{}
+		conntrack := &capturingConntrack{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		enforcer.conntrackHdl = conntrack
+
+		context, err := pucontext.NewPU("client", policy.NewPUInfo("client", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		conn := connection.NewUDPConnection(context, writer)
+		conn.Auth.RemoteContext = []byte("remote context")
+
+		// The application sent the syn to 30.1.1.1:53, which was NATed to 20.1.1.1:53.
+		enforcer.udpNatConnectionTracker.AddOrUpdate("10.1.1.1:5000", "30.1.1.1:53")
+
+		synAck, err := newUDPTestPacket("20.1.1.1", "10.1.1.1", 53, 5000, nil)
+		So(err, ShouldBeNil)
+
+		Convey("When I send the ack, it should be sent to the original destination", func() {
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+			So(writer.count(), ShouldEqual, 1)
+
+			ack, err := packet.New(packet.PacketTypeNetwork, writer.last(), "0", true)
+			So(err, ShouldBeNil)
+			So(ack.GetUDPType(), ShouldEqual, packet.UDPAckMask)
+			So(ack.SourceAddress.String(), ShouldEqual, "10.1.1.1")
+			So(ack.SourcePort, ShouldEqual, 5000)
+			So(ack.DestinationAddress.String(), ShouldEqual, "30.1.1.1")
+			So(ack.DestinationPort, ShouldEqual, 53)
+
+			Convey("The conntrack mark of the original flow should be updated", func() {
+				So(conntrack.calls(), ShouldResemble, []conntrackUpdate{
+					{
+						ipSrc:    "30.1.1.1",
+						ipDst:    "10.1.1.1",
+						protonum: packet.IPProtocolUDP,
+						srcport:  53,
+						dstport:  5000,
+						newmark:  constants.DefaultConnMark,
+					},
+				})
+			})
+		})
+
+		Convey("When a conn mark is configured, the conntrack entry should be updated with it", func() {
+			enforcer.filterQueue.ConnMark = 0x1234
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+			So(len(conntrack.calls()), ShouldEqual, 1)
+			So(conntrack.calls()[0].newmark, ShouldEqual, 0x1234)
+		})
+
+		Convey("When the connection is a service connection, the conntrack mark should not be updated", func() {
+			conn.ServiceConnection = true
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+			So(writer.count(), ShouldEqual, 1)
+			So(len(conntrack.calls()), ShouldEqual, 0)
+		})
+
+		Convey("When the connection is a service connection, its mark should be applied to the sockets of the service", func() {
+			enforcer.filterQueue.ConnMark = 0x1234
+			conn.ServiceConnection = true
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+			So(conn.ConnMark(), ShouldEqual, 0x1234)
+
+			server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			So(err, ShouldBeNil)
+			defer server.Close() // nolint errcheck
+
+			// Setting the mark of a socket requires CAP_NET_ADMIN.
+			if os.Geteuid() != 0 || !socketMarkSupported {
+				return
+			}
+
+			// The proxy of the service dials the flow with the dialer of the
+			// connection.
+			proxy, err := conn.Dialer(time.Second).Dial("udp4", server.LocalAddr().String())
+			So(err, ShouldBeNil)
+			defer proxy.Close() // nolint errcheck
+
+			mark, err := socketMark(proxy.(*net.UDPConn))
+			So(err, ShouldBeNil)
+			So(mark, ShouldEqual, 0x1234)
+
+			// The return traffic is received on the marked socket.
+			_, err = proxy.Write([]byte("request"))
+			So(err, ShouldBeNil)
+			buf := make([]byte, 16)
+			So(server.SetReadDeadline(time.Now().Add(time.Second)), ShouldBeNil)
+			_, from, err := server.ReadFromUDP(buf)
+			So(err, ShouldBeNil)
+			_, err = server.WriteToUDP([]byte("reply"), from)
+			So(err, ShouldBeNil)
+			So(proxy.SetReadDeadline(time.Now().Add(time.Second)), ShouldBeNil)
+			n, err := proxy.Read(buf)
+			So(err, ShouldBeNil)
+			So(string(buf[:n]), ShouldEqual, "reply")
+
+			// Sockets that are already open can be marked too.
+			So(conn.MarkSocket(server), ShouldBeNil)
+			mark, err = socketMark(server)
+			So(err, ShouldBeNil)
+			So(mark, ShouldEqual, 0x1234)
+		})
+
+		Convey("When the connection is not a service connection, its sockets should not be marked", func() {
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+			So(conn.ConnMark(), ShouldEqual, 0)
+
+			sock, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			So(err, ShouldBeNil)
+			defer sock.Close() // nolint errcheck
+
+			if !socketMarkSupported {
+				return
+			}
+
+			So(conn.MarkSocket(sock), ShouldNotBeNil)
+			_, err = conn.Dialer(time.Second).Dial("udp4", sock.LocalAddr().String())
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the conntrack update fails, the ack should still be sent", func() {
+			conntrack.fail = true
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+			So(writer.count(), ShouldEqual, 1)
+			So(len(conntrack.calls()), ShouldEqual, 1)
+		})
+
+		Convey("When the NAT entry is missing, the ack should be sent to the observed address", func() {
+			enforcer.udpNatConnectionTracker.Remove("10.1.1.1:5000") // nolint errcheck
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+			So(writer.count(), ShouldEqual, 1)
+
+			ack, err := packet.New(packet.PacketTypeNetwork, writer.last(), "0", true)
+			So(err, ShouldBeNil)
+			So(ack.DestinationAddress.String(), ShouldEqual, "20.1.1.1")
+			So(ack.DestinationPort, ShouldEqual, 53)
+			So(conntrack.calls()[0].ipSrc, ShouldEqual, "20.1.1.1")
+		})
+
+		Convey("When the NAT entry is missing, the flow should be reported with the missing entry", func() {
+			flows := &flowCapturingCollector{}
+			enforcer.collector = flows
+			enforcer.udpNatConnectionTracker.Remove("10.1.1.1:5000") // nolint errcheck
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 1)
+			So(records[0].DropReason, ShouldEqual, collector.MissingNATEntry)
+			So(records[0].Action, ShouldEqual, policy.Accept)
+			So(records[0].ContextID, ShouldEqual, "client")
+		})
+
+		Convey("When the NAT entry is present, no flow should be reported", func() {
+			flows := &flowCapturingCollector{}
+			enforcer.collector = flows
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+			So(len(flows.records()), ShouldEqual, 0)
+		})
+
+		Convey("When the NAT entry is an IPv4 mapped IPv6 address, the ack should be sent to the IPv4 address", func() {
+			enforcer.udpNatConnectionTracker.AddOrUpdate("10.1.1.1:5000", "[::ffff:30.1.1.2]:54")
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+
+			ack, err := packet.New(packet.PacketTypeNetwork, writer.last(), "0", true)
+			So(err, ShouldBeNil)
+			So(ack.DestinationAddress.String(), ShouldEqual, "30.1.1.2")
+			So(ack.DestinationPort, ShouldEqual, 54)
+		})
+
+		Convey("When the NAT entry is an IPv6 address, the ack should be sent to the observed address", func() {
+			enforcer.udpNatConnectionTracker.AddOrUpdate("10.1.1.1:5000", "[2001:db8::1]:53")
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldBeNil)
+
+			ack, err := packet.New(packet.PacketTypeNetwork, writer.last(), "0", true)
+			So(err, ShouldBeNil)
+			So(ack.DestinationAddress.String(), ShouldEqual, "20.1.1.1")
+			So(ack.DestinationPort, ShouldEqual, 53)
+		})
+
+		Convey("When the NAT entry is malformed, the ack should not be sent", func() {
+			enforcer.udpNatConnectionTracker.AddOrUpdate("10.1.1.1:5000", "30.1.1.1")
+			So(enforcer.sendUDPAckPacket(synAck, context, conn), ShouldNotBeNil)
+			So(writer.count(), ShouldEqual, 0)
+			So(len(conntrack.calls()), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestParseHostPort(t *testing.T) {
+
+	Convey("Given cached addresses", t, func() {
+
+		Convey("An IPv4 address should be parsed", func() {
+			ip, port, err := parseHostPort("10.1.1.1:53")
+			So(err, ShouldBeNil)
+			So(ip.String(), ShouldEqual, "10.1.1.1")
+			So(port, ShouldEqual, 53)
+		})
+
+		Convey("A bracketed IPv6 address should be parsed", func() {
+			ip, port, err := parseHostPort("[2001:db8::1]:5353")
+			So(err, ShouldBeNil)
+			So(ip.Equal(net.ParseIP("2001:db8::1")), ShouldBeTrue)
+			So(port, ShouldEqual, 5353)
+		})
+
+		Convey("A packet source port hash should be parsed back", func() {
+			p := &packet.Packet{
+				DestinationAddress: net.ParseIP("2001:db8::2"),
+				DestinationPort:    443,
+			}
+			ip, port, err := parseHostPort(p.SourcePortHash(packet.PacketTypeNetwork))
+			So(err, ShouldBeNil)
+			So(ip.Equal(p.DestinationAddress), ShouldBeTrue)
+			So(port, ShouldEqual, 443)
+		})
+
+		Convey("Malformed addresses should fail", func() {
+			for _, hostPort := range []string{"2001:db8::1:53", "10.1.1.1", "host:53", "10.1.1.1:port", "10.1.1.1:70000"} {
+				_, _, err := parseHostPort(hostPort)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+type flowCapturingCollector struct {
+	collector.DefaultCollector
+	sync.Mutex
+	flows []*collector.FlowRecord
+}
+
+func (c *flowCapturingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.flows = append(c.flows, record)
+}
+
+func (c *flowCapturingCollector) records() []*collector.FlowRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	return append([]*collector.FlowRecord{}, c.flows...)
+}
+
+func TestFlowDirection(t *testing.T) {
+
+	Convey("Given the packets of a TCP handshake", t, func() {
+		syn, err := newTCPTestPacket("10.1.1.1", "10.1.1.2", 2000, 80, packet.TCPSynMask, nil)
+		So(err, ShouldBeNil)
+		synAck, err := newTCPTestPacket("10.1.1.2", "10.1.1.1", 80, 2000, packet.TCPSynAckMask, nil)
+		So(err, ShouldBeNil)
+		ack, err := newTCPTestPacket("10.1.1.1", "10.1.1.2", 2000, 80, packet.TCPAckMask, nil)
+		So(err, ShouldBeNil)
+
+		Convey("The flows of the packets from the network should be incoming, except for the syn ack", func() {
+			So(flowDirection(syn), ShouldEqual, collector.FlowDirectionIncoming)
+			So(flowDirection(synAck), ShouldEqual, collector.FlowDirectionOutgoing)
+			So(flowDirection(ack), ShouldEqual, collector.FlowDirectionIncoming)
+		})
+
+		Convey("The flows of the packets from the application should be outgoing, except for the syn ack", func() {
+			for _, p := range []*packet.Packet{syn, synAck, ack} {
+				app, err := packet.New(packet.PacketTypeApplication, p.GetBytes(), "0", true)
+				So(err, ShouldBeNil)
+				So(flowDirection(app), ShouldNotEqual, flowDirection(p))
+			}
+		})
+
+		Convey("The flows of the packets of an unknown type should have no direction", func() {
+			p, err := packet.New(0, syn.GetBytes(), "0", true)
+			So(err, ShouldBeNil)
+			So(flowDirection(p), ShouldEqual, collector.FlowDirectionUnknown)
+		})
+	})
+
+	Convey("Given a UDP packet from the application", t, func() {
+		data, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 53, []byte("data"))
+		So(err, ShouldBeNil)
+
+		Convey("Its flow should be outgoing", func() {
+			So(flowDirection(data), ShouldEqual, collector.FlowDirectionOutgoing)
+		})
+	})
+}
+
+func TestUDPAcceptedFlowReport(t *testing.T) {
+
+	Convey("Given I have a client and a server enforcer in the middle of a UDP handshake", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		flows := &flowCapturingCollector{}
+		writer := &capturingSocketWriter{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		client := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		client.conntrackHdl = &capturingConntrack{}
+		server := NewWithDefaults("SomeServerId", flows, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		server.conntrackHdl = &capturingConntrack{}
+
+		clientContext, err := pucontext.NewPU("client", policy.NewPUInfo("client", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+		serverPolicy := policy.NewPUPolicy("serverpu", policy.AllowAll, nil, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+		serverRuntime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+		serverContext, err := pucontext.NewPU("server", policy.PUInfoFromPolicyAndRuntime("server", serverPolicy, serverRuntime), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		clientConn := connection.NewUDPConnection(clientContext, writer)
+		serverConn := connection.NewUDPConnection(serverContext, writer)
+		clientConn.Auth.RemoteContext = serverConn.Auth.LocalContext
+		serverConn.Auth.RemoteContext = clientConn.Auth.LocalContext
+		serverConn.Auth.RemoteContextID = "clientpu"
+
+		matched := &policy.FlowPolicy{
+			Action:    policy.Accept,
+			PolicyID:  "policy",
+			ServiceID: "service",
+		}
+		serverConn.ReportFlowPolicy = matched
+		serverConn.PacketFlowPolicy = matched
+
+		synAck, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 53, 5000, nil)
+		So(err, ShouldBeNil)
+		So(client.sendUDPAckPacket(synAck, clientContext, clientConn), ShouldBeNil)
+
+		ack, err := packet.New(packet.PacketTypeNetwork, writer.last(), "0", true)
+		So(err, ShouldBeNil)
+
+		Convey("When the server accepts the ack, the flow should be reported with the matched policy", func() {
+			_, _, err := server.processNetworkUDPAckPacket(ack, serverContext, serverConn)
+			So(err, ShouldBeNil)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 1)
+			So(records[0].Action.Accepted(), ShouldBeTrue)
+			So(records[0].PolicyID, ShouldEqual, "policy")
+			So(records[0].ServiceID, ShouldEqual, "service")
+			So(records[0].Direction, ShouldEqual, collector.FlowDirectionIncoming)
+			So(records[0].Source.ID, ShouldEqual, "clientpu")
+			So(records[0].Destination.ID, ShouldEqual, "serverpu")
+			So(records[0].L4Protocol, ShouldEqual, packet.IPProtocolUDP)
+		})
+	})
+}
+
+func TestUDPUnenforcedFlowReport(t *testing.T) {
+
+	Convey("Given I have an enforcer for Linux processes without PUs", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		flows := &flowCapturingCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", flows, nil, secret, constants.LocalServer, "/proc", []string{"0.0.0.0/0"})
+		enforcer.mode = constants.LocalServer
+
+		Convey("When an unenforced process sends packets", func() {
+			p, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+			So(err, ShouldBeNil)
+
+			for i := 0; i < 3; i++ {
+				_, err = enforcer.appUDPRetrieveState(p)
+				So(err, ShouldNotBeNil)
+			}
+
+			Convey("A single flow should be reported with the unenforced reason", func() {
+				records := flows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].DropReason, ShouldEqual, collector.UnenforcedSource)
+				So(records[0].Action, ShouldEqual, policy.Reject)
+				So(records[0].Source.IP, ShouldEqual, "10.1.1.1")
+				So(records[0].Destination.IP, ShouldEqual, "10.1.1.2")
+				So(records[0].Destination.Port, ShouldEqual, 3000)
+			})
+		})
+
+		Convey("When packets are received for unenforced processes from two sources", func() {
+			for _, source := range []string{"10.1.1.3", "10.1.1.4", "10.1.1.3"} {
+				p, err := newUDPTestPacket(source, "10.1.1.1", 3000, 2000, []byte("data"))
+				So(err, ShouldBeNil)
+
+				_, err = enforcer.netSynUDPRetrieveState(p)
+				So(err, ShouldNotBeNil)
+			}
+
+			Convey("A flow should be reported for every source", func() {
+				records := flows.records()
+				So(len(records), ShouldEqual, 2)
+				So(records[0].Source.IP, ShouldEqual, "10.1.1.3")
+				So(records[1].Source.IP, ShouldEqual, "10.1.1.4")
+				So(records[1].DropReason, ShouldEqual, collector.UnenforcedSource)
+			})
+		})
+	})
+}
+
+func TestUDPHandshakeLimits(t *testing.T) {
+
+	Convey("Given I have a client and a server enforcer with handshake limits", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		flows := &flowCapturingCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return &capturingSocketWriter{}, nil
+		}
+
+		client := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		server := NewWithDefaults("SomeServerId", flows, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		server.conntrackHdl = &capturingConntrack{}
+		server.SetUDPHandshakeLimits(3, 2)
+
+		newContext := func(id string) *pucontext.PUContext {
+			puInfo := policy.NewPUInfo(id, common.ContainerPU)
+			puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, "value")
+			puInfo.Policy.AddReceiverRules(policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      enforcerconstants.TransmitterLabel,
+						Value:    []string{"value"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept},
+			})
+			context, err := pucontext.NewPU(id, puInfo, 10*time.Second)
+			So(err, ShouldBeNil)
+			return context
+		}
+
+		clientContext := newContext("client")
+		first := newContext("first")
+		second := newContext("second")
+
+		// syn sends a syn from a new client connection and returns the server
+		// connection that processed it.
+		clientConns := map[uint16]*connection.UDPConnection{}
+		syn := func(context *pucontext.PUContext, sport uint16) (*packet.Packet, *connection.UDPConnection, error) {
+			clientConn, ok := clientConns[sport]
+			if !ok {
+				clientConn = connection.NewUDPConnection(clientContext, nil)
+				clientConns[sport] = clientConn
+			}
+			token, err := client.tokenAccessor.CreateSynPacketToken(clientContext, &clientConn.Auth)
+			So(err, ShouldBeNil)
+			synPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", sport, 53, nil)
+			So(err, ShouldBeNil)
+			synPacket.UDPTokenAttach(client.CreateUDPAuthMarker(packet.UDPSynMask), token)
+
+			conn := connection.NewUDPConnection(context, nil)
+			_, _, err = server.processNetworkUDPSynPacket(context, conn, synPacket)
+			return synPacket, conn, err
+		}
+
+		Convey("When a PU receives syns up to its limit", func() {
+			_, conn, err := syn(first, 1000)
+			So(err, ShouldBeNil)
+			_, _, err = syn(first, 1001)
+			So(err, ShouldBeNil)
+
+			Convey("Then the next syn of the PU should be rejected as resource exhausted", func() {
+				synPacket, _, err := syn(first, 1002)
+				So(err, ShouldNotBeNil)
+				_, err = server.udpNetOrigConnectionTracker.Get(synPacket.L4FlowHash())
+				So(err, ShouldNotBeNil)
+
+				records := flows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].DropReason, ShouldEqual, collector.ResourceExhausted)
+				So(records[0].Action.Rejected(), ShouldBeTrue)
+			})
+
+			Convey("Then retransmissions of the pending syns should be accepted", func() {
+				_, _, err := syn(first, 1000)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then syns of other PUs should be accepted up to the global limit", func() {
+				_, _, err := syn(second, 2000)
+				So(err, ShouldBeNil)
+				_, _, err = syn(second, 2001)
+				So(err, ShouldNotBeNil)
+
+				total, perPU := server.udpHandshakes.count("second")
+				So(total, ShouldEqual, 3)
+				So(perPU, ShouldEqual, 1)
+			})
+
+			Convey("When a handshake completes, its slot should be freed", func() {
+				clientConn := clientConns[1000]
+				clientConn.Auth.RemoteContext = conn.Auth.LocalContext
+				token, err := client.tokenAccessor.CreateAckPacketToken(clientContext, &clientConn.Auth)
+				So(err, ShouldBeNil)
+				ack, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 1000, 53, nil)
+				So(err, ShouldBeNil)
+				ack.UDPTokenAttach(client.CreateUDPAuthMarker(packet.UDPAckMask), token)
+
+				_, _, err = server.processNetworkUDPAckPacket(ack, first, conn)
+				So(err, ShouldBeNil)
+
+				_, _, err = syn(first, 1002)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a syn is replayed from another flow", func() {
+			synPacket, _, err := syn(first, 1000)
+			So(err, ShouldBeNil)
+
+			replay, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 4000, 53, nil)
+			So(err, ShouldBeNil)
+			replay.UDPTokenAttach(client.CreateUDPAuthMarker(packet.UDPSynMask), synPacket.ReadUDPToken())
+			_, _, err = server.processNetworkUDPSynPacket(second, connection.NewUDPConnection(second, nil), replay)
+
+			Convey("Then it should be rejected as a replayed nonce", func() {
+				So(err, ShouldNotBeNil)
+				_, err = server.udpNetOrigConnectionTracker.Get(replay.L4FlowHash())
+				So(err, ShouldNotBeNil)
+
+				records := flows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].DropReason, ShouldEqual, collector.InvalidNonse)
+				So(records[0].Action.Rejected(), ShouldBeTrue)
+			})
+
+			Convey("Then it should not hold a handshake slot", func() {
+				total, perPU := server.udpHandshakes.count("second")
+				So(total, ShouldEqual, 1)
+				So(perPU, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the pending handshakes time out, their slots should be freed", func() {
+			server.udpHandshakes = newHandshakeLimiter(3, 2, time.Millisecond)
+			for i := uint16(0); i < 2; i++ {
+				_, _, err := syn(first, 1000+i)
+				So(err, ShouldBeNil)
+			}
+
+			time.Sleep(10 * time.Millisecond)
+
+			_, _, err := syn(first, 1002)
+			So(err, ShouldBeNil)
+			total, perPU := server.udpHandshakes.count("first")
+			So(total, ShouldEqual, 1)
+			So(perPU, ShouldEqual, 1)
+		})
+
+		Convey("When many syns are received, the number of half open connections should be capped", func() {
+			server.SetUDPHandshakeLimits(0, 50)
+			accepted := 0
+			for i := uint16(0); i < 200; i++ {
+				if _, _, err := syn(first, 3000+i); err == nil {
+					accepted++
+				}
+			}
+
+			So(accepted, ShouldEqual, 50)
+			_, perPU := server.udpHandshakes.count("first")
+			So(perPU, ShouldEqual, 50)
+		})
+	})
+}
+
+func TestUDPClonePreservesTOS(t *testing.T) {
+
+	Convey("Given I have an enforcer and a UDP packet with DSCP and ECN bits", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return &capturingSocketWriter{}, nil
+		}
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		// DSCP EF with ECN capable transport
+		tos := byte(0xba)
+
+		udpPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+		So(err, ShouldBeNil)
+		udpPacket.Buffer[1] = tos
+
+		Convey("When the packet headers are cloned and a token is attached", func() {
+			clone, _, err := enforcer.clonePacketHeaders(udpPacket, 0)
+			So(err, ShouldBeNil)
+			So(clone.Buffer[1], ShouldEqual, tos)
+
+			clone.UDPTokenAttach(enforcer.CreateUDPAuthMarker(packet.UDPSynMask), []byte("token"))
+
+			Convey("Then the emitted packet should keep the TOS byte", func() {
+				emitted, err := packet.New(packet.PacketTypeNetwork, clone.Buffer, "0", true)
+				So(err, ShouldBeNil)
+				So(emitted.Buffer[1], ShouldEqual, tos)
+			})
+		})
+	})
+}
+
+func TestUDPHandshakeChecksums(t *testing.T) {
+
+	Convey("Given I have a client and a server enforcer", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		writer := &capturingSocketWriter{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		client := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		server := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		clientPolicy := policy.NewPUPolicy("clientpu", policy.Police, nil, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{"0.0.0.0/0"}, []string{}, nil, nil, []string{})
+		clientRuntime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+		clientContext, err := pucontext.NewPU("client", policy.PUInfoFromPolicyAndRuntime("client", clientPolicy, clientRuntime), 10*time.Second)
+		So(err, ShouldBeNil)
+		serverContext, err := pucontext.NewPU("server", policy.NewPUInfo("server", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		clientConn := connection.NewUDPConnection(clientContext, writer)
+		serverConn := connection.NewUDPConnection(serverContext, writer)
+		clientConn.Auth.RemoteContext = serverConn.Auth.LocalContext
+		serverConn.Auth.RemoteContext = clientConn.Auth.LocalContext
+
+		// sent returns the packet written at the given position. Later
+		// positions may be retransmissions.
+		sent := func(index int) *packet.Packet {
+			writer.Lock()
+			buffer := make([]byte, len(writer.packets[index]))
+			copy(buffer, writer.packets[index])
+			writer.Unlock()
+
+			p, err := packet.New(packet.PacketTypeNetwork, buffer, "0", true)
+			So(err, ShouldBeNil)
+			return p
+		}
+
+		appPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, []byte("query"))
+		So(err, ShouldBeNil)
+
+		Convey("Then the emitted syn, synack and ack packets should have correct checksums", func() {
+			synIndex := writer.count()
+			So(client.processApplicationUDPSynPacket(appPacket, clientContext, clientConn), ShouldBeNil)
+
+			// The replies are created in place from the received packets.
+			synAckIndex := writer.count()
+			So(server.sendUDPSynAckPacket(sent(synIndex), serverContext, serverConn), ShouldBeNil)
+
+			ackIndex := writer.count()
+			So(client.sendUDPAckPacket(sent(synAckIndex), clientContext, clientConn), ShouldBeNil)
+
+			for _, index := range []int{synIndex, synAckIndex, ackIndex} {
+				p := sent(index)
+				So(p.VerifyIPChecksum(), ShouldBeTrue)
+				So(p.UDPChecksum, ShouldNotEqual, 0)
+				So(p.VerifyUDPChecksum(), ShouldBeTrue)
+			}
+		})
+	})
+}
+
+func TestUDPReapedHandshakeReport(t *testing.T) {
+
+	Convey("Given I have an enforcer with application packets queued during a UDP handshake", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		flows := &flowCapturingCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return &capturingSocketWriter{}, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", flows, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		puContext, err := pucontext.NewPU("client", policy.NewPUInfo("client", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		conn := connection.NewUDPConnection(puContext, nil)
+		conn.SetState(connection.UDPClientSendSyn)
+
+		first, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, []byte("query"))
+		So(err, ShouldBeNil)
+		second, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, []byte("another query"))
+		So(err, ShouldBeNil)
+		So(conn.QueuePackets(first), ShouldBeNil)
+		So(conn.QueuePackets(second), ShouldBeNil)
+		size := len(first.Buffer) + len(second.Buffer)
+
+		Convey("When the connection is reaped, the queued packets should be reported as dropped", func() {
+			enforcer.reapUDPConnection(conn)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 1)
+			So(records[0].DropReason, ShouldEqual, collector.HandshakeTimeout)
+			So(records[0].Action.Rejected(), ShouldBeTrue)
+			So(records[0].DroppedPackets, ShouldEqual, 2)
+			So(records[0].DroppedBytes, ShouldEqual, size)
+			So(records[0].Source.IP, ShouldEqual, "10.1.1.1")
+			So(records[0].Destination.IP, ShouldEqual, "10.1.1.2")
+			So(records[0].Destination.Port, ShouldEqual, 53)
+			So(records[0].L4Protocol, ShouldEqual, packet.IPProtocolUDP)
+			So(conn.ReadPacket(), ShouldBeNil)
+		})
+
+		Convey("When the connection expires from the tracker, it should be reaped", func() {
+			enforcer.udpAppOrigConnectionTracker.AddOrUpdate(first.L4FlowHash(), conn)
+			So(enforcer.udpAppOrigConnectionTracker.SetTimeOut(first.L4FlowHash(), time.Millisecond), ShouldBeNil)
+
+			for i := 0; i < 100 && len(flows.records()) == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 1)
+			So(records[0].DroppedPackets, ShouldEqual, 2)
+			So(records[0].DroppedBytes, ShouldEqual, size)
+		})
+
+		Convey("When the handshake completed, nothing should be reported", func() {
+			conn.SetState(connection.UDPData)
+			enforcer.reapUDPConnection(conn)
+			So(flows.records(), ShouldBeEmpty)
+		})
+
+		Convey("When the connection was closed, nothing should be reported again", func() {
+			conn.SetState(connection.UDPClosed)
+			conn.DropPackets()
+			enforcer.reapUDPConnection(conn)
+			So(flows.records(), ShouldBeEmpty)
+
+			packets, bytes := conn.DroppedPackets()
+			So(packets, ShouldEqual, 2)
+			So(bytes, ShouldEqual, size)
+		})
+
+		Convey("When nothing is queued, nothing should be reported", func() {
+			conn.DiscardPackets()
+			enforcer.reapUDPConnection(conn)
+			So(flows.records(), ShouldBeEmpty)
+		})
+	})
+}
+
+// newTCPFastOpenSynPacket returns the syn packet with a fast open cookie
+// option and data.
+func newTCPFastOpenSynPacket(syn []byte, data []byte) (*packet.Packet, error) {
+
+	option := []byte{packet.TCPFastOpenOption, 10, 1, 2, 3, 4, 5, 6, 7, 8, packet.TCPNopOption, packet.TCPNopOption}
+	dataStart := 20 + int(syn[32]>>4)*4
+
+	buffer := append([]byte{}, syn[:dataStart]...)
+	buffer = append(buffer, option...)
+	buffer = append(buffer, data...)
+	buffer[32] = uint8((dataStart-20+len(option))/4) << 4
+	binary.BigEndian.PutUint16(buffer[2:4], uint16(len(buffer)))
+
+	p, err := packet.New(0, buffer, "0", true)
+	if err != nil {
+		return nil, err
+	}
+	p.UpdateIPChecksum()
+	p.UpdateTCPChecksum()
+
+	return p, nil
+}
+
+func TestTCPFastOpenSyn(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I create a new enforcer instance with two processing units", t, func() {
+		mockCollector := mockcollector.NewMockEventCollector(ctrl)
+		mockCollector.EXPECT().CollectFlowEvent(gomock.Any()).AnyTimes()
+
+		_, _, enforcer, err1, err2, _, _ := setupProcessingUnitsInDatapathAndEnforce(mockCollector, false, "container", false)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+		syn, err := PacketFlow.GetFirstSynPacket().ToBytes()
+		So(err, ShouldBeNil)
+
+		Convey("When the application sends a syn packet with a fast open cookie and data", func() {
+			var reports []*collector.HealthRecord
+			mockCollector.EXPECT().CollectHealthEvent(gomock.Any()).Do(func(record *collector.HealthRecord) {
+				reports = append(reports, record)
+			}).AnyTimes()
+
+			tcpPacket, err := newTCPFastOpenSynPacket(syn, []byte("GET / HTTP/1.1\r\n"))
+			So(err, ShouldBeNil)
+			So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+
+			Convey("Then the fast open should be replaced by the token and be reported", func() {
+				outPacket, err := packet.New(0, tcpPacket.GetBytes(), "0", true)
+				So(err, ShouldBeNil)
+				So(outPacket.VerifyIPChecksum(), ShouldBeTrue)
+				So(outPacket.VerifyTCPChecksum(), ShouldBeTrue)
+				So(outPacket.CheckTCPAuthenticationOption(enforcerconstants.TCPAuthenticationOptionBaseLen), ShouldBeNil)
+				So(string(outPacket.ReadTCPData()), ShouldNotContainSubstring, "GET")
+
+				So(len(reports), ShouldEqual, 1)
+				So(reports[0].Component, ShouldEqual, tcpFastOpenComponent)
+				So(reports[0].Critical, ShouldBeFalse)
+
+				Convey("Then the syn packet should be accepted by the network side without data", func() {
+					So(enforcer.processNetworkTCPPackets(outPacket), ShouldBeNil)
+					So(outPacket.IsEmptyTCPPayload(), ShouldBeTrue)
+					So(outPacket.TCPDataLength(), ShouldEqual, 0)
+				})
+
+				Convey("Then a retransmission should not be reported again", func() {
+					retransmitted, err := newTCPFastOpenSynPacket(syn, []byte("GET / HTTP/1.1\r\n"))
+					So(err, ShouldBeNil)
+					So(enforcer.processApplicationTCPPackets(retransmitted), ShouldBeNil)
+					So(len(reports), ShouldEqual, 1)
+				})
+			})
+		})
+	})
+}
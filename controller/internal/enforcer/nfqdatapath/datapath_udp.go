@@ -2,21 +2,23 @@ package nfqdatapath
 
 // Go libraries
 import (
+	"bytes"
 	"fmt"
 	"net"
-	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 
 	"go.aporeto.io/trireme-lib/collector"
-	"go.aporeto.io/trireme-lib/controller/constants"
-	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
 	"go.aporeto.io/trireme-lib/controller/pkg/connection"
 	"go.aporeto.io/trireme-lib/controller/pkg/packet"
 	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
 	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/cache"
+	"go.aporeto.io/trireme-lib/utils/crypto"
+	"go.aporeto.io/trireme-lib/utils/logging"
 )
 
 const (
@@ -24,33 +26,55 @@ const (
 	retransmitDelay = 200
 	// rentrasmitRetries is the number of times we will retry
 	retransmitRetries = 3
+	// udpSocketFailureThreshold is the number of consecutive raw socket write
+	// failures after which the datapath is reported as degraded
+	udpSocketFailureThreshold = 10
+	// unenforcedReportInterval is the interval between two reports of the
+	// packets dropped for a source because the process is not enforced
+	unenforcedReportInterval = 10 * time.Second
 )
 
 // ProcessNetworkUDPPacket processes packets arriving from network and are destined to the application.
 func (d *Datapath) ProcessNetworkUDPPacket(p *packet.Packet) (err error) {
 
 	if d.packetLogs {
-		zap.L().Debug("Processing network packet ",
+		logging.Named("datapath").Debug("Processing network packet ",
 			zap.String("flow", p.L4FlowHash()),
 		)
-		defer zap.L().Debug("Finished Processing network packet ",
+		defer logging.Named("datapath").Debug("Finished Processing network packet ",
 			zap.String("flow", p.L4FlowHash()),
 			zap.Error(err),
 		)
 	}
 
+	if d.bypassExcludedPort(p, false) {
+		return nil
+	}
+
+	if d.acceptFailedOpenFlow(p) {
+		return nil
+	}
+
+	if d.dropRevokedFlow(p) {
+		return fmt.Errorf("flow revoked by the policy")
+	}
+
+	defer func() {
+		err = d.failOpen(p, false, err)
+	}()
+
 	// First we must recover the connection for the packet.
 	var conn *connection.UDPConnection
 
 	udpPacketType := p.GetUDPType()
-	zap.L().Debug("Got packet of type:", zap.Reflect("Type", udpPacketType), zap.Reflect("Len", len(p.Buffer)))
+	logging.Named("datapath").Debug("Got packet of type:", zap.Reflect("Type", udpPacketType), zap.Reflect("Len", len(p.Buffer)))
 
 	switch udpPacketType {
 	case packet.UDPSynMask:
 		conn, err = d.netSynUDPRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
-				zap.L().Debug("Packet rejected",
+				logging.Named("datapath").Debug("Packet rejected",
 					zap.String("flow", p.L4FlowHash()),
 					zap.Error(err),
 				)
@@ -61,17 +85,17 @@ func (d *Datapath) ProcessNetworkUDPPacket(p *packet.Packet) (err error) {
 		conn, err = d.netSynAckUDPRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
-				zap.L().Debug("Syn ack Packet Rejected/ignored",
+				logging.Named("datapath").Debug("Syn ack Packet Rejected/ignored",
 					zap.String("flow", p.L4FlowHash()),
 				)
 			}
 			return err
 		}
-	case packet.UDPAckMask:
+	case packet.UDPAckMask, packet.UDPKeyRotateMask, packet.UDPFinMask:
 		conn, err = d.netUDPAckRetrieveState(p)
 		if err != nil {
 			if d.packetLogs {
-				zap.L().Debug("Packet rejected",
+				logging.Named("datapath").Debug("Packet rejected",
 					zap.String("flow", p.L4FlowHash()),
 					zap.Error(err),
 				)
@@ -82,12 +106,28 @@ func (d *Datapath) ProcessNetworkUDPPacket(p *packet.Packet) (err error) {
 		// Process packets that don't have the control header. These are data packets.
 		conn, err = d.netUDPAckRetrieveState(p)
 		if err != nil {
+			if d.unenforcedFlow(p) {
+				return nil
+			}
+
+			context, cerr := d.contextFromIP(false, p.DestinationAddress.String(), p.Mark, p.DestinationPort, packet.IPProtocolUDP)
+
+			// The flows initiated by the network are not enforced.
+			if cerr == nil && !context.EnforcementDirection().Ingress() {
+				d.acceptUnenforcedFlow(p)
+				return nil
+			}
+
 			if d.packetLogs {
-				zap.L().Debug("No connection found for the flow, Dropping it",
+				logging.Named("datapath").Debug("No connection found for the flow, Dropping it",
 					zap.String("flow", p.L4FlowHash()),
 					zap.Error(err),
 				)
 			}
+
+			if cerr != nil {
+				return newNoContextFailure(collector.UnenforcedSource, err)
+			}
 			return err
 		}
 	}
@@ -101,7 +141,7 @@ func (d *Datapath) ProcessNetworkUDPPacket(p *packet.Packet) (err error) {
 	if d.service != nil {
 		if !d.service.PreProcessUDPNetPacket(p, conn.Context, conn) {
 			p.Print(packet.PacketFailureService)
-			return fmt.Errorf("pre  processing failed for network packet")
+			return newServiceFailure(conn.Context, false, conn.Auth.RemoteContextID, fmt.Errorf("pre  processing failed for network packet"))
 		}
 	}
 
@@ -109,7 +149,7 @@ func (d *Datapath) ProcessNetworkUDPPacket(p *packet.Packet) (err error) {
 	action, claims, err := d.processNetUDPPacket(p, conn.Context, conn)
 	if err != nil {
 		if d.packetLogs {
-			zap.L().Debug("Rejecting packet ",
+			logging.Named("datapath").Debug("Rejecting packet ",
 				zap.String("flow", p.L4FlowHash()),
 				zap.Error(err),
 			)
@@ -121,27 +161,35 @@ func (d *Datapath) ProcessNetworkUDPPacket(p *packet.Packet) (err error) {
 	if d.service != nil {
 		if !d.service.PostProcessUDPNetPacket(p, action, claims, conn.Context, conn) {
 			p.Print(packet.PacketFailureService)
-			return fmt.Errorf("post service processing failed for network packet")
+			return newServiceFailure(conn.Context, false, conn.Auth.RemoteContextID, fmt.Errorf("post service processing failed for network packet"))
 		}
 	}
 
+	// Key rotation and teardown packets are not delivered to the application.
+	if udpPacketType == packet.UDPKeyRotateMask || udpPacketType == packet.UDPFinMask {
+		return fmt.Errorf("Drop net control packets (udp)")
+	}
+
 	// If reached the final state, drain the queue.
 	if conn.GetState() == connection.UDPClientSendAck {
 		conn.SetState(connection.UDPData)
-		zap.L().Debug("Draining the queue of application packets")
+		logging.Named("datapath").Debug("Draining the queue of application packets")
 		for udpPacket := conn.ReadPacket(); udpPacket != nil; udpPacket = conn.ReadPacket() {
+			payload := len(udpPacket.GetUDPData())
 			if d.service != nil {
 				// PostProcessServiceInterface
 				// We call it for all outgoing packets.
 				if !d.service.PostProcessUDPAppPacket(udpPacket, nil, conn.Context, conn) {
 					udpPacket.Print(packet.PacketFailureService)
-					zap.L().Error("Failed to encrypt queued packet")
+					logging.Named("datapath").Error("Failed to encrypt queued packet")
 				}
 			}
-			err = d.udpSocketWriter.WriteSocket(udpPacket.Buffer)
+			err = d.writeUDPSocket(udpPacket.Buffer)
 			if err != nil {
-				zap.L().Error("Unable to transmit Queued UDP packets", zap.Error(err))
+				logging.Named("datapath").Error("Unable to transmit Queued UDP packets", zap.Error(err))
+				continue
 			}
+			conn.AddTransmittedBytes(payload)
 		}
 		return fmt.Errorf("Drop the packet")
 	}
@@ -159,6 +207,7 @@ func (d *Datapath) netSynUDPRetrieveState(p *packet.Packet) (*connection.UDPConn
 	// Retrieve the context from the packet information.
 	context, err := d.contextFromIP(false, p.DestinationAddress.String(), p.Mark, p.DestinationPort, packet.IPProtocolUDP)
 	if err != nil {
+		d.reportUnenforcedFlow(p, false)
 		return nil, err
 	}
 
@@ -174,7 +223,9 @@ func (d *Datapath) netSynUDPRetrieveState(p *packet.Packet) (*connection.UDPConn
 
 func (d *Datapath) netSynAckUDPRetrieveState(p *packet.Packet) (*connection.UDPConnection, error) {
 
-	conn, err := d.udpSourcePortConnectionCache.GetReset(p.SourcePortHash(packet.PacketTypeNetwork), 0)
+	// The source port cache is only consulted during the handshake. Do
+	// not extend the lifetime of the entry.
+	conn, err := d.udpSourcePortConnectionCache.Get(p.SourcePortHash(packet.PacketTypeNetwork))
 	if err != nil {
 		return nil, fmt.Errorf("No connection.Drop the syn ack packet")
 	}
@@ -222,6 +273,7 @@ func (d *Datapath) processNetUDPPacket(udpPacket *packet.Packet, context *pucont
 
 		// Mark the state that we have transmitted a SynAck packet.
 		conn.SetState(connection.UDPReceiverSendSynAck)
+		conn.StartHandshake(timeNow(), d.UDPHandshakeTimeout())
 		return action, claims, nil
 
 	case packet.UDPAckMask:
@@ -229,7 +281,7 @@ func (d *Datapath) processNetUDPPacket(udpPacket *packet.Packet, context *pucont
 		// Retrieve the header and parse the signatures.
 		action, claims, err = d.processNetworkUDPAckPacket(udpPacket, context, conn)
 		if err != nil {
-			zap.L().Error("Error during authorization", zap.Error(err))
+			logging.Named("datapath").Error("Error during authorization", zap.Error(err))
 			return action, claims, err
 		}
 
@@ -242,14 +294,14 @@ func (d *Datapath) processNetUDPPacket(udpPacket *packet.Packet, context *pucont
 		// Process the synack header and claims of the other side.
 		action, claims, err = d.processNetworkUDPSynAckPacket(udpPacket, context, conn)
 		if err != nil {
-			zap.L().Error("UDP Syn ack failed with", zap.Error(err))
+			logging.Named("datapath").Error("UDP Syn ack failed with", zap.Error(err))
 			return nil, nil, err
 		}
 
 		// Send back the acknowledgement.
 		err = d.sendUDPAckPacket(udpPacket, context, conn)
 		if err != nil {
-			zap.L().Error("Unable to send udp Syn ack failed", zap.Error(err))
+			logging.Named("datapath").Error("Unable to send udp Syn ack failed", zap.Error(err))
 			return nil, nil, err
 		}
 
@@ -257,10 +309,31 @@ func (d *Datapath) processNetUDPPacket(udpPacket *packet.Packet, context *pucont
 
 		return action, claims, nil
 
+	case packet.UDPKeyRotateMask:
+
+		// Switch the keys of the connection. The state is not changed.
+		if err = d.processNetworkUDPKeyRotatePacket(udpPacket, conn); err != nil {
+			logging.Named("datapath").Debug("Key rotation packet ignored", zap.Error(err))
+			return nil, nil, err
+		}
+
+		return nil, nil, nil
+
+	case packet.UDPFinMask:
+
+		// Release the state of the connection.
+		if err = d.processNetworkUDPFinPacket(udpPacket, conn); err != nil {
+			logging.Named("datapath").Debug("Fin packet ignored", zap.Error(err))
+			return nil, nil, err
+		}
+
+		return nil, nil, nil
+
 	default:
 		state := conn.GetState()
 		if state == connection.UDPReceiverProcessedAck || state == connection.UDPClientSendAck || state == connection.UDPData {
 			conn.SetState(connection.UDPData)
+			conn.AddReceivedBytes(len(udpPacket.GetUDPData()))
 			return nil, nil, nil
 		}
 		return nil, nil, fmt.Errorf("Invalid packet")
@@ -271,20 +344,37 @@ func (d *Datapath) processNetUDPPacket(udpPacket *packet.Packet, context *pucont
 func (d *Datapath) ProcessApplicationUDPPacket(p *packet.Packet) (err error) {
 
 	if d.packetLogs {
-		zap.L().Debug("Processing application UDP packet ",
+		logging.Named("datapath").Debug("Processing application UDP packet ",
 			zap.String("flow", p.L4FlowHash()),
 		)
-		defer zap.L().Debug("Finished Processing UDP application packet ",
+		defer logging.Named("datapath").Debug("Finished Processing UDP application packet ",
 			zap.String("flow", p.L4FlowHash()),
 			zap.Error(err),
 		)
 	}
+
+	if d.bypassExcludedPort(p, true) {
+		return nil
+	}
+
+	if d.acceptFailedOpenFlow(p) {
+		return nil
+	}
+
+	if d.dropRevokedFlow(p) {
+		return fmt.Errorf("flow revoked by the policy")
+	}
+
+	defer func() {
+		err = d.failOpen(p, true, err)
+	}()
+
 	// First retrieve the connection state.
 	var conn *connection.UDPConnection
 	conn, err = d.appUDPRetrieveState(p)
 	if err != nil {
-		zap.L().Debug("Connection not found", zap.Error(err))
-		return fmt.Errorf("Received packet from unenforced process: %s", err)
+		logging.Named("datapath").Debug("Connection not found", zap.Error(err))
+		return wrapError("Received packet from unenforced process", err)
 	}
 
 	// We are processing only one packet from a given connection at a time.
@@ -296,13 +386,29 @@ func (d *Datapath) ProcessApplicationUDPPacket(p *packet.Packet) (err error) {
 		// PreProcessServiceInterface
 		if !d.service.PreProcessUDPAppPacket(p, conn.Context, conn, packet.UDPSynMask) {
 			p.Print(packet.PacketFailureService)
-			return fmt.Errorf("pre service processing failed for UDP application packet")
+			return newServiceFailure(conn.Context, true, conn.Auth.RemoteContextID, fmt.Errorf("pre service processing failed for UDP application packet"))
 		}
 	}
 
 	drop := false
 	switch conn.GetState() {
+	case connection.UDPClosed:
+		// The connection was torn down while this packet was waiting.
+		return fmt.Errorf("Drop packet of closed connection (udp)")
+
 	case connection.UDPStart:
+		// The replies of the flows initiated by the network that are not
+		// enforced, and the flows initiated by the PU if they are not
+		// enforced, are accepted without a handshake.
+		if d.unenforcedFlow(p) {
+			return nil
+		}
+
+		if !conn.Context.EnforcementDirection().Egress() {
+			d.acceptUnenforcedFlow(p)
+			return nil
+		}
+
 		// Queue the packet. We will send it after we authorize the session.
 		if err = conn.QueuePackets(p); err != nil {
 			return fmt.Errorf("Unable to queue packets:%s", err)
@@ -316,15 +422,26 @@ func (d *Datapath) ProcessApplicationUDPPacket(p *packet.Packet) (err error) {
 
 		// Set the state indicating that we send out a Syn packet
 		conn.SetState(connection.UDPClientSendSyn)
+		conn.StartHandshake(timeNow(), d.UDPHandshakeTimeout())
 		// Drop the packet. We stored it in the queue.
 		drop = true
 
 	case connection.UDPReceiverProcessedAck, connection.UDPClientSendAck, connection.UDPData:
 		conn.SetState(connection.UDPData)
-		break
+		conn.AddTransmittedBytes(len(p.GetUDPData()))
+
+		// The packet is still transmitted with the current keys.
+		if conn.KeyRotationDue(d.UDPKeyRotationInterval()) {
+			if rerr := d.sendUDPKeyRotatePacket(p, conn); rerr != nil {
+				logging.Named("datapath").Debug("Unable to start key rotation",
+					zap.String("flow", p.L4FlowHash()),
+					zap.Error(rerr),
+				)
+			}
+		}
 
 	default:
-		zap.L().Debug("Packet is added to the queue", zap.String("flow", p.L4FlowHash()))
+		logging.Named("datapath").Debug("Packet is added to the queue", zap.String("flow", p.L4FlowHash()))
 		if err = conn.QueuePackets(p); err != nil {
 			return fmt.Errorf("Unable to queue packets:%s", err)
 		}
@@ -336,7 +453,7 @@ func (d *Datapath) ProcessApplicationUDPPacket(p *packet.Packet) (err error) {
 		// PostProcessServiceInterface
 		if !d.service.PostProcessUDPAppPacket(p, nil, conn.Context, conn) {
 			p.Print(packet.PacketFailureService)
-			return fmt.Errorf("Encryption failed for application packet")
+			return newServiceFailure(conn.Context, true, conn.Auth.RemoteContextID, fmt.Errorf("Encryption failed for application packet"))
 		}
 	}
 
@@ -361,7 +478,11 @@ func (d *Datapath) appUDPRetrieveState(p *packet.Packet) (*connection.UDPConnect
 
 	context, err := d.contextFromIP(true, p.SourceAddress.String(), p.Mark, p.SourcePort, packet.IPProtocolUDP)
 	if err != nil {
-		return nil, fmt.Errorf("No context in app processing")
+		// Flows that fail open are reported when the packet is accepted.
+		if !d.failsOpen(FailureNoContext) {
+			d.reportUnenforcedFlow(p, true)
+		}
+		return nil, newNoContextFailure(collector.UnenforcedSource, fmt.Errorf("No context in app processing"))
 	}
 
 	return connection.NewUDPConnection(context, d.udpSocketWriter), nil
@@ -370,6 +491,11 @@ func (d *Datapath) appUDPRetrieveState(p *packet.Packet) (*connection.UDPConnect
 // processApplicationUDPSynPacket processes a single Syn Packet
 func (d *Datapath) processApplicationUDPSynPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) (err error) {
 
+	if context.Draining() {
+		d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), collector.DefaultEndPoint, context, collector.PUDraining, nil, nil)
+		return fmt.Errorf("UDP Syn packet dropped because the PU is draining")
+	}
+
 	if !addressMatch(udpPacket.DestinationAddress, context.UDPNetworks()) {
 		d.reportUDPExternalFlow(udpPacket, context, true, nil, nil)
 		return fmt.Errorf("No target found")
@@ -382,10 +508,14 @@ func (d *Datapath) processApplicationUDPSynPacket(udpPacket *packet.Packet, cont
 		return err
 	}
 
-	newPacket, err := d.clonePacketHeaders(udpPacket)
+	// The buffer of the clone is released on return. The hashes of the
+	// clone are computed before.
+	newPacket, buffer, err := d.clonePacketHeaders(udpPacket, len(udpOptions)+len(udpData))
 	if err != nil {
 		return fmt.Errorf("Unable to clone packet: %s", err)
 	}
+	defer handshakeBuffers.put(buffer)
+
 	// Attach the UDP data and token
 	newPacket.UDPTokenAttach(udpOptions, udpData)
 
@@ -393,8 +523,8 @@ func (d *Datapath) processApplicationUDPSynPacket(udpPacket *packet.Packet, cont
 	// err = d.udpSocketWriter.WriteSocket(newPacket.Buffer)
 	err = d.writeWithRetransmit(newPacket.Buffer, conn.SynChannel())
 	if err != nil {
-		zap.L().Error("Unable to send syn token on raw socket", zap.Error(err))
-		return fmt.Errorf("unable to transmit syn packet")
+		d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), collector.DefaultEndPoint, context, collector.SocketWriteFailed, nil, nil)
+		return fmt.Errorf("unable to transmit syn packet: %s", err)
 	}
 
 	// Poplate the caches to track the connection
@@ -410,37 +540,80 @@ func (d *Datapath) processApplicationUDPSynPacket(udpPacket *packet.Packet, cont
 
 func (d *Datapath) writeWithRetransmit(buffer []byte, stop chan bool) error {
 
-	localBuffer := make([]byte, len(buffer))
-	copy(localBuffer, buffer)
+	// The copy is owned by the retransmissions and released when they stop.
+	localBuffer := handshakeBuffers.get(len(buffer))
+	copy(*localBuffer, buffer)
 
-	if err := d.udpSocketWriter.WriteSocket(localBuffer); err != nil {
-		zap.L().Error("Failed to write control packet to socket", zap.Error(err))
+	if err := d.writeUDPSocket(*localBuffer); err != nil {
+		handshakeBuffers.put(localBuffer)
 		return err
 	}
 
 	go func() {
+		defer handshakeBuffers.put(localBuffer)
+
 		for retries := 0; retries < retransmitRetries; retries++ {
 			delay := time.Millisecond * time.Duration((retransmitDelay * (retries + 1)))
 			select {
 			case <-stop:
 				return
 			case <-time.After(delay):
-				if err := d.udpSocketWriter.WriteSocket(localBuffer); err != nil {
-					zap.L().Error("Failed to write control packet to socket", zap.Error(err))
-				}
+				d.writeUDPSocket(*localBuffer) // nolint
 			}
 		}
 	}()
 	return nil
 }
 
-func (d *Datapath) clonePacketHeaders(p *packet.Packet) (*packet.Packet, error) {
-	// copy the ip and udp headers.
-	newPacket := make([]byte, packet.UDPDataPos)
+// writeUDPSocket writes a packet on the raw socket and keeps track of the
+// failures. Failures are escalated once they persist, since a failing socket
+// drops all the handshakes. The packets larger than the MTU of their path
+// can be fragmented.
+func (d *Datapath) writeUDPSocket(buffer []byte) error {
+
+	d.fitPathMTU(buffer)
+
+	if err := d.udpSocketWriter.WriteSocket(buffer); err != nil {
+		total := atomic.AddUint64(&d.udpSocketWriteFailures, 1)
+		consecutive := atomic.AddUint32(&d.udpSocketConsecutiveFailures, 1)
+		if consecutive >= udpSocketFailureThreshold {
+			logging.Named("datapath").Error("Raw socket writes are failing",
+				zap.Uint32("consecutive", consecutive),
+				zap.Uint64("total", total),
+				zap.Error(err),
+			)
+		} else {
+			logging.Named("datapath").Debug("Failed to write packet to raw socket",
+				zap.Uint32("consecutive", consecutive),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	atomic.StoreUint32(&d.udpSocketConsecutiveFailures, 0)
+	return nil
+}
+
+// clonePacketHeaders returns a packet with the headers of the given packet.
+// The buffer of the clone is pooled and has room for dataLen bytes of data.
+// It is returned with the clone and must be released once the clone is not
+// used anymore.
+func (d *Datapath) clonePacketHeaders(p *packet.Packet, dataLen int) (*packet.Packet, *[]byte, error) {
+	// copy the ip and udp headers. The TOS byte is part of the copied
+	// header, so the DSCP and ECN bits of the application packet are kept.
+	buffer := handshakeBuffers.get(packet.UDPDataPos + dataLen)
+	newPacket := (*buffer)[:packet.UDPDataPos]
 	p.FixupIPHdrOnDataModify(p.IPTotalLength, packet.UDPDataPos)
 	_ = copy(newPacket, p.Buffer[:packet.UDPDataPos])
 
-	return packet.New(packet.PacketTypeApplication, newPacket, p.Mark, true)
+	clone, err := packet.New(packet.PacketTypeApplication, newPacket, p.Mark, true)
+	if err != nil {
+		handshakeBuffers.put(buffer)
+		return nil, nil, err
+	}
+
+	return clone, buffer, nil
 }
 
 // CreateUDPAuthMarker creates a UDP auth marker.
@@ -448,9 +621,9 @@ func (d *Datapath) CreateUDPAuthMarker(packetType uint8) []byte {
 
 	// Every UDP control packet has a 20 byte packet signature. The
 	// first 2 bytes represent the following control information.
-	// Byte 0 : Bits 0,1 are reserved fields.
+	// Byte 0 : Bits 0,1 represent extended udp packet types.
 	//          Bits 2,3,4 represent version information.
-	//          Bits 5, 6 represent udp packet type,
+	//          Bits 5,6 represent udp packet type,
 	//          Bit 7 represents encryption. (currently unused).
 	// Byte 1: reserved for future use.
 	// Bytes [2:20]: Packet signature.
@@ -489,8 +662,8 @@ func (d *Datapath) sendUDPSynAckPacket(udpPacket *packet.Packet, context *pucont
 
 	// Only start the retransmission timer once. Not on every packet.
 	if err := d.writeWithRetransmit(udpPacket.Buffer, conn.SynAckChannel()); err != nil {
-		zap.L().Debug("Unable to send synack token on raw socket", zap.Error(err))
-		return err
+		d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.SocketWriteFailed, nil, nil)
+		return fmt.Errorf("unable to transmit synack packet: %s", err)
 	}
 
 	return nil
@@ -499,7 +672,7 @@ func (d *Datapath) sendUDPSynAckPacket(udpPacket *packet.Packet, context *pucont
 func (d *Datapath) sendUDPAckPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) (err error) {
 
 	// Create UDP Option
-	zap.L().Debug("Sending UDP Ack packet", zap.String("flow", udpPacket.L4ReverseFlowHash()))
+	logging.Named("datapath").Debug("Sending UDP Ack packet", zap.String("flow", udpPacket.L4ReverseFlowHash()))
 	udpOptions := d.CreateUDPAuthMarker(packet.UDPAckMask)
 
 	udpData, err := d.tokenAccessor.CreateAckPacketToken(context, &conn.Auth)
@@ -508,47 +681,90 @@ func (d *Datapath) sendUDPAckPacket(udpPacket *packet.Packet, context *pucontext
 		return err
 	}
 
-	srcPortHash, err := d.udpNatConnectionTracker.GetReset(udpPacket.SourcePortHash(packet.PacketTypeNetwork), 0)
-	if err != nil {
-		return fmt.Errorf("error getting actual destination")
+	// The ack is sent to the original destination of the syn before NAT. If
+	// the NAT entry expired, the address the synack came from is used, so that
+	// the handshake can still complete. The address is copied since it refers
+	// to the buffer of the packet that is reversed below.
+	destIP := append(net.IP{}, udpPacket.SourceAddress...)
+	destPort := udpPacket.SourcePort
+
+	destIPPort, nerr := d.udpNatConnectionTracker.Get(udpPacket.SourcePortHash(packet.PacketTypeNetwork))
+	if nerr == nil {
+		natIP, natPort, perr := parseHostPort(destIPPort.(string))
+		if perr != nil {
+			d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidNATEntry, nil, nil)
+			return fmt.Errorf("invalid nat entry for flow %s: %s", udpPacket.L4ReverseFlowHash(), perr)
+		}
+
+		// The packets of the datapath are IPv4, and an IPv4 flow has no IPv6
+		// source to answer an IPv6 destination from. IPv6 NAT destinations are
+		// not supported and are handled like a missing NAT entry.
+		if natIP.To4() != nil {
+			destIP, destPort = natIP, natPort
+		} else {
+			nerr = fmt.Errorf("ipv6 nat destination %s", natIP)
+		}
 	}
 
-	destIPPort := srcPortHash.(string)
-	destIP := strings.Split(destIPPort, ":")[0]
-	destPort, err := (strconv.Atoi(strings.Split(destIPPort, ":")[1]))
-	if err != nil {
-		return fmt.Errorf("Unable to get dest port from cache")
+	if nerr != nil {
+		logging.Named("datapath").Debug("No nat entry for flow, sending ack to the observed address",
+			zap.String("flow", udpPacket.L4ReverseFlowHash()),
+			zap.Error(nerr),
+		)
+
+		accept := &policy.FlowPolicy{
+			Action:   policy.Accept,
+			PolicyID: "default",
+		}
+		d.reportFlow(udpPacket, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.MissingNATEntry, accept, accept)
 	}
 
-	udpPacket.CreateReverseFlowPacket(net.ParseIP(destIP), uint16(destPort))
+	udpPacket.CreateReverseFlowPacket(destIP, destPort)
 
 	// Attach the UDP data and token
 	udpPacket.UDPTokenAttach(udpOptions, udpData)
 
 	// send packet
-	err = d.udpSocketWriter.WriteSocket(udpPacket.Buffer)
+	err = d.writeUDPSocket(udpPacket.Buffer)
 	if err != nil {
-		zap.L().Debug("Unable to send ack token on raw socket", zap.Error(err))
-		return err
+		d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.SocketWriteFailed, nil, nil)
+		return fmt.Errorf("unable to transmit ack packet: %s", err)
+	}
+
+	// The service marks its sockets with the mark of the connection.
+	if conn.ServiceConnection {
+		conn.SetConnMark(d.filterQueue.GetConnMark())
 	}
 
 	if !conn.ServiceConnection {
-		zap.L().Debug("Plumbing the conntrack (app) rule for flow", zap.String("flow", udpPacket.L4FlowHash()))
-		if err = d.conntrackHdl.ConntrackTableUpdateMark(
-			destIP,
+		logging.Named("datapath").Debug("Plumbing the conntrack (app) rule for flow", zap.String("flow", udpPacket.L4FlowHash()))
+		if err = d.updateConntrackMark(
+			destIP.String(),
 			udpPacket.SourceAddress.String(),
 			udpPacket.IPProto,
-			uint16(destPort),
+			destPort,
 			udpPacket.SourcePort,
-			constants.DefaultConnMark,
+			d.filterQueue.GetConnMark(),
 		); err != nil {
-			zap.L().Error("Failed to update conntrack table for flow",
+			logging.Named("datapath").Error("Failed to update conntrack table for flow",
 				zap.String("context", string(conn.Auth.LocalContext)),
 				zap.String("app-conn", udpPacket.L4FlowHash()),
 				zap.String("state", fmt.Sprintf("%d", conn.GetState())),
 				zap.Error(err),
 			)
 		}
+
+		d.recordMarkedFlow(
+			context,
+			conn.RemoteTags,
+			false,
+			udpPacket.L4ReverseFlowHash(),
+			destIP.String(),
+			udpPacket.SourceAddress.String(),
+			udpPacket.IPProto,
+			destPort,
+			udpPacket.SourcePort,
+		)
 	}
 	return nil
 }
@@ -556,7 +772,28 @@ func (d *Datapath) sendUDPAckPacket(udpPacket *packet.Packet, context *pucontext
 // processNetworkUDPSynPacket processes a syn packet arriving from the network
 func (d *Datapath) processNetworkUDPSynPacket(context *pucontext.PUContext, conn *connection.UDPConnection, udpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
+	// The claims and the policy are kept for the audit of the rejects.
+	var remoteClaims *tokens.ConnectionClaims
+	var flowPolicy *policy.FlowPolicy
+	defer func() {
+		d.auditUDPDecision(AuditStageUDPSyn, udpPacket, context, conn, remoteClaims, flowPolicy, err)
+	}()
+
+	// Retransmissions of an accepted syn are not counted again.
+	if conn.GetState() == connection.UDPStart && context.Draining() {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.PUDraining, nil, nil)
+		return nil, nil, fmt.Errorf("UDP Syn packet dropped because the PU is draining")
+	}
+
+	if conn.GetState() == connection.UDPStart && !context.AllowConnection(udpPacket.SourceAddress.String()) {
+		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.RateLimited, nil, nil)
+		return nil, nil, fmt.Errorf("UDP Syn packet dropped because of rate limit")
+	}
+
+	previousNonce := conn.Auth.RemoteContext
+
 	claims, err = d.tokenAccessor.ParsePacketToken(&conn.Auth, udpPacket.ReadUDPToken())
+	remoteClaims = claims
 	if err != nil {
 		d.reportUDPRejectedFlow(udpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
 		return nil, nil, fmt.Errorf("UDP Syn packet dropped because of invalid token: %s", err)
@@ -569,21 +806,39 @@ func (d *Datapath) processNetworkUDPSynPacket(context *pucontext.PUContext, conn
 		return nil, nil, fmt.Errorf("UDP Syn packet dropped because of no claims")
 	}
 
-	// Why is this required. Take a look.
-	txLabel, _ := claims.T.Get(enforcerconstants.TransmitterLabel)
+	// The transmitter is used as the source of the reported flows.
+	txLabel, _ := claims.Transmitter()
 
-	// Add the port as a label with an @ prefix. These labels are invalid otherwise
 	// If all policies are restricted by port numbers this will allow port-specific policies
-	claims.T.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(udpPacket.DestinationPort)))
+	tags := claims.TagsWithPort(udpPacket.DestinationPort)
 
-	report, pkt := context.SearchRcvRules(claims.T)
+	report, pkt := context.SearchRcvRules(tags)
+	flowPolicy = pkt
 	if pkt.Action.Rejected() {
 		d.reportUDPRejectedFlow(udpPacket, conn, txLabel, context.ManagementID(), context, collector.PolicyDrop, report, pkt)
-		return nil, nil, fmt.Errorf("connection rejected because of policy: %s", claims.T.String())
+		return nil, nil, fmt.Errorf("connection rejected because of policy: %s", tags.String())
 	}
 
 	hash := udpPacket.L4FlowHash()
 
+	// A retransmitted syn carries the nonce of the pending handshake of the
+	// flow, or of the connection it created.
+	retransmission := d.udpHandshakes.isPending(hash) || bytes.Equal(previousNonce, conn.Auth.RemoteContext)
+
+	// The connection is only tracked if there is room for another half
+	// open connection. Retransmissions use the slot of the first syn.
+	if !d.udpHandshakes.acquire(hash, context.ID()) {
+		d.reportUDPRejectedFlow(udpPacket, conn, txLabel, context.ManagementID(), context, collector.ResourceExhausted, nil, nil)
+		return nil, nil, fmt.Errorf("UDP Syn packet dropped because of too many half open connections")
+	}
+
+	// The nonce of a new handshake must not have been used by another one.
+	if !retransmission && !d.udpNonces.consume(conn.Auth.RemoteContext) {
+		d.udpHandshakes.release(hash)
+		d.reportUDPRejectedFlow(udpPacket, conn, txLabel, context.ManagementID(), context, collector.InvalidNonse, nil, nil)
+		return nil, nil, fmt.Errorf("UDP Syn packet dropped because of a replayed nonce")
+	}
+
 	// conntrack
 	d.udpNetOrigConnectionTracker.AddOrUpdate(hash, conn)
 	d.udpAppReplyConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
@@ -591,17 +846,26 @@ func (d *Datapath) processNetworkUDPSynPacket(context *pucontext.PUContext, conn
 	// Record actions
 	conn.ReportFlowPolicy = report
 	conn.PacketFlowPolicy = pkt
+	conn.RemoteTags = tags
 
 	return pkt, claims, nil
 }
 
 func (d *Datapath) processNetworkUDPSynAckPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
+	// The claims and the policy are kept for the audit of the rejects.
+	var remoteClaims *tokens.ConnectionClaims
+	var flowPolicy *policy.FlowPolicy
+	defer func() {
+		d.auditUDPDecision(AuditStageUDPSynAck, udpPacket, context, conn, remoteClaims, flowPolicy, err)
+	}()
+
 	conn.SynStop()
 
 	// Packets that have authorization information go through the auth path
 	// Decode the JWT token using the context key
 	claims, err = d.tokenAccessor.ParsePacketToken(&conn.Auth, udpPacket.ReadUDPToken())
+	remoteClaims = claims
 	if err != nil {
 		d.reportUDPRejectedFlow(udpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
 		return nil, nil, fmt.Errorf("SynAck packet dropped because of bad claims: %s", err)
@@ -612,20 +876,31 @@ func (d *Datapath) processNetworkUDPSynAckPacket(udpPacket *packet.Packet, conte
 		return nil, nil, fmt.Errorf("SynAck packet dropped because of no claims")
 	}
 
-	report, pkt := context.SearchTxtRules(claims.T, !d.mutualAuthorization)
+	report, pkt := context.SearchTxtRules(claims.T, !context.MutualAuthorization(d.mutualAuthorization))
+	flowPolicy = pkt
 	if pkt.Action.Rejected() {
 		d.reportUDPRejectedFlow(udpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.PolicyDrop, report, pkt)
 		return nil, nil, fmt.Errorf("dropping because of reject rule on transmitter: %s", claims.T.String())
 	}
 
+	d.reportRelaxedMutualAuthFlow(udpPacket, context, conn.Auth.RemoteContextID, claims)
+
+	conn.RemoteTags = claims.T
+
 	// conntrack
-	d.udpNetReplyConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+	conn.NetReplyHash = udpPacket.L4FlowHash()
+	d.udpNetReplyConnectionTracker.AddOrUpdate(conn.NetReplyHash, conn)
 
 	return pkt, claims, nil
 }
 
 func (d *Datapath) processNetworkUDPAckPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
+	// The ack carries no claims. The decision follows the policy of the syn.
+	defer func() {
+		d.auditUDPDecision(AuditStageUDPAck, udpPacket, context, conn, nil, conn.PacketFlowPolicy, err)
+	}()
+
 	conn.SynAckStop()
 
 	_, err = d.tokenAccessor.ParseAckToken(&conn.Auth, udpPacket.ReadUDPToken())
@@ -634,22 +909,396 @@ func (d *Datapath) processNetworkUDPAckPacket(udpPacket *packet.Packet, context
 		return nil, nil, fmt.Errorf("ack packet dropped because signature validation failed: %s", err)
 	}
 
+	// The handshake is complete.
+	d.udpHandshakes.release(udpPacket.L4FlowHash())
+
+	// The service marks its sockets with the mark of the connection.
+	if conn.ServiceConnection {
+		conn.SetConnMark(d.filterQueue.GetConnMark())
+	}
+
 	if !conn.ServiceConnection {
-		zap.L().Debug("Plumb conntrack rule for flow:", zap.String("flow", udpPacket.L4FlowHash()))
+		logging.Named("datapath").Debug("Plumb conntrack rule for flow:", zap.String("flow", udpPacket.L4FlowHash()))
 		// Plumb connmark rule here.
-		if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		if err := d.updateConntrackMark(
 			udpPacket.DestinationAddress.String(),
 			udpPacket.SourceAddress.String(),
 			udpPacket.IPProto,
 			udpPacket.DestinationPort,
 			udpPacket.SourcePort,
-			constants.DefaultConnMark,
+			d.filterQueue.GetConnMark(),
 		); err != nil {
-			zap.L().Error("Failed to update conntrack table after ack packet")
+			logging.Named("datapath").Error("Failed to update conntrack table after ack packet")
 		}
+
+		d.recordMarkedFlow(
+			context,
+			conn.RemoteTags,
+			true,
+			udpPacket.L4FlowHash(),
+			udpPacket.DestinationAddress.String(),
+			udpPacket.SourceAddress.String(),
+			udpPacket.IPProto,
+			udpPacket.DestinationPort,
+			udpPacket.SourcePort,
+		)
 	}
 
 	d.reportUDPAcceptedFlow(udpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID(), context, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
 
 	return nil, nil, nil
 }
+
+// sendUDPKeyRotatePacket proposes a new local context to the remote side of an
+// established connection. The keys are switched when the reply is received.
+func (d *Datapath) sendUDPKeyRotatePacket(udpPacket *packet.Packet, conn *connection.UDPConnection) error {
+
+	localContext, err := conn.StartKeyRotation()
+	if err != nil {
+		return err
+	}
+
+	udpData, err := d.tokenAccessor.CreateKeyRotationToken(&conn.Auth, localContext, conn.Auth.RemoteContext)
+	if err != nil {
+		conn.CancelKeyRotation()
+		return err
+	}
+
+	// The application packet is still transmitted. Clone the headers from a copy.
+	buffer := handshakeBuffers.get(len(udpPacket.Buffer))
+	defer handshakeBuffers.put(buffer)
+	copy(*buffer, udpPacket.Buffer)
+
+	copyPacket, err := packet.New(packet.PacketTypeApplication, *buffer, udpPacket.Mark, true)
+	if err != nil {
+		conn.CancelKeyRotation()
+		return fmt.Errorf("Unable to copy packet: %s", err)
+	}
+
+	newPacket, cloneBuffer, err := d.clonePacketHeaders(copyPacket, packet.UDPSignatureLen+len(udpData))
+	if err != nil {
+		conn.CancelKeyRotation()
+		return fmt.Errorf("Unable to clone packet: %s", err)
+	}
+	defer handshakeBuffers.put(cloneBuffer)
+
+	newPacket.UDPTokenAttach(d.CreateUDPAuthMarker(packet.UDPKeyRotateMask), udpData)
+
+	if err := d.writeWithRetransmit(newPacket.Buffer, conn.KeyRotateChannel()); err != nil {
+		conn.CancelKeyRotation()
+		return fmt.Errorf("unable to transmit key rotation packet: %s", err)
+	}
+
+	return nil
+}
+
+// sendUDPKeyRotateReply acknowledges a key rotation with the current contexts
+// of the connection.
+func (d *Datapath) sendUDPKeyRotateReply(udpPacket *packet.Packet, conn *connection.UDPConnection) error {
+
+	udpData, err := d.tokenAccessor.CreateKeyRotationToken(&conn.Auth, conn.Auth.LocalContext, conn.Auth.RemoteContext)
+	if err != nil {
+		return err
+	}
+
+	udpPacket.CreateReverseFlowPacket(udpPacket.SourceAddress, udpPacket.SourcePort)
+
+	udpPacket.UDPTokenAttach(d.CreateUDPAuthMarker(packet.UDPKeyRotateMask), udpData)
+
+	if err := d.writeUDPSocket(udpPacket.Buffer); err != nil {
+		return fmt.Errorf("unable to transmit key rotation reply: %s", err)
+	}
+
+	return nil
+}
+
+// processNetworkUDPKeyRotatePacket processes a key rotation packet. It is either
+// the reply to a rotation started locally or a request from the remote side.
+func (d *Datapath) processNetworkUDPKeyRotatePacket(udpPacket *packet.Packet, conn *connection.UDPConnection) error {
+
+	state := conn.GetState()
+	if state != connection.UDPReceiverProcessedAck && state != connection.UDPClientSendAck && state != connection.UDPData {
+		return fmt.Errorf("key rotation on a connection that is not established")
+	}
+
+	claims, err := d.tokenAccessor.ParseKeyRotationToken(&conn.Auth, udpPacket.ReadUDPToken())
+	if err != nil {
+		return fmt.Errorf("key rotation packet dropped because signature validation failed: %s", err)
+	}
+
+	// Reply to our own request. Switch to the new keys.
+	pending := conn.PendingContext()
+	if pending != nil && bytes.Equal(claims.RMT, pending) {
+		conn.KeyRotateStop()
+		conn.RotateKeys(pending, claims.LCL)
+		return nil
+	}
+
+	// Retransmission of a request that was already accepted. The reply was lost.
+	previousLocal, _ := conn.PreviousContexts()
+	if previousLocal != nil && bytes.Equal(claims.RMT, previousLocal) && bytes.Equal(claims.LCL, conn.Auth.RemoteContext) {
+		return d.sendUDPKeyRotateReply(udpPacket, conn)
+	}
+
+	if !bytes.Equal(claims.RMT, conn.Auth.LocalContext) {
+		return fmt.Errorf("failed to match context in key rotation packet")
+	}
+
+	// A request always proposes a new context. This is a replayed reply.
+	if bytes.Equal(claims.LCL, conn.Auth.RemoteContext) {
+		return fmt.Errorf("stale key rotation packet")
+	}
+
+	// Both sides started a rotation. The side with the lower context yields.
+	if pending != nil {
+		if bytes.Compare(pending, claims.LCL) > 0 {
+			return fmt.Errorf("concurrent key rotation: waiting for reply")
+		}
+		conn.CancelKeyRotation()
+	}
+
+	localContext, err := crypto.GenerateRandomBytes(16)
+	if err != nil {
+		return err
+	}
+
+	conn.RotateKeys(localContext, claims.LCL)
+
+	return d.sendUDPKeyRotateReply(udpPacket, conn)
+}
+
+// SendUDPFinPacket tears down the connection of an application flow and
+// notifies the remote side, so that state is released before the idle timeout.
+// It is used when the application closes a connection.
+func (d *Datapath) SendUDPFinPacket(p *packet.Packet) error {
+
+	hash := p.L4FlowHash()
+
+	initiator := false
+	item, err := d.udpAppReplyConnectionTracker.Get(hash)
+	if err != nil {
+		if item, err = d.udpAppOrigConnectionTracker.Get(hash); err != nil {
+			return fmt.Errorf("app state not found: %s", err)
+		}
+		initiator = true
+	}
+	conn := item.(*connection.UDPConnection)
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	return d.sendUDPFinPacket(p, conn, initiator)
+}
+
+// sendUDPFinPacket sends a teardown packet for the connection and releases
+// the local state. Packets of the flow that are processed afterwards will
+// start a new connection.
+func (d *Datapath) sendUDPFinPacket(udpPacket *packet.Packet, conn *connection.UDPConnection, initiator bool) error {
+
+	state := conn.GetState()
+	if state != connection.UDPReceiverProcessedAck && state != connection.UDPClientSendAck && state != connection.UDPData {
+		return fmt.Errorf("connection is not established")
+	}
+
+	udpData, err := d.tokenAccessor.CreateFinToken(&conn.Auth)
+	if err != nil {
+		return err
+	}
+
+	// The packet of the caller is not modified. Clone the headers from a copy.
+	buffer := handshakeBuffers.get(len(udpPacket.Buffer))
+	defer handshakeBuffers.put(buffer)
+	copy(*buffer, udpPacket.Buffer)
+
+	copyPacket, err := packet.New(packet.PacketTypeApplication, *buffer, udpPacket.Mark, true)
+	if err != nil {
+		return fmt.Errorf("Unable to copy packet: %s", err)
+	}
+
+	newPacket, cloneBuffer, err := d.clonePacketHeaders(copyPacket, packet.UDPSignatureLen+len(udpData))
+	if err != nil {
+		return fmt.Errorf("Unable to clone packet: %s", err)
+	}
+	defer handshakeBuffers.put(cloneBuffer)
+
+	newPacket.UDPTokenAttach(d.CreateUDPAuthMarker(packet.UDPFinMask), udpData)
+
+	// The local state is released even if the remote side is not notified.
+	// It will expire there.
+	d.closeUDPConnection(conn, udpPacket.L4FlowHash(), initiator)
+
+	if err := d.writeUDPSocket(newPacket.Buffer); err != nil {
+		return fmt.Errorf("unable to transmit fin packet: %s", err)
+	}
+
+	return nil
+}
+
+// processNetworkUDPFinPacket validates a teardown packet and releases the
+// state of the connection. The nonce of the packet is consumed, so that a
+// teardown packet cannot be replayed.
+func (d *Datapath) processNetworkUDPFinPacket(udpPacket *packet.Packet, conn *connection.UDPConnection) error {
+
+	state := conn.GetState()
+	if state != connection.UDPReceiverProcessedAck && state != connection.UDPClientSendAck && state != connection.UDPData {
+		return fmt.Errorf("fin on a connection that is not established")
+	}
+
+	// The remote side may have sent the fin before it switched to the keys
+	// of the last rotation.
+	claims, err := d.tokenAccessor.ParseFinToken(&conn.Auth, udpPacket.ReadUDPToken())
+	if previous := conn.PreviousAuth(); err != nil && previous != nil {
+		claims, err = d.tokenAccessor.ParseFinToken(previous, udpPacket.ReadUDPToken())
+	}
+	if err != nil {
+		return fmt.Errorf("fin packet dropped because signature validation failed: %s", err)
+	}
+
+	if !d.udpNonces.consume(claims.FIN) {
+		return fmt.Errorf("fin packet dropped because it was replayed")
+	}
+
+	// The replies of the connections opened by the local PU are tracked
+	// as they arrive from the network. The application flow goes to the
+	// destination before NAT.
+	hash := udpPacket.L4FlowHash()
+	if _, err := d.udpNetReplyConnectionTracker.Get(hash); err != nil {
+		d.closeUDPConnection(conn, udpPacket.L4ReverseFlowHash(), false)
+		return nil
+	}
+
+	destIP := udpPacket.SourceAddress
+	destPort := udpPacket.SourcePort
+	if destIPPort, nerr := d.udpNatConnectionTracker.Get(udpPacket.SourcePortHash(packet.PacketTypeNetwork)); nerr == nil {
+		if ip, port, perr := parseHostPort(destIPPort.(string)); perr == nil {
+			destIP, destPort = ip, port
+		}
+	}
+
+	reply := &flowTuple{
+		protocol:        udpPacket.IPProto,
+		source:          destIP.String(),
+		destination:     udpPacket.DestinationAddress.String(),
+		sourcePort:      destPort,
+		destinationPort: udpPacket.DestinationPort,
+	}
+
+	d.closeUDPConnection(conn, reply.reverseHash(), true)
+
+	return nil
+}
+
+// closeUDPConnection removes the connection from the trackers and clears the
+// conntrack mark of the flow. The flow is given by its hash as seen from the
+// application, and initiator is set if the local PU opened it. Data packets
+// that are in flight are dropped, since they don't find the connection
+// anymore.
+func (d *Datapath) closeUDPConnection(conn *connection.UDPConnection, appHash string, initiator bool) {
+
+	flow, err := parseFlowHash(appHash)
+	if err != nil {
+		logging.Named("datapath").Debug("Invalid flow of closed connection", zap.Error(err))
+		return
+	}
+
+	// The network flow of a connection opened by the local PU is the one of
+	// its replies, which come from the destination after NAT.
+	netHash := flow.reverseHash()
+	if initiator && conn.NetReplyHash != "" {
+		netHash = conn.NetReplyHash
+	}
+
+	d.releaseUDPConnection(conn, netHash, appHash)
+	d.markedFlows.Remove(netHash) // nolint
+
+	if conn.ServiceConnection {
+		return
+	}
+
+	// The conntrack mark is set with the tuple of the replies of the flow,
+	// with the destination before NAT.
+	if initiator {
+		err = d.updateConntrackMark(flow.destinationIP(), flow.sourceIP(), flow.protocol, flow.destinationPort, flow.sourcePort, 0)
+	} else {
+		err = d.updateConntrackMark(flow.sourceIP(), flow.destinationIP(), flow.protocol, flow.sourcePort, flow.destinationPort, 0)
+	}
+	if err != nil {
+		logging.Named("datapath").Debug("Failed to clear conntrack mark for closed flow",
+			zap.String("flow", appHash),
+			zap.Error(err),
+		)
+	}
+}
+
+// releaseUDPConnection closes the connection and removes it from the trackers.
+// The traffic that was not reported yet is reported.
+func (d *Datapath) releaseUDPConnection(conn *connection.UDPConnection, netHash, appHash string) {
+
+	d.reportUDPTraffic(conn)
+
+	conn.SetState(connection.UDPClosed)
+	conn.SynStop()
+	conn.SynAckStop()
+	conn.AckStop()
+	conn.KeyRotateStop()
+	conn.DropPackets()
+
+	d.udpHandshakes.release(netHash)
+
+	d.udpNetOrigConnectionTracker.Remove(netHash)  // nolint
+	d.udpNetReplyConnectionTracker.Remove(netHash) // nolint
+	d.udpAppOrigConnectionTracker.Remove(appHash)  // nolint
+	d.udpAppReplyConnectionTracker.Remove(appHash) // nolint
+}
+
+// udpConnectionExpired is the expiration notifier of the application UDP
+// connections. The cache is locked when it is called, so the connection is
+// reaped in another routine.
+func (d *Datapath) udpConnectionExpired(c cache.DataStore, id interface{}, item interface{}) {
+
+	if conn, ok := item.(*connection.UDPConnection); ok {
+		go d.reapUDPConnection(conn)
+	}
+}
+
+// reapUDPConnection discards the application packets of an expired connection
+// whose handshake did not complete. They are reported with the flow, so that
+// the data lost because of a failed handshake can be told apart from a
+// rejection. The traffic of an expired connection that was accepted is
+// reported instead.
+func (d *Datapath) reapUDPConnection(conn *connection.UDPConnection) {
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	switch conn.GetState() {
+	case connection.UDPClosed:
+		return
+	case connection.UDPClientSendAck, connection.UDPReceiverProcessedAck, connection.UDPData:
+		d.reportUDPTraffic(conn)
+		return
+	}
+
+	first := conn.DiscardPackets()
+	if first == nil {
+		return
+	}
+
+	packets, size := conn.DroppedPackets()
+	logging.Named("datapath").Debug("Dropped the queued packets of an incomplete handshake",
+		zap.String("flow", first.L4FlowHash()),
+		zap.Int("packets", packets),
+		zap.Int("bytes", size),
+	)
+
+	report := &policy.FlowPolicy{
+		Action:   policy.Reject,
+		PolicyID: "default",
+	}
+
+	record := flowRecord(first, conn.Context.ManagementID(), collector.DefaultEndPoint, conn.Context, collector.HandshakeTimeout, report, report)
+	record.DroppedPackets = packets
+	record.DroppedBytes = size
+
+	d.collector.CollectFlowEvent(record)
+}
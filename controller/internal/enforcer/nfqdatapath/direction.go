@@ -0,0 +1,30 @@
+package nfqdatapath
+
+import (
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+)
+
+// unenforcedFlowLifetime is the time after the last packet after which a
+// flow that is not enforced is forgotten. It matches the lifetime of the
+// connection trackers.
+const unenforcedFlowLifetime = 60 * time.Second
+
+// acceptUnenforcedFlow records a new flow initiated in a direction that the
+// PU does not enforce, so that the packets of both of its legs are accepted
+// without a connection. The replies of the flows initiated in the enforced
+// direction are still processed.
+func (d *Datapath) acceptUnenforcedFlow(p *packet.Packet) {
+
+	d.unenforcedFlows.AddOrUpdate(p.L4FlowHash(), true)
+	d.unenforcedFlows.AddOrUpdate(p.L4ReverseFlowHash(), true)
+}
+
+// unenforcedFlow returns true if the packet belongs to a flow accepted by
+// acceptUnenforcedFlow. The lifetime of the flow is extended.
+func (d *Datapath) unenforcedFlow(p *packet.Packet) bool {
+
+	_, err := d.unenforcedFlows.GetReset(p.L4FlowHash(), 0)
+	return err == nil
+}
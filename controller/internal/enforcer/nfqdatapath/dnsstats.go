@@ -0,0 +1,42 @@
+package nfqdatapath
+
+import (
+	"context"
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+)
+
+// dnsStatsInterval is the interval at which the activity of the rules
+// learned from DNS is reported.
+const dnsStatsInterval = time.Minute
+
+// reportDNSStats reports the activity of the rules that the PU learned from
+// DNS since the previous report.
+func (d *Datapath) reportDNSStats(pu *pucontext.PUContext) {
+
+	if stats := pu.DNSStats(); stats != nil {
+		d.collector.CollectDNSEvent(stats)
+	}
+}
+
+// reportDNSStatsPeriodically reports the DNS activity of every PU until the
+// context is cancelled.
+func (d *Datapath) reportDNSStatsPeriodically(ctx context.Context) {
+
+	ticker := time.NewTicker(dnsStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, contextID := range d.puFromContextID.KeyList() {
+				if pu, err := d.puFromContextID.Get(contextID); err == nil {
+					d.reportDNSStats(pu.(*pucontext.PUContext))
+				}
+			}
+		}
+	}
+}
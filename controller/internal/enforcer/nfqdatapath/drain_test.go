@@ -0,0 +1,99 @@
+package nfqdatapath
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/utils/packetgen"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+)
+
+func TestDrainPU(t *testing.T) {
+
+	Convey("Given an enforcer with two PUs and a flow between them", t, func() {
+		puInfo1, puInfo2, enforcer, err1, err2, _, _ := setupProcessingUnitsInDatapathAndEnforce(nil, false, "container", false)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+
+		process := func(i int) error {
+			data, err := PacketFlow.GetNthPacket(i).ToBytes()
+			So(err, ShouldBeNil)
+			tcpPacket, err := packet.New(0, data, "0", true)
+			So(err, ShouldBeNil)
+
+			if err := enforcer.processApplicationTCPPackets(tcpPacket); err != nil {
+				return err
+			}
+
+			output := make([]byte, len(tcpPacket.GetBytes()))
+			copy(output, tcpPacket.GetBytes())
+			outPacket, err := packet.New(0, output, "0", true)
+			So(err, ShouldBeNil)
+
+			return enforcer.processNetworkTCPPackets(outPacket)
+		}
+
+		// The handshake establishes the flow.
+		for i := 0; i < 3; i++ {
+			So(process(i), ShouldBeNil)
+		}
+
+		Convey("When I drain the PUs during the flow", func() {
+			So(enforcer.DrainPU(puInfo1.ContextID), ShouldBeNil)
+			So(enforcer.DrainPU(puInfo2.ContextID), ShouldBeNil)
+
+			item, err := enforcer.puFromContextID.Get(puInfo2.ContextID)
+			So(err, ShouldBeNil)
+			context := item.(*pucontext.PUContext)
+
+			Convey("Then the established flow should complete", func() {
+				for i := 3; i < PacketFlow.GetNumPackets(); i++ {
+					So(process(i), ShouldBeNil)
+				}
+			})
+
+			Convey("Then new application connections should be rejected", func() {
+				err := process(0)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "draining")
+			})
+
+			Convey("Then new network connections should be rejected", func() {
+				data, err := PacketFlow.GetFirstSynPacket().ToBytes()
+				So(err, ShouldBeNil)
+				tcpPacket, err := packet.New(0, data, "0", true)
+				So(err, ShouldBeNil)
+
+				_, _, err = enforcer.processNetworkSynPacket(context, connection.NewTCPConnection(context), tcpPacket)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "draining")
+
+				udpPacket, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+				So(err, ShouldBeNil)
+
+				_, _, err = enforcer.processNetworkUDPSynPacket(context, connection.NewUDPConnection(context, nil), udpPacket)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "draining")
+			})
+
+			Convey("Then the PUs should still be draining after a policy update", func() {
+				So(enforcer.Enforce(puInfo2.ContextID, puInfo2), ShouldBeNil)
+
+				item, err := enforcer.puFromContextID.Get(puInfo2.ContextID)
+				So(err, ShouldBeNil)
+				So(item.(*pucontext.PUContext).Draining(), ShouldBeTrue)
+				So(item.(*pucontext.PUContext).State().Draining, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I drain an unknown PU, I should get an error", func() {
+			So(enforcer.DrainPU("unknown"), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,124 @@
+package nfqdatapath
+
+import (
+	"fmt"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/logging"
+	"go.aporeto.io/trireme-lib/utils/portspec"
+	"go.uber.org/zap"
+)
+
+// excludedFlowLifetime is the time after which a flow to an excluded port is
+// forgotten if none of its packets is seen.
+const excludedFlowLifetime = 60 * time.Second
+
+// excludedPorts holds the destination ports of the flows that bypass the
+// enforcement, whatever the policy of the PUs.
+type excludedPorts struct {
+	ports  []*portspec.PortSpec
+	report bool
+}
+
+// includes returns true if the port is excluded.
+func (e *excludedPorts) includes(port uint16) bool {
+
+	for _, spec := range e.ports {
+		if spec.IsIncluded(int(port)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetExcludedPorts sets the destination ports of the flows that bypass the
+// enforcement. The ports are single ports or ranges such as 8000:8100. The
+// bypassed flows are reported as accepted if report is set.
+func (d *Datapath) SetExcludedPorts(ports []string, report bool) error {
+
+	excluded := &excludedPorts{
+		ports:  make([]*portspec.PortSpec, 0, len(ports)),
+		report: report,
+	}
+
+	for _, port := range ports {
+		spec, err := portspec.NewPortSpecFromString(port, nil)
+		if err != nil {
+			return fmt.Errorf("invalid excluded port %s: %s", port, err)
+		}
+		excluded.ports = append(excluded.ports, spec)
+	}
+
+	d.excludedPorts.Store(excluded)
+
+	return nil
+}
+
+// bypassExcludedPort returns true if the packet belongs to a flow to an
+// excluded port. These packets are accepted before the lookup of their
+// context, so neither the ACLs nor the handshake are involved. The flows are
+// recorded, and only the replies of a recorded flow release it from the
+// datapath, so that a packet from an excluded port does not bypass the
+// enforcement by itself.
+func (d *Datapath) bypassExcludedPort(p *packet.Packet, app bool) bool {
+
+	excluded, ok := d.excludedPorts.Load().(*excludedPorts)
+	if !ok || len(excluded.ports) == 0 {
+		return false
+	}
+
+	// The UDP handshake packets of the enforcers are always processed.
+	if p.IPProto == packet.IPProtocolUDP && !app && p.GetUDPType() != 0 {
+		return false
+	}
+
+	syn := p.IPProto == packet.IPProtocolTCP && p.TCPFlags&packet.TCPSynAckMask == packet.TCPSynMask
+
+	switch {
+	case excluded.includes(p.DestinationPort):
+		// The syn may come from an enforcer that does not exclude the port.
+		if syn && !app {
+			stripTCPAuthentication(p)
+		}
+
+		d.excludedFlows.AddOrUpdate(p.L4FlowHash(), true)
+
+		if excluded.report && (syn || p.IPProto == packet.IPProtocolUDP && d.excludedFlowReports.Add(p.L4FlowHash(), nil) == nil) {
+			d.reportExternalIPFlow(p, policy.Accept, collector.ExcludedPort)
+		}
+
+	case excluded.includes(p.SourcePort) && !syn:
+		if _, err := d.excludedFlows.GetReset(p.L4ReverseFlowHash(), 0); err != nil {
+			return false
+		}
+
+		if err := d.updateConntrackMark(
+			p.DestinationAddress.String(),
+			p.SourceAddress.String(),
+			p.IPProto,
+			p.DestinationPort,
+			p.SourcePort,
+			d.filterQueue.GetConnMark(),
+		); err != nil {
+			logging.Named("datapath").Debug("Failed to release the flow of an excluded port",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+
+	default:
+		return false
+	}
+
+	if d.packetLogs {
+		logging.Named("datapath").Debug("Packet of an excluded port accepted",
+			zap.String("flow", p.L4FlowHash()),
+		)
+	}
+
+	return true
+}
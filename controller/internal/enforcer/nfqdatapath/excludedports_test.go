@@ -0,0 +1,137 @@
+package nfqdatapath
+
+import (
+	"strconv"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/utils/packetgen"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/policy"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExcludedPorts(t *testing.T) {
+
+	Convey("Given I have an enforcer with a PU", t, func() {
+		flows := &flowCapturingCollector{}
+		writer := &capturingSocketWriter{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		enforcer := newFailureTestEnforcer(flows, constants.RemoteContainer)
+		conntrack := NewMemoryConntrack()
+		enforcer.conntrackHdl = conntrack
+		So(enforcer.Enforce("SomeServerId", policy.NewPUInfo("SomeServerId", common.ContainerPU)), ShouldBeNil)
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+
+		syn, err := newFailureTestPacket(packet.PacketTypeApplication, PacketFlow.GetSynPackets())
+		So(err, ShouldBeNil)
+		length := len(syn.GetBytes())
+		tcpPort := strconv.Itoa(int(syn.DestinationPort))
+
+		udp, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 10250, []byte("data"))
+		So(err, ShouldBeNil)
+
+		Convey("When the ports are not excluded, the packets should be enforced", func() {
+			So(enforcer.SetExcludedPorts([]string{"10255"}, true), ShouldBeNil)
+
+			So(enforcer.processApplicationTCPPackets(syn), ShouldBeNil)
+			So(len(syn.GetBytes()), ShouldBeGreaterThan, length)
+			_, err := enforcer.appOrigConnectionTracker.Get(syn.L4FlowHash())
+			So(err, ShouldBeNil)
+
+			// The PU has no udp target networks.
+			So(enforcer.ProcessApplicationUDPPacket(udp), ShouldNotBeNil)
+		})
+
+		Convey("When the ports are excluded", func() {
+			So(enforcer.SetExcludedPorts([]string{tcpPort, "10000:10300"}, true), ShouldBeNil)
+
+			Convey("The tcp syn should be accepted without a handshake and reported", func() {
+				So(enforcer.processApplicationTCPPackets(syn), ShouldBeNil)
+				So(len(syn.GetBytes()), ShouldEqual, length)
+				_, err := enforcer.appOrigConnectionTracker.Get(syn.L4FlowHash())
+				So(err, ShouldNotBeNil)
+
+				records := flows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].Action, ShouldEqual, policy.Accept)
+				So(records[0].DropReason, ShouldEqual, collector.ExcludedPort)
+			})
+
+			Convey("The token of a network syn should be removed", func() {
+				So(syn.TCPDataAttach(enforcer.createTCPAuthenticationOption([]byte{}), []byte("token")), ShouldBeNil)
+				syn.UpdateTCPChecksum()
+				netSyn, err := packet.New(0, syn.GetBytes(), "0", true)
+				So(err, ShouldBeNil)
+
+				So(enforcer.processNetworkTCPPackets(netSyn), ShouldBeNil)
+				So(len(netSyn.GetBytes()), ShouldEqual, length)
+				_, err = enforcer.netOrigConnectionTracker.Get(netSyn.L4FlowHash())
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("The udp packets should be accepted without a handshake and reported once", func() {
+				So(enforcer.ProcessApplicationUDPPacket(udp), ShouldBeNil)
+				So(enforcer.ProcessApplicationUDPPacket(udp), ShouldBeNil)
+				So(writer.count(), ShouldEqual, 0)
+				_, err := enforcer.udpAppOrigConnectionTracker.Get(udp.L4FlowHash())
+				So(err, ShouldNotBeNil)
+
+				records := flows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].DropReason, ShouldEqual, collector.ExcludedPort)
+				So(records[0].L4Protocol, ShouldEqual, packet.IPProtocolUDP)
+			})
+
+			Convey("The udp packets from the network should skip the ACLs and the replies release the flow", func() {
+				external, err := newUDPTestPacket("192.168.1.1", "10.1.1.2", 3000, 10250, []byte("data"))
+				So(err, ShouldBeNil)
+				So(enforcer.ProcessNetworkUDPPacket(external), ShouldBeNil)
+
+				reply, err := newUDPTestPacket("10.1.1.2", "192.168.1.1", 10250, 3000, []byte("reply"))
+				So(err, ShouldBeNil)
+				So(enforcer.ProcessApplicationUDPPacket(reply), ShouldBeNil)
+
+				mark, ok := conntrack.Mark("192.168.1.1", "10.1.1.2", packet.IPProtocolUDP, 3000, 10250)
+				So(ok, ShouldBeTrue)
+				So(mark, ShouldEqual, enforcer.filterQueue.GetConnMark())
+			})
+
+			Convey("The packets from an excluded port that do not reply to a flow should be enforced", func() {
+				unsolicited, err := newUDPTestPacket("10.1.1.2", "192.168.1.1", 10250, 3000, []byte("data"))
+				So(err, ShouldBeNil)
+				So(enforcer.ProcessApplicationUDPPacket(unsolicited), ShouldNotBeNil)
+
+				_, ok := conntrack.Mark("192.168.1.1", "10.1.1.2", packet.IPProtocolUDP, 3000, 10250)
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("The flows should not be reported if reporting is disabled", func() {
+				So(enforcer.SetExcludedPorts([]string{tcpPort}, false), ShouldBeNil)
+
+				So(enforcer.processApplicationTCPPackets(syn), ShouldBeNil)
+				So(len(flows.records()), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When an excluded port is invalid, it should fail", func() {
+			So(enforcer.SetExcludedPorts([]string{"http"}, false), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,241 @@
+package nfqdatapath
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	enforcerconstants "go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/logging"
+	"go.uber.org/zap"
+)
+
+// failOpenFlowTimeout is the idle time after which a flow that was accepted
+// after a failure is processed again.
+const failOpenFlowTimeout = 60 * time.Second
+
+// FailureClass is a class of errors for which the datapath cannot take a
+// policy decision for a packet.
+type FailureClass int
+
+const (
+	// FailureNoContext is the class of the packets without a PU context.
+	FailureNoContext FailureClass = iota
+	// FailureInvalidToken is the class of the packets with a missing or
+	// invalid token.
+	FailureInvalidToken
+	// FailureServiceError is the class of the packets rejected by the
+	// service processor.
+	FailureServiceError
+)
+
+// FailureAction is the decision of the datapath for the packets of a failure
+// class.
+type FailureAction int
+
+const (
+	// FailClosed drops the packets. This is the default.
+	FailClosed FailureAction = iota
+	// FailOpen accepts the packets. The flows are reported as accepted with
+	// the reason of the failure. UDP handshake packets are always dropped,
+	// since they are not data of the application.
+	FailOpen
+)
+
+// failure is the error returned when the datapath cannot take a policy
+// decision for a packet.
+type failure struct {
+	class    FailureClass
+	reason   string
+	sourceID string
+	destID   string
+	context  *pucontext.PUContext
+	// authData is set if the packet still carries the authentication data
+	// of the enforcers.
+	authData bool
+	err      error
+}
+
+func (f *failure) Error() string {
+	return f.err.Error()
+}
+
+// newNoContextFailure returns the failure of a packet without a context.
+func newNoContextFailure(reason string, err error) *failure {
+
+	return &failure{
+		class:  FailureNoContext,
+		reason: reason,
+		err:    err,
+	}
+}
+
+// newServiceFailure returns the failure of a packet rejected by the service
+// processor. remoteID is the identity of the other end of the connection.
+func newServiceFailure(context *pucontext.PUContext, app bool, remoteID string, err error) *failure {
+
+	if remoteID == "" {
+		remoteID = collector.DefaultEndPoint
+	}
+
+	f := &failure{
+		class:    FailureServiceError,
+		reason:   collector.ServiceError,
+		sourceID: remoteID,
+		destID:   context.ManagementID(),
+		context:  context,
+		err:      err,
+	}
+
+	if app {
+		f.sourceID, f.destID = f.destID, f.sourceID
+	}
+
+	return f
+}
+
+// tcpTokenFailure returns the failure of a TCP packet with a missing or
+// invalid token. The flow is reported as rejected, unless the invalid tokens
+// fail open. In this case the flow is reported when the packet is accepted.
+func (d *Datapath) tcpTokenFailure(p *packet.Packet, conn *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, reason string, err error) error {
+
+	if !d.failsOpen(FailureInvalidToken) {
+		d.reportRejectedFlow(p, conn, sourceID, destID, context, reason, nil, nil)
+	}
+
+	return &failure{
+		class:    FailureInvalidToken,
+		reason:   reason,
+		sourceID: sourceID,
+		destID:   destID,
+		context:  context,
+		authData: true,
+		err:      err,
+	}
+}
+
+// wrapError adds a prefix to the message of an error. The class of a failure
+// is preserved.
+func wrapError(prefix string, err error) error {
+
+	if f, ok := err.(*failure); ok {
+		wrapped := *f
+		wrapped.err = fmt.Errorf("%s: %s", prefix, f.err)
+		return &wrapped
+	}
+
+	return fmt.Errorf("%s: %s", prefix, err)
+}
+
+// failsOpen returns true if the packets of the class are accepted.
+func (d *Datapath) failsOpen(class FailureClass) bool {
+
+	return atomic.LoadUint32(&d.failOpenClasses)&(uint32(1)<<uint(class)) != 0
+}
+
+// failOpen returns the verdict of a packet that failed the processing. The
+// error is returned if the packet must be dropped, and nil if the class of
+// the failure fails open. Flows that fail open are reported and remembered
+// until they are idle, so that the rest of their packets are accepted.
+func (d *Datapath) failOpen(p *packet.Packet, app bool, err error) error {
+
+	f, ok := err.(*failure)
+	if !ok || !d.failsOpen(f.class) {
+		return err
+	}
+
+	if p.IPProto == packet.IPProtocolUDP && p.GetUDPType() != 0 {
+		return err
+	}
+
+	if p.IPProto == packet.IPProtocolTCP && !app && f.authData {
+		stripTCPAuthentication(p)
+	}
+
+	d.failOpenFlows.AddOrUpdate(p.L4FlowHash(), f.class)
+	d.failOpenFlows.AddOrUpdate(p.L4ReverseFlowHash(), f.class)
+
+	logging.Named("datapath").Warn("Packet accepted after a failure",
+		zap.String("flow", p.L4FlowHash()),
+		zap.String("reason", f.reason),
+		zap.Error(f.err),
+	)
+
+	d.reportFailOpenFlow(p, f)
+
+	return nil
+}
+
+// acceptFailedOpenFlow returns true if the packet belongs to a flow that was
+// accepted after a failure, and the class of the failure still fails open.
+// TCP syn packets start a new flow and are always processed. The rest of the
+// packets of the flow don't carry the authentication data of the enforcers,
+// since the handshake was not completed.
+func (d *Datapath) acceptFailedOpenFlow(p *packet.Packet) bool {
+
+	if atomic.LoadUint32(&d.failOpenClasses) == 0 {
+		return false
+	}
+
+	if p.IPProto == packet.IPProtocolTCP && p.TCPFlags&packet.TCPSynAckMask == packet.TCPSynMask {
+		return false
+	}
+
+	class, err := d.failOpenFlows.GetReset(p.L4FlowHash(), 0)
+	if err != nil || !d.failsOpen(class.(FailureClass)) {
+		return false
+	}
+
+	return true
+}
+
+// reportFailOpenFlow reports a flow accepted after a failure with the reason
+// of the failure.
+func (d *Datapath) reportFailOpenFlow(p *packet.Packet, f *failure) {
+
+	if f.context == nil {
+		d.reportExternalIPFlow(p, policy.Accept, f.reason)
+		return
+	}
+
+	accept := &policy.FlowPolicy{
+		Action:   policy.Accept,
+		PolicyID: "default",
+	}
+
+	d.reportFlow(p, f.sourceID, f.destID, f.context, f.reason, accept, accept)
+}
+
+// carriesAuthData returns true if a network packet of the connection carries
+// the authentication data of the enforcers. These are the syn and synack
+// packets, and the ack that completes the handshake.
+func carriesAuthData(p *packet.Packet, conn *connection.TCPConnection) bool {
+
+	if p.TCPFlags&packet.TCPSynMask != 0 {
+		return true
+	}
+
+	return conn.GetState() == connection.TCPSynAckSend || conn.GetState() == connection.TCPSynReceived
+}
+
+// stripTCPAuthentication removes the authentication option and the token of
+// the enforcers from a network packet, if the packet has them.
+func stripTCPAuthentication(p *packet.Packet) {
+
+	if err := p.CheckTCPAuthenticationOption(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
+		return
+	}
+
+	if err := p.TCPDataDetach(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
+		return
+	}
+
+	p.DropDetachedBytes()
+
+	p.UpdateTCPChecksum()
+}
@@ -0,0 +1,271 @@
+package nfqdatapath
+
+import (
+	"testing"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/utils/packetgen"
+	"go.aporeto.io/trireme-lib/controller/pkg/aclprovider"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/fqconfig"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
+	"go.aporeto.io/trireme-lib/policy"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// rejectingProcessor is a service processor that rejects all the packets.
+type rejectingProcessor struct{}
+
+func (r *rejectingProcessor) Initialize(fq *fqconfig.FilterQueue, p provider.IptablesProvider) {}
+
+func (r *rejectingProcessor) Stop() error { return nil }
+
+func (r *rejectingProcessor) PreProcessTCPAppPacket(p *packet.Packet, context *pucontext.PUContext, conn *connection.TCPConnection) bool {
+	return false
+}
+
+func (r *rejectingProcessor) PostProcessTCPAppPacket(p *packet.Packet, action interface{}, context *pucontext.PUContext, conn *connection.TCPConnection) bool {
+	return false
+}
+
+func (r *rejectingProcessor) PreProcessTCPNetPacket(p *packet.Packet, context *pucontext.PUContext, conn *connection.TCPConnection) bool {
+	return false
+}
+
+func (r *rejectingProcessor) PostProcessTCPNetPacket(p *packet.Packet, action interface{}, claims *tokens.ConnectionClaims, context *pucontext.PUContext, conn *connection.TCPConnection) bool {
+	return false
+}
+
+func (r *rejectingProcessor) PreProcessUDPAppPacket(p *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection, packetType uint8) bool {
+	return false
+}
+
+func (r *rejectingProcessor) PostProcessUDPAppPacket(p *packet.Packet, action interface{}, context *pucontext.PUContext, conn *connection.UDPConnection) bool {
+	return false
+}
+
+func (r *rejectingProcessor) PreProcessUDPNetPacket(p *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) bool {
+	return false
+}
+
+func (r *rejectingProcessor) PostProcessUDPNetPacket(p *packet.Packet, action interface{}, claims *tokens.ConnectionClaims, context *pucontext.PUContext, conn *connection.UDPConnection) bool {
+	return false
+}
+
+func newFailureTestEnforcer(flows collector.EventCollector, mode constants.ModeType) *Datapath {
+
+	secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+
+	enforcer := NewWithDefaults("SomeServerId", flows, nil, secret, mode, "/proc", []string{"0.0.0.0/0"})
+	enforcer.mode = mode
+
+	return enforcer
+}
+
+func newFailureTestPacket(packetType uint64, flow packetgen.PacketFlowManipulator) (*packet.Packet, error) {
+
+	buffer, err := flow.GetNthPacket(0).ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return packet.New(packetType, buffer, "0", true)
+}
+
+func TestFailureModeNoContext(t *testing.T) {
+
+	Convey("Given I have an enforcer for Linux processes without PUs", t, func() {
+		flows := &flowCapturingCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := newFailureTestEnforcer(flows, constants.LocalServer)
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+
+		syn, err := newFailureTestPacket(packet.PacketTypeApplication, PacketFlow.GetSynPackets())
+		So(err, ShouldBeNil)
+
+		udp, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+		So(err, ShouldBeNil)
+
+		Convey("When no context fails closed, the packets should be dropped", func() {
+			So(enforcer.processApplicationTCPPackets(syn), ShouldNotBeNil)
+			So(enforcer.ProcessApplicationUDPPacket(udp), ShouldNotBeNil)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 1)
+			So(records[0].Action, ShouldEqual, policy.Reject)
+			So(records[0].DropReason, ShouldEqual, collector.UnenforcedSource)
+		})
+
+		Convey("When no context fails open, the packets should be accepted and reported", func() {
+			enforcer.SetFailureMode(FailureNoContext, FailOpen)
+
+			So(enforcer.processApplicationTCPPackets(syn), ShouldBeNil)
+			So(enforcer.ProcessApplicationUDPPacket(udp), ShouldBeNil)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 2)
+			So(records[0].Action, ShouldEqual, policy.Accept)
+			So(records[0].DropReason, ShouldEqual, collector.InvalidContext)
+			So(records[0].L4Protocol, ShouldEqual, packet.IPProtocolTCP)
+			So(records[1].Action, ShouldEqual, policy.Accept)
+			So(records[1].DropReason, ShouldEqual, collector.UnenforcedSource)
+			So(records[1].L4Protocol, ShouldEqual, packet.IPProtocolUDP)
+
+			Convey("The rest of the packets of the flows should be accepted without a report", func() {
+				reply, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 3000, 2000, []byte("reply"))
+				So(err, ShouldBeNil)
+
+				So(enforcer.ProcessNetworkUDPPacket(reply), ShouldBeNil)
+				So(enforcer.ProcessApplicationUDPPacket(udp), ShouldBeNil)
+				So(len(flows.records()), ShouldEqual, 2)
+			})
+
+			Convey("The flows should be processed again when the class fails closed", func() {
+				enforcer.SetFailureMode(FailureNoContext, FailClosed)
+
+				So(enforcer.ProcessApplicationUDPPacket(udp), ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestFailureModeInvalidToken(t *testing.T) {
+
+	Convey("Given I have an enforcer with a PU", t, func() {
+		flows := &flowCapturingCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := newFailureTestEnforcer(flows, constants.RemoteContainer)
+		So(enforcer.Enforce("SomeServerId", policy.NewPUInfo("SomeServerId", common.ContainerPU)), ShouldBeNil)
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+
+		// Attach an invalid token to the syn packet.
+		p, err := newFailureTestPacket(packet.PacketTypeNetwork, PacketFlow.GetSynPackets())
+		So(err, ShouldBeNil)
+		length := len(p.GetBytes())
+		So(p.TCPDataAttach(enforcer.createTCPAuthenticationOption([]byte{}), []byte("invalid token")), ShouldBeNil)
+		p.UpdateTCPChecksum()
+		syn, err := packet.New(packet.PacketTypeNetwork, p.GetBytes(), "0", true)
+		So(err, ShouldBeNil)
+
+		synAck, err := newFailureTestPacket(packet.PacketTypeApplication, PacketFlow.GetSynAckPackets())
+		So(err, ShouldBeNil)
+
+		Convey("When invalid tokens fail closed, the syn should be dropped and reported", func() {
+			So(enforcer.processNetworkTCPPackets(syn), ShouldNotBeNil)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 1)
+			So(records[0].Action, ShouldEqual, policy.Reject)
+			So(records[0].DropReason, ShouldEqual, collector.InvalidToken)
+		})
+
+		Convey("When invalid tokens fail open, the syn should be accepted without the token", func() {
+			enforcer.SetFailureMode(FailureInvalidToken, FailOpen)
+
+			So(enforcer.processNetworkTCPPackets(syn), ShouldBeNil)
+			So(len(syn.GetBytes()), ShouldEqual, length)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 1)
+			So(records[0].Action, ShouldEqual, policy.Accept)
+			So(records[0].DropReason, ShouldEqual, collector.InvalidToken)
+			So(records[0].Destination.ID, ShouldEqual, "SomeServerId")
+
+			Convey("The synack of the PU should be accepted", func() {
+				So(enforcer.processApplicationTCPPackets(synAck), ShouldBeNil)
+				So(len(flows.records()), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestFailureModeServiceError(t *testing.T) {
+
+	Convey("Given I have an enforcer with a PU and a service that rejects the packets", t, func() {
+		flows := &flowCapturingCollector{}
+
+		// mock the call
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := newFailureTestEnforcer(flows, constants.RemoteContainer)
+		enforcer.service = &rejectingProcessor{}
+		So(enforcer.Enforce("SomeServerId", policy.NewPUInfo("SomeServerId", common.ContainerPU)), ShouldBeNil)
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+
+		syn, err := newFailureTestPacket(packet.PacketTypeApplication, PacketFlow.GetSynPackets())
+		So(err, ShouldBeNil)
+
+		udp, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 2000, 3000, []byte("data"))
+		So(err, ShouldBeNil)
+
+		Convey("When service errors fail closed, the packets should be dropped", func() {
+			So(enforcer.processApplicationTCPPackets(syn), ShouldNotBeNil)
+			So(enforcer.ProcessApplicationUDPPacket(udp), ShouldNotBeNil)
+			So(len(flows.records()), ShouldEqual, 0)
+		})
+
+		Convey("When service errors fail open, the packets should be accepted and reported", func() {
+			enforcer.SetFailureMode(FailureServiceError, FailOpen)
+
+			So(enforcer.processApplicationTCPPackets(syn), ShouldBeNil)
+			So(enforcer.ProcessApplicationUDPPacket(udp), ShouldBeNil)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 2)
+			for _, record := range records {
+				So(record.Action, ShouldEqual, policy.Accept)
+				So(record.DropReason, ShouldEqual, collector.ServiceError)
+				So(record.Source.ID, ShouldEqual, "SomeServerId")
+				So(record.Direction, ShouldEqual, collector.FlowDirectionOutgoing)
+			}
+		})
+
+		Convey("When another class fails open, the packets should be dropped", func() {
+			enforcer.SetFailureMode(FailureNoContext, FailOpen)
+			enforcer.SetFailureMode(FailureInvalidToken, FailOpen)
+
+			So(enforcer.processApplicationTCPPackets(syn), ShouldNotBeNil)
+			So(len(flows.records()), ShouldEqual, 0)
+		})
+	})
+}
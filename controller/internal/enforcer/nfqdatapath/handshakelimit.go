@@ -0,0 +1,173 @@
+package nfqdatapath
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultUDPHandshakeLimit is the maximum number of half open UDP
+	// connections of the enforcer
+	defaultUDPHandshakeLimit = 16384
+	// defaultUDPHandshakeLimitPerPU is the maximum number of half open UDP
+	// connections of a single PU
+	defaultUDPHandshakeLimitPerPU = 4096
+	// udpHandshakeTimeout is the time after which a half open UDP connection
+	// no longer counts against the limits. It matches the lifetime of the
+	// connection trackers.
+	udpHandshakeTimeout = 60 * time.Second
+)
+
+// pendingHandshake is a connection that received a syn and has not
+// completed the handshake yet.
+type pendingHandshake struct {
+	hash      string
+	contextID string
+	expires   time.Time
+	element   *list.Element
+}
+
+// handshakeLimiter limits the number of half open connections globally and
+// per context. A limit of 0 disables the corresponding check. The pending
+// handshakes are also kept in the order in which they expire, so that the
+// expired ones are purged without scanning the others.
+type handshakeLimiter struct {
+	total      int
+	perContext int
+	timeout    time.Duration
+
+	pending  map[string]*pendingHandshake
+	expiries *list.List
+	contexts map[string]int
+
+	sync.Mutex
+}
+
+// newHandshakeLimiter returns a limiter with the given limits.
+func newHandshakeLimiter(total, perContext int, timeout time.Duration) *handshakeLimiter {
+
+	return &handshakeLimiter{
+		total:      total,
+		perContext: perContext,
+		timeout:    timeout,
+		pending:    map[string]*pendingHandshake{},
+		expiries:   list.New(),
+		contexts:   map[string]int{},
+	}
+}
+
+// setLimits changes the limits. Pending handshakes over the new limits are
+// not released.
+func (h *handshakeLimiter) setLimits(total, perContext int) {
+
+	h.Lock()
+	defer h.Unlock()
+
+	h.total = total
+	h.perContext = perContext
+}
+
+// acquire reserves a slot for the handshake of the flow. It returns false if
+// the limits are reached. Retransmissions of a pending handshake always
+// succeed and extend its lifetime.
+func (h *handshakeLimiter) acquire(hash string, contextID string) bool {
+
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+
+	if p, ok := h.pending[hash]; ok {
+		p.expires = now.Add(h.timeout)
+		h.expiries.MoveToBack(p.element)
+		return true
+	}
+
+	// Expired handshakes are only purged when a limit is reached.
+	if h.exceeded(contextID) {
+		h.purge(now)
+		if h.exceeded(contextID) {
+			return false
+		}
+	}
+
+	p := &pendingHandshake{
+		hash:      hash,
+		contextID: contextID,
+		expires:   now.Add(h.timeout),
+	}
+	p.element = h.expiries.PushBack(p)
+	h.pending[hash] = p
+	h.contexts[contextID]++
+
+	return true
+}
+
+// isPending returns true if the flow has a pending handshake.
+func (h *handshakeLimiter) isPending(hash string) bool {
+
+	h.Lock()
+	defer h.Unlock()
+
+	p, ok := h.pending[hash]
+
+	return ok && time.Now().Before(p.expires)
+}
+
+// release frees the slot of the flow once the handshake is complete or the
+// connection is closed.
+func (h *handshakeLimiter) release(hash string) {
+
+	h.Lock()
+	defer h.Unlock()
+
+	if p, ok := h.pending[hash]; ok {
+		h.remove(hash, p)
+	}
+}
+
+// count returns the number of pending handshakes of the enforcer and of the
+// context.
+func (h *handshakeLimiter) count(contextID string) (int, int) {
+
+	h.Lock()
+	defer h.Unlock()
+
+	h.purge(time.Now())
+
+	return len(h.pending), h.contexts[contextID]
+}
+
+func (h *handshakeLimiter) exceeded(contextID string) bool {
+
+	if h.total > 0 && len(h.pending) >= h.total {
+		return true
+	}
+
+	return h.perContext > 0 && h.contexts[contextID] >= h.perContext
+}
+
+// purge removes the expired handshakes. They are at the front of the
+// expiries, since every handshake expires after the same timeout.
+func (h *handshakeLimiter) purge(now time.Time) {
+
+	for e := h.expiries.Front(); e != nil; e = h.expiries.Front() {
+		p := e.Value.(*pendingHandshake)
+		if !now.After(p.expires) {
+			return
+		}
+		h.remove(p.hash, p)
+	}
+}
+
+func (h *handshakeLimiter) remove(hash string, p *pendingHandshake) {
+
+	delete(h.pending, hash)
+	h.expiries.Remove(p.element)
+
+	h.contexts[p.contextID]--
+	if h.contexts[p.contextID] <= 0 {
+		delete(h.contexts, p.contextID)
+	}
+}
@@ -0,0 +1,70 @@
+package nfqdatapath
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandshakeLimiter(t *testing.T) {
+
+	Convey("Given a handshake limiter", t, func() {
+		h := newHandshakeLimiter(3, 2, time.Minute)
+
+		Convey("When a context reaches its limit, it should not acquire more slots", func() {
+			So(h.acquire("a1", "a"), ShouldBeTrue)
+			So(h.acquire("a2", "a"), ShouldBeTrue)
+			So(h.acquire("a3", "a"), ShouldBeFalse)
+			So(h.acquire("a1", "a"), ShouldBeTrue)
+
+			Convey("Other contexts should acquire slots up to the global limit", func() {
+				So(h.acquire("b1", "b"), ShouldBeTrue)
+				So(h.acquire("b2", "b"), ShouldBeFalse)
+			})
+
+			Convey("A released slot should be reused", func() {
+				h.release("a1")
+				So(h.acquire("a3", "a"), ShouldBeTrue)
+				total, perContext := h.count("a")
+				So(total, ShouldEqual, 2)
+				So(perContext, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the limits are disabled, all handshakes should be accepted", func() {
+			h.setLimits(0, 0)
+			for _, hash := range []string{"1", "2", "3", "4", "5"} {
+				So(h.acquire(hash, "a"), ShouldBeTrue)
+			}
+		})
+
+		Convey("When handshakes expire, their slots should be freed", func() {
+			h.timeout = time.Millisecond
+			So(h.acquire("a1", "a"), ShouldBeTrue)
+			So(h.acquire("a2", "a"), ShouldBeTrue)
+			time.Sleep(5 * time.Millisecond)
+			So(h.acquire("a3", "a"), ShouldBeTrue)
+
+			total, _ := h.count("a")
+			So(total, ShouldEqual, 1)
+		})
+
+		Convey("When a pending handshake is retransmitted, it should expire after the others", func() {
+			h.setLimits(0, 0)
+			h.timeout = 100 * time.Millisecond
+			So(h.acquire("a1", "a"), ShouldBeTrue)
+			So(h.acquire("a2", "a"), ShouldBeTrue)
+			time.Sleep(60 * time.Millisecond)
+			So(h.acquire("a1", "a"), ShouldBeTrue)
+			time.Sleep(60 * time.Millisecond)
+
+			total, perContext := h.count("a")
+			So(total, ShouldEqual, 1)
+			So(perContext, ShouldEqual, 1)
+			So(h.isPending("a1"), ShouldBeTrue)
+			So(h.isPending("a2"), ShouldBeFalse)
+			So(h.expiries.Len(), ShouldEqual, 1)
+		})
+	})
+}
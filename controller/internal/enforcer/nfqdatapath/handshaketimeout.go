@@ -0,0 +1,164 @@
+package nfqdatapath
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/cache"
+	"go.aporeto.io/trireme-lib/utils/logging"
+	"go.uber.org/zap"
+)
+
+// udpHandshakeCheckInterval is the interval at which the UDP handshakes are
+// checked for expiration.
+const udpHandshakeCheckInterval = time.Second
+
+// timeNow returns the current time. It is replaced by the tests.
+var timeNow = time.Now
+
+// SetUDPHandshakeTimeout sets the time after which a UDP handshake that did
+// not complete is reported with the handshake timeout reason and torn down.
+// It applies to the handshakes started after the call. A timeout of 0
+// disables the expiration, and the handshakes are only reaped with their
+// connection trackers.
+func (d *Datapath) SetUDPHandshakeTimeout(timeout time.Duration) {
+
+	atomic.StoreInt64(&d.udpHandshakeTimeout, int64(timeout))
+}
+
+// UDPHandshakeTimeout returns the timeout of the UDP handshakes.
+func (d *Datapath) UDPHandshakeTimeout() time.Duration {
+
+	return time.Duration(atomic.LoadInt64(&d.udpHandshakeTimeout))
+}
+
+// expireUDPHandshakesPeriodically expires the UDP handshakes until the
+// context is cancelled.
+func (d *Datapath) expireUDPHandshakesPeriodically(ctx context.Context) {
+
+	ticker := time.NewTicker(udpHandshakeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.expireUDPHandshakes()
+		}
+	}
+}
+
+// expireUDPHandshakes reports and tears down the UDP connections whose
+// handshake did not complete within the timeout. The connections started by
+// the PUs are tracked by their application flow and the connections they
+// received by their network flow.
+func (d *Datapath) expireUDPHandshakes() {
+
+	now := timeNow()
+
+	for _, tracker := range []struct {
+		flows    cache.DataStore
+		receiver bool
+	}{
+		{d.udpAppOrigConnectionTracker, false},
+		{d.udpNetOrigConnectionTracker, true},
+	} {
+		for _, key := range tracker.flows.KeyList() {
+			hash, ok := key.(string)
+			if !ok {
+				continue
+			}
+
+			item, err := tracker.flows.Get(hash)
+			if err != nil {
+				continue
+			}
+
+			if conn, ok := item.(*connection.UDPConnection); ok {
+				d.expireUDPHandshake(conn, hash, tracker.receiver, now)
+			}
+		}
+	}
+}
+
+// expireUDPHandshake reports and tears down a connection if its handshake
+// expired. The queued application packets are reported as dropped.
+func (d *Datapath) expireUDPHandshake(conn *connection.UDPConnection, hash string, receiver bool, now time.Time) {
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	if conn.Context == nil || !conn.HandshakeExpired(now) {
+		return
+	}
+
+	flow, err := parseFlowHash(hash)
+	if err != nil {
+		return
+	}
+
+	conn.DiscardPackets()
+	packets, size := conn.DroppedPackets()
+
+	record := handshakeTimeoutRecord(conn, flow, receiver)
+	record.DroppedPackets = packets
+	record.DroppedBytes = size
+
+	if receiver {
+		d.releaseUDPConnection(conn, hash, flow.reverseHash())
+	} else {
+		d.releaseUDPConnection(conn, flow.reverseHash(), hash)
+	}
+
+	logging.Named("datapath").Debug("UDP handshake timed out",
+		zap.String("contextID", conn.Context.ID()),
+		zap.String("flow", hash),
+		zap.Int("packets", packets),
+	)
+
+	d.collector.CollectFlowEvent(record)
+}
+
+// handshakeTimeoutRecord returns the flow record of a connection whose
+// handshake timed out. The flow is the flow of the packets of the initiator.
+func handshakeTimeoutRecord(conn *connection.UDPConnection, flow *flowTuple, receiver bool) *collector.FlowRecord {
+
+	sourceID := conn.Context.ManagementID()
+	destID := collector.DefaultEndPoint
+	direction := collector.FlowDirectionOutgoing
+	if receiver {
+		sourceID, destID = collector.DefaultEndPoint, conn.Context.ManagementID()
+		if conn.Auth.RemoteContextID != "" {
+			sourceID = conn.Auth.RemoteContextID
+		}
+		direction = collector.FlowDirectionIncoming
+	}
+
+	return &collector.FlowRecord{
+		ContextID: conn.Context.ID(),
+		Source: &collector.EndPoint{
+			ID:   sourceID,
+			IP:   flow.sourceIP(),
+			Port: flow.sourcePort,
+			Type: collector.EnpointTypePU,
+		},
+		Destination: &collector.EndPoint{
+			ID:   destID,
+			IP:   flow.destinationIP(),
+			Port: flow.destinationPort,
+			Type: collector.EnpointTypePU,
+		},
+		Tags:       conn.Context.Annotations(),
+		Action:     policy.Reject,
+		DropReason: collector.HandshakeTimeout,
+		PolicyID:   "default",
+		L4Protocol: flow.protocol,
+		Count:      1,
+		Direction:  direction,
+	}
+}
@@ -0,0 +1,172 @@
+package nfqdatapath
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+)
+
+func TestUDPHandshakeTimeout(t *testing.T) {
+
+	Convey("Given I have a client and a server datapath with a handshake timeout", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		transport := newUDPTestTransport()
+
+		now := time.Now()
+		prevTimeNow := timeNow
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			timeNow = prevTimeNow
+			GetUDPRawSocket = prevRawSocket
+		}()
+		timeNow = func() time.Time {
+			return now
+		}
+
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return transport.client, nil
+		}
+		clientFlows := &flowCapturingCollector{}
+		client := NewWithDefaults("SomeServerId", clientFlows, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		client.conntrackHdl = &capturingConntrack{}
+		client.SetUDPHandshakeTimeout(5 * time.Second)
+
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return transport.server, nil
+		}
+		serverFlows := &flowCapturingCollector{}
+		server := NewWithDefaults("SomeServerId", serverFlows, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		server.conntrackHdl = &capturingConntrack{}
+		server.SetUDPHandshakeTimeout(5 * time.Second)
+
+		So(client.Enforce("clientpu", udpTestTransportPUInfo("clientpu", "client")), ShouldBeNil)
+		So(server.Enforce("serverpu", udpTestTransportPUInfo("serverpu", "server")), ShouldBeNil)
+
+		data, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, []byte("query"))
+		So(err, ShouldBeNil)
+		So(client.ProcessApplicationUDPPacket(data), ShouldNotBeNil)
+
+		syn, err := transport.deliverToServer(server)
+		So(err, ShouldBeNil)
+		So(syn.udpType, ShouldEqual, packet.UDPSynMask)
+
+		item, err := client.udpAppOrigConnectionTracker.Get(data.L4FlowHash())
+		So(err, ShouldBeNil)
+		clientConn := item.(*connection.UDPConnection)
+		So(clientConn.GetState(), ShouldEqual, connection.UDPClientSendSyn)
+
+		item, err = server.udpNetOrigConnectionTracker.Get(data.L4FlowHash())
+		So(err, ShouldBeNil)
+		serverConn := item.(*connection.UDPConnection)
+		So(serverConn.GetState(), ShouldEqual, connection.UDPReceiverSendSynAck)
+
+		Convey("When the clock is advanced past the timeout", func() {
+			now = now.Add(6 * time.Second)
+			client.expireUDPHandshakes()
+			server.expireUDPHandshakes()
+
+			Convey("Then the client should report the timeout with its queued packet", func() {
+				records := clientFlows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].DropReason, ShouldEqual, collector.HandshakeTimeout)
+				So(records[0].Action.Rejected(), ShouldBeTrue)
+				So(records[0].Direction, ShouldEqual, collector.FlowDirectionOutgoing)
+				So(records[0].Source.IP, ShouldEqual, "10.1.1.1")
+				So(records[0].Destination.IP, ShouldEqual, "10.1.1.2")
+				So(records[0].Destination.Port, ShouldEqual, 53)
+				So(records[0].DroppedPackets, ShouldEqual, 1)
+			})
+
+			Convey("Then the server should report the timeout", func() {
+				records := serverFlows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].DropReason, ShouldEqual, collector.HandshakeTimeout)
+				So(records[0].Direction, ShouldEqual, collector.FlowDirectionIncoming)
+				So(records[0].Source.ID, ShouldEqual, "clientpu")
+				So(records[0].Source.IP, ShouldEqual, "10.1.1.1")
+				So(records[0].Destination.Port, ShouldEqual, 53)
+			})
+
+			Convey("Then the connections should be removed from all the trackers", func() {
+				So(clientConn.GetState(), ShouldEqual, connection.UDPClosed)
+				_, err := client.udpAppOrigConnectionTracker.Get(data.L4FlowHash())
+				So(err, ShouldNotBeNil)
+				_, err = client.udpNetReplyConnectionTracker.Get(data.L4ReverseFlowHash())
+				So(err, ShouldNotBeNil)
+
+				So(serverConn.GetState(), ShouldEqual, connection.UDPClosed)
+				_, err = server.udpNetOrigConnectionTracker.Get(data.L4FlowHash())
+				So(err, ShouldNotBeNil)
+				_, err = server.udpAppReplyConnectionTracker.Get(data.L4ReverseFlowHash())
+				So(err, ShouldNotBeNil)
+
+				total, _ := server.udpHandshakes.count("serverpu")
+				So(total, ShouldEqual, 0)
+			})
+
+			Convey("Then the connections should not be reported again", func() {
+				now = now.Add(6 * time.Second)
+				client.expireUDPHandshakes()
+				server.expireUDPHandshakes()
+				So(len(clientFlows.records()), ShouldEqual, 1)
+				So(len(serverFlows.records()), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the clock is not advanced past the timeout", func() {
+			now = now.Add(4 * time.Second)
+			client.expireUDPHandshakes()
+			server.expireUDPHandshakes()
+
+			Convey("Then the handshakes should be kept", func() {
+				So(clientFlows.records(), ShouldBeEmpty)
+				So(serverFlows.records(), ShouldBeEmpty)
+				So(clientConn.GetState(), ShouldEqual, connection.UDPClientSendSyn)
+				So(serverConn.GetState(), ShouldEqual, connection.UDPReceiverSendSynAck)
+			})
+		})
+
+		Convey("When the handshake completes before the timeout", func() {
+			_, err := transport.deliverToClient(client)
+			So(err, ShouldBeNil)
+			_, err = transport.deliverToServer(server)
+			So(err, ShouldBeNil)
+
+			now = now.Add(6 * time.Second)
+			client.expireUDPHandshakes()
+			server.expireUDPHandshakes()
+
+			Convey("Then the connections should not expire", func() {
+				So(clientConn.GetState(), ShouldEqual, connection.UDPData)
+				So(serverConn.GetState(), ShouldEqual, connection.UDPReceiverProcessedAck)
+				for _, record := range append(clientFlows.records(), serverFlows.records()...) {
+					So(record.DropReason, ShouldNotEqual, collector.HandshakeTimeout)
+				}
+			})
+		})
+
+		Convey("When the timeout is disabled", func() {
+			client.SetUDPHandshakeTimeout(0)
+			other, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5001, 53, []byte("query"))
+			So(err, ShouldBeNil)
+			So(client.ProcessApplicationUDPPacket(other), ShouldNotBeNil)
+
+			now = now.Add(time.Hour)
+			client.expireUDPHandshakes()
+
+			Convey("Then the handshakes started after it should not expire", func() {
+				item, err := client.udpAppOrigConnectionTracker.Get(other.L4FlowHash())
+				So(err, ShouldBeNil)
+				So(item.(*connection.UDPConnection).GetState(), ShouldEqual, connection.UDPClientSendSyn)
+				So(len(clientFlows.records()), ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,29 @@
+package nfqdatapath
+
+import (
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/acls"
+)
+
+// SetImplicitAllowNetworks sets the networks that the ACLs of the PUs
+// enforced afterwards accept when no rule matches, such as the loopback and
+// link-local networks. No network is implicitly allowed by default. Only IPv4
+// networks are supported.
+func (d *Datapath) SetImplicitAllowNetworks(networks []string) error {
+
+	if err := acls.ValidateImplicitAllowNetworks(networks); err != nil {
+		return err
+	}
+
+	d.implicitAllowNetworks.Store(append([]string{}, networks...))
+
+	return nil
+}
+
+// ImplicitAllowNetworks returns the networks that the ACLs of the PUs accept
+// when no rule matches.
+func (d *Datapath) ImplicitAllowNetworks() []string {
+
+	networks, _ := d.implicitAllowNetworks.Load().([]string)
+
+	return networks
+}
@@ -0,0 +1,47 @@
+package nfqdatapath
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestImplicitAllowNetworks(t *testing.T) {
+
+	Convey("Given an enforcer", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		lookup := func() *policy.FlowPolicy {
+			So(enforcer.Enforce("server", policy.NewPUInfo("server", common.ContainerPU)), ShouldBeNil)
+			item, err := enforcer.puFromContextID.Get("server")
+			So(err, ShouldBeNil)
+			_, p, _ := item.(*pucontext.PUContext).ApplicationACLPolicyFromAddr(net.ParseIP("169.254.169.254").To4(), 80, 0)
+			return p
+		}
+
+		Convey("When no network is implicitly allowed, the metadata endpoint should be rejected", func() {
+			So(enforcer.ImplicitAllowNetworks(), ShouldBeEmpty)
+			So(lookup().Action, ShouldEqual, policy.Reject)
+		})
+
+		Convey("When the link-local network is implicitly allowed, the metadata endpoint should be accepted", func() {
+			So(enforcer.SetImplicitAllowNetworks([]string{"169.254.0.0/16"}), ShouldBeNil)
+			p := lookup()
+			So(p.Action, ShouldEqual, policy.Accept)
+			So(p.PolicyID, ShouldEqual, "implicit")
+		})
+
+		Convey("When invalid networks are set, it should fail", func() {
+			So(enforcer.SetImplicitAllowNetworks([]string{"::1/128"}), ShouldNotBeNil)
+			So(enforcer.ImplicitAllowNetworks(), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,21 @@
+package nfqdatapath
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SetUDPKeyRotationInterval sets the lifetime of the keys of the established
+// UDP connections before a rotation is started. The peers of the PUs must
+// understand the key rotation packets, so the rotation is disabled by default
+// and with an interval of 0.
+func (d *Datapath) SetUDPKeyRotationInterval(interval time.Duration) {
+
+	atomic.StoreInt64(&d.udpKeyRotationInterval, int64(interval))
+}
+
+// UDPKeyRotationInterval returns the lifetime of the keys of the UDP connections.
+func (d *Datapath) UDPKeyRotationInterval() time.Duration {
+
+	return time.Duration(atomic.LoadInt64(&d.udpKeyRotationInterval))
+}
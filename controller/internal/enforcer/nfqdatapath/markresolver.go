@@ -0,0 +1,63 @@
+package nfqdatapath
+
+import (
+	"fmt"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+)
+
+// MarkResolver maps the marks that other tools set on the packets to the
+// contexts of the PUs.
+type MarkResolver interface {
+
+	// ContextID returns the ID of the context of the packets with the mark,
+	// or false if the mark is unknown.
+	ContextID(mark string) (string, bool)
+}
+
+// noMarkResolver is the default resolver. It knows no mark, so that only
+// the marks and ports of the PUs are used.
+type noMarkResolver struct{}
+
+func (noMarkResolver) ContextID(mark string) (string, bool) {
+	return "", false
+}
+
+// markResolverHolder wraps the resolver so that resolvers of different types
+// can be stored in the same atomic value.
+type markResolverHolder struct {
+	resolver MarkResolver
+}
+
+// SetMarkResolver sets the resolver consulted when the context of a packet
+// cannot be found from the marks and ports of the PUs. A nil resolver
+// restores the default, which knows no mark.
+func (d *Datapath) SetMarkResolver(r MarkResolver) {
+
+	if r == nil {
+		r = noMarkResolver{}
+	}
+
+	d.markResolver.Store(&markResolverHolder{resolver: r})
+}
+
+// resolveMark returns the context of a mark with the mark resolver.
+func (d *Datapath) resolveMark(mark string) (*pucontext.PUContext, error) {
+
+	r := MarkResolver(noMarkResolver{})
+	if h, _ := d.markResolver.Load().(*markResolverHolder); h != nil {
+		r = h.resolver
+	}
+
+	contextID, ok := r.ContextID(mark)
+	if !ok {
+		return nil, fmt.Errorf("mark %s cannot be resolved", mark)
+	}
+
+	pu, err := d.puFromContextID.Get(contextID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find contextID %s of mark %s", contextID, mark)
+	}
+
+	return pu.(*pucontext.PUContext), nil
+}
@@ -0,0 +1,113 @@
+package nfqdatapath
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// classifierMarks maps the marks of an external classifier to contexts.
+type classifierMarks struct {
+	marks   map[string]string
+	lookups int
+}
+
+func (c *classifierMarks) ContextID(mark string) (string, bool) {
+	c.lookups++
+	contextID, ok := c.marks[mark]
+	return contextID, ok
+}
+
+func TestMarkResolver(t *testing.T) {
+
+	Convey("Given an enforcer for Linux processes with a PU and a mark resolver", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.LocalServer, "/proc", []string{"0.0.0.0/0"})
+		enforcer.mode = constants.LocalServer
+
+		context, err := pucontext.NewPU("pu", policy.NewPUInfo("pu", common.LinuxProcessPU), 10*time.Second)
+		So(err, ShouldBeNil)
+		enforcer.puFromMark.AddOrUpdate("100", context)
+		enforcer.puFromContextID.AddOrUpdate("pu", context)
+
+		resolver := &classifierMarks{
+			marks: map[string]string{
+				"200": "pu",
+				"300": "unknown",
+			},
+		}
+		enforcer.SetMarkResolver(resolver)
+
+		Convey("The marks of the PUs should be found without the resolver", func() {
+			ctx, err := enforcer.contextFromIP(true, "20.1.1.1", "100", 0, packet.IPProtocolTCP)
+			So(err, ShouldBeNil)
+			So(ctx, ShouldEqual, context)
+			So(resolver.lookups, ShouldEqual, 0)
+		})
+
+		Convey("The marks of the classifier should be resolved for app packets", func() {
+			ctx, err := enforcer.contextFromIP(true, "20.1.1.1", "200", 0, packet.IPProtocolTCP)
+			So(err, ShouldBeNil)
+			So(ctx, ShouldEqual, context)
+
+			Convey("And the context should not be cached", func() {
+				_, err := enforcer.contextFromIP(true, "20.1.1.1", "200", 0, packet.IPProtocolTCP)
+				So(err, ShouldBeNil)
+				So(resolver.lookups, ShouldEqual, 2)
+			})
+
+			Convey("When a PU is enforced with the mark, its context should be found", func() {
+				enforced, err := pucontext.NewPU("enforced", policy.NewPUInfo("enforced", common.LinuxProcessPU), 10*time.Second)
+				So(err, ShouldBeNil)
+				enforcer.puFromMark.AddOrUpdate("200", enforced)
+
+				ctx, err := enforcer.contextFromIP(true, "20.1.1.1", "200", 0, packet.IPProtocolTCP)
+				So(err, ShouldBeNil)
+				So(ctx, ShouldEqual, enforced)
+				So(resolver.lookups, ShouldEqual, 1)
+			})
+		})
+
+		Convey("The marks of the classifier should be resolved for net packets without a PU port", func() {
+			ctx, err := enforcer.contextFromIP(false, "20.1.1.1", "200", 9000, packet.IPProtocolTCP)
+			So(err, ShouldBeNil)
+			So(ctx, ShouldEqual, context)
+
+			Convey("And the context should not be cached for the port", func() {
+				_, err := enforcer.contextFromIP(false, "20.1.1.1", "", 9000, packet.IPProtocolTCP)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("The marks that are unknown or resolve to unknown contexts should fail", func() {
+			_, err := enforcer.contextFromIP(true, "20.1.1.1", "2000", 0, packet.IPProtocolTCP)
+			So(err, ShouldNotBeNil)
+			_, err = enforcer.contextFromIP(true, "20.1.1.1", "300", 0, packet.IPProtocolTCP)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The default resolver should not resolve any mark", func() {
+			enforcer.SetMarkResolver(nil)
+			_, err := enforcer.contextFromIP(true, "20.1.1.1", "200", 0, packet.IPProtocolTCP)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package nfqdatapath
+
+import (
+	"sync/atomic"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// SetRelaxedMutualAuthReporting enables the reports of the flows that are
+// accepted only because the mutual authorization is disabled, so that the
+// flows that would break if it were enforced can be audited. The reports
+// are disabled by default.
+func (d *Datapath) SetRelaxedMutualAuthReporting(enable bool) {
+
+	var value uint32
+	if enable {
+		value = 1
+	}
+
+	atomic.StoreUint32(&d.reportRelaxedMutualAuth, value)
+}
+
+// reportRelaxedMutualAuthFlow reports the flow of a SynAck packet accepted
+// without mutual authorization if the transmit rules of the PU reject the
+// remote PU.
+func (d *Datapath) reportRelaxedMutualAuthFlow(p *packet.Packet, context *pucontext.PUContext, remoteID string, claims *tokens.ConnectionClaims) {
+
+	if atomic.LoadUint32(&d.reportRelaxedMutualAuth) == 0 || context.MutualAuthorization(d.mutualAuthorization) {
+		return
+	}
+
+	report, pkt := context.SearchTxtRules(claims.T, false)
+	if !pkt.Action.Rejected() {
+		return
+	}
+
+	accept := &policy.FlowPolicy{
+		Action:     policy.Accept,
+		PolicyID:   pkt.PolicyID,
+		SelectorID: pkt.SelectorID,
+		ServiceID:  pkt.ServiceID,
+	}
+
+	record := flowRecord(p, context.ManagementID(), remoteID, context, "", report, accept)
+	record.RelaxedMutualAuth = true
+
+	d.collector.CollectFlowEvent(record)
+}
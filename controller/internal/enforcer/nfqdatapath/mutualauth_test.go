@@ -0,0 +1,81 @@
+package nfqdatapath
+
+import (
+	"testing"
+	"time"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
+	"go.aporeto.io/trireme-lib/policy"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRelaxedMutualAuthReporting(t *testing.T) {
+
+	Convey("Given I have an enforcer and a PU that transmits only to web servers", t, func() {
+		flows := &flowCapturingCollector{}
+		enforcer := newFailureTestEnforcer(flows, constants.RemoteContainer)
+
+		txtags := policy.TagSelectorList{
+			policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{
+						Key:      "app",
+						Value:    []string{"web"},
+						Operator: policy.Equal,
+					},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web"},
+			},
+		}
+
+		newContext := func(mutualAuth policy.MutualAuthorizationType) *pucontext.PUContext {
+			puPolicy := policy.NewPUPolicy("pu", policy.Police, nil, nil, nil, txtags, nil, nil, nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+			puPolicy.SetMutualAuthorization(mutualAuth)
+			runtime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+			context, err := pucontext.NewPU("pu", policy.PUInfoFromPolicyAndRuntime("pu", puPolicy, runtime), 10*time.Second)
+			So(err, ShouldBeNil)
+			return context
+		}
+
+		p, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 80, 2000, []byte{})
+		So(err, ShouldBeNil)
+
+		db := &tokens.ConnectionClaims{T: policy.NewTagStoreFromSlice([]string{"app=db"})}
+		web := &tokens.ConnectionClaims{T: policy.NewTagStoreFromSlice([]string{"app=web"})}
+
+		Convey("When the reporting is disabled, the relaxed flows should not be reported", func() {
+			enforcer.reportRelaxedMutualAuthFlow(p, newContext(policy.MutualAuthorizationDisabled), "remote", db)
+			So(len(flows.records()), ShouldEqual, 0)
+		})
+
+		Convey("When the reporting is enabled", func() {
+			enforcer.SetRelaxedMutualAuthReporting(true)
+
+			Convey("The flows rejected by the transmit rules should be reported", func() {
+				enforcer.reportRelaxedMutualAuthFlow(p, newContext(policy.MutualAuthorizationDisabled), "remote", db)
+
+				records := flows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].RelaxedMutualAuth, ShouldBeTrue)
+				So(records[0].Action, ShouldEqual, policy.Accept)
+				So(records[0].PolicyID, ShouldEqual, "default")
+				So(records[0].Source.ID, ShouldEqual, "pu")
+				So(records[0].Destination.ID, ShouldEqual, "remote")
+			})
+
+			Convey("The flows accepted by the transmit rules should not be reported", func() {
+				enforcer.reportRelaxedMutualAuthFlow(p, newContext(policy.MutualAuthorizationDisabled), "remote", web)
+				So(len(flows.records()), ShouldEqual, 0)
+			})
+
+			Convey("The flows of the PUs with mutual authorization should not be reported", func() {
+				enforcer.reportRelaxedMutualAuthFlow(p, newContext(policy.MutualAuthorizationEnabled), "remote", db)
+				So(len(flows.records()), ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/controller/pkg/packet"
 	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/logging"
 
 	"go.uber.org/zap"
 )
@@ -58,7 +59,7 @@ func (a *nfLog) sourceNFLogsHanlder(buf *nflog.NfPacket, data interface{}) {
 
 	record, err := a.recordFromNFLogBuffer(buf, false)
 	if err != nil {
-		zap.L().Error("sourceNFLogsHanlder: create flow record", zap.Error(err))
+		logging.Named("datapath").Error("sourceNFLogsHanlder: create flow record", zap.Error(err))
 		return
 	}
 
@@ -69,7 +70,7 @@ func (a *nfLog) destNFLogsHandler(buf *nflog.NfPacket, data interface{}) {
 
 	record, err := a.recordFromNFLogBuffer(buf, true)
 	if err != nil {
-		zap.L().Error("destNFLogsHandler: create flow record", zap.Error(err))
+		logging.Named("datapath").Error("destNFLogsHandler: create flow record", zap.Error(err))
 		return
 	}
 
@@ -78,7 +79,7 @@ func (a *nfLog) destNFLogsHandler(buf *nflog.NfPacket, data interface{}) {
 
 func (a *nfLog) nflogErrorHandler(err error) {
 
-	zap.L().Error("Error while processing nflog packet", zap.Error(err))
+	logging.Named("datapath").Error("Error while processing nflog packet", zap.Error(err))
 }
 
 func (a *nfLog) recordFromNFLogBuffer(buf *nflog.NfPacket, puIsSource bool) (*collector.FlowRecord, error) {
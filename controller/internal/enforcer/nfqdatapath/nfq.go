@@ -6,6 +6,11 @@ import "context"
 
 // Go libraries
 
+// newNFQBinder returns no binder, since the queues are not used.
+func newNFQBinder(d *Datapath) nfqBinder {
+	return nil
+}
+
 // startNetworkInterceptor will the process that processes  packets from the network
 // Still has one more copy than needed. Can be improved.
 func (d *Datapath) startNetworkInterceptor(ctx context.Context) {}
@@ -6,16 +6,18 @@ package nfqdatapath
 import (
 	"context"
 	"fmt"
+	"os"
 	"strconv"
 	"time"
 
 	nfqueue "go.aporeto.io/netlink-go/nfqueue"
 	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/utils/logging"
 	"go.uber.org/zap"
 )
 
 func errorCallback(err error, data interface{}) {
-	zap.L().Error("Error while processing packets on queue", zap.Error(err))
+	logging.Named("datapath").Error("Error while processing packets on queue", zap.Error(err))
 }
 func networkCallback(packet *nfqueue.NFPacket, d interface{}) {
 	d.(*Datapath).processNetworkPacketsFromNFQ(packet)
@@ -25,23 +27,63 @@ func appCallBack(packet *nfqueue.NFPacket, d interface{}) {
 	d.(*Datapath).processApplicationPacketsFromNFQ(packet)
 }
 
+// nfqueueProcFile lists the NFQ queues bound in the network namespace.
+const nfqueueProcFile = "/proc/net/netfilter/nfnetlink_queue"
+
+// nfqueueBinder binds the queues of the datapath with netlink.
+type nfqueueBinder struct {
+	d *Datapath
+}
+
+// newNFQBinder returns the binder of the queues of the datapath.
+func newNFQBinder(d *Datapath) nfqBinder {
+	return &nfqueueBinder{d: d}
+}
+
+// bind binds the queue and starts processing its packets.
+func (b *nfqueueBinder) bind(ctx context.Context, queue uint16, app bool) error {
+
+	size, callback := b.d.filterQueue.GetNetworkQueueSize(), networkCallback
+	if app {
+		size, callback = b.d.filterQueue.GetApplicationQueueSize(), appCallBack
+	}
+
+	_, err := nfqueue.CreateAndStartNfQueue(ctx, queue, size, nfqueue.NfDefaultPacketSize, callback, errorCallback, b.d)
+
+	return err
+}
+
+// bound returns the queues listed by the kernel. No queue is bound if the
+// nfnetlink_queue module is not loaded.
+func (b *nfqueueBinder) bound() (map[uint16]bool, error) {
+
+	f, err := os.Open(nfqueueProcFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[uint16]bool{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close() // nolint errcheck
+
+	return parseBoundQueues(f)
+}
+
 // startNetworkInterceptor will the process that processes  packets from the network
 // Still has one more copy than needed. Can be improved.
 func (d *Datapath) startNetworkInterceptor(ctx context.Context) {
 	var err error
 
-	nfq := make([]nfqueue.Verdict, d.filterQueue.GetNumNetworkQueues())
-
 	for i := uint16(0); i < d.filterQueue.GetNumNetworkQueues(); i++ {
 		// Initialize all the queues
-		nfq[i], err = nfqueue.CreateAndStartNfQueue(ctx, d.filterQueue.GetNetworkQueueStart()+i, d.filterQueue.GetNetworkQueueSize(), nfqueue.NfDefaultPacketSize, networkCallback, errorCallback, d)
+		err = d.nfqProbe.bind(ctx, d.filterQueue.GetNetworkQueueStart()+i, false)
 		if err != nil {
 			for retry := 0; retry < 5 && err != nil; retry++ {
-				nfq[i], err = nfqueue.CreateAndStartNfQueue(ctx, d.filterQueue.GetNetworkQueueStart()+i, d.filterQueue.GetNetworkQueueSize(), nfqueue.NfDefaultPacketSize, networkCallback, errorCallback, d)
+				err = d.nfqProbe.bind(ctx, d.filterQueue.GetNetworkQueueStart()+i, false)
 				<-time.After(3 * time.Second)
 			}
 			if err != nil {
-				zap.L().Fatal("Unable to initialize netfilter queue", zap.Error(err))
+				logging.Named("datapath").Fatal("Unable to initialize netfilter queue", zap.Error(err))
 			}
 		}
 	}
@@ -52,18 +94,16 @@ func (d *Datapath) startNetworkInterceptor(ctx context.Context) {
 func (d *Datapath) startApplicationInterceptor(ctx context.Context) {
 	var err error
 
-	nfq := make([]nfqueue.Verdict, d.filterQueue.GetNumApplicationQueues())
-
 	for i := uint16(0); i < d.filterQueue.GetNumApplicationQueues(); i++ {
-		nfq[i], err = nfqueue.CreateAndStartNfQueue(ctx, d.filterQueue.GetApplicationQueueStart()+i, d.filterQueue.GetApplicationQueueSize(), nfqueue.NfDefaultPacketSize, appCallBack, errorCallback, d)
+		err = d.nfqProbe.bind(ctx, d.filterQueue.GetApplicationQueueStart()+i, true)
 
 		if err != nil {
 			for retry := 0; retry < 5 && err != nil; retry++ {
-				nfq[i], err = nfqueue.CreateAndStartNfQueue(ctx, d.filterQueue.GetApplicationQueueStart()+i, d.filterQueue.GetApplicationQueueSize(), nfqueue.NfDefaultPacketSize, appCallBack, errorCallback, d)
+				err = d.nfqProbe.bind(ctx, d.filterQueue.GetApplicationQueueStart()+i, true)
 				<-time.After(3 * time.Second)
 			}
 			if err != nil {
-				zap.L().Fatal("Unable to initialize netfilter queue", zap.Int("QueueNum", int(d.filterQueue.GetNetworkQueueStart()+i)), zap.Error(err))
+				logging.Named("datapath").Fatal("Unable to initialize netfilter queue", zap.Int("QueueNum", int(d.filterQueue.GetNetworkQueueStart()+i)), zap.Error(err))
 			}
 
 		}
@@ -73,6 +113,13 @@ func (d *Datapath) startApplicationInterceptor(ctx context.Context) {
 // processNetworkPacketsFromNFQ processes packets arriving from the network in an NF queue
 func (d *Datapath) processNetworkPacketsFromNFQ(p *nfqueue.NFPacket) {
 
+	// The ICMP errors that report the MTU of a path are accepted. The kernel
+	// learns the MTU too.
+	if d.learnPathMTU(p.Buffer) {
+		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 1, uint32(p.Mark), uint32(len(p.Buffer)), uint32(p.ID), p.Buffer)
+		return
+	}
+
 	// Parse the packet - drop if parsing fails
 	netPacket, err := packet.New(packet.PacketTypeNetwork, p.Buffer, strconv.Itoa(int(p.Mark)), true)
 
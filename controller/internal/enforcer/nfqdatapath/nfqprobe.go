@@ -0,0 +1,221 @@
+package nfqdatapath
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/utils/logging"
+	"go.uber.org/zap"
+)
+
+// nfqProbeInterval is the interval of the checks of the NFQ queue bindings.
+var nfqProbeInterval = 10 * time.Second
+
+// nfqComponent is the component of the health events of the NFQ queues.
+const nfqComponent = "nfqueue"
+
+// nfqBinder binds the NFQ queues of the datapath.
+type nfqBinder interface {
+
+	// bind binds the queue and processes its packets until the context is
+	// done. app is set for the queues of the application packets.
+	bind(ctx context.Context, queue uint16, app bool) error
+
+	// bound returns the queues that are bound in the kernel.
+	bound() (map[uint16]bool, error)
+}
+
+// nfqBinding is a queue bound by the datapath. bound is cleared when the
+// binding is lost and the queue can't be bound again.
+type nfqBinding struct {
+	app    bool
+	bound  bool
+	cancel context.CancelFunc
+}
+
+// nfqProbe checks periodically that the NFQ queues bound by the datapath
+// are still bound in the kernel. The kernel drops the bindings when the
+// nfnetlink_queue module is reloaded, and the packets are then silently no
+// longer delivered to the datapath. The lost queues are bound again, and a
+// critical health event is reported. A second event is reported once all
+// the queues are bound again.
+type nfqProbe struct {
+	binder    nfqBinder
+	collector collector.EventCollector
+	queues    map[uint16]*nfqBinding
+	// err is set while some queues can't be bound again.
+	err error
+	sync.Mutex
+}
+
+// newNFQProbe returns a probe of the queues bound with the binder.
+func newNFQProbe(binder nfqBinder, collector collector.EventCollector) *nfqProbe {
+
+	return &nfqProbe{
+		binder:    binder,
+		collector: collector,
+		queues:    map[uint16]*nfqBinding{},
+	}
+}
+
+// bind binds the queue and records it so that it is checked.
+func (p *nfqProbe) bind(ctx context.Context, queue uint16, app bool) error {
+
+	p.Lock()
+	defer p.Unlock()
+
+	return p.bindLocked(ctx, queue, app)
+}
+
+// bindLocked binds the queue. The previous binding of the queue is stopped
+// first. It must be called with the lock held.
+func (p *nfqProbe) bindLocked(ctx context.Context, queue uint16, app bool) error {
+
+	b, ok := p.queues[queue]
+	if !ok {
+		b = &nfqBinding{app: app}
+		p.queues[queue] = b
+	}
+
+	if b.cancel != nil {
+		b.cancel()
+	}
+
+	qctx, cancel := context.WithCancel(ctx)
+	b.cancel = cancel
+	b.bound = true
+
+	if err := p.binder.bind(qctx, queue, app); err != nil {
+		b.bound = false
+		return err
+	}
+
+	return nil
+}
+
+// healthy returns an error if some queues are no longer bound.
+func (p *nfqProbe) healthy() error {
+
+	p.Lock()
+	defer p.Unlock()
+
+	return p.err
+}
+
+// run checks the queues at every interval until the context is done.
+func (p *nfqProbe) run(ctx context.Context, interval time.Duration) {
+
+	if p.binder == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.check(ctx)
+		}
+	}
+}
+
+// check binds again the queues that are no longer bound in the kernel.
+func (p *nfqProbe) check(ctx context.Context) {
+
+	kernel, err := p.binder.bound()
+	if err != nil {
+		logging.Named("datapath").Warn("Unable to check the bindings of the queues", zap.Error(err))
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	lost := []int{}
+	retried := []int{}
+	for queue, b := range p.queues {
+		switch {
+		case !b.bound:
+			retried = append(retried, int(queue))
+		case !kernel[queue]:
+			lost = append(lost, int(queue))
+		}
+	}
+
+	if len(lost) == 0 && len(retried) == 0 {
+		return
+	}
+
+	sort.Ints(lost)
+
+	if len(lost) > 0 {
+		logging.Named("datapath").Error("Queues are no longer bound, binding them again", zap.Ints("queues", lost))
+		p.collector.CollectHealthEvent(&collector.HealthRecord{
+			Component: nfqComponent,
+			Critical:  true,
+			Message:   fmt.Sprintf("queues %v are no longer bound", lost),
+		})
+	}
+
+	unbound := append(append([]int{}, lost...), retried...)
+	sort.Ints(unbound)
+
+	failed := []int{}
+	for _, queue := range unbound {
+		if err := p.bindLocked(ctx, uint16(queue), p.queues[uint16(queue)].app); err != nil {
+			logging.Named("datapath").Error("Unable to bind the queue again", zap.Int("queue", queue), zap.Error(err))
+			failed = append(failed, queue)
+		}
+	}
+
+	if len(failed) > 0 {
+		p.err = fmt.Errorf("nfq queues %v are not bound", failed)
+		return
+	}
+
+	p.err = nil
+
+	logging.Named("datapath").Info("Queues are bound again", zap.Ints("queues", unbound))
+	p.collector.CollectHealthEvent(&collector.HealthRecord{
+		Component: nfqComponent,
+		Message:   fmt.Sprintf("queues %v are bound again", unbound),
+	})
+}
+
+// parseBoundQueues returns the queues listed in the format of
+// /proc/net/netfilter/nfnetlink_queue. The first field of every line is
+// the number of a bound queue.
+func parseBoundQueues(r io.Reader) (map[uint16]bool, error) {
+
+	queues := map[uint16]bool{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		queue, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid queue %s: %s", fields[0], err)
+		}
+		queues[uint16(queue)] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return queues, nil
+}
@@ -0,0 +1,183 @@
+package nfqdatapath
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/collector/mockcollector"
+)
+
+// fakeNFQBinder simulates the bindings of the queues in the kernel.
+type fakeNFQBinder struct {
+	kernel   map[uint16]bool
+	contexts map[uint16]context.Context
+	binds    []uint16
+	fail     bool
+	sync.Mutex
+}
+
+func newFakeNFQBinder() *fakeNFQBinder {
+	return &fakeNFQBinder{
+		kernel:   map[uint16]bool{},
+		contexts: map[uint16]context.Context{},
+	}
+}
+
+func (b *fakeNFQBinder) bind(ctx context.Context, queue uint16, app bool) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.binds = append(b.binds, queue)
+	if b.fail {
+		return errors.New("bind failed")
+	}
+
+	b.kernel[queue] = true
+	b.contexts[queue] = ctx
+	return nil
+}
+
+func (b *fakeNFQBinder) bound() (map[uint16]bool, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	kernel := map[uint16]bool{}
+	for queue, bound := range b.kernel {
+		kernel[queue] = bound
+	}
+	return kernel, nil
+}
+
+// unbind simulates the loss of the binding of the queue.
+func (b *fakeNFQBinder) unbind(queue uint16) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.kernel, queue)
+}
+
+func TestNFQProbe(t *testing.T) {
+
+	Convey("Given a probe of two bound queues", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		c := mockcollector.NewMockEventCollector(ctrl)
+		binder := newFakeNFQBinder()
+		p := newNFQProbe(binder, c)
+
+		So(p.bind(context.Background(), 1, false), ShouldBeNil)
+		So(p.bind(context.Background(), 2, true), ShouldBeNil)
+		first := binder.contexts[2]
+		binder.binds = nil
+
+		Convey("When the queues are still bound, nothing should be done", func() {
+			p.check(context.Background())
+			So(binder.binds, ShouldBeEmpty)
+			So(p.healthy(), ShouldBeNil)
+		})
+
+		Convey("When a binding is lost, the queue should be bound again", func() {
+			binder.unbind(2)
+
+			gomock.InOrder(
+				c.EXPECT().CollectHealthEvent(&collector.HealthRecord{
+					Component: nfqComponent,
+					Critical:  true,
+					Message:   "queues [2] are no longer bound",
+				}),
+				c.EXPECT().CollectHealthEvent(&collector.HealthRecord{
+					Component: nfqComponent,
+					Message:   "queues [2] are bound again",
+				}),
+			)
+			p.check(context.Background())
+
+			So(binder.binds, ShouldResemble, []uint16{2})
+			So(p.queues[2].app, ShouldBeTrue)
+			So(first.Err(), ShouldNotBeNil)
+			So(binder.contexts[2].Err(), ShouldBeNil)
+			So(p.healthy(), ShouldBeNil)
+		})
+
+		Convey("When a lost queue can't be bound again, the probe should not be healthy", func() {
+			binder.unbind(1)
+			binder.fail = true
+
+			c.EXPECT().CollectHealthEvent(&collector.HealthRecord{
+				Component: nfqComponent,
+				Critical:  true,
+				Message:   "queues [1] are no longer bound",
+			})
+			p.check(context.Background())
+
+			So(binder.binds, ShouldResemble, []uint16{1})
+			So(p.healthy(), ShouldNotBeNil)
+
+			Convey("The queue should be bound again at the next check without a new critical event", func() {
+				binder.fail = false
+
+				c.EXPECT().CollectHealthEvent(&collector.HealthRecord{
+					Component: nfqComponent,
+					Message:   "queues [1] are bound again",
+				})
+				p.check(context.Background())
+
+				So(binder.binds, ShouldResemble, []uint16{1, 1})
+				So(p.healthy(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestNFQProbeHealthy(t *testing.T) {
+
+	Convey("Given a datapath whose queue can't be bound again", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		c := mockcollector.NewMockEventCollector(ctrl)
+		c.EXPECT().CollectHealthEvent(gomock.Any())
+
+		binder := newFakeNFQBinder()
+		d := &Datapath{
+			ready:    make(chan struct{}),
+			nfqProbe: newNFQProbe(binder, c),
+		}
+		close(d.ready)
+
+		So(d.nfqProbe.bind(context.Background(), 3, false), ShouldBeNil)
+		So(d.Healthy(), ShouldBeNil)
+
+		binder.unbind(3)
+		binder.fail = true
+		d.nfqProbe.check(context.Background())
+
+		Convey("The datapath should not be healthy", func() {
+			So(d.Healthy(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestParseBoundQueues(t *testing.T) {
+
+	Convey("When I parse the queues listed by the kernel, I should get their numbers", t, func() {
+		queues, err := parseBoundQueues(strings.NewReader(
+			"    0  12345     0 2 65531     0     0        1  1\n" +
+				"   40  12346     3 2 65531     0     0       17  1\n",
+		))
+		So(err, ShouldBeNil)
+		So(queues, ShouldResemble, map[uint16]bool{0: true, 40: true})
+	})
+
+	Convey("When I parse an invalid queue, I should get an error", t, func() {
+		_, err := parseBoundQueues(strings.NewReader("x 1 2\n"))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,168 @@
+package nfqdatapath
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash"
+)
+
+const (
+	// udpNonceWindow is the minimum time during which the nonce of a UDP
+	// handshake is remembered. Nonces are remembered for up to twice the
+	// window.
+	udpNonceWindow = 5 * time.Minute
+	// udpNonceCapacity is the number of nonces that a generation of the
+	// cache holds before it is rotated.
+	udpNonceCapacity = 65536
+	// udpNonceFalsePositive is the probability that the nonce of a new
+	// handshake is wrongly found in a generation of the cache.
+	udpNonceFalsePositive = 1e-6
+)
+
+// bloomFilter is a fixed size set of byte slices. It can report that an
+// element is present when it was never added, but never the opposite.
+type bloomFilter struct {
+	bits   []uint64
+	size   uint64
+	hashes uint64
+	count  int
+}
+
+// newBloomFilter returns a filter sized for the capacity and the false
+// positive rate.
+func newBloomFilter(capacity int, falsePositive float64) *bloomFilter {
+
+	size := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositive) / (math.Ln2 * math.Ln2)))
+	if size < 64 {
+		size = 64
+	}
+
+	hashes := uint64(math.Ceil(float64(size) / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	return &bloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: hashes,
+	}
+}
+
+// add adds the element to the filter.
+func (b *bloomFilter) add(data []byte) {
+
+	h1, h2 := b.split(data)
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % b.size
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+
+	b.count++
+}
+
+// test returns true if the element is probably in the filter.
+func (b *bloomFilter) test(data []byte) bool {
+
+	h1, h2 := b.split(data)
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % b.size
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reset removes all the elements of the filter.
+func (b *bloomFilter) reset() {
+
+	for i := range b.bits {
+		b.bits[i] = 0
+	}
+
+	b.count = 0
+}
+
+// split derives the two hashes of the double hashing of the element.
+func (b *bloomFilter) split(data []byte) (uint64, uint64) {
+
+	h := xxhash.Sum64(data)
+
+	return h & 0xffffffff, h>>32 | 1
+}
+
+// nonceCache holds the nonces of the handshakes accepted in a sliding
+// window, so that a replayed handshake is rejected. The nonces are stored
+// in two generations of bloom filters. The current generation becomes the
+// previous one when the window elapses, so a nonce is remembered between
+// one and two windows. The memory is fixed by the capacity.
+//
+// A false positive rejects a legitimate handshake as a replay. Its rate is
+// bounded by rotating a generation early when it is full, at the cost of a
+// shorter window: under a burst of more than the capacity of handshakes in
+// a window, the replays of the oldest nonces are no longer detected.
+type nonceCache struct {
+	window   time.Duration
+	capacity int
+
+	current  *bloomFilter
+	previous *bloomFilter
+	rotated  time.Time
+
+	sync.Mutex
+}
+
+// newNonceCache returns a cache that remembers the nonces for the window.
+// Every generation holds up to capacity nonces with the false positive rate.
+func newNonceCache(window time.Duration, capacity int, falsePositive float64) *nonceCache {
+
+	return &nonceCache{
+		window:   window,
+		capacity: capacity,
+		current:  newBloomFilter(capacity, falsePositive),
+		previous: newBloomFilter(capacity, falsePositive),
+		rotated:  time.Now(),
+	}
+}
+
+// consume records the nonce. It returns false if the nonce was already
+// consumed in the window.
+func (n *nonceCache) consume(nonce []byte) bool {
+
+	n.Lock()
+	defer n.Unlock()
+
+	n.rotate(time.Now())
+
+	if n.current.test(nonce) || n.previous.test(nonce) {
+		return false
+	}
+
+	n.current.add(nonce)
+
+	return true
+}
+
+// rotate starts a new generation when the window has elapsed or the
+// current generation is full.
+func (n *nonceCache) rotate(now time.Time) {
+
+	elapsed := now.Sub(n.rotated)
+
+	switch {
+	case elapsed >= 2*n.window:
+		n.current.reset()
+		n.previous.reset()
+	case elapsed >= n.window || n.current.count >= n.capacity:
+		n.previous.reset()
+		n.current, n.previous = n.previous, n.current
+	default:
+		return
+	}
+
+	n.rotated = now
+}
@@ -0,0 +1,72 @@
+package nfqdatapath
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNonceCache(t *testing.T) {
+
+	Convey("Given a nonce cache", t, func() {
+		n := newNonceCache(time.Minute, 4, 0.001)
+
+		Convey("When a nonce is replayed within the window, it should be rejected", func() {
+			So(n.consume([]byte("nonce1")), ShouldBeTrue)
+			So(n.consume([]byte("nonce2")), ShouldBeTrue)
+			So(n.consume([]byte("nonce1")), ShouldBeFalse)
+		})
+
+		Convey("When the window elapses, the nonce should still be rejected from the previous generation", func() {
+			So(n.consume([]byte("nonce1")), ShouldBeTrue)
+			n.rotated = n.rotated.Add(-time.Minute)
+			So(n.consume([]byte("nonce1")), ShouldBeFalse)
+			So(n.consume([]byte("nonce2")), ShouldBeTrue)
+		})
+
+		Convey("When a nonce is replayed beyond the window, it should be accepted", func() {
+			n.window = time.Millisecond
+			So(n.consume([]byte("nonce1")), ShouldBeTrue)
+			time.Sleep(5 * time.Millisecond)
+			So(n.consume([]byte("nonce1")), ShouldBeTrue)
+			So(n.consume([]byte("nonce1")), ShouldBeFalse)
+		})
+
+		Convey("When a generation is full, it should be rotated before the window elapses", func() {
+			for _, nonce := range []string{"a", "b", "c", "d"} {
+				So(n.consume([]byte(nonce)), ShouldBeTrue)
+			}
+			So(n.consume([]byte("e")), ShouldBeTrue)
+			So(n.current.count, ShouldEqual, 1)
+			So(n.consume([]byte("a")), ShouldBeFalse)
+
+			for _, nonce := range []string{"f", "g", "h", "i"} {
+				So(n.consume([]byte(nonce)), ShouldBeTrue)
+			}
+			So(n.consume([]byte("a")), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a nonce cache filled to its capacity", t, func() {
+		n := newNonceCache(time.Minute, 1000, 0.01)
+		for i := 0; i < 1000; i++ {
+			nonce := make([]byte, 16)
+			rand.Read(nonce) // nolint errcheck
+			n.current.add(nonce)
+		}
+
+		Convey("The false positive rate of new nonces should be bounded", func() {
+			rejected := 0
+			for i := 0; i < 10000; i++ {
+				nonce := make([]byte, 16)
+				rand.Read(nonce) // nolint errcheck
+				if n.current.test(nonce) {
+					rejected++
+				}
+			}
+			So(rejected, ShouldBeLessThan, 300)
+		})
+	})
+}
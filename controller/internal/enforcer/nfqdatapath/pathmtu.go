@@ -0,0 +1,88 @@
+package nfqdatapath
+
+import (
+	"net"
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/utils/logging"
+	"go.uber.org/zap"
+)
+
+// pathMTULifetime is how long the MTU learned for a destination is used. The
+// MTU of a path can increase again, so it is relearned once it expires.
+const pathMTULifetime = 10 * time.Minute
+
+// The smallest MTUs accepted from the ICMP errors. The errors with smaller
+// MTUs can only be forged, to make the datapath fragment its packets.
+const (
+	minIPv4PathMTU = 68
+	minIPv6PathMTU = 1280
+)
+
+// learnPathMTU caches the MTU of the path reported by an ICMP fragmentation
+// needed or ICMPv6 packet too big error. It returns false if the packet is
+// not such an error. The MTU of a path is only lowered until it expires.
+func (d *Datapath) learnPathMTU(bytes []byte) bool {
+
+	dst, mtu, ok := packet.ParsePathMTUError(bytes)
+	if !ok {
+		return false
+	}
+
+	minMTU := minIPv4PathMTU
+	if dst.To4() == nil {
+		minMTU = minIPv6PathMTU
+	}
+
+	if mtu < minMTU {
+		logging.Named("datapath").Debug("Ignoring path MTU below the minimum",
+			zap.String("destination", dst.String()),
+			zap.Int("mtu", mtu),
+		)
+		return true
+	}
+
+	if current, ok := d.pathMTU(dst); ok && current <= mtu {
+		return true
+	}
+
+	d.pathMTUs.AddOrUpdate(dst.String(), mtu)
+
+	return true
+}
+
+// pathMTU returns the MTU learned for the path to the destination.
+func (d *Datapath) pathMTU(dst net.IP) (int, bool) {
+
+	mtu, err := d.pathMTUs.Get(dst.String())
+	if err != nil {
+		return 0, false
+	}
+
+	return mtu.(int), true
+}
+
+// fitPathMTU clears the don't fragment flag of an IPv4 packet larger than the
+// MTU learned for the path to its destination, so that the routers fragment
+// it rather than drop it.
+func (d *Datapath) fitPathMTU(buffer []byte) {
+
+	if len(buffer) < packet.UDPDataPos || buffer[0]>>4 != 4 {
+		return
+	}
+
+	dst := net.IP(buffer[16:20])
+	mtu, ok := d.pathMTU(dst)
+	if !ok || len(buffer) <= mtu {
+		return
+	}
+
+	if packet.ClearDontFragment(buffer) {
+		logging.Named("datapath").Debug("Packet larger than the path MTU can be fragmented",
+			zap.String("destination", dst.String()),
+			zap.Int("mtu", mtu),
+			zap.Int("length", len(buffer)),
+		)
+	}
+}
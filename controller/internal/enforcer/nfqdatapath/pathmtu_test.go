@@ -0,0 +1,165 @@
+package nfqdatapath
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+)
+
+// newFragNeededTestPacket returns an ICMP fragmentation needed error sent by
+// a router for a packet to the destination.
+func newFragNeededTestPacket(router, dst string, mtu uint16) []byte {
+
+	buf := make([]byte, 20+8+28)
+	buf[0] = 0x45
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	buf[8] = 64
+	buf[9] = packet.IPProtocolICMP
+	copy(buf[12:16], net.ParseIP(router).To4())
+	copy(buf[16:20], net.ParseIP("10.1.1.1").To4())
+
+	// ICMP destination unreachable, fragmentation needed
+	buf[20] = 3
+	buf[21] = 4
+	binary.BigEndian.PutUint16(buf[26:28], mtu)
+
+	// The header of the packet that was too big
+	original := buf[28:]
+	original[0] = 0x45
+	original[9] = packet.IPProtocolUDP
+	copy(original[12:16], net.ParseIP("10.1.1.1").To4())
+	copy(original[16:20], net.ParseIP(dst).To4())
+
+	return buf
+}
+
+// newPacketTooBigTestPacket returns an ICMPv6 packet too big error for a
+// packet to the destination.
+func newPacketTooBigTestPacket(dst string, mtu uint32) []byte {
+
+	buf := make([]byte, 40+8+40)
+	buf[0] = 6 << 4
+	buf[6] = packet.IPProtocolICMPv6
+	buf[7] = 64
+	buf[40] = 2
+	binary.BigEndian.PutUint32(buf[44:48], mtu)
+
+	original := buf[48:]
+	original[0] = 6 << 4
+	copy(original[24:40], net.ParseIP(dst))
+
+	return buf
+}
+
+func TestPathMTUDiscovery(t *testing.T) {
+
+	Convey("Given an enforcer", t, func() {
+		writer := &capturingSocketWriter{}
+
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		dst := net.ParseIP("20.1.1.1")
+
+		Convey("No MTU should be known for a destination", func() {
+			_, ok := enforcer.pathMTU(dst)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When a fragmentation needed error is received", func() {
+			So(enforcer.learnPathMTU(newFragNeededTestPacket("30.1.1.1", "20.1.1.1", 1400)), ShouldBeTrue)
+
+			Convey("Then the MTU of the path should be cached", func() {
+				mtu, ok := enforcer.pathMTU(dst)
+				So(ok, ShouldBeTrue)
+				So(mtu, ShouldEqual, 1400)
+
+				_, ok = enforcer.pathMTU(net.ParseIP("20.1.1.2"))
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("Then a lower MTU should replace it", func() {
+				So(enforcer.learnPathMTU(newFragNeededTestPacket("30.1.1.2", "20.1.1.1", 1280)), ShouldBeTrue)
+				mtu, _ := enforcer.pathMTU(dst)
+				So(mtu, ShouldEqual, 1280)
+			})
+
+			Convey("Then a higher MTU should not replace it", func() {
+				So(enforcer.learnPathMTU(newFragNeededTestPacket("30.1.1.2", "20.1.1.1", 1500)), ShouldBeTrue)
+				mtu, _ := enforcer.pathMTU(dst)
+				So(mtu, ShouldEqual, 1400)
+			})
+
+			Convey("Then an MTU below the minimum should be ignored", func() {
+				So(enforcer.learnPathMTU(newFragNeededTestPacket("30.1.1.2", "20.1.1.1", 40)), ShouldBeTrue)
+				mtu, _ := enforcer.pathMTU(dst)
+				So(mtu, ShouldEqual, 1400)
+			})
+
+			Convey("Then the larger packets to the destination should be allowed to fragment", func() {
+				p, err := newUDPTestPacket("10.1.1.1", "20.1.1.1", 5000, 53, make([]byte, 1500))
+				So(err, ShouldBeNil)
+				p.Buffer[6] = 0x40
+				p.UpdateIPChecksum()
+
+				So(enforcer.writeUDPSocket(p.Buffer), ShouldBeNil)
+				So(writer.count(), ShouldEqual, 1)
+
+				sent, err := packet.New(packet.PacketTypeNetwork, writer.last(), "0", true)
+				So(err, ShouldBeNil)
+				So(writer.last()[6]&0x40, ShouldEqual, 0)
+				So(sent.VerifyIPChecksum(), ShouldBeTrue)
+			})
+
+			Convey("Then the packets that fit should keep the don't fragment flag", func() {
+				p, err := newUDPTestPacket("10.1.1.1", "20.1.1.1", 5000, 53, make([]byte, 100))
+				So(err, ShouldBeNil)
+				p.Buffer[6] = 0x40
+				p.UpdateIPChecksum()
+
+				So(enforcer.writeUDPSocket(p.Buffer), ShouldBeNil)
+				So(writer.last()[6]&0x40, ShouldEqual, 0x40)
+			})
+		})
+
+		Convey("When a packet too big error is received, the MTU of the IPv6 path should be cached", func() {
+			So(enforcer.learnPathMTU(newPacketTooBigTestPacket("2001:db8::1", 1400)), ShouldBeTrue)
+			mtu, ok := enforcer.pathMTU(net.ParseIP("2001:db8::1"))
+			So(ok, ShouldBeTrue)
+			So(mtu, ShouldEqual, 1400)
+
+			Convey("And an MTU below the IPv6 minimum should be ignored", func() {
+				So(enforcer.learnPathMTU(newPacketTooBigTestPacket("2001:db8::1", 1000)), ShouldBeTrue)
+				mtu, _ := enforcer.pathMTU(net.ParseIP("2001:db8::1"))
+				So(mtu, ShouldEqual, 1400)
+			})
+		})
+
+		Convey("Other packets should not be learned", func() {
+			p, err := newUDPTestPacket("20.1.1.1", "10.1.1.1", 53, 5000, nil)
+			So(err, ShouldBeNil)
+			So(enforcer.learnPathMTU(p.Buffer), ShouldBeFalse)
+
+			unreachable := newFragNeededTestPacket("30.1.1.1", "20.1.1.1", 1400)
+			unreachable[21] = 1
+			So(enforcer.learnPathMTU(unreachable), ShouldBeFalse)
+
+			So(enforcer.learnPathMTU(newFragNeededTestPacket("30.1.1.1", "20.1.1.1", 0)), ShouldBeFalse)
+		})
+	})
+}
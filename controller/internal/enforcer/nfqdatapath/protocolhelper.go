@@ -0,0 +1,201 @@
+package nfqdatapath
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/logging"
+	"go.uber.org/zap"
+)
+
+// RelatedFlow is a connection that a server asks a client to open on a
+// control connection.
+type RelatedFlow struct {
+	Address net.IP
+	Port    uint16
+}
+
+// ProtocolHelper parses the data that the servers of a protocol send on the
+// control connections for the related connections.
+type ProtocolHelper interface {
+
+	// Port is the server port of the control connections.
+	Port() uint16
+
+	// RelatedFlows returns the related connections announced by the server
+	// in the data of a packet.
+	RelatedFlows(server net.IP, data []byte) []RelatedFlow
+}
+
+// protocolHelpers are the protocol helpers by name.
+var protocolHelpers = map[string]func() ProtocolHelper{
+	"ftp": func() ProtocolHelper { return &ftpHelper{} },
+}
+
+// ValidateProtocolHelpers returns an error if one of the protocol helpers
+// is unknown.
+func ValidateProtocolHelpers(names []string) error {
+
+	for _, name := range names {
+		if _, ok := protocolHelpers[name]; !ok {
+			return fmt.Errorf("unknown protocol helper: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// SetProtocolHelpers enables the protocol helpers with the given names, such
+// as ftp. The external control connections of the protocols are kept in the
+// datapath instead of being released to the kernel, and the related
+// connections they announce are accepted until the control connection is
+// closed. Only the servers in the target networks are inspected, as the
+// packets of the other servers are not sent to the datapath. No helper is
+// enabled by default.
+func (d *Datapath) SetProtocolHelpers(names []string) error {
+
+	if err := ValidateProtocolHelpers(names); err != nil {
+		return err
+	}
+
+	helpers := map[uint16]ProtocolHelper{}
+	for _, name := range names {
+		h := protocolHelpers[name]()
+		helpers[h.Port()] = h
+	}
+
+	d.protocolHelpers.Store(helpers)
+
+	return nil
+}
+
+// protocolHelper returns the helper of the control connections with the
+// given server port, or nil.
+func (d *Datapath) protocolHelper(port uint16) ProtocolHelper {
+
+	helpers, _ := d.protocolHelpers.Load().(map[uint16]ProtocolHelper)
+
+	return helpers[port]
+}
+
+// holdControlFlow keeps an accepted external connection in the datapath if
+// it is a control connection of a protocol helper. It returns false if the
+// flow should be released.
+func (d *Datapath) holdControlFlow(context *pucontext.PUContext, conn *connection.TCPConnection, report *policy.FlowPolicy, action *policy.FlowPolicy, tcpPacket *packet.Packet) bool {
+
+	if d.protocolHelper(tcpPacket.SourcePort) == nil {
+		return false
+	}
+
+	if err := d.sourcePortConnectionCache.Remove(tcpPacket.SourcePortHash(packet.PacketTypeApplication)); err != nil {
+		logging.Named("datapath").Debug("Failed to clean cache sourcePortConnectionCache", zap.Error(err))
+	}
+
+	// The connection stays in the application cache, and is tracked for the
+	// packets of the server.
+	conn.SetState(connection.TCPData)
+	d.netReplyConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+
+	d.reportReverseExternalServiceFlow(context, report, action, true, tcpPacket)
+
+	return true
+}
+
+// inspectControlFlow accepts the related connections that the server
+// announces on a control connection, and removes their rules when the
+// connection is closed.
+func (d *Datapath) inspectControlFlow(context *pucontext.PUContext, tcpPacket *packet.Packet) {
+
+	h := d.protocolHelper(tcpPacket.SourcePort)
+	if h == nil {
+		return
+	}
+
+	owner := tcpPacket.L4FlowHash()
+
+	if tcpPacket.TCPFlags&(packet.TCPFinMask|packet.TCPRstMask) != 0 {
+		context.RemoveRelatedRules(owner)
+		return
+	}
+
+	for _, flow := range h.RelatedFlows(tcpPacket.SourceAddress, tcpPacket.ReadTCPData()) {
+		context.AddRelatedRule(owner, flow.Address, flow.Port, "TCP")
+	}
+}
+
+// closeControlFlow removes the rules of the related connections when the
+// application closes a control connection.
+func (d *Datapath) closeControlFlow(context *pucontext.PUContext, tcpPacket *packet.Packet) {
+
+	if d.protocolHelper(tcpPacket.DestinationPort) == nil {
+		return
+	}
+
+	if tcpPacket.TCPFlags&(packet.TCPFinMask|packet.TCPRstMask) != 0 {
+		context.RemoveRelatedRules(tcpPacket.L4ReverseFlowHash())
+	}
+}
+
+// ftpPassiveMode matches the reply of an FTP server to the PASV command,
+// such as 227 Entering Passive Mode (10,1,1,1,78,52).
+var ftpPassiveMode = regexp.MustCompile(`^227 .*?\(?(\d{1,3}),(\d{1,3}),(\d{1,3}),(\d{1,3}),(\d{1,3}),(\d{1,3})\)?`)
+
+// ftpHelper is the protocol helper of FTP. It accepts the data connections
+// of the passive mode.
+type ftpHelper struct{}
+
+func (h *ftpHelper) Port() uint16 {
+	return 21
+}
+
+// RelatedFlows returns the data connections of the replies to PASV. The
+// connections to other addresses than the server are ignored, so that the
+// server cannot open the ACLs to other destinations.
+func (h *ftpHelper) RelatedFlows(server net.IP, data []byte) []RelatedFlow {
+
+	flows := []RelatedFlow{}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		m := ftpPassiveMode.FindSubmatch(bytes.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+
+		values := make([]byte, 6)
+		valid := true
+		for i := range values {
+			v, err := strconv.Atoi(string(m[i+1]))
+			if err != nil || v > 255 {
+				valid = false
+				break
+			}
+			values[i] = byte(v)
+		}
+		if !valid {
+			continue
+		}
+
+		addr := net.IPv4(values[0], values[1], values[2], values[3])
+		if !addr.Equal(server) {
+			zap.L().Debug("Ignoring ftp data connection to another address",
+				zap.String("server", server.String()),
+				zap.String("address", addr.String()),
+			)
+			continue
+		}
+
+		flows = append(flows, RelatedFlow{
+			Address: addr.To4(),
+			Port:    uint16(values[4])<<8 | uint16(values[5]),
+		})
+	}
+
+	return flows
+}
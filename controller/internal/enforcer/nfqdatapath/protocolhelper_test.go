@@ -0,0 +1,157 @@
+package nfqdatapath
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector/mockcollector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func newTCPTestPacket(src, dst string, sport, dport uint16, flags uint8, payload []byte) (*packet.Packet, error) {
+
+	buffer := make([]byte, 40+len(payload))
+	buffer[0] = 0x45
+	binary.BigEndian.PutUint16(buffer[2:4], uint16(len(buffer)))
+	buffer[8] = 64
+	buffer[9] = packet.IPProtocolTCP
+	copy(buffer[12:16], net.ParseIP(src).To4())
+	copy(buffer[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(buffer[20:22], sport)
+	binary.BigEndian.PutUint16(buffer[22:24], dport)
+	buffer[32] = 0x50
+	buffer[33] = flags
+	copy(buffer[40:], payload)
+
+	return packet.New(packet.PacketTypeNetwork, buffer, "0", true)
+}
+
+func TestFTPHelper(t *testing.T) {
+
+	Convey("Given the ftp helper", t, func() {
+		h := &ftpHelper{}
+		server := net.ParseIP("52.1.1.1")
+
+		Convey("The data connection of a PASV reply should be returned", func() {
+			flows := h.RelatedFlows(server, []byte("227 Entering Passive Mode (52,1,1,1,78,52).\r\n"))
+			So(len(flows), ShouldEqual, 1)
+			So(flows[0].Address.String(), ShouldEqual, "52.1.1.1")
+			So(flows[0].Port, ShouldEqual, 20020)
+		})
+
+		Convey("The PASV replies without parentheses should be parsed", func() {
+			flows := h.RelatedFlows(server, []byte("220 Welcome\r\n227 =52,1,1,1,0,21\r\n"))
+			So(len(flows), ShouldEqual, 1)
+			So(flows[0].Port, ShouldEqual, 21)
+		})
+
+		Convey("The data connections to other addresses should be ignored", func() {
+			flows := h.RelatedFlows(server, []byte("227 Entering Passive Mode (10,1,1,1,78,52)\r\n"))
+			So(flows, ShouldBeEmpty)
+		})
+
+		Convey("The invalid replies should be ignored", func() {
+			So(h.RelatedFlows(server, []byte("227 Entering Passive Mode (52,1,1,1,300,52)\r\n")), ShouldBeEmpty)
+			So(h.RelatedFlows(server, []byte("150 Opening data connection (52,1,1,1,78,52)\r\n")), ShouldBeEmpty)
+			So(h.RelatedFlows(server, nil), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestProtocolHelpers(t *testing.T) {
+
+	Convey("Given a datapath with the ftp helper", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockCollector := mockcollector.NewMockEventCollector(ctrl)
+		mockCollector.EXPECT().CollectFlowEvent(gomock.Any()).AnyTimes()
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		d := NewWithDefaults("SomeServerId", mockCollector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		So(d.SetProtocolHelpers([]string{"ftp"}), ShouldBeNil)
+
+		context, err := pucontext.NewPU("pu", policy.NewPUInfo("pu", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		conn := connection.NewTCPConnection(context)
+		conn.SetState(connection.TCPData)
+
+		server := net.ParseIP("52.1.1.1").To4()
+		pasv, err := newTCPTestPacket("52.1.1.1", "10.1.1.1", 21, 40000, packet.TCPAckMask, []byte("227 Entering Passive Mode (52,1,1,1,78,52)\r\n"))
+		So(err, ShouldBeNil)
+
+		Convey("Unknown helpers should be rejected", func() {
+			So(d.SetProtocolHelpers([]string{"ftp", "sip"}), ShouldNotBeNil)
+		})
+
+		Convey("The accepted control connections should be kept in the datapath", func() {
+			synAck, err := newTCPTestPacket("52.1.1.1", "10.1.1.1", 21, 40000, packet.TCPSynAckMask, nil)
+			So(err, ShouldBeNil)
+			accept := &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept"}
+			So(d.holdControlFlow(context, conn, accept, accept, synAck), ShouldBeTrue)
+
+			_, err = d.netReplyConnectionTracker.Get(synAck.L4FlowHash())
+			So(err, ShouldBeNil)
+
+			other, err := newTCPTestPacket("52.1.1.1", "10.1.1.1", 443, 40001, packet.TCPSynAckMask, nil)
+			So(err, ShouldBeNil)
+			So(d.holdControlFlow(context, conn, accept, accept, other), ShouldBeFalse)
+		})
+
+		Convey("When the server replies to PASV on the control connection", func() {
+			_, _, err := d.processNetworkAckPacket(context, conn, pasv)
+			So(err, ShouldBeNil)
+
+			Convey("Then the data connection should be accepted", func() {
+				report, action, err := d.applicationACLPolicyFromAddr(context, server, 20020, 40002, "TCP")
+				So(err, ShouldBeNil)
+				So(report.Action.Accepted(), ShouldBeTrue)
+				So(action.Action.Accepted(), ShouldBeTrue)
+				So(len(context.State().RelatedRules), ShouldEqual, 1)
+
+				_, _, err = d.applicationACLPolicyFromAddr(context, server, 20021, 40002, "TCP")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the rule should be removed when the server closes the control connection", func() {
+				fin, err := newTCPTestPacket("52.1.1.1", "10.1.1.1", 21, 40000, packet.TCPAckMask|packet.TCPFinMask, nil)
+				So(err, ShouldBeNil)
+				_, _, err = d.processNetworkAckPacket(context, conn, fin)
+				So(err, ShouldBeNil)
+
+				_, _, err = d.applicationACLPolicyFromAddr(context, server, 20020, 40002, "TCP")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the rule should be removed when the application closes the control connection", func() {
+				fin, err := newTCPTestPacket("10.1.1.1", "52.1.1.1", 40000, 21, packet.TCPAckMask|packet.TCPFinMask, nil)
+				So(err, ShouldBeNil)
+				_, err = d.processApplicationAckPacket(fin, context, conn)
+				So(err, ShouldBeNil)
+
+				_, _, err = d.applicationACLPolicyFromAddr(context, server, 20020, 40002, "TCP")
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the helper is disabled, the data connection should not be accepted", func() {
+			So(d.SetProtocolHelpers(nil), ShouldBeNil)
+			_, _, err := d.processNetworkAckPacket(context, conn, pasv)
+			So(err, ShouldBeNil)
+
+			_, _, err = d.applicationACLPolicyFromAddr(context, server, 20020, 40002, "TCP")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,198 @@
+package nfqdatapath
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/cache"
+	"go.uber.org/zap"
+)
+
+const (
+	// reverseDNSTTL is the time the name of an address is cached. The
+	// addresses that could not be resolved are cached too.
+	reverseDNSTTL = 10 * time.Minute
+	// reverseDNSTimeout is the timeout of a lookup
+	reverseDNSTimeout = 5 * time.Second
+	// reverseDNSLookupsPerSecond is the maximum number of lookups started
+	// every second
+	reverseDNSLookupsPerSecond = 20
+)
+
+// ReverseResolver resolves the names of an address and the addresses of a
+// name, as net.Resolver.
+type ReverseResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// reverseDNS resolves the names of the external addresses in the background.
+type reverseDNS struct {
+	resolver    ReverseResolver
+	matchPolicy bool
+	// names holds the names of the addresses. The addresses that are being
+	// resolved or could not be resolved have an empty name.
+	names cache.DataStore
+	// window and lookups rate limit the lookups
+	window  time.Time
+	lookups int
+	sync.Mutex
+}
+
+// SetReverseDNS enables the reverse DNS lookups of the external addresses
+// with the given resolver. Only the names that resolve back to the address
+// are used, since anybody controlling the reverse zone of an address can
+// choose its names. The accepted external flows are reported with the
+// name of the external address as a tag. If matchPolicy is set, the name is
+// also matched against the DNS rules of domains of the PU, such as
+// *.amazonaws.com, for the destinations that no ACL matches. The lookups are
+// started in the background, so the name is only used for the flows after
+// the first flow to an address. A nil resolver disables the lookups, which
+// is the default.
+func (d *Datapath) SetReverseDNS(resolver ReverseResolver, matchPolicy bool) {
+
+	if resolver == nil {
+		d.reverseDNS.Store((*reverseDNS)(nil))
+		return
+	}
+
+	d.reverseDNS.Store(&reverseDNS{
+		resolver:    resolver,
+		matchPolicy: matchPolicy,
+		names:       cache.NewCacheWithExpiration("reverseDNS", reverseDNSTTL),
+	})
+}
+
+// reverseDNSName returns the cached name of an address. The lookup of the
+// address is started if it is not cached.
+func (d *Datapath) reverseDNSName(ip net.IP) (string, bool) {
+
+	r, _ := d.reverseDNS.Load().(*reverseDNS)
+	if r == nil {
+		return "", false
+	}
+
+	return r.name(ip.String())
+}
+
+// name returns the name of an address if it is cached, and starts its
+// lookup otherwise.
+func (r *reverseDNS) name(addr string) (string, bool) {
+
+	if item, err := r.names.Get(addr); err == nil {
+		name := item.(string)
+		return name, name != ""
+	}
+
+	if !r.allow(time.Now()) {
+		return "", false
+	}
+
+	// The address is cached while it is resolved, so that it is only
+	// resolved once.
+	if err := r.names.Add(addr, ""); err != nil {
+		return "", false
+	}
+
+	go r.lookup(addr)
+
+	return "", false
+}
+
+// allow returns true if another lookup can be started in the current
+// second.
+func (r *reverseDNS) allow(now time.Time) bool {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if now.Sub(r.window) >= time.Second {
+		r.window = now
+		r.lookups = 0
+	}
+
+	if r.lookups >= reverseDNSLookupsPerSecond {
+		return false
+	}
+
+	r.lookups++
+	return true
+}
+
+// lookup resolves an address and caches its first name that resolves back
+// to the address.
+func (r *reverseDNS) lookup(addr string) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), reverseDNSTimeout)
+	defer cancel()
+
+	names, err := r.resolver.LookupAddr(ctx, addr)
+	if err != nil || len(names) == 0 {
+		zap.L().Debug("Unable to resolve the name of the address", zap.String("address", addr), zap.Error(err))
+		return
+	}
+
+	for _, name := range names {
+		if r.confirmed(ctx, name, addr) {
+			r.names.AddOrUpdate(addr, strings.TrimSuffix(name, "."))
+			return
+		}
+	}
+
+	zap.L().Debug("No name of the address resolves back to it", zap.String("address", addr), zap.Strings("names", names))
+}
+
+// confirmed returns true if the name resolves to the address.
+func (r *reverseDNS) confirmed(ctx context.Context, name string, addr string) bool {
+
+	addrs, err := r.resolver.LookupHost(ctx, name)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(addr)
+	for _, a := range addrs {
+		if ip.Equal(net.ParseIP(a)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applicationACLPolicyFromAddr returns the policy of the application ACLs of
+// the PU for an external address. If no ACL matches, the related connections
+// announced on control connections are accepted, and the name of the address
+// is matched against the DNS rules of domains of the PU when it is enabled.
+func (d *Datapath) applicationACLPolicyFromAddr(context *pucontext.PUContext, addr net.IP, port uint16, sourcePort uint16, protocol string) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+
+	report, action, err = context.ApplicationACLPolicyFromAddr(addr, port, sourcePort)
+	if err == nil {
+		return report, action, nil
+	}
+
+	if plc, rerr := context.RelatedACLPolicyFromAddr(addr, port, protocol); rerr == nil {
+		return plc, plc, nil
+	}
+
+	if r, _ := d.reverseDNS.Load().(*reverseDNS); r == nil || !r.matchPolicy {
+		return report, action, err
+	}
+
+	name, ok := d.reverseDNSName(addr)
+	if !ok {
+		return report, action, err
+	}
+
+	plc, perr := context.ApplicationACLPolicyFromName(name, port, protocol)
+	if perr != nil {
+		return report, action, err
+	}
+
+	return plc, plc, nil
+}
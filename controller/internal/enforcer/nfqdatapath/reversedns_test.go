@@ -0,0 +1,215 @@
+package nfqdatapath
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/collector/mockcollector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+type fakeReverseResolver struct {
+	sync.Mutex
+	names     map[string]string
+	addresses map[string][]string
+	lookups   []string
+}
+
+func (r *fakeReverseResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.lookups = append(r.lookups, addr)
+	name, ok := r.names[addr]
+	if !ok {
+		return nil, fmt.Errorf("no name for %s", addr)
+	}
+	return []string{name}, nil
+}
+
+func (r *fakeReverseResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	addrs, ok := r.addresses[host]
+	if !ok {
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+	return addrs, nil
+}
+
+func (r *fakeReverseResolver) lookupCount() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.lookups)
+}
+
+// waitReverseDNSName waits for the lookup of an address started by a
+// previous call to complete.
+func waitReverseDNSName(d *Datapath, ip net.IP) (string, bool) {
+
+	for i := 0; i < 100; i++ {
+		if name, ok := d.reverseDNSName(ip); ok {
+			return name, ok
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return "", false
+}
+
+func TestReverseDNS(t *testing.T) {
+
+	Convey("Given a datapath with reverse DNS lookups", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockCollector := mockcollector.NewMockEventCollector(ctrl)
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		d := NewWithDefaults("SomeServerId", mockCollector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		resolver := &fakeReverseResolver{
+			names: map[string]string{
+				"52.1.1.1": "ec2-52-1-1-1.compute-1.amazonaws.com.",
+				"52.4.4.4": "spoofed.amazonaws.com.",
+			},
+			addresses: map[string][]string{
+				"ec2-52-1-1-1.compute-1.amazonaws.com.": {"52.1.1.1"},
+				"spoofed.amazonaws.com.":                {"52.5.5.5"},
+			},
+		}
+		d.SetReverseDNS(resolver, true)
+
+		puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+		puInfo.Policy.UpdateDNSNetworks(policy.DNSRuleList{
+			{Name: "*.amazonaws.com", Port: "443"},
+		})
+		context, err := pucontext.NewPU("pu", puInfo, 10*time.Second)
+		So(err, ShouldBeNil)
+
+		Convey("The name should only be returned once it is resolved", func() {
+			ip := net.ParseIP("52.1.1.1")
+			_, ok := d.reverseDNSName(ip)
+			So(ok, ShouldBeFalse)
+
+			name, ok := waitReverseDNSName(d, ip)
+			So(ok, ShouldBeTrue)
+			So(name, ShouldEqual, "ec2-52-1-1-1.compute-1.amazonaws.com")
+			So(resolver.lookupCount(), ShouldEqual, 1)
+		})
+
+		Convey("The addresses that cannot be resolved should not be resolved again", func() {
+			ip := net.ParseIP("52.2.2.2")
+			_, ok := d.reverseDNSName(ip)
+			So(ok, ShouldBeFalse)
+			_, ok = waitReverseDNSName(d, ip)
+			So(ok, ShouldBeFalse)
+			So(resolver.lookupCount(), ShouldEqual, 1)
+		})
+
+		Convey("The names that do not resolve back to the address should not be used", func() {
+			ip := net.ParseIP("52.4.4.4").To4()
+			d.reverseDNSName(ip)
+			_, ok := waitReverseDNSName(d, ip)
+			So(ok, ShouldBeFalse)
+
+			_, _, err := d.applicationACLPolicyFromAddr(context, ip, 443, 5000, "TCP")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The lookups should be rate limited", func() {
+			for i := 0; i < 2*reverseDNSLookupsPerSecond; i++ {
+				d.reverseDNSName(net.IPv4(52, 3, 3, byte(i)))
+			}
+			time.Sleep(100 * time.Millisecond)
+			So(resolver.lookupCount(), ShouldEqual, reverseDNSLookupsPerSecond)
+		})
+
+		Convey("The accepted external flows should be tagged with the name", func() {
+			p, err := newUDPTestPacket("10.1.1.1", "52.1.1.1", 5000, 443, nil)
+			So(err, ShouldBeNil)
+			_, ok := waitReverseDNSName(d, p.DestinationAddress)
+			So(ok, ShouldBeTrue)
+
+			var record *collector.FlowRecord
+			mockCollector.EXPECT().CollectFlowEvent(gomock.Any()).Do(func(r *collector.FlowRecord) {
+				record = r
+			}).Times(1)
+
+			accept := &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept"}
+			d.reportExternalServiceFlowCommon(context, accept, accept, true, p, &collector.EndPoint{IP: "10.1.1.1"}, &collector.EndPoint{IP: "52.1.1.1"})
+			So(record, ShouldNotBeNil)
+			name, ok := record.Tags.Get(enforcerconstants.ReverseDNSLabelString)
+			So(ok, ShouldBeTrue)
+			So(name, ShouldEqual, "ec2-52-1-1-1.compute-1.amazonaws.com")
+			_, ok = context.Annotations().Get(enforcerconstants.ReverseDNSLabelString)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("The rejected external flows should not be tagged", func() {
+			p, err := newUDPTestPacket("10.1.1.1", "52.1.1.1", 5000, 443, nil)
+			So(err, ShouldBeNil)
+			_, ok := waitReverseDNSName(d, p.DestinationAddress)
+			So(ok, ShouldBeTrue)
+
+			var record *collector.FlowRecord
+			mockCollector.EXPECT().CollectFlowEvent(gomock.Any()).Do(func(r *collector.FlowRecord) {
+				record = r
+			}).Times(1)
+
+			reject := &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"}
+			d.reportExternalServiceFlowCommon(context, reject, reject, true, p, &collector.EndPoint{IP: "10.1.1.1"}, &collector.EndPoint{IP: "52.1.1.1"})
+			So(record, ShouldNotBeNil)
+			_, ok = record.Tags.Get(enforcerconstants.ReverseDNSLabelString)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("The name should be matched against the DNS rules of domains", func() {
+			ip := net.ParseIP("52.1.1.1").To4()
+			_, _, err := d.applicationACLPolicyFromAddr(context, ip, 443, 5000, "TCP")
+			So(err, ShouldNotBeNil)
+			_, ok := waitReverseDNSName(d, ip)
+			So(ok, ShouldBeTrue)
+
+			report, action, err := d.applicationACLPolicyFromAddr(context, ip, 443, 5000, "TCP")
+			So(err, ShouldBeNil)
+			So(report.Action.Accepted(), ShouldBeTrue)
+			So(action.Action.Accepted(), ShouldBeTrue)
+
+			_, _, err = d.applicationACLPolicyFromAddr(context, ip, 80, 5000, "TCP")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The name should not be matched against the DNS rules if it is not enabled", func() {
+			d.SetReverseDNS(resolver, false)
+			ip := net.ParseIP("52.1.1.1").To4()
+			d.reverseDNSName(ip)
+			_, ok := waitReverseDNSName(d, ip)
+			So(ok, ShouldBeTrue)
+
+			_, _, err := d.applicationACLPolicyFromAddr(context, ip, 443, 5000, "TCP")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("No lookup should be started when it is disabled", func() {
+			d.SetReverseDNS(nil, false)
+			_, ok := d.reverseDNSName(net.ParseIP("52.1.1.1"))
+			So(ok, ShouldBeFalse)
+			time.Sleep(50 * time.Millisecond)
+			So(resolver.lookupCount(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,348 @@
+package nfqdatapath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/cache"
+	"go.aporeto.io/trireme-lib/utils/logging"
+	"go.uber.org/zap"
+)
+
+// markedFlowLifetime is the time after which a flow released to the kernel
+// is not revoked anymore. It is the default timeout of the established TCP
+// flows of conntrack, after which their entry is gone.
+const markedFlowLifetime = 5 * 24 * time.Hour
+
+// revokedFlowLifetime is the time after the last dropped packet after which
+// a revoked flow is forgotten.
+const revokedFlowLifetime = 5 * time.Minute
+
+// markedFlow is a flow between PUs that was released to the kernel. The
+// conntrack tuple is kept in the order it was marked with.
+type markedFlow struct {
+	contextID  string
+	remoteTags *policy.TagStore
+	receiver   bool
+	conntrack  *flowTuple
+}
+
+// recordMarkedFlow keeps a flow between PUs that was released to the kernel,
+// so that the policy updates can revoke it for as long as it lives. The flows
+// are indexed by their hash as seen from the network.
+func (d *Datapath) recordMarkedFlow(context *pucontext.PUContext, remoteTags *policy.TagStore, receiver bool, netHash string, ipSrc, ipDst string, protonum uint8, srcport, dstport uint16) {
+
+	if remoteTags == nil {
+		return
+	}
+
+	d.markedFlows.AddOrUpdate(netHash, &markedFlow{
+		contextID:  context.ID(),
+		remoteTags: remoteTags,
+		receiver:   receiver,
+		conntrack: &flowTuple{
+			protocol:        protonum,
+			source:          ipSrc,
+			destination:     ipDst,
+			sourcePort:      srcport,
+			destinationPort: dstport,
+		},
+	})
+}
+
+// forgetMarkedFlows removes the flows of a PU from the index of the flows
+// released to the kernel.
+func (d *Datapath) forgetMarkedFlows(contextID string) {
+
+	for _, key := range d.markedFlows.KeyList() {
+		item, err := d.markedFlows.Get(key)
+		if err != nil {
+			continue
+		}
+
+		if item.(*markedFlow).contextID == contextID {
+			d.markedFlows.Remove(key) // nolint
+		}
+	}
+}
+
+// revokeDeniedFlows evaluates the flows of a PU against the policy of its
+// new context. The flows that are now denied are removed from the trackers
+// and their conntrack mark is cleared, so that their next packets are
+// processed by the datapath again and dropped.
+func (d *Datapath) revokeDeniedFlows(pu *pucontext.PUContext) {
+
+	// The flows that are still tracked, including the ones that were not
+	// released to the kernel yet.
+	for _, tracker := range []struct {
+		flows    cache.DataStore
+		receiver bool
+	}{
+		{d.netOrigConnectionTracker, true},
+		{d.netReplyConnectionTracker, false},
+		{d.udpNetOrigConnectionTracker, true},
+		{d.udpNetReplyConnectionTracker, false},
+	} {
+		for _, key := range tracker.flows.KeyList() {
+			hash, ok := key.(string)
+			if !ok {
+				continue
+			}
+
+			item, err := tracker.flows.Get(hash)
+			if err != nil {
+				continue
+			}
+
+			switch conn := item.(type) {
+			case *connection.TCPConnection:
+				d.revokeTCPFlow(pu, conn, hash, tracker.receiver)
+			case *connection.UDPConnection:
+				d.revokeUDPFlow(pu, conn, hash, tracker.receiver)
+			}
+		}
+	}
+
+	// The flows that were released to the kernel and are not tracked
+	// anymore.
+	for _, key := range d.markedFlows.KeyList() {
+		hash, ok := key.(string)
+		if !ok {
+			continue
+		}
+
+		item, err := d.markedFlows.Get(hash)
+		if err != nil {
+			continue
+		}
+
+		flow := item.(*markedFlow)
+		if flow.contextID != pu.ID() || !d.flowDenied(pu, flow.remoteTags, flow.receiver) {
+			continue
+		}
+
+		d.revokeFlow(pu, hash, true)
+	}
+}
+
+// revokeTCPFlow tears down a TCP flow of the PU if the policy denies it.
+func (d *Datapath) revokeTCPFlow(pu *pucontext.PUContext, conn *connection.TCPConnection, netHash string, receiver bool) {
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	if conn.Context == nil || conn.Context.ID() != pu.ID() || !d.flowDenied(pu, conn.RemoteTags, receiver) {
+		return
+	}
+
+	appHash := reverseFlowHash(netHash)
+	d.netOrigConnectionTracker.Remove(netHash)  // nolint
+	d.netReplyConnectionTracker.Remove(netHash) // nolint
+	d.appOrigConnectionTracker.Remove(appHash)  // nolint
+	d.appReplyConnectionTracker.Remove(appHash) // nolint
+
+	d.revokeFlow(pu, netHash, !conn.ServiceConnection)
+}
+
+// revokeUDPFlow tears down a UDP flow of the PU if the policy denies it.
+func (d *Datapath) revokeUDPFlow(pu *pucontext.PUContext, conn *connection.UDPConnection, netHash string, receiver bool) {
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	if conn.Context == nil || conn.Context.ID() != pu.ID() || !d.flowDenied(pu, conn.RemoteTags, receiver) {
+		return
+	}
+
+	d.releaseUDPConnection(conn, netHash, reverseFlowHash(netHash))
+
+	d.revokeFlow(pu, netHash, !conn.ServiceConnection)
+}
+
+// revokeFlow clears the conntrack mark of a denied flow if it was released to
+// the kernel, and remembers the flow so that its packets keep being dropped.
+func (d *Datapath) revokeFlow(pu *pucontext.PUContext, netHash string, clearMark bool) {
+
+	if clearMark {
+		d.clearFlowMark(netHash)
+	}
+	d.markedFlows.Remove(netHash) // nolint
+
+	d.revokedFlows.AddOrUpdate(netHash, true)
+	d.revokedFlows.AddOrUpdate(reverseFlowHash(netHash), true)
+
+	logging.Named("datapath").Info("Revoked flow denied by the updated policy",
+		zap.String("contextID", pu.ID()),
+		zap.String("flow", netHash),
+	)
+}
+
+// dropRevokedFlow returns true if the packet belongs to a flow that was
+// revoked. The packets of a revoked flow are dropped before they reach the
+// connection state, so that the flow is not accepted again. A new TCP
+// connection with the tuple of a revoked flow is processed again.
+func (d *Datapath) dropRevokedFlow(p *packet.Packet) bool {
+
+	hash := p.L4FlowHash()
+	if _, err := d.revokedFlows.GetReset(hash, 0); err != nil {
+		return false
+	}
+
+	if p.IPProto == packet.IPProtocolTCP && p.TCPFlags&packet.TCPSynAckMask == packet.TCPSynMask {
+		d.revokedFlows.Remove(hash)                  // nolint
+		d.revokedFlows.Remove(p.L4ReverseFlowHash()) // nolint
+		return false
+	}
+
+	return true
+}
+
+// flowDenied returns true if the policy of the PU denies a flow with the
+// remote PU of the tags. The flows whose remote tags are unknown, such as the
+// flows with external networks, are not evaluated.
+func (d *Datapath) flowDenied(pu *pucontext.PUContext, tags *policy.TagStore, receiver bool) bool {
+
+	if tags == nil {
+		return false
+	}
+
+	if receiver {
+		_, pkt := pu.SearchRcvRules(tags)
+		return pkt.Action.Rejected()
+	}
+
+	_, pkt := pu.SearchTxtRules(tags, !pu.MutualAuthorization(d.mutualAuthorization))
+	return pkt.Action.Rejected()
+}
+
+// clearFlowMark clears the conntrack mark of a flow seen from the network.
+// The tuple the flow was marked with is used if it is known.
+func (d *Datapath) clearFlowMark(netHash string) {
+
+	var flow *flowTuple
+	if item, err := d.markedFlows.Get(netHash); err == nil {
+		flow = item.(*markedFlow).conntrack
+	} else {
+		reply, err := parseFlowHash(reverseFlowHash(netHash))
+		if err != nil {
+			return
+		}
+		flow = reply
+	}
+
+	if err := d.updateConntrackMark(
+		flow.sourceIP(),
+		flow.destinationIP(),
+		flow.protocol,
+		flow.sourcePort,
+		flow.destinationPort,
+		0,
+	); err != nil {
+		logging.Named("datapath").Warn("Failed to clear conntrack mark of revoked flow",
+			zap.String("flow", netHash),
+			zap.Error(err),
+		)
+	}
+}
+
+// flowTuple is the 5-tuple of a flow hash. The addresses are kept as they
+// appear in the hash, with the IPv6 addresses in brackets.
+type flowTuple struct {
+	protocol        uint8
+	source          string
+	destination     string
+	sourcePort      uint16
+	destinationPort uint16
+}
+
+// parseFlowHash parses a hash returned by L4FlowHash.
+func parseFlowHash(hash string) (*flowTuple, error) {
+
+	parts := strings.SplitN(hash, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid flow hash %s", hash)
+	}
+
+	protocol, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protocol in flow hash %s: %s", hash, err)
+	}
+
+	// The ports are the last fields of the hash.
+	fields := []string{}
+	rest := parts[1]
+	for i := 0; i < 2; i++ {
+		separator := strings.LastIndex(rest, ":")
+		if separator < 0 {
+			return nil, fmt.Errorf("invalid flow hash %s", hash)
+		}
+		fields = append(fields, rest[separator+1:])
+		rest = rest[:separator]
+	}
+
+	destinationPort, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination port in flow hash %s: %s", hash, err)
+	}
+
+	sourcePort, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port in flow hash %s: %s", hash, err)
+	}
+
+	// The addresses are separated by the first colon that is not in brackets.
+	addresses := rest
+	separator := strings.Index(addresses, ":")
+	if strings.HasPrefix(addresses, "[") {
+		separator = strings.Index(addresses, "]:") + 1
+	}
+	if separator <= 0 {
+		return nil, fmt.Errorf("invalid addresses in flow hash %s", hash)
+	}
+
+	return &flowTuple{
+		protocol:        uint8(protocol),
+		source:          addresses[:separator],
+		destination:     addresses[separator+1:],
+		sourcePort:      uint16(sourcePort),
+		destinationPort: uint16(destinationPort),
+	}, nil
+}
+
+// hash returns the hash of the flow.
+func (f *flowTuple) hash() string {
+	return strconv.Itoa(int(f.protocol)) + ":" + f.source + ":" + f.destination + ":" + strconv.Itoa(int(f.sourcePort)) + ":" + strconv.Itoa(int(f.destinationPort))
+}
+
+// reverseFlowHash returns the hash of the flow of a hash in the other
+// direction. The hash is returned unchanged if it is invalid.
+func reverseFlowHash(hash string) string {
+
+	flow, err := parseFlowHash(hash)
+	if err != nil {
+		return hash
+	}
+
+	return flow.reverseHash()
+}
+
+// reverseHash returns the hash of the flow in the other direction.
+func (f *flowTuple) reverseHash() string {
+	return strconv.Itoa(int(f.protocol)) + ":" + f.destination + ":" + f.source + ":" + strconv.Itoa(int(f.destinationPort)) + ":" + strconv.Itoa(int(f.sourcePort))
+}
+
+// sourceIP returns the source address without brackets.
+func (f *flowTuple) sourceIP() string {
+	return strings.Trim(f.source, "[]")
+}
+
+// destinationIP returns the destination address without brackets.
+func (f *flowTuple) destinationIP() string {
+	return strings.Trim(f.destination, "[]")
+}
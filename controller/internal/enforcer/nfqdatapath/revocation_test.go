@@ -0,0 +1,222 @@
+package nfqdatapath
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func revocationTestPUInfo(allowed bool, revoke bool) *policy.PUInfo {
+
+	puInfo := policy.NewPUInfo("server", common.ContainerPU)
+	if allowed {
+		puInfo.Policy.AddReceiverRules(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{
+					Key:      "app",
+					Value:    []string{"client"},
+					Operator: policy.Equal,
+				},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "allowed"},
+		})
+	}
+	puInfo.Policy.SetRevokeDeniedFlows(revoke)
+
+	return puInfo
+}
+
+func TestRevokeDeniedFlows(t *testing.T) {
+
+	Convey("Given I have an enforcer with accepted TCP and UDP flows", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		writer := &capturingSocketWriter{}
+
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		conntrack := &capturingConntrack{}
+		enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		enforcer.conntrackHdl = conntrack
+
+		So(enforcer.Enforce("server", revocationTestPUInfo(true, false)), ShouldBeNil)
+		item, err := enforcer.puFromContextID.Get("server")
+		So(err, ShouldBeNil)
+		context := item.(*pucontext.PUContext)
+
+		tags := policy.NewTagStoreFromSlice([]string{"app=client"})
+
+		tcpConn := connection.NewTCPConnection(context)
+		tcpConn.RemoteTags = tags
+		tcpConn.SetState(connection.TCPData)
+		enforcer.netOrigConnectionTracker.AddOrUpdate("6:10.1.1.1:10.1.1.2:5000:80", tcpConn)
+		enforcer.appReplyConnectionTracker.AddOrUpdate("6:10.1.1.2:10.1.1.1:80:5000", tcpConn)
+
+		udpConn := connection.NewUDPConnection(context, writer)
+		udpConn.RemoteTags = tags
+		udpConn.SetState(connection.UDPData)
+		enforcer.udpNetOrigConnectionTracker.AddOrUpdate("17:10.1.1.1:10.1.1.2:5000:53", udpConn)
+		enforcer.udpAppReplyConnectionTracker.AddOrUpdate("17:10.1.1.2:10.1.1.1:53:5000", udpConn)
+
+		// A flow that was released to the kernel long ago and is not tracked
+		// anymore.
+		enforcer.recordMarkedFlow(context, tags, true, "6:10.1.1.1:10.1.1.2:6000:80", "10.1.1.2", "10.1.1.1", 6, 80, 6000)
+
+		Convey("When the policy that denies them is enforced with the revocation enabled", func() {
+			So(enforcer.Enforce("server", revocationTestPUInfo(false, true)), ShouldBeNil)
+
+			Convey("Then the flows should be removed from the trackers", func() {
+				_, err := enforcer.netOrigConnectionTracker.Get("6:10.1.1.1:10.1.1.2:5000:80")
+				So(err, ShouldNotBeNil)
+				_, err = enforcer.appReplyConnectionTracker.Get("6:10.1.1.2:10.1.1.1:80:5000")
+				So(err, ShouldNotBeNil)
+				_, err = enforcer.udpNetOrigConnectionTracker.Get("17:10.1.1.1:10.1.1.2:5000:53")
+				So(err, ShouldNotBeNil)
+				_, err = enforcer.udpAppReplyConnectionTracker.Get("17:10.1.1.2:10.1.1.1:53:5000")
+				So(err, ShouldNotBeNil)
+				So(udpConn.GetState(), ShouldEqual, connection.UDPClosed)
+			})
+
+			Convey("Then the conntrack marks of the flows should be cleared", func() {
+				So(conntrack.calls(), ShouldContain, conntrackUpdate{ipSrc: "10.1.1.2", ipDst: "10.1.1.1", protonum: 6, srcport: 80, dstport: 5000, newmark: 0})
+				So(conntrack.calls(), ShouldContain, conntrackUpdate{ipSrc: "10.1.1.2", ipDst: "10.1.1.1", protonum: 17, srcport: 53, dstport: 5000, newmark: 0})
+			})
+
+			Convey("Then the flow that is not tracked anymore should be revoked with the tuple it was marked with", func() {
+				So(conntrack.calls(), ShouldContain, conntrackUpdate{ipSrc: "10.1.1.2", ipDst: "10.1.1.1", protonum: 6, srcport: 80, dstport: 6000, newmark: 0})
+				_, err := enforcer.markedFlows.Get("6:10.1.1.1:10.1.1.2:6000:80")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the next packets of the flows should be dropped without marking them again", func() {
+				conntrack = &capturingConntrack{}
+				enforcer.conntrackHdl = conntrack
+
+				ack, err := newTCPTestPacket("10.1.1.1", "10.1.1.2", 6000, 80, packet.TCPAckMask, nil)
+				So(err, ShouldBeNil)
+				So(enforcer.processNetworkTCPPackets(ack), ShouldNotBeNil)
+
+				reply, err := newTCPTestPacket("10.1.1.2", "10.1.1.1", 80, 6000, packet.TCPAckMask, nil)
+				So(err, ShouldBeNil)
+				So(enforcer.processApplicationTCPPackets(reply), ShouldNotBeNil)
+
+				data, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, []byte("query"))
+				So(err, ShouldBeNil)
+				So(enforcer.ProcessNetworkUDPPacket(data), ShouldNotBeNil)
+
+				So(conntrack.calls(), ShouldBeEmpty)
+			})
+
+			Convey("Then a new TCP connection with the tuple of a revoked flow should be processed again", func() {
+				syn, err := newTCPTestPacket("10.1.1.1", "10.1.1.2", 6000, 80, packet.TCPSynMask, nil)
+				So(err, ShouldBeNil)
+				So(enforcer.dropRevokedFlow(syn), ShouldBeFalse)
+				_, err = enforcer.revokedFlows.Get("6:10.1.1.2:10.1.1.1:80:6000")
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the policy that denies them is enforced with the revocation disabled", func() {
+			So(enforcer.Enforce("server", revocationTestPUInfo(false, false)), ShouldBeNil)
+
+			Convey("Then the flows should be kept", func() {
+				_, err := enforcer.netOrigConnectionTracker.Get("6:10.1.1.1:10.1.1.2:5000:80")
+				So(err, ShouldBeNil)
+				_, err = enforcer.udpNetOrigConnectionTracker.Get("17:10.1.1.1:10.1.1.2:5000:53")
+				So(err, ShouldBeNil)
+				_, err = enforcer.markedFlows.Get("6:10.1.1.1:10.1.1.2:6000:80")
+				So(err, ShouldBeNil)
+				So(conntrack.calls(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the policy that still allows them is enforced with the revocation enabled", func() {
+			So(enforcer.Enforce("server", revocationTestPUInfo(true, true)), ShouldBeNil)
+
+			Convey("Then the flows should be kept", func() {
+				_, err := enforcer.netOrigConnectionTracker.Get("6:10.1.1.1:10.1.1.2:5000:80")
+				So(err, ShouldBeNil)
+				_, err = enforcer.udpNetOrigConnectionTracker.Get("17:10.1.1.1:10.1.1.2:5000:53")
+				So(err, ShouldBeNil)
+				_, err = enforcer.markedFlows.Get("6:10.1.1.1:10.1.1.2:6000:80")
+				So(err, ShouldBeNil)
+				So(conntrack.calls(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a flow has no remote tags", func() {
+			tcpConn.RemoteTags = nil
+			So(enforcer.Enforce("server", revocationTestPUInfo(false, true)), ShouldBeNil)
+
+			Convey("Then it should not be evaluated", func() {
+				_, err := enforcer.netOrigConnectionTracker.Get("6:10.1.1.1:10.1.1.2:5000:80")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the PU is unenforced", func() {
+			So(enforcer.Unenforce("server"), ShouldBeNil)
+
+			Convey("Then its flows should not be kept for the revocation", func() {
+				_, err := enforcer.markedFlows.Get("6:10.1.1.1:10.1.1.2:6000:80")
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestParseFlowHash(t *testing.T) {
+
+	Convey("When I parse the hash of an IPv4 flow", t, func() {
+		flow, err := parseFlowHash("6:10.1.1.1:10.1.1.2:5000:80")
+
+		Convey("Then I should get its tuple", func() {
+			So(err, ShouldBeNil)
+			So(flow.protocol, ShouldEqual, 6)
+			So(flow.sourceIP(), ShouldEqual, "10.1.1.1")
+			So(flow.destinationIP(), ShouldEqual, "10.1.1.2")
+			So(flow.sourcePort, ShouldEqual, 5000)
+			So(flow.destinationPort, ShouldEqual, 80)
+			So(flow.hash(), ShouldEqual, "6:10.1.1.1:10.1.1.2:5000:80")
+			So(flow.reverseHash(), ShouldEqual, "6:10.1.1.2:10.1.1.1:80:5000")
+		})
+	})
+
+	Convey("When I parse the hash of an IPv6 flow", t, func() {
+		flow, err := parseFlowHash("17:[2001:db8::1]:[2001:db8::2]:5000:53")
+
+		Convey("Then I should get its tuple", func() {
+			So(err, ShouldBeNil)
+			So(flow.protocol, ShouldEqual, 17)
+			So(flow.sourceIP(), ShouldEqual, "2001:db8::1")
+			So(flow.destinationIP(), ShouldEqual, "2001:db8::2")
+			So(flow.sourcePort, ShouldEqual, 5000)
+			So(flow.destinationPort, ShouldEqual, 53)
+			So(flow.reverseHash(), ShouldEqual, "17:[2001:db8::2]:[2001:db8::1]:53:5000")
+		})
+	})
+
+	Convey("When I parse invalid hashes", t, func() {
+		for _, hash := range []string{"", "6", "tcp:10.1.1.1:10.1.1.2:5000:80", "6:10.1.1.1:5000:80", "6:10.1.1.1:10.1.1.2:http:80"} {
+			_, err := parseFlowHash(hash)
+
+			Convey("Then I should get an error for "+hash, func() {
+				So(err, ShouldNotBeNil)
+			})
+		}
+	})
+}
@@ -0,0 +1,379 @@
+package nfqdatapath
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/logging"
+	"go.uber.org/zap"
+)
+
+const (
+	// tlsRecordHandshake is the content type of the TLS handshake records.
+	tlsRecordHandshake = 22
+	// tlsHandshakeClientHello is the type of the ClientHello message.
+	tlsHandshakeClientHello = 1
+	// tlsExtensionServerName is the type of the server name extension.
+	tlsExtensionServerName = 0
+	// tlsServerNameHost is the type of the host names of the extension.
+	tlsServerNameHost = 0
+	// maxClientHelloLen is the maximum size of the data buffered until the
+	// ClientHello is complete.
+	maxClientHelloLen = 16 * 1024
+	// serverNameTTL is the time the addresses of a server name are cached.
+	// The names that could not be resolved are cached too.
+	serverNameTTL = time.Minute
+	// serverNameTimeout is the timeout of the resolution of a server name.
+	serverNameTimeout = 5 * time.Second
+)
+
+// errClientHelloIncomplete is returned when more data is needed to parse the
+// ClientHello.
+var errClientHelloIncomplete = errors.New("incomplete client hello")
+
+// LookupServerName is mapped to the function net.DefaultResolver.LookupHost
+var LookupServerName = net.DefaultResolver.LookupHost
+
+// SetServerNameInspection sets whether the TLS flows to the external services
+// that no ACL accepts are matched by the server name of their ClientHello
+// against the DNS rules of the PU. A flow is only accepted if its destination
+// is one of the addresses that its server name resolves to, so that a PU
+// cannot reach any address by sending an allowed name. The TCP handshake of
+// the flows completes before the check, since the client only sends its
+// ClientHello afterwards, but the data of the client is held in the datapath
+// until the ClientHello is complete and checked, and the flows are then
+// released or dropped. No data reaches the destination before the check
+// passes. It is disabled by default.
+func (d *Datapath) SetServerNameInspection(enable bool) {
+
+	var value uint32
+	if enable {
+		value = 1
+	}
+
+	atomic.StoreUint32(&d.serverNameInspection, value)
+}
+
+// holdServerNameFlow keeps an external connection that no ACL accepts in the
+// datapath if a DNS rule of the PU may accept it by its server name. It
+// returns false if the connection should be dropped.
+func (d *Datapath) holdServerNameFlow(context *pucontext.PUContext, conn *connection.TCPConnection, tcpPacket *packet.Packet) bool {
+
+	if atomic.LoadUint32(&d.serverNameInspection) == 0 {
+		return false
+	}
+
+	if !context.HasDNSRules(tcpPacket.DestinationPort, "TCP") {
+		return false
+	}
+
+	conn.SetState(connection.TCPServerNamePending)
+	conn.ServerNameData = nil
+	conn.ServerNameSeq = tcpPacket.TCPSeq + 1
+
+	d.appOrigConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+	d.sourcePortConnectionCache.AddOrUpdate(tcpPacket.SourcePortHash(packet.PacketTypeApplication), conn)
+
+	return true
+}
+
+// acceptServerNameSynAck accepts the SynAck of a connection that waits for
+// its server name, and tracks the connection for the packets of the server.
+func (d *Datapath) acceptServerNameSynAck(conn *connection.TCPConnection, tcpPacket *packet.Packet) {
+
+	if err := d.sourcePortConnectionCache.Remove(tcpPacket.SourcePortHash(packet.PacketTypeNetwork)); err != nil {
+		logging.Named("datapath").Debug("Failed to clean cache sourcePortConnectionCache", zap.Error(err))
+	}
+
+	d.netReplyConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+}
+
+// inspectServerName buffers the data that the application sends on a
+// connection that waits for its server name. Once the ClientHello is
+// complete, its server name is matched against the DNS rules of the PU and
+// the connection is released if a rule accepts it. It returns an error if
+// the packet must be dropped.
+func (d *Datapath) inspectServerName(context *pucontext.PUContext, conn *connection.TCPConnection, tcpPacket *packet.Packet) error {
+
+	if tcpPacket.TCPFlags&(packet.TCPFinMask|packet.TCPRstMask) != 0 {
+		d.forgetServerNameFlow(conn, tcpPacket)
+		return nil
+	}
+
+	data := tcpPacket.ReadTCPData()
+	if len(data) == 0 {
+		return nil
+	}
+
+	// The data is buffered in order, and held until the ClientHello is
+	// checked. The retransmissions of the data that was already buffered and
+	// the data after a gap are dropped so that they are sent again.
+	if offset := int32(tcpPacket.TCPSeq - conn.ServerNameSeq); offset != 0 {
+		return fmt.Errorf("out of order data on connection waiting for server name")
+	}
+
+	conn.ServerNameData = append(conn.ServerNameData, data...)
+	conn.ServerNameSeq += uint32(len(data))
+
+	name, err := parseClientHelloServerName(conn.ServerNameData)
+	if err == errClientHelloIncomplete && len(conn.ServerNameData) < maxClientHelloLen {
+		// The segment is dropped and sent again by the client once the
+		// flow is released.
+		return fmt.Errorf("holding data of connection waiting for server name")
+	}
+
+	report := &policy.FlowPolicy{
+		Action:    policy.Reject,
+		PolicyID:  "default",
+		ServiceID: "default",
+	}
+
+	if err == nil {
+		var plc *policy.FlowPolicy
+		if plc, err = context.ApplicationACLPolicyFromServerName(name, tcpPacket.DestinationPort, "TCP"); err == nil {
+			addrs, resolved := d.serverNameAddresses(name)
+			if !resolved {
+				// The last segment is dropped until the name is resolved,
+				// and the ClientHello is checked again when it is sent
+				// again.
+				conn.ServerNameData = conn.ServerNameData[:len(conn.ServerNameData)-len(data)]
+				conn.ServerNameSeq -= uint32(len(data))
+				return fmt.Errorf("resolving server name %s", name)
+			}
+
+			if addrs[tcpPacket.DestinationAddress.String()] {
+				d.releaseServerNameFlow(context, conn, plc, tcpPacket)
+				return nil
+			}
+
+			err = fmt.Errorf("server name %s does not resolve to %s", name, tcpPacket.DestinationAddress.String())
+		}
+	}
+
+	d.forgetServerNameFlow(conn, tcpPacket)
+	d.reportExternalServiceFlow(context, report, report, true, tcpPacket)
+
+	return fmt.Errorf("dropping connection waiting for server name: %s", err)
+}
+
+// serverNameAddresses returns the addresses that a server name resolves to
+// if they are cached, and starts the resolution of the name otherwise. It
+// returns false while the name is resolved.
+func (d *Datapath) serverNameAddresses(name string) (map[string]bool, bool) {
+
+	if item, err := d.serverNameAddrs.Get(name); err == nil {
+		addrs, ok := item.(map[string]bool)
+		return addrs, ok
+	}
+
+	// The name is cached while it is resolved, so that it is only resolved
+	// once.
+	if err := d.serverNameAddrs.Add(name, nil); err != nil {
+		return nil, false
+	}
+
+	go d.resolveServerName(name)
+
+	return nil, false
+}
+
+// resolveServerName caches the addresses of a server name. A name that
+// cannot be resolved has no address.
+func (d *Datapath) resolveServerName(name string) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverNameTimeout)
+	defer cancel()
+
+	resolved, err := LookupServerName(ctx, name)
+	if err != nil {
+		zap.L().Debug("Unable to resolve the server name", zap.String("name", name), zap.Error(err))
+	}
+
+	addrs := map[string]bool{}
+	for _, addr := range resolved {
+		if ip := net.ParseIP(addr); ip != nil {
+			addrs[ip.String()] = true
+		}
+	}
+
+	d.serverNameAddrs.AddOrUpdate(name, addrs)
+}
+
+// releaseServerNameFlow releases a connection accepted by its server name to
+// the kernel. The connection stays in the caches as a data connection until
+// they expire, for the packets that are already queued.
+func (d *Datapath) releaseServerNameFlow(context *pucontext.PUContext, conn *connection.TCPConnection, plc *policy.FlowPolicy, tcpPacket *packet.Packet) {
+
+	conn.ServerNameData = nil
+	conn.SetState(connection.TCPData)
+
+	if err := d.updateConntrackMark(
+		tcpPacket.SourceAddress.String(),
+		tcpPacket.DestinationAddress.String(),
+		tcpPacket.IPProto,
+		tcpPacket.SourcePort,
+		tcpPacket.DestinationPort,
+		d.filterQueue.GetConnMark(),
+	); err != nil {
+		logging.Named("datapath").Error("Failed to update conntrack table", zap.Error(err))
+	}
+
+	d.reportExternalServiceFlow(context, plc, plc, true, tcpPacket)
+}
+
+// forgetServerNameFlow removes a connection that waits for its server name
+// from the caches.
+func (d *Datapath) forgetServerNameFlow(conn *connection.TCPConnection, tcpPacket *packet.Packet) {
+
+	conn.ServerNameData = nil
+
+	d.appOrigConnectionTracker.Remove(tcpPacket.L4FlowHash())                                  // nolint
+	d.netReplyConnectionTracker.Remove(tcpPacket.L4ReverseFlowHash())                          // nolint
+	d.sourcePortConnectionCache.Remove(tcpPacket.SourcePortHash(packet.PacketTypeApplication)) // nolint
+}
+
+// parseClientHelloServerName returns the host name of the server name
+// extension of a TLS ClientHello. The data is the start of the stream of the
+// client, and the ClientHello can span several records. It returns
+// errClientHelloIncomplete if more data is needed.
+func parseClientHelloServerName(data []byte) (string, error) {
+
+	handshake := []byte{}
+
+	for len(data) > 0 {
+		if data[0] != tlsRecordHandshake {
+			return "", fmt.Errorf("not a tls handshake record: %d", data[0])
+		}
+
+		if len(data) < 5 {
+			return "", errClientHelloIncomplete
+		}
+
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			return "", errClientHelloIncomplete
+		}
+
+		handshake = append(handshake, data[5:5+length]...)
+		data = data[5+length:]
+
+		if len(handshake) > 0 && handshake[0] != tlsHandshakeClientHello {
+			return "", fmt.Errorf("not a tls client hello: %d", handshake[0])
+		}
+
+		if len(handshake) < 4 {
+			continue
+		}
+
+		messageLen := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if len(handshake) >= 4+messageLen {
+			return clientHelloServerName(handshake[4 : 4+messageLen])
+		}
+	}
+
+	return "", errClientHelloIncomplete
+}
+
+// clientHelloServerName returns the host name of the server name extension
+// of the body of a ClientHello.
+func clientHelloServerName(hello []byte) (string, error) {
+
+	r := &tlsReader{data: hello}
+
+	// The version and the random.
+	r.skip(34)
+	// The session id, cipher suites and compression methods.
+	r.skip(r.uint8())
+	r.skip(r.uint16())
+	r.skip(r.uint8())
+
+	if r.err != nil {
+		return "", r.err
+	}
+
+	extensions := &tlsReader{data: r.bytes(r.uint16())}
+	for r.err == nil && extensions.err == nil && len(extensions.data) > 0 {
+		extensionType := extensions.uint16()
+		extension := &tlsReader{data: extensions.bytes(extensions.uint16())}
+		if extensionType != tlsExtensionServerName {
+			continue
+		}
+
+		names := &tlsReader{data: extension.bytes(extension.uint16())}
+		for extension.err == nil && names.err == nil && len(names.data) > 0 {
+			nameType := names.uint8()
+			name := names.bytes(names.uint16())
+			if names.err == nil && nameType == tlsServerNameHost && len(name) > 0 {
+				return strings.ToLower(string(name)), nil
+			}
+		}
+	}
+
+	if r.err != nil {
+		return "", r.err
+	}
+
+	if extensions.err != nil {
+		return "", extensions.err
+	}
+
+	return "", errors.New("no server name in client hello")
+}
+
+// tlsReader reads the fields of a TLS message. The first read past the end
+// of the data sets err, and the following reads return zero values.
+type tlsReader struct {
+	data []byte
+	err  error
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+
+	if r.err != nil {
+		return nil
+	}
+
+	if len(r.data) < n {
+		r.err = errors.New("truncated tls message")
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+
+	return b
+}
+
+func (r *tlsReader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *tlsReader) uint8() int {
+
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+
+	return int(b[0])
+}
+
+func (r *tlsReader) uint16() int {
+
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint16(b))
+}
@@ -0,0 +1,310 @@
+package nfqdatapath
+
+import (
+	gocontext "context"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// newClientHello returns the first record that a TLS client sends to a
+// server with the given name.
+func newClientHello(serverName string) []byte {
+
+	client, server := net.Pipe()
+	defer server.Close() // nolint
+
+	go func() {
+		defer client.Close() // nolint
+
+		config := &tls.Config{ServerName: serverName, InsecureSkipVerify: true} // nolint
+		tls.Client(client, config).Handshake()                                  // nolint
+	}()
+
+	buffer := make([]byte, 16*1024)
+	n, _ := server.Read(buffer)
+
+	return buffer[:n]
+}
+
+// splitClientHelloRecord returns the handshake of a ClientHello record in two
+// records.
+func splitClientHelloRecord(record []byte, at int) []byte {
+
+	handshake := record[5:]
+	split := []byte{}
+	for _, fragment := range [][]byte{handshake[:at], handshake[at:]} {
+		header := []byte{record[0], record[1], record[2], 0, 0}
+		binary.BigEndian.PutUint16(header[3:5], uint16(len(fragment)))
+		split = append(split, header...)
+		split = append(split, fragment...)
+	}
+
+	return split
+}
+
+func TestParseClientHelloServerName(t *testing.T) {
+
+	Convey("Given a ClientHello with a server name", t, func() {
+		hello := newClientHello("www.Example.com")
+		So(hello[0], ShouldEqual, tlsRecordHandshake)
+
+		Convey("The server name should be returned", func() {
+			name, err := parseClientHelloServerName(hello)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "www.example.com")
+		})
+
+		Convey("The server name should be returned with the data that follows", func() {
+			name, err := parseClientHelloServerName(append(append([]byte{}, hello...), 23, 3, 3, 0, 1))
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "www.example.com")
+		})
+
+		Convey("More data should be needed for a part of the ClientHello", func() {
+			for _, n := range []int{1, 4, 5, 50, len(hello) - 1} {
+				_, err := parseClientHelloServerName(hello[:n])
+				So(err, ShouldEqual, errClientHelloIncomplete)
+			}
+		})
+
+		Convey("The server name of a ClientHello split in several records should be returned", func() {
+			split := splitClientHelloRecord(hello, 2)
+
+			_, err := parseClientHelloServerName(split[:10])
+			So(err, ShouldEqual, errClientHelloIncomplete)
+
+			name, err := parseClientHelloServerName(split)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "www.example.com")
+
+			split = splitClientHelloRecord(hello, 100)
+			name, err = parseClientHelloServerName(split)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "www.example.com")
+		})
+
+		Convey("A truncated ClientHello should be rejected", func() {
+			truncated := append([]byte{}, hello...)
+			// The ClientHello claims to be shorter than its extensions.
+			truncated = truncated[:len(truncated)-10]
+			binary.BigEndian.PutUint16(truncated[3:5], uint16(len(truncated)-5))
+			truncated[6] = 0
+			truncated[7] = byte((len(truncated) - 9) >> 8)
+			truncated[8] = byte(len(truncated) - 9)
+
+			_, err := parseClientHelloServerName(truncated)
+			So(err, ShouldNotBeNil)
+			So(err, ShouldNotEqual, errClientHelloIncomplete)
+		})
+	})
+
+	Convey("A ClientHello without server name should be rejected", t, func() {
+		_, err := parseClientHelloServerName(newClientHello(""))
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, errClientHelloIncomplete)
+	})
+
+	Convey("Data that is not TLS should be rejected", t, func() {
+		_, err := parseClientHelloServerName([]byte("GET / HTTP/1.1\r\nHost: www.example.com\r\n\r\n"))
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, errClientHelloIncomplete)
+	})
+}
+
+func newServerNameTestPacket(flags uint8, seq uint32, payload []byte) *packet.Packet {
+
+	p, err := newTCPTestPacket("10.1.1.1", "52.1.1.1", 40000, 443, flags, payload)
+	So(err, ShouldBeNil)
+	p.TCPSeq = seq
+
+	return p
+}
+
+func TestServerNameInspection(t *testing.T) {
+
+	Convey("Given a datapath that inspects the server names of a PU with DNS rules", t, func() {
+		flows := &flowCapturingCollector{}
+		conntrack := &capturingConntrack{}
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		d := NewWithDefaults("SomeServerId", flows, nil, secret, constants.RemoteContainer, "/proc", []string{"10.0.0.0/8"})
+		d.conntrackHdl = conntrack
+		d.SetServerNameInspection(true)
+
+		puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+		puInfo.Policy.UpdateDNSNetworks(policy.DNSRuleList{
+			{Name: "*.example.com", Port: "443"},
+		})
+		context, err := pucontext.NewPU("pu", puInfo, 10*time.Second)
+		So(err, ShouldBeNil)
+
+		conn := connection.NewTCPConnection(context)
+		syn := newServerNameTestPacket(packet.TCPSynMask, 1000, nil)
+		_, err = d.processApplicationSynPacket(syn, context, conn)
+		So(err, ShouldBeNil)
+		So(conn.GetState(), ShouldEqual, connection.TCPServerNamePending)
+
+		ack := newServerNameTestPacket(packet.TCPAckMask, 1001, nil)
+		_, err = d.processApplicationAckPacket(ack, context, conn)
+		So(err, ShouldBeNil)
+
+		d.serverNameAddrs.AddOrUpdate("www.example.com", map[string]bool{"52.1.1.1": true})
+		d.serverNameAddrs.AddOrUpdate("other.example.com", map[string]bool{"52.2.2.2": true})
+
+		Convey("When the ClientHello of an allowed name is sent in two segments", func() {
+			hello := newClientHello("www.example.com")
+
+			_, err := d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1001, hello[:100]), context, conn)
+			So(err, ShouldNotBeNil)
+			So(conn.GetState(), ShouldEqual, connection.TCPServerNamePending)
+			So(conntrack.calls(), ShouldBeEmpty)
+
+			_, err = d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1101, hello[100:]), context, conn)
+
+			Convey("Then the flow should be accepted and released", func() {
+				So(err, ShouldBeNil)
+				So(conn.GetState(), ShouldEqual, connection.TCPData)
+				So(conntrack.calls(), ShouldResemble, []conntrackUpdate{
+					{ipSrc: "10.1.1.1", ipDst: "52.1.1.1", protonum: packet.IPProtocolTCP, srcport: 40000, dstport: 443, newmark: d.filterQueue.GetConnMark()},
+				})
+
+				records := flows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].Action.Accepted(), ShouldBeTrue)
+				So(records[0].Destination.IP, ShouldEqual, "52.1.1.1")
+			})
+		})
+
+		Convey("When a segment of the ClientHello is retransmitted or sent out of order", func() {
+			hello := newClientHello("www.example.com")
+
+			_, err := d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1001, hello[:100]), context, conn)
+			So(err, ShouldNotBeNil)
+			_, err = d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1001, hello[:100]), context, conn)
+			So(err, ShouldNotBeNil)
+			_, err = d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1201, hello[200:]), context, conn)
+			So(err, ShouldNotBeNil)
+			_, err = d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1101, hello[100:]), context, conn)
+
+			Convey("Then the ClientHello should be buffered in order", func() {
+				So(err, ShouldBeNil)
+				So(conn.GetState(), ShouldEqual, connection.TCPData)
+			})
+		})
+
+		Convey("When the ClientHello of an allowed name that does not resolve to the destination is sent", func() {
+			_, err := d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1001, newClientHello("other.example.com")), context, conn)
+
+			Convey("Then the flow should be dropped and forgotten", func() {
+				So(err, ShouldNotBeNil)
+				So(conntrack.calls(), ShouldBeEmpty)
+
+				_, err := d.appOrigConnectionTracker.Get(syn.L4FlowHash())
+				So(err, ShouldNotBeNil)
+
+				records := flows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].Action.Rejected(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the ClientHello of an allowed name that is not resolved yet is sent", func() {
+			prevLookup := LookupServerName
+			defer func() {
+				LookupServerName = prevLookup
+			}()
+			resolved := make(chan string, 1)
+			LookupServerName = func(ctx gocontext.Context, host string) ([]string, error) {
+				resolved <- host
+				return []string{"52.1.1.1"}, nil
+			}
+
+			hello := newClientHello("new.example.com")
+			_, err := d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1001, hello), context, conn)
+			So(err, ShouldNotBeNil)
+			So(conn.GetState(), ShouldEqual, connection.TCPServerNamePending)
+			So(conntrack.calls(), ShouldBeEmpty)
+
+			So(<-resolved, ShouldEqual, "new.example.com")
+			for i := 0; i < 100; i++ {
+				if _, ok := d.serverNameAddresses("new.example.com"); ok {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			_, err = d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1001, hello), context, conn)
+
+			Convey("Then the flow should be accepted once the name resolves to the destination", func() {
+				So(err, ShouldBeNil)
+				So(conn.GetState(), ShouldEqual, connection.TCPData)
+				So(len(conntrack.calls()), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the ClientHello of a name that no rule allows is sent", func() {
+			_, err := d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1001, newClientHello("www.example.org")), context, conn)
+
+			Convey("Then the flow should be dropped and forgotten", func() {
+				So(err, ShouldNotBeNil)
+				So(conntrack.calls(), ShouldBeEmpty)
+
+				_, err := d.appOrigConnectionTracker.Get(syn.L4FlowHash())
+				So(err, ShouldNotBeNil)
+
+				records := flows.records()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].Action.Rejected(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the application sends data that is not TLS", func() {
+			_, err := d.processApplicationAckPacket(newServerNameTestPacket(packet.TCPAckMask, 1001, []byte("GET / HTTP/1.1\r\n\r\n")), context, conn)
+
+			Convey("Then the flow should be dropped", func() {
+				So(err, ShouldNotBeNil)
+				So(conntrack.calls(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the server of the flow is in the target networks", func() {
+			synAck, err := newTCPTestPacket("52.1.1.1", "10.1.1.1", 443, 40000, packet.TCPSynAckMask, nil)
+			So(err, ShouldBeNil)
+			_, _, err = d.processNetworkSynAckPacket(context, conn, synAck)
+
+			Convey("Then its SynAck should be accepted while the flow waits for the server name", func() {
+				So(err, ShouldBeNil)
+				_, err := d.netReplyConnectionTracker.Get(synAck.L4FlowHash())
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a datapath that does not inspect the server names", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		d := NewWithDefaults("SomeServerId", &flowCapturingCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"10.0.0.0/8"})
+
+		puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+		puInfo.Policy.UpdateDNSNetworks(policy.DNSRuleList{
+			{Name: "*.example.com", Port: "443"},
+		})
+		context, err := pucontext.NewPU("pu", puInfo, 10*time.Second)
+		So(err, ShouldBeNil)
+
+		Convey("The Syn of a flow that no ACL accepts should be dropped", func() {
+			_, err := d.processApplicationSynPacket(newServerNameTestPacket(packet.TCPSynMask, 1000, nil), context, connection.NewTCPConnection(context))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,27 @@
+// +build linux
+
+package nfqdatapath
+
+import "syscall"
+
+// socketMarkSupported is set if the sockets can be marked.
+const socketMarkSupported = true
+
+// socketMark returns the mark of the socket.
+func socketMark(sock syscall.Conn) (int, error) {
+
+	rawconn, err := sock.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var mark int
+	var markErr error
+	if err := rawconn.Control(func(fd uintptr) {
+		mark, markErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK)
+	}); err != nil {
+		return 0, err
+	}
+
+	return mark, markErr
+}
@@ -0,0 +1,16 @@
+// +build !linux
+
+package nfqdatapath
+
+import (
+	"errors"
+	"syscall"
+)
+
+// socketMarkSupported is set if the sockets can be marked.
+const socketMarkSupported = false
+
+// socketMark returns the mark of the socket.
+func socketMark(sock syscall.Conn) (int, error) {
+	return 0, errors.New("socket marks are not supported")
+}
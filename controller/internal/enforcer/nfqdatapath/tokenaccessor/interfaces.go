@@ -20,4 +20,8 @@ type TokenAccessor interface {
 	CreateSynAckPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) (token []byte, err error)
 	ParsePacketToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error)
 	ParseAckToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error)
+	CreateKeyRotationToken(auth *connection.AuthInfo, localContext []byte, remoteContext []byte) ([]byte, error)
+	ParseKeyRotationToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error)
+	CreateFinToken(auth *connection.AuthInfo) ([]byte, error)
+	ParseFinToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error)
 }
@@ -6,36 +6,52 @@ import (
 	"sync"
 	"time"
 
-	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
 	"go.aporeto.io/trireme-lib/controller/pkg/connection"
 	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
 	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
 	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
+	"go.aporeto.io/trireme-lib/utils/crypto"
 )
 
 // tokenAccessor is a wrapper around tokenEngine to provide locks for accessing
 type tokenAccessor struct {
 	sync.RWMutex
-	tokens   tokens.TokenEngine
-	serverID string
-	validity time.Duration
+	tokens    tokens.TokenEngine
+	serverID  string
+	validity  time.Duration
+	clockSkew time.Duration
 }
 
-// New creates a new instance of TokenAccessor interface
-func New(serverID string, validity time.Duration, secret secrets.Secrets) (TokenAccessor, error) {
+// New creates a new instance of TokenAccessor interface. The clock skew is
+// the tolerance applied to the time based claims of received tokens.
+func New(serverID string, validity time.Duration, clockSkew time.Duration, secret secrets.Secrets) (TokenAccessor, error) {
 
-	tokenEngine, err := tokens.NewJWT(validity, serverID, secret)
+	tokenEngine, err := newTokenEngine(serverID, validity, clockSkew, secret)
 	if err != nil {
 		return nil, err
 	}
 
 	return &tokenAccessor{
-		tokens:   tokenEngine,
-		serverID: serverID,
-		validity: validity,
+		tokens:    tokenEngine,
+		serverID:  serverID,
+		validity:  validity,
+		clockSkew: clockSkew,
 	}, nil
 }
 
+// newTokenEngine creates a JWT token engine with the given clock skew
+func newTokenEngine(serverID string, validity time.Duration, clockSkew time.Duration, secret secrets.Secrets) (tokens.TokenEngine, error) {
+
+	tokenEngine, err := tokens.NewJWT(validity, serverID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenEngine.ClockSkew = clockSkew
+
+	return tokenEngine, nil
+}
+
 func (t *tokenAccessor) getToken() tokens.TokenEngine {
 
 	t.Lock()
@@ -49,7 +65,7 @@ func (t *tokenAccessor) SetToken(serverID string, validity time.Duration, secret
 
 	t.Lock()
 	defer t.Unlock()
-	tokenEngine, err := tokens.NewJWT(validity, serverID, secret)
+	tokenEngine, err := newTokenEngine(serverID, validity, t.clockSkew, secret)
 	if err != nil {
 		return err
 	}
@@ -141,7 +157,7 @@ func (t *tokenAccessor) ParsePacketToken(auth *connection.AuthInfo, data []byte)
 	if claims.T == nil {
 		return nil, errors.New("no claims found")
 	}
-	remoteContextID, ok := claims.T.Get(enforcerconstants.TransmitterLabel)
+	remoteContextID, ok := claims.Transmitter()
 	if !ok {
 		return nil, errors.New("no transmitter label")
 	}
@@ -171,6 +187,11 @@ func (t *tokenAccessor) ParseAckToken(auth *connection.AuthInfo, data []byte) (*
 		return nil, err
 	}
 
+	// A teardown token is not an ack token, even if it carries the contexts
+	if len(claims.FIN) != 0 {
+		return nil, errors.New("fin token in ack packet")
+	}
+
 	// Compare the incoming random context with the stored context
 	matchLocal := bytes.Compare(claims.RMT, auth.LocalContext)
 	matchRemote := bytes.Compare(claims.LCL, auth.RemoteContext)
@@ -180,3 +201,95 @@ func (t *tokenAccessor) ParseAckToken(auth *connection.AuthInfo, data []byte) (*
 
 	return claims, nil
 }
+
+// CreateKeyRotationToken creates the token for key rotation packets. It is signed
+// like an ack token and carries the new local context of the sender together
+// with the remote context it expects.
+func (t *tokenAccessor) CreateKeyRotationToken(auth *connection.AuthInfo, localContext []byte, remoteContext []byte) ([]byte, error) {
+
+	claims := &tokens.ConnectionClaims{
+		LCL: localContext,
+		RMT: remoteContext,
+	}
+
+	token, err := t.getToken().CreateAndSign(true, claims, localContext)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return token, nil
+}
+
+// ParseKeyRotationToken validates the signature of a key rotation token. Matching
+// the contexts is left to the caller since it depends on the rotation state.
+func (t *tokenAccessor) ParseKeyRotationToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
+
+	if auth == nil {
+		return nil, errors.New("auth is nil")
+	}
+
+	claims, _, _, err := t.getToken().Decode(true, data, auth.RemotePublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(claims.LCL) == 0 || len(claims.RMT) == 0 {
+		return nil, errors.New("missing context in key rotation packet")
+	}
+
+	if len(claims.FIN) != 0 {
+		return nil, errors.New("fin token in key rotation packet")
+	}
+
+	return claims, nil
+}
+
+// CreateFinToken creates the token for connection teardown packets. It is
+// signed like an ack token and carries the contexts of the connection, with
+// a random nonce that makes every teardown token unique and tells it apart
+// from the ack tokens.
+func (t *tokenAccessor) CreateFinToken(auth *connection.AuthInfo) ([]byte, error) {
+
+	nonce, err := crypto.GenerateRandomBytes(16)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	claims := &tokens.ConnectionClaims{
+		LCL: auth.LocalContext,
+		RMT: auth.RemoteContext,
+		FIN: nonce,
+	}
+
+	token, err := t.getToken().CreateAndSign(true, claims, auth.LocalContext)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return token, nil
+}
+
+// ParseFinToken validates a teardown token against the contexts of the
+// connection. Checking that the nonce was not seen before is left to the
+// caller.
+func (t *tokenAccessor) ParseFinToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
+
+	if auth == nil {
+		return nil, errors.New("auth is nil")
+	}
+
+	claims, _, _, err := t.getToken().Decode(true, data, auth.RemotePublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(claims.FIN) == 0 {
+		return nil, errors.New("missing nonce in fin packet")
+	}
+
+	if !bytes.Equal(claims.RMT, auth.LocalContext) || !bytes.Equal(claims.LCL, auth.RemoteContext) {
+		return nil, errors.New("failed to match context in fin packet")
+	}
+
+	return claims, nil
+}
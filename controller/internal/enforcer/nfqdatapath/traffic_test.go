@@ -0,0 +1,109 @@
+package nfqdatapath
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestUDPTrafficReport(t *testing.T) {
+
+	Convey("Given I have a server enforcer that accepted a UDP connection", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		flows := &flowCapturingCollector{}
+		writer := &capturingSocketWriter{}
+
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return writer, nil
+		}
+
+		client := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		client.conntrackHdl = &capturingConntrack{}
+		server := NewWithDefaults("SomeServerId", flows, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		server.conntrackHdl = &capturingConntrack{}
+
+		clientContext, err := pucontext.NewPU("client", policy.NewPUInfo("client", common.ContainerPU), 10*time.Second)
+		So(err, ShouldBeNil)
+		serverPolicy := policy.NewPUPolicy("serverpu", policy.AllowAll, nil, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{}, []string{}, nil, nil, []string{})
+		serverRuntime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+		serverContext, err := pucontext.NewPU("server", policy.PUInfoFromPolicyAndRuntime("server", serverPolicy, serverRuntime), 10*time.Second)
+		So(err, ShouldBeNil)
+
+		clientConn := connection.NewUDPConnection(clientContext, writer)
+		serverConn := connection.NewUDPConnection(serverContext, writer)
+		clientConn.Auth.RemoteContext = serverConn.Auth.LocalContext
+		serverConn.Auth.RemoteContext = clientConn.Auth.LocalContext
+		serverConn.Auth.RemoteContextID = "clientpu"
+		serverConn.ReportFlowPolicy = &policy.FlowPolicy{Action: policy.Accept, PolicyID: "policy"}
+		serverConn.PacketFlowPolicy = serverConn.ReportFlowPolicy
+
+		synAck, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 53, 5000, nil)
+		So(err, ShouldBeNil)
+		So(client.sendUDPAckPacket(synAck, clientContext, clientConn), ShouldBeNil)
+
+		ack, err := packet.New(packet.PacketTypeNetwork, writer.last(), "0", true)
+		So(err, ShouldBeNil)
+		_, _, err = server.processNetUDPPacket(ack, serverContext, serverConn)
+		So(err, ShouldBeNil)
+		So(len(flows.records()), ShouldEqual, 1)
+		So(flows.records()[0].BytesSent, ShouldEqual, 0)
+
+		reply, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 53, 5000, nil)
+		So(err, ShouldBeNil)
+		server.udpAppReplyConnectionTracker.AddOrUpdate(reply.L4FlowHash(), serverConn)
+
+		// The client sends 3 packets and the server replies with 2.
+		for _, size := range []int{100, 200, 300} {
+			data, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, make([]byte, size))
+			So(err, ShouldBeNil)
+			_, _, err = server.processNetUDPPacket(data, serverContext, serverConn)
+			So(err, ShouldBeNil)
+		}
+		for _, size := range []int{10, 20} {
+			data, err := newUDPTestPacket("10.1.1.2", "10.1.1.1", 53, 5000, make([]byte, size))
+			So(err, ShouldBeNil)
+			So(server.ProcessApplicationUDPPacket(data), ShouldBeNil)
+		}
+
+		Convey("When the connection is closed, the bytes of its packets should be reported with the flow", func() {
+			server.closeUDPConnection(serverConn, ack.L4ReverseFlowHash(), false)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 2)
+			So(records[1].Count, ShouldEqual, 0)
+			So(records[1].BytesSent, ShouldEqual, 600)
+			So(records[1].BytesReceived, ShouldEqual, 30)
+			So(records[1].PolicyID, ShouldEqual, "policy")
+			So(collector.StatsFlowHash(records[1]), ShouldEqual, collector.StatsFlowHash(records[0]))
+
+			Convey("And the bytes should not be reported again when the connection expires", func() {
+				server.reapUDPConnection(serverConn)
+				So(len(flows.records()), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the connection expires, the bytes of its packets should be reported once", func() {
+			server.reapUDPConnection(serverConn)
+			server.reapUDPConnection(serverConn)
+
+			records := flows.records()
+			So(len(records), ShouldEqual, 2)
+			So(records[1].BytesSent, ShouldEqual, 600)
+			So(records[1].BytesReceived, ShouldEqual, 30)
+		})
+	})
+}
@@ -0,0 +1,237 @@
+package nfqdatapath
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/utils/cache"
+	"go.aporeto.io/trireme-lib/utils/logging"
+	"go.uber.org/zap"
+)
+
+const (
+	// udpConnectionSaveInterval is the interval at which the established
+	// UDP connections are persisted
+	udpConnectionSaveInterval = 10 * time.Second
+	// udpConnectionStateMaxAge is the age after which persisted connections
+	// are stale. It matches the expiration of the connection trackers.
+	udpConnectionStateMaxAge = 60 * time.Second
+)
+
+// Names of the UDP connection trackers in the persisted state
+const (
+	udpAppOrigTracker  = "appOrig"
+	udpAppReplyTracker = "appReply"
+	udpNetOrigTracker  = "netOrig"
+	udpNetReplyTracker = "netReply"
+)
+
+// UDPConnectionStore persists the state of established UDP connections so
+// that they survive a restart of the enforcer.
+type UDPConnectionStore interface {
+	// Save replaces the persisted connections.
+	Save(states []*connection.UDPConnectionState) error
+	// Load returns the persisted connections.
+	Load() ([]*connection.UDPConnectionState, error)
+}
+
+// udpFileStore persists the connections in a local file.
+type udpFileStore struct {
+	path string
+}
+
+// NewUDPFileStore returns a store that persists the connections in the
+// given file.
+func NewUDPFileStore(path string) UDPConnectionStore {
+	return &udpFileStore{path: path}
+}
+
+// Save writes the connections to a temporary file that atomically replaces
+// the store, so that a restart never loads a partial file.
+func (s *udpFileStore) Save(states []*connection.UDPConnectionState) error {
+
+	data, err := json.Marshal(states)
+	if err != nil {
+		return fmt.Errorf("unable to encode udp connections: %s", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("unable to write udp connections: %s", err)
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// Load reads the connections from the file. A missing file is an empty store.
+func (s *udpFileStore) Load() ([]*connection.UDPConnectionState, error) {
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read udp connections: %s", err)
+	}
+
+	states := []*connection.UDPConnectionState{}
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("unable to decode udp connections: %s", err)
+	}
+
+	return states, nil
+}
+
+// SetUDPConnectionStore enables the persistence of established UDP
+// connections. The persisted connections are loaded and restored when their
+// PU is enforced, and the current connections are saved periodically until
+// the context is cancelled.
+func (d *Datapath) SetUDPConnectionStore(ctx context.Context, store UDPConnectionStore) error {
+
+	states, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	pending := map[string][]*connection.UDPConnectionState{}
+	for _, s := range states {
+		if s == nil || time.Since(s.SavedAt) > udpConnectionStateMaxAge {
+			continue
+		}
+		pending[s.ContextID] = append(pending[s.ContextID], s)
+	}
+
+	d.udpStoreLock.Lock()
+	d.udpConnectionStore = store
+	d.udpPendingConnections = pending
+	d.udpStoreLock.Unlock()
+
+	// Restore the connections of the PUs that are already enforced.
+	for contextID := range pending {
+		if pu, err := d.puFromContextID.Get(contextID); err == nil {
+			d.restoreUDPConnections(pu.(*pucontext.PUContext))
+		}
+	}
+
+	go d.saveUDPConnectionsPeriodically(ctx)
+
+	return nil
+}
+
+// SaveUDPConnections persists the established UDP connections.
+func (d *Datapath) SaveUDPConnections() error {
+
+	d.udpStoreLock.Lock()
+	store := d.udpConnectionStore
+	d.udpStoreLock.Unlock()
+
+	if store == nil {
+		return nil
+	}
+
+	states := map[*connection.UDPConnection]*connection.UDPConnectionState{}
+
+	collect := func(name string, tracker cache.DataStore) {
+		for _, key := range tracker.KeyList() {
+			item, err := tracker.Get(key)
+			if err != nil {
+				continue
+			}
+
+			conn := item.(*connection.UDPConnection)
+			state, ok := states[conn]
+			if !ok {
+				conn.Lock()
+				state = conn.PersistentState()
+				conn.Unlock()
+				states[conn] = state
+			}
+
+			if state != nil {
+				state.Trackers[name] = key.(string)
+			}
+		}
+	}
+
+	collect(udpAppOrigTracker, d.udpAppOrigConnectionTracker)
+	collect(udpAppReplyTracker, d.udpAppReplyConnectionTracker)
+	collect(udpNetOrigTracker, d.udpNetOrigConnectionTracker)
+	collect(udpNetReplyTracker, d.udpNetReplyConnectionTracker)
+
+	list := make([]*connection.UDPConnectionState, 0, len(states))
+	for _, state := range states {
+		if state != nil {
+			list = append(list, state)
+		}
+	}
+
+	return store.Save(list)
+}
+
+// restoreUDPConnections restores the persisted connections of the PU.
+func (d *Datapath) restoreUDPConnections(pu *pucontext.PUContext) {
+
+	d.udpStoreLock.Lock()
+	states := d.udpPendingConnections[pu.ID()]
+	delete(d.udpPendingConnections, pu.ID())
+	d.udpStoreLock.Unlock()
+
+	restored := 0
+	for _, s := range states {
+		if time.Since(s.SavedAt) > udpConnectionStateMaxAge {
+			continue
+		}
+
+		conn := connection.NewUDPConnectionFromState(s, pu, d.udpSocketWriter)
+
+		for name, key := range s.Trackers {
+			switch name {
+			case udpAppOrigTracker:
+				d.udpAppOrigConnectionTracker.AddOrUpdate(key, conn)
+			case udpAppReplyTracker:
+				d.udpAppReplyConnectionTracker.AddOrUpdate(key, conn)
+			case udpNetOrigTracker:
+				d.udpNetOrigConnectionTracker.AddOrUpdate(key, conn)
+			case udpNetReplyTracker:
+				d.udpNetReplyConnectionTracker.AddOrUpdate(key, conn)
+			}
+		}
+
+		restored++
+	}
+
+	if restored > 0 {
+		logging.Named("datapath").Info("Restored udp connections",
+			zap.String("contextID", pu.ID()),
+			zap.Int("connections", restored),
+		)
+	}
+}
+
+// saveUDPConnectionsPeriodically saves the connections until the context is
+// cancelled, and one last time before returning.
+func (d *Datapath) saveUDPConnectionsPeriodically(ctx context.Context) {
+
+	ticker := time.NewTicker(udpConnectionSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := d.SaveUDPConnections(); err != nil {
+				logging.Named("datapath").Warn("Unable to save udp connections", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+			if err := d.SaveUDPConnections(); err != nil {
+				logging.Named("datapath").Warn("Unable to save udp connections", zap.Error(err))
+			}
+		}
+	}
+}
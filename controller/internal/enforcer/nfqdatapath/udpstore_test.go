@@ -0,0 +1,121 @@
+package nfqdatapath
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestUDPConnectionStore(t *testing.T) {
+
+	Convey("Given an enforcer with an established udp connection", t, func() {
+		dir, err := ioutil.TempDir("", "udpstore")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint errcheck
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return &capturingSocketWriter{}, nil
+		}
+
+		newEnforcer := func() *Datapath {
+			secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+			return NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		}
+
+		puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+		store := NewUDPFileStore(filepath.Join(dir, "pu.udp"))
+
+		enforcer := newEnforcer()
+		So(enforcer.SetUDPConnectionStore(ctx, store), ShouldBeNil)
+		So(enforcer.Enforce("pu", puInfo), ShouldBeNil)
+
+		item, err := enforcer.puFromContextID.Get("pu")
+		So(err, ShouldBeNil)
+		puContext := item.(*pucontext.PUContext)
+
+		established := connection.NewUDPConnection(puContext, nil)
+		established.SetState(connection.UDPData)
+		established.Auth.RemoteContext = []byte("remote context")
+		established.Auth.RemoteContextID = "remotepu"
+		enforcer.udpAppOrigConnectionTracker.AddOrUpdate("apphash", established)
+		enforcer.udpNetReplyConnectionTracker.AddOrUpdate("nethash", established)
+
+		handshake := connection.NewUDPConnection(puContext, nil)
+		handshake.SetState(connection.UDPClientSendSyn)
+		enforcer.udpAppOrigConnectionTracker.AddOrUpdate("handshakehash", handshake)
+
+		So(enforcer.SaveUDPConnections(), ShouldBeNil)
+
+		Convey("Only the established connection should be persisted", func() {
+			states, err := store.Load()
+			So(err, ShouldBeNil)
+			So(len(states), ShouldEqual, 1)
+			So(states[0].ContextID, ShouldEqual, "pu")
+			So(states[0].Trackers, ShouldResemble, map[string]string{
+				udpAppOrigTracker:  "apphash",
+				udpNetReplyTracker: "nethash",
+			})
+		})
+
+		Convey("When the enforcer restarts, the connection should be restored once the pu is enforced", func() {
+			restarted := newEnforcer()
+			So(restarted.SetUDPConnectionStore(ctx, store), ShouldBeNil)
+
+			_, err := restarted.udpAppOrigConnectionTracker.Get("apphash")
+			So(err, ShouldNotBeNil)
+
+			So(restarted.Enforce("pu", puInfo), ShouldBeNil)
+
+			item, err := restarted.udpAppOrigConnectionTracker.Get("apphash")
+			So(err, ShouldBeNil)
+			conn := item.(*connection.UDPConnection)
+			So(conn.GetState(), ShouldEqual, connection.UDPData)
+			So(conn.Auth.LocalContext, ShouldResemble, established.Auth.LocalContext)
+			So(conn.Auth.RemoteContext, ShouldResemble, []byte("remote context"))
+			So(conn.Auth.RemoteContextID, ShouldEqual, "remotepu")
+
+			reply, err := restarted.udpNetReplyConnectionTracker.Get("nethash")
+			So(err, ShouldBeNil)
+			So(reply, ShouldPointTo, conn)
+
+			_, err = restarted.udpAppOrigConnectionTracker.Get("handshakehash")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the persisted connections are stale, they should not be restored", func() {
+			states, err := store.Load()
+			So(err, ShouldBeNil)
+			for _, s := range states {
+				s.SavedAt = time.Now().Add(-2 * udpConnectionStateMaxAge)
+			}
+			So(store.Save(states), ShouldBeNil)
+
+			restarted := newEnforcer()
+			So(restarted.SetUDPConnectionStore(ctx, store), ShouldBeNil)
+			So(restarted.Enforce("pu", puInfo), ShouldBeNil)
+
+			_, err = restarted.udpAppOrigConnectionTracker.Get("apphash")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,195 @@
+package nfqdatapath
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller/constants"
+	enforcerconstants "go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/connection"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// udpTestTransportTimeout is how long a delivery waits for a packet.
+const udpTestTransportTimeout = time.Second
+
+// udpTestEndpoint is the raw socket of a datapath connected to a
+// udpTestTransport. The packets written on it are queued for the peer.
+type udpTestEndpoint struct {
+	out chan []byte
+}
+
+func (e *udpTestEndpoint) WriteSocket(buf []byte) error {
+
+	packet := make([]byte, len(buf))
+	copy(packet, buf)
+
+	select {
+	case e.out <- packet:
+		return nil
+	default:
+		return fmt.Errorf("transport queue is full")
+	}
+}
+
+func (e *udpTestEndpoint) CloseSocket() error {
+	return nil
+}
+
+// udpTestTransport connects the raw sockets of a client and a server
+// datapath in memory. The packets written by one datapath are delivered to
+// the network side of the other one, so that the handshake can be driven
+// end to end without the queues.
+type udpTestTransport struct {
+	client *udpTestEndpoint
+	server *udpTestEndpoint
+}
+
+func newUDPTestTransport() *udpTestTransport {
+	return &udpTestTransport{
+		client: &udpTestEndpoint{out: make(chan []byte, 64)},
+		server: &udpTestEndpoint{out: make(chan []byte, 64)},
+	}
+}
+
+// udpTestDelivery is a packet delivered by a udpTestTransport with the
+// verdict of the datapath that processed it. The type and the data of the
+// packet are read before the processing, since the datapath can reuse the
+// packet to build its reply.
+type udpTestDelivery struct {
+	udpType byte
+	data    []byte
+	verdict error
+}
+
+// deliverToServer delivers the next packet written by the client to the
+// server.
+func (t *udpTestTransport) deliverToServer(server *Datapath) (*udpTestDelivery, error) {
+	return deliverUDPTestPacket(t.client.out, server)
+}
+
+// deliverToClient delivers the next packet written by the server to the
+// client.
+func (t *udpTestTransport) deliverToClient(client *Datapath) (*udpTestDelivery, error) {
+	return deliverUDPTestPacket(t.server.out, client)
+}
+
+// deliverUDPTestPacket processes the next packet of the queue on the
+// network side of the datapath.
+func deliverUDPTestPacket(queue chan []byte, d *Datapath) (*udpTestDelivery, error) {
+
+	select {
+	case buffer := <-queue:
+		p, err := packet.New(packet.PacketTypeNetwork, buffer, "0", true)
+		if err != nil {
+			return nil, err
+		}
+		delivery := &udpTestDelivery{
+			udpType: p.GetUDPType(),
+			data:    append([]byte{}, p.GetUDPData()...),
+		}
+		delivery.verdict = d.ProcessNetworkUDPPacket(p)
+
+		return delivery, nil
+
+	case <-time.After(udpTestTransportTimeout):
+		return nil, fmt.Errorf("no packet was delivered")
+	}
+}
+
+func udpTestTransportPUInfo(id string, tag string) *policy.PUInfo {
+
+	puPolicy := policy.NewPUPolicy(id, policy.AllowAll, nil, nil, nil, nil, nil, nil, nil, nil, []string{}, []string{"10.1.1.0/24"}, []string{}, nil, nil, []string{})
+	puRuntime := policy.NewPURuntime("", 0, "", nil, nil, common.ContainerPU, nil)
+	puInfo := policy.PUInfoFromPolicyAndRuntime(id, puPolicy, puRuntime)
+	puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, id)
+	puInfo.Policy.AddIdentityTag("app", tag)
+	selector := policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{
+				Key:      "app",
+				Value:    []string{"client", "server"},
+				Operator: policy.Equal,
+			},
+		},
+		Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: id + "policy"},
+	}
+	puInfo.Policy.AddReceiverRules(selector)
+	puInfo.Policy.AddTransmitterRules(selector)
+
+	return puInfo
+}
+
+func TestUDPHandshakeOverTransport(t *testing.T) {
+
+	Convey("Given I have a client and a server datapath connected by a transport", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		transport := newUDPTestTransport()
+
+		prevRawSocket := GetUDPRawSocket
+		defer func() {
+			GetUDPRawSocket = prevRawSocket
+		}()
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return transport.client, nil
+		}
+		client := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		client.conntrackHdl = &capturingConntrack{}
+
+		GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return transport.server, nil
+		}
+		server := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+		server.conntrackHdl = &capturingConntrack{}
+
+		So(client.Enforce("clientpu", udpTestTransportPUInfo("clientpu", "client")), ShouldBeNil)
+		So(server.Enforce("serverpu", udpTestTransportPUInfo("serverpu", "server")), ShouldBeNil)
+
+		Convey("When the client sends the first packet of a flow", func() {
+			data, err := newUDPTestPacket("10.1.1.1", "10.1.1.2", 5000, 53, []byte("query"))
+			So(err, ShouldBeNil)
+			So(client.ProcessApplicationUDPPacket(data), ShouldNotBeNil)
+
+			Convey("Then the handshake should complete on both sides and the packet should be released", func() {
+				syn, err := transport.deliverToServer(server)
+				So(err, ShouldBeNil)
+				So(syn.udpType, ShouldEqual, packet.UDPSynMask)
+				So(syn.verdict, ShouldNotBeNil)
+
+				synAck, err := transport.deliverToClient(client)
+				So(err, ShouldBeNil)
+				So(synAck.udpType, ShouldEqual, packet.UDPSynAckMask)
+				So(synAck.verdict, ShouldNotBeNil)
+
+				item, err := client.udpAppOrigConnectionTracker.Get(data.L4FlowHash())
+				So(err, ShouldBeNil)
+				clientConn := item.(*connection.UDPConnection)
+				So(clientConn.GetState(), ShouldEqual, connection.UDPData)
+
+				ack, err := transport.deliverToServer(server)
+				So(err, ShouldBeNil)
+				So(ack.udpType, ShouldEqual, packet.UDPAckMask)
+				So(ack.verdict, ShouldNotBeNil)
+
+				item, err = server.udpNetOrigConnectionTracker.Get(data.L4FlowHash())
+				So(err, ShouldBeNil)
+				serverConn := item.(*connection.UDPConnection)
+				So(serverConn.GetState(), ShouldEqual, connection.UDPReceiverProcessedAck)
+				So(serverConn.Auth.RemoteContextID, ShouldEqual, "clientpu")
+
+				released, err := transport.deliverToServer(server)
+				So(err, ShouldBeNil)
+				So(string(released.data), ShouldEqual, "query")
+				So(released.verdict, ShouldBeNil)
+				So(serverConn.GetState(), ShouldEqual, connection.UDPData)
+			})
+		})
+	})
+}
@@ -1,9 +1,12 @@
 package nfqdatapath
 
 import (
+	"fmt"
 	"net"
+	"strconv"
 
 	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
 	"go.aporeto.io/trireme-lib/controller/pkg/connection"
 	"go.aporeto.io/trireme-lib/controller/pkg/packet"
 	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
@@ -17,11 +20,63 @@ func (d *Datapath) reportAcceptedFlow(p *packet.Packet, conn *connection.TCPConn
 	d.reportFlow(p, sourceID, destID, context, "", report, packet)
 }
 
+// reportUDPAcceptedFlow reports an accepted flow. The flow is kept with the
+// connection, so that the traffic that follows is reported with it.
 func (d *Datapath) reportUDPAcceptedFlow(p *packet.Packet, conn *connection.UDPConnection, sourceID string, destID string, context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+	record := flowRecord(p, sourceID, destID, context, "", report, packet)
 	if conn != nil {
 		conn.SetReported(connection.AcceptReported)
+		transmitted, received := conn.TakeTraffic()
+		setFlowTraffic(record, transmitted, received)
+		conn.Flow = copyFlowRecord(record)
 	}
-	d.reportFlow(p, sourceID, destID, context, "", report, packet)
+	d.collector.CollectFlowEvent(record)
+}
+
+// reportUDPTraffic reports the bytes of an accepted connection that were not
+// reported yet. The record has no count, since the flow was counted when it
+// was accepted.
+func (d *Datapath) reportUDPTraffic(conn *connection.UDPConnection) {
+
+	if conn.Flow == nil {
+		return
+	}
+
+	transmitted, received := conn.TakeTraffic()
+	if transmitted == 0 && received == 0 {
+		return
+	}
+
+	record := copyFlowRecord(conn.Flow)
+	record.Count = 0
+	setFlowTraffic(record, transmitted, received)
+
+	d.collector.CollectFlowEvent(record)
+}
+
+// setFlowTraffic sets the bytes of a flow from the bytes transmitted and
+// received by the PU that reports it.
+func setFlowTraffic(record *collector.FlowRecord, transmitted int, received int) {
+
+	if record.Direction == collector.FlowDirectionIncoming {
+		record.BytesSent, record.BytesReceived = received, transmitted
+		return
+	}
+
+	record.BytesSent, record.BytesReceived = transmitted, received
+}
+
+// copyFlowRecord returns a copy of a flow record. The collectors may update
+// the endpoints of the records they aggregate, so they are copied too.
+func copyFlowRecord(record *collector.FlowRecord) *collector.FlowRecord {
+
+	c := *record
+	source := *record.Source
+	destination := *record.Destination
+	c.Source = &source
+	c.Destination = &destination
+
+	return &c
 }
 
 func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
@@ -75,6 +130,53 @@ func (d *Datapath) reportUDPRejectedFlow(p *packet.Packet, conn *connection.UDPC
 	d.reportFlow(p, sourceID, destID, context, mode, report, packet)
 }
 
+// reportUnenforcedFlow reports a packet that is dropped because the process
+// at the PU end is not enforced. There is no context for the packet, so the
+// flow is reported without a PU. Only one flow is reported per source and
+// direction in an interval.
+func (d *Datapath) reportUnenforcedFlow(p *packet.Packet, app bool) {
+
+	key := "net:" + p.SourceAddress.String()
+	if app {
+		key = "app:" + p.SourceAddress.String()
+	}
+
+	if _, err := d.unenforcedReports.Get(key); err == nil {
+		return
+	}
+
+	if err := d.unenforcedReports.Add(key, nil); err != nil {
+		return
+	}
+
+	d.reportExternalIPFlow(p, policy.Reject, collector.UnenforcedSource)
+}
+
+// reportExternalIPFlow reports a packet for which there is no context. The
+// endpoints are reported with their addresses only.
+func (d *Datapath) reportExternalIPFlow(p *packet.Packet, action policy.ActionType, reason string) {
+
+	d.collector.CollectFlowEvent(&collector.FlowRecord{
+		Source: &collector.EndPoint{
+			ID:   collector.DefaultEndPoint,
+			IP:   p.SourceAddress.String(),
+			Port: p.SourcePort,
+			Type: collector.EndPointTypeExternalIP,
+		},
+		Destination: &collector.EndPoint{
+			ID:   collector.DefaultEndPoint,
+			IP:   p.DestinationAddress.String(),
+			Port: p.DestinationPort,
+			Type: collector.EndPointTypeExternalIP,
+		},
+		Action:     action,
+		DropReason: reason,
+		PolicyID:   "default",
+		L4Protocol: p.IPProto,
+		Count:      1,
+	})
+}
+
 func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet, src, dst *collector.EndPoint) {
 
 	if app {
@@ -106,6 +208,21 @@ func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext,
 		record.ObservedPolicyID = packet.PolicyID
 	}
 
+	if report.Action.Accepted() {
+		external := p.DestinationAddress
+		if !app {
+			external = p.SourceAddress
+		}
+		if name, ok := d.reverseDNSName(external); ok {
+			tags := policy.NewTagStore()
+			if record.Tags != nil {
+				tags = record.Tags.Copy()
+			}
+			tags.AppendKeyValue(enforcerconstants.ReverseDNSLabelString, name)
+			record.Tags = tags
+		}
+	}
+
 	d.collector.CollectFlowEvent(record)
 }
 
@@ -147,3 +264,25 @@ func addressMatch(ip net.IP, targets []*net.IPNet) bool {
 	}
 	return false
 }
+
+// parseHostPort parses an address and port as stored in the connection
+// caches. IPv6 addresses are enclosed in brackets.
+func parseHostPort(hostPort string) (net.IP, uint16, error) {
+
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("invalid ip address %s", host)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port %s", port)
+	}
+
+	return ip, uint16(p), nil
+}
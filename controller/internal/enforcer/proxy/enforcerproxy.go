@@ -15,7 +15,9 @@ import (
 	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/controller/constants"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/acls"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
 	"go.aporeto.io/trireme-lib/controller/internal/portset"
 	"go.aporeto.io/trireme-lib/controller/internal/processmon"
@@ -23,6 +25,7 @@ import (
 	"go.aporeto.io/trireme-lib/controller/pkg/packetprocessor"
 	"go.aporeto.io/trireme-lib/controller/pkg/remoteenforcer"
 	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
 	"go.aporeto.io/trireme-lib/policy"
 	"go.aporeto.io/trireme-lib/utils/crypto"
 )
@@ -34,6 +37,7 @@ type ProxyInfo struct {
 	Secrets                secrets.Secrets
 	serverID               string
 	validity               time.Duration
+	clockSkew              time.Duration
 	prochdl                processmon.ProcessManager
 	rpchdl                 rpcwrapper.RPCClient
 	initDone               map[string]bool
@@ -45,6 +49,24 @@ type ProxyInfo struct {
 	portSetInstance        portset.PortSet
 	collector              collector.EventCollector
 	targetNetworks         []string
+	excludedPorts          []string
+	reportExcludedPorts    bool
+	addressSets            map[string][]string
+	implicitAllowNetworks  []string
+	udpStateDir            string
+	udpHandshakeLimits     *rpcwrapper.UDPHandshakeLimits
+	statsFlowHash          string
+	auditDir               string
+	reverseDNS             bool
+	reverseDNSPolicy       bool
+	protocolHelpers        []string
+	serverNameInspection   bool
+	udpHandshakeTimeout    *time.Duration
+	udpKeyRotationInterval time.Duration
+	encryptStats           bool
+	prevSecrets            secrets.Secrets
+	ready                  chan struct{}
+	readyOnce              sync.Once
 	sync.RWMutex
 }
 
@@ -53,17 +75,40 @@ func (s *ProxyInfo) InitRemoteEnforcer(contextID string) error {
 
 	resp := &rpcwrapper.Response{}
 
+	payload := &rpcwrapper.InitRequestPayload{
+		FqConfig:               s.filterQueue,
+		MutualAuth:             s.MutualAuth,
+		Validity:               s.validity,
+		ClockSkew:              s.clockSkew,
+		ServerID:               s.serverID,
+		ExternalIPCacheTimeout: s.ExternalIPCacheTimeout,
+		PacketLogs:             s.PacketLogs,
+		Secrets:                s.Secrets.PublicSecrets(),
+		TargetNetworks:         s.targetNetworks,
+		EncryptStats:           s.encryptStats,
+	}
+
+	// The excluded ports, the address sets and the options of the remote
+	// enforcers can be updated async to the init.
+	s.RLock()
+	payload.ExcludedPorts = s.excludedPorts
+	payload.ReportExcludedPorts = s.reportExcludedPorts
+	payload.AddressSets = s.addressSets
+	payload.ImplicitAllowNetworks = s.implicitAllowNetworks
+	payload.UDPStateDir = s.udpStateDir
+	payload.UDPHandshakeLimits = s.udpHandshakeLimits
+	payload.StatsFlowHash = s.statsFlowHash
+	payload.AuditDir = s.auditDir
+	payload.ReverseDNS = s.reverseDNS
+	payload.ReverseDNSPolicy = s.reverseDNSPolicy
+	payload.ProtocolHelpers = s.protocolHelpers
+	payload.ServerNameInspection = s.serverNameInspection
+	payload.UDPHandshakeTimeout = s.udpHandshakeTimeout
+	payload.UDPKeyRotationInterval = s.udpKeyRotationInterval
+	s.RUnlock()
+
 	request := &rpcwrapper.Request{
-		Payload: &rpcwrapper.InitRequestPayload{
-			FqConfig:               s.filterQueue,
-			MutualAuth:             s.MutualAuth,
-			Validity:               s.validity,
-			ServerID:               s.serverID,
-			ExternalIPCacheTimeout: s.ExternalIPCacheTimeout,
-			PacketLogs:             s.PacketLogs,
-			Secrets:                s.Secrets.PublicSecrets(),
-			TargetNetworks:         s.targetNetworks,
-		},
+		Payload: payload,
 	}
 
 	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.InitEnforcer, request, resp); err != nil {
@@ -84,6 +129,8 @@ func (s *ProxyInfo) InitRemoteEnforcer(contextID string) error {
 // UpdateSecrets updates the secrets used for signing communication between trireme instances
 func (s *ProxyInfo) UpdateSecrets(token secrets.Secrets) error {
 	s.Lock()
+	// The previous secrets decrypt the stats sent before the update.
+	s.prevSecrets = s.Secrets
 	s.Secrets = token
 	s.Unlock()
 
@@ -177,6 +224,260 @@ func (s *ProxyInfo) SetTargetNetworks(networks []string) error {
 	return nil
 }
 
+// SetExcludedPorts does the RPC call for SetExcludedPorts to the remote
+// enforcers. The ports are kept for the enforcers started later.
+func (s *ProxyInfo) SetExcludedPorts(ports []string, report bool) error {
+
+	s.Lock()
+	s.excludedPorts = ports
+	s.reportExcludedPorts = report
+	s.Unlock()
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.SetExcludedPorts{
+			Ports:  ports,
+			Report: report,
+		},
+	}
+
+	for _, contextID := range s.rpchdl.ContextList() {
+		if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.SetExcludedPorts, request, resp); err != nil {
+			return fmt.Errorf("Failed to update excluded ports. status %s: %s", resp.Status, err)
+		}
+	}
+
+	return nil
+}
+
+// SetImplicitAllowNetworks sets the implicitly allowed networks of the
+// remote enforcers. They are sent to the enforcers when they are started.
+func (s *ProxyInfo) SetImplicitAllowNetworks(networks []string) error {
+
+	if err := acls.ValidateImplicitAllowNetworks(networks); err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.implicitAllowNetworks = networks
+	s.Unlock()
+
+	return nil
+}
+
+// SetUDPStateDir sets the directory where the remote enforcers persist their
+// established UDP connections. It is sent to the enforcers when they are
+// started.
+func (s *ProxyInfo) SetUDPStateDir(dir string) {
+
+	s.Lock()
+	s.udpStateDir = dir
+	s.Unlock()
+}
+
+// SetUDPHandshakeLimits sets the maximum number of half open UDP connections
+// of the remote enforcers and of every PU. They are sent to the enforcers
+// when they are started.
+func (s *ProxyInfo) SetUDPHandshakeLimits(total, perPU int) {
+
+	s.Lock()
+	s.udpHandshakeLimits = &rpcwrapper.UDPHandshakeLimits{
+		Total: total,
+		PerPU: perPU,
+	}
+	s.Unlock()
+}
+
+// SetAuditDir sets the directory where the remote enforcers append the
+// records of their policy decisions. It is sent to the enforcers when they
+// are started.
+func (s *ProxyInfo) SetAuditDir(dir string) {
+
+	s.Lock()
+	s.auditDir = dir
+	s.Unlock()
+}
+
+// EnableReverseDNS enables the reverse DNS lookups of the external addresses
+// of the remote enforcers, which also match the names against the DNS rules
+// if matchPolicy is set. It is sent to the enforcers when they are started.
+func (s *ProxyInfo) EnableReverseDNS(matchPolicy bool) {
+
+	s.Lock()
+	s.reverseDNS = true
+	s.reverseDNSPolicy = matchPolicy
+	s.Unlock()
+}
+
+// EnableProtocolHelpers enables the protocol helpers with the given names,
+// such as ftp, in the remote enforcers. It is sent to the enforcers when
+// they are started.
+func (s *ProxyInfo) EnableProtocolHelpers(names []string) error {
+
+	if err := nfqdatapath.ValidateProtocolHelpers(names); err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.protocolHelpers = names
+	s.Unlock()
+
+	return nil
+}
+
+// EnableServerNameInspection enables the server name inspection of the
+// external TLS flows in the remote enforcers. It is sent to the enforcers
+// when they are started.
+func (s *ProxyInfo) EnableServerNameInspection() {
+
+	s.Lock()
+	s.serverNameInspection = true
+	s.Unlock()
+}
+
+// SetUDPHandshakeTimeout sets the time after which the UDP handshakes of the
+// remote enforcers that did not complete are reported and torn down. It is
+// sent to the enforcers when they are started.
+func (s *ProxyInfo) SetUDPHandshakeTimeout(timeout time.Duration) {
+
+	s.Lock()
+	s.udpHandshakeTimeout = &timeout
+	s.Unlock()
+}
+
+// SetUDPKeyRotationInterval sets the lifetime of the keys of the UDP
+// connections of the remote enforcers. It is sent to the enforcers when they
+// are started.
+func (s *ProxyInfo) SetUDPKeyRotationInterval(interval time.Duration) {
+
+	s.Lock()
+	s.udpKeyRotationInterval = interval
+	s.Unlock()
+}
+
+// SetStatsFlowHash sets the name of the built-in flow hash function with
+// which the remote enforcers aggregate their flows. It is sent to the
+// enforcers when they are started.
+func (s *ProxyInfo) SetStatsFlowHash(name string) error {
+
+	if _, err := collector.FlowHashByName(name); err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.statsFlowHash = name
+	s.Unlock()
+
+	return nil
+}
+
+// UpdateAddressSet does the RPC call for UpdateAddressSet to the remote
+// enforcers. The address set is kept for the enforcers started later.
+func (s *ProxyInfo) UpdateAddressSet(name string, addresses []string) error {
+	return s.updateAddressSet(&rpcwrapper.AddressSetPayload{
+		Name:      name,
+		Addresses: addresses,
+	})
+}
+
+// RemoveAddressSet does the RPC call for UpdateAddressSet to the remote
+// enforcers with the removal of the address set.
+func (s *ProxyInfo) RemoveAddressSet(name string) error {
+	return s.updateAddressSet(&rpcwrapper.AddressSetPayload{
+		Name:   name,
+		Remove: true,
+	})
+}
+
+func (s *ProxyInfo) updateAddressSet(payload *rpcwrapper.AddressSetPayload) error {
+
+	// The map is copied since the initialization payloads share it.
+	s.Lock()
+	addressSets := make(map[string][]string, len(s.addressSets)+1)
+	for name, addresses := range s.addressSets {
+		addressSets[name] = addresses
+	}
+	if payload.Remove {
+		delete(addressSets, payload.Name)
+	} else {
+		addressSets[payload.Name] = payload.Addresses
+	}
+	s.addressSets = addressSets
+	s.Unlock()
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{
+		Payload: payload,
+	}
+
+	for _, contextID := range s.rpchdl.ContextList() {
+		if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.UpdateAddressSet, request, resp); err != nil {
+			return fmt.Errorf("Failed to update address set %s. status %s: %s", payload.Name, resp.Status, err)
+		}
+	}
+
+	return nil
+}
+
+// ExportPUState does the RPC call for ExportPUState to the remote enforcer
+// of the PU.
+func (s *ProxyInfo) ExportPUState(contextID string) ([]byte, error) {
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.ExportPUStatePayload{
+			ContextID: contextID,
+		},
+	}
+
+	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.ExportPUState, request, resp); err != nil {
+		return nil, fmt.Errorf("Failed to export pu state. status %s: %s", resp.Status, err)
+	}
+
+	state, ok := resp.Payload.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid pu state from remote enforcer: %T", resp.Payload)
+	}
+
+	return state, nil
+}
+
+// ExportConntrackUpdates does the RPC call for ExportConntrackUpdates to the
+// remote enforcer of the PU.
+func (s *ProxyInfo) ExportConntrackUpdates(contextID string) ([]byte, error) {
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{}
+
+	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.ExportConntrackUpdates, request, resp); err != nil {
+		return nil, fmt.Errorf("Failed to export conntrack updates. status %s: %s", resp.Status, err)
+	}
+
+	updates, ok := resp.Payload.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid conntrack updates from remote enforcer: %T", resp.Payload)
+	}
+
+	return updates, nil
+}
+
+// DrainPU does the RPC call for DrainPU to the remote enforcer of the PU.
+func (s *ProxyInfo) DrainPU(contextID string) error {
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.DrainPUPayload{
+			ContextID: contextID,
+		},
+	}
+
+	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.DrainPU, request, resp); err != nil {
+		return fmt.Errorf("Failed to drain pu. status %s: %s", resp.Status, err)
+	}
+
+	return nil
+}
+
 // GetFilterQueue returns the current FilterQueueConfig.
 func (s *ProxyInfo) GetFilterQueue() *fqconfig.FilterQueue {
 	return s.filterQueue
@@ -191,11 +492,47 @@ func (s *ProxyInfo) GetPortSetInstance() portset.PortSet {
 func (s *ProxyInfo) Run(ctx context.Context) error {
 
 	statsServer := rpcwrapper.NewRPCWrapper()
-	rpcServer := &StatsServer{rpchdl: statsServer, collector: s.collector, secret: s.statsServerSecret}
+	rpcServer := &StatsServer{rpchdl: statsServer, collector: s.collector, secret: s.statsServerSecret, secrets: s.statsSecrets}
 
 	// Start the server for statistics collection.
 	go statsServer.StartServer(ctx, "unix", rpcwrapper.StatsChannel, rpcServer) // nolint
 
+	s.readyOnce.Do(func() {
+		close(s.ready)
+	})
+
+	return nil
+}
+
+// statsSecrets returns the secrets that decrypt the stats of the remote
+// enforcers, the current ones first.
+func (s *ProxyInfo) statsSecrets() []secrets.Secrets {
+
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.prevSecrets == nil {
+		return []secrets.Secrets{s.Secrets}
+	}
+
+	return []secrets.Secrets{s.Secrets, s.prevSecrets}
+}
+
+// Ready returns a channel that is closed when the proxy is started. The
+// remote enforcers are started on demand for every PU.
+func (s *ProxyInfo) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Healthy returns an error if the proxy is not started.
+func (s *ProxyInfo) Healthy() error {
+
+	select {
+	case <-s.ready:
+	default:
+		return errors.New("enforcer proxy is not ready")
+	}
+
 	return nil
 }
 
@@ -207,12 +544,14 @@ func NewProxyEnforcer(mutualAuth bool,
 	secrets secrets.Secrets,
 	serverID string,
 	validity time.Duration,
+	clockSkew time.Duration,
 	rpchdl rpcwrapper.RPCClient,
 	cmdArg string,
 	procMountPoint string,
 	ExternalIPCacheTimeout time.Duration,
 	packetLogs bool,
 	targetNetworks []string,
+	encryptStats bool,
 ) enforcer.Enforcer {
 	return newProxyEnforcer(
 		mutualAuth,
@@ -222,6 +561,7 @@ func NewProxyEnforcer(mutualAuth bool,
 		secrets,
 		serverID,
 		validity,
+		clockSkew,
 		rpchdl,
 		cmdArg,
 		processmon.GetProcessManagerHdl(),
@@ -230,6 +570,7 @@ func NewProxyEnforcer(mutualAuth bool,
 		nil,
 		packetLogs,
 		targetNetworks,
+		encryptStats,
 	)
 }
 
@@ -241,6 +582,7 @@ func newProxyEnforcer(mutualAuth bool,
 	secrets secrets.Secrets,
 	serverID string,
 	validity time.Duration,
+	clockSkew time.Duration,
 	rpchdl rpcwrapper.RPCClient,
 	cmdArg string,
 	procHdl processmon.ProcessManager,
@@ -249,6 +591,7 @@ func newProxyEnforcer(mutualAuth bool,
 	portSetInstance portset.PortSet,
 	packetLogs bool,
 	targetNetworks []string,
+	encryptStats bool,
 ) enforcer.Enforcer {
 
 	statsServersecret, err := crypto.GenerateRandomString(32)
@@ -264,6 +607,7 @@ func newProxyEnforcer(mutualAuth bool,
 		Secrets:                secrets,
 		serverID:               serverID,
 		validity:               validity,
+		clockSkew:              clockSkew,
 		prochdl:                procHdl,
 		rpchdl:                 rpchdl,
 		initDone:               make(map[string]bool),
@@ -276,6 +620,8 @@ func newProxyEnforcer(mutualAuth bool,
 		portSetInstance:        portSetInstance,
 		collector:              collector,
 		targetNetworks:         targetNetworks,
+		encryptStats:           encryptStats,
+		ready:                  make(chan struct{}),
 	}
 
 	return proxydata
@@ -306,12 +652,14 @@ func NewDefaultProxyEnforcer(serverID string,
 		secrets,
 		serverID,
 		validity,
+		tokens.DefaultClockSkew,
 		rpchdl,
 		constants.DefaultRemoteArg,
 		procMountPoint,
 		defaultExternalIPCacheTimeout,
 		defaultPacketLogs,
 		targetNetworks,
+		false,
 	)
 }
 
@@ -320,6 +668,8 @@ type StatsServer struct {
 	collector collector.EventCollector
 	rpchdl    rpcwrapper.RPCServer
 	secret    string
+	// secrets returns the secrets that decrypt the encrypted stats.
+	secrets func() []secrets.Secrets
 }
 
 // GetStats is the function called from the remoteenforcer when it has new flow events to publish.
@@ -329,7 +679,20 @@ func (r *StatsServer) GetStats(req rpcwrapper.Request, resp *rpcwrapper.Response
 		return errors.New("message sender cannot be verified")
 	}
 
-	payload := req.Payload.(rpcwrapper.StatsPayload)
+	var payload rpcwrapper.StatsPayload
+	switch p := req.Payload.(type) {
+	case rpcwrapper.StatsPayload:
+		// The remote enforcers that don't encrypt the stats send them in clear.
+		payload = p
+	case rpcwrapper.EncryptedStatsPayload:
+		decrypted, err := r.decryptStats(&p)
+		if err != nil {
+			return err
+		}
+		payload = *decrypted
+	default:
+		return fmt.Errorf("unexpected stats payload: %T", req.Payload)
+	}
 
 	for _, record := range payload.Flows {
 		r.collector.CollectFlowEvent(record)
@@ -339,5 +702,36 @@ func (r *StatsServer) GetStats(req rpcwrapper.Request, resp *rpcwrapper.Response
 		r.collector.CollectUserEvent(record)
 	}
 
+	for _, record := range payload.DNS {
+		r.collector.CollectDNSEvent(record)
+	}
+
+	for _, record := range payload.Health {
+		r.collector.CollectHealthEvent(record)
+	}
+
 	return nil
 }
+
+// decryptStats decrypts the stats with the current secrets, or with the
+// previous ones if the remote enforcer has not received the update yet.
+func (r *StatsServer) decryptStats(payload *rpcwrapper.EncryptedStatsPayload) (*rpcwrapper.StatsPayload, error) {
+
+	if r.secrets == nil {
+		return nil, errors.New("no secrets to decrypt the stats")
+	}
+
+	var err error
+	for _, s := range r.secrets() {
+		var key []byte
+		if key, err = rpcwrapper.StatsKey(s); err != nil {
+			continue
+		}
+		var stats *rpcwrapper.StatsPayload
+		if stats, err = rpcwrapper.DecryptStats(key, payload); err == nil {
+			return stats, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to decrypt the stats: %s", err)
+}
@@ -2,10 +2,12 @@ package enforcerproxy
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"testing"
 	"time"
 
 	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/collector/mockcollector"
 	"go.aporeto.io/trireme-lib/controller/constants"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
@@ -15,6 +17,7 @@ import (
 	"go.aporeto.io/trireme-lib/controller/pkg/fqconfig"
 	"go.aporeto.io/trireme-lib/controller/pkg/remoteenforcer"
 	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+	"go.aporeto.io/trireme-lib/controller/pkg/tokens"
 	"go.aporeto.io/trireme-lib/policy"
 
 	gomock "github.com/golang/mock/gomock"
@@ -134,6 +137,7 @@ func setupProxyEnforcer(rpchdl rpcwrapper.RPCClient, prochdl processmon.ProcessM
 		secretGen(nil, nil, nil),
 		"testServerID",
 		validity,
+		tokens.DefaultClockSkew,
 		rpchdl,
 		constants.DefaultRemoteArg,
 		prochdl,
@@ -141,7 +145,8 @@ func setupProxyEnforcer(rpchdl rpcwrapper.RPCClient, prochdl processmon.ProcessM
 		defaultExternalIPCacheTimeout,
 		nil,
 		false,
-		[]string{"0.0.0.0/0"})
+		[]string{"0.0.0.0/0"},
+		false)
 	return policyEnf
 }
 
@@ -323,6 +328,488 @@ func TestSetTargetNetworks(t *testing.T) {
 	})
 }
 
+func TestSetExcludedPorts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I call SetExcludedPorts with a running remote enforcer", func() {
+			rpchdl.EXPECT().ContextList().Return([]string{"testServerID"})
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.SetExcludedPorts, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			err := policyEnf.(*ProxyInfo).SetExcludedPorts([]string{"10250"}, true)
+
+			Convey("Then I should not get any error", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("When I initiate a new remote enforcer, it should get the excluded ports", func() {
+				var payload *rpcwrapper.InitRequestPayload
+				rpchdl.EXPECT().RemoteCall("otherServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+					func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+						payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+					}).Return(nil)
+
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("otherServerID"), ShouldBeNil)
+				So(payload.ExcludedPorts, ShouldResemble, []string{"10250"})
+				So(payload.ReportExcludedPorts, ShouldBeTrue)
+			})
+		})
+
+		Convey("When the remote call fails, I should get an error", func() {
+			rpchdl.EXPECT().ContextList().Return([]string{"testServerID"})
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.SetExcludedPorts, gomock.Any(), gomock.Any()).Times(1).Return(errors.New("error"))
+
+			So(policyEnf.(*ProxyInfo).SetExcludedPorts([]string{"10250"}, false), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSetImplicitAllowNetworks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I set the implicitly allowed networks", func() {
+			So(policyEnf.(*ProxyInfo).SetImplicitAllowNetworks([]string{"169.254.0.0/16"}), ShouldBeNil)
+
+			Convey("When I initiate a new remote enforcer, it should get the networks", func() {
+				var payload *rpcwrapper.InitRequestPayload
+				rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+					func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+						payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+					}).Return(nil)
+
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+				So(payload.ImplicitAllowNetworks, ShouldResemble, []string{"169.254.0.0/16"})
+			})
+		})
+
+		Convey("When I set invalid networks, I should get an error", func() {
+			So(policyEnf.(*ProxyInfo).SetImplicitAllowNetworks([]string{"::1/128"}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSetUDPStateDir(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I set the udp state directory", func() {
+			policyEnf.(*ProxyInfo).SetUDPStateDir("/var/run/trireme")
+
+			Convey("When I initiate a new remote enforcer, it should get the directory", func() {
+				var payload *rpcwrapper.InitRequestPayload
+				rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+					func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+						payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+					}).Return(nil)
+
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+				So(payload.UDPStateDir, ShouldEqual, "/var/run/trireme")
+			})
+		})
+	})
+}
+
+func TestSetAuditDir(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I set the audit directory", func() {
+			policyEnf.(*ProxyInfo).SetAuditDir("/var/log/trireme")
+
+			Convey("When I initiate a new remote enforcer, it should get the directory", func() {
+				var payload *rpcwrapper.InitRequestPayload
+				rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+					func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+						payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+					}).Return(nil)
+
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+				So(payload.AuditDir, ShouldEqual, "/var/log/trireme")
+			})
+		})
+	})
+}
+
+func TestEnableReverseDNS(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		var payload *rpcwrapper.InitRequestPayload
+		rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+			func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+				payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+			}).Return(nil)
+
+		Convey("When I initiate a new remote enforcer, it should not resolve the addresses", func() {
+			So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+			So(payload.ReverseDNS, ShouldBeFalse)
+		})
+
+		Convey("When I enable the reverse dns lookups with the policy", func() {
+			policyEnf.(*ProxyInfo).EnableReverseDNS(true)
+
+			Convey("When I initiate a new remote enforcer, it should get them", func() {
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+				So(payload.ReverseDNS, ShouldBeTrue)
+				So(payload.ReverseDNSPolicy, ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestEnableProtocolHelpers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I enable the ftp helper", func() {
+			So(policyEnf.(*ProxyInfo).EnableProtocolHelpers([]string{"ftp"}), ShouldBeNil)
+
+			Convey("When I initiate a new remote enforcer, it should get the helper", func() {
+				var payload *rpcwrapper.InitRequestPayload
+				rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+					func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+						payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+					}).Return(nil)
+
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+				So(payload.ProtocolHelpers, ShouldResemble, []string{"ftp"})
+			})
+		})
+
+		Convey("When I enable an unknown helper, I should get an error", func() {
+			So(policyEnf.(*ProxyInfo).EnableProtocolHelpers([]string{"sip"}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestEnableServerNameInspection(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I enable the server name inspection", func() {
+			policyEnf.(*ProxyInfo).EnableServerNameInspection()
+
+			Convey("When I initiate a new remote enforcer, it should get it", func() {
+				var payload *rpcwrapper.InitRequestPayload
+				rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+					func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+						payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+					}).Return(nil)
+
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+				So(payload.ServerNameInspection, ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestSetUDPHandshakeLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		var payload *rpcwrapper.InitRequestPayload
+		rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+			func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+				payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+			}).Return(nil)
+
+		Convey("When I set the udp handshake limits, a new remote enforcer should get them", func() {
+			policyEnf.(*ProxyInfo).SetUDPHandshakeLimits(1000, 0)
+
+			So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+			So(payload.UDPHandshakeLimits, ShouldResemble, &rpcwrapper.UDPHandshakeLimits{Total: 1000})
+		})
+
+		Convey("When I don't set the udp handshake limits, a new remote enforcer should keep its defaults", func() {
+			So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+			So(payload.UDPHandshakeLimits, ShouldBeNil)
+		})
+	})
+}
+
+func TestSetUDPHandshakeTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		var payload *rpcwrapper.InitRequestPayload
+		rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+			func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+				payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+			}).Return(nil)
+
+		Convey("When I initiate a new remote enforcer, it should keep its default timeout", func() {
+			So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+			So(payload.UDPHandshakeTimeout, ShouldBeNil)
+		})
+
+		Convey("When I disable the udp handshake timeout", func() {
+			policyEnf.(*ProxyInfo).SetUDPHandshakeTimeout(0)
+
+			Convey("When I initiate a new remote enforcer, it should get the timeout", func() {
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+				So(payload.UDPHandshakeTimeout, ShouldNotBeNil)
+				So(*payload.UDPHandshakeTimeout, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestSetStatsFlowHash(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I set the stats flow hash", func() {
+			So(policyEnf.(*ProxyInfo).SetStatsFlowHash(collector.FlowHashCollapseSourcePort), ShouldBeNil)
+
+			Convey("When I initiate a new remote enforcer, it should get the flow hash", func() {
+				var payload *rpcwrapper.InitRequestPayload
+				rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+					func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+						payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+					}).Return(nil)
+
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+				So(payload.StatsFlowHash, ShouldEqual, collector.FlowHashCollapseSourcePort)
+			})
+		})
+
+		Convey("When I set an unknown stats flow hash, I should get an error", func() {
+			So(policyEnf.(*ProxyInfo).SetStatsFlowHash("unknown"), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestExportPUState(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I export the state of a pu, I should get the state of its remote enforcer", func() {
+			var payload *rpcwrapper.ExportPUStatePayload
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.ExportPUState, gomock.Any(), gomock.Any()).Times(1).Do(
+				func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					payload = req.Payload.(*rpcwrapper.ExportPUStatePayload)
+					resp.Payload = []byte(`{"ContextID":"testServerID"}`)
+				}).Return(nil)
+
+			state, err := policyEnf.(*ProxyInfo).ExportPUState("testServerID")
+			So(err, ShouldBeNil)
+			So(string(state), ShouldEqual, `{"ContextID":"testServerID"}`)
+			So(payload.ContextID, ShouldEqual, "testServerID")
+		})
+
+		Convey("When the remote call fails, I should get an error", func() {
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.ExportPUState, gomock.Any(), gomock.Any()).Times(1).Return(errors.New("error"))
+
+			_, err := policyEnf.(*ProxyInfo).ExportPUState("testServerID")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the remote enforcer returns no state, I should get an error", func() {
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.ExportPUState, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+
+			_, err := policyEnf.(*ProxyInfo).ExportPUState("testServerID")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestExportConntrackUpdates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I export the conntrack updates of a pu, I should get the updates of its remote enforcer", func() {
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.ExportConntrackUpdates, gomock.Any(), gomock.Any()).Times(1).Do(
+				func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					resp.Payload = []byte(`[{"SourcePort":53}]`)
+				}).Return(nil)
+
+			updates, err := policyEnf.(*ProxyInfo).ExportConntrackUpdates("testServerID")
+			So(err, ShouldBeNil)
+			So(string(updates), ShouldEqual, `[{"SourcePort":53}]`)
+		})
+
+		Convey("When the remote call fails, I should get an error", func() {
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.ExportConntrackUpdates, gomock.Any(), gomock.Any()).Times(1).Return(errors.New("error"))
+
+			_, err := policyEnf.(*ProxyInfo).ExportConntrackUpdates("testServerID")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDrainPU(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		Convey("When I drain a pu, its remote enforcer should drain it", func() {
+			var payload *rpcwrapper.DrainPUPayload
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.DrainPU, gomock.Any(), gomock.Any()).Times(1).Do(
+				func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					payload = req.Payload.(*rpcwrapper.DrainPUPayload)
+				}).Return(nil)
+
+			So(policyEnf.(*ProxyInfo).DrainPU("testServerID"), ShouldBeNil)
+			So(payload.ContextID, ShouldEqual, "testServerID")
+		})
+
+		Convey("When the remote call fails, I should get an error", func() {
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.DrainPU, gomock.Any(), gomock.Any()).Times(1).Return(errors.New("error"))
+
+			So(policyEnf.(*ProxyInfo).DrainPU("testServerID"), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestStatsServer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I start a stats server with encrypted stats", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		proxy := setupProxyEnforcer(rpchdl, prochdl).(*ProxyInfo)
+		proxy.encryptStats = true
+
+		statshdl := mockrpcwrapper.NewMockRPCServer(ctrl)
+		statshdl.EXPECT().ProcessMessage(gomock.Any(), gomock.Any()).AnyTimes().Return(true)
+		eventCollector := mockcollector.NewMockEventCollector(ctrl)
+		server := &StatsServer{rpchdl: statshdl, collector: eventCollector, secrets: proxy.statsSecrets}
+
+		stats := &rpcwrapper.StatsPayload{
+			Flows: map[string]*collector.FlowRecord{
+				"flow": {ContextID: "testServerID", Count: 1},
+			},
+		}
+
+		encrypt := func(s secrets.Secrets) rpcwrapper.EncryptedStatsPayload {
+			key, err := rpcwrapper.StatsKey(s)
+			So(err, ShouldBeNil)
+			payload, err := rpcwrapper.EncryptStats(key, stats)
+			So(err, ShouldBeNil)
+			return *payload
+		}
+
+		Convey("When I initiate a remote enforcer, it should be asked to encrypt the stats", func() {
+			var payload *rpcwrapper.InitRequestPayload
+			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+				func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+				}).Return(nil)
+
+			So(proxy.InitRemoteEnforcer("testServerID"), ShouldBeNil)
+			So(payload.EncryptStats, ShouldBeTrue)
+		})
+
+		Convey("When I receive stats in clear, they should be collected", func() {
+			eventCollector.EXPECT().CollectFlowEvent(stats.Flows["flow"]).Times(1)
+
+			err := server.GetStats(rpcwrapper.Request{Payload: *stats}, &rpcwrapper.Response{})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When I receive health events, they should be collected", func() {
+			record := &collector.HealthRecord{Component: "nfqueue", Critical: true, Message: "queues [0] are no longer bound"}
+			eventCollector.EXPECT().CollectHealthEvent(record).Times(1)
+
+			payload := rpcwrapper.StatsPayload{Health: []*collector.HealthRecord{record}}
+			So(server.GetStats(rpcwrapper.Request{Payload: payload}, &rpcwrapper.Response{}), ShouldBeNil)
+		})
+
+		Convey("When I receive encrypted stats, they should be decrypted and collected", func() {
+			eventCollector.EXPECT().CollectFlowEvent(gomock.Any()).Times(1).Do(func(record *collector.FlowRecord) {
+				So(record.ContextID, ShouldEqual, "testServerID")
+				So(record.Count, ShouldEqual, 1)
+			})
+
+			err := server.GetStats(rpcwrapper.Request{Payload: encrypt(proxy.Secrets)}, &rpcwrapper.Response{})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When I receive stats encrypted with the previous secrets, they should be collected", func() {
+			previous := proxy.Secrets
+			rpchdl.EXPECT().ContextList().Return([]string{})
+			So(proxy.UpdateSecrets(secrets.NewPSKSecrets([]byte("New Test Password"))), ShouldBeNil)
+
+			eventCollector.EXPECT().CollectFlowEvent(gomock.Any()).Times(2)
+
+			So(server.GetStats(rpcwrapper.Request{Payload: encrypt(previous)}, &rpcwrapper.Response{}), ShouldBeNil)
+			So(server.GetStats(rpcwrapper.Request{Payload: encrypt(proxy.Secrets)}, &rpcwrapper.Response{}), ShouldBeNil)
+		})
+
+		Convey("When I receive stats encrypted with other secrets, I should get an error", func() {
+			payload := encrypt(secrets.NewPSKSecrets([]byte("Other Test Password")))
+
+			err := server.GetStats(rpcwrapper.Request{Payload: payload}, &rpcwrapper.Response{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
 func TestUnenforce(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -356,3 +843,34 @@ func TestUnenforce(t *testing.T) {
 		})
 	})
 }
+
+func TestSetUDPKeyRotationInterval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to start a proxy enforcer with defaults", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl)
+
+		var payload *rpcwrapper.InitRequestPayload
+		rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+			func(contextID string, method string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+				payload = req.Payload.(*rpcwrapper.InitRequestPayload)
+			}).Return(nil)
+
+		Convey("When I initiate a new remote enforcer, it should not rotate the udp keys", func() {
+			So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+			So(payload.UDPKeyRotationInterval, ShouldEqual, 0)
+		})
+
+		Convey("When I set the udp key rotation interval", func() {
+			policyEnf.(*ProxyInfo).SetUDPKeyRotationInterval(30 * time.Minute)
+
+			Convey("When I initiate a new remote enforcer, it should get the interval", func() {
+				So(policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID"), ShouldBeNil)
+				So(payload.UDPKeyRotationInterval, ShouldEqual, 30*time.Minute)
+			})
+		})
+	})
+}
@@ -267,6 +267,12 @@ func RegisterTypes() {
 	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.Supervise_Request_Payload", *(&SuperviseRequestPayload{}))
 	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.UnSupervise_Payload", *(&UnSupervisePayload{}))
 	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.Stats_Payload", *(&StatsPayload{}))
+	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.Encrypted_Stats_Payload", *(&EncryptedStatsPayload{}))
 	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.UpdateSecrets_Payload", *(&UpdateSecretsPayload{}))
 	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.SetTarget_Networks", *(&SetTargetNetworks{}))
+	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.SetExcluded_Ports", *(&SetExcludedPorts{}))
+	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.AddressSet_Payload", *(&AddressSetPayload{}))
+	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.ExportPUState_Payload", *(&ExportPUStatePayload{}))
+	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.PolicyVersion_Payload", *(&PolicyVersionPayload{}))
+	gob.RegisterName("go.aporeto.io/enforcer/utils/rpcwrapper.DrainPU_Payload", *(&DrainPUPayload{}))
 }
@@ -0,0 +1,104 @@
+package rpcwrapper
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+)
+
+// StatsKey derives the key used to encrypt the stats from the private key
+// of the secrets. The controller and the remote enforcers share the same
+// secrets, so they derive the same key.
+func StatsKey(s secrets.Secrets) ([]byte, error) {
+
+	if s == nil {
+		return nil, errors.New("no secrets")
+	}
+
+	var material []byte
+	switch key := s.EncodingKey().(type) {
+	case []byte:
+		material = key
+	case *ecdsa.PrivateKey:
+		material = key.D.Bytes()
+	default:
+		return nil, fmt.Errorf("unsupported key for stats encryption: %T", key)
+	}
+
+	if len(material) == 0 {
+		return nil, errors.New("empty key")
+	}
+
+	sum := sha256.Sum256(append([]byte("trireme-stats:"), material...))
+
+	return sum[:], nil
+}
+
+// EncryptStats encrypts the stats payload with the key.
+func EncryptStats(key []byte, payload *StatsPayload) (*EncryptedStatsPayload, error) {
+
+	aead, err := statsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(payload); err != nil {
+		return nil, fmt.Errorf("unable to encode stats: %s", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %s", err)
+	}
+
+	return &EncryptedStatsPayload{
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, buf.Bytes(), nil),
+	}, nil
+}
+
+// DecryptStats decrypts the stats payload with the key.
+func DecryptStats(key []byte, payload *EncryptedStatsPayload) (*StatsPayload, error) {
+
+	aead, err := statsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payload.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	data, err := aead.Open(nil, payload.Nonce, payload.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt stats: %s", err)
+	}
+
+	stats := &StatsPayload{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(stats); err != nil {
+		return nil, fmt.Errorf("unable to decode stats: %s", err)
+	}
+
+	return stats, nil
+}
+
+// statsCipher returns the AES-GCM cipher of the key.
+func statsCipher(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stats key: %s", err)
+	}
+
+	return cipher.NewGCM(block)
+}
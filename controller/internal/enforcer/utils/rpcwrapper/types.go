@@ -32,9 +32,10 @@ const (
 )
 
 //Response is the response for every RPC call. This is used to carry the status of the actual function call
-//made on the remote end
+//made on the remote end, and its result in the payload if it has one
 type Response struct {
-	Status string
+	Status  string
+	Payload interface{}
 }
 
 //InitRequestPayload Payload for enforcer init request
@@ -43,10 +44,33 @@ type InitRequestPayload struct {
 	MutualAuth             bool                  `json:",omitempty"`
 	PacketLogs             bool                  `json:",omitempty"`
 	Validity               time.Duration         `json:",omitempty"`
+	ClockSkew              time.Duration         `json:",omitempty"`
 	ServerID               string                `json:",omitempty"`
 	ExternalIPCacheTimeout time.Duration         `json:",omitempty"`
 	Secrets                secrets.PublicSecrets `json:",omitempty"`
 	TargetNetworks         []string              `json:",omitempty"`
+	ExcludedPorts          []string              `json:",omitempty"`
+	ReportExcludedPorts    bool                  `json:",omitempty"`
+	EncryptStats           bool                  `json:",omitempty"`
+	AddressSets            map[string][]string   `json:",omitempty"`
+	ImplicitAllowNetworks  []string              `json:",omitempty"`
+	UDPStateDir            string                `json:",omitempty"`
+	UDPHandshakeLimits     *UDPHandshakeLimits   `json:",omitempty"`
+	StatsFlowHash          string                `json:",omitempty"`
+	AuditDir               string                `json:",omitempty"`
+	ReverseDNS             bool                  `json:",omitempty"`
+	ReverseDNSPolicy       bool                  `json:",omitempty"`
+	ProtocolHelpers        []string              `json:",omitempty"`
+	ServerNameInspection   bool                  `json:",omitempty"`
+	UDPHandshakeTimeout    *time.Duration        `json:",omitempty"`
+	UDPKeyRotationInterval time.Duration         `json:",omitempty"`
+}
+
+// UDPHandshakeLimits are the maximum numbers of half open UDP connections of
+// an enforcer and of every PU
+type UDPHandshakeLimits struct {
+	Total int `json:",omitempty"`
+	PerPU int `json:",omitempty"`
 }
 
 // UpdateSecretsPayload payload for the update secrets to remote enforcers
@@ -56,8 +80,10 @@ type UpdateSecretsPayload struct {
 
 //InitSupervisorPayload for supervisor init request
 type InitSupervisorPayload struct {
-	TriremeNetworks []string    `json:",omitempty"`
-	CaptureMethod   CaptureType `json:",omitempty"`
+	TriremeNetworks []string            `json:",omitempty"`
+	CaptureMethod   CaptureType         `json:",omitempty"`
+	ExcludedPorts   []string            `json:",omitempty"`
+	AddressSets     map[string][]string `json:",omitempty"`
 }
 
 // EnforcePayload Payload for enforce request
@@ -105,8 +131,16 @@ type UnEnforceResponsePayload struct {
 
 //StatsPayload is the payload carries by the stats reporting form the remote enforcer
 type StatsPayload struct {
-	Flows map[string]*collector.FlowRecord `json:",omitempty"`
-	Users map[string]*collector.UserRecord `json:",omitempty"`
+	Flows  map[string]*collector.FlowRecord `json:",omitempty"`
+	Users  map[string]*collector.UserRecord `json:",omitempty"`
+	DNS    map[string]*collector.DNSRecord  `json:",omitempty"`
+	Health []*collector.HealthRecord        `json:",omitempty"`
+}
+
+//EncryptedStatsPayload is the stats payload encrypted with the secrets of the enforcer
+type EncryptedStatsPayload struct {
+	Nonce []byte `json:",omitempty"`
+	Data  []byte `json:",omitempty"`
 }
 
 //ExcludeIPRequestPayload carries the list of excluded ips
@@ -118,3 +152,34 @@ type ExcludeIPRequestPayload struct {
 type SetTargetNetworks struct {
 	TargetNetworks []string `json:",omitempty"`
 }
+
+//SetExcludedPorts carries the payload for the excluded ports
+type SetExcludedPorts struct {
+	Ports  []string `json:",omitempty"`
+	Report bool     `json:",omitempty"`
+}
+
+//AddressSetPayload carries the addresses of an address set, or the request
+//to remove them.
+type AddressSetPayload struct {
+	Name      string   `json:",omitempty"`
+	Addresses []string `json:",omitempty"`
+	Remove    bool     `json:",omitempty"`
+}
+
+//ExportPUStatePayload carries the payload of the request of the state of a PU.
+//The state is returned as JSON in the payload of the response.
+type ExportPUStatePayload struct {
+	ContextID string `json:",omitempty"`
+}
+
+//PolicyVersionPayload carries the payload of the request of the version of
+//the policy of a PU. The version is returned in the payload of the response.
+type PolicyVersionPayload struct {
+	ContextID string `json:",omitempty"`
+}
+
+//DrainPUPayload carries the payload of the request to drain a PU.
+type DrainPUPayload struct {
+	ContextID string `json:",omitempty"`
+}
@@ -22,8 +22,24 @@ type Supervisor interface {
 	// SetTargetNetworks sets the target networks of the supervisor
 	SetTargetNetworks([]string) error
 
+	// SetExcludedPorts sets the ports whose flows bypass the enforcement
+	SetExcludedPorts([]string) error
+
+	// UpdateAddressSet sets the addresses of the given address set
+	UpdateAddressSet(name string, addresses []string) error
+
+	// RemoveAddressSet removes the addresses of the given address set
+	RemoveAddressSet(name string) error
+
 	// CleanUp requests the supervisor to clean up all ACLs
 	CleanUp() error
+
+	// PolicyVersion returns the version of the rules installed for the given PU
+	PolicyVersion(contextID string) (int, error)
+
+	// Validate builds the rules of the PU without installing them and returns
+	// the first error.
+	Validate(contextID string, puInfo *policy.PUInfo) error
 }
 
 // Implementor is the interface of the implementation based on iptables, ipsets, remote etc
@@ -35,12 +51,24 @@ type Implementor interface {
 	// UpdateRules updates the rules with a new version
 	UpdateRules(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) error
 
+	// ValidateRules builds the rules of the PU without installing them
+	ValidateRules(version int, contextID string, containerInfo *policy.PUInfo) error
+
 	// DeleteRules
 	DeleteRules(version int, context string, tcpPorts, udpPorts string, mark string, uid string, proxyPort string) error
 
 	// SetTargetNetworks sets the target networks of the supervisor
 	SetTargetNetworks([]string, []string) error
 
+	// SetExcludedPorts sets the ports whose flows bypass the enforcement
+	SetExcludedPorts([]string) error
+
+	// UpdateAddressSet sets the addresses of the given address set
+	UpdateAddressSet(name string, addresses []string) error
+
+	// RemoveAddressSet removes the addresses of the given address set
+	RemoveAddressSet(name string) error
+
 	// Start initializes any defaults
 	Run(ctx context.Context) error
 
@@ -250,8 +250,10 @@ func (i *Instance) proxyRules(appChain string, netChain string, port string, pro
 	return proxyrules
 }
 
-//trapRules provides the packet trap rules to add/delete
-func (i *Instance) trapRules(appChain string, netChain string) [][]string {
+//trapRules provides the packet trap rules to add/delete. The UDP packets and
+// the TCP packets from the network are only trapped for the target networks
+// of the set.
+func (i *Instance) trapRules(appChain string, netChain string, targetSetName string) [][]string {
 
 	rules := [][]string{}
 
@@ -288,7 +290,7 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", targetSetName, "dst",
 		"-p", "udp",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
 	})
@@ -307,21 +309,21 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Network Packets - SYN
 	rules = append(rules, []string{
 		i.netPacketIPTableContext, netChain,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
 	})
 	// Network Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 	rules = append(rules, []string{
 		i.netPacketIPTableContext, netChain,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", targetSetName, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
 	})
 
 	rules = append(rules, []string{
 		i.netPacketIPTableContext, netChain,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", targetSetName, "src",
 		"-p", "udp",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
 	})
@@ -420,10 +422,100 @@ func (i *Instance) addChainRules(portSetName string, appChain string, netChain s
 }
 
 // addPacketTrap adds the necessary iptables rules to capture control packets to user space
-func (i *Instance) addPacketTrap(appChain string, netChain string, networks []string) error {
+func (i *Instance) addPacketTrap(appChain string, netChain string, targetSetName string, direction policy.EnforcementDirection) error {
+
+	return i.processRulesFromList(append(i.directionRules(appChain, netChain, direction), i.trapRules(appChain, netChain, targetSetName)...), "Append")
+
+}
+
+// directionRules accepts the flows initiated in a direction that the PU does
+// not enforce before they are trapped. The packets of the flows initiated by
+// the PU are in the original direction in the application chain and in the
+// reply direction in the network chain, and the other way around for the
+// flows initiated by the network. The replies of the flows initiated in the
+// enforced direction are still trapped.
+func (i *Instance) directionRules(appChain string, netChain string, direction policy.EnforcementDirection) [][]string {
+
+	rules := [][]string{}
+
+	if !direction.Egress() {
+		rules = append(rules, []string{
+			i.appPacketIPTableContext, appChain,
+			"-m", "conntrack", "--ctdir", "ORIGINAL",
+			"-j", "ACCEPT",
+		}, []string{
+			i.netPacketIPTableContext, netChain,
+			"-m", "conntrack", "--ctdir", "REPLY",
+			"-j", "ACCEPT",
+		})
+	}
+
+	if !direction.Ingress() {
+		rules = append(rules, []string{
+			i.netPacketIPTableContext, netChain,
+			"-m", "conntrack", "--ctdir", "ORIGINAL",
+			"-j", "ACCEPT",
+		}, []string{
+			i.appPacketIPTableContext, appChain,
+			"-m", "conntrack", "--ctdir", "REPLY",
+			"-j", "ACCEPT",
+		})
+	}
+
+	return rules
+}
 
-	return i.processRulesFromList(i.trapRules(appChain, netChain), "Append")
+// aclRuleSpec adapts a rule spec to the address set and the source port of
+// the rule. The address of the rule is replaced by a match of the ipset of
+// its address set. The spec matches the packets to the port of the rule with
+// --dport, or the replies from the port of the rule with --sport, and the
+// source port is matched on the other side of the flow.
+func aclRuleSpec(rule policy.IPRule, spec ...string) []string {
 
+	if rule.SourcePort == "" && rule.AddressSet == "" {
+		return spec
+	}
+
+	out := make([]string, 0, len(spec)+4)
+	for k := 0; k < len(spec); k++ {
+		if k+1 >= len(spec) {
+			out = append(out, spec[k])
+			continue
+		}
+
+		switch {
+		case rule.AddressSet != "" && spec[k] == "-d" && spec[k+1] == rule.Address:
+			out = append(out, "-m", "set", "--match-set", addressSetName(rule.AddressSet), "dst")
+			k++
+		case rule.AddressSet != "" && spec[k] == "-s" && spec[k+1] == rule.Address:
+			out = append(out, "-m", "set", "--match-set", addressSetName(rule.AddressSet), "src")
+			k++
+		case rule.SourcePort != "" && spec[k] == "--dport" && spec[k+1] == rule.Port:
+			out = append(out, spec[k], spec[k+1], "--sport", rule.SourcePort)
+			k++
+		case rule.SourcePort != "" && spec[k] == "--sport" && spec[k+1] == rule.Port:
+			out = append(out, spec[k], spec[k+1], "--dport", rule.SourcePort)
+			k++
+		default:
+			out = append(out, spec[k])
+		}
+	}
+
+	return out
+}
+
+// validateSourcePorts returns an error if a rule has a source port that
+// iptables cannot match, since its protocol has no ports.
+func validateSourcePorts(rules policy.IPRuleList) error {
+
+	for _, rule := range rules {
+		proto := strings.ToLower(rule.Protocol)
+		if rule.SourcePort != "" && proto != tcpProto && proto != udpProto {
+			return fmt.Errorf("source port %s not supported for protocol %s", rule.SourcePort, rule.Protocol)
+		}
+	}
+
+	return nil
 }
 
 func (i *Instance) addTCPAppACLS(contextID, chain string, rules policy.IPRuleList) error {
@@ -459,13 +551,15 @@ func (i *Instance) addTCPAppACLS(contextID, chain string, rules policy.IPRuleLis
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext,
 							chain,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "10",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, appChain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -474,21 +568,25 @@ func (i *Instance) addTCPAppACLS(contextID, chain string, rules policy.IPRuleLis
 					if observeContinue {
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext, chain,
-							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol, "-m", "state", "--state", "NEW",
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
 					} else {
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext, chain,
-							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-j", "ACCEPT",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol, "-m", "state", "--state", "NEW",
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-j", "ACCEPT",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -498,21 +596,25 @@ func (i *Instance) addTCPAppACLS(contextID, chain string, rules policy.IPRuleLis
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, chain, 1,
-							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol, "-m", "state", "--state", "NEW",
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, chain, 1,
-							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-j", "DROP",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol, "-m", "state", "--state", "NEW",
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-j", "DROP",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -523,13 +625,15 @@ func (i *Instance) addTCPAppACLS(contextID, chain string, rules policy.IPRuleLis
 							i.appPacketIPTableContext,
 							chain,
 							1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "10",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -578,12 +682,14 @@ func (i *Instance) addOtherAppACLs(contextID, appChain string, rules policy.IPRu
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext,
 							appChain,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-m", "state", "--state", "NEW",
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"-m", "state", "--state", "NEW",
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "NFLOG", "--nflog-group", "10",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
@@ -592,19 +698,23 @@ func (i *Instance) addOtherAppACLs(contextID, appChain string, rules policy.IPRu
 					if observeContinue {
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext, appChain,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
 					} else {
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext, appChain,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-j", "ACCEPT",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"-j", "ACCEPT",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
@@ -614,19 +724,23 @@ func (i *Instance) addOtherAppACLs(contextID, appChain string, rules policy.IPRu
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, appChain, 1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, appChain, 1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-j", "DROP",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"-j", "DROP",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
@@ -637,12 +751,14 @@ func (i *Instance) addOtherAppACLs(contextID, appChain string, rules policy.IPRu
 							i.appPacketIPTableContext,
 							appChain,
 							1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-m", "state", "--state", "NEW",
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"-m", "state", "--state", "NEW",
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "NFLOG", "--nflog-group", "10",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
@@ -692,21 +808,25 @@ func (i *Instance) addUDPAppACLS(contextID, appChain, netChain string, rules pol
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, appChain, 1,
-							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol, "-m", "state", "--state", "NEW",
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, appChain, 1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-j", "ACCEPT",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-j", "ACCEPT",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add outgoin acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
@@ -716,13 +836,15 @@ func (i *Instance) addUDPAppACLS(contextID, appChain, netChain string, rules pol
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext,
 							appChain, 1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "10",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
@@ -731,11 +853,13 @@ func (i *Instance) addUDPAppACLS(contextID, appChain, netChain string, rules pol
 					// Add a corresponding rule on the top of the network chain.
 					if err := i.ipt.Insert(
 						i.netPacketIPTableContext, netChain, 1,
-						"-p", rule.Protocol,
-						"-s", rule.Address,
-						"--sport", rule.Port,
-						"-m", "state", "--state", "ESTABLISHED",
-						"-j", "ACCEPT",
+						aclRuleSpec(rule,
+							"-p", rule.Protocol,
+							"-s", rule.Address,
+							"--sport", rule.Port,
+							"-m", "state", "--state", "ESTABLISHED",
+							"-j", "ACCEPT",
+						)...,
 					); err != nil {
 						return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, netChain, err)
 					}
@@ -744,21 +868,25 @@ func (i *Instance) addUDPAppACLS(contextID, appChain, netChain string, rules pol
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, appChain, 1,
-							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol, "-m", "state", "--state", "NEW",
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, appChain, 1,
-							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-j", "DROP",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol, "-m", "state", "--state", "NEW",
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-j", "DROP",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
@@ -769,13 +897,15 @@ func (i *Instance) addUDPAppACLS(contextID, appChain, netChain string, rules pol
 							i.appPacketIPTableContext,
 							appChain,
 							1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "10",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, appChain, err)
 						}
@@ -795,6 +925,14 @@ func (i *Instance) addUDPAppACLS(contextID, appChain, netChain string, rules pol
 // by an application. The allow rules are inserted with highest priority.
 func (i *Instance) addAppACLs(contextID, appChain, netChain string, rules policy.IPRuleList) error {
 
+	if err := validateSourcePorts(rules); err != nil {
+		return fmt.Errorf("Unable to add app acls: %s", err)
+	}
+
+	if err := i.createAddressSets(rules); err != nil {
+		return fmt.Errorf("Unable to add app acls: %s", err)
+	}
+
 	if err := i.addTCPAppACLS(contextID, appChain, rules); err != nil {
 		return fmt.Errorf("Unable to add tcp app acls: %s", err)
 	}
@@ -874,13 +1012,15 @@ func (i *Instance) addTCPNetACLS(contextID, netChain string, rules policy.IPRule
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext,
 							netChain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "11",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -889,21 +1029,25 @@ func (i *Instance) addTCPNetACLS(contextID, netChain string, rules policy.IPRule
 					if observeContinue {
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext, netChain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
 					} else {
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext, netChain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-j", "ACCEPT",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-j", "ACCEPT",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -913,21 +1057,25 @@ func (i *Instance) addTCPNetACLS(contextID, netChain string, rules policy.IPRule
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, netChain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, netChain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-j", "DROP",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-j", "DROP",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -938,13 +1086,15 @@ func (i *Instance) addTCPNetACLS(contextID, netChain string, rules policy.IPRule
 							i.netPacketIPTableContext,
 							netChain,
 							1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "11",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -1005,21 +1155,25 @@ func (i *Instance) addUDPNetACLS(contextID, appChain, netChain string, rules pol
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, netChain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, netChain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-j", "ACCEPT",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-j", "ACCEPT",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -1030,13 +1184,15 @@ func (i *Instance) addUDPNetACLS(contextID, appChain, netChain string, rules pol
 							i.netPacketIPTableContext,
 							netChain,
 							1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "11",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -1045,11 +1201,13 @@ func (i *Instance) addUDPNetACLS(contextID, appChain, netChain string, rules pol
 					// Add a corresponding rule at the top of appChain.
 					if err := i.ipt.Insert(
 						i.appPacketIPTableContext, appChain, 1,
-						"-p", rule.Protocol,
-						"-d", rule.Address,
-						"--sport", rule.Port,
-						"-m", "state", "--state", "ESTABLISHED",
-						"-j", "ACCEPT",
+						aclRuleSpec(rule,
+							"-p", rule.Protocol,
+							"-d", rule.Address,
+							"--sport", rule.Port,
+							"-m", "state", "--state", "ESTABLISHED",
+							"-j", "ACCEPT",
+						)...,
 					); err != nil {
 						return fmt.Errorf("unable to add net acl rule for table %s, appChain %s: %s", i.appPacketIPTableContext, appChain, err)
 					}
@@ -1058,21 +1216,25 @@ func (i *Instance) addUDPNetACLS(contextID, appChain, netChain string, rules pol
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, netChain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, netChain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-j", "DROP",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-j", "DROP",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -1083,13 +1245,15 @@ func (i *Instance) addUDPNetACLS(contextID, appChain, netChain string, rules pol
 							i.netPacketIPTableContext,
 							netChain,
 							1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "11",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -1138,12 +1302,14 @@ func (i *Instance) addOtherNetACLS(contextID, netChain string, rules policy.IPRu
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext,
 							netChain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "11",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -1152,19 +1318,23 @@ func (i *Instance) addOtherNetACLS(contextID, netChain string, rules policy.IPRu
 					if observeContinue {
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext, netChain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
 					} else {
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext, netChain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-j", "ACCEPT",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"-j", "ACCEPT",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -1174,19 +1344,23 @@ func (i *Instance) addOtherNetACLS(contextID, netChain string, rules policy.IPRu
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, netChain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-j", "MARK", "--set-mark", observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, netChain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-j", "DROP",
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"-j", "DROP",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -1197,12 +1371,14 @@ func (i *Instance) addOtherNetACLS(contextID, netChain string, rules policy.IPRu
 							i.netPacketIPTableContext,
 							netChain,
 							1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							aclRuleSpec(rule,
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"-m", "mark", "!", "--mark", observeMark,
+								"-m", "state", "--state", "NEW",
+								"-j", "NFLOG", "--nflog-group", "11",
+								"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, netChain %s: %s", i.netPacketIPTableContext, netChain, err)
 						}
@@ -1220,6 +1396,14 @@ func (i *Instance) addOtherNetACLS(contextID, netChain string, rules policy.IPRu
 // explicit rules are added with the highest priority since they are direct allows.
 func (i *Instance) addNetACLs(contextID, appChain, netChain string, rules policy.IPRuleList) error {
 
+	if err := validateSourcePorts(rules); err != nil {
+		return fmt.Errorf("Unable to add net acls: %s", err)
+	}
+
+	if err := i.createAddressSets(rules); err != nil {
+		return fmt.Errorf("Unable to add net acls: %s", err)
+	}
+
 	if err := i.addTCPNetACLS(contextID, netChain, rules); err != nil {
 		return fmt.Errorf("Unable to add tcp net acls: %s", err)
 	}
@@ -1316,13 +1500,42 @@ func (i *Instance) deleteAllContainerChains(appChain, netChain string) error {
 	return nil
 }
 
+// excludedPortRules returns the rules that accept the flows of the excluded
+// ports. The first packets of a flow are still queued, so that the datapath
+// removes the token of a syn and reports the flow. The replies are matched by
+// their conntrack direction, so that a port excluded as a destination is not
+// accepted as a source of new flows.
+func (i *Instance) excludedPortRules() [][]string {
+
+	rules := [][]string{}
+
+	for _, section := range [][]string{
+		{i.appPacketIPTableContext, i.appPacketIPTableSection},
+		{i.netPacketIPTableContext, i.netPacketIPTableSection},
+	} {
+		rules = append(rules, []string{
+			section[0], section[1],
+			"-m", "set", "--match-set", excludedPortSet, "dst",
+			"-m", "conntrack", "--ctdir", "ORIGINAL", "--ctstate", "ESTABLISHED",
+			"-j", "ACCEPT",
+		}, []string{
+			section[0], section[1],
+			"-m", "set", "--match-set", excludedPortSet, "src",
+			"-m", "conntrack", "--ctdir", "REPLY",
+			"-j", "ACCEPT",
+		})
+	}
+
+	return rules
+}
+
 // setGlobalRules installs the global rules
 func (i *Instance) setGlobalRules(appChain, netChain string) error {
 
 	err := i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", strconv.Itoa(int(i.fqc.GetConnMark())),
 		"-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("unable to add default allow for marked packets at app: %s", err)
@@ -1371,7 +1584,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", strconv.Itoa(int(i.fqc.GetConnMark())),
 		"-j", "ACCEPT")
 
 	if err != nil {
@@ -1402,12 +1615,23 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", strconv.Itoa(int(i.fqc.GetConnMark())),
 		"-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("unable to add capture synack rule for table %s, chain %s: %s", i.appPacketIPTableContext, i.appPacketIPTableSection, err)
 	}
 
+	// The datapath learns the MTU of the paths from the ICMP errors. The
+	// errors of released flows carry their mark, so they are queued first.
+	err = i.ipt.Insert(
+		i.netPacketIPTableContext,
+		netChain, 1,
+		"-p", "icmp", "--icmp-type", "fragmentation-needed",
+		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynAckStr())
+	if err != nil {
+		return fmt.Errorf("unable to add capture icmp fragmentation needed rule for table %s, chain %s: %s", i.netPacketIPTableContext, netChain, err)
+	}
+
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
@@ -1497,6 +1721,71 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 		return fmt.Errorf("unable to add application raw socket mark rule output chain: %s", err)
 	}
 
+	return i.addInterfaceRules(appChain, netChain)
+}
+
+// interfaceRules returns the rules of the interface chains. The traffic of
+// the enforced interfaces returns to the section and the rest is accepted.
+func (i *Instance) interfaceRules() [][]string {
+
+	rules := [][]string{}
+
+	for _, iface := range i.interfaces {
+		rules = append(rules, []string{
+			i.appPacketIPTableContext,
+			interfaceAppChain,
+			"-o", iface,
+			"-j", "RETURN",
+		}, []string{
+			i.netPacketIPTableContext,
+			interfaceNetChain,
+			"-i", iface,
+			"-j", "RETURN",
+		})
+	}
+
+	return append(rules, []string{
+		i.appPacketIPTableContext,
+		interfaceAppChain,
+		"-m", "comment", "--comment", "Interface-not-enforced",
+		"-j", "ACCEPT",
+	}, []string{
+		i.netPacketIPTableContext,
+		interfaceNetChain,
+		"-m", "comment", "--comment", "Interface-not-enforced",
+		"-j", "ACCEPT",
+	})
+}
+
+// addInterfaceRules scopes the enforcement to the configured interfaces. The
+// interface chains are evaluated before any other rule of the sections, so
+// that the traffic of the other interfaces never reaches the datapath.
+func (i *Instance) addInterfaceRules(appChain, netChain string) error {
+
+	if len(i.interfaces) == 0 {
+		return nil
+	}
+
+	if err := i.ipt.NewChain(i.appPacketIPTableContext, interfaceAppChain); err != nil {
+		return fmt.Errorf("unable to add chain %s of context %s: %s", interfaceAppChain, i.appPacketIPTableContext, err)
+	}
+
+	if err := i.ipt.NewChain(i.netPacketIPTableContext, interfaceNetChain); err != nil {
+		return fmt.Errorf("unable to add chain %s of context %s: %s", interfaceNetChain, i.netPacketIPTableContext, err)
+	}
+
+	if err := i.processRulesFromList(i.interfaceRules(), "Append"); err != nil {
+		return err
+	}
+
+	if err := i.ipt.Insert(i.appPacketIPTableContext, appChain, 1, "-j", interfaceAppChain); err != nil {
+		return fmt.Errorf("unable to add interface chain %s to %s: %s", interfaceAppChain, appChain, err)
+	}
+
+	if err := i.ipt.Insert(i.netPacketIPTableContext, netChain, 1, "-j", interfaceNetChain); err != nil {
+		return fmt.Errorf("unable to add interface chain %s to %s: %s", interfaceNetChain, netChain, err)
+	}
+
 	return nil
 }
 
@@ -1524,7 +1813,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.appPacketIPTableContext,
 		i.appPacketIPTableSection,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", strconv.Itoa(int(i.fqc.GetConnMark())),
 		"-j", "ACCEPT"); err != nil {
 		zap.L().Debug("Can not clear the global app mark rule", zap.Error(err))
 		return fmt.Errorf("unable to add default allow for marked packets at app: %s", err)
@@ -1533,11 +1822,19 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.netPacketIPTableContext,
 		i.netPacketIPTableSection,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", strconv.Itoa(int(i.fqc.GetConnMark())),
 		"-j", "ACCEPT"); err != nil {
 		zap.L().Debug("Can not clear the global net mark rule", zap.Error(err))
 	}
 
+	if err := i.ipt.Delete(
+		i.netPacketIPTableContext,
+		i.netPacketIPTableSection,
+		"-p", "icmp", "--icmp-type", "fragmentation-needed",
+		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynAckStr()); err != nil {
+		zap.L().Debug("Can not clear the icmp fragmentation needed capture net chain", zap.Error(err))
+	}
+
 	if err := i.ipset.DestroyAll(); err != nil {
 		zap.L().Debug("Failed to clear targetIPset", zap.Error(err))
 	}
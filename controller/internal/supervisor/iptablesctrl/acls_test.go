@@ -7,6 +7,7 @@ import (
 
 	"github.com/bvandewalle/go-ipset/ipset"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/common"
 	"go.aporeto.io/trireme-lib/controller/constants"
 	"go.aporeto.io/trireme-lib/controller/internal/portset"
 	"go.aporeto.io/trireme-lib/controller/pkg/aclprovider"
@@ -29,7 +30,7 @@ func matchSpec(term string, rulespec []string) error {
 func TestAddContainerChain(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -75,7 +76,7 @@ func TestAddContainerChain(t *testing.T) {
 func TestAddChainRules(t *testing.T) {
 
 	Convey("Given an iptables controller for LocalContainer", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -115,7 +116,7 @@ func TestAddChainRules(t *testing.T) {
 	})
 
 	Convey("Given an iptables controller for LocalServer", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -176,7 +177,7 @@ func TestAddChainRules(t *testing.T) {
 func TestAddPacketTrap(t *testing.T) {
 
 	Convey("Given an iptables controller, when I test addPacketTrap for Local Container", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -184,7 +185,7 @@ func TestAddPacketTrap(t *testing.T) {
 			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.addPacketTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			err := i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -197,7 +198,7 @@ func TestAddPacketTrap(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addPacketTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			err := i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -210,7 +211,7 @@ func TestAddPacketTrap(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addPacketTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			err := i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -219,7 +220,7 @@ func TestAddPacketTrap(t *testing.T) {
 	})
 
 	Convey("Given an iptables controller, when I test addPacketTrap for Local Server", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -227,7 +228,7 @@ func TestAddPacketTrap(t *testing.T) {
 			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.addPacketTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			err := i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -240,7 +241,7 @@ func TestAddPacketTrap(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addPacketTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			err := i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -253,7 +254,7 @@ func TestAddPacketTrap(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addPacketTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			err := i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -262,7 +263,7 @@ func TestAddPacketTrap(t *testing.T) {
 	})
 
 	Convey("Given an iptables controller, when I test addPacketTrap for sidecar container", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.Sidecar, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.Sidecar, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -270,7 +271,7 @@ func TestAddPacketTrap(t *testing.T) {
 			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.addPacketTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			err := i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -283,7 +284,7 @@ func TestAddPacketTrap(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addPacketTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			err := i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -296,7 +297,7 @@ func TestAddPacketTrap(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addPacketTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			err := i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -308,7 +309,7 @@ func TestAddPacketTrap(t *testing.T) {
 func TestAddAppACLs(t *testing.T) {
 
 	Convey("Given an iptables controller ", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -462,7 +463,7 @@ func TestAddAppACLs(t *testing.T) {
 func TestAddNetAcls(t *testing.T) {
 
 	Convey("Given an iptables controller ", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -616,10 +617,167 @@ func TestAddNetAcls(t *testing.T) {
 	})
 }
 
+func TestSourcePortACLs(t *testing.T) {
+
+	Convey("Given an iptables controller", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		specs := [][]string{}
+		capture := func(table string, chain string, rulespec ...string) error {
+			specs = append(specs, rulespec)
+			return nil
+		}
+		iptables.MockAppend(t, capture)
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			return capture(table, chain, rulespec...)
+		})
+
+		Convey("When I add app ACLs with a source port", func() {
+			err := i.addAppACLs("", "appChain", "netChain", policy.IPRuleList{
+				{
+					Address:    "192.30.253.0/24",
+					Port:       "53",
+					SourcePort: "1000:2000",
+					Protocol:   "udp",
+					Policy:     &policy.FlowPolicy{Action: policy.Accept},
+				},
+			})
+
+			Convey("Then the rules should match the source port of the flow and the destination port of its replies", func() {
+				So(err, ShouldBeNil)
+				So(specs, ShouldContain, []string{"-p", "udp", "-d", "192.30.253.0/24", "--dport", "53", "--sport", "1000:2000", "-j", "ACCEPT"})
+				So(specs, ShouldContain, []string{"-p", "udp", "-s", "192.30.253.0/24", "--sport", "53", "--dport", "1000:2000", "-m", "state", "--state", "ESTABLISHED", "-j", "ACCEPT"})
+			})
+		})
+
+		Convey("When I add net ACLs with a source port", func() {
+			err := i.addNetACLs("", "appChain", "netChain", policy.IPRuleList{
+				{
+					Address:    "192.30.253.0/24",
+					Port:       "80",
+					SourcePort: "443",
+					Protocol:   "tcp",
+					Policy:     &policy.FlowPolicy{Action: policy.Reject},
+				},
+			})
+
+			Convey("Then the rules should match the source port", func() {
+				So(err, ShouldBeNil)
+				So(specs, ShouldContain, []string{"-p", "tcp", "-s", "192.30.253.0/24", "--dport", "80", "--sport", "443", "-j", "DROP"})
+			})
+		})
+
+		Convey("When I add ACLs with a source port for a protocol without ports", func() {
+			rules := policy.IPRuleList{
+				{
+					Address:    "192.30.253.0/24",
+					SourcePort: "443",
+					Protocol:   "icmp",
+					Policy:     &policy.FlowPolicy{Action: policy.Accept},
+				},
+			}
+
+			Convey("Then I should get an error", func() {
+				So(i.addAppACLs("", "appChain", "netChain", rules), ShouldNotBeNil)
+				So(i.addNetACLs("", "appChain", "netChain", rules), ShouldNotBeNil)
+				So(specs, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestAddressSetACLs(t *testing.T) {
+
+	Convey("Given an iptables controller", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		ipsets := provider.NewTestIpsetProvider()
+		i.ipset = ipsets
+
+		sets := map[string][]string{}
+		ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+			if _, ok := sets[name]; !ok {
+				sets[name] = []string{}
+			}
+			testset := provider.NewTestIpset()
+			testset.MockFlush(t, func() error {
+				sets[name] = []string{}
+				return nil
+			})
+			testset.MockAdd(t, func(entry string, timeout int) error {
+				sets[name] = append(sets[name], entry)
+				return nil
+			})
+			return testset, nil
+		})
+
+		specs := [][]string{}
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			specs = append(specs, rulespec)
+			return nil
+		})
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			specs = append(specs, rulespec)
+			return nil
+		})
+
+		rules := policy.IPRuleList{
+			{
+				AddressSet: "feed",
+				Port:       "443",
+				Protocol:   "tcp",
+				Policy:     &policy.FlowPolicy{Action: policy.Reject},
+			},
+		}
+
+		Convey("When I add app ACLs that reference an address set", func() {
+			err := i.addAppACLs("", "appChain", "netChain", rules)
+
+			Convey("Then the rules should match the ipset of the address set", func() {
+				So(err, ShouldBeNil)
+				So(specs, ShouldContain, []string{"-p", "tcp", "-m", "state", "--state", "NEW", "-m", "set", "--match-set", addressSetName("feed"), "dst", "--dport", "443", "-j", "DROP"})
+				for _, spec := range specs {
+					So(spec, ShouldNotContain, "")
+				}
+			})
+
+			Convey("Then the ipset should be created empty", func() {
+				So(sets, ShouldContainKey, addressSetName("feed"))
+				So(sets[addressSetName("feed")], ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I add net ACLs that reference an address set", func() {
+			err := i.addNetACLs("", "appChain", "netChain", rules)
+
+			Convey("Then the rules should match the sources of the ipset", func() {
+				So(err, ShouldBeNil)
+				So(specs, ShouldContain, []string{"-p", "tcp", "-m", "set", "--match-set", addressSetName("feed"), "src", "--dport", "443", "-j", "DROP"})
+			})
+		})
+
+		Convey("When I update the addresses of an address set", func() {
+			So(i.UpdateAddressSet("feed", []string{"20.0.0.1", "20.1.0.0/16"}), ShouldBeNil)
+
+			Convey("Then the ipset should hold them", func() {
+				So(sets[addressSetName("feed")], ShouldResemble, []string{"20.0.0.1", "20.1.0.0/16"})
+			})
+
+			Convey("Then the ipset should be emptied when the set is removed", func() {
+				So(i.RemoveAddressSet("feed"), ShouldBeNil)
+				So(sets[addressSetName("feed")], ShouldBeEmpty)
+			})
+		})
+	})
+}
+
 func TestDeleteChainRules(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -647,7 +805,7 @@ func TestDeleteChainRules(t *testing.T) {
 func TestDeleteAllContainerChains(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -697,7 +855,7 @@ func TestDeleteAllContainerChains(t *testing.T) {
 func TestAcceptMarkedPackets(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -707,7 +865,7 @@ func TestAcceptMarkedPackets(t *testing.T) {
 func TestRemoveMarkRule(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -732,9 +890,90 @@ func TestRemoveMarkRule(t *testing.T) {
 	})
 }
 
+func TestEnforcementDirection(t *testing.T) {
+	Convey("Given an iptables controller that records the appended rules", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		accepted := map[string][]string{}
+		targets := map[string]int{}
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			targets[rulespec[len(rulespec)-1]]++
+			if len(rulespec) == 6 && rulespec[1] == "conntrack" && rulespec[5] == "ACCEPT" {
+				accepted[chain] = append(accepted[chain], rulespec[3])
+			}
+			return nil
+		})
+
+		Convey("When I install the rules of an egress only PU", func() {
+			So(i.addChainRules("", "appchain", "netchain", "", "", "", "", "5000", "proxyPortSet"), ShouldBeNil)
+			So(i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceEgressOnly), ShouldBeNil)
+
+			Convey("Both chains should be used", func() {
+				So(targets["appchain"], ShouldEqual, 1)
+				So(targets["netchain"], ShouldEqual, 1)
+			})
+
+			Convey("Only the flows initiated by the network should be accepted before the trap", func() {
+				So(accepted["netchain"], ShouldResemble, []string{"ORIGINAL"})
+				So(accepted["appchain"], ShouldResemble, []string{"REPLY"})
+			})
+		})
+
+		Convey("When I install the rules of an ingress only PU", func() {
+			So(i.addChainRules("", "appchain", "netchain", "", "", "", "", "5000", "proxyPortSet"), ShouldBeNil)
+			So(i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceIngressOnly), ShouldBeNil)
+
+			Convey("Both chains should be used", func() {
+				So(targets["appchain"], ShouldEqual, 1)
+				So(targets["netchain"], ShouldEqual, 1)
+			})
+
+			Convey("Only the flows initiated by the PU should be accepted before the trap", func() {
+				So(accepted["appchain"], ShouldResemble, []string{"ORIGINAL"})
+				So(accepted["netchain"], ShouldResemble, []string{"REPLY"})
+			})
+		})
+
+		Convey("When I install the rules of a PU that enforces both directions", func() {
+			So(i.addChainRules("", "appchain", "netchain", "", "", "", "", "5000", "proxyPortSet"), ShouldBeNil)
+			So(i.addPacketTrap("appchain", "netchain", targetNetworkSet, policy.EnforceBoth), ShouldBeNil)
+
+			Convey("No flow should be accepted before the trap", func() {
+				So(targets["appchain"], ShouldEqual, 1)
+				So(targets["netchain"], ShouldEqual, 1)
+				So(accepted, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestExcludedPortRules(t *testing.T) {
+	Convey("Given an iptables controller", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+
+		Convey("The flows to the excluded ports should be accepted after their first packets", func() {
+			rules := i.excludedPortRules()
+			So(len(rules), ShouldEqual, 4)
+
+			for _, rule := range rules {
+				switch rule[6] {
+				case "dst":
+					So(rule[7:13], ShouldResemble, []string{"-m", "conntrack", "--ctdir", "ORIGINAL", "--ctstate", "ESTABLISHED"})
+				case "src":
+					So(rule[7:11], ShouldResemble, []string{"-m", "conntrack", "--ctdir", "REPLY"})
+				default:
+					t.Fatalf("unexpected rule %v", rule)
+				}
+			}
+		})
+	})
+}
+
 func TestAddExclusionACLs(t *testing.T) {
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -777,10 +1016,78 @@ func TestAddExclusionACLs(t *testing.T) {
 	})
 }
 
+func TestAddInterfaceRules(t *testing.T) {
+	Convey("Given an iptables controller that enforces eth0 and eth1", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil), []string{"eth0", "eth1"})
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		chains := []string{}
+		iptables.MockNewChain(t, func(table string, chain string) error {
+			chains = append(chains, chain)
+			return nil
+		})
+
+		appended := [][]string{}
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			appended = append(appended, append([]string{table, chain}, rulespec...))
+			return nil
+		})
+
+		inserted := [][]string{}
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			So(pos, ShouldEqual, 1)
+			inserted = append(inserted, append([]string{table, chain}, rulespec...))
+			return nil
+		})
+
+		Convey("When I add the interface rules", func() {
+			err := i.addInterfaceRules("OUTPUT", "INPUT")
+			Convey("The traffic of the interfaces should be enforced and the rest accepted", func() {
+				So(err, ShouldBeNil)
+				So(chains, ShouldResemble, []string{interfaceAppChain, interfaceNetChain})
+				So(appended, ShouldResemble, [][]string{
+					{"mangle", interfaceAppChain, "-o", "eth0", "-j", "RETURN"},
+					{"mangle", interfaceNetChain, "-i", "eth0", "-j", "RETURN"},
+					{"mangle", interfaceAppChain, "-o", "eth1", "-j", "RETURN"},
+					{"mangle", interfaceNetChain, "-i", "eth1", "-j", "RETURN"},
+					{"mangle", interfaceAppChain, "-m", "comment", "--comment", "Interface-not-enforced", "-j", "ACCEPT"},
+					{"mangle", interfaceNetChain, "-m", "comment", "--comment", "Interface-not-enforced", "-j", "ACCEPT"},
+				})
+				So(inserted, ShouldResemble, [][]string{
+					{"mangle", "OUTPUT", "-j", interfaceAppChain},
+					{"mangle", "INPUT", "-j", interfaceNetChain},
+				})
+			})
+		})
+
+		Convey("When I add the interface rules and the chain cannot be created", func() {
+			iptables.MockNewChain(t, func(table string, chain string) error {
+				return errors.New("error")
+			})
+			err := i.addInterfaceRules("OUTPUT", "INPUT")
+			Convey("I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When all the interfaces are enforced", func() {
+			i.interfaces = nil
+			err := i.addInterfaceRules("OUTPUT", "INPUT")
+			Convey("No rule should be added", func() {
+				So(err, ShouldBeNil)
+				So(chains, ShouldBeEmpty)
+				So(appended, ShouldBeEmpty)
+				So(inserted, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
 //
 // func TestSetGlobalRules(t *testing.T) {
 // 	Convey("Given an iptables controller", t, func() {
-// 		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+// 		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 // 		iptables := provider.NewTestIptablesProvider()
 // 		i.ipt = iptables
 // 		ipsets := provider.NewTestIpsetProvider()
@@ -878,7 +1185,7 @@ func TestAddExclusionACLs(t *testing.T) {
 
 func TestClearCaptureSynAckPackets(t *testing.T) {
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -904,7 +1211,7 @@ func TestClearCaptureSynAckPackets(t *testing.T) {
 
 func TestUpdateTargetNetworks(t *testing.T) {
 	Convey("Given an iptables controller,", t, func() {
-		i, _ := NewInstance(&fqconfig.FilterQueue{}, constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(&fqconfig.FilterQueue{}, constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 		ipsets := provider.NewTestIpsetProvider()
@@ -949,3 +1256,74 @@ func TestUpdateTargetNetworks(t *testing.T) {
 		})
 	})
 }
+
+func TestPUTargetNetworks(t *testing.T) {
+	Convey("Given an iptables controller with the target networks of the node", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		ipsets := provider.NewTestIpsetProvider()
+		i.ipset = ipsets
+
+		// matched records the sets matched by the rules of the PU chains.
+		matched := map[string]int{}
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			for idx, spec := range rulespec {
+				if spec == "--match-set" && (chain == "appchain" || chain == "netchain") {
+					matched[rulespec[idx+1]]++
+				}
+			}
+			return nil
+		})
+		iptables.MockNewChain(t, func(table string, chain string) error {
+			return nil
+		})
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			return nil
+		})
+
+		sets := map[string][]string{}
+		ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+			testset := provider.NewTestIpset()
+			testset.MockFlush(t, func() error {
+				sets[name] = []string{}
+				return nil
+			})
+			testset.MockAdd(t, func(entry string, timeout int) error {
+				sets[name] = append(sets[name], entry)
+				return nil
+			})
+			return testset, nil
+		})
+
+		puInfo := func(networks []string) *policy.PUInfo {
+			info := policy.NewPUInfo("pu", common.ContainerPU)
+			info.Policy = policy.NewPUPolicy("pu", policy.Police, nil, nil, nil, nil, nil, nil, nil, nil, networks, []string{}, []string{}, nil, nil, []string{})
+			info.Runtime = policy.NewPURuntimeWithDefaults()
+			return info
+		}
+
+		Convey("When the PU has its own target networks, its chains should only trap them", func() {
+			setName := puTargetSetName("pu", 1)
+			So(i.installRules("pu", "appchain", "netchain", "proxyPortSet", setName, puInfo([]string{"10.1.0.0/16"})), ShouldBeNil)
+
+			So(sets[setName], ShouldResemble, []string{"10.1.0.0/16"})
+			So(matched[setName], ShouldBeGreaterThan, 0)
+			So(matched[targetNetworkSet], ShouldEqual, 0)
+		})
+
+		Convey("When the PU has no target networks, its chains should trap the networks of the node", func() {
+			setName := puTargetSetName("pu", 1)
+			So(i.installRules("pu", "appchain", "netchain", "proxyPortSet", setName, puInfo(nil)), ShouldBeNil)
+
+			So(sets, ShouldBeEmpty)
+			So(matched[setName], ShouldEqual, 0)
+			So(matched[targetNetworkSet], ShouldBeGreaterThan, 0)
+		})
+
+		Convey("The sets of the versions of the rules should be different", func() {
+			So(puTargetSetName("pu", 0), ShouldNotEqual, puTargetSetName("pu", 1))
+			So(len(puTargetSetName("a-very-long-context-id", 1)), ShouldBeLessThanOrEqualTo, 31)
+		})
+	})
+}
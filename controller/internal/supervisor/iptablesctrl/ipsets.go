@@ -60,6 +60,118 @@ func (i *Instance) createTargetSet(networks []string) error {
 	return nil
 }
 
+// createExcludedPortSet creates the set of the excluded ports.
+func (i *Instance) createExcludedPortSet() error {
+
+	if err := i.createPUPortSet(excludedPortSet); err != nil {
+		return fmt.Errorf("unable to create ipset for %s: %s", excludedPortSet, err)
+	}
+
+	return nil
+}
+
+// updateExcludedPortSet replaces the ports of the excluded port set. The
+// ports are single ports or ranges such as 8000:8100.
+func (i *Instance) updateExcludedPortSet(ports []string) error {
+
+	set := ipset.IPSet{
+		Name: excludedPortSet,
+	}
+
+	if err := set.Flush(); err != nil {
+		return fmt.Errorf("unable to flush ipset %s: %s", excludedPortSet, err)
+	}
+
+	for _, port := range ports {
+		if err := set.Add(strings.Replace(port, ":", "-", 1), 0); err != nil {
+			return fmt.Errorf("unable to add port %s to excluded ports ipset: %s", port, err)
+		}
+	}
+
+	return nil
+}
+
+// addressSetName returns the name of the ipset of an address set.
+func addressSetName(name string) string {
+	return puPortSetName(name, addressSetPrefix)
+}
+
+// createAddressSets creates the ipsets of the address sets that the rules
+// reference and that do not exist yet. They are empty until their addresses
+// are set.
+func (i *Instance) createAddressSets(rules policy.IPRuleList) error {
+
+	for _, rule := range rules {
+		if rule.AddressSet == "" {
+			continue
+		}
+
+		setName := addressSetName(rule.AddressSet)
+		if _, err := i.ipset.NewIpset(setName, "hash:net", &ipset.Params{}); err != nil {
+			return fmt.Errorf("unable to create ipset %s for address set %s: %s", setName, rule.AddressSet, err)
+		}
+	}
+
+	return nil
+}
+
+// updateAddressSet replaces the addresses of the ipset of an address set.
+// The set is created if it does not exist.
+func (i *Instance) updateAddressSet(name string, addresses []string) error {
+
+	setName := addressSetName(name)
+	ips, err := i.ipset.NewIpset(setName, "hash:net", &ipset.Params{})
+	if err != nil {
+		return fmt.Errorf("unable to create ipset %s for address set %s: %s", setName, name, err)
+	}
+
+	if err := ips.Flush(); err != nil {
+		return fmt.Errorf("unable to flush ipset %s: %s", setName, err)
+	}
+
+	for _, address := range addresses {
+		if err := ips.Add(address, 0); err != nil {
+			return fmt.Errorf("unable to add address %s to ipset %s: %s", address, setName, err)
+		}
+	}
+
+	return nil
+}
+
+// createPUTargetSet creates the target network set of a PU. A set left by
+// previous rules is reused and flushed.
+func (i *Instance) createPUTargetSet(setName string, networks []string) error {
+
+	ips, err := i.ipset.NewIpset(setName, "hash:net", &ipset.Params{})
+	if err != nil {
+		return fmt.Errorf("unable to create ipset for %s: %s", setName, err)
+	}
+
+	if err := ips.Flush(); err != nil {
+		return fmt.Errorf("unable to flush ipset %s: %s", setName, err)
+	}
+
+	for _, net := range networks {
+		if err := ips.Add(net, 0); err != nil {
+			return fmt.Errorf("unable to add ip %s to target networks ipset %s: %s", net, setName, err)
+		}
+	}
+
+	return nil
+}
+
+// deletePUTargetSet deletes the target network set of a PU. Most PUs use the
+// target networks of the node and have no set.
+func (i *Instance) deletePUTargetSet(setName string) {
+
+	ips := ipset.IPSet{
+		Name: setName,
+	}
+	if err := ips.Destroy(); err != nil {
+		zap.L().Debug("Failed to destroy target network set", zap.String("set name", setName), zap.Error(err))
+	}
+}
+
 // createProxySet creates a new target set -- ipportset is a list of {ip,port}
 func (i *Instance) createProxySets(portSetName string) error {
 	destSetName, srcSetName, srvSetName := i.getSetNames(portSetName)
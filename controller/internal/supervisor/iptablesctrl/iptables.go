@@ -26,6 +26,15 @@ const (
 	appChainPrefix   = chainPrefix + "App-"
 	netChainPrefix   = chainPrefix + "Net-"
 	targetNetworkSet = "TargetNetSet"
+	// targetNetworkSetPrefix is the prefix of the target network sets of
+	// the PUs with their own target networks.
+	targetNetworkSetPrefix = "TargetNet-"
+	// excludedPortSet is the set of the ports whose flows bypass the
+	// enforcement.
+	excludedPortSet = "ExcludedPorts"
+	// addressSetPrefix is the prefix of the sets of the addresses of the
+	// address sets that the ACLs reference.
+	addressSetPrefix = "AddrSet-"
 	// PuPortSet The prefix for portset names
 	PuPortSet                = "PUPort-"
 	proxyPortSetPrefix       = "Proxy-"
@@ -37,8 +46,13 @@ const (
 	proxyOutputChain         = "Proxy-App"
 	proxyInputChain          = "Proxy-Net"
 	proxyMark                = "0x40"
+	interfaceAppChain        = chainPrefix + "Interfaces-App"
+	interfaceNetChain        = chainPrefix + "Interfaces-Net"
 	// ProxyPort DefaultProxyPort
 	ProxyPort = "5000"
+	// maxInterfaceNameLen is the maximum length of the name of a network
+	// interface accepted by iptables.
+	maxInterfaceNameLen = 15
 )
 
 // Instance  is the structure holding all information about a implementation
@@ -56,10 +70,24 @@ type Instance struct {
 	appSynAckIPTableSection string
 	mode                    constants.ModeType
 	portSetInstance         portset.PortSet
+	// interfaces are the network interfaces where the policy is enforced.
+	// The traffic of the other interfaces bypasses the datapath. All the
+	// interfaces are enforced when it is empty.
+	interfaces []string
+	// excludedPorts is set once the excluded port set and its rules are
+	// installed.
+	excludedPorts bool
 }
 
-// NewInstance creates a new iptables controller instance
-func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset portset.PortSet) (*Instance, error) {
+// NewInstance creates a new iptables controller instance. The policy is only
+// enforced on the given network interfaces, or on all of them if there are none.
+func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset portset.PortSet, interfaces []string) (*Instance, error) {
+
+	for _, iface := range interfaces {
+		if iface == "" || len(iface) > maxInterfaceNameLen {
+			return nil, fmt.Errorf("invalid interface name: '%s'", iface)
+		}
+	}
 
 	ipt, err := provider.NewGoIPTablesProvider([]string{"mangle"})
 	if err != nil {
@@ -84,6 +112,7 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset por
 		appCgroupIPTableSection: ipTableSectionOutput,
 		netPacketIPTableSection: ipTableSectionInput,
 		appSynAckIPTableSection: ipTableSectionOutput,
+		interfaces:              interfaces,
 	}
 
 	return i, nil
@@ -129,6 +158,13 @@ func puPortSetName(contextID string, prefix string) string {
 	return (prefix + contextID)
 }
 
+// puTargetSetName returns the name of the target network set of a version
+// of the rules of the PU. Every version has its own set, so that the set of
+// the new rules is filled before the old rules are removed.
+func puTargetSetName(contextID string, version int) string {
+	return puPortSetName(contextID, targetNetworkSetPrefix) + "-" + strconv.Itoa(version)
+}
+
 // ConfigureRules implmenets the ConfigureRules interface. It will create the
 // port sets and then it will call install rules to create all the ACLs for
 // the given chains. PortSets are only created here. Updates will use the
@@ -153,7 +189,7 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 	}
 
 	// Install all the rules
-	if err := i.installRules(contextID, appChain, netChain, proxySetName, containerInfo); err != nil {
+	if err := i.installRules(contextID, appChain, netChain, proxySetName, puTargetSetName(contextID, version), containerInfo); err != nil {
 		return err
 	}
 
@@ -192,6 +228,8 @@ func (i *Instance) DeleteRules(version int, contextID string, tcpPorts, udpPorts
 		zap.L().Warn("Failed to delete proxy sets", zap.Error(err))
 	}
 
+	i.deletePUTargetSet(puTargetSetName(contextID, version))
+
 	return nil
 }
 
@@ -218,7 +256,7 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 	}
 
 	// Install the new rules
-	if err := i.installRules(contextID, appChain, netChain, proxySetName, containerInfo); err != nil {
+	if err := i.installRules(contextID, appChain, netChain, proxySetName, puTargetSetName(contextID, version), containerInfo); err != nil {
 		return nil
 	}
 
@@ -242,7 +280,14 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return err
 	}
 
-	return i.ipt.Commit()
+	if err := i.ipt.Commit(); err != nil {
+		return err
+	}
+
+	// The old target network set is no longer referenced.
+	i.deletePUTargetSet(puTargetSetName(contextID, version^1))
+
+	return nil
 }
 
 // Run starts the iptables controller
@@ -304,6 +349,43 @@ func (i *Instance) SetTargetNetworks(current, networks []string) error {
 	return nil
 }
 
+// SetExcludedPorts updates the ports whose flows bypass the enforcement. The
+// set and its rules are only installed once there are excluded ports.
+func (i *Instance) SetExcludedPorts(ports []string) error {
+
+	if !i.excludedPorts {
+		if len(ports) == 0 {
+			return nil
+		}
+
+		if err := i.createExcludedPortSet(); err != nil {
+			return err
+		}
+
+		if err := i.processRulesFromList(i.excludedPortRules(), "Insert"); err != nil {
+			return fmt.Errorf("failed to add excluded port rules: %s", err)
+		}
+
+		i.excludedPorts = true
+	}
+
+	return i.updateExcludedPortSet(ports)
+}
+
+// UpdateAddressSet replaces the addresses of an address set. The ACLs that
+// reference the set apply to the new addresses once it returns.
+func (i *Instance) UpdateAddressSet(name string, addresses []string) error {
+
+	return i.updateAddressSet(name, addresses)
+}
+
+// RemoveAddressSet removes the addresses of an address set. The set itself is
+// kept as long as ACLs reference it, and they match no address.
+func (i *Instance) RemoveAddressSet(name string) error {
+
+	return i.updateAddressSet(name, nil)
+}
+
 // InitializeChains initializes the chains.
 func (i *Instance) InitializeChains() error {
 
@@ -367,13 +449,9 @@ func (i *Instance) configureLinuxRules(contextID, appChain, netChain, proxyPortS
 	portSetName := ""
 	if uid != "" {
 		portSetName = puPortSetName(contextID, PuPortSet)
-		// update the portset cache, so that it can program the portset
 		if i.portSetInstance == nil {
 			return errors.New("enforcer portset instance cannot be nil for host")
 		}
-		if err := i.portSetInstance.AddUserPortSet(uid, portSetName, mark); err != nil {
-			return err
-		}
 	}
 
 	return i.addChainRules(portSetName, appChain, netChain, tcpPorts, udpPorts, mark, uid, proxyPort, proxyPortSetName)
@@ -421,14 +499,42 @@ func (i *Instance) deleteProxySets(proxyPortSetName string) error {
 	return nil
 }
 
-// Install rules will install all the rules and update the port sets.
-func (i *Instance) installRules(contextID, appChain, netChain, proxySetName string, containerInfo *policy.PUInfo) error {
-	policyrules := containerInfo.Policy
+// Install rules will install all the rules and update the port sets. The
+// packets of the PU are trapped for its own target networks, or for the
+// target networks of the node if it has none.
+func (i *Instance) installRules(contextID, appChain, netChain, proxySetName, targetSetName string, containerInfo *policy.PUInfo) error {
 
 	if err := i.updateProxySet(containerInfo.Policy, proxySetName); err != nil {
 		return err
 	}
 
+	if networks := containerInfo.Policy.TriremeNetworks(); len(networks) > 0 {
+		if err := i.createPUTargetSet(targetSetName, networks); err != nil {
+			return err
+		}
+	} else {
+		targetSetName = targetNetworkSet
+	}
+
+	if err := i.addPURules(contextID, appChain, netChain, proxySetName, targetSetName, containerInfo); err != nil {
+		return err
+	}
+
+	// Update the portset cache of a UID process, so that it can program the portset.
+	if uid := containerInfo.Runtime.Options().UserID; i.mode == constants.LocalServer && uid != "" {
+		if err := i.portSetInstance.AddUserPortSet(uid, puPortSetName(contextID, PuPortSet), containerInfo.Runtime.Options().CgroupMark); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addPURules adds the chains and the ACLs of the PU. The sets used by the
+// rules are not created here.
+func (i *Instance) addPURules(contextID, appChain, netChain, proxySetName, targetSetName string, containerInfo *policy.PUInfo) error {
+	policyrules := containerInfo.Policy
+
 	// Install the PU specific chain first.
 	if err := i.addContainerChain(appChain, netChain); err != nil {
 		return err
@@ -448,16 +554,23 @@ func (i *Instance) installRules(contextID, appChain, netChain, proxySetName stri
 		}
 	}
 
-	if err := i.addPacketTrap(appChain, netChain, containerInfo.Policy.TriremeNetworks()); err != nil {
+	direction := containerInfo.Runtime.Options().EnforcementDirection
+
+	if err := i.addPacketTrap(appChain, netChain, targetSetName, direction); err != nil {
 		return err
 	}
 
-	if err := i.addAppACLs(contextID, appChain, netChain, policyrules.ApplicationACLs()); err != nil {
-		return err
+	// The ACLs of a direction that is not enforced are not installed.
+	if direction.Egress() {
+		if err := i.addAppACLs(contextID, appChain, netChain, policyrules.ApplicationACLs()); err != nil {
+			return err
+		}
 	}
 
-	if err := i.addNetACLs(contextID, appChain, netChain, policyrules.NetworkACLs()); err != nil {
-		return err
+	if direction.Ingress() {
+		if err := i.addNetACLs(contextID, appChain, netChain, policyrules.NetworkACLs()); err != nil {
+			return err
+		}
 	}
 
 	return i.addExclusionACLs(appChain, netChain, policyrules.ExcludedNetworks())
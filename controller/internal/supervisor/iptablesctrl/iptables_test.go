@@ -17,7 +17,7 @@ import (
 func TestNewInstance(t *testing.T) {
 	Convey("When I create a new iptables instance", t, func() {
 		Convey("If I create a remote implemenetation and iptables exists", func() {
-			i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+			i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 			Convey("It should succeed", func() {
 				So(i, ShouldNotBeNil)
 				So(err, ShouldBeNil)
@@ -25,12 +25,20 @@ func TestNewInstance(t *testing.T) {
 				So(i.netPacketIPTableSection, ShouldResemble, "INPUT")
 			})
 		})
+
+		Convey("If I provide an invalid interface name", func() {
+			i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), []string{"eth0", "averylonginterface"})
+			Convey("It should fail", func() {
+				So(i, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
 	})
 }
 
 func TestChainName(t *testing.T) {
 	Convey("When I test the creation of the name of the chain", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		Convey("With a contextID of Context and version of 1", func() {
 			app, net, err := i.chainName("Context", 1)
 			So(err, ShouldBeNil)
@@ -47,7 +55,7 @@ func TestChainName(t *testing.T) {
 
 func TestConfigureRules(t *testing.T) {
 	Convey("Given an iptables controllers", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -219,9 +227,73 @@ func TestConfigureRules(t *testing.T) {
 	})
 }
 
+func TestValidateRules(t *testing.T) {
+	Convey("Given an iptables controller that can't program iptables", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			return errors.New("iptables should not be programmed")
+		})
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			return errors.New("iptables should not be programmed")
+		})
+		iptables.MockNewChain(t, func(table string, chain string) error {
+			return errors.New("iptables should not be programmed")
+		})
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		puInfo := func(rules policy.IPRuleList, networks []string) *policy.PUInfo {
+			info := policy.NewPUInfo("Context", common.ContainerPU)
+			info.Policy = policy.NewPUPolicy("Context", policy.Police, rules, rules, nil, nil, nil, nil, nil, nil, networks, []string{}, []string{}, nil, nil, []string{})
+			info.Runtime = policy.NewPURuntimeWithDefaults()
+			return info
+		}
+
+		Convey("When the rules are valid, they should be built without programming iptables", func() {
+			So(i.ValidateRules(1, "Context", puInfo(rules, []string{"10.1.0.0/16", "10.2.0.1"})), ShouldBeNil)
+		})
+
+		Convey("When a rule has no port, I should get an error", func() {
+			invalid := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+			}
+			So(i.ValidateRules(1, "Context", puInfo(invalid, nil)), ShouldNotBeNil)
+		})
+
+		Convey("When a target network is invalid, I should get an error", func() {
+			So(i.ValidateRules(1, "Context", puInfo(rules, []string{"10.1.0.0/33"})), ShouldNotBeNil)
+		})
+
+		Convey("When the PU has no proxy port, I should get an error", func() {
+			info := puInfo(rules, nil)
+			info.Runtime.SetOptions(policy.OptionsType{})
+			So(i.ValidateRules(1, "Context", info), ShouldNotBeNil)
+		})
+
+		Convey("When a linux process has no mark, I should get an error", func() {
+			i.mode = constants.LocalServer
+			So(i.ValidateRules(1, "Context", puInfo(rules, nil)), ShouldNotBeNil)
+		})
+	})
+}
+
 func TestDeleteRules(t *testing.T) {
 	Convey("Given an iptables controllers", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -244,7 +316,7 @@ func TestDeleteRules(t *testing.T) {
 
 func TestUpdateRules(t *testing.T) {
 	Convey("Given an iptables controllers", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -350,7 +422,7 @@ func TestUpdateRules(t *testing.T) {
 
 func TestStart(t *testing.T) {
 	Convey("Given an iptables controllers,", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
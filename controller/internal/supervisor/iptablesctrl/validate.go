@@ -0,0 +1,95 @@
+package iptablesctrl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// ValidateRules builds the rules of the given version for the PU without
+// installing them and returns the first error. The rules are passed to a
+// provider that only checks them, and no set is created.
+func (i *Instance) ValidateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if containerInfo.Policy == nil {
+		return errors.New("policy rules cannot be nil")
+	}
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return err
+	}
+
+	targetSetName := targetNetworkSet
+	if networks := containerInfo.Policy.TriremeNetworks(); len(networks) > 0 {
+		for _, network := range networks {
+			if _, _, err := net.ParseCIDR(network); err != nil && net.ParseIP(network) == nil {
+				return fmt.Errorf("invalid target network %s", network)
+			}
+		}
+		targetSetName = puTargetSetName(contextID, version)
+	}
+
+	dryRun := *i
+	dryRun.ipt = &ruleChecker{}
+
+	return dryRun.addPURules(contextID, appChain, netChain, puPortSetName(contextID, proxyPortSetPrefix), targetSetName, containerInfo)
+}
+
+// ruleChecker is an iptables provider that checks the rules instead of
+// programming them. A rule with an empty argument is rejected, since
+// iptables would reject it.
+type ruleChecker struct{}
+
+func (r *ruleChecker) Append(table, chain string, rulespec ...string) error {
+	return checkRule(table, chain, rulespec)
+}
+
+func (r *ruleChecker) Insert(table, chain string, pos int, rulespec ...string) error {
+	return checkRule(table, chain, rulespec)
+}
+
+func (r *ruleChecker) Delete(table, chain string, rulespec ...string) error {
+	return nil
+}
+
+func (r *ruleChecker) ListChains(table string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (r *ruleChecker) ClearChain(table, chain string) error {
+	return nil
+}
+
+func (r *ruleChecker) DeleteChain(table, chain string) error {
+	return nil
+}
+
+func (r *ruleChecker) NewChain(table, chain string) error {
+	if chain == "" {
+		return fmt.Errorf("empty chain name in table %s", table)
+	}
+	return nil
+}
+
+func (r *ruleChecker) Commit() error {
+	return nil
+}
+
+// checkRule returns an error if the rule has an empty argument.
+func checkRule(table, chain string, rulespec []string) error {
+
+	if chain == "" {
+		return fmt.Errorf("empty chain name in table %s", table)
+	}
+
+	for n, arg := range rulespec {
+		if arg == "" {
+			return fmt.Errorf("invalid rule for table %s, chain %s: empty argument %d in %v", table, chain, n, rulespec)
+		}
+	}
+
+	return nil
+}
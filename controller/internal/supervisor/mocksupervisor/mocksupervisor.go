@@ -96,6 +96,48 @@ func (mr *MockSupervisorMockRecorder) SetTargetNetworks(arg0 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTargetNetworks", reflect.TypeOf((*MockSupervisor)(nil).SetTargetNetworks), arg0)
 }
 
+// SetExcludedPorts mocks base method
+// nolint
+func (m *MockSupervisor) SetExcludedPorts(arg0 []string) error {
+	ret := m.ctrl.Call(m, "SetExcludedPorts", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetExcludedPorts indicates an expected call of SetExcludedPorts
+// nolint
+func (mr *MockSupervisorMockRecorder) SetExcludedPorts(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetExcludedPorts", reflect.TypeOf((*MockSupervisor)(nil).SetExcludedPorts), arg0)
+}
+
+// UpdateAddressSet mocks base method
+// nolint
+func (m *MockSupervisor) UpdateAddressSet(arg0 string, arg1 []string) error {
+	ret := m.ctrl.Call(m, "UpdateAddressSet", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateAddressSet indicates an expected call of UpdateAddressSet
+// nolint
+func (mr *MockSupervisorMockRecorder) UpdateAddressSet(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAddressSet", reflect.TypeOf((*MockSupervisor)(nil).UpdateAddressSet), arg0, arg1)
+}
+
+// RemoveAddressSet mocks base method
+// nolint
+func (m *MockSupervisor) RemoveAddressSet(arg0 string) error {
+	ret := m.ctrl.Call(m, "RemoveAddressSet", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveAddressSet indicates an expected call of RemoveAddressSet
+// nolint
+func (mr *MockSupervisorMockRecorder) RemoveAddressSet(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveAddressSet", reflect.TypeOf((*MockSupervisor)(nil).RemoveAddressSet), arg0)
+}
+
 // CleanUp mocks base method
 // nolint
 func (m *MockSupervisor) CleanUp() error {
@@ -110,6 +152,35 @@ func (mr *MockSupervisorMockRecorder) CleanUp() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CleanUp", reflect.TypeOf((*MockSupervisor)(nil).CleanUp))
 }
 
+// PolicyVersion mocks base method
+// nolint
+func (m *MockSupervisor) PolicyVersion(contextID string) (int, error) {
+	ret := m.ctrl.Call(m, "PolicyVersion", contextID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PolicyVersion indicates an expected call of PolicyVersion
+// nolint
+func (mr *MockSupervisorMockRecorder) PolicyVersion(contextID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PolicyVersion", reflect.TypeOf((*MockSupervisor)(nil).PolicyVersion), contextID)
+}
+
+// Validate mocks base method
+// nolint
+func (m *MockSupervisor) Validate(contextID string, puInfo *policy.PUInfo) error {
+	ret := m.ctrl.Call(m, "Validate", contextID, puInfo)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Validate indicates an expected call of Validate
+// nolint
+func (mr *MockSupervisorMockRecorder) Validate(contextID, puInfo interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Validate", reflect.TypeOf((*MockSupervisor)(nil).Validate), contextID, puInfo)
+}
+
 // MockImplementor is a mock of Implementor interface
 // nolint
 type MockImplementor struct {
@@ -165,6 +236,20 @@ func (mr *MockImplementorMockRecorder) UpdateRules(version, contextID, container
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRules", reflect.TypeOf((*MockImplementor)(nil).UpdateRules), version, contextID, containerInfo, oldContainerInfo)
 }
 
+// ValidateRules mocks base method
+// nolint
+func (m *MockImplementor) ValidateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+	ret := m.ctrl.Call(m, "ValidateRules", version, contextID, containerInfo)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ValidateRules indicates an expected call of ValidateRules
+// nolint
+func (mr *MockImplementorMockRecorder) ValidateRules(version, contextID, containerInfo interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateRules", reflect.TypeOf((*MockImplementor)(nil).ValidateRules), version, contextID, containerInfo)
+}
+
 // DeleteRules mocks base method
 // nolint
 func (m *MockImplementor) DeleteRules(version int, context, tcpPorts, udpPorts, mark, uid, proxyPort string) error {
@@ -193,6 +278,48 @@ func (mr *MockImplementorMockRecorder) SetTargetNetworks(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTargetNetworks", reflect.TypeOf((*MockImplementor)(nil).SetTargetNetworks), arg0, arg1)
 }
 
+// SetExcludedPorts mocks base method
+// nolint
+func (m *MockImplementor) SetExcludedPorts(arg0 []string) error {
+	ret := m.ctrl.Call(m, "SetExcludedPorts", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetExcludedPorts indicates an expected call of SetExcludedPorts
+// nolint
+func (mr *MockImplementorMockRecorder) SetExcludedPorts(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetExcludedPorts", reflect.TypeOf((*MockImplementor)(nil).SetExcludedPorts), arg0)
+}
+
+// UpdateAddressSet mocks base method
+// nolint
+func (m *MockImplementor) UpdateAddressSet(arg0 string, arg1 []string) error {
+	ret := m.ctrl.Call(m, "UpdateAddressSet", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateAddressSet indicates an expected call of UpdateAddressSet
+// nolint
+func (mr *MockImplementorMockRecorder) UpdateAddressSet(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAddressSet", reflect.TypeOf((*MockImplementor)(nil).UpdateAddressSet), arg0, arg1)
+}
+
+// RemoveAddressSet mocks base method
+// nolint
+func (m *MockImplementor) RemoveAddressSet(arg0 string) error {
+	ret := m.ctrl.Call(m, "RemoveAddressSet", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveAddressSet indicates an expected call of RemoveAddressSet
+// nolint
+func (mr *MockImplementorMockRecorder) RemoveAddressSet(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveAddressSet", reflect.TypeOf((*MockImplementor)(nil).RemoveAddressSet), arg0)
+}
+
 // Run mocks base method
 // nolint
 func (m *MockImplementor) Run(ctx context.Context) error {
@@ -30,6 +30,14 @@ type ProxyInfo struct {
 	prochdl        processmon.ProcessManager
 	rpchdl         rpcwrapper.RPCClient
 	initDone       map[string]bool
+	// targetNetworks are the target networks of the PUs without their own.
+	targetNetworks []string
+	// puNetworks holds the target networks of the policy of every PU.
+	puNetworks map[string][]string
+	// excludedPorts are the ports whose flows bypass the enforcement.
+	excludedPorts []string
+	// addressSets are the addresses of the address sets of the ACLs.
+	addressSets map[string][]string
 
 	sync.Mutex
 }
@@ -39,8 +47,11 @@ func (s *ProxyInfo) Supervise(contextID string, puInfo *policy.PUInfo) error {
 
 	s.Lock()
 	_, ok := s.initDone[contextID]
+	changed := !stringsEqual(s.puNetworks[contextID], puInfo.Policy.TriremeNetworks())
 	s.Unlock()
-	if !ok {
+	if !ok || changed {
+		// The remote supervisor is initialized again when the target
+		// networks of the PU change.
 		err := s.InitRemoteSupervisor(contextID, puInfo)
 		if err != nil {
 			return err
@@ -57,6 +68,7 @@ func (s *ProxyInfo) Supervise(contextID string, puInfo *policy.PUInfo) error {
 	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.Supervise, req, &rpcwrapper.Response{}); err != nil {
 		s.Lock()
 		delete(s.initDone, contextID)
+		delete(s.puNetworks, contextID)
 		s.Unlock()
 		return fmt.Errorf("unable to send supervise command for context id %s: %s", contextID, err)
 	}
@@ -69,6 +81,7 @@ func (s *ProxyInfo) Supervise(contextID string, puInfo *policy.PUInfo) error {
 func (s *ProxyInfo) Unsupervise(contextID string) error {
 	s.Lock()
 	delete(s.initDone, contextID)
+	delete(s.puNetworks, contextID)
 	s.Unlock()
 
 	s.prochdl.KillProcess(contextID)
@@ -76,16 +89,20 @@ func (s *ProxyInfo) Unsupervise(contextID string) error {
 	return nil
 }
 
-// SetTargetNetworks sets the target networks in case of an  update
+// SetTargetNetworks sets the target networks in case of an  update. The
+// PUs with their own target networks are not updated.
 func (s *ProxyInfo) SetTargetNetworks(networks []string) error {
 	s.Lock()
 	defer s.Unlock()
+	s.targetNetworks = networks
 	for contextID, done := range s.initDone {
-		if done {
+		if done && len(s.puNetworks[contextID]) == 0 {
 			request := &rpcwrapper.Request{
 				Payload: &rpcwrapper.InitSupervisorPayload{
 					TriremeNetworks: networks,
 					CaptureMethod:   rpcwrapper.IPTables,
+					ExcludedPorts:   s.excludedPorts,
+					AddressSets:     s.addressSets,
 				},
 			}
 
@@ -98,6 +115,92 @@ func (s *ProxyInfo) SetTargetNetworks(networks []string) error {
 	return nil
 }
 
+// SetExcludedPorts sets the ports whose flows bypass the enforcement. The
+// remote supervisors are initialized again with their target networks and
+// the new ports.
+func (s *ProxyInfo) SetExcludedPorts(ports []string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.excludedPorts = ports
+	for contextID, done := range s.initDone {
+		if !done {
+			continue
+		}
+
+		networks := s.targetNetworks
+		if len(s.puNetworks[contextID]) > 0 {
+			networks = s.puNetworks[contextID]
+		}
+
+		request := &rpcwrapper.Request{
+			Payload: &rpcwrapper.InitSupervisorPayload{
+				TriremeNetworks: networks,
+				CaptureMethod:   rpcwrapper.IPTables,
+				ExcludedPorts:   ports,
+				AddressSets:     s.addressSets,
+			},
+		}
+
+		if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.InitSupervisor, request, &rpcwrapper.Response{}); err != nil {
+			return fmt.Errorf("unable to initialize remote supervisor for contextid %s: %s", contextID, err)
+		}
+	}
+
+	return nil
+}
+
+// UpdateAddressSet sets the addresses of an address set on the remote
+// supervisors. The remote supervisors initialized later get them with
+// their initialization.
+func (s *ProxyInfo) UpdateAddressSet(name string, addresses []string) error {
+	return s.updateAddressSet(&rpcwrapper.AddressSetPayload{
+		Name:      name,
+		Addresses: addresses,
+	})
+}
+
+// RemoveAddressSet removes the addresses of an address set on the remote
+// supervisors.
+func (s *ProxyInfo) RemoveAddressSet(name string) error {
+	return s.updateAddressSet(&rpcwrapper.AddressSetPayload{
+		Name:   name,
+		Remove: true,
+	})
+}
+
+func (s *ProxyInfo) updateAddressSet(payload *rpcwrapper.AddressSetPayload) error {
+	s.Lock()
+	defer s.Unlock()
+
+	// The map is copied since the initialization payloads share it.
+	addressSets := make(map[string][]string, len(s.addressSets)+1)
+	for name, addresses := range s.addressSets {
+		addressSets[name] = addresses
+	}
+	if payload.Remove {
+		delete(addressSets, payload.Name)
+	} else {
+		addressSets[payload.Name] = payload.Addresses
+	}
+	s.addressSets = addressSets
+
+	request := &rpcwrapper.Request{
+		Payload: payload,
+	}
+
+	for contextID, done := range s.initDone {
+		if !done {
+			continue
+		}
+
+		if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.UpdateSupervisorAddressSet, request, &rpcwrapper.Response{}); err != nil {
+			return fmt.Errorf("unable to update address set %s for contextid %s: %s", payload.Name, contextID, err)
+		}
+	}
+
+	return nil
+}
+
 // CleanUp implements the cleanup interface
 func (s *ProxyInfo) CleanUp() error {
 	for c := range s.initDone {
@@ -106,13 +209,47 @@ func (s *ProxyInfo) CleanUp() error {
 	return nil
 }
 
+// PolicyVersion does the RPC call for PolicyVersion to the remote supervisor
+// of the PU.
+func (s *ProxyInfo) PolicyVersion(contextID string) (int, error) {
+
+	resp := &rpcwrapper.Response{}
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.PolicyVersionPayload{
+			ContextID: contextID,
+		},
+	}
+
+	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.SupervisorPolicyVersion, request, resp); err != nil {
+		return 0, fmt.Errorf("Failed to get policy version. status %s: %s", resp.Status, err)
+	}
+
+	version, ok := resp.Payload.(int)
+	if !ok {
+		return 0, fmt.Errorf("invalid policy version from remote supervisor: %T", resp.Payload)
+	}
+
+	return version, nil
+}
+
+// Validate only checks the PU info. The rules are built by the remote
+// supervisor, which is not started before the PU is supervised.
+func (s *ProxyInfo) Validate(contextID string, puInfo *policy.PUInfo) error {
+	if puInfo == nil || puInfo.Policy == nil || puInfo.Runtime == nil {
+		return errors.New("Invalid PU or policy info")
+	}
+
+	return nil
+}
+
 // Run runs the proxy supervisor and initializes the cleaners.
 func (s *ProxyInfo) Run(ctx context.Context) error {
 	return nil
 }
 
-// NewProxySupervisor creates a new IptablesSupervisor launcher
-func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.Enforcer, rpchdl rpcwrapper.RPCClient) (*ProxyInfo, error) {
+// NewProxySupervisor creates a new IptablesSupervisor launcher. The target
+// networks are used by the PUs without their own target networks.
+func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.Enforcer, rpchdl rpcwrapper.RPCClient, targetNetworks []string) (*ProxyInfo, error) {
 
 	if collector == nil {
 		return nil, errors.New("collector cannot be nil")
@@ -129,6 +266,9 @@ func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.En
 		prochdl:        processmon.GetProcessManagerHdl(),
 		rpchdl:         rpchdl,
 		initDone:       make(map[string]bool),
+		targetNetworks: targetNetworks,
+		puNetworks:     make(map[string][]string),
+		addressSets:    map[string][]string{},
 		ExcludedIPs:    []string{},
 	}
 
@@ -136,13 +276,28 @@ func NewProxySupervisor(collector collector.EventCollector, enforcer enforcer.En
 
 }
 
-//InitRemoteSupervisor calls initsupervisor method on the remote
+//InitRemoteSupervisor calls initsupervisor method on the remote. The remote
+//supervisor uses the target networks of the PU, or the ones of the node if
+//the PU has none.
 func (s *ProxyInfo) InitRemoteSupervisor(contextID string, puInfo *policy.PUInfo) error {
 
+	puNetworks := puInfo.Policy.TriremeNetworks()
+
+	s.Lock()
+	networks := s.targetNetworks
+	excludedPorts := s.excludedPorts
+	addressSets := s.addressSets
+	s.Unlock()
+	if len(puNetworks) > 0 {
+		networks = puNetworks
+	}
+
 	request := &rpcwrapper.Request{
 		Payload: &rpcwrapper.InitSupervisorPayload{
-			TriremeNetworks: puInfo.Policy.TriremeNetworks(),
+			TriremeNetworks: networks,
 			CaptureMethod:   rpcwrapper.IPTables,
+			ExcludedPorts:   excludedPorts,
+			AddressSets:     addressSets,
 		},
 	}
 
@@ -152,6 +307,7 @@ func (s *ProxyInfo) InitRemoteSupervisor(contextID string, puInfo *policy.PUInfo
 
 	s.Lock()
 	s.initDone[contextID] = true
+	s.puNetworks[contextID] = puNetworks
 	s.Unlock()
 
 	return nil
@@ -174,3 +330,20 @@ func (s *ProxyInfo) AddExcludedIPs(ips []string) error {
 	}
 	return nil
 }
+
+// stringsEqual returns true if the lists hold the same strings in the same
+// order.
+func stringsEqual(a, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -14,7 +14,12 @@ type mockedMethods struct {
 	UnsuperviseMock       func(string) error
 	RunMock               func(ctx context.Context) error
 	SetTargetNetworksMock func([]string) error
+	SetExcludedPortsMock  func([]string) error
+	UpdateAddressSetMock  func(string, []string) error
+	RemoveAddressSetMock  func(string) error
 	CleanUpMock           func() error
+	PolicyVersionMock     func(string) (int, error)
+	ValidateMock          func(string, *policy.PUInfo) error
 }
 
 // TestSupervisorLauncher is a mock
@@ -67,10 +72,30 @@ func (m *testSupervisorLauncher) MockSetTargetNetworks(t *testing.T, impl func([
 	m.currentMocks(t).SetTargetNetworksMock = impl
 }
 
+func (m *testSupervisorLauncher) MockSetExcludedPorts(t *testing.T, impl func([]string) error) {
+	m.currentMocks(t).SetExcludedPortsMock = impl
+}
+
+func (m *testSupervisorLauncher) MockUpdateAddressSet(t *testing.T, impl func(string, []string) error) {
+	m.currentMocks(t).UpdateAddressSetMock = impl
+}
+
+func (m *testSupervisorLauncher) MockRemoveAddressSet(t *testing.T, impl func(string) error) {
+	m.currentMocks(t).RemoveAddressSetMock = impl
+}
+
 func (m *testSupervisorLauncher) MockCleanUp(t *testing.T, impl func() error) {
 	m.currentMocks(t).CleanUpMock = impl
 }
 
+func (m *testSupervisorLauncher) MockPolicyVersion(t *testing.T, impl func(string) (int, error)) {
+	m.currentMocks(t).PolicyVersionMock = impl
+}
+
+func (m *testSupervisorLauncher) MockValidate(t *testing.T, impl func(string, *policy.PUInfo) error) {
+	m.currentMocks(t).ValidateMock = impl
+}
+
 func (m *testSupervisorLauncher) Supervise(contextID string, puInfo *policy.PUInfo) error {
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.SuperviseMock != nil {
 		return mock.SuperviseMock(contextID, puInfo)
@@ -99,9 +124,44 @@ func (m *testSupervisorLauncher) SetTargetNetworks(networks []string) error {
 	return nil
 }
 
+func (m *testSupervisorLauncher) SetExcludedPorts(ports []string) error {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.SetExcludedPortsMock != nil {
+		return mock.SetExcludedPortsMock(ports)
+	}
+	return nil
+}
+
+func (m *testSupervisorLauncher) UpdateAddressSet(name string, addresses []string) error {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.UpdateAddressSetMock != nil {
+		return mock.UpdateAddressSetMock(name, addresses)
+	}
+	return nil
+}
+
+func (m *testSupervisorLauncher) RemoveAddressSet(name string) error {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.RemoveAddressSetMock != nil {
+		return mock.RemoveAddressSetMock(name)
+	}
+	return nil
+}
+
 func (m *testSupervisorLauncher) CleanUp() error {
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.CleanUpMock != nil {
 		return mock.CleanUpMock()
 	}
 	return nil
 }
+
+func (m *testSupervisorLauncher) PolicyVersion(contextID string) (int, error) {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.PolicyVersionMock != nil {
+		return mock.PolicyVersionMock(contextID)
+	}
+	return 0, nil
+}
+
+func (m *testSupervisorLauncher) Validate(contextID string, puInfo *policy.PUInfo) error {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.ValidateMock != nil {
+		return mock.ValidateMock(contextID, puInfo)
+	}
+	return nil
+}
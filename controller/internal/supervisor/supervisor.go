@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"sync"
 
 	"go.uber.org/zap"
@@ -19,10 +20,16 @@ import (
 	"go.aporeto.io/trireme-lib/controller/pkg/packetprocessor"
 	"go.aporeto.io/trireme-lib/policy"
 	"go.aporeto.io/trireme-lib/utils/cache"
+	"go.aporeto.io/trireme-lib/utils/portspec"
 )
 
 type cacheData struct {
-	version       int
+	// version selects the set of chains of the rules. It alternates between
+	// the two sets on every update so that the changes are hitless.
+	version int
+	// policyVersion counts the policies installed for the PU. It starts at 1
+	// and only increases.
+	policyVersion int
 	ips           policy.ExtendedMap
 	mark          string
 	tcpPorts      string
@@ -49,6 +56,10 @@ type Config struct {
 	excludedIPs []string
 	// triremeNetworks are the target networks where Trireme is implemented
 	triremeNetworks []string
+	// excludedPorts are the ports whose flows bypass the enforcement
+	excludedPorts []string
+	// addressSets are the addresses of the address sets of the ACLs
+	addressSets map[string][]string
 	// service is an external packet service
 	service packetprocessor.PacketProcessor
 
@@ -58,8 +69,10 @@ type Config struct {
 // NewSupervisor will create a new connection supervisor that uses IPTables
 // to redirect specific packets to userspace. It instantiates multiple data stores
 // to maintain efficient mappings between contextID, policy and IP addresses. This
-// simplifies the lookup operations at the expense of memory.
-func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer.Enforcer, mode constants.ModeType, networks []string, p packetprocessor.PacketProcessor) (*Config, error) {
+// simplifies the lookup operations at the expense of memory. The policy is
+// only enforced on the given network interfaces, or on all of them if there
+// are none.
+func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer.Enforcer, mode constants.ModeType, networks []string, interfaces []string, p packetprocessor.PacketProcessor) (*Config, error) {
 
 	if collector == nil || enforcerInstance == nil {
 		return nil, errors.New("Invalid parameters")
@@ -75,7 +88,7 @@ func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer
 		return nil, errors.New("portSetInstance cannot be nil")
 	}
 
-	impl, err := iptablesctrl.NewInstance(filterQueue, mode, portSetInstance)
+	impl, err := iptablesctrl.NewInstance(filterQueue, mode, portSetInstance, interfaces)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize supervisor controllers: %s", err)
 	}
@@ -92,6 +105,7 @@ func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer
 		filterQueue:     filterQueue,
 		excludedIPs:     []string{},
 		triremeNetworks: networks,
+		addressSets:     map[string][]string{},
 		portSetInstance: portSetInstance,
 		service:         p,
 	}, nil
@@ -152,6 +166,16 @@ func (s *Config) Run(ctx context.Context) error {
 		return err
 	}
 
+	if err := s.impl.SetExcludedPorts(s.excludedPorts); err != nil {
+		return err
+	}
+
+	for name, addresses := range s.addressSets {
+		if err := s.impl.UpdateAddressSet(name, addresses); err != nil {
+			return err
+		}
+	}
+
 	if s.service != nil {
 		s.service.Initialize(s.filterQueue, s.impl.ACLProvider())
 	}
@@ -187,12 +211,115 @@ func (s *Config) SetTargetNetworks(networks []string) error {
 	return nil
 }
 
+// SetExcludedPorts sets the ports whose flows bypass the enforcement. The
+// ports are single ports or ranges such as 8000:8100.
+func (s *Config) SetExcludedPorts(ports []string) error {
+
+	for _, port := range ports {
+		if _, err := portspec.NewPortSpecFromString(port, nil); err != nil {
+			return fmt.Errorf("invalid excluded port %s: %s", port, err)
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.impl.SetExcludedPorts(ports); err != nil {
+		return err
+	}
+
+	s.excludedPorts = ports
+
+	return nil
+}
+
+// UpdateAddressSet sets the addresses of an address set. The addresses are
+// IPv4 addresses or networks, and the ACLs of all the PUs that reference
+// the set apply to them.
+func (s *Config) UpdateAddressSet(name string, addresses []string) error {
+
+	if name == "" {
+		return errors.New("address set name cannot be empty")
+	}
+
+	for _, address := range addresses {
+		if _, _, err := net.ParseCIDR(address); err == nil {
+			continue
+		}
+		if ip := net.ParseIP(address); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid address %s in address set %s", address, name)
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.impl.UpdateAddressSet(name, addresses); err != nil {
+		return err
+	}
+
+	s.addressSets[name] = addresses
+
+	return nil
+}
+
+// RemoveAddressSet removes the addresses of an address set. The ACLs that
+// reference the set no longer match any address.
+func (s *Config) RemoveAddressSet(name string) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.impl.RemoveAddressSet(name); err != nil {
+		return err
+	}
+
+	delete(s.addressSets, name)
+
+	return nil
+}
+
 // ACLProvider returns the ACL provider used by the supervisor that can be
 // shared with other entities.
 func (s *Config) ACLProvider() provider.IptablesProvider {
 	return s.impl.ACLProvider()
 }
 
+// PolicyVersion returns the version of the rules that are currently installed
+// for the given PU. A PU whose rules failed to install is not supervised
+// and an error is returned.
+func (s *Config) PolicyVersion(contextID string) (int, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := s.versionTracker.Get(contextID)
+	if err != nil {
+		return 0, fmt.Errorf("cannot find policy version: %s", err)
+	}
+
+	return data.(*cacheData).policyVersion, nil
+}
+
+// Validate builds the rules of the PU without installing them and returns
+// the first error. The rules are built for the version that Supervise would
+// install.
+func (s *Config) Validate(contextID string, pu *policy.PUInfo) error {
+	if pu == nil || pu.Policy == nil || pu.Runtime == nil {
+		return errors.New("Invalid PU or policy info")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	version := 0
+	if data, err := s.versionTracker.Get(contextID); err == nil {
+		version = data.(*cacheData).version ^ 1
+	}
+
+	return s.impl.ValidateRules(version, contextID, pu)
+}
+
 func (s *Config) doCreatePU(contextID string, pu *policy.PUInfo) error {
 
 	s.Lock()
@@ -201,6 +328,7 @@ func (s *Config) doCreatePU(contextID string, pu *policy.PUInfo) error {
 	tcpPorts, udpPorts := common.ConvertServicesToProtocolPortList(pu.Runtime.Options().Services)
 	c := &cacheData{
 		version:       0,
+		policyVersion: 1,
 		ips:           pu.Policy.IPAddresses(),
 		mark:          pu.Runtime.Options().CgroupMark,
 		tcpPorts:      tcpPorts,
@@ -241,6 +369,8 @@ func (s *Config) doUpdatePU(contextID string, pu *policy.PUInfo) error {
 		return err
 	}
 
+	c.policyVersion++
+
 	return nil
 }
 
@@ -83,7 +83,7 @@ func TestNewSupervisor(t *testing.T) {
 		mode := constants.LocalServer
 
 		Convey("When I provide correct parameters", func() {
-			s, err := NewSupervisor(c, e, mode, []string{}, nil)
+			s, err := NewSupervisor(c, e, mode, []string{}, nil, nil)
 			Convey("I should not get an error ", func() {
 				So(err, ShouldBeNil)
 				So(s, ShouldNotBeNil)
@@ -92,7 +92,7 @@ func TestNewSupervisor(t *testing.T) {
 		})
 
 		Convey("When I provide a nil  collector", func() {
-			s, err := NewSupervisor(nil, e, mode, []string{}, nil)
+			s, err := NewSupervisor(nil, e, mode, []string{}, nil, nil)
 			Convey("I should get an error ", func() {
 				So(err, ShouldNotBeNil)
 				So(s, ShouldBeNil)
@@ -100,7 +100,7 @@ func TestNewSupervisor(t *testing.T) {
 		})
 
 		Convey("When I provide a nil enforcer", func() {
-			s, err := NewSupervisor(c, nil, mode, []string{}, nil)
+			s, err := NewSupervisor(c, nil, mode, []string{}, nil, nil)
 			Convey("I should get an error ", func() {
 				So(err, ShouldNotBeNil)
 				So(s, ShouldBeNil)
@@ -127,7 +127,7 @@ func TestSupervise(t *testing.T) {
 		}
 		e := enforcer.NewWithDefaults("serverID", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
 
-		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{}, nil)
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{}, nil, nil)
 		So(s, ShouldNotBeNil)
 
 		impl := mocksupervisor.NewMockImplementor(ctrl)
@@ -185,6 +185,125 @@ func TestSupervise(t *testing.T) {
 	})
 }
 
+func TestPolicyVersion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a valid supervisor", t, func() {
+		c := &collector.DefaultCollector{}
+		scrts := secrets.NewPSKSecrets([]byte("test password"))
+
+		prevRawSocket := nfqdatapath.GetUDPRawSocket
+		defer func() {
+			nfqdatapath.GetUDPRawSocket = prevRawSocket
+		}()
+		nfqdatapath.GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+		e := enforcer.NewWithDefaults("serverID", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{}, nil, nil)
+		So(s, ShouldNotBeNil)
+
+		impl := mocksupervisor.NewMockImplementor(ctrl)
+		s.impl = impl
+
+		puInfo := createPUInfo()
+
+		Convey("When I query the version of an unknown PU, I should get an error", func() {
+			_, err := s.PolicyVersion("contextID")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I supervise a new PU, I should get the first version", func() {
+			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+			version, err := s.PolicyVersion("contextID")
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 1)
+		})
+
+		Convey("When I update a PU several times, I should get an increasing version", func() {
+			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			impl.EXPECT().UpdateRules(1, "contextID", gomock.Any(), gomock.Any()).Return(nil)
+			impl.EXPECT().UpdateRules(0, "contextID", gomock.Any(), gomock.Any()).Return(nil)
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+			version, err := s.PolicyVersion("contextID")
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 2)
+
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+			version, err = s.PolicyVersion("contextID")
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 3)
+		})
+
+		Convey("When the update of a PU fails, I should get an error", func() {
+			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			impl.EXPECT().UpdateRules(1, "contextID", gomock.Any(), gomock.Any()).Return(errors.New("error"))
+			impl.EXPECT().DeleteRules(1, "contextID", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+			So(s.Supervise("contextID", puInfo), ShouldNotBeNil)
+			_, err := s.PolicyVersion("contextID")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestValidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a valid supervisor", t, func() {
+		c := &collector.DefaultCollector{}
+		scrts := secrets.NewPSKSecrets([]byte("test password"))
+
+		prevRawSocket := nfqdatapath.GetUDPRawSocket
+		defer func() {
+			nfqdatapath.GetUDPRawSocket = prevRawSocket
+		}()
+		nfqdatapath.GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+		e := enforcer.NewWithDefaults("serverID", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{}, nil, nil)
+		So(s, ShouldNotBeNil)
+
+		impl := mocksupervisor.NewMockImplementor(ctrl)
+		s.impl = impl
+
+		puInfo := createPUInfo()
+
+		Convey("When I validate a nil PU, I should get an error", func() {
+			So(s.Validate("contextID", nil), ShouldNotBeNil)
+		})
+
+		Convey("When I validate a new PU, the rules of the first version should be built", func() {
+			impl.EXPECT().ValidateRules(0, "contextID", puInfo).Return(nil)
+			So(s.Validate("contextID", puInfo), ShouldBeNil)
+			_, err := s.PolicyVersion("contextID")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I validate a supervised PU, the rules of the next version should be built", func() {
+			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			impl.EXPECT().ValidateRules(1, "contextID", puInfo).Return(nil)
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+			So(s.Validate("contextID", puInfo), ShouldBeNil)
+			version, err := s.PolicyVersion("contextID")
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 1)
+		})
+
+		Convey("When the rules can't be built, I should get the error", func() {
+			impl.EXPECT().ValidateRules(0, "contextID", puInfo).Return(errors.New("error"))
+			So(s.Validate("contextID", puInfo), ShouldNotBeNil)
+		})
+	})
+}
+
 func TestUnsupervise(t *testing.T) {
 
 	ctrl := gomock.NewController(t)
@@ -204,7 +323,7 @@ func TestUnsupervise(t *testing.T) {
 
 		e := enforcer.NewWithDefaults("serverID", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
 
-		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"}, nil)
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"}, nil, nil)
 		So(s, ShouldNotBeNil)
 
 		impl := mocksupervisor.NewMockImplementor(ctrl)
@@ -250,7 +369,7 @@ func TestStart(t *testing.T) {
 
 		e := enforcer.NewWithDefaults("serverID", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
 
-		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"}, nil)
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"}, nil, nil)
 		So(s, ShouldNotBeNil)
 
 		impl := mocksupervisor.NewMockImplementor(ctrl)
@@ -259,6 +378,7 @@ func TestStart(t *testing.T) {
 		Convey("When I try to start it and the implementor works", func() {
 			impl.EXPECT().Run(gomock.Any()).Return(nil)
 			impl.EXPECT().SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}).Return(nil)
+			impl.EXPECT().SetExcludedPorts(nil).Return(nil)
 			err := s.Run(context.Background())
 			Convey("I should get no errors", func() {
 				So(err, ShouldBeNil)
@@ -275,6 +395,105 @@ func TestStart(t *testing.T) {
 	})
 }
 
+func TestSetExcludedPorts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a properly configured supervisor", t, func() {
+		c := &collector.DefaultCollector{}
+		scrts := secrets.NewPSKSecrets([]byte("test password"))
+
+		prevRawSocket := nfqdatapath.GetUDPRawSocket
+		defer func() {
+			nfqdatapath.GetUDPRawSocket = prevRawSocket
+		}()
+		nfqdatapath.GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		e := enforcer.NewWithDefaults("serverID", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"}, nil, nil)
+		So(s, ShouldNotBeNil)
+
+		impl := mocksupervisor.NewMockImplementor(ctrl)
+		s.impl = impl
+
+		Convey("When I set valid excluded ports, they should be installed", func() {
+			impl.EXPECT().SetExcludedPorts([]string{"10250", "8000:8100"}).Return(nil)
+			So(s.SetExcludedPorts([]string{"10250", "8000:8100"}), ShouldBeNil)
+
+			Convey("And they should be installed again when the supervisor starts", func() {
+				impl.EXPECT().Run(gomock.Any()).Return(nil)
+				impl.EXPECT().SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}).Return(nil)
+				impl.EXPECT().SetExcludedPorts([]string{"10250", "8000:8100"}).Return(nil)
+				So(s.Run(context.Background()), ShouldBeNil)
+			})
+		})
+
+		Convey("When I set an invalid excluded port, I should get an error", func() {
+			So(s.SetExcludedPorts([]string{"http"}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAddressSets(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a properly configured supervisor", t, func() {
+		c := &collector.DefaultCollector{}
+		scrts := secrets.NewPSKSecrets([]byte("test password"))
+
+		prevRawSocket := nfqdatapath.GetUDPRawSocket
+		defer func() {
+			nfqdatapath.GetUDPRawSocket = prevRawSocket
+		}()
+		nfqdatapath.GetUDPRawSocket = func(mark int, device string) (afinetrawsocket.SocketWriter, error) {
+			return nil, nil
+		}
+
+		e := enforcer.NewWithDefaults("serverID", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"}, nil, nil)
+		So(s, ShouldNotBeNil)
+
+		impl := mocksupervisor.NewMockImplementor(ctrl)
+		s.impl = impl
+
+		Convey("When I update an address set, it should be installed", func() {
+			impl.EXPECT().UpdateAddressSet("feed", []string{"10.1.1.1", "192.168.0.0/16"}).Return(nil)
+			So(s.UpdateAddressSet("feed", []string{"10.1.1.1", "192.168.0.0/16"}), ShouldBeNil)
+
+			Convey("And it should be installed again when the supervisor starts", func() {
+				impl.EXPECT().Run(gomock.Any()).Return(nil)
+				impl.EXPECT().SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}).Return(nil)
+				impl.EXPECT().SetExcludedPorts(nil).Return(nil)
+				impl.EXPECT().UpdateAddressSet("feed", []string{"10.1.1.1", "192.168.0.0/16"}).Return(nil)
+				So(s.Run(context.Background()), ShouldBeNil)
+			})
+
+			Convey("And it should not be installed again once removed", func() {
+				impl.EXPECT().RemoveAddressSet("feed").Return(nil)
+				So(s.RemoveAddressSet("feed"), ShouldBeNil)
+
+				impl.EXPECT().Run(gomock.Any()).Return(nil)
+				impl.EXPECT().SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}).Return(nil)
+				impl.EXPECT().SetExcludedPorts(nil).Return(nil)
+				So(s.Run(context.Background()), ShouldBeNil)
+			})
+		})
+
+		Convey("When I update an address set with an invalid address, I should get an error", func() {
+			So(s.UpdateAddressSet("feed", []string{"10.1.1.1", "example.com"}), ShouldNotBeNil)
+		})
+
+		Convey("When I update an address set without a name, I should get an error", func() {
+			So(s.UpdateAddressSet("", []string{"10.1.1.1"}), ShouldNotBeNil)
+		})
+	})
+}
+
 func TestStop(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -293,7 +512,7 @@ func TestStop(t *testing.T) {
 
 		e := enforcer.NewWithDefaults("serverID", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
 
-		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"}, nil)
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"}, nil, nil)
 		So(s, ShouldNotBeNil)
 
 		impl := mocksupervisor.NewMockImplementor(ctrl)
@@ -302,6 +521,7 @@ func TestStop(t *testing.T) {
 		Convey("When I try to start it and the implementor works", func() {
 			impl.EXPECT().Run(gomock.Any()).Return(nil)
 			impl.EXPECT().SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}).Return(nil)
+			impl.EXPECT().SetExcludedPorts(nil).Return(nil)
 			err := s.Run(context.Background())
 			Convey("I should get no errors", func() {
 				So(err, ShouldBeNil)
@@ -7,6 +7,7 @@ package mockcontroller
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	secrets "go.aporeto.io/trireme-lib/controller/pkg/secrets"
@@ -137,3 +138,132 @@ func (m *MockTriremeController) UpdateConfiguration(networks []string) error {
 func (mr *MockTriremeControllerMockRecorder) UpdateConfiguration(networks interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateConfiguration", reflect.TypeOf((*MockTriremeController)(nil).UpdateConfiguration), networks)
 }
+
+// UpdateExcludedPorts mocks base method
+// nolint
+func (m *MockTriremeController) UpdateExcludedPorts(ports []string) error {
+	ret := m.ctrl.Call(m, "UpdateExcludedPorts", ports)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateExcludedPorts indicates an expected call of UpdateExcludedPorts
+// nolint
+func (mr *MockTriremeControllerMockRecorder) UpdateExcludedPorts(ports interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateExcludedPorts", reflect.TypeOf((*MockTriremeController)(nil).UpdateExcludedPorts), ports)
+}
+
+// UpdateAddressSet mocks base method
+// nolint
+func (m *MockTriremeController) UpdateAddressSet(name string, addresses []string) error {
+	ret := m.ctrl.Call(m, "UpdateAddressSet", name, addresses)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateAddressSet indicates an expected call of UpdateAddressSet
+// nolint
+func (mr *MockTriremeControllerMockRecorder) UpdateAddressSet(name, addresses interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAddressSet", reflect.TypeOf((*MockTriremeController)(nil).UpdateAddressSet), name, addresses)
+}
+
+// RemoveAddressSet mocks base method
+// nolint
+func (m *MockTriremeController) RemoveAddressSet(name string) error {
+	ret := m.ctrl.Call(m, "RemoveAddressSet", name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveAddressSet indicates an expected call of RemoveAddressSet
+// nolint
+func (mr *MockTriremeControllerMockRecorder) RemoveAddressSet(name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveAddressSet", reflect.TypeOf((*MockTriremeController)(nil).RemoveAddressSet), name)
+}
+
+// Healthy mocks base method
+// nolint
+func (m *MockTriremeController) Healthy() error {
+	ret := m.ctrl.Call(m, "Healthy")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Healthy indicates an expected call of Healthy
+// nolint
+func (mr *MockTriremeControllerMockRecorder) Healthy() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Healthy", reflect.TypeOf((*MockTriremeController)(nil).Healthy))
+}
+
+// PolicyVersion mocks base method
+// nolint
+func (m *MockTriremeController) PolicyVersion(puID string) (int, error) {
+	ret := m.ctrl.Call(m, "PolicyVersion", puID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PolicyVersion indicates an expected call of PolicyVersion
+// nolint
+func (mr *MockTriremeControllerMockRecorder) PolicyVersion(puID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PolicyVersion", reflect.TypeOf((*MockTriremeController)(nil).PolicyVersion), puID)
+}
+
+// Validate mocks base method
+// nolint
+func (m *MockTriremeController) Validate(ctx context.Context, puID string, policy *policy.PUPolicy, runtime *policy.PURuntime) error {
+	ret := m.ctrl.Call(m, "Validate", ctx, puID, policy, runtime)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Validate indicates an expected call of Validate
+// nolint
+func (mr *MockTriremeControllerMockRecorder) Validate(ctx, puID, policy, runtime interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Validate", reflect.TypeOf((*MockTriremeController)(nil).Validate), ctx, puID, policy, runtime)
+}
+
+// ExportPUState mocks base method
+// nolint
+func (m *MockTriremeController) ExportPUState(puID string) ([]byte, error) {
+	ret := m.ctrl.Call(m, "ExportPUState", puID)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportPUState indicates an expected call of ExportPUState
+// nolint
+func (mr *MockTriremeControllerMockRecorder) ExportPUState(puID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportPUState", reflect.TypeOf((*MockTriremeController)(nil).ExportPUState), puID)
+}
+
+// ExportConntrackUpdates mocks base method
+// nolint
+func (m *MockTriremeController) ExportConntrackUpdates(puID string) ([]byte, error) {
+	ret := m.ctrl.Call(m, "ExportConntrackUpdates", puID)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportConntrackUpdates indicates an expected call of ExportConntrackUpdates
+// nolint
+func (mr *MockTriremeControllerMockRecorder) ExportConntrackUpdates(puID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportConntrackUpdates", reflect.TypeOf((*MockTriremeController)(nil).ExportConntrackUpdates), puID)
+}
+
+// Drain mocks base method
+// nolint
+func (m *MockTriremeController) Drain(ctx context.Context, puID string, gracePeriod time.Duration) error {
+	ret := m.ctrl.Call(m, "Drain", ctx, puID, gracePeriod)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Drain indicates an expected call of Drain
+// nolint
+func (mr *MockTriremeControllerMockRecorder) Drain(ctx, puID, gracePeriod interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Drain", reflect.TypeOf((*MockTriremeController)(nil).Drain), ctx, puID, gracePeriod)
+}
@@ -2,11 +2,15 @@ package connection
 
 import (
 	"fmt"
+	"net"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 
+	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
 	"go.aporeto.io/trireme-lib/controller/pkg/packet"
 	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
@@ -15,6 +19,9 @@ import (
 	"go.aporeto.io/trireme-lib/utils/crypto"
 )
 
+// timeNow returns the current time. It is replaced by the tests.
+var timeNow = time.Now
+
 // TCPFlowState identifies the constants of the state of a TCP connectioncon
 type TCPFlowState int
 
@@ -49,6 +56,10 @@ const (
 
 	// UnknownState indicates that this an existing connection in the uknown state.
 	UnknownState
+
+	// TCPServerNamePending indicates that the flow waits for the server name
+	// of its TLS ClientHello to be matched against the policy.
+	TCPServerNamePending
 )
 
 const (
@@ -89,6 +100,9 @@ const (
 
 	// UDPData is the state where data is being transmitted.
 	UDPData
+
+	// UDPClosed is the state where the connection has been torn down.
+	UDPClosed
 )
 
 // MaximumUDPQueueLen is the maximum number of UDP packets buffered.
@@ -141,11 +155,25 @@ type TCPConnection struct {
 	// ServiceConnection indicates that this connection is handled by a service
 	ServiceConnection bool
 
+	// connMark is the mark of the sockets of a service connection. It is set
+	// when the handshake completes and is accessed atomically.
+	connMark uint32
+
 	// ReportFlowPolicy holds the last matched observed policy
 	ReportFlowPolicy *policy.FlowPolicy
 
 	// PacketFlowPolicy holds the last matched actual policy
 	PacketFlowPolicy *policy.FlowPolicy
+
+	// RemoteTags are the tags of the remote PU that the policy of the
+	// connection was evaluated against.
+	RemoteTags *policy.TagStore
+
+	// ServerNameData holds the data sent by the application while the flow
+	// waits for its server name, and ServerNameSeq is the sequence number
+	// of the next data.
+	ServerNameData []byte
+	ServerNameSeq  uint32
 }
 
 // TCPConnectionExpirationNotifier handles processing the expiration of an element
@@ -199,6 +227,34 @@ func (c *TCPConnection) Cleanup(expiration bool) {
 	}
 }
 
+// SetConnMark sets the mark of the sockets of a service connection.
+func (c *TCPConnection) SetConnMark(mark uint32) {
+
+	atomic.StoreUint32(&c.connMark, mark)
+}
+
+// ConnMark returns the mark of the sockets of a service connection. It is 0
+// until the handshake completes.
+func (c *TCPConnection) ConnMark() uint32 {
+
+	return atomic.LoadUint32(&c.connMark)
+}
+
+// MarkSocket sets the mark of the connection on a socket of the service that
+// handles it, so that the return traffic is classified with the connection.
+func (c *TCPConnection) MarkSocket(sock syscall.Conn) error {
+
+	return markSocket(sock, c.ConnMark())
+}
+
+// Dialer returns a dialer that marks the sockets of the proxy of the service
+// before they connect, so that all the packets of the flow and the return
+// traffic are classified with the connection.
+func (c *TCPConnection) Dialer(timeout time.Duration) *net.Dialer {
+
+	return markedDialer(c.ConnMark(), timeout)
+}
+
 // NewTCPConnection returns a TCPConnection information struct
 func NewTCPConnection(context *pucontext.PUContext) *TCPConnection {
 
@@ -270,22 +326,59 @@ type UDPConnection struct {
 
 	ReportFlowPolicy *policy.FlowPolicy
 	PacketFlowPolicy *policy.FlowPolicy
+	// RemoteTags are the tags of the remote PU that the policy of the
+	// connection was evaluated against.
+	RemoteTags *policy.TagStore
 	// ServiceData allows services to associate state with a connection
 	ServiceData interface{}
 
 	// PacketQueue indicates app UDP packets queued while authorization is in progress.
 	PacketQueue chan *packet.Packet
 	Writer      afinetrawsocket.SocketWriter
+	// droppedPackets and droppedBytes count the queued packets that were
+	// discarded instead of transmitted.
+	droppedPackets int
+	droppedBytes   int
+	// Traffic counts the payload bytes of the connection
+	Traffic
+	// Flow is the flow reported when the connection was accepted. The
+	// traffic of the connection is reported with it when it ends.
+	Flow *collector.FlowRecord
 	// Debugging information - pushed to the end for compact structure
 	flowLastReporting bool
 	reported          bool
 	// ServiceConnection indicates that this connection is handled by a service
 	ServiceConnection bool
+	// connMark is the mark of the sockets of a service connection. It is set
+	// when the handshake completes and is accessed atomically.
+	connMark uint32
+	// NetReplyHash is the hash of the replies of a connection opened by the
+	// local PU, as they arrive from the network. It is the reverse of the
+	// application flow unless the destination was translated.
+	NetReplyHash string
 
 	// Stop channels for restransmissions
-	synStop    chan bool
-	synAckStop chan bool
-	ackStop    chan bool
+	synStop       chan bool
+	synAckStop    chan bool
+	ackStop       chan bool
+	keyRotateStop chan bool
+
+	// Key rotation state. The keys of the connection are derived from the
+	// local and remote contexts. The previous contexts are kept until the
+	// next rotation, so that the tokens that the remote side signed before
+	// it switched keys are still accepted. The state is protected by the
+	// lock of the connection.
+	keyEpoch              uint32
+	keyRotatedAt          time.Time
+	keyRotationStartedAt  time.Time
+	pendingContext        []byte
+	previousLocalContext  []byte
+	previousRemoteContext []byte
+
+	// Handshake timeout state. A handshake that does not complete within
+	// the timeout expires.
+	handshakeStartedAt time.Time
+	handshakeTimeout   time.Duration
 
 	TestIgnore bool
 }
@@ -306,10 +399,12 @@ func NewUDPConnection(context *pucontext.PUContext, writer afinetrawsocket.Socke
 		Auth: AuthInfo{
 			LocalContext: nonce,
 		},
-		synStop:    make(chan bool),
-		synAckStop: make(chan bool),
-		ackStop:    make(chan bool),
-		TestIgnore: true,
+		synStop:       make(chan bool),
+		synAckStop:    make(chan bool),
+		ackStop:       make(chan bool),
+		keyRotateStop: make(chan bool),
+		keyRotatedAt:  timeNow(),
+		TestIgnore:    true,
 	}
 }
 
@@ -357,6 +452,136 @@ func (c *UDPConnection) AckChannel() chan bool {
 	return c.ackStop
 }
 
+// KeyRotateStop issues a stop in the key rotation channel.
+func (c *UDPConnection) KeyRotateStop() {
+	select {
+	case c.keyRotateStop <- true:
+	default:
+		zap.L().Debug("Packet loss - channel was already done")
+	}
+}
+
+// KeyRotateChannel returns the key rotation stop channel.
+func (c *UDPConnection) KeyRotateChannel() chan bool {
+	return c.keyRotateStop
+}
+
+// KeyEpoch returns the number of key rotations completed on the connection.
+// The connection must be locked.
+func (c *UDPConnection) KeyEpoch() uint32 {
+	return c.keyEpoch
+}
+
+// KeyRotationDue returns true if the keys of an established connection are
+// older than the interval. A rotation that was not acknowledged within the
+// interval is due again. A zero interval disables rotation. The connection
+// must be locked.
+func (c *UDPConnection) KeyRotationDue(interval time.Duration) bool {
+
+	if interval <= 0 || c.state != UDPData {
+		return false
+	}
+
+	if c.pendingContext != nil {
+		return timeNow().Sub(c.keyRotationStartedAt) >= interval
+	}
+
+	return timeNow().Sub(c.keyRotatedAt) >= interval
+}
+
+// StartKeyRotation generates the new local context that will be proposed
+// to the remote side. The current keys remain in use until the remote side
+// acknowledges the rotation. The connection must be locked.
+func (c *UDPConnection) StartKeyRotation() ([]byte, error) {
+
+	nonce, err := crypto.GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+
+	c.pendingContext = nonce
+	c.keyRotationStartedAt = timeNow()
+
+	return nonce, nil
+}
+
+// PendingContext returns the local context of a rotation in progress. The
+// connection must be locked.
+func (c *UDPConnection) PendingContext() []byte {
+	return c.pendingContext
+}
+
+// CancelKeyRotation abandons a rotation in progress. The connection must be
+// locked.
+func (c *UDPConnection) CancelKeyRotation() {
+	c.pendingContext = nil
+	c.KeyRotateStop()
+}
+
+// RotateKeys switches the connection to the new contexts. The tokens created
+// after this call use the new contexts, and the current contexts become the
+// previous ones. The connection must be locked.
+func (c *UDPConnection) RotateKeys(localContext, remoteContext []byte) {
+
+	c.previousLocalContext = c.Auth.LocalContext
+	c.previousRemoteContext = c.Auth.RemoteContext
+	c.Auth.LocalContext = localContext
+	c.Auth.RemoteContext = remoteContext
+	c.pendingContext = nil
+	c.keyRotatedAt = timeNow()
+	c.keyEpoch++
+}
+
+// PreviousContexts returns the local and remote contexts that were in use
+// before the last rotation. The connection must be locked.
+func (c *UDPConnection) PreviousContexts() ([]byte, []byte) {
+	return c.previousLocalContext, c.previousRemoteContext
+}
+
+// PreviousAuth returns the authorization information of the connection with
+// the contexts that were in use before the last rotation, or nil if the keys
+// were never rotated. The connection must be locked.
+func (c *UDPConnection) PreviousAuth() *AuthInfo {
+
+	if c.previousLocalContext == nil {
+		return nil
+	}
+
+	auth := c.Auth
+	auth.LocalContext = c.previousLocalContext
+	auth.RemoteContext = c.previousRemoteContext
+
+	return &auth
+}
+
+// StartHandshake records the time the handshake of the connection started.
+// A zero timeout disables the expiration of the handshake. The start time of
+// a handshake that was already started is not changed by retransmissions.
+func (c *UDPConnection) StartHandshake(now time.Time, timeout time.Duration) {
+
+	if !c.handshakeStartedAt.IsZero() {
+		return
+	}
+
+	c.handshakeStartedAt = now
+	c.handshakeTimeout = timeout
+}
+
+// HandshakeExpired returns true if the handshake of the connection started
+// and did not complete within its timeout.
+func (c *UDPConnection) HandshakeExpired(now time.Time) bool {
+
+	if c.handshakeTimeout <= 0 || c.handshakeStartedAt.IsZero() {
+		return false
+	}
+
+	if c.state != UDPClientSendSyn && c.state != UDPReceiverSendSynAck {
+		return false
+	}
+
+	return now.Sub(c.handshakeStartedAt) >= c.handshakeTimeout
+}
+
 // GetState is used to get state of UDP Connection.
 func (c *UDPConnection) GetState() UDPFlowState {
 	return c.state
@@ -389,10 +614,33 @@ func (c *UDPConnection) QueuePackets(udpPacket *packet.Packet) (err error) {
 
 // DropPackets drops packets on errors during Authorization.
 func (c *UDPConnection) DropPackets() {
+	c.DiscardPackets()
 	close(c.PacketQueue)
 	c.PacketQueue = make(chan *packet.Packet, MaximumUDPQueueLen)
 }
 
+// DiscardPackets empties the queue and counts the packets as dropped. It
+// returns the first discarded packet, or nil if the queue was empty.
+func (c *UDPConnection) DiscardPackets() *packet.Packet {
+
+	var first *packet.Packet
+	for p := c.ReadPacket(); p != nil; p = c.ReadPacket() {
+		if first == nil {
+			first = p
+		}
+		c.droppedPackets++
+		c.droppedBytes += len(p.Buffer)
+	}
+
+	return first
+}
+
+// DroppedPackets returns the number of queued packets and bytes that were
+// discarded instead of transmitted.
+func (c *UDPConnection) DroppedPackets() (packets int, bytes int) {
+	return c.droppedPackets, c.droppedBytes
+}
+
 // ReadPacket reads a packet from the queue.
 func (c *UDPConnection) ReadPacket() *packet.Packet {
 	select {
@@ -403,6 +651,34 @@ func (c *UDPConnection) ReadPacket() *packet.Packet {
 	}
 }
 
+// SetConnMark sets the mark of the sockets of a service connection.
+func (c *UDPConnection) SetConnMark(mark uint32) {
+
+	atomic.StoreUint32(&c.connMark, mark)
+}
+
+// ConnMark returns the mark of the sockets of a service connection. It is 0
+// until the handshake completes.
+func (c *UDPConnection) ConnMark() uint32 {
+
+	return atomic.LoadUint32(&c.connMark)
+}
+
+// MarkSocket sets the mark of the connection on a socket of the service that
+// handles it, so that the return traffic is classified with the connection.
+func (c *UDPConnection) MarkSocket(sock syscall.Conn) error {
+
+	return markSocket(sock, c.ConnMark())
+}
+
+// Dialer returns a dialer that marks the sockets of the proxy of the service
+// before they connect, so that all the packets of the flow and the return
+// traffic are classified with the connection.
+func (c *UDPConnection) Dialer(timeout time.Duration) *net.Dialer {
+
+	return markedDialer(c.ConnMark(), timeout)
+}
+
 // SetReported is used to track if a flow is reported
 func (c *UDPConnection) SetReported(flowState bool) {
 
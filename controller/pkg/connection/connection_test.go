@@ -0,0 +1,54 @@
+package connection
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUDPKeyRotationDue(t *testing.T) {
+
+	Convey("Given an established UDP connection", t, func() {
+		now := time.Now()
+		prevTimeNow := timeNow
+		defer func() {
+			timeNow = prevTimeNow
+		}()
+		timeNow = func() time.Time {
+			return now
+		}
+
+		conn := NewUDPConnection(nil, nil)
+		conn.SetState(UDPData)
+
+		Convey("When the rotation is disabled, it should never be due", func() {
+			now = now.Add(time.Hour)
+			So(conn.KeyRotationDue(0), ShouldBeFalse)
+		})
+
+		Convey("When the keys are younger than the interval, it should not be due", func() {
+			now = now.Add(time.Minute)
+			So(conn.KeyRotationDue(time.Hour), ShouldBeFalse)
+		})
+
+		Convey("When the keys are older than the interval, it should be due", func() {
+			now = now.Add(time.Hour)
+			So(conn.KeyRotationDue(time.Hour), ShouldBeTrue)
+
+			Convey("When a rotation is started, it should be due again only after the interval", func() {
+				_, err := conn.StartKeyRotation()
+				So(err, ShouldBeNil)
+				So(conn.KeyRotationDue(time.Hour), ShouldBeFalse)
+
+				now = now.Add(time.Hour)
+				So(conn.KeyRotationDue(time.Hour), ShouldBeTrue)
+			})
+
+			Convey("When the keys are rotated, it should not be due", func() {
+				conn.RotateKeys([]byte("local"), []byte("remote"))
+				So(conn.KeyRotationDue(time.Hour), ShouldBeFalse)
+			})
+		})
+	})
+}
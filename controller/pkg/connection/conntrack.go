@@ -0,0 +1,17 @@
+package connection
+
+import "time"
+
+// ConntrackUpdate is an update of the conntrack mark of a flow issued by a
+// datapath to release the flow to the kernel.
+type ConntrackUpdate struct {
+	SourceIP        string
+	DestinationIP   string
+	Protocol        uint8
+	SourcePort      uint16
+	DestinationPort uint16
+	Mark            uint32
+	Time            time.Time
+	// Error is set if the update failed.
+	Error string `json:",omitempty"`
+}
@@ -0,0 +1,50 @@
+// +build linux
+
+package connection
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// markSocket sets the mark on the socket.
+func markSocket(sock syscall.Conn, mark uint32) error {
+
+	rawconn, err := sock.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	return markRawConn(rawconn, mark)
+}
+
+// markRawConn sets the mark on the raw socket.
+func markRawConn(rawconn syscall.RawConn, mark uint32) error {
+
+	if mark == 0 {
+		return errors.New("no mark for the connection")
+	}
+
+	var serr error
+	if err := rawconn.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, int(mark))
+	}); err != nil {
+		return err
+	}
+
+	return serr
+}
+
+// markedDialer returns a dialer that sets the mark on the sockets before
+// they connect.
+func markedDialer(mark uint32, timeout time.Duration) *net.Dialer {
+
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, rawconn syscall.RawConn) error {
+			return markRawConn(rawconn, mark)
+		},
+	}
+}
@@ -0,0 +1,22 @@
+// +build !linux
+
+package connection
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// markSocket is not supported on this platform.
+func markSocket(sock syscall.Conn, mark uint32) error {
+	return nil
+}
+
+// markedDialer returns a dialer that does not mark the sockets, since marks
+// are not supported on this platform.
+func markedDialer(mark uint32, timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+	}
+}
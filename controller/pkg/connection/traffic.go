@@ -0,0 +1,28 @@
+package connection
+
+// Traffic counts the payload bytes of the data packets of a UDP connection that
+// the datapath processed.
+type Traffic struct {
+	transmitted int
+	received    int
+}
+
+// AddTransmittedBytes counts the payload of a packet transmitted by the PU.
+func (t *Traffic) AddTransmittedBytes(n int) {
+	t.transmitted += n
+}
+
+// AddReceivedBytes counts the payload of a packet received by the PU.
+func (t *Traffic) AddReceivedBytes(n int) {
+	t.received += n
+}
+
+// TakeTraffic returns the bytes transmitted and received by the PU since the
+// last call and resets the counters, so that the bytes are reported once.
+func (t *Traffic) TakeTraffic() (transmitted int, received int) {
+
+	transmitted, received = t.transmitted, t.received
+	t.transmitted, t.received = 0, 0
+
+	return transmitted, received
+}
@@ -0,0 +1,97 @@
+package connection
+
+import (
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/nfqdatapath/afinetrawsocket"
+	"go.aporeto.io/trireme-lib/controller/pkg/packet"
+	"go.aporeto.io/trireme-lib/controller/pkg/pucontext"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// UDPConnectionState is the state of an established UDP connection that can
+// be persisted across enforcer restarts. Only the state needed to resume the
+// data phase is captured: the authorization contexts that the keys derive
+// from, the identity of the remote side and the flow policies. Handshake
+// state, queued packets, retransmissions and the remote public key are not
+// persisted, so that connections that were not established re-handshake.
+type UDPConnectionState struct {
+	// ContextID is the PU of the connection
+	ContextID string
+	// LocalContext and RemoteContext are the current authorization contexts
+	LocalContext  []byte
+	RemoteContext []byte
+	// RemoteContextID is the identity of the remote PU
+	RemoteContextID string
+	// RemoteIP and RemotePort are the address of the remote side
+	RemoteIP   string
+	RemotePort string
+	// KeyEpoch and KeyRotatedAt track the key rotations of the connection
+	KeyEpoch     uint32
+	KeyRotatedAt time.Time
+	// ReportFlowPolicy and PacketFlowPolicy are the policies of the flow
+	ReportFlowPolicy *policy.FlowPolicy
+	PacketFlowPolicy *policy.FlowPolicy
+	// Reported indicates the flow was already reported
+	Reported bool
+	// Trackers maps the connection trackers of the datapath to the keys the
+	// connection is stored with
+	Trackers map[string]string
+	// SavedAt is the time the state was captured
+	SavedAt time.Time
+}
+
+// PersistentState returns the state of the connection that can be persisted.
+// It returns nil if the connection is not established. The caller must hold
+// the lock of the connection.
+func (c *UDPConnection) PersistentState() *UDPConnectionState {
+
+	if c.state != UDPData || c.Context == nil || c.pendingContext != nil {
+		return nil
+	}
+
+	return &UDPConnectionState{
+		ContextID:        c.Context.ID(),
+		LocalContext:     c.Auth.LocalContext,
+		RemoteContext:    c.Auth.RemoteContext,
+		RemoteContextID:  c.Auth.RemoteContextID,
+		RemoteIP:         c.Auth.RemoteIP,
+		RemotePort:       c.Auth.RemotePort,
+		KeyEpoch:         c.keyEpoch,
+		KeyRotatedAt:     c.keyRotatedAt,
+		ReportFlowPolicy: c.ReportFlowPolicy,
+		PacketFlowPolicy: c.PacketFlowPolicy,
+		Reported:         c.reported,
+		Trackers:         map[string]string{},
+		SavedAt:          time.Now(),
+	}
+}
+
+// NewUDPConnectionFromState restores an established connection from its
+// persisted state.
+func NewUDPConnectionFromState(s *UDPConnectionState, context *pucontext.PUContext, writer afinetrawsocket.SocketWriter) *UDPConnection {
+
+	return &UDPConnection{
+		state:       UDPData,
+		Context:     context,
+		PacketQueue: make(chan *packet.Packet, MaximumUDPQueueLen),
+		Writer:      writer,
+		Auth: AuthInfo{
+			LocalContext:    s.LocalContext,
+			RemoteContext:   s.RemoteContext,
+			RemoteContextID: s.RemoteContextID,
+			RemoteIP:        s.RemoteIP,
+			RemotePort:      s.RemotePort,
+		},
+		ReportFlowPolicy: s.ReportFlowPolicy,
+		PacketFlowPolicy: s.PacketFlowPolicy,
+		reported:         s.Reported,
+		synStop:          make(chan bool),
+		synAckStop:       make(chan bool),
+		ackStop:          make(chan bool),
+		keyRotateStop:    make(chan bool),
+		keyEpoch:         s.KeyEpoch,
+		keyRotatedAt:     s.KeyRotatedAt,
+		TestIgnore:       true,
+	}
+}
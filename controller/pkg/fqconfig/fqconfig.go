@@ -1,6 +1,10 @@
 package fqconfig
 
-import "strconv"
+import (
+	"strconv"
+
+	"go.aporeto.io/trireme-lib/controller/constants"
+)
 
 // FilterQueue captures all the configuration parameters of the NFQUEUEs
 type FilterQueue struct {
@@ -8,6 +12,8 @@ type FilterQueue struct {
 	QueueSeparation bool
 	// MarkValue is the default mark to set in packets in the RAW chain
 	MarkValue int
+	// ConnMark is the conntrack mark of the flows released to the kernel
+	ConnMark uint32
 	// NetworkQueue is the queue number of the base queue for network packets
 	NetworkQueue uint16
 	// NumberOfApplicationQueues is the number of queues that must be allocated
@@ -57,6 +63,7 @@ func NewFilterQueue(queueSeparation bool, MarkValue int, QueueStart, NumberOfNet
 	fq := &FilterQueue{
 		QueueSeparation:      queueSeparation,
 		MarkValue:            MarkValue,
+		ConnMark:             constants.DefaultConnMark,
 		NetworkQueueSize:     NetworkQueueSize,
 		ApplicationQueueSize: ApplicationQueueSize,
 	}
@@ -101,6 +108,14 @@ func (f *FilterQueue) GetMarkValue() int {
 	return f.MarkValue
 }
 
+// GetConnMark returns the conntrack mark of the flows released to the kernel
+func (f *FilterQueue) GetConnMark() uint32 {
+	if f.ConnMark == 0 {
+		return constants.DefaultConnMark
+	}
+	return f.ConnMark
+}
+
 // GetNetworkQueueStart returns start of network queues to be used by iptables action
 func (f *FilterQueue) GetNetworkQueueStart() uint16 {
 	return f.NetworkQueue
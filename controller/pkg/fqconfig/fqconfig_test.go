@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/controller/constants"
 )
 
 func TestFqDefaultConfig(t *testing.T) {
@@ -15,6 +16,7 @@ func TestFqDefaultConfig(t *testing.T) {
 			So(fqc, ShouldNotBeNil)
 
 			So(fqc.GetMarkValue(), ShouldEqual, DefaultMarkValue)
+			So(fqc.GetConnMark(), ShouldEqual, constants.DefaultConnMark)
 
 			So(fqc.GetApplicationQueueSize(), ShouldEqual, DefaultQueueSize)
 			So(fqc.GetNumApplicationQueues(), ShouldEqual, DefaultNumberOfQueues*4)
@@ -34,3 +36,23 @@ func TestFqDefaultConfig(t *testing.T) {
 		})
 	})
 }
+
+func TestFqConnMark(t *testing.T) {
+
+	Convey("Given a filter queue config with a conn mark", t, func() {
+		fqc := NewFilterQueueWithDefaults()
+		fqc.ConnMark = 0x1234
+
+		Convey("Then I should get the configured conn mark", func() {
+			So(fqc.GetConnMark(), ShouldEqual, 0x1234)
+		})
+	})
+
+	Convey("Given a filter queue config without a conn mark", t, func() {
+		fqc := &FilterQueue{}
+
+		Convey("Then I should get the default conn mark", func() {
+			So(fqc.GetConnMark(), ShouldEqual, constants.DefaultConnMark)
+		})
+	})
+}
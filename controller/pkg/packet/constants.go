@@ -9,6 +9,8 @@ const (
 	minIPHdrSize = 20
 
 	minIPHdrWords = (minIPHdrSize / 4)
+	// minTCPHdrSize is the size of the TCP header without options
+	minTCPHdrSize = 20
 )
 
 // IP Header field position constants
@@ -107,6 +109,24 @@ const (
 
 	// TCPMssOptionLen is the type for MSS option
 	TCPMssOptionLen = uint8(4)
+
+	// TCPEndOption is the type of the end of options list option
+	TCPEndOption = uint8(0)
+
+	// TCPNopOption is the type of the no-operation option
+	TCPNopOption = uint8(1)
+
+	// TCPFastOpenOption is the type of the TCP fast open option (RFC 7413).
+	// It is the type of the authentication option too, which has a length
+	// that no fast open option has.
+	TCPFastOpenOption = uint8(34)
+
+	// TCPFastOpenExperimentalOption is the experimental type of the TCP fast
+	// open option, followed by TCPFastOpenMagic.
+	TCPFastOpenExperimentalOption = uint8(254)
+
+	// TCPFastOpenMagic identifies the experimental fast open option
+	TCPFastOpenMagic = uint16(0xF989)
 )
 
 // UDP related constants.
@@ -125,8 +145,12 @@ const (
 	UDPSynAckMask = 0x40
 	// UDPAckMask mask that identifies ACK packets.
 	UDPAckMask = 0x60
+	// UDPKeyRotateMask mask that identifies key rotation packets.
+	UDPKeyRotateMask = 0x01
+	// UDPFinMask mask that identifies connection teardown packets.
+	UDPFinMask = 0x02
 	// UDPPacketMask identifies type of UDP packet.
-	UDPPacketMask = 0x60
+	UDPPacketMask = 0x63
 )
 
 const (
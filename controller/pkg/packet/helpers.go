@@ -178,8 +178,8 @@ func (p *Packet) computeUDPChecksum() uint16 {
 	// bytes 10,11: UDP buffer size (real header + payload)
 	binary.BigEndian.PutUint16(buf[10:12], udpSize)
 
-	// bytes 12+: The TCP buffer (real header + payload)
-	copy(buf[12:], p.Buffer[UDPDataPos:])
+	// bytes 12+: The UDP buffer (real header + payload)
+	copy(buf[12:], p.Buffer[UDPBeginPos:])
 
 	// Set current checksum to zero (in buf, not changing packet)
 	buf[pseudoHeaderLen+6] = 0
@@ -230,20 +230,39 @@ func checksum(buf []byte) uint16 {
 	return csum
 }
 
-// UpdateUDPChecksum updates the UDP checksum field of packet
+// UpdateUDPChecksum updates the UDP length and checksum fields of packet
 func (p *Packet) UpdateUDPChecksum() {
 
-	// checksum set to 0, ignored by the stack
-	ignoreCheckSum := []byte{0, 0}
-	p.UDPChecksum = binary.BigEndian.Uint16(ignoreCheckSum[:])
-
 	curLen := uint16(len(p.Buffer))
 	udpDataLen := curLen - p.GetUDPDataStartBytes()
 
-	// update checksum.
-	binary.BigEndian.PutUint16(p.Buffer[UDPChecksumPos:UDPChecksumPos+2], p.UDPChecksum)
 	// update length.
 	binary.BigEndian.PutUint16(p.Buffer[UDPLengthPos:UDPLengthPos+2], udpDataLen+8)
+
+	// update checksum. A zero checksum means that there is no checksum,
+	// so a computed zero is sent as all ones.
+	p.UDPChecksum = p.computeUDPChecksum()
+	if p.UDPChecksum == 0 {
+		p.UDPChecksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(p.Buffer[UDPChecksumPos:UDPChecksumPos+2], p.UDPChecksum)
+}
+
+// VerifyUDPChecksum returns true if the UDP checksum is correct or not
+// used for this packet, false otherwise. Note that the checksum is not
+// modified.
+func (p *Packet) VerifyUDPChecksum() bool {
+
+	if p.UDPChecksum == 0 {
+		return true
+	}
+
+	sum := p.computeUDPChecksum()
+	if sum == 0 {
+		sum = 0xffff
+	}
+
+	return sum == p.UDPChecksum
 }
 
 // ReadUDPToken returnthe UDP token. Gets called only during the handshake process.
@@ -269,6 +288,7 @@ func (p *Packet) UDPTokenAttach(udpdata []byte, udptoken []byte) {
 
 	// IP Header Processing
 	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength+packetLenIncrease)
+	p.UpdateIPChecksum()
 
 	// Attach Data @ the end of current buffer
 	p.Buffer = append(p.Buffer, p.udpData...)
@@ -288,6 +308,7 @@ func (p *Packet) UDPDataAttach(udpdata []byte) {
 	p.Buffer = append(p.Buffer, p.udpData...)
 	// IP Header Processing
 	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.GetUDPDataStartBytes()+packetLenIncrease)
+	p.UpdateIPChecksum()
 	p.UpdateUDPChecksum()
 }
 
@@ -331,12 +352,19 @@ func (p *Packet) CreateReverseFlowPacket(destIP net.IP, destPort uint16) {
 	p.UpdateUDPChecksum()
 }
 
+// PacketType returns PacketTypeNetwork if the packet was received from the
+// network and PacketTypeApplication if it was sent by the application.
+func (p *Packet) PacketType() uint64 {
+
+	return p.context & (PacketTypeNetwork | PacketTypeApplication)
+}
+
 // GetUDPType returns udp type of packet.
 func (p *Packet) GetUDPType() byte {
 
 	// Every UDP control packet has a 20 byte packet signature. The
 	// first 2 bytes represent the following control information.
-	// Byte 0 : Bits 0,1 are reserved fields.
+	// Byte 0 : Bits 0,1 represent extended udp packet types.
 	//          Bits 2,3,4 represent version information.
 	//          Bits 5,6 represent udp packet type,
 	//          Bit 7 represents encryption. (currently unused).
@@ -0,0 +1,19 @@
+package packet
+
+// IPv6 header field position constants
+const (
+	// ipv6VersionPos is the location of the version of the IPv6 header
+	ipv6VersionPos = 0
+
+	// ipv6NextHeaderPos is the location of the protocol of the next header
+	ipv6NextHeaderPos = 6
+
+	// ipv6HopLimitPos is the location of the hop limit
+	ipv6HopLimitPos = 7
+
+	// ipv6HdrSize is the size of the IPv6 header without extension headers
+	ipv6HdrSize = 40
+)
+
+// IPProtocolICMPv6 defines the constant for ICMPv6 protocol number
+const IPProtocolICMPv6 = 58
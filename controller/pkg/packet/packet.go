@@ -281,7 +281,9 @@ func (p *Packet) CheckTCPAuthenticationOption(iOptionLength int) (err error) {
 
 	// Our option was not found in the right place. We don't do anything
 	// for this packet.
-	if p.Buffer[p.TCPDataStartBytes()-optionLength] != TCPAuthenticationOption {
+	// The length is checked too, since the fast open option has the same
+	// type.
+	if p.Buffer[p.TCPDataStartBytes()-optionLength] != TCPAuthenticationOption || p.Buffer[p.TCPDataStartBytes()-optionLength+1] != uint8(optionLength) {
 		err = fmt.Errorf("tcp authentication option not found: optionlength=%d", optionLength)
 		// TODO: what about the error here ?
 		return
@@ -290,6 +292,74 @@ func (p *Packet) CheckTCPAuthenticationOption(iOptionLength int) (err error) {
 	return
 }
 
+// DisableTCPFastOpen replaces the TCP fast open options of a Syn packet with
+// no-operation options and removes the data it carries, so that the peers
+// fall back to a regular handshake. The sender retransmits the data once the
+// handshake completes. It returns false if the packet has no fast open option.
+func (p *Packet) DisableTCPFastOpen() (bool, error) {
+
+	found := false
+
+	optionsEnd := int(p.TCPDataStartBytes())
+	if len(p.Buffer) < optionsEnd {
+		return false, fmt.Errorf("tcp options truncated: length=%d", len(p.Buffer))
+	}
+
+	for i := int(p.l4BeginPos) + minTCPHdrSize; i < optionsEnd; {
+
+		kind := p.Buffer[i]
+		if kind == TCPEndOption {
+			break
+		}
+
+		if kind == TCPNopOption {
+			i++
+			continue
+		}
+
+		if i+1 >= optionsEnd || p.Buffer[i+1] < 2 || i+int(p.Buffer[i+1]) > optionsEnd {
+			return false, fmt.Errorf("invalid tcp option %d at %d", kind, i)
+		}
+		length := int(p.Buffer[i+1])
+
+		if isTCPFastOpenOption(p.Buffer[i : i+length]) {
+			for j := i; j < i+length; j++ {
+				p.Buffer[j] = TCPNopOption
+			}
+			found = true
+		}
+
+		i += length
+	}
+
+	if !found || p.IsEmptyTCPPayload() {
+		return found, nil
+	}
+
+	if err := p.TCPDataDetach(0); err != nil {
+		return true, err
+	}
+
+	p.DropDetachedDataBytes()
+
+	return true, nil
+}
+
+// isTCPFastOpenOption returns true if the option is a fast open cookie or
+// cookie request. The authentication option has a length of 4 that would be a
+// cookie of 2 bytes, shorter than the minimum of 4.
+func isTCPFastOpenOption(option []byte) bool {
+
+	switch option[0] {
+	case TCPFastOpenOption:
+		return len(option) == 2 || len(option) >= 6
+	case TCPFastOpenExperimentalOption:
+		return len(option) >= 4 && binary.BigEndian.Uint16(option[2:4]) == TCPFastOpenMagic
+	default:
+		return false
+	}
+}
+
 // FixupIPHdrOnDataModify modifies the IP header fields and checksum
 func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 
@@ -433,22 +503,34 @@ func (p *Packet) TCPDataAttach(tcpOptions []byte, tcpData []byte) (err error) {
 	return
 }
 
-// L4FlowHash calculate a hash string based on the 4-tuple
+// L4FlowHash calculate a hash string based on the 5-tuple. The protocol is
+// part of the hash so that TCP and UDP flows with the same addresses and
+// ports do not collide.
 func (p *Packet) L4FlowHash() string {
-	return p.SourceAddress.String() + ":" + p.DestinationAddress.String() + ":" + strconv.Itoa(int(p.SourcePort)) + ":" + strconv.Itoa(int(p.DestinationPort))
+	return strconv.Itoa(int(p.IPProto)) + ":" + flowAddress(p.SourceAddress) + ":" + flowAddress(p.DestinationAddress) + ":" + strconv.Itoa(int(p.SourcePort)) + ":" + strconv.Itoa(int(p.DestinationPort))
 }
 
-// L4ReverseFlowHash calculate a hash string based on the 4-tuple by reversing source and destination information
+// L4ReverseFlowHash calculate a hash string based on the 5-tuple by reversing source and destination information
 func (p *Packet) L4ReverseFlowHash() string {
-	return p.DestinationAddress.String() + ":" + p.SourceAddress.String() + ":" + strconv.Itoa(int(p.DestinationPort)) + ":" + strconv.Itoa(int(p.SourcePort))
+	return strconv.Itoa(int(p.IPProto)) + ":" + flowAddress(p.DestinationAddress) + ":" + flowAddress(p.SourceAddress) + ":" + strconv.Itoa(int(p.DestinationPort)) + ":" + strconv.Itoa(int(p.SourcePort))
 }
 
 // SourcePortHash calculates a hash based on dest ip/port for net packet and src ip/port for app packet.
+// The hash can be parsed back with net.SplitHostPort.
 func (p *Packet) SourcePortHash(stage uint64) string {
 	if stage == PacketTypeNetwork {
-		return p.DestinationAddress.String() + ":" + strconv.Itoa(int(p.DestinationPort))
+		return net.JoinHostPort(p.DestinationAddress.String(), strconv.Itoa(int(p.DestinationPort)))
+	}
+	return net.JoinHostPort(p.SourceAddress.String(), strconv.Itoa(int(p.SourcePort)))
+}
+
+// flowAddress returns the address used in the flow hashes. IPv6 addresses
+// are enclosed in brackets, since they contain the separator of the hash.
+func flowAddress(ip net.IP) string {
+	if ip.To4() == nil {
+		return "[" + ip.String() + "]"
 	}
-	return p.SourceAddress.String() + ":" + strconv.Itoa(int(p.SourcePort))
+	return ip.String()
 }
 
 // ID returns the IP ID of the packet
@@ -1,6 +1,10 @@
 package packet
 
-import "testing"
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
 
 type SamplePacketName int
 
@@ -375,3 +379,337 @@ func getTestPacketWithError(t *testing.T, id SamplePacketName) error {
 	_, err := New(0, tmp, "0", true)
 	return err
 }
+
+func TestFlowHashes(t *testing.T) {
+
+	t.Parallel()
+
+	tests := []struct {
+		src, dst string
+	}{
+		{"10.1.1.1", "10.1.1.2"},
+		{"2001:db8::1", "2001:db8::2"},
+	}
+
+	for _, tt := range tests {
+		p := &Packet{
+			SourceAddress:      net.ParseIP(tt.src),
+			DestinationAddress: net.ParseIP(tt.dst),
+			SourcePort:         2000,
+			DestinationPort:    3000,
+		}
+		reverse := &Packet{
+			SourceAddress:      net.ParseIP(tt.dst),
+			DestinationAddress: net.ParseIP(tt.src),
+			SourcePort:         3000,
+			DestinationPort:    2000,
+		}
+
+		if p.L4FlowHash() != reverse.L4ReverseFlowHash() || p.L4ReverseFlowHash() != reverse.L4FlowHash() {
+			t.Errorf("Forward and reverse hashes of %s do not match", p.L4FlowHash())
+		}
+		if p.L4FlowHash() == p.L4ReverseFlowHash() {
+			t.Errorf("Forward and reverse hashes of %s are equal", p.L4FlowHash())
+		}
+		if p.SourcePortHash(PacketTypeApplication) != reverse.SourcePortHash(PacketTypeNetwork) {
+			t.Errorf("Source port hashes of %s do not match", p.L4FlowHash())
+		}
+
+		host, port, err := net.SplitHostPort(p.SourcePortHash(PacketTypeApplication))
+		if err != nil || host != tt.src || port != "2000" {
+			t.Errorf("Unable to parse source port hash %s", p.SourcePortHash(PacketTypeApplication))
+		}
+	}
+}
+
+func TestIPv6FlowHashesAreDistinct(t *testing.T) {
+
+	t.Parallel()
+
+	// Without delimiting the addresses, both flows produce 1::2:3:4:::4:5.
+	p1 := &Packet{
+		SourceAddress:      net.ParseIP("1::2:3"),
+		DestinationAddress: net.ParseIP("4::"),
+		SourcePort:         4,
+		DestinationPort:    5,
+	}
+	p2 := &Packet{
+		SourceAddress:      net.ParseIP("1::2"),
+		DestinationAddress: net.ParseIP("3:4::"),
+		SourcePort:         4,
+		DestinationPort:    5,
+	}
+
+	if p1.L4FlowHash() == p2.L4FlowHash() {
+		t.Errorf("Distinct flows have the same hash %s", p1.L4FlowHash())
+	}
+	if p1.L4ReverseFlowHash() == p2.L4ReverseFlowHash() {
+		t.Errorf("Distinct flows have the same reverse hash %s", p1.L4ReverseFlowHash())
+	}
+}
+
+func TestFlowHashesIncludeProtocol(t *testing.T) {
+
+	t.Parallel()
+
+	tcp := &Packet{
+		IPProto:            IPProtocolTCP,
+		SourceAddress:      net.ParseIP("10.1.1.1"),
+		DestinationAddress: net.ParseIP("10.1.1.2"),
+		SourcePort:         2000,
+		DestinationPort:    3000,
+	}
+	udp := &Packet{
+		IPProto:            IPProtocolUDP,
+		SourceAddress:      net.ParseIP("10.1.1.1"),
+		DestinationAddress: net.ParseIP("10.1.1.2"),
+		SourcePort:         2000,
+		DestinationPort:    3000,
+	}
+
+	if tcp.L4FlowHash() == udp.L4FlowHash() {
+		t.Errorf("TCP and UDP flows have the same hash %s", tcp.L4FlowHash())
+	}
+	if tcp.L4ReverseFlowHash() == udp.L4ReverseFlowHash() {
+		t.Errorf("TCP and UDP flows have the same reverse hash %s", tcp.L4ReverseFlowHash())
+	}
+}
+
+// newUDPTestPacket returns a UDP packet with a correct IP checksum and no UDP
+// checksum.
+func newUDPTestPacket(t *testing.T, payload []byte) *Packet {
+
+	buffer := make([]byte, UDPDataPos+len(payload))
+	buffer[0] = 0x45
+	binary.BigEndian.PutUint16(buffer[2:4], uint16(len(buffer)))
+	buffer[8] = 64
+	buffer[9] = IPProtocolUDP
+	copy(buffer[12:16], net.ParseIP("10.1.1.1").To4())
+	copy(buffer[16:20], net.ParseIP("10.1.1.2").To4())
+	binary.BigEndian.PutUint16(buffer[20:22], 2000)
+	binary.BigEndian.PutUint16(buffer[22:24], 53)
+	binary.BigEndian.PutUint16(buffer[24:26], uint16(8+len(payload)))
+	copy(buffer[UDPDataPos:], payload)
+
+	p, err := New(PacketTypeApplication, buffer, "0", true)
+	if err != nil {
+		t.Fatalf("Unable to create udp packet: %s", err)
+	}
+	p.UpdateIPChecksum()
+
+	return p
+}
+
+// validChecksums verifies the checksums of an IPv4 UDP packet from the
+// ones complement sums of the header and of the pseudo header and segment.
+func validChecksums(buffer []byte) bool {
+
+	if checksumDelta(buffer[:UDPBeginPos]) != 0xffff {
+		return false
+	}
+
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:8], buffer[ipSourceAddrPos:ipSourceAddrPos+8])
+	pseudo[9] = IPProtocolUDP
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(buffer)-UDPBeginPos))
+
+	return binary.BigEndian.Uint16(buffer[UDPLengthPos:UDPLengthPos+2]) == uint16(len(buffer)-UDPBeginPos) &&
+		binary.BigEndian.Uint16(buffer[UDPChecksumPos:UDPChecksumPos+2]) != 0 &&
+		checksumDelta(append(pseudo, buffer[UDPBeginPos:]...)) == 0xffff
+}
+
+func TestUDPChecksumsAfterTokenAttach(t *testing.T) {
+
+	t.Parallel()
+
+	for _, payload := range [][]byte{nil, []byte("data"), []byte("odd")} {
+		p := newUDPTestPacket(t, payload)
+		p.UDPTokenAttach([]byte("marker"), []byte("token"))
+
+		if !validChecksums(p.Buffer) {
+			t.Errorf("Invalid checksums after token attach with payload %q", payload)
+		}
+		if !p.VerifyIPChecksum() || !p.VerifyUDPChecksum() {
+			t.Errorf("Checksums of token attach with payload %q are not verified", payload)
+		}
+	}
+}
+
+func TestUDPChecksumsAfterReverseFlow(t *testing.T) {
+
+	t.Parallel()
+
+	p := newUDPTestPacket(t, []byte("data"))
+	p.CreateReverseFlowPacket(p.SourceAddress, p.SourcePort)
+	p.UDPTokenAttach([]byte("marker"), []byte("token"))
+
+	if !validChecksums(p.Buffer) {
+		t.Errorf("Invalid checksums after reverse flow")
+	}
+}
+
+func TestUDPChecksumsAfterDataAttach(t *testing.T) {
+
+	t.Parallel()
+
+	p := newUDPTestPacket(t, []byte("data"))
+	p.UDPDataDetach()
+	p.UDPDataAttach([]byte("encrypted data"))
+
+	if !validChecksums(p.Buffer) {
+		t.Errorf("Invalid checksums after data attach")
+	}
+
+	p.Buffer[UDPDataPos] ^= 0xff
+	if p.VerifyUDPChecksum() {
+		t.Errorf("Corrupted payload should not verify")
+	}
+}
+
+// newTCPFastOpenTestPacket returns a Syn packet with the given fast open
+// option, padded with no-operation options, and data.
+func newTCPFastOpenTestPacket(t *testing.T, option []byte, data []byte) *Packet {
+
+	for len(option)%4 != 0 {
+		option = append(option, TCPNopOption)
+	}
+
+	syn := testPackets[synGoodTCPChecksum]
+	headerLen := int(syn[tcpDataOffsetPos]>>4) * 4
+
+	buffer := append([]byte{}, syn[:minIPHdrSize+headerLen]...)
+	buffer = append(buffer, option...)
+	buffer = append(buffer, data...)
+	buffer[tcpDataOffsetPos] = uint8((headerLen+len(option))/4) << 4
+	binary.BigEndian.PutUint16(buffer[ipLengthPos:ipLengthPos+2], uint16(len(buffer)))
+
+	p, err := New(0, buffer, "0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.UpdateIPChecksum()
+	p.UpdateTCPChecksum()
+
+	return p
+}
+
+func TestDisableTCPFastOpen(t *testing.T) {
+
+	t.Parallel()
+
+	cookie := []byte{TCPFastOpenOption, 10, 1, 2, 3, 4, 5, 6, 7, 8}
+	experimental := []byte{TCPFastOpenExperimentalOption, 4, 0xF9, 0x89}
+
+	for _, option := range [][]byte{cookie, {TCPFastOpenOption, 2}, experimental} {
+		p := newTCPFastOpenTestPacket(t, option, []byte("GET / HTTP/1.1\r\n"))
+		dataStart := p.TCPDataStartBytes()
+
+		disabled, err := p.DisableTCPFastOpen()
+		if err != nil || !disabled {
+			t.Fatalf("Fast open option %v not disabled: %s", option, err)
+		}
+
+		if p.TCPDataStartBytes() != dataStart || !p.IsEmptyTCPPayload() || int(p.IPTotalLength) != len(p.GetBytes()) {
+			t.Errorf("Data of fast open option %v not removed: length=%d", option, p.IPTotalLength)
+		}
+
+		for _, b := range p.Buffer[dataStart-4 : dataStart] {
+			if b != TCPNopOption {
+				t.Errorf("Fast open option %v not replaced: %v", option, p.Buffer[dataStart-4:dataStart])
+			}
+		}
+
+		// The authentication option and the token can be attached now.
+		if err := p.TCPDataAttach([]byte{TCPAuthenticationOption, 4, 0, 0}, []byte("token")); err != nil {
+			t.Errorf("Unable to attach the token after disabling fast open option %v: %s", option, err)
+		}
+		p.UpdateTCPChecksum()
+
+		attached, err := New(0, p.GetBytes(), "0", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attached.VerifyIPChecksum() || !attached.VerifyTCPChecksum() {
+			t.Errorf("Invalid checksums after disabling fast open option %v", option)
+		}
+		if err := attached.CheckTCPAuthenticationOption(4); err != nil || string(attached.ReadTCPData()) != "token" {
+			t.Errorf("Token not attached after disabling fast open option %v: %s", option, err)
+		}
+	}
+
+	p := getTestPacket(t, synGoodTCPChecksum)
+	if disabled, err := p.DisableTCPFastOpen(); err != nil || disabled {
+		t.Errorf("Packet without fast open should not be modified: %t %s", disabled, err)
+	}
+	if !p.VerifyTCPChecksum() {
+		t.Errorf("Packet without fast open was modified")
+	}
+
+	p = newTCPFastOpenTestPacket(t, []byte{TCPFastOpenExperimentalOption, 4, 0, 0}, nil)
+	if disabled, err := p.DisableTCPFastOpen(); err != nil || disabled {
+		t.Errorf("Experimental option without the fast open magic should not be disabled: %t %s", disabled, err)
+	}
+}
+
+func TestTCPFastOpenIsNotAuthenticationOption(t *testing.T) {
+
+	t.Parallel()
+
+	p := newTCPFastOpenTestPacket(t, []byte{TCPFastOpenOption, 2}, nil)
+	if err := p.CheckTCPAuthenticationOption(4); err == nil {
+		t.Errorf("Fast open cookie request found as authentication option")
+	}
+
+	p = newTCPFastOpenTestPacket(t, []byte{TCPAuthenticationOption, 4, 0, 0}, nil)
+	if err := p.CheckTCPAuthenticationOption(4); err != nil {
+		t.Errorf("Authentication option not found: %s", err)
+	}
+}
+
+// newICMPv6TestPacket returns an IPv6 packet of an ICMPv6 message.
+func newICMPv6TestPacket(icmpType byte, hopLimit byte) []byte {
+
+	buf := make([]byte, ipv6HdrSize+24)
+	buf[ipv6VersionPos] = 6 << 4
+	binary.BigEndian.PutUint16(buf[4:6], 24)
+	buf[ipv6NextHeaderPos] = IPProtocolICMPv6
+	buf[ipv6HopLimitPos] = hopLimit
+	copy(buf[8:24], net.ParseIP("fe80::1"))
+	copy(buf[24:40], net.ParseIP("ff02::1:ff00:2"))
+	buf[ipv6HdrSize] = icmpType
+
+	return buf
+}
+
+func TestClearDontFragment(t *testing.T) {
+
+	t.Parallel()
+
+	p := getTestPacket(t, synGoodTCPChecksum)
+	if p.Buffer[ipFlagsPos]&ipDontFragment == 0 {
+		t.Fatalf("Test packet without the don't fragment flag")
+	}
+
+	if !ClearDontFragment(p.Buffer) {
+		t.Errorf("Don't fragment flag not cleared")
+	}
+	if p.Buffer[ipFlagsPos]&ipDontFragment != 0 {
+		t.Errorf("Don't fragment flag still set")
+	}
+
+	cleared, err := New(0, p.Buffer, "0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cleared.VerifyIPChecksum() {
+		t.Errorf("Invalid ip checksum after clearing the don't fragment flag")
+	}
+
+	if ClearDontFragment(p.Buffer) {
+		t.Errorf("Don't fragment flag cleared twice")
+	}
+
+	if ClearDontFragment(newICMPv6TestPacket(128, 64)) {
+		t.Errorf("Don't fragment flag cleared on an IPv6 packet")
+	}
+}
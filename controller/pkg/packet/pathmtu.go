@@ -0,0 +1,110 @@
+package packet
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// IPProtocolICMP defines the constant for ICMP protocol number
+const IPProtocolICMP = 1
+
+// ICMP errors that report the MTU of a path
+const (
+	// icmpDestinationUnreachable is the type of the ICMP errors of unreachable destinations
+	icmpDestinationUnreachable = 3
+
+	// icmpFragmentationNeeded is the code of the ICMP errors of packets that
+	// need to be fragmented but have the don't fragment flag (RFC 1191)
+	icmpFragmentationNeeded = 4
+
+	// icmpv6PacketTooBig is the type of the ICMPv6 errors of packets larger
+	// than the MTU of the next hop (RFC 4443)
+	icmpv6PacketTooBig = 2
+
+	// icmpHdrSize is the size of the ICMP and ICMPv6 error headers, which
+	// are followed by the start of the packet that caused the error
+	icmpHdrSize = 8
+)
+
+// ipv4 flags
+const (
+	// ipFlagsPos is the location of the flags and fragment offset
+	ipFlagsPos = 6
+
+	// ipDontFragment is the don't fragment flag
+	ipDontFragment = 0x40
+)
+
+// ParsePathMTUError returns the destination of the packet that caused an
+// ICMP fragmentation needed or ICMPv6 packet too big error, and the MTU of
+// the path reported by the error. It returns false for any other packet and
+// for the errors that do not report an MTU.
+func ParsePathMTUError(bytes []byte) (net.IP, int, bool) {
+
+	if len(bytes) == 0 {
+		return nil, 0, false
+	}
+
+	switch bytes[0] >> 4 {
+	case 4:
+		hdrLen := int(bytes[ipHdrLenPos]&ipHdrLenMask) * 4
+		if hdrLen < minIPHdrSize || bytes[ipProtoPos] != IPProtocolICMP {
+			return nil, 0, false
+		}
+
+		// The error is followed by the IP header of the packet.
+		icmp := bytes[hdrLen:]
+		if len(icmp) < icmpHdrSize+minIPHdrSize || icmp[0] != icmpDestinationUnreachable || icmp[1] != icmpFragmentationNeeded {
+			return nil, 0, false
+		}
+
+		// Routers that predate RFC 1191 do not report the MTU.
+		mtu := int(binary.BigEndian.Uint16(icmp[6:8]))
+		if mtu == 0 {
+			return nil, 0, false
+		}
+
+		original := icmp[icmpHdrSize:]
+		return net.IP(append([]byte{}, original[ipDestAddrPos:ipDestAddrPos+net.IPv4len]...)), mtu, true
+
+	case 6:
+		if len(bytes) < ipv6HdrSize || bytes[ipv6NextHeaderPos] != IPProtocolICMPv6 {
+			return nil, 0, false
+		}
+
+		icmp := bytes[ipv6HdrSize:]
+		if len(icmp) < icmpHdrSize+ipv6HdrSize || icmp[0] != icmpv6PacketTooBig {
+			return nil, 0, false
+		}
+
+		mtu := int(binary.BigEndian.Uint32(icmp[4:8]))
+		original := icmp[icmpHdrSize:]
+		return net.IP(append([]byte{}, original[24:24+net.IPv6len]...)), mtu, true
+
+	default:
+		return nil, 0, false
+	}
+}
+
+// ClearDontFragment clears the don't fragment flag of an IPv4 packet and
+// updates its header checksum, so that the routers fragment it rather than
+// drop it when it is larger than the MTU of the path. It returns false if
+// the flag was not set.
+func ClearDontFragment(bytes []byte) bool {
+
+	if len(bytes) < minIPHdrSize || bytes[0]>>4 != 4 || bytes[ipFlagsPos]&ipDontFragment == 0 {
+		return false
+	}
+
+	hdrLen := int(bytes[ipHdrLenPos]&ipHdrLenMask) * 4
+	if hdrLen < minIPHdrSize || len(bytes) < hdrLen {
+		return false
+	}
+
+	bytes[ipFlagsPos] &^= ipDontFragment
+
+	binary.BigEndian.PutUint16(bytes[ipChecksumPos:ipChecksumPos+2], 0)
+	binary.BigEndian.PutUint16(bytes[ipChecksumPos:ipChecksumPos+2], checksum(bytes[:hdrLen]))
+
+	return true
+}
@@ -9,12 +9,14 @@ import (
 	"sync"
 	"time"
 
+	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/common"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/acls"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/lookup"
 	"go.aporeto.io/trireme-lib/controller/pkg/packet"
 	"go.aporeto.io/trireme-lib/policy"
 	"go.aporeto.io/trireme-lib/utils/cache"
+	"go.aporeto.io/trireme-lib/utils/portspec"
 	"go.uber.org/zap"
 )
 
@@ -27,9 +29,20 @@ type policies struct {
 	encryptRules       *lookup.PolicyDB // Packet: Encrypt       Report: Encrypt
 }
 
-// LookupHost is mapped to the function net.LookupHost
+// LookupHost is mapped to the function net.LookupHost. A PU resolves its
+// DNS rules with the function that is set when the PU is created.
 var LookupHost = net.LookupHost
 
+// DNSRuleLifetime is the time a rule learned from a DNS resolution is
+// carried over policy updates without being resolved again.
+const DNSRuleLifetime = 5 * time.Minute
+
+// dnsRule is an application ACL rule learned from a DNS resolution.
+type dnsRule struct {
+	rule       policy.IPRule
+	expiration time.Time
+}
+
 // PUContext holds data indexed by the PU ID
 type PUContext struct {
 	id                string
@@ -42,7 +55,14 @@ type PUContext struct {
 	networkACLs       *acls.ACLCache
 	externalIPCache   cache.DataStore
 	udpNetworks       []*net.IPNet
+	targetNetworks    *acls.ACLCache
 	DNSACLs           cache.DataStore
+	dnsRules          map[string]*dnsRule
+	relatedRules      map[string]*relatedRule
+	nameRules         []policy.DNSRule
+	domainRules       []policy.DNSRule
+	dnsStats          collector.DNSRecord
+	lookupHost        func(string) ([]string, error)
 	mark              string
 	ProxyPort         string
 	tcpPorts          []string
@@ -54,6 +74,11 @@ type PUContext struct {
 	jwt               string
 	jwtExpiration     time.Time
 	scopes            []string
+	mutualAuth        policy.MutualAuthorizationType
+	rateLimit         policy.RateLimit
+	rateLimiters      cache.DataStore
+	direction         policy.EnforcementDirection
+	draining          bool
 	Extension         interface{}
 	CancelFunc        context.CancelFunc
 	sync.RWMutex
@@ -73,11 +98,21 @@ func NewPU(contextID string, puInfo *policy.PUInfo, timeout time.Duration) (*PUC
 		externalIPCache: cache.NewCacheWithExpiration("External IP Cache", timeout),
 		ApplicationACLs: acls.NewACLCache(),
 		networkACLs:     acls.NewACLCache(),
+		dnsRules:        map[string]*dnsRule{},
+		relatedRules:    map[string]*relatedRule{},
+		lookupHost:      LookupHost,
 		mark:            puInfo.Runtime.Options().CgroupMark,
 		scopes:          puInfo.Policy.Scopes(),
+		mutualAuth:      puInfo.Policy.MutualAuthorization(),
+		rateLimit:       puInfo.Policy.RateLimit(),
+		direction:       puInfo.Runtime.Options().EnforcementDirection,
 		CancelFunc:      cancelFunc,
 	}
 
+	if !pu.rateLimit.Unlimited() {
+		pu.rateLimiters = cache.NewCacheWithExpiration("Rate Limiters", rateLimiterTimeout)
+	}
+
 	pu.CreateRcvRules(puInfo.Policy.ReceiverRules())
 
 	pu.CreateTxtRules(puInfo.Policy.TransmitterRules())
@@ -97,6 +132,13 @@ func NewPU(contextID string, puInfo *policy.PUInfo, timeout time.Duration) (*PUC
 	}
 	pu.udpNetworks = udpNetworks
 
+	if networks := puInfo.Policy.TriremeNetworks(); len(networks) > 0 {
+		pu.targetNetworks = acls.NewACLCache()
+		if err := pu.targetNetworks.AddRuleList(targetNetworkRules(networks)); err != nil {
+			return nil, fmt.Errorf("Invalid target networks: %s", err)
+		}
+	}
+
 	if err := pu.UpdateApplicationACLs(puInfo.Policy.ApplicationACLs()); err != nil {
 		return nil, err
 	}
@@ -106,12 +148,175 @@ func NewPU(contextID string, puInfo *policy.PUInfo, timeout time.Duration) (*PUC
 	}
 
 	dnsACL := puInfo.Policy.DNSNameACLs()
+	pu.nameRules, pu.domainRules = splitDomainRules(dnsACL)
 	pu.startDNS(ctx, &dnsACL)
 
 	return pu, nil
 }
 
-func createACLRules(rules *policy.IPRuleList, port string, ip string) *policy.IPRuleList {
+// targetNetworkRules returns the rules that accept the TCP flows to the
+// target networks.
+func targetNetworkRules(networks []string) policy.IPRuleList {
+
+	rules := policy.IPRuleList{}
+	for _, network := range networks {
+		rules = append(rules, policy.IPRule{
+			Address:  network,
+			Port:     "0:65535",
+			Protocol: "tcp",
+			Policy:   &policy.FlowPolicy{Action: policy.Accept},
+		})
+	}
+
+	return rules
+}
+
+// dnsService is a port and protocol opened for the addresses of a name.
+type dnsService struct {
+	port     string
+	protocol string
+}
+
+// dnsServices returns the ports and protocols opened by a DNS rule. The port
+// of the rule is either a port used with the protocol of the rule, or a comma
+// separated list of port/protocol pairs such as 443/tcp,443/udp. The protocol
+// is TCP if it is not provided.
+func dnsServices(rule policy.DNSRule) []dnsService {
+
+	services := []dnsService{}
+
+	for _, entry := range strings.Split(rule.Port, ",") {
+		port := strings.TrimSpace(entry)
+		protocol := rule.Protocol
+
+		if parts := strings.Split(port, "/"); len(parts) == 2 {
+			port = parts[0]
+			protocol = parts[1]
+		}
+
+		if port == "" {
+			zap.L().Warn("Invalid port in DNS rule", zap.String("name", rule.Name), zap.String("port", rule.Port))
+			continue
+		}
+
+		if protocol == "" {
+			protocol = "TCP"
+		}
+
+		services = append(services, dnsService{
+			port:     port,
+			protocol: strings.ToUpper(protocol),
+		})
+	}
+
+	return services
+}
+
+// splitDomainRules separates the DNS rules of domains, such as
+// *.amazonaws.com, from the rules of names. All the rules are still resolved,
+// and the domains are also matched against the reverse DNS names of the
+// destinations.
+func splitDomainRules(rules policy.DNSRuleList) (names policy.DNSRuleList, domains policy.DNSRuleList) {
+
+	names = policy.DNSRuleList{}
+	for _, rule := range rules {
+		if strings.HasPrefix(rule.Name, "*.") {
+			domains = append(domains, rule)
+			continue
+		}
+		names = append(names, rule)
+	}
+
+	return names, domains
+}
+
+// ApplicationACLPolicyFromName returns the policy of the DNS rules of domains
+// for a destination with the given reverse DNS name. It returns an error if
+// no rule matches the name, port and protocol.
+func (p *PUContext) ApplicationACLPolicyFromName(name string, port uint16, protocol string) (*policy.FlowPolicy, error) {
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	for _, rule := range p.domainRules {
+		if !strings.HasSuffix(name, strings.ToLower(strings.TrimPrefix(rule.Name, "*"))) {
+			continue
+		}
+
+		if dnsRuleIncludes(rule, port, protocol) {
+			return dnsRulePolicy(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("no dns rule for name %s", name)
+}
+
+// ApplicationACLPolicyFromServerName returns the policy of the DNS rules for
+// a destination with the given server name, such as the name of a TLS
+// ClientHello. The names of the rules match exactly and the domains match
+// their subdomains. It returns an error if no rule matches the name, port
+// and protocol.
+func (p *PUContext) ApplicationACLPolicyFromServerName(name string, port uint16, protocol string) (*policy.FlowPolicy, error) {
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	for _, rule := range p.nameRules {
+		if strings.ToLower(strings.TrimSuffix(rule.Name, ".")) != name {
+			continue
+		}
+
+		if dnsRuleIncludes(rule, port, protocol) {
+			return dnsRulePolicy(), nil
+		}
+	}
+
+	return p.ApplicationACLPolicyFromName(name, port, protocol)
+}
+
+// HasDNSRules returns true if a DNS rule of the PU opens the port and
+// protocol for some name.
+func (p *PUContext) HasDNSRules(port uint16, protocol string) bool {
+
+	for _, rules := range [][]policy.DNSRule{p.nameRules, p.domainRules} {
+		for _, rule := range rules {
+			if dnsRuleIncludes(rule, port, protocol) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// dnsRuleIncludes returns true if the DNS rule opens the port and protocol.
+func dnsRuleIncludes(rule policy.DNSRule, port uint16, protocol string) bool {
+
+	for _, s := range dnsServices(rule) {
+		if s.protocol != strings.ToUpper(protocol) {
+			continue
+		}
+
+		ports, err := portspec.NewPortSpecFromString(s.port, nil)
+		if err != nil || !ports.IsIncluded(int(port)) {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// dnsRulePolicy returns the policy of the flows accepted by a DNS rule.
+func dnsRulePolicy() *policy.FlowPolicy {
+	return &policy.FlowPolicy{
+		Action:        policy.Accept,
+		ObserveAction: policy.ObserveNone,
+		ServiceID:     "default",
+		PolicyID:      "default",
+	}
+}
+
+func createACLRules(rules *policy.IPRuleList, port string, protocol string, ip string) *policy.IPRuleList {
 	// ipv6 is not supported
 	if strings.Contains(ip, ":") {
 		return rules
@@ -121,7 +326,7 @@ func createACLRules(rules *policy.IPRuleList, port string, ip string) *policy.IP
 	rulesAppend = append(*rules, policy.IPRule{
 		Address:  ip,
 		Port:     port,
-		Protocol: "TCP",
+		Protocol: protocol,
 		Policy: &policy.FlowPolicy{
 			Action:        policy.Accept,
 			ObserveAction: policy.ObserveNone,
@@ -139,17 +344,26 @@ func (p *PUContext) dnsToACLs(dnsList *policy.DNSRuleList, ipcache map[string]bo
 
 	rules = new(policy.IPRuleList)
 	for _, name := range *dnsList {
-		if ips, err := LookupHost(name.Name); err == nil {
+		ips, err := p.lookupHost(name.Name)
+		p.countDNSLookup(err == nil)
+
+		if err == nil {
+			services := dnsServices(name)
 			for _, ip := range ips {
-				if ipcache[ip] == false {
-					rules = createACLRules(rules, name.Port, ip)
-					ipcache[ip] = true
+				for _, s := range services {
+					key := dnsRuleKey(ip, s.port, s.protocol)
+					if !p.refreshDNSRule(key) && !ipcache[key] {
+						rules = createACLRules(rules, s.port, s.protocol, ip)
+					}
+					ipcache[key] = true
 				}
 			}
 
 			if len(*rules) > 0 {
 				if err := p.UpdateApplicationACLs(*rules); err != nil {
 					zap.L().Error("Error in Adding rules", zap.Error(err))
+				} else {
+					p.learnDNSRules(*rules)
 				}
 				// empty the contents of the rules
 				rules = new(policy.IPRuleList)
@@ -160,6 +374,111 @@ func (p *PUContext) dnsToACLs(dnsList *policy.DNSRuleList, ipcache map[string]bo
 	}
 }
 
+// dnsRuleKey returns the key of a learned DNS rule.
+func dnsRuleKey(ip string, port string, protocol string) string {
+	return ip + ":" + port + "/" + strings.ToUpper(protocol)
+}
+
+// learnDNSRules records the rules learned from a DNS resolution.
+func (p *PUContext) learnDNSRules(rules policy.IPRuleList) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.dnsStats.RulesAdded += len(rules)
+
+	expiration := time.Now().Add(DNSRuleLifetime)
+	for _, rule := range rules {
+		p.dnsRules[dnsRuleKey(rule.Address, rule.Port, rule.Protocol)] = &dnsRule{
+			rule:       rule,
+			expiration: expiration,
+		}
+	}
+}
+
+// refreshDNSRule extends the lifetime of a learned DNS rule. It returns
+// false if the rule is not known.
+func (p *PUContext) refreshDNSRule(key string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	r, ok := p.dnsRules[key]
+	if !ok {
+		return false
+	}
+
+	r.expiration = time.Now().Add(DNSRuleLifetime)
+	return true
+}
+
+// countDNSLookup records a resolution of a name of the DNS rules.
+func (p *PUContext) countDNSLookup(resolved bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.dnsStats.Lookups++
+	if !resolved {
+		p.dnsStats.Unresolved++
+	}
+}
+
+// DNSStats returns the activity of the rules learned from DNS since the
+// previous call, or nil if there was none.
+func (p *PUContext) DNSStats() *collector.DNSRecord {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.dnsStats == (collector.DNSRecord{}) {
+		return nil
+	}
+
+	stats := p.dnsStats
+	stats.ContextID = p.id
+	p.dnsStats = collector.DNSRecord{}
+
+	return &stats
+}
+
+// InheritDNSACLs applies the unexpired rules that a previous context of the
+// same PU learned from DNS resolutions on top of the application ACLs of
+// this context. Resolved names keep working across policy updates until
+// they are resolved again.
+func (p *PUContext) InheritDNSACLs(prev *PUContext) error {
+
+	now := time.Now()
+	learned := map[string]*dnsRule{}
+	expired := 0
+
+	prev.RLock()
+	for key, r := range prev.dnsRules {
+		if r.expiration.After(now) {
+			learned[key] = &dnsRule{rule: r.rule, expiration: r.expiration}
+		} else {
+			expired++
+		}
+	}
+	prev.RUnlock()
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.dnsStats.RulesExpired += expired
+
+	rules := policy.IPRuleList{}
+	for key, r := range learned {
+		if _, ok := p.dnsRules[key]; ok {
+			continue
+		}
+		p.dnsRules[key] = r
+		rules = append(rules, r.rule)
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return p.ApplicationACLs.AddRuleList(rules)
+}
+
 func (p *PUContext) startDNS(ctx context.Context, dnsList *policy.DNSRuleList) {
 	var ipcache map[string]bool
 
@@ -204,11 +523,70 @@ func (p *PUContext) UDPNetworks() []*net.IPNet {
 	return p.udpNetworks
 }
 
+// TargetNetworks returns the target networks of the PU, or nil if the PU
+// uses the target networks of the enforcer.
+func (p *PUContext) TargetNetworks() *acls.ACLCache {
+	return p.targetNetworks
+}
+
 // Type return the pu type
 func (p *PUContext) Type() common.PUType {
 	return p.puType
 }
 
+// MutualAuthorization returns true if the transmitter rules of the PU must be
+// evaluated. The datapath setting is used when the policy does not override it.
+func (p *PUContext) MutualAuthorization(datapathDefault bool) bool {
+	switch p.mutualAuth {
+	case policy.MutualAuthorizationEnabled:
+		return true
+	case policy.MutualAuthorizationDisabled:
+		return false
+	default:
+		return datapathDefault
+	}
+}
+
+// AllowConnection returns false if a new connection from the source exceeds
+// the rate limit of the PU.
+func (p *PUContext) AllowConnection(sourceIP string) bool {
+
+	if p.rateLimiters == nil {
+		return true
+	}
+
+	// Active sources keep their limiter.
+	limiter, err := p.rateLimiters.GetReset(sourceIP, 0)
+	if err != nil {
+		limiter = newTokenBucket(p.rateLimit)
+		if err = p.rateLimiters.Add(sourceIP, limiter); err != nil {
+			// Another packet of the same source created the limiter.
+			if limiter, err = p.rateLimiters.Get(sourceIP); err != nil {
+				return true
+			}
+		}
+	}
+
+	return limiter.(*tokenBucket).allow(time.Now())
+}
+
+// Drain stops the PU from accepting new connections. The established
+// connections are not affected.
+func (p *PUContext) Drain() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.draining = true
+}
+
+// Draining returns true if the PU does not accept new connections.
+func (p *PUContext) Draining() bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.draining
+}
+
 // Identity returns the indentity
 func (p *PUContext) Identity() *policy.TagStore {
 	return p.identity
@@ -219,6 +597,12 @@ func (p *PUContext) Mark() string {
 	return p.mark
 }
 
+// EnforcementDirection returns the direction of the traffic that is enforced
+// for the PU.
+func (p *PUContext) EnforcementDirection() policy.EnforcementDirection {
+	return p.direction
+}
+
 // TCPPorts returns the PU TCP ports
 func (p *PUContext) TCPPorts() []string {
 	return p.tcpPorts
@@ -244,22 +628,22 @@ func (p *PUContext) NetworkACLPolicy(packet *packet.Packet) (report *policy.Flow
 	defer p.RUnlock()
 	p.RLock()
 
-	return p.networkACLs.GetMatchingAction(packet.SourceAddress.To4(), packet.DestinationPort)
+	return p.networkACLs.GetMatchingAction(packet.SourceAddress.To4(), packet.DestinationPort, packet.SourcePort)
 }
 
-// NetworkACLPolicyFromAddr retrieve the policy given an address and port.
-func (p *PUContext) NetworkACLPolicyFromAddr(addr net.IP, port uint16) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+// NetworkACLPolicyFromAddr retrieve the policy given an address, port and source port.
+func (p *PUContext) NetworkACLPolicyFromAddr(addr net.IP, port uint16, sourcePort uint16) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
 	defer p.RUnlock()
 	p.RLock()
 
-	return p.networkACLs.GetMatchingAction(addr, port)
+	return p.networkACLs.GetMatchingAction(addr, port, sourcePort)
 }
 
-// ApplicationACLPolicyFromAddr retrieve the policy given an address and port.
-func (p *PUContext) ApplicationACLPolicyFromAddr(addr net.IP, port uint16) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+// ApplicationACLPolicyFromAddr retrieve the policy given an address, port and source port.
+func (p *PUContext) ApplicationACLPolicyFromAddr(addr net.IP, port uint16, sourcePort uint16) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
 	defer p.RUnlock()
 	p.RLock()
-	return p.ApplicationACLs.GetMatchingAction(addr, port)
+	return p.ApplicationACLs.GetMatchingAction(addr, port, sourcePort)
 }
 
 // UpdateApplicationACLs updates the application ACL policy
@@ -276,6 +660,57 @@ func (p *PUContext) UpdateNetworkACLs(rules policy.IPRuleList) error {
 	return p.networkACLs.AddRuleList(rules)
 }
 
+// SetImplicitAllowNetworks sets the networks whose traffic the application
+// and network ACLs accept when no rule matches.
+func (p *PUContext) SetImplicitAllowNetworks(networks []string) error {
+	defer p.Unlock()
+	p.Lock()
+
+	if err := p.ApplicationACLs.SetImplicitAllowNetworks(networks); err != nil {
+		return err
+	}
+
+	return p.networkACLs.SetImplicitAllowNetworks(networks)
+}
+
+// UpdateAddressSet sets the addresses of an address set of the application
+// and network ACLs. The cached policies of the external flows are dropped,
+// so that the flows are matched against the new addresses.
+func (p *PUContext) UpdateAddressSet(name string, addresses []string) error {
+	defer p.Unlock()
+	p.Lock()
+
+	if err := p.ApplicationACLs.UpdateAddressSet(name, addresses); err != nil {
+		return err
+	}
+
+	if err := p.networkACLs.UpdateAddressSet(name, addresses); err != nil {
+		return err
+	}
+
+	p.flushExternalFlowPolicies()
+	return nil
+}
+
+// RemoveAddressSet removes an address set of the application and network
+// ACLs. The rules that reference it no longer match any address.
+func (p *PUContext) RemoveAddressSet(name string) {
+	defer p.Unlock()
+	p.Lock()
+
+	p.ApplicationACLs.RemoveAddressSet(name)
+	p.networkACLs.RemoveAddressSet(name)
+
+	p.flushExternalFlowPolicies()
+}
+
+// flushExternalFlowPolicies drops the cached policies of the external flows.
+func (p *PUContext) flushExternalFlowPolicies() {
+	for _, id := range p.externalIPCache.KeyList() {
+		p.externalIPCache.Remove(id) // nolint
+	}
+}
+
 // CacheExternalFlowPolicy will cache an external flow
 func (p *PUContext) CacheExternalFlowPolicy(packet *packet.Packet, plc interface{}) {
 	p.externalIPCache.AddOrUpdate(packet.SourceAddress.String()+":"+strconv.Itoa(int(packet.SourcePort)), plc)
@@ -395,6 +830,16 @@ func (p *PUContext) createRuleDBs(policyRules policy.TagSelectorList) *policies
 	return policyDB
 }
 
+// matchedPolicy returns a copy of the policy found in the policy DB with the
+// ID of the selector that matched.
+func matchedPolicy(db *lookup.PolicyDB, index int, action interface{}) *policy.FlowPolicy {
+
+	matched := *action.(*policy.FlowPolicy)
+	matched.SelectorID = db.SelectorID(index)
+
+	return &matched
+}
+
 // CreateRcvRules create receive rules for this PU based on the update of the policy.
 func (p *PUContext) CreateRcvRules(policyRules policy.TagSelectorList) {
 	p.rcv = p.createRuleDBs(policyRules)
@@ -419,13 +864,13 @@ func (p *PUContext) searchRules(
 		// Look for rejection rules
 		observeIndex, observeAction := policies.observeRejectRules.Search(tags)
 		if observeIndex >= 0 {
-			reportingAction = observeAction.(*policy.FlowPolicy)
+			reportingAction = matchedPolicy(policies.observeRejectRules, observeIndex, observeAction)
 		}
 		// TODO: Is this if case required ?
 		if packetAction == nil {
 			index, action := policies.rejectRules.Search(tags)
 			if index >= 0 {
-				packetAction = action.(*policy.FlowPolicy)
+				packetAction = matchedPolicy(policies.rejectRules, index, action)
 				if reportingAction == nil {
 					reportingAction = packetAction
 				}
@@ -438,23 +883,24 @@ func (p *PUContext) searchRules(
 		// Look for allow rules
 		observeIndex, observeAction := policies.observeAcceptRules.Search(tags)
 		if observeIndex >= 0 {
-			reportingAction = observeAction.(*policy.FlowPolicy)
+			reportingAction = matchedPolicy(policies.observeAcceptRules, observeIndex, observeAction)
 		}
 	}
 
 	if packetAction == nil {
 		index, action := policies.acceptRules.Search(tags)
 		if index >= 0 {
-			packetAction = action.(*policy.FlowPolicy)
+			packetAction = matchedPolicy(policies.acceptRules, index, action)
 			// Look for encrypt rules
 			encryptIndex, _ := policies.encryptRules.Search(tags)
 			if encryptIndex >= 0 {
 				// Do not overwrite the action for accept rules.
-				finalAction := action.(*policy.FlowPolicy)
+				finalAction := packetAction
 				packetAction = &policy.FlowPolicy{
-					Action:    policy.Accept | policy.Encrypt,
-					PolicyID:  finalAction.PolicyID,
-					ServiceID: finalAction.ServiceID,
+					Action:     policy.Accept | policy.Encrypt,
+					PolicyID:   finalAction.PolicyID,
+					ServiceID:  finalAction.ServiceID,
+					SelectorID: finalAction.SelectorID,
 				}
 			}
 			if reportingAction == nil {
@@ -467,7 +913,7 @@ func (p *PUContext) searchRules(
 	// Look for observe apply rules
 	observeIndex, observeAction := policies.observeApplyRules.Search(tags)
 	if observeIndex >= 0 {
-		packetAction = observeAction.(*policy.FlowPolicy)
+		packetAction = matchedPolicy(policies.observeApplyRules, observeIndex, observeAction)
 		if reportingAction == nil {
 			reportingAction = packetAction
 		}
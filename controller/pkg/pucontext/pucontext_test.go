@@ -0,0 +1,409 @@
+package pucontext
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestDNSServices(t *testing.T) {
+
+	Convey("Given DNS rules", t, func() {
+
+		Convey("A rule with a single port should use the protocol of the rule", func() {
+			services := dnsServices(policy.DNSRule{Name: "a.com", Port: "53", Protocol: "udp"})
+			So(services, ShouldResemble, []dnsService{{port: "53", protocol: "UDP"}})
+		})
+
+		Convey("A rule without a protocol should use TCP", func() {
+			services := dnsServices(policy.DNSRule{Name: "a.com", Port: "80"})
+			So(services, ShouldResemble, []dnsService{{port: "80", protocol: "TCP"}})
+		})
+
+		Convey("A rule with a list of ports and protocols should open all of them", func() {
+			services := dnsServices(policy.DNSRule{Name: "a.com", Port: "443/tcp, 443/udp,80", Protocol: "tcp"})
+			So(services, ShouldResemble, []dnsService{
+				{port: "443", protocol: "TCP"},
+				{port: "443", protocol: "UDP"},
+				{port: "80", protocol: "TCP"},
+			})
+		})
+
+		Convey("Empty ports should be ignored", func() {
+			services := dnsServices(policy.DNSRule{Name: "a.com", Port: "443/tcp,,/udp"})
+			So(services, ShouldResemble, []dnsService{{port: "443", protocol: "TCP"}})
+		})
+	})
+}
+
+func TestDNSToACLs(t *testing.T) {
+
+	Convey("Given a PU context with a DNS rule for several protocols", t, func() {
+		origLookupHost := LookupHost
+		defer func() {
+			LookupHost = origLookupHost
+		}()
+
+		LookupHost = func(name string) ([]string, error) {
+			if name == "a.com" {
+				return []string{"10.1.1.1"}, nil
+			}
+			return nil, fmt.Errorf("unknown name")
+		}
+
+		pu, err := NewPU("pu", policy.NewPUInfo("pu", common.ContainerPU), time.Second)
+		So(err, ShouldBeNil)
+
+		dnsList := policy.DNSRuleList{{Name: "a.com", Port: "443/tcp,443/udp"}}
+		ipcache := map[string]bool{}
+
+		Convey("When the name is resolved, a rule should be learned for every protocol", func() {
+			pu.dnsToACLs(&dnsList, ipcache)
+
+			So(pu.dnsRules, ShouldContainKey, dnsRuleKey("10.1.1.1", "443", "tcp"))
+			So(pu.dnsRules, ShouldContainKey, dnsRuleKey("10.1.1.1", "443", "udp"))
+			So(pu.dnsRules[dnsRuleKey("10.1.1.1", "443", "udp")].rule.Protocol, ShouldEqual, "UDP")
+			So(len(pu.dnsRules), ShouldEqual, 2)
+
+			_, action, err := pu.ApplicationACLPolicyFromAddr(net.ParseIP("10.1.1.1").To4(), 443, 0)
+			So(err, ShouldBeNil)
+			So(action.Action.Accepted(), ShouldBeTrue)
+
+			Convey("When the name is resolved again, no rule should be added", func() {
+				pu.dnsToACLs(&dnsList, ipcache)
+				So(len(pu.dnsRules), ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestDNSStats(t *testing.T) {
+
+	Convey("Given a PU context with DNS rules", t, func() {
+		origLookupHost := LookupHost
+		defer func() {
+			LookupHost = origLookupHost
+		}()
+
+		LookupHost = func(name string) ([]string, error) {
+			if name == "a.com" {
+				return []string{"10.1.1.1", "10.1.1.2"}, nil
+			}
+			return nil, fmt.Errorf("unknown name")
+		}
+
+		pu, err := NewPU("pu", policy.NewPUInfo("pu", common.ContainerPU), time.Second)
+		So(err, ShouldBeNil)
+		So(pu.DNSStats(), ShouldBeNil)
+
+		dnsList := policy.DNSRuleList{{Name: "a.com", Port: "443"}, {Name: "b.com", Port: "443"}}
+
+		Convey("When the names are resolved, the lookups and learned rules should be counted", func() {
+			pu.dnsToACLs(&dnsList, map[string]bool{})
+
+			So(pu.DNSStats(), ShouldResemble, &collector.DNSRecord{
+				ContextID:  "pu",
+				Lookups:    2,
+				Unresolved: 1,
+				RulesAdded: 2,
+			})
+
+			Convey("Then the counters should be reset", func() {
+				So(pu.DNSStats(), ShouldBeNil)
+			})
+
+			Convey("When a new context inherits the rules, the expired rules should be counted", func() {
+				pu.dnsRules[dnsRuleKey("10.1.1.1", "443", "tcp")].expiration = time.Now().Add(-time.Second)
+
+				next, err := NewPU("pu", policy.NewPUInfo("pu", common.ContainerPU), time.Second)
+				So(err, ShouldBeNil)
+				So(next.InheritDNSACLs(pu), ShouldBeNil)
+
+				So(next.DNSStats(), ShouldResemble, &collector.DNSRecord{
+					ContextID:    "pu",
+					RulesExpired: 1,
+				})
+				So(len(next.dnsRules), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestApplicationACLPolicyFromName(t *testing.T) {
+
+	Convey("Given a PU context with DNS rules of names and domains", t, func() {
+		origLookupHost := LookupHost
+		defer func() {
+			LookupHost = origLookupHost
+		}()
+
+		lookups := make(chan string, 10)
+		LookupHost = func(name string) ([]string, error) {
+			lookups <- name
+			return nil, fmt.Errorf("unknown name")
+		}
+
+		puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+		puInfo.Policy.UpdateDNSNetworks(policy.DNSRuleList{
+			{Name: "a.com", Port: "443"},
+			{Name: "*.amazonaws.com", Port: "443/tcp,1000:2000/udp"},
+		})
+		pu, err := NewPU("pu", puInfo, time.Second)
+		So(err, ShouldBeNil)
+		defer pu.CancelFunc()
+
+		Convey("All the rules should still be resolved", func() {
+			So(<-lookups, ShouldEqual, "a.com")
+			So(<-lookups, ShouldEqual, "*.amazonaws.com")
+			So(pu.domainRules, ShouldResemble, []policy.DNSRule{{Name: "*.amazonaws.com", Port: "443/tcp,1000:2000/udp"}})
+		})
+
+		Convey("The names of the domain should be accepted on the ports of the rule", func() {
+			action, err := pu.ApplicationACLPolicyFromName("ec2-1-2-3-4.compute-1.AmazonAWS.com.", 443, "tcp")
+			So(err, ShouldBeNil)
+			So(action.Action.Accepted(), ShouldBeTrue)
+
+			action, err = pu.ApplicationACLPolicyFromName("s3.amazonaws.com", 1500, "udp")
+			So(err, ShouldBeNil)
+			So(action.Action.Accepted(), ShouldBeTrue)
+		})
+
+		Convey("Other names, ports and protocols should not be accepted", func() {
+			for _, c := range []struct {
+				name     string
+				port     uint16
+				protocol string
+			}{
+				{"amazonaws.com", 443, "tcp"},
+				{"s3.amazonaws.com.evil.com", 443, "tcp"},
+				{"s3.notamazonaws.com", 443, "tcp"},
+				{"s3.amazonaws.com", 80, "tcp"},
+				{"s3.amazonaws.com", 443, "udp"},
+				{"a.com", 443, "tcp"},
+			} {
+				_, err := pu.ApplicationACLPolicyFromName(c.name, c.port, c.protocol)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestApplicationACLPolicyFromServerName(t *testing.T) {
+
+	Convey("Given a PU context with DNS rules of names and domains", t, func() {
+		origLookupHost := LookupHost
+		defer func() {
+			LookupHost = origLookupHost
+		}()
+
+		LookupHost = func(name string) ([]string, error) {
+			return nil, fmt.Errorf("unknown name")
+		}
+
+		puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+		puInfo.Policy.UpdateDNSNetworks(policy.DNSRuleList{
+			{Name: "a.com", Port: "443"},
+			{Name: "*.amazonaws.com", Port: "443/tcp,1000:2000/udp"},
+		})
+		pu, err := NewPU("pu", puInfo, time.Second)
+		So(err, ShouldBeNil)
+		defer pu.CancelFunc()
+
+		Convey("The names and the names of the domains should be accepted on the ports of the rules", func() {
+			action, err := pu.ApplicationACLPolicyFromServerName("A.com", 443, "tcp")
+			So(err, ShouldBeNil)
+			So(action.Action.Accepted(), ShouldBeTrue)
+
+			action, err = pu.ApplicationACLPolicyFromServerName("s3.amazonaws.com", 443, "tcp")
+			So(err, ShouldBeNil)
+			So(action.Action.Accepted(), ShouldBeTrue)
+		})
+
+		Convey("Other names, ports and protocols should not be accepted", func() {
+			for _, c := range []struct {
+				name     string
+				port     uint16
+				protocol string
+			}{
+				{"b.a.com", 443, "tcp"},
+				{"a.com.evil.com", 443, "tcp"},
+				{"a.com", 80, "tcp"},
+				{"a.com", 443, "udp"},
+				{"amazonaws.com", 443, "tcp"},
+			} {
+				_, err := pu.ApplicationACLPolicyFromServerName(c.name, c.port, c.protocol)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("The ports opened by the rules should be known", func() {
+			So(pu.HasDNSRules(443, "tcp"), ShouldBeTrue)
+			So(pu.HasDNSRules(1500, "udp"), ShouldBeTrue)
+			So(pu.HasDNSRules(80, "tcp"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestSimulate(t *testing.T) {
+
+	Convey("Given a PU context with transmitter rules and application ACLs", t, func() {
+		pu, err := NewPU("pu", policy.NewPUInfo("pu", common.ContainerPU), time.Second)
+		So(err, ShouldBeNil)
+
+		pu.CreateTxtRules(policy.TagSelectorList{
+			{
+				ID: "selector-accept",
+				Clause: []policy.KeyValueOperator{
+					{Key: "app", Value: []string{"web"}, Operator: policy.Equal},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "accept"},
+			},
+			{
+				ID: "selector-reject",
+				Clause: []policy.KeyValueOperator{
+					{Key: "app", Value: []string{"db"}, Operator: policy.Equal},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "reject"},
+			},
+			{
+				ID: "selector-observe",
+				Clause: []policy.KeyValueOperator{
+					{Key: "app", Value: []string{"cache"}, Operator: policy.Equal},
+				},
+				Policy: &policy.FlowPolicy{Action: policy.Reject, ObserveAction: policy.ObserveContinue, PolicyID: "observe"},
+			},
+		})
+
+		So(pu.UpdateApplicationACLs(policy.IPRuleList{
+			{
+				Address:  "20.1.1.0/24",
+				Port:     "443",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, PolicyID: "external"},
+			},
+		}), ShouldBeNil)
+
+		Convey("A connection to a PU matching an accept rule should be accepted", func() {
+			result := pu.Simulate(policy.NewTagStoreFromSlice([]string{"app=web"}), net.ParseIP("10.1.1.1"), 80, "tcp")
+			So(result.Verdict, ShouldEqual, SimulationAccepted)
+			So(result.SelectorID, ShouldEqual, "selector-accept")
+			So(result.Policy.PolicyID, ShouldEqual, "accept")
+			So(result.ACLMatched, ShouldBeFalse)
+		})
+
+		Convey("A connection to a PU matching a reject rule should be rejected", func() {
+			result := pu.Simulate(policy.NewTagStoreFromSlice([]string{"app=db"}), net.ParseIP("10.1.1.1"), 5432, "tcp")
+			So(result.Verdict, ShouldEqual, SimulationRejected)
+			So(result.SelectorID, ShouldEqual, "selector-reject")
+		})
+
+		Convey("A connection to a PU matching an observed rule should be observed", func() {
+			result := pu.Simulate(policy.NewTagStoreFromSlice([]string{"app=cache"}), net.ParseIP("10.1.1.1"), 6379, "udp")
+			So(result.Verdict, ShouldEqual, SimulationObserved)
+			So(result.Report.PolicyID, ShouldEqual, "observe")
+			So(result.ACL, ShouldBeNil)
+		})
+
+		Convey("A connection to a PU matching no rule should be rejected by default", func() {
+			result := pu.Simulate(policy.NewTagStoreFromSlice([]string{"app=other"}), net.ParseIP("10.1.1.1"), 80, "tcp")
+			So(result.Verdict, ShouldEqual, SimulationRejected)
+			So(result.SelectorID, ShouldBeEmpty)
+			So(result.Policy.PolicyID, ShouldEqual, "default")
+		})
+
+		Convey("A connection to an external network should use the ACLs", func() {
+			result := pu.Simulate(nil, net.ParseIP("20.1.1.1"), 443, "tcp")
+			So(result.Verdict, ShouldEqual, SimulationAccepted)
+			So(result.ACLMatched, ShouldBeTrue)
+			So(result.ACL.PolicyID, ShouldEqual, "external")
+
+			result = pu.Simulate(nil, net.ParseIP("20.1.1.1"), 80, "tcp")
+			So(result.Verdict, ShouldEqual, SimulationRejected)
+			So(result.ACLMatched, ShouldBeFalse)
+		})
+
+		Convey("A connection to an external network with another protocol should be rejected", func() {
+			result := pu.Simulate(nil, net.ParseIP("20.1.1.1"), 443, "udp")
+			So(result.Verdict, ShouldEqual, SimulationRejected)
+			So(result.ACL, ShouldBeNil)
+			So(result.Policy.PolicyID, ShouldEqual, "default")
+		})
+	})
+}
+
+func TestState(t *testing.T) {
+
+	Convey("Given a PU context with rules learned from DNS", t, func() {
+		puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+		puInfo.Policy.UpdateDNSNetworks(policy.DNSRuleList{
+			{Name: "*.amazonaws.com", Port: "443"},
+		})
+		pu, err := NewPU("pu", puInfo, time.Second)
+		So(err, ShouldBeNil)
+
+		rules := policy.IPRuleList{
+			{Address: "2.2.2.2", Port: "443", Protocol: "TCP", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "dns"}},
+			{Address: "1.1.1.1", Port: "443", Protocol: "TCP", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "dns"}},
+		}
+		pu.learnDNSRules(rules)
+		So(pu.UpdateApplicationACLs(rules), ShouldBeNil)
+
+		Convey("The state should contain the learned rules and their compiled ACLs", func() {
+			state := pu.State()
+			So(state.ContextID, ShouldEqual, "pu")
+			So(len(state.DNSRules), ShouldEqual, 2)
+			So(state.DNSRules[0].Rule.Address, ShouldEqual, "1.1.1.1")
+			So(state.DNSRules[1].Rule.Address, ShouldEqual, "2.2.2.2")
+			So(state.DNSRules[0].Expiration.After(time.Now()), ShouldBeTrue)
+			So(len(state.ApplicationACLs.Accept), ShouldEqual, 2)
+			So(state.DomainRules, ShouldResemble, policy.DNSRuleList{{Name: "*.amazonaws.com", Port: "443"}})
+			So(state.TargetNetworks, ShouldBeNil)
+		})
+	})
+}
+
+func TestRelatedRules(t *testing.T) {
+
+	Convey("Given a PU context with the rules of related connections", t, func() {
+		puInfo := policy.NewPUInfo("pu", common.ContainerPU)
+		pu, err := NewPU("pu", puInfo, time.Second)
+		So(err, ShouldBeNil)
+
+		pu.AddRelatedRule("control1", net.ParseIP("52.1.1.1"), 20000, "tcp")
+		pu.AddRelatedRule("control2", net.ParseIP("52.2.2.2"), 30000, "TCP")
+
+		Convey("The related connections should be accepted", func() {
+			plc, err := pu.RelatedACLPolicyFromAddr(net.ParseIP("52.1.1.1"), 20000, "TCP")
+			So(err, ShouldBeNil)
+			So(plc.Action.Accepted(), ShouldBeTrue)
+
+			_, err = pu.RelatedACLPolicyFromAddr(net.ParseIP("52.1.1.1"), 20001, "TCP")
+			So(err, ShouldNotBeNil)
+			_, err = pu.RelatedACLPolicyFromAddr(net.ParseIP("52.1.1.1"), 20000, "UDP")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The rules should be removed with their control connection", func() {
+			pu.RemoveRelatedRules("control1")
+
+			_, err := pu.RelatedACLPolicyFromAddr(net.ParseIP("52.1.1.1"), 20000, "TCP")
+			So(err, ShouldNotBeNil)
+			_, err = pu.RelatedACLPolicyFromAddr(net.ParseIP("52.2.2.2"), 30000, "TCP")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("The rules should expire", func() {
+			pu.relatedRules[dnsRuleKey("52.2.2.2", "30000", "TCP")].expiration = time.Now().Add(-time.Second)
+
+			_, err := pu.RelatedACLPolicyFromAddr(net.ParseIP("52.2.2.2"), 30000, "TCP")
+			So(err, ShouldNotBeNil)
+			So(len(pu.State().RelatedRules), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package pucontext
+
+import (
+	"sync"
+	"time"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// rateLimiterTimeout is the time after which the limiter of an idle source
+// is released.
+const rateLimiterTimeout = time.Minute
+
+// tokenBucket limits the rate of new connections from a single source.
+type tokenBucket struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+	sync.Mutex
+}
+
+// newTokenBucket returns a full bucket for the given limit.
+func newTokenBucket(limit policy.RateLimit) *tokenBucket {
+
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		tokens: burst,
+		rate:   limit.Rate,
+		burst:  burst,
+		last:   time.Now(),
+	}
+}
+
+// allow consumes a token if one is available.
+func (b *tokenBucket) allow(now time.Time) bool {
+
+	b.Lock()
+	defer b.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
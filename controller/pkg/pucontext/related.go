@@ -0,0 +1,87 @@
+package pucontext
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// RelatedRuleLifetime is the time a client has to open a related connection
+// once it is announced on the control connection. The rule is removed
+// earlier if the control connection is closed.
+var RelatedRuleLifetime = 2 * time.Minute
+
+// relatedRule is an application ACL rule that accepts a related connection
+// announced on a control connection, such as the data connection of FTP.
+type relatedRule struct {
+	rule       policy.IPRule
+	owner      string
+	expiration time.Time
+}
+
+// AddRelatedRule accepts the connections of the PU to the address and port
+// until the control connection identified by owner is closed, or
+// RelatedRuleLifetime has passed.
+func (p *PUContext) AddRelatedRule(owner string, addr net.IP, port uint16, protocol string) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	rule := policy.IPRule{
+		Address:  addr.String(),
+		Port:     strconv.Itoa(int(port)),
+		Protocol: strings.ToUpper(protocol),
+		Policy: &policy.FlowPolicy{
+			Action:        policy.Accept,
+			ObserveAction: policy.ObserveNone,
+			ServiceID:     "related",
+			PolicyID:      "related",
+		},
+	}
+
+	p.relatedRules[dnsRuleKey(addr.String(), rule.Port, rule.Protocol)] = &relatedRule{
+		rule:       rule,
+		owner:      owner,
+		expiration: time.Now().Add(RelatedRuleLifetime),
+	}
+}
+
+// RemoveRelatedRules removes the rules of the related connections announced
+// on a control connection.
+func (p *PUContext) RemoveRelatedRules(owner string) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	for key, r := range p.relatedRules {
+		if r.owner == owner {
+			delete(p.relatedRules, key)
+		}
+	}
+}
+
+// RelatedACLPolicyFromAddr returns the policy of the rules of the related
+// connections for a destination. It returns an error if no rule matches.
+func (p *PUContext) RelatedACLPolicyFromAddr(addr net.IP, port uint16, protocol string) (*policy.FlowPolicy, error) {
+
+	key := dnsRuleKey(addr.String(), strconv.Itoa(int(port)), strings.ToUpper(protocol))
+
+	p.Lock()
+	defer p.Unlock()
+
+	r, ok := p.relatedRules[key]
+	if !ok {
+		return nil, fmt.Errorf("no related rule for %s", key)
+	}
+
+	if time.Now().After(r.expiration) {
+		delete(p.relatedRules, key)
+		return nil, fmt.Errorf("related rule for %s expired", key)
+	}
+
+	return r.rule.Policy, nil
+}
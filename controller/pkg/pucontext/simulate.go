@@ -0,0 +1,86 @@
+package pucontext
+
+import (
+	"net"
+	"strings"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// SimulationVerdict is the outcome of a simulated connection.
+type SimulationVerdict string
+
+// Values of SimulationVerdict
+const (
+	// SimulationAccepted means that the connection would be accepted.
+	SimulationAccepted SimulationVerdict = "accepted"
+	// SimulationRejected means that the connection would be rejected.
+	SimulationRejected SimulationVerdict = "rejected"
+	// SimulationObserved means that an observed policy matched the
+	// connection. The action applied to the packets is the action of Policy.
+	SimulationObserved SimulationVerdict = "observed"
+)
+
+// SimulationResult is the result of the simulation of a connection from the PU.
+type SimulationResult struct {
+	// SelectorID is the ID of the tag selector that matched the tags of the
+	// destination. It is empty when no selector matched.
+	SelectorID string
+	// Report is the policy that would be reported for the connection.
+	Report *policy.FlowPolicy
+	// Policy is the policy that would be applied to the packets.
+	Policy *policy.FlowPolicy
+	// ACLMatched is true when an application ACL matched the destination.
+	ACLMatched bool
+	// ACL is the policy of the application ACLs for the destination. It is
+	// nil for protocols other than TCP and for IPv6 destinations, which are
+	// not in the ACL caches.
+	ACL *policy.FlowPolicy
+	// Verdict is the outcome of the connection.
+	Verdict SimulationVerdict
+}
+
+// Simulate returns what would happen to a connection from the PU to
+// dstIP:dstPort with the given protocol, without sending any packet. The tags
+// are the identity of the destination when it is a PU, in which case the
+// transmitter policies are searched as in the handshake. When the tags are
+// nil, the destination is an external network and the application ACLs are
+// used as for external services.
+func (p *PUContext) Simulate(tags *policy.TagStore, dstIP net.IP, dstPort uint16, proto string) SimulationResult {
+
+	result := SimulationResult{}
+
+	if strings.EqualFold(proto, "tcp") && dstIP.To4() != nil {
+		report, action, err := p.ApplicationACLPolicyFromAddr(dstIP.To4(), dstPort, 0)
+		result.ACLMatched = err == nil
+		result.ACL = action
+		if tags == nil {
+			result.Report = report
+			result.Policy = action
+		}
+	}
+
+	if tags != nil {
+		result.Report, result.Policy = p.SearchTxtRules(tags, false)
+		result.SelectorID = result.Policy.SelectorID
+	}
+
+	if result.Policy == nil {
+		result.Policy = &policy.FlowPolicy{
+			Action:   policy.Reject,
+			PolicyID: "default",
+		}
+		result.Report = result.Policy
+	}
+
+	switch {
+	case result.Report.ObserveAction.Observed():
+		result.Verdict = SimulationObserved
+	case result.Policy.Action.Accepted():
+		result.Verdict = SimulationAccepted
+	default:
+		result.Verdict = SimulationRejected
+	}
+
+	return result
+}
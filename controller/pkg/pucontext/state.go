@@ -0,0 +1,136 @@
+package pucontext
+
+import (
+	"sort"
+	"time"
+
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/acls"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// State is what the datapath enforces for a PU. It is exported for
+// debugging, for example in support bundles.
+type State struct {
+	ContextID    string
+	ManagementID string
+	Identity     []string
+	Annotations  []string
+	// ApplicationACLs and NetworkACLs are the compiled ACLs of the PU,
+	// including the rules learned from DNS.
+	ApplicationACLs *acls.ACLDump
+	NetworkACLs     *acls.ACLDump
+	// TargetNetworks is nil if the PU uses the networks of the datapath.
+	TargetNetworks *acls.ACLDump
+	// Transmitter and Receiver are the selectors of the PU policy.
+	Transmitter *RulesState
+	Receiver    *RulesState
+	// DNSRules are the application ACL rules learned from DNS.
+	DNSRules []DNSRuleState
+	// RelatedRules are the application ACL rules of the connections
+	// announced on control connections, such as the data connections of FTP.
+	RelatedRules []RelatedRuleState
+	// DomainRules are the DNS rules of domains, which are matched against
+	// the reverse DNS names of the destinations.
+	DomainRules policy.DNSRuleList
+	// Draining is true if the PU does not accept new connections.
+	Draining bool
+}
+
+// RulesState holds the selectors of the policy DBs of a direction by action.
+type RulesState struct {
+	Reject        policy.TagSelectorList
+	ObserveReject policy.TagSelectorList
+	Accept        policy.TagSelectorList
+	ObserveAccept policy.TagSelectorList
+	ObserveApply  policy.TagSelectorList
+	Encrypt       policy.TagSelectorList
+}
+
+// DNSRuleState is an application ACL rule learned from DNS and the time it
+// expires unless the name is resolved to the same address again.
+type DNSRuleState struct {
+	Rule       policy.IPRule
+	Expiration time.Time
+}
+
+// RelatedRuleState is an application ACL rule of a related connection, the
+// control connection that announced it and the time it expires.
+type RelatedRuleState struct {
+	Rule       policy.IPRule
+	Owner      string
+	Expiration time.Time
+}
+
+// State returns what the datapath enforces for the PU.
+func (p *PUContext) State() *State {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	state := &State{
+		ContextID:       p.id,
+		ManagementID:    p.managementID,
+		ApplicationACLs: p.ApplicationACLs.Dump(),
+		NetworkACLs:     p.networkACLs.Dump(),
+		Transmitter:     p.txt.state(),
+		Receiver:        p.rcv.state(),
+		DNSRules:        []DNSRuleState{},
+		RelatedRules:    []RelatedRuleState{},
+		DomainRules:     append(policy.DNSRuleList{}, p.domainRules...),
+		Draining:        p.draining,
+	}
+
+	if p.identity != nil {
+		state.Identity = p.identity.GetSlice()
+	}
+
+	if p.annotations != nil {
+		state.Annotations = p.annotations.GetSlice()
+	}
+
+	if p.targetNetworks != nil {
+		state.TargetNetworks = p.targetNetworks.Dump()
+	}
+
+	for _, r := range p.dnsRules {
+		state.DNSRules = append(state.DNSRules, DNSRuleState{
+			Rule:       r.rule,
+			Expiration: r.expiration,
+		})
+	}
+	sort.Slice(state.DNSRules, func(i, j int) bool {
+		a, b := state.DNSRules[i].Rule, state.DNSRules[j].Rule
+		return dnsRuleKey(a.Address, a.Port, a.Protocol) < dnsRuleKey(b.Address, b.Port, b.Protocol)
+	})
+
+	for _, r := range p.relatedRules {
+		state.RelatedRules = append(state.RelatedRules, RelatedRuleState{
+			Rule:       r.rule,
+			Owner:      r.owner,
+			Expiration: r.expiration,
+		})
+	}
+	sort.Slice(state.RelatedRules, func(i, j int) bool {
+		a, b := state.RelatedRules[i].Rule, state.RelatedRules[j].Rule
+		return dnsRuleKey(a.Address, a.Port, a.Protocol) < dnsRuleKey(b.Address, b.Port, b.Protocol)
+	})
+
+	return state
+}
+
+// state returns the selectors of the policy DBs.
+func (p *policies) state() *RulesState {
+
+	if p == nil {
+		return nil
+	}
+
+	return &RulesState{
+		Reject:        p.rejectRules.Selectors(),
+		ObserveReject: p.observeRejectRules.Selectors(),
+		Accept:        p.acceptRules.Selectors(),
+		ObserveAccept: p.observeAcceptRules.Selectors(),
+		ObserveApply:  p.observeApplyRules.Selectors(),
+		Encrypt:       p.encryptRules.Selectors(),
+	}
+}
@@ -23,6 +23,20 @@ const (
 	UpdateSecrets = "RemoteEnforcer.UpdateSecrets"
 	// SetTargetNetworks is string for invoking SetTargetNetworks RPC
 	SetTargetNetworks = "RemoteEnforcer.SetTargetNetworks"
+	// SetExcludedPorts is string for invoking SetExcludedPorts RPC
+	SetExcludedPorts = "RemoteEnforcer.SetExcludedPorts"
+	// UpdateAddressSet is string for invoking UpdateAddressSet RPC
+	UpdateAddressSet = "RemoteEnforcer.UpdateAddressSet"
+	// UpdateSupervisorAddressSet is string for invoking UpdateSupervisorAddressSet RPC
+	UpdateSupervisorAddressSet = "RemoteEnforcer.UpdateSupervisorAddressSet"
+	// SupervisorPolicyVersion is string for invoking SupervisorPolicyVersion RPC
+	SupervisorPolicyVersion = "RemoteEnforcer.SupervisorPolicyVersion"
+	// ExportPUState is string for invoking ExportPUState RPC
+	ExportPUState = "RemoteEnforcer.ExportPUState"
+	// DrainPU is string for invoking DrainPU RPC
+	DrainPU = "RemoteEnforcer.DrainPU"
+	// ExportConntrackUpdates is string for invoking ExportConntrackUpdates RPC
+	ExportConntrackUpdates = "RemoteEnforcer.ExportConntrackUpdates"
 )
 
 // RemoteIntf is the interface implemented by the remote enforcer
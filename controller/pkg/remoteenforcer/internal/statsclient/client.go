@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"os"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -11,6 +12,7 @@ import (
 	"go.aporeto.io/trireme-lib/controller/constants"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
 	"go.aporeto.io/trireme-lib/controller/pkg/remoteenforcer/internal/statscollector"
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
 )
 
 const (
@@ -30,6 +32,9 @@ type statsClient struct {
 	statsInterval time.Duration
 	userRetention time.Duration
 	stop          chan bool
+	// key encrypts the stats if not nil.
+	key []byte
+	sync.Mutex
 }
 
 // NewStatsClient initializes a new stats client
@@ -68,15 +73,25 @@ func (s *statsClient) sendStats(ctx context.Context) {
 
 			flows := s.collector.GetAllRecords()
 			users := s.collector.GetUserRecords()
-			if flows == nil && users == nil {
+			dns := s.collector.GetDNSRecords()
+			health := s.collector.GetHealthRecords()
+			if flows == nil && users == nil && dns == nil && health == nil {
+				continue
+			}
+
+			payload, err := s.statsPayload(&rpcwrapper.StatsPayload{
+				Flows:  flows,
+				Users:  users,
+				DNS:    dns,
+				Health: health,
+			})
+			if err != nil {
+				zap.L().Error("Unable to encrypt statistics: Dropping flows", zap.Error(err))
 				continue
 			}
 
 			request := rpcwrapper.Request{
-				Payload: &rpcwrapper.StatsPayload{
-					Flows: flows,
-					Users: users,
-				},
+				Payload: payload,
 			}
 
 			if err := s.rpchdl.RemoteCall(
@@ -96,6 +111,35 @@ func (s *statsClient) sendStats(ctx context.Context) {
 
 }
 
+// statsPayload returns the payload encrypted if a key is set.
+func (s *statsClient) statsPayload(payload *rpcwrapper.StatsPayload) (interface{}, error) {
+
+	s.Lock()
+	key := s.key
+	s.Unlock()
+
+	if key == nil {
+		return payload, nil
+	}
+
+	return rpcwrapper.EncryptStats(key, payload)
+}
+
+// EncryptStats encrypts the stats with a key derived from the secrets.
+func (s *statsClient) EncryptStats(secrets secrets.Secrets) error {
+
+	key, err := rpcwrapper.StatsKey(secrets)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.key = key
+	s.Unlock()
+
+	return nil
+}
+
 // Start This is an private function called by the remoteenforcer to connect back
 // to the controller over a stats channel
 func (s *statsClient) Run(ctx context.Context) error {
@@ -1,10 +1,17 @@
 package statsclient
 
-import "context"
+import (
+	"context"
+
+	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
+)
 
 // StatsClient interface provides functions to start/stop a stats client
 // A stats client is an active component which is responsible for collecting
 // stats events stored by datapath and ship them to the master enforcer.
 type StatsClient interface {
 	Run(ctx context.Context) error
+	// EncryptStats encrypts the stats with a key derived from the secrets.
+	// The stats are sent in clear until it is called.
+	EncryptStats(s secrets.Secrets) error
 }
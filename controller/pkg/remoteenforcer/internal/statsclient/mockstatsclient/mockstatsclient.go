@@ -9,6 +9,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	secrets "go.aporeto.io/trireme-lib/controller/pkg/secrets"
 )
 
 // MockStatsClient is a mock of StatsClient interface
@@ -51,3 +52,17 @@ func (m *MockStatsClient) Run(ctx context.Context) error {
 func (mr *MockStatsClientMockRecorder) Run(ctx interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockStatsClient)(nil).Run), ctx)
 }
+
+// EncryptStats mocks base method
+// nolint
+func (m *MockStatsClient) EncryptStats(s secrets.Secrets) error {
+	ret := m.ctrl.Call(m, "EncryptStats", s)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EncryptStats indicates an expected call of EncryptStats
+// nolint
+func (mr *MockStatsClientMockRecorder) EncryptStats(s interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EncryptStats", reflect.TypeOf((*MockStatsClient)(nil).EncryptStats), s)
+}
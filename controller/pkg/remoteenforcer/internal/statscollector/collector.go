@@ -8,13 +8,31 @@ import (
 
 // NewCollector provides a new collector interface
 func NewCollector() Collector {
+	return NewCollectorWithFlowHash(collector.StatsFlowHash)
+}
+
+// NewCollectorWithFlowHash provides a new collector interface that aggregates
+// the flows of the same flowHash key.
+func NewCollectorWithFlowHash(flowHash collector.FlowHashFunc) Collector {
 	return &collectorImpl{
+		flowHash:       flowHash,
 		Flows:          map[string]*collector.FlowRecord{},
 		Users:          map[string]*collector.UserRecord{},
 		ProcessedUsers: map[string]bool{},
+		DNS:            map[string]*collector.DNSRecord{},
 	}
 }
 
+// SetFlowHash sets the function that keys the flows that are aggregated.
+// The flows collected before are kept with their keys.
+func (c *collectorImpl) SetFlowHash(flowHash collector.FlowHashFunc) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.flowHash = flowHash
+}
+
 // collectorImpl : This object is a stash implements two interfaces.
 //
 //  collector.EventCollector - so datapath can report flow events
@@ -26,5 +44,8 @@ type collectorImpl struct {
 	Flows          map[string]*collector.FlowRecord
 	ProcessedUsers map[string]bool
 	Users          map[string]*collector.UserRecord
+	DNS            map[string]*collector.DNSRecord
+	Health         []*collector.HealthRecord
+	flowHash       collector.FlowHashFunc
 	sync.Mutex
 }
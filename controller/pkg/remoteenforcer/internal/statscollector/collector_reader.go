@@ -38,6 +38,35 @@ func (c *collectorImpl) GetUserRecords() map[string]*collector.UserRecord {
 	return retval
 }
 
+// GetDNSRecords retrieves the DNS records of every PU.
+func (c *collectorImpl) GetDNSRecords() map[string]*collector.DNSRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.DNS) == 0 {
+		return nil
+	}
+
+	retval := c.DNS
+	c.DNS = map[string]*collector.DNSRecord{}
+	return retval
+}
+
+// GetHealthRecords retrieves the health events in the order they were
+// collected.
+func (c *collectorImpl) GetHealthRecords() []*collector.HealthRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.Health) == 0 {
+		return nil
+	}
+
+	retval := c.Health
+	c.Health = nil
+	return retval
+}
+
 // FlushUserCache flushes the user cache.
 func (c *collectorImpl) FlushUserCache() {
 	c.Lock()
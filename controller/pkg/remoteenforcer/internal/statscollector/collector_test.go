@@ -106,3 +106,248 @@ func TestCollectFlowEvent(t *testing.T) {
 		})
 	})
 }
+
+func TestCollectFlowEventReasons(t *testing.T) {
+	Convey("Given a stats collector", t, func() {
+		c := &collectorImpl{
+			Flows: map[string]*collector.FlowRecord{},
+		}
+
+		flow := func(action policy.ActionType, reason string, count int) *collector.FlowRecord {
+			return &collector.FlowRecord{
+				ContextID: "1",
+				Source: &collector.EndPoint{
+					ID:   "A",
+					IP:   "1.1.1.1",
+					Type: collector.EnpointTypePU,
+				},
+				Destination: &collector.EndPoint{
+					ID:   "B",
+					IP:   "2.2.2.2",
+					Type: collector.EnpointTypePU,
+					Port: 80,
+				},
+				Count:      count,
+				Tags:       policy.NewTagStore(),
+				Action:     action,
+				DropReason: reason,
+				L4Protocol: packet.IPProtocolTCP,
+			}
+		}
+
+		Convey("When I add flows of the same tuple dropped for different reasons", func() {
+			invalidToken := flow(policy.Reject, collector.InvalidToken, 2)
+			policyDrop := flow(policy.Reject, collector.PolicyDrop, 3)
+			c.CollectFlowEvent(invalidToken)
+			c.CollectFlowEvent(policyDrop)
+			c.CollectFlowEvent(flow(policy.Reject, collector.PolicyDrop, 4))
+
+			Convey("The flows should be aggregated per reason", func() {
+				So(len(c.Flows), ShouldEqual, 2)
+				So(c.Flows[collector.StatsFlowHash(invalidToken)].DropReason, ShouldEqual, collector.InvalidToken)
+				So(c.Flows[collector.StatsFlowHash(invalidToken)].Count, ShouldEqual, 2)
+				So(c.Flows[collector.StatsFlowHash(policyDrop)].DropReason, ShouldEqual, collector.PolicyDrop)
+				So(c.Flows[collector.StatsFlowHash(policyDrop)].Count, ShouldEqual, 7)
+			})
+		})
+
+		Convey("When I add flows of the same tuple accepted with and without the mutual authorization", func() {
+			relaxed := flow(policy.Accept, "", 1)
+			relaxed.RelaxedMutualAuth = true
+			c.CollectFlowEvent(flow(policy.Accept, "", 1))
+			c.CollectFlowEvent(relaxed)
+
+			Convey("The flows should be aggregated separately", func() {
+				So(len(c.Flows), ShouldEqual, 2)
+				So(c.Flows[collector.StatsFlowHash(relaxed)].RelaxedMutualAuth, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I add flows whose handshake timed out, the dropped packets should be added up", func() {
+			r1 := flow(policy.Reject, collector.HandshakeTimeout, 1)
+			r1.DroppedPackets = 2
+			r1.DroppedBytes = 200
+			r2 := flow(policy.Reject, collector.HandshakeTimeout, 1)
+			r2.DroppedPackets = 1
+			r2.DroppedBytes = 50
+			c.CollectFlowEvent(r1)
+			c.CollectFlowEvent(r2)
+
+			So(len(c.Flows), ShouldEqual, 1)
+			So(c.Flows[collector.StatsFlowHash(r1)].Count, ShouldEqual, 2)
+			So(c.Flows[collector.StatsFlowHash(r1)].DroppedPackets, ShouldEqual, 3)
+			So(c.Flows[collector.StatsFlowHash(r1)].DroppedBytes, ShouldEqual, 250)
+		})
+
+		Convey("When I add the bytes of a flow, they should be added up without counting the flow again", func() {
+			r1 := flow(policy.Accept, "", 1)
+			r1.BytesSent = 100
+			r1.BytesReceived = 10
+			r2 := flow(policy.Accept, "", 0)
+			r2.BytesSent = 200
+			r2.BytesReceived = 20
+			r3 := flow(policy.Accept, "", 0)
+			r3.BytesReceived = 30
+			c.CollectFlowEvent(r1)
+			c.CollectFlowEvent(r2)
+			c.CollectFlowEvent(r3)
+
+			So(len(c.Flows), ShouldEqual, 1)
+			So(c.Flows[collector.StatsFlowHash(r1)].Count, ShouldEqual, 1)
+			So(c.Flows[collector.StatsFlowHash(r1)].BytesSent, ShouldEqual, 300)
+			So(c.Flows[collector.StatsFlowHash(r1)].BytesReceived, ShouldEqual, 60)
+		})
+
+		Convey("When the reason and the uri of two flows have the same concatenation", func() {
+			r1 := flow(policy.Reject, collector.PolicyDrop, 1)
+			r1.Destination.URI = "/api"
+			r2 := flow(policy.Reject, collector.PolicyDrop+"/api", 1)
+
+			Convey("They should not have the same hash", func() {
+				So(collector.StatsFlowHash(r1), ShouldNotEqual, collector.StatsFlowHash(r2))
+			})
+		})
+	})
+}
+
+func TestCollectFlowEventWithFlowHash(t *testing.T) {
+	Convey("Given a flow", t, func() {
+		flow := func(sourceIP string, sourcePort uint16, count int) *collector.FlowRecord {
+			return &collector.FlowRecord{
+				ContextID: "1",
+				Source: &collector.EndPoint{
+					ID:   "A",
+					IP:   sourceIP,
+					Port: sourcePort,
+					Type: collector.EnpointTypePU,
+				},
+				Destination: &collector.EndPoint{
+					ID:   "B",
+					IP:   "2.2.2.2",
+					Type: collector.EnpointTypePU,
+					Port: 80,
+				},
+				Count:      count,
+				Tags:       policy.NewTagStore(),
+				Action:     policy.Accept,
+				L4Protocol: packet.IPProtocolUDP,
+			}
+		}
+
+		Convey("The built-in flow hashes should be selected by name", func() {
+			for _, name := range []string{"", collector.FlowHashDefault, collector.FlowHashCollapseSourcePort} {
+				flowHash, err := collector.FlowHashByName(name)
+				So(err, ShouldBeNil)
+				So(flowHash, ShouldNotBeNil)
+			}
+			r := flow("1.1.1.1", 1000, 1)
+			flowHash, _ := collector.FlowHashByName("")
+			So(flowHash(r), ShouldEqual, collector.StatsFlowHash(r))
+			flowHash, _ = collector.FlowHashByName(collector.FlowHashCollapseSourcePort)
+			So(flowHash(r), ShouldEqual, collector.CollapseSourcePortFlowHash(r))
+
+			_, err := collector.FlowHashByName("unknown")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I add flows to a collector with the default flow hash", func() {
+			c := NewCollector().(*collectorImpl)
+			c.CollectFlowEvent(flow("1.1.1.1", 1000, 1))
+			c.CollectFlowEvent(flow("1.1.1.1", 1001, 2))
+			c.CollectFlowEvent(flow("3.3.3.3", 1002, 3))
+
+			Convey("The flows of the PUs should be aggregated", func() {
+				So(len(c.Flows), ShouldEqual, 1)
+				r := c.Flows[collector.StatsFlowHash(flow("1.1.1.1", 1000, 1))]
+				So(r.Count, ShouldEqual, 6)
+				So(r.Source.Port, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I add flows to a collector that collapses the source port", func() {
+			c := NewCollectorWithFlowHash(collector.CollapseSourcePortFlowHash).(*collectorImpl)
+			c.CollectFlowEvent(flow("1.1.1.1", 1000, 1))
+			c.CollectFlowEvent(flow("1.1.1.1", 1001, 2))
+			c.CollectFlowEvent(flow("3.3.3.3", 1002, 3))
+			c.CollectFlowEvent(flow("3.3.3.3", 1002, 0))
+
+			Convey("The flows should be aggregated per address", func() {
+				So(len(c.Flows), ShouldEqual, 2)
+
+				r := c.Flows[collector.CollapseSourcePortFlowHash(flow("1.1.1.1", 1000, 1))]
+				So(r.Count, ShouldEqual, 3)
+				So(r.Source.Port, ShouldEqual, 0)
+
+				r = c.Flows[collector.CollapseSourcePortFlowHash(flow("3.3.3.3", 1002, 1))]
+				So(r.Count, ShouldEqual, 4)
+				So(r.Source.Port, ShouldEqual, 1002)
+			})
+		})
+
+		Convey("When I set the flow hash of a collector, the flows should be aggregated with it", func() {
+			c := NewCollector().(*collectorImpl)
+			c.SetFlowHash(collector.CollapseSourcePortFlowHash)
+			c.CollectFlowEvent(flow("1.1.1.1", 1000, 1))
+			c.CollectFlowEvent(flow("1.1.1.1", 1001, 2))
+
+			So(len(c.Flows), ShouldEqual, 1)
+			So(c.Flows[collector.CollapseSourcePortFlowHash(flow("1.1.1.1", 1000, 1))].Count, ShouldEqual, 3)
+		})
+
+		Convey("The flows of different protocols should not have the same collapsed hash", func() {
+			tcp := flow("1.1.1.1", 1000, 1)
+			tcp.L4Protocol = packet.IPProtocolTCP
+			So(collector.CollapseSourcePortFlowHash(tcp), ShouldNotEqual, collector.CollapseSourcePortFlowHash(flow("1.1.1.1", 1000, 1)))
+		})
+	})
+}
+
+func TestCollectDNSEvent(t *testing.T) {
+	Convey("Given a stats collector", t, func() {
+		c := NewCollector()
+
+		Convey("When I add DNS events of two PUs", func() {
+			c.CollectDNSEvent(&collector.DNSRecord{ContextID: "1", Lookups: 2, RulesAdded: 3})
+			c.CollectDNSEvent(&collector.DNSRecord{ContextID: "1", Lookups: 1, Unresolved: 1, RulesExpired: 2})
+			c.CollectDNSEvent(&collector.DNSRecord{ContextID: "2", Lookups: 1})
+
+			Convey("The events of each PU should be added up", func() {
+				records := c.GetDNSRecords()
+				So(len(records), ShouldEqual, 2)
+				So(records["1"], ShouldResemble, &collector.DNSRecord{
+					ContextID:    "1",
+					Lookups:      3,
+					Unresolved:   1,
+					RulesAdded:   3,
+					RulesExpired: 2,
+				})
+				So(records["2"].Lookups, ShouldEqual, 1)
+
+				Convey("The records should only be returned once", func() {
+					So(c.GetDNSRecords(), ShouldBeNil)
+				})
+			})
+		})
+	})
+}
+
+func TestCollectHealthEvent(t *testing.T) {
+	Convey("Given a stats collector", t, func() {
+		c := NewCollector()
+
+		Convey("When I add two health events", func() {
+			lost := &collector.HealthRecord{Component: "nfqueue", Critical: true, Message: "lost"}
+			recovered := &collector.HealthRecord{Component: "nfqueue", Message: "recovered"}
+			c.CollectHealthEvent(lost)
+			c.CollectHealthEvent(recovered)
+
+			Convey("The events should be returned in order", func() {
+				So(c.GetHealthRecords(), ShouldResemble, []*collector.HealthRecord{lost, recovered})
+
+				Convey("The records should only be returned once", func() {
+					So(c.GetHealthRecords(), ShouldBeNil)
+				})
+			})
+		})
+	})
+}
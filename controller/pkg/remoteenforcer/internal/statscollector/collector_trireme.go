@@ -8,18 +8,32 @@ import (
 // CollectFlowEvent collects a new flow event and adds it to a local list it shares with SendStats
 func (c *collectorImpl) CollectFlowEvent(record *collector.FlowRecord) {
 
-	hash := collector.StatsFlowHash(record)
-
-	// If flow event doesn't have a count make it equal to 1. At least one flow is collected
-	if record.Count == 0 {
+	// If flow event doesn't have a count make it equal to 1. At least one flow is collected,
+	// unless the event only reports the bytes of a flow.
+	if record.Count == 0 && record.BytesSent == 0 && record.BytesReceived == 0 {
 		record.Count = 1
 	}
 
 	c.Lock()
 	defer c.Unlock()
 
+	flowHash := c.flowHash
+	if flowHash == nil {
+		flowHash = collector.StatsFlowHash
+	}
+	hash := flowHash(record)
+
 	if r, ok := c.Flows[hash]; ok {
 		r.Count = r.Count + record.Count
+		r.DroppedPackets = r.DroppedPackets + record.DroppedPackets
+		r.DroppedBytes = r.DroppedBytes + record.DroppedBytes
+		r.BytesSent = r.BytesSent + record.BytesSent
+		r.BytesReceived = r.BytesReceived + record.BytesReceived
+		// The source port is not reported when the aggregated flows
+		// don't have the same.
+		if r.Source.Port != record.Source.Port {
+			r.Source.Port = 0
+		}
 		return
 	}
 
@@ -33,6 +47,40 @@ func (c *collectorImpl) CollectContainerEvent(record *collector.ContainerRecord)
 	zap.L().Error("Unexpected call for collecting container event")
 }
 
+// CollectAllocatorEvent is called when allocator events are received
+func (c *collectorImpl) CollectAllocatorEvent(record *collector.AllocatorRecord) {
+	zap.L().Error("Unexpected call for collecting allocator event")
+}
+
+// CollectDNSEvent adds the DNS activity of a PU to the activity that has not
+// been reported yet.
+func (c *collectorImpl) CollectDNSEvent(record *collector.DNSRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	r, ok := c.DNS[record.ContextID]
+	if !ok {
+		c.DNS[record.ContextID] = record
+		return
+	}
+
+	r.Lookups += record.Lookups
+	r.Unresolved += record.Unresolved
+	r.RulesAdded += record.RulesAdded
+	r.RulesExpired += record.RulesExpired
+}
+
+// CollectHealthEvent adds a health event to the events that have not been
+// reported yet.
+func (c *collectorImpl) CollectHealthEvent(record *collector.HealthRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.Health = append(c.Health, record)
+}
+
 // CollectUserEvent collects a new user event and adds it to a local cache.
 func (c *collectorImpl) CollectUserEvent(record *collector.UserRecord) {
 	if err := collector.StatsUserHash(record); err != nil {
@@ -9,6 +9,8 @@ type CollectorReader interface {
 	Count() int
 	GetAllRecords() map[string]*collector.FlowRecord
 	GetUserRecords() map[string]*collector.UserRecord
+	GetDNSRecords() map[string]*collector.DNSRecord
+	GetHealthRecords() []*collector.HealthRecord
 	FlushUserCache()
 }
 
@@ -16,4 +18,7 @@ type CollectorReader interface {
 type Collector interface {
 	CollectorReader
 	collector.EventCollector
+
+	// SetFlowHash sets the function that keys the flows that are aggregated.
+	SetFlowHash(flowHash collector.FlowHashFunc)
 }
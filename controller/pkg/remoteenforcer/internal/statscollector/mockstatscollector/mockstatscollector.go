@@ -80,6 +80,34 @@ func (mr *MockCollectorReaderMockRecorder) GetUserRecords() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserRecords", reflect.TypeOf((*MockCollectorReader)(nil).GetUserRecords))
 }
 
+// GetDNSRecords mocks base method
+// nolint
+func (m *MockCollectorReader) GetDNSRecords() map[string]*collector.DNSRecord {
+	ret := m.ctrl.Call(m, "GetDNSRecords")
+	ret0, _ := ret[0].(map[string]*collector.DNSRecord)
+	return ret0
+}
+
+// GetDNSRecords indicates an expected call of GetDNSRecords
+// nolint
+func (mr *MockCollectorReaderMockRecorder) GetDNSRecords() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDNSRecords", reflect.TypeOf((*MockCollectorReader)(nil).GetDNSRecords))
+}
+
+// GetHealthRecords mocks base method
+// nolint
+func (m *MockCollectorReader) GetHealthRecords() []*collector.HealthRecord {
+	ret := m.ctrl.Call(m, "GetHealthRecords")
+	ret0, _ := ret[0].([]*collector.HealthRecord)
+	return ret0
+}
+
+// GetHealthRecords indicates an expected call of GetHealthRecords
+// nolint
+func (mr *MockCollectorReaderMockRecorder) GetHealthRecords() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHealthRecords", reflect.TypeOf((*MockCollectorReader)(nil).GetHealthRecords))
+}
+
 // FlushUserCache mocks base method
 // nolint
 func (m *MockCollectorReader) FlushUserCache() {
@@ -161,6 +189,46 @@ func (mr *MockCollectorMockRecorder) GetUserRecords() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserRecords", reflect.TypeOf((*MockCollector)(nil).GetUserRecords))
 }
 
+// GetDNSRecords mocks base method
+// nolint
+func (m *MockCollector) GetDNSRecords() map[string]*collector.DNSRecord {
+	ret := m.ctrl.Call(m, "GetDNSRecords")
+	ret0, _ := ret[0].(map[string]*collector.DNSRecord)
+	return ret0
+}
+
+// GetDNSRecords indicates an expected call of GetDNSRecords
+// nolint
+func (mr *MockCollectorMockRecorder) GetDNSRecords() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDNSRecords", reflect.TypeOf((*MockCollector)(nil).GetDNSRecords))
+}
+
+// SetFlowHash mocks base method
+// nolint
+func (m *MockCollector) SetFlowHash(flowHash collector.FlowHashFunc) {
+	m.ctrl.Call(m, "SetFlowHash", flowHash)
+}
+
+// SetFlowHash indicates an expected call of SetFlowHash
+// nolint
+func (mr *MockCollectorMockRecorder) SetFlowHash(flowHash interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFlowHash", reflect.TypeOf((*MockCollector)(nil).SetFlowHash), flowHash)
+}
+
+// GetHealthRecords mocks base method
+// nolint
+func (m *MockCollector) GetHealthRecords() []*collector.HealthRecord {
+	ret := m.ctrl.Call(m, "GetHealthRecords")
+	ret0, _ := ret[0].([]*collector.HealthRecord)
+	return ret0
+}
+
+// GetHealthRecords indicates an expected call of GetHealthRecords
+// nolint
+func (mr *MockCollectorMockRecorder) GetHealthRecords() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHealthRecords", reflect.TypeOf((*MockCollector)(nil).GetHealthRecords))
+}
+
 // FlushUserCache mocks base method
 // nolint
 func (m *MockCollector) FlushUserCache() {
@@ -208,3 +276,39 @@ func (m *MockCollector) CollectUserEvent(record *collector.UserRecord) {
 func (mr *MockCollectorMockRecorder) CollectUserEvent(record interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectUserEvent", reflect.TypeOf((*MockCollector)(nil).CollectUserEvent), record)
 }
+
+// CollectAllocatorEvent mocks base method
+// nolint
+func (m *MockCollector) CollectAllocatorEvent(record *collector.AllocatorRecord) {
+	m.ctrl.Call(m, "CollectAllocatorEvent", record)
+}
+
+// CollectAllocatorEvent indicates an expected call of CollectAllocatorEvent
+// nolint
+func (mr *MockCollectorMockRecorder) CollectAllocatorEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectAllocatorEvent", reflect.TypeOf((*MockCollector)(nil).CollectAllocatorEvent), record)
+}
+
+// CollectDNSEvent mocks base method
+// nolint
+func (m *MockCollector) CollectDNSEvent(record *collector.DNSRecord) {
+	m.ctrl.Call(m, "CollectDNSEvent", record)
+}
+
+// CollectDNSEvent indicates an expected call of CollectDNSEvent
+// nolint
+func (mr *MockCollectorMockRecorder) CollectDNSEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectDNSEvent", reflect.TypeOf((*MockCollector)(nil).CollectDNSEvent), record)
+}
+
+// CollectHealthEvent mocks base method
+// nolint
+func (m *MockCollector) CollectHealthEvent(record *collector.HealthRecord) {
+	m.ctrl.Call(m, "CollectHealthEvent", record)
+}
+
+// CollectHealthEvent indicates an expected call of CollectHealthEvent
+// nolint
+func (mr *MockCollectorMockRecorder) CollectHealthEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectHealthEvent", reflect.TypeOf((*MockCollector)(nil).CollectHealthEvent), record)
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,6 +22,7 @@ import (
 
 	_ "go.aporeto.io/trireme-lib/controller/internal/enforcer/utils/nsenter" // nolint
 
+	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/controller/constants"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer"
 	"go.aporeto.io/trireme-lib/controller/internal/enforcer/utils/rpcwrapper"
@@ -48,10 +50,10 @@ func newServer(
 	statsClient statsclient.StatsClient,
 ) (s RemoteIntf, err error) {
 
-	var collector statscollector.Collector
+	var statsCollector statscollector.Collector
 	if statsClient == nil {
-		collector = statscollector.NewCollector()
-		statsClient, err = statsclient.NewStatsClient(collector)
+		statsCollector = statscollector.NewCollector()
+		statsClient, err = statsclient.NewStatsClient(statsCollector)
 		if err != nil {
 			return nil, err
 		}
@@ -63,7 +65,7 @@ func newServer(
 	}
 
 	return &RemoteEnforcer{
-		collector:      collector,
+		collector:      statsCollector,
 		service:        service,
 		rpcChannel:     rpcChannel,
 		rpcSecret:      secret,
@@ -109,6 +111,7 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) error {
 		s.secrets,
 		payload.ServerID,
 		payload.Validity,
+		payload.ClockSkew,
 		constants.RemoteContainer,
 		s.procMountPoint,
 		payload.ExternalIPCacheTimeout,
@@ -118,6 +121,34 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) error {
 		return fmt.Errorf("Error while initializing remote enforcer, %s", err)
 	}
 
+	if len(payload.ExcludedPorts) > 0 {
+		if err := s.enforcer.SetExcludedPorts(payload.ExcludedPorts, payload.ReportExcludedPorts); err != nil {
+			return fmt.Errorf("Error while initializing remote enforcer, %s", err)
+		}
+	}
+
+	if i, ok := s.enforcer.(enforcer.ImplicitAllowSetter); ok && len(payload.ImplicitAllowNetworks) > 0 {
+		if err := i.SetImplicitAllowNetworks(payload.ImplicitAllowNetworks); err != nil {
+			return fmt.Errorf("Error while initializing remote enforcer, %s", err)
+		}
+	}
+
+	if payload.StatsFlowHash != "" && s.collector != nil {
+		flowHash, err := collector.FlowHashByName(payload.StatsFlowHash)
+		if err != nil {
+			return fmt.Errorf("Error while initializing remote enforcer, %s", err)
+		}
+		s.collector.SetFlowHash(flowHash)
+	}
+
+	for name, addresses := range payload.AddressSets {
+		if err := s.enforcer.UpdateAddressSet(name, addresses); err != nil {
+			return fmt.Errorf("Error while initializing remote enforcer, %s", err)
+		}
+	}
+
+	s.encryptStats = payload.EncryptStats
+
 	return nil
 }
 
@@ -177,6 +208,8 @@ func (s *RemoteEnforcer) InitEnforcer(req rpcwrapper.Request, resp *rpcwrapper.R
 	cmdLock.Lock()
 	defer cmdLock.Unlock()
 
+	payload := req.Payload.(rpcwrapper.InitRequestPayload)
+
 	if err := s.setupEnforcer(req); err != nil {
 		resp.Status = err.Error()
 		return fmt.Errorf(resp.Status)
@@ -187,6 +220,59 @@ func (s *RemoteEnforcer) InitEnforcer(req rpcwrapper.Request, resp *rpcwrapper.R
 		return fmt.Errorf(resp.Status)
 	}
 
+	if payload.UDPStateDir != "" {
+		if p, ok := s.enforcer.(enforcer.UDPConnectionPersister); ok {
+			path := filepath.Join(payload.UDPStateDir, filepath.Base(os.Getenv(constants.EnvContextSocket))+".udp")
+			if err := p.PersistUDPConnections(s.ctx, path); err != nil {
+				zap.L().Warn("Unable to persist udp connections", zap.String("path", path), zap.Error(err))
+			}
+		}
+	}
+
+	if payload.AuditDir != "" {
+		if a, ok := s.enforcer.(enforcer.PolicyAuditor); ok {
+			path := filepath.Join(payload.AuditDir, filepath.Base(os.Getenv(constants.EnvContextSocket))+".audit")
+			if err := a.AuditPolicyDecisions(s.ctx, path); err != nil {
+				zap.L().Warn("Unable to audit policy decisions", zap.String("path", path), zap.Error(err))
+			}
+		}
+	}
+
+	if r, ok := s.enforcer.(enforcer.ReverseDNSEnricher); ok && payload.ReverseDNS {
+		r.EnableReverseDNS(payload.ReverseDNSPolicy)
+	}
+
+	if len(payload.ProtocolHelpers) > 0 {
+		if h, ok := s.enforcer.(enforcer.ProtocolHelperEnabler); ok {
+			if err := h.EnableProtocolHelpers(payload.ProtocolHelpers); err != nil {
+				zap.L().Warn("Unable to enable protocol helpers", zap.Strings("helpers", payload.ProtocolHelpers), zap.Error(err))
+			}
+		}
+	}
+
+	if i, ok := s.enforcer.(enforcer.ServerNameInspector); ok && payload.ServerNameInspection {
+		i.EnableServerNameInspection()
+	}
+
+	if l, ok := s.enforcer.(enforcer.UDPHandshakeLimiter); ok && payload.UDPHandshakeLimits != nil {
+		l.SetUDPHandshakeLimits(payload.UDPHandshakeLimits.Total, payload.UDPHandshakeLimits.PerPU)
+	}
+
+	if t, ok := s.enforcer.(enforcer.UDPHandshakeTimeoutSetter); ok && payload.UDPHandshakeTimeout != nil {
+		t.SetUDPHandshakeTimeout(*payload.UDPHandshakeTimeout)
+	}
+
+	if k, ok := s.enforcer.(enforcer.UDPKeyRotationSetter); ok && payload.UDPKeyRotationInterval > 0 {
+		k.SetUDPKeyRotationInterval(payload.UDPKeyRotationInterval)
+	}
+
+	if s.encryptStats {
+		if err := s.statsClient.EncryptStats(s.secrets); err != nil {
+			resp.Status = fmt.Sprintf("unable to encrypt stats: %s", err)
+			return fmt.Errorf(resp.Status)
+		}
+	}
+
 	if err := s.statsClient.Run(s.ctx); err != nil {
 		resp.Status = err.Error()
 		return fmt.Errorf(resp.Status)
@@ -217,6 +303,9 @@ func (s *RemoteEnforcer) InitSupervisor(req rpcwrapper.Request, resp *rpcwrapper
 			s.enforcer,
 			constants.RemoteContainer,
 			payload.TriremeNetworks,
+			// The remote enforcer runs in the network namespace of the
+			// container, where all the interfaces are enforced.
+			nil,
 			s.service,
 		)
 		if err != nil {
@@ -234,6 +323,16 @@ func (s *RemoteEnforcer) InitSupervisor(req rpcwrapper.Request, resp *rpcwrapper
 		}
 	}
 
+	if err := s.supervisor.SetExcludedPorts(payload.ExcludedPorts); err != nil {
+		zap.L().Error("unable to set excluded ports", zap.Error(err))
+	}
+
+	for name, addresses := range payload.AddressSets {
+		if err := s.supervisor.UpdateAddressSet(name, addresses); err != nil {
+			zap.L().Error("unable to set address set", zap.String("addressSet", name), zap.Error(err))
+		}
+	}
+
 	resp.Status = ""
 
 	return nil
@@ -327,6 +426,177 @@ func (s *RemoteEnforcer) SetTargetNetworks(req rpcwrapper.Request, resp *rpcwrap
 
 }
 
+// SetExcludedPorts calls the same method on the actual enforcer
+func (s *RemoteEnforcer) SetExcludedPorts(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "SetExcludedPorts message auth failed" //nolint
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+	if s.enforcer == nil {
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.SetExcludedPorts)
+	return s.enforcer.SetExcludedPorts(payload.Ports, payload.Report)
+}
+
+// UpdateAddressSet updates or removes the address set in the actual enforcer
+func (s *RemoteEnforcer) UpdateAddressSet(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "UpdateAddressSet message auth failed" //nolint
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+	if s.enforcer == nil {
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.AddressSetPayload)
+	if payload.Remove {
+		return s.enforcer.RemoveAddressSet(payload.Name)
+	}
+
+	return s.enforcer.UpdateAddressSet(payload.Name, payload.Addresses)
+}
+
+// UpdateSupervisorAddressSet updates or removes the address set in the
+// actual supervisor
+func (s *RemoteEnforcer) UpdateSupervisorAddressSet(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "UpdateSupervisorAddressSet message auth failed" //nolint
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+	if s.supervisor == nil {
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.AddressSetPayload)
+	if payload.Remove {
+		return s.supervisor.RemoveAddressSet(payload.Name)
+	}
+
+	return s.supervisor.UpdateAddressSet(payload.Name, payload.Addresses)
+}
+
+// SupervisorPolicyVersion returns the version of the policy of the PU in the
+// actual supervisor
+func (s *RemoteEnforcer) SupervisorPolicyVersion(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "SupervisorPolicyVersion message auth failed" //nolint
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+	if s.supervisor == nil {
+		resp.Status = "supervisor not initialized"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.PolicyVersionPayload)
+	version, err := s.supervisor.PolicyVersion(payload.ContextID)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = version
+	return nil
+}
+
+// ExportPUState returns the state of the PU in the actual enforcer
+func (s *RemoteEnforcer) ExportPUState(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "ExportPUState message auth failed" //nolint
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	exporter, ok := s.enforcer.(enforcer.PUStateExporter)
+	if !ok {
+		resp.Status = "enforcer cannot export pu state"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.ExportPUStatePayload)
+	state, err := exporter.ExportPUState(payload.ContextID)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = state
+	return nil
+}
+
+// ExportConntrackUpdates returns the conntrack mark updates of the actual enforcer
+func (s *RemoteEnforcer) ExportConntrackUpdates(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "ExportConntrackUpdates message auth failed" //nolint
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	exporter, ok := s.enforcer.(enforcer.ConntrackExporter)
+	if !ok {
+		resp.Status = "enforcer cannot export conntrack updates"
+		return fmt.Errorf(resp.Status)
+	}
+
+	updates, err := exporter.ExportConntrackUpdates("")
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = updates
+	return nil
+}
+
+// DrainPU drains the PU in the actual enforcer
+func (s *RemoteEnforcer) DrainPU(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "DrainPU message auth failed" //nolint
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	drainer, ok := s.enforcer.(enforcer.PUDrainer)
+	if !ok {
+		resp.Status = "enforcer cannot drain pu"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.DrainPUPayload)
+	if err := drainer.DrainPU(payload.ContextID); err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	return nil
+}
+
 // Enforce this method calls the enforce method on the enforcer created during initenforcer
 func (s *RemoteEnforcer) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
@@ -398,6 +668,11 @@ func (s *RemoteEnforcer) UpdateSecrets(req rpcwrapper.Request, resp *rpcwrapper.
 	if err != nil {
 		return err
 	}
+
+	if s.encryptStats {
+		return s.statsClient.EncryptStats(s.secrets)
+	}
+
 	return nil
 }
 
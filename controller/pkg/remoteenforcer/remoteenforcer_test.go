@@ -210,7 +210,7 @@ func initTestSupPayload() rpcwrapper.SuperviseRequestPayload {
 		Identity:         initIdentity(idString),
 		TransmitterRules: initTrans(),
 		Annotations:      initAnnotations(anoString),
-		TriremeNetworks:  []string{"127.0.0.1/32 172.0.0.0/8 10.0.0.0/8"},
+		TriremeNetworks:  []string{"127.0.0.1/32", "172.0.0.0/8", "10.0.0.0/8"},
 	}
 
 	return initPayload
@@ -230,7 +230,7 @@ func initTestEnfPayload() rpcwrapper.EnforcePayload {
 		Identity:         initIdentity(idString),
 		Annotations:      initAnnotations(anoString),
 		TransmitterRules: initTrans(),
-		TriremeNetworks:  []string{"127.0.0.1/32 172.0.0.0/8 10.0.0.0/8"},
+		TriremeNetworks:  []string{"127.0.0.1/32", "172.0.0.0/8", "10.0.0.0/8"},
 	}
 
 	return initPayload
@@ -561,7 +561,7 @@ func TestInitSupervisor(t *testing.T) {
 				}
 
 				server.enforcer = enforcer.NewWithDefaults("someServerID", collector, nil, secret, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"}).(enforcer.Enforcer)
-				server.supervisor, _ = supervisor.NewSupervisor(collector, server.enforcer, constants.RemoteContainer, []string{}, nil)
+				server.supervisor, _ = supervisor.NewSupervisor(collector, server.enforcer, constants.RemoteContainer, []string{}, nil, nil)
 
 				err := server.InitSupervisor(rpcwrperreq, &rpcwrperres)
 
@@ -658,7 +658,7 @@ func TestLaunchRemoteEnforcer(t *testing.T) {
 				}
 
 				e := enforcer.NewWithDefaults("serverID", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
-				server.supervisor, _ = supervisor.NewSupervisor(c, e, constants.RemoteContainer, []string{}, nil)
+				server.supervisor, _ = supervisor.NewSupervisor(c, e, constants.RemoteContainer, []string{}, nil, nil)
 				server.enforcer = nil
 				err := server.EnforcerExit(rpcwrapper.Request{}, &rpcwrapper.Response{})
 
@@ -1080,7 +1080,7 @@ func TestUnSupervise(t *testing.T) {
 
 				e := enforcer.NewWithDefaults("ac0d3577e808", c, nil, scrts, constants.RemoteContainer, "/proc", []string{"0.0.0.0/0"})
 
-				server.supervisor, _ = supervisor.NewSupervisor(c, e, constants.RemoteContainer, []string{}, nil)
+				server.supervisor, _ = supervisor.NewSupervisor(c, e, constants.RemoteContainer, []string{}, nil, nil)
 
 				err := server.Unsupervise(rpcwrperreq, &rpcwrperres)
 
@@ -1118,3 +1118,79 @@ func TestUnSupervise(t *testing.T) {
 		})
 	})
 }
+
+func TestSupervisorPolicyVersion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a remote enforcer", t, func() {
+		rpcHdl := rpcwrapper.NewRPCServer()
+		mockSup := mocksupervisor.NewMockSupervisor(ctrl)
+
+		serr := os.Setenv(constants.EnvStatsChannel, "/tmp/test.sock")
+		So(serr, ShouldBeNil)
+		serr = os.Setenv(constants.EnvStatsSecret, "zsGt6jhc1DkE0cHcv8HtJl_iP-8K_zPX4u0TUykDJSg=")
+		So(serr, ShouldBeNil)
+		defer os.Setenv(constants.EnvStatsChannel, "") // nolint
+		defer os.Setenv(constants.EnvStatsSecret, "")  // nolint
+
+		var service packetprocessor.PacketProcessor
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		remoteIntf, err := newServer(ctx, cancel, service, rpcHdl, os.Getenv(constants.EnvStatsChannel), os.Getenv(constants.EnvStatsSecret), nil)
+		So(err, ShouldBeNil)
+		server := remoteIntf.(*RemoteEnforcer)
+
+		var rpcwrperreq rpcwrapper.Request
+		var rpcwrperres rpcwrapper.Response
+		rpcwrperreq.Payload = rpcwrapper.PolicyVersionPayload{ContextID: "ac0d3577e808"}
+
+		digest := hmac.New(sha256.New, []byte(os.Getenv(constants.EnvStatsSecret)))
+		_, err = digest.Write(getHash(rpcwrperreq.Payload))
+		So(err, ShouldBeNil)
+		rpcwrperreq.HashAuth = digest.Sum(nil)
+
+		Convey("When I request the policy version of a supervised PU", func() {
+			mockSup.EXPECT().PolicyVersion("ac0d3577e808").Times(1).Return(3, nil)
+			server.supervisor = mockSup
+
+			err := server.SupervisorPolicyVersion(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get the version of the supervisor", func() {
+				So(err, ShouldBeNil)
+				So(rpcwrperres.Payload, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When I request the policy version of a PU that is not supervised", func() {
+			mockSup.EXPECT().PolicyVersion("ac0d3577e808").Times(1).Return(0, errors.New("cannot find policy version"))
+			server.supervisor = mockSup
+
+			err := server.SupervisorPolicyVersion(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+				So(rpcwrperres.Payload, ShouldBeNil)
+			})
+		})
+
+		Convey("When I request the policy version before the supervisor is initialized", func() {
+			err := server.SupervisorPolicyVersion(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I request the policy version with an invalid secret", func() {
+			rpcwrperreq.HashAuth = []byte("invalid")
+			server.supervisor = mockSup
+
+			err := server.SupervisorPolicyVersion(rpcwrperreq, &rpcwrperres)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldResemble, errors.New("SupervisorPolicyVersion message auth failed"))
+			})
+		})
+	})
+}
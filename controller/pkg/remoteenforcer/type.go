@@ -29,6 +29,7 @@ type RemoteEnforcer struct {
 	supervisor     supervisor.Supervisor
 	service        packetprocessor.PacketProcessor
 	secrets        secrets.Secrets
+	encryptStats   bool
 	ctx            context.Context
 	cancel         context.CancelFunc
 }
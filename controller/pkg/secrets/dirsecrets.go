@@ -0,0 +1,254 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+const (
+	// DirSecretsKeyFile is the name of the private key file in a secrets directory
+	DirSecretsKeyFile = "key.pem"
+	// DirSecretsCertFile is the name of the certificate file in a secrets directory
+	DirSecretsCertFile = "cert.pem"
+	// DirSecretsCAFile is the name of the CA file in a secrets directory
+	DirSecretsCAFile = "ca.pem"
+
+	// dirSecretsSettleTime is the time we wait after the last change in the
+	// directory before reloading, so that all the files are written.
+	dirSecretsSettleTime = 500 * time.Millisecond
+)
+
+// UpdateSecretsFunc is called with the new secrets after a reload.
+type UpdateSecretsFunc func(Secrets) error
+
+// DirSecrets implements Secrets with PKI certificates loaded from a directory.
+// The directory is watched for changes and the secrets are reloaded when the
+// key, certificate and CA files are consistent again.
+type DirSecrets struct {
+	dir        string
+	update     UpdateSecretsFunc
+	current    *PKISecrets
+	lastReload time.Time
+	lastErr    error
+	sync.RWMutex
+}
+
+// NewDirSecrets loads the secrets from the given directory. The update function
+// is called every time the secrets are reloaded, and would typically be the
+// UpdateSecrets method of the controller.
+func NewDirSecrets(dir string, update UpdateSecretsFunc) (*DirSecrets, error) {
+
+	current, err := loadDirSecrets(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirSecrets{
+		dir:        dir,
+		update:     update,
+		current:    current,
+		lastReload: time.Now(),
+	}, nil
+}
+
+// Run starts watching the directory for changes until the context is cancelled.
+func (d *DirSecrets) Run(ctx context.Context) error {
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to create watcher: %s", err)
+	}
+
+	if err := watcher.Add(d.dir); err != nil {
+		watcher.Close() // nolint errcheck
+		return fmt.Errorf("unable to watch secrets directory %s: %s", d.dir, err)
+	}
+
+	go d.watch(ctx, watcher)
+
+	return nil
+}
+
+// Reload loads the secrets from the directory and calls the update function if
+// they have changed. The previous secrets are kept if the files are incomplete
+// or inconsistent, or if the update fails.
+func (d *DirSecrets) Reload() error {
+
+	s, err := loadDirSecrets(d.dir)
+	if err != nil {
+		d.setStatus(err)
+		zap.L().Warn("Unable to reload secrets", zap.String("dir", d.dir), zap.Error(err))
+		return err
+	}
+
+	d.Lock()
+	if bytes.Equal(s.PrivateKeyPEM, d.current.PrivateKeyPEM) &&
+		bytes.Equal(s.PublicKeyPEM, d.current.PublicKeyPEM) &&
+		bytes.Equal(s.AuthorityPEM, d.current.AuthorityPEM) {
+		d.Unlock()
+		return nil
+	}
+	previous := d.current
+	d.current = s
+	d.Unlock()
+
+	if d.update != nil {
+		if err = d.update(d); err != nil {
+			err = fmt.Errorf("unable to update secrets: %s", err)
+
+			// Keep the previous secrets so that the next reload retries the update.
+			d.Lock()
+			d.current = previous
+			d.Unlock()
+		}
+	}
+
+	d.setStatus(err)
+
+	if err != nil {
+		zap.L().Error("Failed to apply reloaded secrets", zap.String("dir", d.dir), zap.Error(err))
+		return err
+	}
+
+	zap.L().Info("Reloaded secrets", zap.String("dir", d.dir))
+
+	return nil
+}
+
+// LastReload returns the time and the result of the last reload.
+func (d *DirSecrets) LastReload() (time.Time, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.lastReload, d.lastErr
+}
+
+// Type implements the interface Secrets
+func (d *DirSecrets) Type() PrivateSecretsType {
+	return PKIType
+}
+
+// EncodingKey returns the private key
+func (d *DirSecrets) EncodingKey() interface{} {
+	return d.secrets().EncodingKey()
+}
+
+// PublicKey returns the public key
+func (d *DirSecrets) PublicKey() interface{} {
+	return d.secrets().PublicKey()
+}
+
+// DecodingKey returns the public key
+func (d *DirSecrets) DecodingKey(server string, ackCert interface{}, prevCert interface{}) (interface{}, error) {
+	return d.secrets().DecodingKey(server, ackCert, prevCert)
+}
+
+// VerifyPublicKey verifies if the inband public key is correct.
+func (d *DirSecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
+	return d.secrets().VerifyPublicKey(pkey)
+}
+
+// TransmittedKey returns the PEM of the public key in the case of PKI
+// if there is no certificate cache configured
+func (d *DirSecrets) TransmittedKey() []byte {
+	return d.secrets().TransmittedKey()
+}
+
+// AckSize returns the default size of an ACK packet
+func (d *DirSecrets) AckSize() uint32 {
+	return d.secrets().AckSize()
+}
+
+// PublicSecrets returns the secrets that are marshallable over the RPC interface.
+func (d *DirSecrets) PublicSecrets() PublicSecrets {
+	return d.secrets().PublicSecrets()
+}
+
+// secrets returns the current secrets.
+func (d *DirSecrets) secrets() *PKISecrets {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.current
+}
+
+// setStatus records the result of a reload.
+func (d *DirSecrets) setStatus(err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.lastReload = time.Now()
+	d.lastErr = err
+}
+
+// watch reloads the secrets once the directory has settled after a change.
+func (d *DirSecrets) watch(ctx context.Context, watcher *fsnotify.Watcher) {
+
+	defer watcher.Close() // nolint errcheck
+
+	settle := time.NewTimer(dirSecretsSettleTime)
+	settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			settle.Stop()
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			zap.L().Debug("Secrets directory changed", zap.String("event", event.String()))
+			settle.Reset(dirSecretsSettleTime)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			zap.L().Warn("Error while watching secrets directory", zap.String("dir", d.dir), zap.Error(err))
+		case <-settle.C:
+			d.Reload() // nolint errcheck
+		}
+	}
+}
+
+// loadDirSecrets reads the key, certificate and CA from the directory and
+// verifies that they are consistent with each other.
+func loadDirSecrets(dir string) (*PKISecrets, error) {
+
+	keyPEM, err := ioutil.ReadFile(filepath.Join(dir, DirSecretsKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key: %s", err)
+	}
+
+	certPEM, err := ioutil.ReadFile(filepath.Join(dir, DirSecretsCertFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate: %s", err)
+	}
+
+	caPEM, err := ioutil.ReadFile(filepath.Join(dir, DirSecretsCAFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ca: %s", err)
+	}
+
+	s, err := NewPKISecrets(keyPEM, certPEM, caPEM, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	certKey, ok := s.publicKey.PublicKey.(*ecdsa.PublicKey)
+	if !ok || certKey.X.Cmp(s.privateKey.X) != 0 || certKey.Y.Cmp(s.privateKey.Y) != 0 {
+		return nil, errors.New("key does not match certificate")
+	}
+
+	return s, nil
+}
@@ -0,0 +1,130 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeDirSecrets(dir string, key, cert, ca string) {
+	So(ioutil.WriteFile(filepath.Join(dir, DirSecretsKeyFile), []byte(key), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(filepath.Join(dir, DirSecretsCertFile), []byte(cert), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(filepath.Join(dir, DirSecretsCAFile), []byte(ca), 0600), ShouldBeNil)
+}
+
+func TestNewDirSecrets(t *testing.T) {
+
+	Convey("Given a secrets directory", t, func() {
+		dir, err := ioutil.TempDir("", "dirsecrets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint errcheck
+
+		Convey("When the directory has valid secrets, it should succeed", func() {
+			writeDirSecrets(dir, privateKeyPEM, publicPEM, caPEM)
+			d, err := NewDirSecrets(dir, nil)
+			So(err, ShouldBeNil)
+			So(d.Type(), ShouldEqual, PKIType)
+			So(d.TransmittedKey(), ShouldResemble, []byte(publicPEM))
+			So(d.PublicSecrets().CertAuthority(), ShouldResemble, []byte(caPEM))
+		})
+
+		Convey("When the directory is missing a file, it should fail", func() {
+			writeDirSecrets(dir, privateKeyPEM, publicPEM, caPEM)
+			So(os.Remove(filepath.Join(dir, DirSecretsCAFile)), ShouldBeNil)
+			d, err := NewDirSecrets(dir, nil)
+			So(err, ShouldNotBeNil)
+			So(d, ShouldBeNil)
+		})
+
+		Convey("When the key does not match the certificate, it should fail", func() {
+			writeDirSecrets(dir, caKeyPEM, publicPEM, caPEM)
+			d, err := NewDirSecrets(dir, nil)
+			So(err, ShouldNotBeNil)
+			So(d, ShouldBeNil)
+		})
+	})
+}
+
+func TestDirSecretsReload(t *testing.T) {
+
+	Convey("Given dir secrets with an update function", t, func() {
+		dir, err := ioutil.TempDir("", "dirsecrets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint errcheck
+
+		writeDirSecrets(dir, privateKeyPEM, publicPEM, caPEM)
+
+		updates := make(chan Secrets, 10)
+		var updateErr error
+		d, err := NewDirSecrets(dir, func(s Secrets) error {
+			updates <- s
+			return updateErr
+		})
+		So(err, ShouldBeNil)
+
+		Convey("When nothing changed, reload should not update the secrets", func() {
+			So(d.Reload(), ShouldBeNil)
+			So(len(updates), ShouldEqual, 0)
+		})
+
+		Convey("When the certificate is partially written, reload should keep the previous secrets", func() {
+			writeDirSecrets(dir, privateKeyPEM, publicPEM[:40], caPEM)
+			So(d.Reload(), ShouldNotBeNil)
+			So(len(updates), ShouldEqual, 0)
+			So(d.TransmittedKey(), ShouldResemble, []byte(publicPEM))
+			_, lastErr := d.LastReload()
+			So(lastErr, ShouldNotBeNil)
+		})
+
+		Convey("When the secrets changed, reload should update the secrets", func() {
+			writeDirSecrets(dir, privateKeyPEM, publicPEM+"\n", caPEM)
+			So(d.Reload(), ShouldBeNil)
+			So(len(updates), ShouldEqual, 1)
+			So(<-updates, ShouldPointTo, d)
+			So(d.TransmittedKey(), ShouldResemble, []byte(publicPEM+"\n"))
+			_, lastErr := d.LastReload()
+			So(lastErr, ShouldBeNil)
+		})
+
+		Convey("When the update function fails, reload should report the failure", func() {
+			updateErr = errors.New("update failed")
+			writeDirSecrets(dir, privateKeyPEM, publicPEM+"\n", caPEM)
+			So(d.Reload(), ShouldNotBeNil)
+			So(d.TransmittedKey(), ShouldResemble, []byte(publicPEM))
+			_, lastErr := d.LastReload()
+			So(lastErr, ShouldNotBeNil)
+
+			Convey("When the update function recovers, the next reload should retry the update", func() {
+				updateErr = nil
+				So(d.Reload(), ShouldBeNil)
+				So(len(updates), ShouldEqual, 2)
+				So(d.TransmittedKey(), ShouldResemble, []byte(publicPEM+"\n"))
+				_, lastErr := d.LastReload()
+				So(lastErr, ShouldBeNil)
+			})
+		})
+
+		Convey("When I run the watcher and the secrets change, they should be reloaded", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			So(d.Run(ctx), ShouldBeNil)
+
+			writeDirSecrets(dir, privateKeyPEM, publicPEM+"\n", caPEM)
+
+			select {
+			case s := <-updates:
+				// Compare the pointers only, the watcher may still be recording
+				// the status of the reload.
+				So(s == Secrets(d), ShouldBeTrue)
+			case <-time.After(5 * time.Second):
+				So("timeout waiting for update", ShouldBeEmpty)
+			}
+		})
+	})
+}
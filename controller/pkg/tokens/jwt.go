@@ -23,10 +23,55 @@ var (
 	tokenPosition = 2 + NonceLength
 )
 
+// DefaultClockSkew is the default tolerance applied to the time based claims
+// of received tokens to account for clock differences between servers
+const DefaultClockSkew = 5 * time.Second
+
+const (
+	// DefaultMaxTokenSize is the default size of the largest token that is
+	// decoded. It accommodates the syn tokens with a certificate.
+	DefaultMaxTokenSize = 8192
+	// DefaultParseBudget is the default time after which the decoding of a
+	// token is abandoned.
+	DefaultParseBudget = 100 * time.Millisecond
+)
+
+var (
+	// ErrTokenTooLarge is returned for the tokens larger than the maximum
+	// size. They are rejected before any crypto operation.
+	ErrTokenTooLarge = errors.New("invalid token: too large")
+	// ErrTokenParseBudget is returned for the tokens whose decoding exceeds
+	// the parse budget.
+	ErrTokenParseBudget = errors.New("invalid token: parse budget exceeded")
+)
+
 // JWTClaims captures all the custom  clains
 type JWTClaims struct {
 	*ConnectionClaims
 	jwt.StandardClaims
+	// leeway is the clock skew tolerated when validating the claims
+	leeway time.Duration
+}
+
+// Valid validates the time based claims allowing for the configured clock skew
+func (c *JWTClaims) Valid() error {
+
+	now := time.Now()
+	leeway := int64(c.leeway / time.Second)
+
+	if !c.VerifyExpiresAt(now.Unix()-leeway, false) {
+		return errors.New("token is expired")
+	}
+
+	if !c.VerifyIssuedAt(now.Unix()+leeway, false) {
+		return errors.New("token used before issued")
+	}
+
+	if !c.VerifyNotBefore(now.Unix()+leeway, false) {
+		return errors.New("token is not valid yet")
+	}
+
+	return nil
 }
 
 // JWTConfig configures the JWT token generator with the standard parameters. One
@@ -36,6 +81,16 @@ type JWTConfig struct {
 	ValidityPeriod time.Duration
 	// Issuer is the server that issues the JWT
 	Issuer string
+	// ClockSkew is the tolerance applied to the expiry, issued at and not
+	// before claims of received tokens
+	ClockSkew time.Duration
+	// MaxTokenSize is the size of the largest token that is decoded. Zero
+	// disables the limit.
+	MaxTokenSize int
+	// ParseBudget bounds the time spent decoding a token. The budget is
+	// checked between the crypto operations, which are not interrupted. Zero
+	// disables the budget.
+	ParseBudget time.Duration
 	// signMethod is the method used to sign the JWT
 	signMethod jwt.SigningMethod
 	// secrets is the secrets used for signing and verifying the JWT
@@ -81,6 +136,9 @@ func NewJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfi
 	return &JWTConfig{
 		ValidityPeriod:       validity,
 		Issuer:               issuer,
+		ClockSkew:            DefaultClockSkew,
+		MaxTokenSize:         DefaultMaxTokenSize,
+		ParseBudget:          DefaultParseBudget,
 		signMethod:           signMethod,
 		secrets:              s,
 		tokenCache:           cache.NewCacheWithExpiration("JWTTokenCache", time.Millisecond*500),
@@ -95,8 +153,8 @@ func (c *JWTConfig) CreateAndSign(isAck bool, claims *ConnectionClaims, nonce []
 
 	// Combine the application claims with the standard claims
 	allclaims := &JWTClaims{
-		claims,
-		jwt.StandardClaims{
+		ConnectionClaims: claims,
+		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(c.ValidityPeriod).Unix(),
 			Issuer:    c.Issuer,
 		},
@@ -163,14 +221,26 @@ func (c *JWTConfig) CreateAndSign(isAck bool, claims *ConnectionClaims, nonce []
 
 // Decode  takes as argument the JWT token and the certificate of the issuer.
 // First it verifies the certificate with the local CA pool, and the decodes
-// the JWT if the certificate is trusted
+// the JWT if the certificate is trusted. The tokens larger than the maximum
+// size are rejected before any crypto operation.
 func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (claims *ConnectionClaims, nonce []byte, publicKey interface{}, err error) {
 
+	if c.MaxTokenSize > 0 && len(data) > c.MaxTokenSize {
+		return nil, nil, nil, ErrTokenTooLarge
+	}
+
+	start := time.Now()
+	overBudget := func() bool {
+		return c.ParseBudget > 0 && time.Since(start) > c.ParseBudget
+	}
+
 	var ackCert interface{}
 
 	token := data
 
-	jwtClaims := &JWTClaims{}
+	jwtClaims := &JWTClaims{
+		leeway: c.ClockSkew,
+	}
 
 	nonce = make([]byte, NonceLength)
 
@@ -203,17 +273,34 @@ func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (c
 		if cachedClaims, cerr := c.tokenCache.Get(string(token)); cerr == nil {
 			return cachedClaims.(*ConnectionClaims), nonce, ackCert, nil
 		}
+
+		if overBudget() {
+			return nil, nil, nil, ErrTokenParseBudget
+		}
 	}
 
 	// Parse the JWT token with the public key recovered
 	jwttoken, err := jwt.ParseWithClaims(string(token), jwtClaims, func(token *jwt.Token) (interface{}, error) {
+		// Reject tokens signed with a different method than ours, so that
+		// enforcers with different secrets types fail explicitly.
+		if token.Method.Alg() != c.signMethod.Alg() {
+			return nil, fmt.Errorf("signing method mismatch: expected %s, got %s", c.signMethod.Alg(), token.Method.Alg())
+		}
 		server := token.Claims.(*JWTClaims).Issuer
 		server = strings.Trim(server, " ")
-		return c.secrets.DecodingKey(server, ackCert, previousCert)
+		key, err := c.secrets.DecodingKey(server, ackCert, previousCert)
+		// The signature is not verified if the budget is exceeded.
+		if err == nil && overBudget() {
+			return nil, ErrTokenParseBudget
+		}
+		return key, err
 	})
 
 	// If error is returned or the token is not valid, reject it
 	if err != nil {
+		if verr, ok := err.(*jwt.ValidationError); ok && verr.Inner == ErrTokenParseBudget {
+			return nil, nil, nil, ErrTokenParseBudget
+		}
 		return nil, nil, nil, fmt.Errorf("unable to parse token: %s", err)
 	}
 	if !jwttoken.Valid {
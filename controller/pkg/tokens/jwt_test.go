@@ -324,3 +324,158 @@ func TestRetrieveNonce(t *testing.T) {
 		})
 	})
 }
+
+func TestClockSkewTolerance(t *testing.T) {
+	Convey("Given a JWT engine with pre-shared key ", t, func() {
+		scrts := secrets.NewPSKSecrets(psk)
+		jwtConfig, _ := NewJWT(validity, "TRIREME", scrts)
+
+		signClaims := func(issuedAt, expiresAt time.Time) []byte {
+			allclaims := &JWTClaims{
+				ConnectionClaims: &ConnectionClaims{
+					RMT: []byte(rmt),
+					LCL: []byte(lcl),
+				},
+				StandardClaims: jwt.StandardClaims{
+					IssuedAt:  issuedAt.Unix(),
+					ExpiresAt: expiresAt.Unix(),
+					Issuer:    "TRIREME",
+				},
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, allclaims).SignedString(scrts.EncodingKey())
+			So(err, ShouldBeNil)
+			return []byte(token)
+		}
+
+		Convey("The default clock skew should be applied", func() {
+			So(jwtConfig.ClockSkew, ShouldEqual, DefaultClockSkew)
+		})
+
+		Convey("Given a token issued slightly in the future", func() {
+			token := signClaims(time.Now().Add(3*time.Second), time.Now().Add(validity))
+
+			Convey("It should be accepted with a clock skew tolerance", func() {
+				jwtConfig.ClockSkew = 5 * time.Second
+				claims, _, _, err := jwtConfig.Decode(true, token, nil)
+				So(err, ShouldBeNil)
+				So(claims.RMT, ShouldResemble, []byte(rmt))
+			})
+
+			Convey("It should be rejected without a clock skew tolerance", func() {
+				jwtConfig.ClockSkew = 0
+				claims, _, _, err := jwtConfig.Decode(true, token, nil)
+				So(err, ShouldNotBeNil)
+				So(claims, ShouldBeNil)
+			})
+		})
+
+		Convey("Given a token that expired slightly in the past", func() {
+			token := signClaims(time.Now().Add(-validity), time.Now().Add(-3*time.Second))
+
+			Convey("It should be accepted with a clock skew tolerance", func() {
+				jwtConfig.ClockSkew = 5 * time.Second
+				_, _, _, err := jwtConfig.Decode(true, token, nil)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("It should be rejected without a clock skew tolerance", func() {
+				jwtConfig.ClockSkew = 0
+				_, _, _, err := jwtConfig.Decode(true, token, nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestSigningMethodMismatch(t *testing.T) {
+	Convey("Given a JWT engine with a pre-shared key and one with a PKI key", t, func() {
+		pskConfig, _ := NewJWT(validity, "TRIREME", secrets.NewPSKSecrets(psk))
+		pkiSecrets, serr := secrets.NewPKISecrets([]byte(keyPEM), []byte(certPEM), []byte(caPool), nil)
+		So(serr, ShouldBeNil)
+		pkiConfig, _ := NewJWT(validity, "TRIREME", pkiSecrets)
+		nonce := []byte("1234567890123456")
+
+		Convey("A PKI signed token should be rejected by the PSK engine", func() {
+			token, err1 := pkiConfig.CreateAndSign(true, &ackClaims, nonce)
+			claims, _, _, err2 := pskConfig.Decode(true, token, nil)
+			So(err1, ShouldBeNil)
+			So(err2, ShouldNotBeNil)
+			So(err2.Error(), ShouldContainSubstring, "signing method mismatch")
+			So(claims, ShouldBeNil)
+		})
+
+		Convey("A PSK signed token should be rejected by the PKI engine", func() {
+			token, err1 := pskConfig.CreateAndSign(true, &ackClaims, nonce)
+			claims, _, _, err2 := pkiConfig.Decode(true, token, pkiSecrets.PublicKey())
+			So(err1, ShouldBeNil)
+			So(err2, ShouldNotBeNil)
+			So(err2.Error(), ShouldContainSubstring, "signing method mismatch")
+			So(claims, ShouldBeNil)
+		})
+	})
+}
+
+func TestTokenLimits(t *testing.T) {
+	Convey("Given a JWT engine with pre-shared key ", t, func() {
+		scrts := secrets.NewPSKSecrets(psk)
+		jwtConfig, _ := NewJWT(validity, "TRIREME", scrts)
+		nonce := []byte("1234567890123456")
+
+		Convey("The default limits should be applied", func() {
+			So(jwtConfig.MaxTokenSize, ShouldEqual, DefaultMaxTokenSize)
+			So(jwtConfig.ParseBudget, ShouldEqual, DefaultParseBudget)
+		})
+
+		Convey("Oversized tokens should be rejected before they are parsed", func() {
+			token, err := jwtConfig.CreateAndSign(false, &defaultClaims, nonce)
+			So(err, ShouldBeNil)
+
+			oversized := make([]byte, DefaultMaxTokenSize+1)
+			copy(oversized, token)
+
+			_, _, _, err = jwtConfig.Decode(false, oversized, nil)
+			So(err, ShouldEqual, ErrTokenTooLarge)
+			_, _, _, err = jwtConfig.Decode(true, oversized, nil)
+			So(err, ShouldEqual, ErrTokenTooLarge)
+
+			Convey("Unless the limit is disabled", func() {
+				jwtConfig.MaxTokenSize = 0
+				_, _, _, err = jwtConfig.Decode(false, oversized, nil)
+				So(err, ShouldNotEqual, ErrTokenTooLarge)
+			})
+		})
+
+		Convey("Malformed tokens should be rejected", func() {
+			for _, data := range [][]byte{
+				{},
+				{0xff, 0xff},
+				append([]byte{0xff, 0xff}, nonce...),
+				append(append([]byte{0x00, 0x04}, nonce...), []byte("a.b.%")...),
+				[]byte("not.a.token"),
+				make([]byte, DefaultMaxTokenSize),
+			} {
+				claims, _, _, err := jwtConfig.Decode(false, data, nil)
+				So(err, ShouldNotBeNil)
+				So(claims, ShouldBeNil)
+
+				claims, _, _, err = jwtConfig.Decode(true, data, nil)
+				So(err, ShouldNotBeNil)
+				So(claims, ShouldBeNil)
+			}
+		})
+
+		Convey("Tokens should be rejected when the parse budget is exceeded", func() {
+			token, err := jwtConfig.CreateAndSign(true, &ackClaims, nonce)
+			So(err, ShouldBeNil)
+
+			jwtConfig.ParseBudget = time.Nanosecond
+			_, _, _, err = jwtConfig.Decode(true, token, nil)
+			So(err, ShouldEqual, ErrTokenParseBudget)
+
+			jwtConfig.ParseBudget = DefaultParseBudget
+			claims, _, _, err := jwtConfig.Decode(true, token, nil)
+			So(err, ShouldBeNil)
+			So(claims.RMT, ShouldResemble, []byte(rmt))
+		})
+	})
+}
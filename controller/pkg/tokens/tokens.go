@@ -1,6 +1,11 @@
 package tokens
 
-import "go.aporeto.io/trireme-lib/policy"
+import (
+	"strconv"
+
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
+	"go.aporeto.io/trireme-lib/policy"
+)
 
 // ConnectionClaims captures all the claim information
 type ConnectionClaims struct {
@@ -15,6 +20,38 @@ type ConnectionClaims struct {
 	C string `json:",omitempty"`
 	// ID is the source PU ID
 	ID string `json:",omitempty"`
+	// FIN is the nonce of a connection teardown. Only the teardown tokens
+	// carry it.
+	FIN []byte `json:",omitempty"`
+}
+
+// Transmitter returns the context ID of the transmitter of the claims. It returns
+// false if the claims don't carry the transmitter label.
+func (c *ConnectionClaims) Transmitter() (string, bool) {
+
+	if c.T == nil {
+		return "", false
+	}
+
+	return c.T.Get(enforcerconstants.TransmitterLabel)
+}
+
+// TagsWithPort returns a copy of the tags of the claims with the destination
+// port of the connection appended as the enforcerconstants.PortNumberLabelString
+// label. Receiver rules can select on this label to restrict policies to a
+// port. The claims are not modified.
+func (c *ConnectionClaims) TagsWithPort(port uint16) *policy.TagStore {
+
+	var tags *policy.TagStore
+	if c.T != nil {
+		tags = c.T.Copy()
+	} else {
+		tags = policy.NewTagStore()
+	}
+
+	tags.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(port)))
+
+	return tags
 }
 
 // TokenEngine is the interface to the different implementations of tokens
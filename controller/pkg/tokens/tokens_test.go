@@ -0,0 +1,55 @@
+package tokens
+
+import (
+	"testing"
+
+	"go.aporeto.io/trireme-lib/controller/internal/enforcer/constants"
+	"go.aporeto.io/trireme-lib/policy"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClaimsHelpers(t *testing.T) {
+
+	Convey("Given I have claims with a transmitter label", t, func() {
+		claims := &ConnectionClaims{
+			T: policy.NewTagStoreFromMap(map[string]string{
+				enforcerconstants.TransmitterLabel: "pu1",
+				"app":                              "web",
+			}),
+		}
+
+		Convey("Then I should get the transmitter", func() {
+			tx, ok := claims.Transmitter()
+			So(ok, ShouldBeTrue)
+			So(tx, ShouldEqual, "pu1")
+		})
+
+		Convey("When I add the port to the tags", func() {
+			tags := claims.TagsWithPort(80)
+
+			Convey("Then the port label should be present in the copy only", func() {
+				port, ok := tags.Get(enforcerconstants.PortNumberLabelString)
+				So(ok, ShouldBeTrue)
+				So(port, ShouldEqual, "80")
+
+				_, ok = claims.T.Get(enforcerconstants.PortNumberLabelString)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given I have claims without tags", t, func() {
+		claims := &ConnectionClaims{}
+
+		Convey("Then there should be no transmitter", func() {
+			_, ok := claims.Transmitter()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Then the port tags should only have the port", func() {
+			tags := claims.TagsWithPort(443)
+			So(tags.Tags, ShouldResemble, []string{enforcerconstants.PortNumberLabelString + "=443"})
+		})
+	})
+}
@@ -0,0 +1,101 @@
+package extractors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"go.aporeto.io/trireme-lib/policy"
+	api "k8s.io/api/core/v1"
+)
+
+// ChainDockerExtractors returns a DockerMetadataExtractor that runs the given
+// extractors in order on the same container. The runtime of the first extractor
+// is used and the tags of the following extractors are merged into it. Later
+// extractors override the tags of earlier ones with the same key.
+func ChainDockerExtractors(extractors ...DockerMetadataExtractor) DockerMetadataExtractor {
+
+	return func(info *types.ContainerJSON) (*policy.PURuntime, error) {
+
+		if len(extractors) == 0 {
+			return nil, fmt.Errorf("no extractors in chain")
+		}
+
+		var runtime *policy.PURuntime
+		for i, extractor := range extractors {
+			r, err := extractor(info)
+			if err != nil {
+				return nil, fmt.Errorf("extractor %d failed: %s", i, err)
+			}
+
+			if r == nil {
+				return nil, fmt.Errorf("extractor %d returned no runtime", i)
+			}
+
+			if runtime == nil {
+				runtime = r
+				continue
+			}
+
+			runtime.SetTags(mergeTags(runtime.Tags(), r.Tags()))
+		}
+
+		return runtime, nil
+	}
+}
+
+// ChainKubernetesExtractors returns a KubernetesMetadataExtractorType that runs
+// the given extractors in order on the same pod. The runtime of the first extractor
+// is used and the tags of the following extractors are merged into it. Later
+// extractors override the tags of earlier ones with the same key. The PU is only
+// activated if all the extractors activate it.
+func ChainKubernetesExtractors(extractors ...KubernetesMetadataExtractorType) KubernetesMetadataExtractorType {
+
+	return func(runtime policy.RuntimeReader, pod *api.Pod) (*policy.PURuntime, bool, error) {
+
+		if len(extractors) == 0 {
+			return nil, false, fmt.Errorf("no extractors in chain")
+		}
+
+		var result *policy.PURuntime
+		for i, extractor := range extractors {
+			r, activate, err := extractor(runtime, pod)
+			if err != nil {
+				return nil, false, fmt.Errorf("extractor %d failed: %s", i, err)
+			}
+
+			if !activate || r == nil {
+				return nil, false, nil
+			}
+
+			if result == nil {
+				result = r
+				continue
+			}
+
+			result.SetTags(mergeTags(result.Tags(), r.Tags()))
+		}
+
+		return result, true, nil
+	}
+}
+
+// mergeTags returns the tags of base with the tags of override. The tags of
+// override replace the tags of base with the same key.
+func mergeTags(base, override *policy.TagStore) *policy.TagStore {
+
+	keys := map[string]bool{}
+	for _, kv := range override.Tags {
+		keys[strings.SplitN(kv, "=", 2)[0]] = true
+	}
+
+	merged := policy.NewTagStore()
+	for _, kv := range base.Tags {
+		if !keys[strings.SplitN(kv, "=", 2)[0]] {
+			merged.Tags = append(merged.Tags, kv)
+		}
+	}
+	merged.Tags = append(merged.Tags, override.Tags...)
+
+	return merged
+}
@@ -0,0 +1,103 @@
+package extractors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+	api "k8s.io/api/core/v1"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestRuntime(tags map[string]string) *policy.PURuntime {
+	return policy.NewPURuntime("test", 1, "", policy.NewTagStoreFromMap(tags), nil, common.ContainerPU, nil)
+}
+
+func TestChainDockerExtractors(t *testing.T) {
+
+	Convey("Given I have two docker extractors", t, func() {
+		base := func(info *types.ContainerJSON) (*policy.PURuntime, error) {
+			return newTestRuntime(map[string]string{"app": "web", "env": "dev"}), nil
+		}
+		enrich := func(info *types.ContainerJSON) (*policy.PURuntime, error) {
+			return newTestRuntime(map[string]string{"env": "prod", "team": "blue"}), nil
+		}
+
+		Convey("When I chain them", func() {
+			r, err := ChainDockerExtractors(base, enrich)(&types.ContainerJSON{})
+
+			Convey("Then the tags should be merged and the later extractor should win", func() {
+				So(err, ShouldBeNil)
+				So(r.Name(), ShouldEqual, "test")
+				tags := r.Tags()
+				So(len(tags.Tags), ShouldEqual, 3)
+				app, _ := tags.Get("app")
+				So(app, ShouldEqual, "web")
+				env, _ := tags.Get("env")
+				So(env, ShouldEqual, "prod")
+				team, _ := tags.Get("team")
+				So(team, ShouldEqual, "blue")
+			})
+		})
+
+		Convey("When an extractor of the chain fails", func() {
+			failing := func(info *types.ContainerJSON) (*policy.PURuntime, error) {
+				return nil, fmt.Errorf("failed")
+			}
+			_, err := ChainDockerExtractors(base, failing)(&types.ContainerJSON{})
+
+			Convey("Then the chain should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the chain is empty", func() {
+			_, err := ChainDockerExtractors()(&types.ContainerJSON{})
+
+			Convey("Then the chain should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestChainKubernetesExtractors(t *testing.T) {
+
+	Convey("Given I have two kubernetes extractors", t, func() {
+		base := func(runtime policy.RuntimeReader, pod *api.Pod) (*policy.PURuntime, bool, error) {
+			return newTestRuntime(map[string]string{"app": "web", "env": "dev"}), true, nil
+		}
+		enrich := func(runtime policy.RuntimeReader, pod *api.Pod) (*policy.PURuntime, bool, error) {
+			return newTestRuntime(map[string]string{"env": "prod"}), true, nil
+		}
+		skip := func(runtime policy.RuntimeReader, pod *api.Pod) (*policy.PURuntime, bool, error) {
+			return nil, false, nil
+		}
+
+		Convey("When I chain them", func() {
+			r, activate, err := ChainKubernetesExtractors(base, enrich)(policy.NewPURuntimeWithDefaults(), &api.Pod{})
+
+			Convey("Then the PU should be activated with the merged tags", func() {
+				So(err, ShouldBeNil)
+				So(activate, ShouldBeTrue)
+				env, _ := r.Tags().Get("env")
+				So(env, ShouldEqual, "prod")
+				app, _ := r.Tags().Get("app")
+				So(app, ShouldEqual, "web")
+			})
+		})
+
+		Convey("When an extractor does not activate the PU", func() {
+			r, activate, err := ChainKubernetesExtractors(base, skip, enrich)(policy.NewPURuntimeWithDefaults(), &api.Pod{})
+
+			Convey("Then the PU should not be activated", func() {
+				So(err, ShouldBeNil)
+				So(activate, ShouldBeFalse)
+				So(r, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -1,6 +1,8 @@
 package dockermonitor
 
 import (
+	"time"
+
 	"go.aporeto.io/trireme-lib/monitor/constants"
 	"go.aporeto.io/trireme-lib/monitor/extractors"
 )
@@ -12,8 +14,19 @@ type Config struct {
 	SocketAddress              string
 	SyncAtStart                bool
 	KillContainerOnPolicyError bool
+	SyncConcurrency            int
+	ReconnectBackoff           time.Duration
+	ResyncOnReconnect          bool
 }
 
+// DefaultSyncConcurrency is the default number of containers that are
+// synced in parallel when the monitor starts.
+const DefaultSyncConcurrency = 8
+
+// DefaultReconnectBackoff is the default initial time to wait before
+// reconnecting to the docker event stream.
+const DefaultReconnectBackoff = time.Second
+
 // DefaultConfig provides a default configuration
 func DefaultConfig() *Config {
 	return &Config{
@@ -22,6 +35,9 @@ func DefaultConfig() *Config {
 		SocketAddress:              constants.DefaultDockerSocket,
 		SyncAtStart:                true,
 		KillContainerOnPolicyError: false,
+		SyncConcurrency:            DefaultSyncConcurrency,
+		ReconnectBackoff:           DefaultReconnectBackoff,
+		ResyncOnReconnect:          true,
 	}
 }
 
@@ -39,5 +55,11 @@ func SetupDefaultConfig(dockerConfig *Config) *Config {
 	if dockerConfig.SocketAddress == "" {
 		dockerConfig.SocketAddress = defaultConfig.SocketAddress
 	}
+	if dockerConfig.SyncConcurrency <= 0 {
+		dockerConfig.SyncConcurrency = defaultConfig.SyncConcurrency
+	}
+	if dockerConfig.ReconnectBackoff <= 0 {
+		dockerConfig.ReconnectBackoff = defaultConfig.ReconnectBackoff
+	}
 	return dockerConfig
 }
@@ -8,6 +8,8 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -46,6 +48,11 @@ type DockerMonitor struct {
 	netcls                     cgnetcls.Cgroupnetcls
 	killContainerOnPolicyError bool
 	syncAtStart                bool
+	syncConcurrency            int
+	reconnectBackoff           time.Duration
+	resyncOnReconnect          bool
+	knownPUs                   map[string]struct{}
+	knownPUsLock               sync.Mutex
 }
 
 // New returns a new docker monitor.
@@ -75,6 +82,10 @@ func (d *DockerMonitor) SetupConfig(registerer registerer.Registerer, cfg interf
 	d.socketAddress = dockerConfig.SocketAddress
 	d.metadataExtractor = dockerConfig.EventMetadataExtractor
 	d.syncAtStart = dockerConfig.SyncAtStart
+	d.syncConcurrency = dockerConfig.SyncConcurrency
+	d.reconnectBackoff = dockerConfig.ReconnectBackoff
+	d.resyncOnReconnect = dockerConfig.ResyncOnReconnect
+	d.knownPUs = map[string]struct{}{}
 	d.killContainerOnPolicyError = dockerConfig.KillContainerOnPolicyError
 	d.handlers = make(map[Event]func(ctx context.Context, event *events.Message) error)
 	d.stoplistener = make(chan bool)
@@ -203,16 +214,11 @@ func (d *DockerMonitor) eventProcessors(ctx context.Context) {
 
 // eventListener listens to Docker events from the daemon and passes to
 // to the processor through a buffered channel. This minimizes the chances
-// that we will miss events because the processor is delayed
+// that we will miss events because the processor is delayed. If the event
+// stream drops, the listener reconnects and optionally resyncs the containers.
 func (d *DockerMonitor) eventListener(ctx context.Context, listenerReady chan struct{}) {
 
-	f := filters.NewArgs()
-	f.Add("type", "container")
-	options := types.EventsOptions{
-		Filters: f,
-	}
-
-	messages, errs := d.dockerClient.Events(context.Background(), options)
+	messages, errs := d.subscribeEvents()
 
 	// Once the buffered event channel was returned by Docker we return the ready status.
 	listenerReady <- struct{}{}
@@ -232,12 +238,140 @@ func (d *DockerMonitor) eventListener(ctx context.Context, listenerReady chan st
 					zap.Error(err),
 				)
 			}
+
+			// The event stream is closed after an error. Reconnect.
+			if messages, errs = d.reconnectEvents(ctx); messages == nil {
+				return
+			}
+
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// subscribeEvents subscribes to the container events of the docker daemon.
+func (d *DockerMonitor) subscribeEvents() (<-chan events.Message, <-chan error) {
+
+	f := filters.NewArgs()
+	f.Add("type", "container")
+	options := types.EventsOptions{
+		Filters: f,
+	}
+
+	return d.dockerClient.Events(context.Background(), options)
+}
+
+// reconnectEvents waits for the docker daemon to come back with an exponential
+// backoff and subscribes again to its event stream. The containers are resynced
+// in the background if configured. It returns nil channels if the context is done.
+func (d *DockerMonitor) reconnectEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+
+	backoff := d.reconnectBackoff
+	if backoff <= 0 {
+		backoff = DefaultReconnectBackoff
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(backoff):
+		}
+
+		if err := d.setupDockerDaemon(); err != nil {
+			zap.L().Debug("Unable to reconnect to docker daemon. Retrying...",
+				zap.Duration("backoff", backoff),
+				zap.Error(err),
+			)
+			if backoff *= 2; backoff > dockerMaxReconnectBackoff {
+				backoff = dockerMaxReconnectBackoff
+			}
+			continue
+		}
+
+		messages, errs := d.subscribeEvents()
+
+		zap.L().Info("Reconnected to docker event stream")
+
+		if d.resyncOnReconnect && d.config.Policy != nil {
+			go func() {
+				if err := d.resyncAfterReconnect(ctx); err != nil {
+					zap.L().Error("Unable to resync containers after reconnect", zap.Error(err))
+				}
+			}()
+		}
+
+		return messages, errs
+	}
+}
+
+// resyncAfterReconnect diffs the containers known by docker with the PUs
+// known by the monitor. PUs of containers that disappeared while the monitor
+// was disconnected are destroyed, and all remaining containers are resynced.
+func (d *DockerMonitor) resyncAfterReconnect(ctx context.Context) error {
+
+	options := types.ContainerListOptions{All: true}
+	containers, err := d.dockerClient.ContainerList(ctx, options)
+	if err != nil {
+		return fmt.Errorf("unable to get container list: %s", err)
+	}
+
+	present := map[string]struct{}{}
+	for _, c := range containers {
+		if puID, err := puIDFromDockerID(c.ID); err == nil {
+			present[puID] = struct{}{}
+		}
+	}
+
+	for _, puID := range d.missingPUs(present) {
+		zap.L().Debug("Removing PU of container that disappeared", zap.String("puID", puID))
+		if err := d.config.Policy.HandlePUEvent(ctx, puID, tevents.EventStop, policy.NewPURuntimeWithDefaults()); err != nil {
+			zap.L().Warn("Failed to stop PU of removed container",
+				zap.String("puID", puID),
+				zap.Error(err),
+			)
+		}
+		d.destroyPU(ctx, puID)
+	}
+
+	return d.resyncContainers(ctx, containers)
+}
+
+// trackPU records a PU that was handed to the policy engine.
+func (d *DockerMonitor) trackPU(puID string) {
+
+	d.knownPUsLock.Lock()
+	defer d.knownPUsLock.Unlock()
+
+	d.knownPUs[puID] = struct{}{}
+}
+
+// untrackPU removes a PU from the known PUs.
+func (d *DockerMonitor) untrackPU(puID string) {
+
+	d.knownPUsLock.Lock()
+	defer d.knownPUsLock.Unlock()
+
+	delete(d.knownPUs, puID)
+}
+
+// missingPUs returns the known PUs that are not in the present set.
+func (d *DockerMonitor) missingPUs(present map[string]struct{}) []string {
+
+	d.knownPUsLock.Lock()
+	defer d.knownPUsLock.Unlock()
+
+	missing := []string{}
+	for puID := range d.knownPUs {
+		if _, ok := present[puID]; !ok {
+			missing = append(missing, puID)
+		}
+	}
+
+	return missing
+}
+
 // Resync resyncs all the existing containers on the Host, using the
 // same process as when a container is initially spawn up
 func (d *DockerMonitor) Resync(ctx context.Context) error {
@@ -258,44 +392,93 @@ func (d *DockerMonitor) Resync(ctx context.Context) error {
 	return d.resyncContainers(ctx, containers)
 }
 
+// resyncContainers syncs the given containers with at most syncConcurrency
+// containers in flight. A container that fails to sync is logged and skipped.
 func (d *DockerMonitor) resyncContainers(ctx context.Context, containers []types.Container) error {
-	// now resync the old containers
-	for _, c := range containers {
-		container, err := d.dockerClient.ContainerInspect(ctx, c.ID)
-		if err != nil {
-			continue
-		}
 
-		puID, _ := puIDFromDockerID(container.ID)
+	concurrency := d.syncConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
 
-		runtime, err := d.extractMetadata(&container)
-		if err != nil {
-			continue
-		}
+	var wg sync.WaitGroup
+	var processed, failed uint32
+	slots := make(chan struct{}, concurrency)
 
-		event := common.EventStop
-		if container.State.Running {
-			if !container.State.Paused {
-				event = common.EventStart
-			} else {
-				event = common.EventPause
+	for _, c := range containers {
+		slots <- struct{}{}
+		wg.Add(1)
+
+		go func(dockerID string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			if err := d.resyncContainer(ctx, dockerID); err != nil {
+				atomic.AddUint32(&failed, 1)
+				zap.L().Error("Unable to sync existing Container",
+					zap.String("dockerID", dockerID),
+					zap.Error(err),
+				)
 			}
-		}
 
-		if container.HostConfig.NetworkMode == constants.DockerHostMode {
-			options := hostModeOptions(&container)
-			options.PolicyExtensions = runtime.Options().PolicyExtensions
-			runtime.SetOptions(*options)
-			runtime.SetPUType(common.LinuxProcessPU)
-		}
+			if done := atomic.AddUint32(&processed, 1); done%syncProgressInterval == 0 {
+				zap.L().Info("Syncing existing containers",
+					zap.Uint32("processed", done),
+					zap.Int("total", len(containers)),
+				)
+			}
+		}(c.ID)
+	}
 
-		if err := d.config.Policy.HandlePUEvent(ctx, puID, event, runtime); err != nil {
-			zap.L().Error("Unable to sync existing Container",
-				zap.String("dockerID", c.ID),
-				zap.Error(err),
-			)
+	wg.Wait()
+
+	zap.L().Info("Synced existing containers",
+		zap.Int("total", len(containers)),
+		zap.Uint32("failed", atomic.LoadUint32(&failed)),
+	)
+
+	return nil
+}
+
+// resyncContainer syncs the state of a single existing container.
+func (d *DockerMonitor) resyncContainer(ctx context.Context, dockerID string) error {
+
+	container, err := d.dockerClient.ContainerInspect(ctx, dockerID)
+	if err != nil {
+		return fmt.Errorf("unable to inspect container: %s", err)
+	}
+
+	puID, _ := puIDFromDockerID(container.ID)
+
+	runtime, err := d.extractMetadata(&container)
+	if err != nil {
+		return fmt.Errorf("unable to extract metadata: %s", err)
+	}
+
+	event := common.EventStop
+	if container.State.Running {
+		if !container.State.Paused {
+			event = common.EventStart
+		} else {
+			event = common.EventPause
 		}
 	}
+
+	if container.HostConfig.NetworkMode == constants.DockerHostMode {
+		options := hostModeOptions(&container)
+		options.PolicyExtensions = runtime.Options().PolicyExtensions
+		runtime.SetOptions(*options)
+		runtime.SetPUType(common.LinuxProcessPU)
+	}
+
+	if err := d.config.Policy.HandlePUEvent(ctx, puID, event, runtime); err != nil {
+		return err
+	}
+
+	d.trackPU(puID)
+
 	return nil
 }
 
@@ -406,7 +589,13 @@ func (d *DockerMonitor) handleCreateEvent(ctx context.Context, event *events.Mes
 		runtime.SetPUType(common.LinuxProcessPU)
 	}
 
-	return d.config.Policy.HandlePUEvent(ctx, puID, tevents.EventCreate, runtime)
+	if err := d.config.Policy.HandlePUEvent(ctx, puID, tevents.EventCreate, runtime); err != nil {
+		return err
+	}
+
+	d.trackPU(puID)
+
+	return nil
 }
 
 // handleStartEvent will notify the policy engine immediately about the event in order
@@ -462,6 +651,8 @@ func (d *DockerMonitor) handleStartEvent(ctx context.Context, event *events.Mess
 		return fmt.Errorf("unable to set policy: container %s kept alive per policy: %s", puID, err)
 	}
 
+	d.trackPU(puID)
+
 	if container.HostConfig.NetworkMode == constants.DockerHostMode {
 		if err = d.setupHostMode(puID, runtime, container); err != nil {
 			return fmt.Errorf("unable to setup host mode for container %s: %s", puID, err)
@@ -489,7 +680,15 @@ func (d *DockerMonitor) handleDestroyEvent(ctx context.Context, event *events.Me
 		return err
 	}
 
-	err = d.config.Policy.HandlePUEvent(ctx, puID, tevents.EventDestroy, policy.NewPURuntimeWithDefaults())
+	d.destroyPU(ctx, puID)
+
+	return nil
+}
+
+// destroyPU sends a destroy event for the PU and cleans up its cgroup.
+func (d *DockerMonitor) destroyPU(ctx context.Context, puID string) {
+
+	err := d.config.Policy.HandlePUEvent(ctx, puID, tevents.EventDestroy, policy.NewPURuntimeWithDefaults())
 	if err != nil {
 		zap.L().Error("Failed to handle delete event",
 			zap.Error(err),
@@ -503,7 +702,7 @@ func (d *DockerMonitor) handleDestroyEvent(ctx context.Context, event *events.Me
 		)
 	}
 
-	return nil
+	d.untrackPU(puID)
 }
 
 // handlePauseEvent generates a create event type.
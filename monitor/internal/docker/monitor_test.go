@@ -621,6 +621,79 @@ func TestSyncContainers(t *testing.T) {
 			})
 
 		})
+
+		Convey("When I sync many containers with bounded concurrency and one of them fails", func() {
+			dmi.syncAtStart = true
+			dmi.syncConcurrency = 2
+
+			containers := []types.Container{}
+			for i := 0; i < 5; i++ {
+				containers = append(containers, types.Container{ID: ID})
+			}
+			containers = append(containers, types.Container{ID: "bad"})
+
+			dmi.dockerClient.(*mockdocker.MockCommonAPIClient).EXPECT().
+				ContainerList(gomock.Any(), gomock.Any()).Return(containers, nil)
+
+			dmi.dockerClient.(*mockdocker.MockCommonAPIClient).EXPECT().
+				ContainerInspect(gomock.Any(), ID).Times(5).Return(defaultContainer(), nil)
+
+			dmi.dockerClient.(*mockdocker.MockCommonAPIClient).EXPECT().
+				ContainerInspect(gomock.Any(), "bad").Return(types.ContainerJSON{}, errors.New("no such container"))
+
+			mockPU.EXPECT().HandlePUEvent(gomock.Any(), ID[:12], tevents.EventStart, gomock.Any()).Times(5).Return(nil)
+
+			err := dmi.Resync(context.Background())
+
+			Convey("Then all the other containers should be synced without error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestResyncAfterReconnect(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a docker monitor that knows a PU whose container disappeared", t, func() {
+
+		dmi, mockPU := setupDockerMonitor(ctrl)
+		mockCG := mockcgnetcls.NewMockCgroupnetcls(ctrl)
+		dmi.netcls = mockCG
+		dmi.trackPU("0123456789ab")
+
+		Convey("When I resync after a reconnect", func() {
+			dmi.dockerClient.(*mockdocker.MockCommonAPIClient).EXPECT().
+				ContainerList(gomock.Any(), gomock.Any()).Return([]types.Container{types.Container{ID: ID}}, nil)
+
+			dmi.dockerClient.(*mockdocker.MockCommonAPIClient).EXPECT().
+				ContainerInspect(gomock.Any(), ID).Return(defaultContainer(), nil)
+
+			mockPU.EXPECT().HandlePUEvent(gomock.Any(), "0123456789ab", tevents.EventStop, gomock.Any()).Return(nil)
+			mockPU.EXPECT().HandlePUEvent(gomock.Any(), "0123456789ab", tevents.EventDestroy, gomock.Any()).Return(nil)
+			mockCG.EXPECT().DeleteCgroup("0123456789ab").Return(nil)
+			mockPU.EXPECT().HandlePUEvent(gomock.Any(), ID[:12], tevents.EventStart, gomock.Any()).Return(nil)
+
+			err := dmi.resyncAfterReconnect(context.Background())
+
+			Convey("Then the missing PU should be removed and the running container enforced", func() {
+				So(err, ShouldBeNil)
+				So(dmi.missingPUs(map[string]struct{}{}), ShouldResemble, []string{ID[:12]})
+			})
+		})
+
+		Convey("When listing the containers fails", func() {
+			dmi.dockerClient.(*mockdocker.MockCommonAPIClient).EXPECT().
+				ContainerList(gomock.Any(), gomock.Any()).Return(nil, errors.New("error"))
+
+			err := dmi.resyncAfterReconnect(context.Background())
+
+			Convey("Then I should get an error and keep the known PU", func() {
+				So(err, ShouldNotBeNil)
+				So(dmi.missingPUs(map[string]struct{}{}), ShouldResemble, []string{"0123456789ab"})
+			})
+		})
 	})
 }
 
@@ -44,6 +44,14 @@ const (
 
 	// dockerInitializationWait is the time after which we will retry to bring docker up.
 	dockerInitializationWait = 2 * dockerRetryTimer
+
+	// syncProgressInterval is the number of synced containers after which
+	// the sync progress is reported.
+	syncProgressInterval = 100
+
+	// dockerMaxReconnectBackoff is the maximum time to wait between attempts
+	// to reconnect to the docker event stream.
+	dockerMaxReconnectBackoff = time.Minute
 )
 
 // A EventHandler is type of docker event handler functions.
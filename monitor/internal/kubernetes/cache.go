@@ -1,8 +1,10 @@
 package kubernetesmonitor
 
 import (
+	"strings"
 	"sync"
 
+	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/policy"
 )
 
@@ -12,6 +14,9 @@ type puidCacheEntry struct {
 	// podID is the reference to the Kubernetes pod that this container refers to
 	kubeIdentifier string
 
+	// podUID is the UID of the Kubernetes pod
+	podUID string
+
 	// The latest reference to the runtime as received from DockerMonitor
 	dockerRuntime policy.RuntimeReader
 
@@ -52,7 +57,7 @@ func kubePodIdentifier(podName string, podNamespace string) string {
 }
 
 // updatePUIDCache updates the cache with an entry coming from a container perspective
-func (c *cache) updatePUIDCache(podNamespace string, podName string, puID string, dockerRuntime policy.RuntimeReader, kubernetesRuntime policy.RuntimeReader) {
+func (c *cache) updatePUIDCache(podNamespace string, podName string, podUID string, puID string, dockerRuntime policy.RuntimeReader, kubernetesRuntime policy.RuntimeReader) {
 	if podNamespace == "" || podName == "" || puID == "" {
 		return
 	}
@@ -68,6 +73,7 @@ func (c *cache) updatePUIDCache(podNamespace string, podName string, puID string
 		c.puidCache[puID] = puidEntry
 	}
 	puidEntry.kubeIdentifier = kubeIdentifier
+	puidEntry.podUID = podUID
 	puidEntry.dockerRuntime = dockerRuntime
 	puidEntry.kubernetesRuntime = kubernetesRuntime
 
@@ -121,6 +127,29 @@ func (c *cache) getKubernetesRuntimeByPUID(puid string) policy.RuntimeReader {
 	return puidEntry.kubernetesRuntime
 }
 
+// getPodByPUID locks the cache in order to return the metadata of the pod of the PUID, or nil if the PUID is not known
+func (c *cache) getPodByPUID(puid string) *collector.PodMetadata {
+	c.RLock()
+	defer c.RUnlock()
+
+	puidEntry, ok := c.puidCache[puid]
+	if !ok {
+		return nil
+	}
+
+	// The namespace and the name of a pod cannot contain a slash
+	parts := strings.SplitN(puidEntry.kubeIdentifier, "/", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	return &collector.PodMetadata{
+		Namespace: parts[0],
+		Name:      parts[1],
+		UID:       puidEntry.podUID,
+	}
+}
+
 // deletePod locks the cache in order to return the pod cache entry if found, or create it if not found
 func (c *cache) deletePodEntry(podNamespace string, podName string) {
 	c.Lock()
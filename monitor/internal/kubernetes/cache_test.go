@@ -5,6 +5,7 @@ import (
 	"sync"
 	"testing"
 
+	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/policy"
 )
 
@@ -26,6 +27,7 @@ func Test_cache_updatePUIDCache(t *testing.T) {
 	type args struct {
 		podNamespace      string
 		podName           string
+		podUID            string
 		puID              string
 		dockerRuntime     policy.RuntimeReader
 		kubernetesRuntime policy.RuntimeReader
@@ -123,6 +125,7 @@ func Test_cache_updatePUIDCache(t *testing.T) {
 				puidCache: map[string]*puidCacheEntry{
 					"123456": &puidCacheEntry{
 						kubeIdentifier:    "namespace/name",
+						podUID:            "d1c5e2a8",
 						dockerRuntime:     runtime1,
 						kubernetesRuntime: runtime2,
 					},
@@ -138,6 +141,7 @@ func Test_cache_updatePUIDCache(t *testing.T) {
 			args: args{
 				podNamespace:      "namespace",
 				podName:           "name",
+				podUID:            "d1c5e2a8",
 				puID:              "123456",
 				dockerRuntime:     runtime1,
 				kubernetesRuntime: runtime2,
@@ -251,7 +255,7 @@ func Test_cache_updatePUIDCache(t *testing.T) {
 				podCache:  tt.fields.podCache,  // nolint
 				RWMutex:   tt.fields.RWMutex,   // nolint
 			} // nolint
-			c.updatePUIDCache(tt.args.podNamespace, tt.args.podName, tt.args.puID, tt.args.dockerRuntime, tt.args.kubernetesRuntime)
+			c.updatePUIDCache(tt.args.podNamespace, tt.args.podName, tt.args.podUID, tt.args.puID, tt.args.dockerRuntime, tt.args.kubernetesRuntime)
 			if !reflect.DeepEqual(c.puidCache, tt.fieldsResult.puidCache) {
 				t.Errorf("updatePUIDCache() field. got %v, want %v", c.puidCache, tt.fieldsResult.puidCache)
 			}
@@ -487,6 +491,83 @@ func Test_cache_getKubernetesRuntimeByPUID(t *testing.T) {
 	}
 }
 
+func Test_cache_getPodByPUID(t *testing.T) {
+
+	puid1 := "12345"
+	pod1 := "test/test"
+	puidEntry1 := &puidCacheEntry{
+		kubeIdentifier: pod1,
+		podUID:         "d1c5e2a8",
+	}
+	podEntry1 := &podCacheEntry{
+		puIDs: map[string]bool{
+			puid1: true,
+		},
+	}
+
+	type fields struct {
+		puidCache map[string]*puidCacheEntry
+		podCache  map[string]*podCacheEntry
+		RWMutex   sync.RWMutex
+	}
+	type args struct {
+		puid string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   *collector.PodMetadata
+	}{
+		{
+			name: "simple get",
+			fields: fields{
+				puidCache: map[string]*puidCacheEntry{
+					puid1: puidEntry1,
+				},
+				podCache: map[string]*podCacheEntry{
+					pod1: podEntry1,
+				},
+			},
+			args: args{
+				puid: puid1,
+			},
+			want: &collector.PodMetadata{
+				Namespace: "test",
+				Name:      "test",
+				UID:       "d1c5e2a8",
+			},
+		},
+		{
+			name: "empty get",
+			fields: fields{
+				puidCache: map[string]*puidCacheEntry{
+					puid1: puidEntry1,
+				},
+				podCache: map[string]*podCacheEntry{
+					pod1: podEntry1,
+				},
+			},
+			args: args{
+				puid: "123123",
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests { // nolint
+		t.Run(tt.name, func(t *testing.T) { // nolint
+			c := &cache{ // nolint
+				puidCache: tt.fields.puidCache, // nolint
+				podCache:  tt.fields.podCache,  // nolint
+				RWMutex:   tt.fields.RWMutex,   // nolint
+			} // nolint
+			if got := c.getPodByPUID(tt.args.puid); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cache.getPodByPUID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_cache_deletePodEntry(t *testing.T) {
 
 	puid1 := "12345"
@@ -3,6 +3,7 @@ package kubernetesmonitor
 import (
 	"fmt"
 
+	"go.aporeto.io/trireme-lib/utils/logging"
 	"go.uber.org/zap"
 	api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -46,17 +47,17 @@ func (m *KubernetesMonitor) CreateLocalPodController(namespace string,
 	return CreateResourceController(m.kubeClient.Core().RESTClient(), "pods", namespace, &api.Pod{}, m.localNodeSelector(),
 		func(addedApiStruct interface{}) {
 			if err := addFunc(addedApiStruct.(*api.Pod)); err != nil {
-				zap.L().Error("Error while handling Add Pod", zap.Error(err))
+				logging.Named("kubernetes").Error("Error while handling Add Pod", zap.Error(err))
 			}
 		},
 		func(deletedApiStruct interface{}) {
 			if err := deleteFunc(deletedApiStruct.(*api.Pod)); err != nil {
-				zap.L().Error("Error while handling Delete Pod", zap.Error(err))
+				logging.Named("kubernetes").Error("Error while handling Delete Pod", zap.Error(err))
 			}
 		},
 		func(oldApiStruct, updatedApiStruct interface{}) {
 			if err := updateFunc(oldApiStruct.(*api.Pod), updatedApiStruct.(*api.Pod)); err != nil {
-				zap.L().Error("Error while handling Update Pod", zap.Error(err))
+				logging.Named("kubernetes").Error("Error while handling Update Pod", zap.Error(err))
 			}
 		})
 }
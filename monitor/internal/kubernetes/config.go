@@ -3,6 +3,7 @@ package kubernetesmonitor
 import (
 	"go.aporeto.io/trireme-lib/monitor/extractors"
 	dockerMonitor "go.aporeto.io/trireme-lib/monitor/internal/docker"
+	"go.aporeto.io/trireme-lib/policy"
 )
 
 // Config is the config for the Kubernetes monitor
@@ -15,6 +16,11 @@ type Config struct { // nolint
 
 	KubernetesExtractor extractors.KubernetesMetadataExtractorType
 	DockerExtractor     extractors.DockerMetadataExtractor
+
+	// NamespaceResolvers are the policy resolvers of the PUs of the pods of
+	// some namespaces. The PUs of the other namespaces use the resolver of the
+	// monitor.
+	NamespaceResolvers map[string]policy.Resolver
 }
 
 // DefaultConfig provides a default configuration
@@ -25,6 +31,7 @@ func DefaultConfig() *Config {
 		EnableHostPods:      false,
 		Kubeconfig:          "",
 		Nodename:            "",
+		NamespaceResolvers:  map[string]policy.Resolver{},
 	}
 }
 
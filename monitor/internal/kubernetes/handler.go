@@ -6,6 +6,7 @@ import (
 
 	"go.aporeto.io/trireme-lib/common"
 	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/logging"
 	"go.uber.org/zap"
 	api "k8s.io/api/core/v1"
 )
@@ -20,15 +21,18 @@ import (
 // is responsible to update all components by explicitly adding a new PU.
 // Specifically for Kubernetes, The monitor handles the downstream events from Docker.
 func (m *KubernetesMonitor) HandlePUEvent(ctx context.Context, puID string, event common.Event, dockerRuntime policy.RuntimeReader) error {
-	zap.L().Debug("dockermonitor event", zap.String("puID", puID), zap.String("eventType", string(event)))
+	logging.Named("kubernetes").Debug("dockermonitor event", zap.String("puID", puID), zap.String("eventType", string(event)))
 
 	var kubernetesRuntime policy.RuntimeReader
+	var podNamespace string
 
 	// If the event coming from DockerMonitor is start or create, we will get a meaningful PURuntime from
 	// DockerMonitor. We can use it and combine it with the pod information on Kubernetes API.
 	if event == common.EventStart || event == common.EventCreate {
 		// We check first if this is a Kubernetes managed container
-		podNamespace, podName, err := getKubernetesInformation(dockerRuntime)
+		var podName string
+		var err error
+		podNamespace, podName, err = getKubernetesInformation(dockerRuntime)
 		if err != nil {
 			return err
 		}
@@ -50,20 +54,24 @@ func (m *KubernetesMonitor) HandlePUEvent(ctx context.Context, puID string, even
 
 		// UnmanagedContainers are simply ignored. No policy is associated.
 		if !managedContainer {
-			zap.L().Debug("unmanaged Kubernetes container on create or start", zap.String("puID", puID), zap.String("podNamespace", podNamespace), zap.String("podName", podName))
+			logging.Named("kubernetes").Debug("unmanaged Kubernetes container on create or start", zap.String("puID", puID), zap.String("podNamespace", podNamespace), zap.String("podName", podName))
 			return nil
 		}
 
 		// We keep the cache uptoDate for future queries
-		m.cache.updatePUIDCache(podNamespace, podName, puID, dockerRuntime, kubernetesRuntime)
+		m.cache.updatePUIDCache(podNamespace, podName, string(pod.GetUID()), puID, dockerRuntime, kubernetesRuntime)
 	} else {
 
 		// We check if this PUID was previously managed. We only sent the event upstream to the resolver if it was managed on create or start.
 		kubernetesRuntime = m.cache.getKubernetesRuntimeByPUID(puID)
 		if kubernetesRuntime == nil {
-			zap.L().Debug("unmanaged Kubernetes container", zap.String("puID", puID))
+			logging.Named("kubernetes").Debug("unmanaged Kubernetes container", zap.String("puID", puID))
 			return nil
 		}
+
+		if pod := m.cache.getPodByPUID(puID); pod != nil {
+			podNamespace = pod.Namespace
+		}
 	}
 
 	if event == common.EventDestroy {
@@ -71,8 +79,8 @@ func (m *KubernetesMonitor) HandlePUEvent(ctx context.Context, puID string, even
 		m.cache.deletePUIDEntry(puID)
 	}
 
-	// The event is then sent to the upstream policyResolver
-	return m.handlers.Policy.HandlePUEvent(ctx, puID, event, kubernetesRuntime)
+	// The event is then sent to the upstream policyResolver of the namespace
+	return m.resolver(podNamespace).HandlePUEvent(ctx, puID, event, kubernetesRuntime)
 }
 
 // RefreshPUs is used to resend an update event to the Upstream Policy Resolver in case of an update is needed.
@@ -99,14 +107,14 @@ func (m *KubernetesMonitor) RefreshPUs(ctx context.Context, pod *api.Pod) error
 
 		// UnmanagedContainers are simply ignored. It should not come this far if it is a non managed container anyways.
 		if !managedContainer {
-			zap.L().Debug("unmanaged Kubernetes container", zap.String("puID", puid), zap.String("podNamespace", podNamespace), zap.String("podName", podName))
+			logging.Named("kubernetes").Debug("unmanaged Kubernetes container", zap.String("puID", puid), zap.String("podNamespace", podNamespace), zap.String("podName", podName))
 			continue
 		}
 
 		// We keep the cache uptoDate for future queries
-		m.cache.updatePUIDCache(podNamespace, podName, puid, dockerRuntime, kubernetesRuntime)
+		m.cache.updatePUIDCache(podNamespace, podName, string(pod.GetUID()), puid, dockerRuntime, kubernetesRuntime)
 
-		if err := m.handlers.Policy.HandlePUEvent(ctx, puid, common.EventUpdate, kubernetesRuntime); err != nil {
+		if err := m.resolver(podNamespace).HandlePUEvent(ctx, puid, common.EventUpdate, kubernetesRuntime); err != nil {
 			return err
 		}
 	}
@@ -114,6 +122,17 @@ func (m *KubernetesMonitor) RefreshPUs(ctx context.Context, pod *api.Pod) error
 	return nil
 }
 
+// resolver returns the policy resolver of the PUs of a namespace. It is the
+// resolver of the monitor unless the namespace has its own.
+func (m *KubernetesMonitor) resolver(podNamespace string) policy.Resolver {
+
+	if r, ok := m.namespaceResolvers[podNamespace]; ok && r != nil {
+		return r
+	}
+
+	return m.handlers.Policy
+}
+
 // getKubernetesInformation returns the name and namespace from a standard Docker runtime, if the docker container is associated at all with Kubernetes
 func getKubernetesInformation(runtime policy.RuntimeReader) (string, string, error) {
 	podNamespace, ok := runtime.Tag(KubernetesPodNamespaceIdentifier)
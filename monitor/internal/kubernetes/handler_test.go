@@ -3,6 +3,7 @@ package kubernetesmonitor
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"go.aporeto.io/trireme-lib/common"
@@ -340,3 +341,83 @@ func TestKubernetesMonitor_RefreshPUs(t *testing.T) {
 		})
 	}
 }
+
+// recordingHandler records the PUs of the events it handles.
+type recordingHandler struct {
+	puIDs []string
+}
+
+func (r *recordingHandler) HandlePUEvent(ctx context.Context, puID string, event common.Event, runtime policy.RuntimeReader) error {
+	r.puIDs = append(r.puIDs, puID)
+	return nil
+}
+
+func TestKubernetesMonitor_NamespaceResolvers(t *testing.T) {
+
+	podRuntime := func(namespace string, name string) *policy.PURuntime {
+		puRuntime := policy.NewPURuntimeWithDefaults()
+		puRuntime.SetTags(policy.NewTagStoreFromMap(map[string]string{
+			KubernetesPodNamespaceIdentifier: namespace,
+			KubernetesPodNameIdentifier:      name,
+		}))
+		return puRuntime
+	}
+
+	newPod := func(namespace string, name string) *api.Pod {
+		pod := &api.Pod{}
+		pod.SetName(name)
+		pod.SetNamespace(namespace)
+		return pod
+	}
+
+	kubernetesExtractorManaged := func(runtime policy.RuntimeReader, pod *api.Pod) (*policy.PURuntime, bool, error) {
+		return runtime.(*policy.PURuntime).Clone(), true, nil
+	}
+
+	defaultResolver := &recordingHandler{}
+	beerResolver := &recordingHandler{}
+	wineResolver := &recordingHandler{}
+
+	m := &KubernetesMonitor{
+		kubeClient:          kubefake.NewSimpleClientset(newPod("beer", "pod1"), newPod("wine", "pod2"), newPod("water", "pod3")),
+		cache:               newCache(),
+		kubernetesExtractor: kubernetesExtractorManaged,
+		handlers: &config.ProcessorConfig{
+			Policy: defaultResolver,
+		},
+		namespaceResolvers: map[string]policy.Resolver{
+			"beer": beerResolver,
+			"wine": wineResolver,
+		},
+	}
+
+	for puID, runtime := range map[string]*policy.PURuntime{
+		"pu1": podRuntime("beer", "pod1"),
+		"pu2": podRuntime("wine", "pod2"),
+		"pu3": podRuntime("water", "pod3"),
+	} {
+		if err := m.HandlePUEvent(context.Background(), puID, common.EventStart, runtime); err != nil {
+			t.Fatalf("KubernetesMonitor.HandlePUEvent() error = %v", err)
+		}
+	}
+
+	if err := m.HandlePUEvent(context.Background(), "pu1", common.EventStop, nil); err != nil {
+		t.Fatalf("KubernetesMonitor.HandlePUEvent() error = %v", err)
+	}
+	if err := m.RefreshPUs(context.Background(), newPod("wine", "pod2")); err != nil {
+		t.Fatalf("KubernetesMonitor.RefreshPUs() error = %v", err)
+	}
+	if err := m.HandlePUEvent(context.Background(), "pu3", common.EventDestroy, nil); err != nil {
+		t.Fatalf("KubernetesMonitor.HandlePUEvent() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(beerResolver.puIDs, []string{"pu1", "pu1"}) {
+		t.Errorf("beer resolver handled %v, want [pu1 pu1]", beerResolver.puIDs)
+	}
+	if !reflect.DeepEqual(wineResolver.puIDs, []string{"pu2", "pu2"}) {
+		t.Errorf("wine resolver handled %v, want [pu2 pu2]", wineResolver.puIDs)
+	}
+	if !reflect.DeepEqual(defaultResolver.puIDs, []string{"pu3", "pu3"}) {
+		t.Errorf("default resolver handled %v, want [pu3 pu3]", defaultResolver.puIDs)
+	}
+}
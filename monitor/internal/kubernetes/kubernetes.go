@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"go.aporeto.io/trireme-lib/utils/logging"
 	"go.uber.org/zap"
 	api "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -29,7 +30,7 @@ const UpstreamNameIdentifier = "k8s:name"
 const UpstreamNamespaceIdentifier = "k8s:namespace"
 
 func (m *KubernetesMonitor) addPod(addedPod *api.Pod) error {
-	zap.L().Debug("pod added event", zap.String("name", addedPod.GetName()), zap.String("namespace", addedPod.GetNamespace()))
+	logging.Named("kubernetes").Debug("pod added event", zap.String("name", addedPod.GetName()), zap.String("namespace", addedPod.GetNamespace()))
 
 	// This event is not needed as the trigger is the  DockerMonitor event
 	// The pod obejct is cached in order to reuse it and avoid an API request possibly laster on
@@ -38,16 +39,16 @@ func (m *KubernetesMonitor) addPod(addedPod *api.Pod) error {
 }
 
 func (m *KubernetesMonitor) deletePod(deletedPod *api.Pod) error {
-	zap.L().Debug("pod deleted event", zap.String("name", deletedPod.GetName()), zap.String("namespace", deletedPod.GetNamespace()))
+	logging.Named("kubernetes").Debug("pod deleted event", zap.String("name", deletedPod.GetName()), zap.String("namespace", deletedPod.GetNamespace()))
 
 	return nil
 }
 
 func (m *KubernetesMonitor) updatePod(oldPod, updatedPod *api.Pod) error {
-	zap.L().Debug("pod modified event", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
+	logging.Named("kubernetes").Debug("pod modified event", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
 
 	if !isPolicyUpdateNeeded(oldPod, updatedPod) {
-		zap.L().Debug("no modified labels for Pod", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
+		logging.Named("kubernetes").Debug("no modified labels for Pod", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
 		return nil
 	}
 
@@ -57,7 +58,7 @@ func (m *KubernetesMonitor) updatePod(oldPod, updatedPod *api.Pod) error {
 }
 
 func (m *KubernetesMonitor) getPod(podNamespace, podName string) (*api.Pod, error) {
-	zap.L().Debug("no pod cached, querying Kubernetes API")
+	logging.Named("kubernetes").Debug("no pod cached, querying Kubernetes API")
 
 	// TODO: Use cached Kube Store (from a shared informer)
 	return m.Pod(podName, podNamespace)
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 
-	"go.uber.org/zap"
 	"k8s.io/client-go/kubernetes"
 	kubecache "k8s.io/client-go/tools/cache"
 
@@ -13,6 +12,8 @@ import (
 
 	"go.aporeto.io/trireme-lib/monitor/config"
 	"go.aporeto.io/trireme-lib/monitor/registerer"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/logging"
 
 	dockermonitor "go.aporeto.io/trireme-lib/monitor/internal/docker"
 )
@@ -28,6 +29,7 @@ type KubernetesMonitor struct {
 	handlers            *config.ProcessorConfig
 	cache               *cache
 	kubernetesExtractor extractors.KubernetesMetadataExtractorType
+	namespaceResolvers  map[string]policy.Resolver
 
 	podStore          kubecache.Store
 	podController     kubecache.Controller
@@ -90,6 +92,7 @@ func (m *KubernetesMonitor) SetupConfig(registerer registerer.Registerer, cfg in
 
 	m.enableHostPods = kubernetesconfig.EnableHostPods
 	m.kubernetesExtractor = kubernetesconfig.KubernetesExtractor
+	m.namespaceResolvers = kubernetesconfig.NamespaceResolvers
 
 	m.podStore, m.podController = m.CreateLocalPodController("",
 		m.addPod,
@@ -98,7 +101,7 @@ func (m *KubernetesMonitor) SetupConfig(registerer registerer.Registerer, cfg in
 
 	m.podControllerStop = make(chan struct{})
 
-	zap.L().Debug("Pod Controller created")
+	logging.Named("kubernetes").Debug("Pod Controller created")
 
 	return nil
 }
@@ -123,6 +126,17 @@ func (m *KubernetesMonitor) Run(ctx context.Context) error {
 // by the consumer of the monitor
 func (m *KubernetesMonitor) SetupHandlers(c *config.ProcessorConfig) {
 	m.handlers = c
+
+	// The flows collected alongside the monitor are tagged with their pods
+	if podCollector, ok := c.Collector.(*collector.PodMetadataCollector); ok {
+		podCollector.SetPodResolver(m)
+	}
+}
+
+// PodByPUID returns the pod of a PU managed by the monitor. It is part of the
+// collector.PodResolver interface.
+func (m *KubernetesMonitor) PodByPUID(puID string) *collector.PodMetadata {
+	return m.cache.getPodByPUID(puID)
 }
 
 // Resync requests to the monitor to do a resync.
@@ -0,0 +1,105 @@
+package linuxmonitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+var (
+	// readExecutable returns the path of the executable that a process runs.
+	readExecutable = func(pid int) (string, error) {
+		return os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+	}
+
+	// executableTimeout is how long the monitor waits for a process to
+	// execute the executable of its event.
+	executableTimeout = 10 * time.Second
+
+	// executableInterval is how often the monitor checks the executable of a
+	// process while it waits.
+	executableInterval = 100 * time.Millisecond
+)
+
+// executableOf returns the path of the executable that a process runs, or an
+// empty string if it cannot be read.
+func executableOf(pid int) string {
+
+	executable, err := readExecutable(pid)
+	if err != nil || !filepath.IsAbs(executable) {
+		return ""
+	}
+
+	return executable
+}
+
+// setExecutableTag sets the executable tag of the runtime. The path claimed
+// in the event is never trusted: the executable is always the one that the
+// monitor read for the process.
+func setExecutableTag(runtime *policy.PURuntime, executable string) {
+
+	if executable == "" {
+		return
+	}
+
+	tags := runtime.Tags()
+	tags.AppendKeyValue(policy.ExecutableRuntimeTag, executable)
+	runtime.SetTags(tags)
+}
+
+// executablePending returns true if the process has not yet executed the
+// executable of its event. The run command sends the start event before it
+// executes the command, so the process still runs the run command.
+func executablePending(event *common.EventInfo, executable string) bool {
+
+	return event.Executable != "" && executable != "" && executable != filepath.Clean(event.Executable)
+}
+
+// waitExecutable waits for the process of a PU to execute another
+// executable and updates the runtime of the PU with its path. It gives up
+// if the process exits or does not execute anything before the timeout.
+func (l *linuxProcessor) waitExecutable(nativeID string, pid int, current string, runtime *policy.PURuntime) {
+
+	ticker := time.NewTicker(executableInterval)
+	defer ticker.Stop()
+
+	timeout := time.After(executableTimeout)
+
+	for {
+		select {
+		case <-timeout:
+			zap.L().Debug("Process did not execute its executable",
+				zap.String("puID", nativeID),
+				zap.Int("pid", pid),
+			)
+			return
+		case <-ticker.C:
+			executable := executableOf(pid)
+			if executable == "" {
+				return
+			}
+
+			if executable == current {
+				continue
+			}
+
+			updated := runtime.Clone()
+			setExecutableTag(updated, executable)
+
+			if err := l.config.Policy.HandlePUEvent(context.Background(), nativeID, common.EventUpdate, updated); err != nil {
+				zap.L().Warn("Unable to update the executable of the PU",
+					zap.String("puID", nativeID),
+					zap.Error(err),
+				)
+			}
+			return
+		}
+	}
+}
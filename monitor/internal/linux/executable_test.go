@@ -0,0 +1,194 @@
+package linuxmonitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/policy/mockpolicy"
+	"go.aporeto.io/trireme-lib/utils/cgnetcls/mockcgnetcls"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStartExecutable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a valid processor", t, func() {
+		puHandler := mockpolicy.NewMockResolver(ctrl)
+		p := testLinuxProcessor(puHandler)
+
+		mockcls := mockcgnetcls.NewMockCgroupnetcls(ctrl)
+		p.netcls = mockcls
+
+		oldReadExecutable := readExecutable
+		defer func() { readExecutable = oldReadExecutable }()
+
+		event := &common.EventInfo{
+			Name:       "PU",
+			PID:        1234,
+			PUID:       "12345",
+			Executable: "/usr/bin/curl",
+			EventType:  common.EventStart,
+			PUType:     common.LinuxProcessPU,
+		}
+
+		Convey("When the process already runs the executable of the event", func() {
+			readExecutable = func(pid int) (string, error) {
+				return "/usr/bin/curl", nil
+			}
+
+			Convey("I should start the PU with the executable", func() {
+				puHandler.EXPECT().HandlePUEvent(gomock.Any(), "12345", gomock.Any(), gomock.Any()).Times(2).Do(
+					func(ctx context.Context, puID string, event common.Event, runtime policy.RuntimeReader) {
+						executable, ok := runtime.Tag(policy.ExecutableRuntimeTag)
+						So(ok, ShouldBeTrue)
+						So(executable, ShouldEqual, "/usr/bin/curl")
+					}).Return(nil)
+				mockcls.EXPECT().Creategroup(gomock.Any()).Return(nil)
+				mockcls.EXPECT().AssignMark(gomock.Any(), gomock.Any()).Return(nil)
+				mockcls.EXPECT().AddProcess(gomock.Any(), gomock.Any())
+
+				err := p.Start(context.Background(), event)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestExecutablePending(t *testing.T) {
+
+	Convey("Given the event of the run command", t, func() {
+		event := &common.EventInfo{
+			Executable: "/usr/bin/../bin/curl",
+		}
+
+		Convey("The executable should be pending until the process runs it", func() {
+			So(executablePending(event, "/usr/bin/trireme"), ShouldBeTrue)
+			So(executablePending(event, "/usr/bin/curl"), ShouldBeFalse)
+		})
+
+		Convey("The executable should not be pending if the process is unknown", func() {
+			So(executablePending(event, ""), ShouldBeFalse)
+		})
+
+		Convey("The executable should not be pending for other events", func() {
+			So(executablePending(&common.EventInfo{}, "/usr/bin/trireme"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestWaitExecutable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a valid processor", t, func() {
+		puHandler := mockpolicy.NewMockResolver(ctrl)
+		p := testLinuxProcessor(puHandler)
+
+		oldReadExecutable := readExecutable
+		oldInterval := executableInterval
+		oldTimeout := executableTimeout
+		executableInterval = time.Millisecond
+		executableTimeout = 100 * time.Millisecond
+		defer func() {
+			readExecutable = oldReadExecutable
+			executableInterval = oldInterval
+			executableTimeout = oldTimeout
+		}()
+
+		runtime := policy.NewPURuntimeWithDefaults()
+
+		Convey("When the process executes another executable", func() {
+			calls := 0
+			readExecutable = func(pid int) (string, error) {
+				calls++
+				if calls < 3 {
+					return "/usr/bin/trireme", nil
+				}
+				return "/usr/bin/curl", nil
+			}
+
+			Convey("I should update the PU with the executable", func() {
+				puHandler.EXPECT().HandlePUEvent(gomock.Any(), "12345", common.EventUpdate, gomock.Any()).Do(
+					func(ctx context.Context, puID string, event common.Event, runtime policy.RuntimeReader) {
+						executable, ok := runtime.Tag(policy.ExecutableRuntimeTag)
+						So(ok, ShouldBeTrue)
+						So(executable, ShouldEqual, "/usr/bin/curl")
+					}).Return(nil)
+
+				p.waitExecutable("12345", 1234, "/usr/bin/trireme", runtime)
+
+				_, ok := runtime.Tag(policy.ExecutableRuntimeTag)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the process exits", func() {
+			readExecutable = func(pid int) (string, error) {
+				return "", errors.New("no such process")
+			}
+
+			Convey("I should not update the PU", func() {
+				p.waitExecutable("12345", 1234, "/usr/bin/trireme", runtime)
+			})
+		})
+
+		Convey("When the process does not execute anything", func() {
+			readExecutable = func(pid int) (string, error) {
+				return "/usr/bin/trireme", nil
+			}
+
+			Convey("I should not update the PU", func() {
+				p.waitExecutable("12345", 1234, "/usr/bin/trireme", runtime)
+			})
+		})
+	})
+}
+
+func TestResyncExecutable(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a valid processor", t, func() {
+		puHandler := mockpolicy.NewMockResolver(ctrl)
+		p := testLinuxProcessor(puHandler)
+
+		mockcls := mockcgnetcls.NewMockCgroupnetcls(ctrl)
+		p.netcls = mockcls
+
+		oldReadExecutable := readExecutable
+		defer func() { readExecutable = oldReadExecutable }()
+
+		Convey("When I resync a cgroup", func() {
+			readExecutable = func(pid int) (string, error) {
+				if pid != 100 {
+					return "", errors.New("no such process")
+				}
+				return "/usr/bin/curl", nil
+			}
+
+			Convey("I should restart the PU with the executable of its process", func() {
+				mockcls.EXPECT().ListAllCgroups(gomock.Any()).Return([]string{"cgroup"}, nil)
+				mockcls.EXPECT().ListCgroupProcesses("cgroup").Return([]string{"100"}, nil)
+				mockcls.EXPECT().MarkVal().Return(uint64(101))
+				mockcls.EXPECT().Creategroup(gomock.Any()).Return(nil)
+				mockcls.EXPECT().AssignMark(gomock.Any(), gomock.Any()).Return(nil)
+				puHandler.EXPECT().HandlePUEvent(gomock.Any(), "cgroup", common.EventStart, gomock.Any()).Do(
+					func(ctx context.Context, puID string, event common.Event, runtime policy.RuntimeReader) {
+						executable, ok := runtime.Tag(policy.ExecutableRuntimeTag)
+						So(ok, ShouldBeTrue)
+						So(executable, ShouldEqual, "/usr/bin/curl")
+					}).Return(nil)
+
+				err := p.Resync(context.Background(), nil)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -32,6 +32,10 @@ func (l *LinuxMonitor) Run(ctx context.Context) error {
 		return fmt.Errorf("linux %t: %s", l.proc.host, err)
 	}
 
+	if err := l.proc.watcher.Run(ctx); err != nil {
+		return err
+	}
+
 	if err := l.Resync(ctx); err != nil {
 		return err
 	}
@@ -65,6 +69,7 @@ func (l *LinuxMonitor) SetupConfig(registerer registerer.Registerer, cfg interfa
 	// Setup config
 	l.proc.host = linuxConfig.Host
 	l.proc.netcls = cgnetcls.NewCgroupNetController(common.TriremeCgroupPath, linuxConfig.ReleasePath)
+	l.proc.watcher = cgnetcls.NewMembershipWatcher(common.TriremeCgroupPath, l.proc.membershipChanged)
 
 	l.proc.regStart = regexp.MustCompile("^[a-zA-Z0-9_].{0,11}$")
 	l.proc.regStop = regexp.MustCompile("^/trireme/[a-zA-Z0-9_].{0,11}$")
@@ -36,6 +36,7 @@ type linuxProcessor struct {
 	config            *config.ProcessorConfig
 	metadataExtractor extractors.EventMetadataExtractor
 	netcls            cgnetcls.Cgroupnetcls
+	watcher           cgnetcls.MembershipWatcher
 	regStart          *regexp.Regexp
 	regStop           *regexp.Regexp
 	sync.Mutex
@@ -77,6 +78,14 @@ func (l *linuxProcessor) Start(ctx context.Context, eventInfo *common.EventInfo)
 		return err
 	}
 
+	// The run command starts the PU before it executes the command. The
+	// executable is then only known once the process has executed it.
+	executable := executableOf(int(eventInfo.PID))
+	pending := executablePending(eventInfo, executable)
+	if !pending {
+		setExecutableTag(runtime, executable)
+	}
+
 	// We need to send a create event to the policy engine.
 	if err = l.config.Policy.HandlePUEvent(ctx, nativeID, common.EventCreate, runtime); err != nil {
 		return fmt.Errorf("Unable to create PU: %s", err)
@@ -107,6 +116,10 @@ func (l *linuxProcessor) Start(ctx context.Context, eventInfo *common.EventInfo)
 		Event:     collector.ContainerStart,
 	})
 
+	if pending {
+		go l.waitExecutable(nativeID, int(eventInfo.PID), executable, runtime.Clone())
+	}
+
 	return nil
 }
 
@@ -122,6 +135,13 @@ func (l *linuxProcessor) Stop(ctx context.Context, event *common.EventInfo) erro
 		return nil
 	}
 
+	// A restarted service keeps its cgroup. Only stop the PU when the
+	// cgroup has no member processes left.
+	if l.cgroupActive(puID) {
+		zap.L().Debug("Ignoring stop event for active cgroup", zap.String("puID", puID))
+		return nil
+	}
+
 	runtime := policy.NewPURuntimeWithDefaults()
 	runtime.SetPUType(common.LinuxProcessPU)
 
@@ -142,6 +162,11 @@ func (l *linuxProcessor) Destroy(ctx context.Context, eventInfo *common.EventInf
 		return nil
 	}
 
+	if !eventInfo.HostService && l.cgroupActive(puID) {
+		zap.L().Debug("Ignoring destroy event for active cgroup", zap.String("puID", puID))
+		return nil
+	}
+
 	runtime := policy.NewPURuntimeWithDefaults()
 	runtime.SetPUType(common.LinuxProcessPU)
 
@@ -171,9 +196,34 @@ func (l *linuxProcessor) Destroy(ctx context.Context, eventInfo *common.EventInf
 		)
 	}
 
+	l.watcher.Unwatch(puID)
+
 	return nil
 }
 
+// membershipChanged stops and destroys the PU as soon as the last process of
+// its cgroup exits, without waiting for the release agent.
+func (l *linuxProcessor) membershipChanged(ctx context.Context, cgroupname string, members int) {
+
+	if members > 0 {
+		return
+	}
+
+	event := &common.EventInfo{
+		PUID:   cgroupname,
+		Cgroup: common.TriremeCgroupPath + cgroupname,
+		PUType: common.LinuxProcessPU,
+	}
+
+	if err := l.Stop(ctx, event); err != nil {
+		zap.L().Debug("Unable to stop PU of empty cgroup", zap.String("cgroup", cgroupname), zap.Error(err))
+	}
+
+	if err := l.Destroy(ctx, event); err != nil {
+		zap.L().Debug("Unable to destroy PU of empty cgroup", zap.String("cgroup", cgroupname), zap.Error(err))
+	}
+}
+
 // Pause handles a pause event
 func (l *linuxProcessor) Pause(ctx context.Context, eventInfo *common.EventInfo) error {
 
@@ -192,6 +242,8 @@ func (l *linuxProcessor) resyncHostService(ctx context.Context, e *common.EventI
 		return err
 	}
 
+	setExecutableTag(runtime, executableOf(int(e.PID)))
+
 	nativeID, err := l.generateContextID(e)
 	if err != nil {
 		return err
@@ -215,7 +267,11 @@ func (l *linuxProcessor) Resync(ctx context.Context, e *common.EventInfo) error
 		}
 	}
 
-	cgroups := l.netcls.ListAllCgroups("")
+	cgroups, err := l.netcls.ListAllCgroups("")
+	if err != nil {
+		return err
+	}
+
 	for _, cgroup := range cgroups {
 		if _, ok := ignoreNames[cgroup]; ok {
 			continue
@@ -241,11 +297,15 @@ func (l *linuxProcessor) Resync(ctx context.Context, e *common.EventInfo) error
 		runtime := policy.NewPURuntimeWithDefaults()
 		runtime.SetPUType(common.LinuxProcessPU)
 		runtime.SetOptions(policy.OptionsType{
-			CgroupMark: strconv.FormatUint(cgnetcls.MarkVal(), 10),
+			CgroupMark: strconv.FormatUint(l.netcls.MarkVal(), 10),
 			CgroupName: cgroup,
 			ProxyPort:  strconv.Itoa(l.config.ApplicationProxyPort),
 		})
 
+		if pid, err := strconv.Atoi(procs[0]); err == nil {
+			setExecutableTag(runtime, executableOf(pid))
+		}
+
 		// Processes are still alive. We should enforce policy.
 		if err := l.config.Policy.HandlePUEvent(ctx, cgroup, common.EventStart, runtime); err != nil {
 			zap.L().Error("Failed to restart cgroup control", zap.String("cgroup ID", cgroup), zap.Error(err))
@@ -258,6 +318,19 @@ func (l *linuxProcessor) Resync(ctx context.Context, e *common.EventInfo) error
 	return nil
 }
 
+// cgroupActive returns true if the cgroup of the PU still has member
+// processes. This is the case when a service is restarted within the
+// same cgroup and only its PID changes.
+func (l *linuxProcessor) cgroupActive(puID string) bool {
+
+	procs, err := l.netcls.ListCgroupProcesses(baseName(puID, "/"))
+	if err != nil {
+		return false
+	}
+
+	return len(procs) > 0
+}
+
 // generateContextID creates the puID from the event information
 func (l *linuxProcessor) generateContextID(eventInfo *common.EventInfo) (string, error) {
 
@@ -309,6 +382,10 @@ func (l *linuxProcessor) processLinuxServiceStart(nativeID string, event *common
 		}
 	}
 
+	if err := l.watcher.Watch(nativeID); err != nil {
+		zap.L().Warn("Unable to watch cgroup", zap.String("cgroup", nativeID), zap.Error(err))
+	}
+
 	return nil
 }
 
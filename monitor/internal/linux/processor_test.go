@@ -74,13 +74,15 @@ func TestStop(t *testing.T) {
 		puHandler := mockpolicy.NewMockResolver(ctrl)
 
 		p := testLinuxProcessor(puHandler)
-		p.netcls = mockcgnetcls.NewMockCgroupnetcls(ctrl)
+		mockcls := mockcgnetcls.NewMockCgroupnetcls(ctrl)
+		p.netcls = mockcls
 
 		Convey("When I get a stop event that is valid", func() {
 			event := &common.EventInfo{
 				PUID: "/trireme/1234",
 			}
 
+			mockcls.EXPECT().ListCgroupProcesses("1234").Return([]string{}, nil)
 			puHandler.EXPECT().HandlePUEvent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 			Convey("I should get the status of the upstream function", func() {
 				err := p.Stop(context.Background(), event)
@@ -88,6 +90,18 @@ func TestStop(t *testing.T) {
 			})
 		})
 
+		Convey("When I get a stop event for a cgroup that still has processes", func() {
+			event := &common.EventInfo{
+				PUID: "/trireme/1234",
+			}
+
+			mockcls.EXPECT().ListCgroupProcesses("1234").Return([]string{"100"}, nil)
+			Convey("I should not stop the PU", func() {
+				err := p.Stop(context.Background(), event)
+				So(err, ShouldBeNil)
+			})
+		})
+
 	})
 }
 
@@ -111,6 +125,7 @@ func TestDestroy(t *testing.T) {
 			event := &common.EventInfo{
 				PUID: "/trireme/1234",
 			}
+			mockcls.EXPECT().ListCgroupProcesses("1234").Return(nil, errors.New("no cgroup"))
 			mockcls.EXPECT().DeleteCgroup(gomock.Any()).Return(nil)
 
 			puHandler.EXPECT().HandlePUEvent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
@@ -120,6 +135,51 @@ func TestDestroy(t *testing.T) {
 			})
 		})
 
+		Convey("When I get a destroy event for a cgroup that still has processes", func() {
+			event := &common.EventInfo{
+				PUID: "/trireme/1234",
+			}
+			mockcls.EXPECT().ListCgroupProcesses("1234").Return([]string{"100"}, nil)
+
+			Convey("I should not destroy the PU or its cgroup", func() {
+				err := p.Destroy(context.Background(), event)
+				So(err, ShouldBeNil)
+			})
+		})
+
+	})
+}
+
+func TestMembershipChanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a valid processor", t, func() {
+		puHandler := mockpolicy.NewMockResolver(ctrl)
+
+		p := testLinuxProcessor(puHandler)
+		mockcls := mockcgnetcls.NewMockCgroupnetcls(ctrl)
+		p.netcls = mockcls
+		mockwatcher := mockcgnetcls.NewMockMembershipWatcher(ctrl)
+		p.watcher = mockwatcher
+
+		Convey("When the last process of a cgroup exits", func() {
+			mockcls.EXPECT().ListCgroupProcesses("1234").Return([]string{}, nil).Times(2)
+			puHandler.EXPECT().HandlePUEvent(gomock.Any(), "1234", common.EventStop, gomock.Any()).Return(nil)
+			puHandler.EXPECT().HandlePUEvent(gomock.Any(), "1234", common.EventDestroy, gomock.Any()).Return(nil)
+			mockcls.EXPECT().DeleteCgroup("1234").Return(nil)
+			mockwatcher.EXPECT().Unwatch("1234")
+
+			Convey("I should stop and destroy the PU", func() {
+				p.membershipChanged(context.Background(), "1234", 0)
+			})
+		})
+
+		Convey("When the cgroup still has processes", func() {
+			Convey("I should not stop the PU", func() {
+				p.membershipChanged(context.Background(), "1234", 1)
+			})
+		})
 	})
 }
 
@@ -266,8 +326,9 @@ func TestResync(t *testing.T) {
 			p.netcls = mockcls
 
 			Convey("I should not get an error ", func() {
-				mockcls.EXPECT().ListAllCgroups(gomock.Any()).Return([]string{"cgroup"})
+				mockcls.EXPECT().ListAllCgroups(gomock.Any()).Return([]string{"cgroup"}, nil)
 				mockcls.EXPECT().ListCgroupProcesses(gomock.Any()).Return([]string{"procs"}, nil)
+				mockcls.EXPECT().MarkVal().Return(uint64(101))
 				mockcls.EXPECT().Creategroup(gomock.Any()).Return(nil)
 				mockcls.EXPECT().AssignMark(gomock.Any(), gomock.Any()).Return(nil)
 				puHandler.EXPECT().HandlePUEvent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
@@ -276,6 +337,17 @@ func TestResync(t *testing.T) {
 			})
 		})
 
+		Convey("When I get a resync event and the cgroups can't be listed", func() {
+			mockcls := mockcgnetcls.NewMockCgroupnetcls(ctrl)
+			p.netcls = mockcls
+
+			Convey("I should get an error ", func() {
+				mockcls.EXPECT().ListAllCgroups(gomock.Any()).Return([]string{}, errors.New("error"))
+				err := p.Resync(context.Background(), nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
 		Convey("When I get a resync event with no croup process", func() {
 			event := &common.EventInfo{
 				Name:      "PU",
@@ -289,7 +361,7 @@ func TestResync(t *testing.T) {
 			p.netcls = mockcls
 
 			Convey("I should not get an error ", func() {
-				mockcls.EXPECT().ListAllCgroups(gomock.Any()).Return([]string{"cgroup"})
+				mockcls.EXPECT().ListAllCgroups(gomock.Any()).Return([]string{"cgroup"}, nil)
 				mockcls.EXPECT().ListCgroupProcesses(gomock.Any()).Return([]string{}, nil)
 				mockcls.EXPECT().DeleteCgroup(gomock.Any()).Return(nil)
 				err := p.Resync(context.Background(), event)
@@ -33,6 +33,10 @@ func (u *UIDMonitor) Run(ctx context.Context) error {
 		return fmt.Errorf("uid: %s", err)
 	}
 
+	if err := u.proc.watcher.Run(ctx); err != nil {
+		return err
+	}
+
 	if err := u.Resync(ctx); err != nil {
 		return err
 	}
@@ -65,6 +69,7 @@ func (u *UIDMonitor) SetupConfig(registerer registerer.Registerer, cfg interface
 
 	// Setup config
 	u.proc.netcls = cgnetcls.NewCgroupNetController(common.TriremeUIDCgroupPath, uidConfig.ReleasePath)
+	u.proc.watcher = cgnetcls.NewMembershipWatcher(common.TriremeUIDCgroupPath, u.proc.membershipChanged)
 	u.proc.regStart = regexp.MustCompile("^[a-zA-Z0-9_].{0,11}$")
 	u.proc.regStop = regexp.MustCompile("^/trireme/[a-zA-Z0-9_].{0,11}$")
 	u.proc.putoPidMap = cache.NewCache("putoPidMap")
@@ -35,6 +35,7 @@ type uidProcessor struct {
 	config            *config.ProcessorConfig
 	metadataExtractor extractors.EventMetadataExtractor
 	netcls            cgnetcls.Cgroupnetcls
+	watcher           cgnetcls.MembershipWatcher
 	regStart          *regexp.Regexp
 	regStop           *regexp.Regexp
 	putoPidMap        *cache.Cache
@@ -87,6 +88,8 @@ func (u *uidProcessor) Stop(ctx context.Context, eventInfo *common.EventInfo) er
 			return err
 		}
 
+		u.watcher.Unwatch(puID)
+
 		if pidlist, err := u.putoPidMap.Get(userID); err == nil {
 			pidCxt := pidlist.(*puToPidEntry)
 
@@ -152,14 +155,22 @@ func (u *uidProcessor) Pause(ctx context.Context, eventInfo *common.EventInfo) e
 // Resync resyncs with all the existing services that were there before we start
 func (u *uidProcessor) Resync(ctx context.Context, e *common.EventInfo) error {
 
-	uids := u.netcls.ListAllCgroups("")
+	uids, err := u.netcls.ListAllCgroups("")
+	if err != nil {
+		return err
+	}
+
 	for _, uid := range uids {
 
 		if _, ok := ignoreNames[uid]; ok {
 			continue
 		}
 
-		processesOfUID := u.netcls.ListAllCgroups(uid)
+		processesOfUID, err := u.netcls.ListAllCgroups(uid)
+		if err != nil {
+			zap.L().Warn("Unable to list cgroups of user", zap.String("user", uid), zap.Error(err))
+			continue
+		}
 		activePids := []int32{}
 
 		for _, pid := range processesOfUID {
@@ -289,8 +300,45 @@ func (u *uidProcessor) createAndStart(ctx context.Context, eventInfo *common.Eve
 
 	pidPath := puID + "/" + strconv.Itoa(int(eventInfo.PID))
 
-	return u.processLinuxServiceStart(pidPath, eventInfo, pids.(*puToPidEntry).Info)
+	if err := u.processLinuxServiceStart(pidPath, eventInfo, pids.(*puToPidEntry).Info); err != nil {
+		return err
+	}
+
+	if err := u.watcher.Watch(pidPath); err != nil {
+		zap.L().Warn("Unable to watch cgroup", zap.String("cgroup", pidPath), zap.Error(err))
+	}
+
+	return nil
+}
+
+// membershipChanged stops the pid as soon as the last process of its cgroup
+// exits, and the user once it has no pid left, without waiting for the
+// release agent.
+func (u *uidProcessor) membershipChanged(ctx context.Context, cgroupname string, members int) {
+
+	if members > 0 {
+		return
+	}
+
+	if err := u.Stop(ctx, &common.EventInfo{PUID: cgroupname}); err != nil {
+		zap.L().Debug("Unable to stop pid of empty cgroup", zap.String("cgroup", cgroupname), zap.Error(err))
+		return
+	}
 
+	userID := strings.SplitN(cgroupname, "/", 2)[0]
+
+	u.Lock()
+	entry, err := u.putoPidMap.Get(userID)
+	active := err != nil || len(entry.(*puToPidEntry).pidlist) > 0
+	u.Unlock()
+
+	if active {
+		return
+	}
+
+	if err := u.Stop(ctx, &common.EventInfo{PUID: userID}); err != nil {
+		zap.L().Debug("Unable to stop user without pids", zap.String("user", userID), zap.Error(err))
+	}
 }
 
 func (u *uidProcessor) processLinuxServiceStart(pidName string, event *common.EventInfo, runtimeInfo *policy.PURuntime) error {
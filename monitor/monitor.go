@@ -63,6 +63,9 @@ func NewMonitors(opts ...Options) (Monitor, error) {
 			if err := mon.SetupConfig(m.registerer, v); err != nil {
 				return nil, fmt.Errorf("CNI: %s", err.Error())
 			}
+			if cfg, ok := v.(*cnimonitor.Config); ok && cfg != nil {
+				validateExtractor("cni", cfg.EventMetadataExtractor, common.ContainerPU)
+			}
 			m.monitors[config.CNI] = mon
 
 		case config.Docker:
@@ -71,6 +74,9 @@ func NewMonitors(opts ...Options) (Monitor, error) {
 			if err := mon.SetupConfig(nil, v); err != nil {
 				return nil, fmt.Errorf("Docker: %s", err.Error())
 			}
+			if cfg, ok := v.(*dockermonitor.Config); ok && cfg != nil {
+				validateDockerExtractor(cfg.EventMetadataExtractor)
+			}
 			m.monitors[config.Docker] = mon
 
 		case config.Kubernetes:
@@ -79,6 +85,9 @@ func NewMonitors(opts ...Options) (Monitor, error) {
 			if err := mon.SetupConfig(nil, v); err != nil {
 				return nil, fmt.Errorf("kubernetes: %s", err.Error())
 			}
+			if cfg, ok := v.(*kubernetesmonitor.Config); ok && cfg != nil {
+				validateKubernetesExtractors(cfg.DockerExtractor, cfg.KubernetesExtractor)
+			}
 			m.monitors[config.Kubernetes] = mon
 
 		case config.LinuxProcess:
@@ -87,6 +96,9 @@ func NewMonitors(opts ...Options) (Monitor, error) {
 			if err := mon.SetupConfig(m.registerer, v); err != nil {
 				return nil, fmt.Errorf("Process: %s", err.Error())
 			}
+			if cfg, ok := v.(*linuxmonitor.Config); ok && cfg != nil {
+				validateExtractor("process", cfg.EventMetadataExtractor, common.LinuxProcessPU)
+			}
 			m.monitors[config.LinuxProcess] = mon
 
 		case config.LinuxHost:
@@ -95,6 +107,9 @@ func NewMonitors(opts ...Options) (Monitor, error) {
 			if err := mon.SetupConfig(m.registerer, v); err != nil {
 				return nil, fmt.Errorf("Host: %s", err.Error())
 			}
+			if cfg, ok := v.(*linuxmonitor.Config); ok && cfg != nil {
+				validateExtractor("host", cfg.EventMetadataExtractor, common.LinuxProcessPU)
+			}
 			m.monitors[config.LinuxHost] = mon
 
 		case config.UID:
@@ -103,6 +118,9 @@ func NewMonitors(opts ...Options) (Monitor, error) {
 			if err := mon.SetupConfig(m.registerer, v); err != nil {
 				return nil, fmt.Errorf("UID: %s", err.Error())
 			}
+			if cfg, ok := v.(*uidmonitor.Config); ok && cfg != nil {
+				validateExtractor("uid", cfg.EventMetadataExtractor, common.UIDLoginPU)
+			}
 			m.monitors[config.UID] = mon
 
 		default:
@@ -1,6 +1,8 @@
 package monitor
 
 import (
+	"time"
+
 	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/monitor/config"
 	"go.aporeto.io/trireme-lib/monitor/extractors"
@@ -119,6 +121,12 @@ func SubOptionMonitorDockerExtractor(extractor extractors.DockerMetadataExtracto
 	}
 }
 
+// SubOptionMonitorDockerExtractors provides a way to specify a chain of metadata
+// extractors for docker. The extractors run in order and their tags are merged.
+func SubOptionMonitorDockerExtractors(extractorList ...extractors.DockerMetadataExtractor) DockerMonitorOption {
+	return SubOptionMonitorDockerExtractor(extractors.ChainDockerExtractors(extractorList...))
+}
+
 // SubOptionMonitorDockerSocket provides a way to specify socket info for docker.
 func SubOptionMonitorDockerSocket(socketType, socketAddress string) DockerMonitorOption {
 	return func(cfg *dockermonitor.Config) {
@@ -135,6 +143,24 @@ func SubOptionMonitorDockerFlags(syncAtStart, killContainerOnPolicyError bool) D
 	}
 }
 
+// SubOptionMonitorDockerSyncConcurrency provides a way to limit the number of
+// containers that are synced in parallel when the monitor starts.
+func SubOptionMonitorDockerSyncConcurrency(n int) DockerMonitorOption {
+	return func(cfg *dockermonitor.Config) {
+		cfg.SyncConcurrency = n
+	}
+}
+
+// SubOptionMonitorDockerReconnect provides a way to specify the initial backoff
+// before reconnecting to the docker event stream and whether the containers are
+// resynced after a reconnection.
+func SubOptionMonitorDockerReconnect(backoff time.Duration, resyncOnReconnect bool) DockerMonitorOption {
+	return func(cfg *dockermonitor.Config) {
+		cfg.ReconnectBackoff = backoff
+		cfg.ResyncOnReconnect = resyncOnReconnect
+	}
+}
+
 // OptionMonitorDocker provides a way to add a docker monitor and related configuration to be used with New().
 func OptionMonitorDocker(opts ...DockerMonitorOption) Options {
 
@@ -192,6 +218,24 @@ func SubOptionMonitorKubernetesExtractor(extractor extractors.KubernetesMetadata
 	}
 }
 
+// SubOptionMonitorKubernetesExtractors provides a way to specify a chain of metadata
+// extractors for Kubernetes. The extractors run in order and their tags are merged.
+func SubOptionMonitorKubernetesExtractors(extractorList ...extractors.KubernetesMetadataExtractorType) KubernetesMonitorOption {
+	return SubOptionMonitorKubernetesExtractor(extractors.ChainKubernetesExtractors(extractorList...))
+}
+
+// SubOptionMonitorKubernetesNamespaceResolver provides a way to specify the policy
+// resolver of the PUs of a namespace. The PUs of the namespaces without their own
+// resolver are resolved by the resolver set with OptionPolicyResolver.
+func SubOptionMonitorKubernetesNamespaceResolver(namespace string, resolver policy.Resolver) KubernetesMonitorOption {
+	return func(cfg *kubernetesmonitor.Config) {
+		if cfg.NamespaceResolvers == nil {
+			cfg.NamespaceResolvers = map[string]policy.Resolver{}
+		}
+		cfg.NamespaceResolvers[namespace] = resolver
+	}
+}
+
 // SubOptionMonitorKubernetesDockerExtractor provides a way to specify metadata extractor for docker.
 func SubOptionMonitorKubernetesDockerExtractor(extractor extractors.DockerMetadataExtractor) KubernetesMonitorOption {
 	return func(cfg *kubernetesmonitor.Config) {
@@ -199,6 +243,12 @@ func SubOptionMonitorKubernetesDockerExtractor(extractor extractors.DockerMetada
 	}
 }
 
+// SubOptionMonitorKubernetesDockerExtractors provides a way to specify a chain of
+// metadata extractors for docker. The extractors run in order and their tags are merged.
+func SubOptionMonitorKubernetesDockerExtractors(extractorList ...extractors.DockerMetadataExtractor) KubernetesMonitorOption {
+	return SubOptionMonitorKubernetesDockerExtractor(extractors.ChainDockerExtractors(extractorList...))
+}
+
 // OptionMergeTags provides a way to add merge tags to be used with New().
 func OptionMergeTags(tags []string) Options {
 	return func(cfg *config.MonitorConfig) {
@@ -229,6 +279,15 @@ func OptionPolicyResolver(p policy.Resolver) Options {
 	}
 }
 
+// OptionCachingPolicyResolver provides a way to add to the monitors a policy
+// resolver that resolves the policies through a cache and applies them. The
+// events are also passed to next, which may be nil.
+func OptionCachingPolicyResolver(next policy.Resolver, cache *policy.ResolverCache, apply policy.PolicyApplier) Options {
+	return func(cfg *config.MonitorConfig) {
+		cfg.Common.Policy = policy.NewCachingResolver(next, cache, apply)
+	}
+}
+
 // NewMonitor provides a configuration for monitors.
 func NewMonitor(opts ...Options) *config.MonitorConfig {
 
@@ -0,0 +1,268 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/monitor/extractors"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/cgnetcls"
+	"go.uber.org/zap"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ValidateExtractor runs the extractor against a sample event and verifies
+// that the returned runtime carries the fields required by enforcement. It
+// returns a descriptive error if the extractor fails or the runtime is incomplete.
+func ValidateExtractor(extractor extractors.EventMetadataExtractor, event *common.EventInfo) error {
+
+	if extractor == nil {
+		return fmt.Errorf("no metadata extractor provided")
+	}
+
+	if event == nil {
+		return fmt.Errorf("no sample event provided")
+	}
+
+	first := cgnetcls.NextMark()
+
+	runtime, err := extractor(event)
+	if err != nil {
+		return fmt.Errorf("extractor failed on sample event %s: %s", event.PUID, err)
+	}
+
+	defer releaseMark(runtime, first)
+
+	return validateRuntime(runtime, "event "+event.PUID)
+}
+
+// ValidateDockerExtractor runs the Docker extractor against a sample
+// container and verifies the returned runtime like ValidateExtractor.
+func ValidateDockerExtractor(extractor extractors.DockerMetadataExtractor, info *types.ContainerJSON) error {
+
+	if extractor == nil {
+		return fmt.Errorf("no metadata extractor provided")
+	}
+
+	if info == nil || info.ContainerJSONBase == nil {
+		return fmt.Errorf("no sample container provided")
+	}
+
+	first := cgnetcls.NextMark()
+
+	runtime, err := extractor(info)
+	if err != nil {
+		return fmt.Errorf("extractor failed on sample container %s: %s", info.Name, err)
+	}
+
+	defer releaseMark(runtime, first)
+
+	return validateRuntime(runtime, "container "+info.Name)
+}
+
+// ValidateKubernetesExtractor runs the Kubernetes extractor against the
+// runtime of a sample container and a sample pod, and verifies the returned
+// runtime like ValidateExtractor. A runtime that is not activated is valid.
+func ValidateKubernetesExtractor(extractor extractors.KubernetesMetadataExtractorType, runtime policy.RuntimeReader, pod *api.Pod) error {
+
+	if extractor == nil {
+		return fmt.Errorf("no metadata extractor provided")
+	}
+
+	if runtime == nil || pod == nil {
+		return fmt.Errorf("no sample pod provided")
+	}
+
+	first := cgnetcls.NextMark()
+
+	podRuntime, activate, err := extractor(runtime, pod)
+	if err != nil {
+		return fmt.Errorf("extractor failed on sample pod %s: %s", pod.GetName(), err)
+	}
+
+	defer releaseMark(podRuntime, first)
+
+	if !activate {
+		return nil
+	}
+
+	return validateRuntime(podRuntime, "pod "+pod.GetName())
+}
+
+// validateRuntime verifies that a runtime carries the fields required by
+// enforcement.
+func validateRuntime(runtime *policy.PURuntime, sample string) error {
+
+	if runtime == nil {
+		return fmt.Errorf("extractor returned no runtime for sample %s", sample)
+	}
+
+	if runtime.Name() == "" {
+		return fmt.Errorf("extractor returned a runtime without a name for sample %s", sample)
+	}
+
+	switch runtime.PUType() {
+	case common.ContainerPU, common.LinuxProcessPU, common.KubernetesPU, common.UIDLoginPU:
+	default:
+		return fmt.Errorf("extractor returned a runtime with unsupported pu type %d for sample %s", runtime.PUType(), sample)
+	}
+
+	if runtime.Tags() == nil {
+		return fmt.Errorf("extractor returned a runtime without tags for sample %s", sample)
+	}
+
+	return nil
+}
+
+// releaseMark returns the cgroup mark that an extractor allocated for a
+// sample runtime, so that validating an extractor does not use up marks.
+// Only a mark allocated after first is released.
+func releaseMark(runtime *policy.PURuntime, first uint64) {
+
+	if runtime == nil {
+		return
+	}
+
+	mark, err := strconv.ParseUint(runtime.Options().CgroupMark, 10, 64)
+	if err != nil || mark < first {
+		return
+	}
+
+	cgnetcls.ReleaseMark(mark)
+}
+
+// sampleEvent returns a representative event for the given PU type that is
+// used to dry-run the configured metadata extractors.
+func sampleEvent(puType common.PUType) *common.EventInfo {
+
+	return &common.EventInfo{
+		EventType: common.EventStart,
+		PUType:    puType,
+		PUID:      "trireme-validation",
+		Name:      "trireme-validation",
+		Tags:      []string{"app=trireme-validation"},
+		NS:        "/var/run/netns/trireme-validation",
+		PID:       int32(os.Getpid()),
+	}
+}
+
+// sampleContainer returns a representative container that is used to
+// dry-run the configured Docker metadata extractors. The container of a pod
+// is the infra container of the pod.
+func sampleContainer(pod bool) *types.ContainerJSON {
+
+	labels := map[string]string{"app": "trireme-validation"}
+	if pod {
+		labels["io.kubernetes.pod.name"] = "trireme-validation"
+		labels["io.kubernetes.pod.namespace"] = "default"
+		labels["io.kubernetes.container.name"] = extractors.KubernetesInfraContainerName
+	}
+
+	return &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   "trireme-validation",
+			Name: "/trireme-validation",
+			State: &types.ContainerState{
+				Running: true,
+				Pid:     os.Getpid(),
+			},
+			HostConfig: &container.HostConfig{
+				NetworkMode: "bridge",
+			},
+		},
+		Config: &container.Config{
+			Image:  "trireme-validation",
+			Labels: labels,
+		},
+		NetworkSettings: &types.NetworkSettings{
+			DefaultNetworkSettings: types.DefaultNetworkSettings{
+				IPAddress: "172.17.0.2",
+			},
+		},
+	}
+}
+
+// samplePod returns a representative pod that is used to dry-run the
+// configured Kubernetes metadata extractors.
+func samplePod() *api.Pod {
+
+	return &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "trireme-validation",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "trireme-validation"},
+		},
+	}
+}
+
+// validateExtractor dry-runs the extractor of a monitor and logs a warning if
+// it fails. A failed validation is not fatal for the monitor.
+func validateExtractor(monitor string, extractor extractors.EventMetadataExtractor, puType common.PUType) {
+
+	if extractor == nil {
+		return
+	}
+
+	if err := ValidateExtractor(extractor, sampleEvent(puType)); err != nil {
+		logValidationError(monitor, err)
+	}
+}
+
+// validateDockerExtractor dry-runs the extractor of the Docker monitor and
+// logs a warning if it fails.
+func validateDockerExtractor(extractor extractors.DockerMetadataExtractor) {
+
+	if extractor == nil {
+		return
+	}
+
+	if err := ValidateDockerExtractor(extractor, sampleContainer(false)); err != nil {
+		logValidationError("docker", err)
+	}
+}
+
+// validateKubernetesExtractors dry-runs the extractors of the Kubernetes
+// monitor and logs a warning if they fail. The Kubernetes extractor is run
+// with the runtime that the Docker extractor returns for the infra container
+// of the sample pod.
+func validateKubernetesExtractors(dockerExtractor extractors.DockerMetadataExtractor, kubernetesExtractor extractors.KubernetesMetadataExtractorType) {
+
+	if dockerExtractor == nil || kubernetesExtractor == nil {
+		return
+	}
+
+	info := sampleContainer(true)
+	first := cgnetcls.NextMark()
+
+	runtime, err := dockerExtractor(info)
+	if err != nil {
+		logValidationError("kubernetes", fmt.Errorf("extractor failed on sample container %s: %s", info.Name, err))
+		return
+	}
+
+	defer releaseMark(runtime, first)
+
+	if err := validateRuntime(runtime, "container "+info.Name); err != nil {
+		logValidationError("kubernetes", err)
+		return
+	}
+
+	if err := ValidateKubernetesExtractor(kubernetesExtractor, runtime, samplePod()); err != nil {
+		logValidationError("kubernetes", err)
+	}
+}
+
+// logValidationError logs the failed validation of the extractor of a
+// monitor.
+func logValidationError(monitor string, err error) {
+
+	zap.L().Warn("Metadata extractor failed validation",
+		zap.String("monitor", monitor),
+		zap.Error(err),
+	)
+}
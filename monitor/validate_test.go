@@ -0,0 +1,142 @@
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/monitor/constants"
+	"go.aporeto.io/trireme-lib/monitor/extractors"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.aporeto.io/trireme-lib/utils/cgnetcls"
+	api "k8s.io/api/core/v1"
+)
+
+func TestValidateExtractor(t *testing.T) {
+
+	Convey("Given a sample event", t, func() {
+		event := sampleEvent(common.UIDLoginPU)
+
+		Convey("A nil extractor should fail validation", func() {
+			So(ValidateExtractor(nil, event), ShouldNotBeNil)
+		})
+
+		Convey("A nil event should fail validation", func() {
+			So(ValidateExtractor(extractors.UIDMetadataExtractor, nil), ShouldNotBeNil)
+		})
+
+		Convey("An extractor that returns an error should fail validation", func() {
+			err := ValidateExtractor(func(*common.EventInfo) (*policy.PURuntime, error) {
+				return nil, fmt.Errorf("bad event")
+			}, event)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "bad event")
+		})
+
+		Convey("An extractor that returns no runtime should fail validation", func() {
+			err := ValidateExtractor(func(*common.EventInfo) (*policy.PURuntime, error) {
+				return nil, nil
+			}, event)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("An extractor that returns a runtime without a name should fail validation", func() {
+			err := ValidateExtractor(func(*common.EventInfo) (*policy.PURuntime, error) {
+				return policy.NewPURuntime("", 1, "", nil, nil, common.UIDLoginPU, nil), nil
+			}, event)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("An extractor that returns an unsupported pu type should fail validation", func() {
+			err := ValidateExtractor(func(*common.EventInfo) (*policy.PURuntime, error) {
+				return policy.NewPURuntime("test", 1, "", nil, nil, common.TransientPU, nil), nil
+			}, event)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The default uid extractor should pass validation", func() {
+			So(ValidateExtractor(extractors.UIDMetadataExtractor, event), ShouldBeNil)
+		})
+
+		Convey("An extractor that allocates a mark should not use it up", func() {
+			next := cgnetcls.NextMark()
+			err := ValidateExtractor(func(*common.EventInfo) (*policy.PURuntime, error) {
+				options := &policy.OptionsType{CgroupMark: strconv.FormatUint(cgnetcls.MarkVal(), 10)}
+				return policy.NewPURuntime("test", 1, "", nil, nil, common.LinuxProcessPU, options), nil
+			}, event)
+			So(err, ShouldBeNil)
+			So(cgnetcls.NextMark(), ShouldEqual, next)
+		})
+	})
+}
+
+func TestValidateDockerExtractor(t *testing.T) {
+
+	Convey("Given a sample container", t, func() {
+		info := sampleContainer(false)
+
+		Convey("A nil container should fail validation", func() {
+			So(ValidateDockerExtractor(extractors.DefaultMetadataExtractor, nil), ShouldNotBeNil)
+		})
+
+		Convey("An extractor that returns an error should fail validation", func() {
+			err := ValidateDockerExtractor(func(*types.ContainerJSON) (*policy.PURuntime, error) {
+				return nil, fmt.Errorf("bad container")
+			}, info)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "bad container")
+		})
+
+		Convey("The default docker extractor should pass validation", func() {
+			So(ValidateDockerExtractor(extractors.DefaultMetadataExtractor, info), ShouldBeNil)
+		})
+
+		Convey("The default docker extractor should not use up marks for host mode containers", func() {
+			info.HostConfig.NetworkMode = constants.DockerHostMode
+			next := cgnetcls.NextMark()
+			So(ValidateDockerExtractor(extractors.DefaultMetadataExtractor, info), ShouldBeNil)
+			So(cgnetcls.NextMark(), ShouldEqual, next)
+		})
+	})
+}
+
+func TestValidateKubernetesExtractor(t *testing.T) {
+
+	Convey("Given the runtime of the infra container of a sample pod", t, func() {
+		runtime, err := extractors.DefaultMetadataExtractor(sampleContainer(true))
+		So(err, ShouldBeNil)
+		pod := samplePod()
+
+		Convey("A nil pod should fail validation", func() {
+			So(ValidateKubernetesExtractor(extractors.DefaultKubernetesMetadataExtractor, runtime, nil), ShouldNotBeNil)
+		})
+
+		Convey("An extractor that returns an error should fail validation", func() {
+			err := ValidateKubernetesExtractor(func(policy.RuntimeReader, *api.Pod) (*policy.PURuntime, bool, error) {
+				return nil, false, fmt.Errorf("bad pod")
+			}, runtime, pod)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "bad pod")
+		})
+
+		Convey("An extractor that activates a runtime without a name should fail validation", func() {
+			err := ValidateKubernetesExtractor(func(policy.RuntimeReader, *api.Pod) (*policy.PURuntime, bool, error) {
+				return policy.NewPURuntime("", 1, "", nil, nil, common.ContainerPU, nil), true, nil
+			}, runtime, pod)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The default kubernetes extractor should pass validation", func() {
+			So(ValidateKubernetesExtractor(extractors.DefaultKubernetesMetadataExtractor, runtime, pod), ShouldBeNil)
+		})
+
+		Convey("A container that is not activated should pass validation", func() {
+			other, err := extractors.DefaultMetadataExtractor(sampleContainer(false))
+			So(err, ShouldBeNil)
+			So(ValidateKubernetesExtractor(extractors.DefaultKubernetesMetadataExtractor, other, pod), ShouldBeNil)
+		})
+	})
+}
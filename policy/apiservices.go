@@ -1,6 +1,8 @@
 package policy
 
 import (
+	"net"
+
 	"go.aporeto.io/trireme-lib/common"
 	"go.aporeto.io/trireme-lib/controller/pkg/usertokens"
 )
@@ -13,11 +15,28 @@ const (
 	ServiceL3 ServiceType = iota
 	ServiceHTTP
 	ServiceTCP
+	ServiceUnixSocket
 )
 
 // ApplicationServicesList is a list of ApplicationServices.
 type ApplicationServicesList []*ApplicationService
 
+// Copy returns a copy of the list and of its services. The token handlers
+// of the services are shared.
+func (l ApplicationServicesList) Copy() ApplicationServicesList {
+
+	if l == nil {
+		return nil
+	}
+
+	list := make(ApplicationServicesList, len(l))
+	for i, s := range l {
+		list[i] = s.Copy()
+	}
+
+	return list
+}
+
 // ApplicationService is the type of service that this PU exposes.
 type ApplicationService struct {
 	// ID is the id of the service
@@ -73,6 +92,70 @@ type ApplicationService struct {
 	CACert []byte
 }
 
+// Copy returns a copy of the service. The token handler is shared.
+func (s *ApplicationService) Copy() *ApplicationService {
+
+	if s == nil {
+		return nil
+	}
+
+	c := *s
+	c.NetworkInfo = copyService(s.NetworkInfo)
+	c.PrivateNetworkInfo = copyService(s.PrivateNetworkInfo)
+	c.PublicNetworkInfo = copyService(s.PublicNetworkInfo)
+
+	if s.HTTPRules != nil {
+		c.HTTPRules = make([]*HTTPRule, len(s.HTTPRules))
+		for i, r := range s.HTTPRules {
+			if r == nil {
+				continue
+			}
+			rule := *r
+			rule.URIs = copyStrings(r.URIs)
+			rule.Methods = copyStrings(r.Methods)
+			rule.Scopes = copyStrings(r.Scopes)
+			c.HTTPRules[i] = &rule
+		}
+	}
+
+	if s.Tags != nil {
+		c.Tags = s.Tags.Copy()
+	}
+
+	if s.JWTClaimMappings != nil {
+		c.JWTClaimMappings = make(map[string]string, len(s.JWTClaimMappings))
+		for k, v := range s.JWTClaimMappings {
+			c.JWTClaimMappings[k] = v
+		}
+	}
+
+	if s.CACert != nil {
+		c.CACert = append([]byte{}, s.CACert...)
+	}
+
+	return &c
+}
+
+// copyService returns a copy of the network information of a service.
+func copyService(s *common.Service) *common.Service {
+
+	if s == nil {
+		return nil
+	}
+
+	c := *s
+	if s.Ports != nil {
+		ports := *s.Ports
+		c.Ports = &ports
+	}
+	if s.Addresses != nil {
+		c.Addresses = append([]*net.IPNet{}, s.Addresses...)
+	}
+	c.FQDNs = copyStrings(s.FQDNs)
+
+	return &c
+}
+
 // HTTPRule holds a rule for a particular HTTPService. The rule
 // relates a set of URIs defined as regular expressions with associated
 // verbs. The * VERB indicates all actions.
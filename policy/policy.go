@@ -1,6 +1,7 @@
 package policy
 
 import (
+	"reflect"
 	"sync"
 
 	"go.aporeto.io/trireme-lib/controller/pkg/usertokens"
@@ -33,7 +34,8 @@ type PUPolicy struct {
 	receiverRules TagSelectorList
 	// ips is the set of IP addresses and namespaces that the policy must be applied to
 	ips ExtendedMap
-	// triremeNetworks is the list of networks that Authorization must be enforced
+	// triremeNetworks is the list of networks that Authorization must be enforced.
+	// The target networks of the enforcer are used when it is empty.
 	triremeNetworks []string
 	// triremeUDPNetworks is the list of UDP networks that this policy should apply to
 	triremeUDPNetworks []string
@@ -51,6 +53,13 @@ type PUPolicy struct {
 	servicesCA string
 	// scopes are the processing unit granted scopes
 	scopes []string
+	// mutualAuthorization overrides the datapath mutual authorization setting
+	mutualAuthorization MutualAuthorizationType
+	// rateLimit limits the rate of new connections per source
+	rateLimit RateLimit
+	// revokeDeniedFlows tears down the established flows that the policy
+	// denies when it is applied
+	revokeDeniedFlows bool
 
 	sync.Mutex
 }
@@ -164,17 +173,140 @@ func (p *PUPolicy) Clone() *PUPolicy {
 		p.identity.Copy(),
 		p.annotations.Copy(),
 		p.ips.Copy(),
-		p.triremeNetworks,
-		p.triremeUDPNetworks,
-		p.excludedNetworks,
-		p.exposedServices,
-		p.dependentServices,
-		p.scopes,
+		copyStrings(p.triremeNetworks),
+		copyStrings(p.triremeUDPNetworks),
+		copyStrings(p.excludedNetworks),
+		p.exposedServices.Copy(),
+		p.dependentServices.Copy(),
+		copyStrings(p.scopes),
 	)
 
+	np.mutualAuthorization = p.mutualAuthorization
+	np.rateLimit = p.rateLimit
+	np.revokeDeniedFlows = p.revokeDeniedFlows
+	np.servicesCertificate = p.servicesCertificate
+	np.servicesPrivateKey = p.servicesPrivateKey
+	np.servicesCA = p.servicesCA
+
 	return np
 }
 
+// Equal returns true if the policies are identical. The flow policies of
+// the rules are compared with FlowPolicy.Equal.
+func (p *PUPolicy) Equal(other *PUPolicy) bool {
+
+	if p == nil || other == nil {
+		return p == other
+	}
+
+	if p == other {
+		return true
+	}
+
+	// The other policy is copied so that the policies are not locked together.
+	o := other.Clone()
+
+	p.Lock()
+	defer p.Unlock()
+
+	return p.managementID == o.managementID &&
+		p.triremeAction == o.triremeAction &&
+		valuesEqual(p.DNSACLs, o.DNSACLs) &&
+		ipRulesEqual(p.applicationACLs, o.applicationACLs) &&
+		ipRulesEqual(p.networkACLs, o.networkACLs) &&
+		tagStoresEqual(p.identity, o.identity) &&
+		tagStoresEqual(p.annotations, o.annotations) &&
+		tagSelectorsEqual(p.transmitterRules, o.transmitterRules) &&
+		tagSelectorsEqual(p.receiverRules, o.receiverRules) &&
+		valuesEqual(p.ips, o.ips) &&
+		valuesEqual(p.triremeNetworks, o.triremeNetworks) &&
+		valuesEqual(p.triremeUDPNetworks, o.triremeUDPNetworks) &&
+		valuesEqual(p.excludedNetworks, o.excludedNetworks) &&
+		valuesEqual(p.exposedServices, o.exposedServices) &&
+		valuesEqual(p.dependentServices, o.dependentServices) &&
+		p.servicesCertificate == o.servicesCertificate &&
+		p.servicesPrivateKey == o.servicesPrivateKey &&
+		p.servicesCA == o.servicesCA &&
+		valuesEqual(p.scopes, o.scopes) &&
+		p.mutualAuthorization == o.mutualAuthorization &&
+		p.rateLimit == o.rateLimit &&
+		p.revokeDeniedFlows == o.revokeDeniedFlows
+}
+
+// valuesEqual compares the values deeply. Nil and empty slices or maps are
+// equal.
+func valuesEqual(a, b interface{}) bool {
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Kind() == vb.Kind() && (va.Kind() == reflect.Slice || va.Kind() == reflect.Map) && va.Len() == 0 && vb.Len() == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// tagStoresEqual returns true if the stores hold the same tags in the same
+// order.
+func tagStoresEqual(a, b *TagStore) bool {
+
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return valuesEqual(a.Tags, b.Tags)
+}
+
+// ipRulesEqual returns true if the rules are identical.
+func ipRulesEqual(a, b IPRuleList) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		ra, rb := a[i], b[i]
+		if !ra.Policy.Equal(rb.Policy) {
+			return false
+		}
+		ra.Policy, rb.Policy = nil, nil
+		if !valuesEqual(ra, rb) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// tagSelectorsEqual returns true if the selectors are identical.
+func tagSelectorsEqual(a, b TagSelectorList) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		sa, sb := a[i], b[i]
+		if !sa.Policy.Equal(sb.Policy) {
+			return false
+		}
+		sa.Policy, sb.Policy = nil, nil
+		if !valuesEqual(sa, sb) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// copyStrings returns a copy of the slice that preserves nil slices.
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append([]string{}, s...)
+}
+
 // ManagementID returns the management ID
 func (p *PUPolicy) ManagementID() string {
 	p.Lock()
@@ -303,7 +435,9 @@ func (p *PUPolicy) SetIPAddresses(l ExtendedMap) {
 	p.ips = l
 }
 
-// TriremeNetworks  returns the list of networks that Trireme must be applied
+// TriremeNetworks  returns the list of networks that Trireme must be applied.
+// They replace the target networks of the enforcer for the PU. The target
+// networks of the enforcer are used if the list is empty.
 func (p *PUPolicy) TriremeNetworks() []string {
 	p.Lock()
 	defer p.Unlock()
@@ -391,6 +525,57 @@ func (p *PUPolicy) Scopes() []string {
 	return p.scopes
 }
 
+// MutualAuthorization returns the mutual authorization setting of the policy.
+func (p *PUPolicy) MutualAuthorization() MutualAuthorizationType {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.mutualAuthorization
+}
+
+// SetMutualAuthorization sets the mutual authorization setting of the policy.
+func (p *PUPolicy) SetMutualAuthorization(m MutualAuthorizationType) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.mutualAuthorization = m
+}
+
+// RateLimit returns the rate limit of new connections of the policy.
+func (p *PUPolicy) RateLimit() RateLimit {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.rateLimit
+}
+
+// SetRateLimit sets the rate limit of new connections of the policy.
+func (p *PUPolicy) SetRateLimit(r RateLimit) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.rateLimit = r
+}
+
+// RevokeDeniedFlows returns true if the established flows that the policy
+// denies are torn down when it is applied. They are kept until they end
+// otherwise.
+func (p *PUPolicy) RevokeDeniedFlows() bool {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.revokeDeniedFlows
+}
+
+// SetRevokeDeniedFlows sets whether the established flows that the policy
+// denies are torn down when it is applied.
+func (p *PUPolicy) SetRevokeDeniedFlows(revoke bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.revokeDeniedFlows = revoke
+}
+
 // ToPublicPolicy converts the object to a marshallable object.
 func (p *PUPolicy) ToPublicPolicy() *PUPolicyPublic {
 	p.Lock()
@@ -416,6 +601,9 @@ func (p *PUPolicy) ToPublicPolicy() *PUPolicyPublic {
 		ServicesCA:          p.servicesCA,
 		ServicesCertificate: p.servicesCertificate,
 		ServicesPrivateKey:  p.servicesPrivateKey,
+		MutualAuthorization: p.mutualAuthorization,
+		RateLimit:           p.rateLimit,
+		RevokeDeniedFlows:   p.revokeDeniedFlows,
 	}
 }
 
@@ -441,6 +629,9 @@ type PUPolicyPublic struct {
 	ServicesPrivateKey  string                  `json:"servicesPrivateKey,omitempty"`
 	ServicesCA          string                  `json:"servicesCA,omitempty"`
 	Scopes              []string                `json:"scopes,omitempty"`
+	MutualAuthorization MutualAuthorizationType `json:"mutualAuthorization,omitempty"`
+	RateLimit           RateLimit               `json:"rateLimit,omitempty"`
+	RevokeDeniedFlows   bool                    `json:"revokeDeniedFlows,omitempty"`
 }
 
 // ToPrivatePolicy converts the object to a private object.
@@ -474,5 +665,8 @@ func (p *PUPolicyPublic) ToPrivatePolicy(convert bool) *PUPolicy {
 		servicesCA:          p.ServicesCA,
 		servicesCertificate: p.ServicesCertificate,
 		servicesPrivateKey:  p.ServicesPrivateKey,
+		mutualAuthorization: p.MutualAuthorization,
+		rateLimit:           p.RateLimit,
+		revokeDeniedFlows:   p.RevokeDeniedFlows,
 	}
 }
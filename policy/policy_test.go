@@ -216,6 +216,102 @@ func TestFuncClone(t *testing.T) {
 				So(p.triremeNetworks, ShouldResemble, triremeNetworks)
 				So(p.excludedNetworks, ShouldResemble, excludedNetworks)
 			})
+
+			Convey("Changing the rules of the clone should not change the policy", func() {
+				p.networkACLs[0].Policy.PolicyID = "changed"
+				p.receiverRules[0].Clause[0].Value[0] = "db"
+				p.receiverRules[0].Policy.Action = Accept
+				So(d.networkACLs[0].Policy.PolicyID, ShouldNotEqual, "changed")
+				So(d.receiverRules[0].Clause[0].Value, ShouldResemble, []string{"web"})
+				So(d.receiverRules[0].Policy.Action, ShouldEqual, Reject)
+			})
+		})
+	})
+}
+
+func TestPolicyEqual(t *testing.T) {
+	Convey("Given a policy with rules", t, func() {
+		newPolicy := func(ruleAction ActionType, observe ObserveActionType) *PUPolicy {
+			netACL := IPRule{
+				Policy: &FlowPolicy{
+					Action:        ruleAction,
+					ObserveAction: observe,
+					PolicyID:      "1",
+				},
+				Address:  "20.0.0.0/8",
+				Protocol: "tcp",
+				Port:     "80",
+			}
+			rxtags := TagSelectorList{
+				TagSelector{
+					Clause: []KeyValueOperator{{Key: "app", Value: []string{"web"}, Operator: Equal}},
+					Policy: &FlowPolicy{Action: Accept, PolicyID: "2"},
+				},
+			}
+			identity := NewTagStore()
+			identity.AppendKeyValue("image", "nginx")
+
+			return NewPUPolicy("id1", Police, nil, IPRuleList{netACL}, nil, nil, rxtags, identity, nil, nil, []string{"10.1.1.0/24"}, nil, nil, nil, nil, nil)
+		}
+
+		p := newPolicy(Accept, ObserveNone)
+
+		Convey("It should be equal to itself and to its clone", func() {
+			So(p.Equal(p), ShouldBeTrue)
+			So(p.Equal(p.Clone()), ShouldBeTrue)
+			So(p.Clone().Equal(p), ShouldBeTrue)
+		})
+
+		Convey("It should be equal to an identical policy", func() {
+			So(p.Equal(newPolicy(Accept, ObserveNone)), ShouldBeTrue)
+		})
+
+		Convey("It should ignore the labels of the flow policies", func() {
+			o := newPolicy(Accept, ObserveNone)
+			o.networkACLs[0].Policy.Labels = []string{"label"}
+			So(p.Equal(o), ShouldBeTrue)
+		})
+
+		Convey("It should not be equal to a policy with a different selector ID", func() {
+			o := newPolicy(Accept, ObserveNone)
+			o.receiverRules[0].Policy.SelectorID = "selector"
+			So(p.Equal(o), ShouldBeFalse)
+		})
+
+		Convey("It should not be equal to a policy with a different rule action", func() {
+			So(p.Equal(newPolicy(Reject, ObserveNone)), ShouldBeFalse)
+		})
+
+		Convey("It should not be equal to a policy with a different observe action", func() {
+			So(p.Equal(newPolicy(Accept, ObserveContinue)), ShouldBeFalse)
+		})
+
+		Convey("It should not be equal to a policy with a different selector", func() {
+			o := newPolicy(Accept, ObserveNone)
+			o.receiverRules[0].Clause[0].Value = []string{"db"}
+			So(p.Equal(o), ShouldBeFalse)
+		})
+
+		Convey("It should not be equal to a policy with other settings", func() {
+			o := newPolicy(Accept, ObserveNone)
+			o.AddIdentityTag("app", "web")
+			So(p.Equal(o), ShouldBeFalse)
+
+			o = newPolicy(Accept, ObserveNone)
+			o.UpdateServiceCertificates("cert", "key")
+			So(p.Equal(o), ShouldBeFalse)
+
+			o = newPolicy(Accept, ObserveNone)
+			o.SetRateLimit(RateLimit{Rate: 10})
+			So(p.Equal(o), ShouldBeFalse)
+
+			o = newPolicy(Accept, ObserveNone)
+			o.SetRevokeDeniedFlows(true)
+			So(p.Equal(o), ShouldBeFalse)
+		})
+
+		Convey("It should not be equal to nil", func() {
+			So(p.Equal(nil), ShouldBeFalse)
 		})
 	})
 }
@@ -363,6 +459,36 @@ func TestAllLockedSetGet(t *testing.T) {
 			So(p.ExcludedNetworks(), ShouldResemble, []string{"90.0.0.0"})
 		})
 
+		Convey("The mutual authorization should default to the datapath setting", func() {
+			So(p.MutualAuthorization(), ShouldEqual, MutualAuthorizationDefault)
+		})
+
+		Convey("If I set the mutual authorization it should be preserved by clone and conversions", func() {
+			p.SetMutualAuthorization(MutualAuthorizationDisabled)
+			So(p.MutualAuthorization(), ShouldEqual, MutualAuthorizationDisabled)
+			So(p.Clone().MutualAuthorization(), ShouldEqual, MutualAuthorizationDisabled)
+			So(p.ToPublicPolicy().ToPrivatePolicy(false).MutualAuthorization(), ShouldEqual, MutualAuthorizationDisabled)
+		})
+
+		Convey("The rate limit should default to unlimited", func() {
+			So(p.RateLimit().Unlimited(), ShouldBeTrue)
+		})
+
+		Convey("If I set the rate limit it should be preserved by clone and conversions", func() {
+			r := RateLimit{Rate: 10, Burst: 20}
+			p.SetRateLimit(r)
+			So(p.RateLimit(), ShouldResemble, r)
+			So(p.Clone().RateLimit(), ShouldResemble, r)
+			So(p.ToPublicPolicy().ToPrivatePolicy(false).RateLimit(), ShouldResemble, r)
+		})
+
+		Convey("If I set the revocation of the denied flows it should be preserved by clone and conversions", func() {
+			So(p.RevokeDeniedFlows(), ShouldBeFalse)
+			p.SetRevokeDeniedFlows(true)
+			So(p.Clone().RevokeDeniedFlows(), ShouldBeTrue)
+			So(p.ToPublicPolicy().ToPrivatePolicy(false).RevokeDeniedFlows(), ShouldBeTrue)
+		})
+
 		newclause := KeyValueOperator{
 			Key:      "app",
 			Value:    []string{"added"},
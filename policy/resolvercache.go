@@ -0,0 +1,207 @@
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"go.aporeto.io/trireme-lib/common"
+)
+
+// ResolverFunc resolves the policy of a PU from its runtime.
+type ResolverFunc func(ctx context.Context, puID string, runtime RuntimeReader) (*PUPolicy, error)
+
+// PolicyApplier applies the policy resolved for an event of a PU, such as
+// with the Enforce or UpdatePolicy methods of the controller.
+type PolicyApplier func(ctx context.Context, puID string, event common.Event, policy *PUPolicy, runtime *PURuntime) error
+
+type resolverCacheEntry struct {
+	policy  *PUPolicy
+	expires time.Time
+}
+
+// ResolverCache is a caching layer for policy resolution. Policies are cached
+// by the ID of the PU and a hash of its whole runtime, so that a PU is not
+// resolved again until its runtime changes, the entry expires or the cache
+// is invalidated.
+type ResolverCache struct {
+	resolve    ResolverFunc
+	ttl        time.Duration
+	entries    map[string]*resolverCacheEntry
+	generation uint64
+	sync.Mutex
+}
+
+// NewResolverCache returns a cache around the given resolver. Entries expire
+// after ttl. A ttl of zero disables expiration.
+func NewResolverCache(resolve ResolverFunc, ttl time.Duration) *ResolverCache {
+
+	return &ResolverCache{
+		resolve: resolve,
+		ttl:     ttl,
+		entries: map[string]*resolverCacheEntry{},
+	}
+}
+
+// Resolve returns a copy of the cached policy for the PU and its runtime,
+// and calls the resolver if there is no valid entry.
+func (c *ResolverCache) Resolve(ctx context.Context, puID string, runtime RuntimeReader) (*PUPolicy, error) {
+
+	key, err := runtimeHash(puID, runtime)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	c.Lock()
+	if entry, ok := c.entries[key]; ok {
+		if c.ttl == 0 || now.Before(entry.expires) {
+			c.Unlock()
+			return entry.policy.Clone(), nil
+		}
+		delete(c.entries, key)
+	}
+	generation := c.generation
+	c.Unlock()
+
+	p, err := c.resolve(ctx, puID, runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	// Do not cache a policy that was resolved before an invalidation.
+	if generation == c.generation {
+		c.expire(now)
+		c.entries[key] = &resolverCacheEntry{
+			policy:  p.Clone(),
+			expires: now.Add(c.ttl),
+		}
+	}
+
+	return p, nil
+}
+
+// Invalidate removes all the cached policies. It must be called when the
+// policies change.
+func (c *ResolverCache) Invalidate() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries = map[string]*resolverCacheEntry{}
+	c.generation++
+}
+
+// Size returns the number of cached policies.
+func (c *ResolverCache) Size() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.entries)
+}
+
+// expire removes the expired entries. Must be called with the lock held.
+func (c *ResolverCache) expire(now time.Time) {
+
+	if c.ttl == 0 {
+		return
+	}
+
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// runtimeHash returns a hash of the ID of the PU and of its runtime that does
+// not depend on the order of the tags.
+func runtimeHash(puID string, runtime RuntimeReader) (string, error) {
+
+	tags := []string{}
+	if t := runtime.Tags(); t != nil {
+		tags = append(tags, t.GetSlice()...)
+	}
+	sort.Strings(tags)
+
+	options := runtime.Options()
+
+	data, err := json.Marshal(struct {
+		PUID    string
+		Runtime *PURuntimeJSON
+		PortMap map[nat.Port][]string
+	}{
+		PUID: puID,
+		Runtime: &PURuntimeJSON{
+			PUType:      runtime.PUType(),
+			Pid:         runtime.Pid(),
+			NSPath:      runtime.NSPath(),
+			Name:        runtime.Name(),
+			IPAddresses: runtime.IPAddresses(),
+			Tags:        NewTagStoreFromSlice(tags),
+			Options:     &options,
+		},
+		PortMap: runtime.PortMap(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to hash runtime of pu %s: %s", puID, err)
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// cachingResolver is a Resolver that resolves the policies through a
+// ResolverCache.
+type cachingResolver struct {
+	next  Resolver
+	cache *ResolverCache
+	apply PolicyApplier
+}
+
+// NewCachingResolver returns a Resolver that passes the events to next, and
+// then resolves the policy of the PUs that start or are updated through the
+// cache and applies it with apply. next may be nil.
+func NewCachingResolver(next Resolver, cache *ResolverCache, apply PolicyApplier) Resolver {
+
+	return &cachingResolver{
+		next:  next,
+		cache: cache,
+		apply: apply,
+	}
+}
+
+// HandlePUEvent implements the Resolver interface.
+func (r *cachingResolver) HandlePUEvent(ctx context.Context, puID string, event common.Event, runtime RuntimeReader) error {
+
+	if r.next != nil {
+		if err := r.next.HandlePUEvent(ctx, puID, event, runtime); err != nil {
+			return err
+		}
+	}
+
+	if event != common.EventStart && event != common.EventUpdate {
+		return nil
+	}
+
+	puRuntime, ok := runtime.(*PURuntime)
+	if !ok {
+		return fmt.Errorf("unable to apply policy of pu %s: invalid runtime %T", puID, runtime)
+	}
+
+	p, err := r.cache.Resolve(ctx, puID, runtime)
+	if err != nil {
+		return fmt.Errorf("unable to resolve policy of pu %s: %s", puID, err)
+	}
+
+	return r.apply(ctx, puID, event, p, puRuntime)
+}
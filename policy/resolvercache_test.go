@@ -0,0 +1,177 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.aporeto.io/trireme-lib/common"
+)
+
+func testCountingResolver(calls *int) ResolverFunc {
+	return func(ctx context.Context, puID string, runtime RuntimeReader) (*PUPolicy, error) {
+		*calls++
+		if _, ok := runtime.Tag("fail"); ok {
+			return nil, fmt.Errorf("resolution failed")
+		}
+		services := ApplicationServicesList{
+			{
+				ID:        "service",
+				HTTPRules: []*HTTPRule{{URIs: []string{"/admin"}}},
+			},
+		}
+		p := NewPUPolicy("", AllowAll, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, services, nil, nil)
+		p.AddIdentityTag("app", "web")
+		return p, nil
+	}
+}
+
+func testRuntime(tags map[string]string) *PURuntime {
+	return NewPURuntime("test", 1, "", NewTagStoreFromMap(tags), nil, common.ContainerPU, nil)
+}
+
+type testEventResolver struct {
+	events []common.Event
+}
+
+func (r *testEventResolver) HandlePUEvent(ctx context.Context, puID string, event common.Event, runtime RuntimeReader) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestResolverCache(t *testing.T) {
+	Convey("Given a policy cache", t, func() {
+		calls := 0
+		c := NewResolverCache(testCountingResolver(&calls), time.Minute)
+		ctx := context.Background()
+
+		Convey("When I resolve the same runtime twice", func() {
+			p1, err1 := c.Resolve(ctx, "pu1", testRuntime(map[string]string{"app": "web", "env": "prod"}))
+			p2, err2 := c.Resolve(ctx, "pu1", testRuntime(map[string]string{"env": "prod", "app": "web"}))
+
+			Convey("The second resolution should be a cache hit", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(calls, ShouldEqual, 1)
+				So(c.Size(), ShouldEqual, 1)
+				So(p2.Identity().GetSlice(), ShouldResemble, p1.Identity().GetSlice())
+			})
+
+			Convey("Mutating a returned policy should not corrupt the cache", func() {
+				p2.AddIdentityTag("$transmitter", "pu1")
+				p2.ExposedServices()[0].HTTPRules[0].URIs[0] = "/"
+				p3, err := c.Resolve(ctx, "pu1", testRuntime(map[string]string{"app": "web", "env": "prod"}))
+				So(err, ShouldBeNil)
+				So(p3.Identity().GetSlice(), ShouldResemble, []string{"app=web"})
+				So(p3.ExposedServices()[0].HTTPRules[0].URIs, ShouldResemble, []string{"/admin"})
+			})
+		})
+
+		Convey("When I resolve different tags", func() {
+			_, err1 := c.Resolve(ctx, "pu1", testRuntime(map[string]string{"app": "web"}))
+			_, err2 := c.Resolve(ctx, "pu1", testRuntime(map[string]string{"app": "db"}))
+
+			Convey("Both resolutions should be cache misses", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(calls, ShouldEqual, 2)
+				So(c.Size(), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I resolve the same tags for different PUs or runtimes", func() {
+			tags := map[string]string{"app": "web"}
+			_, err1 := c.Resolve(ctx, "pu1", testRuntime(tags))
+			_, err2 := c.Resolve(ctx, "pu2", testRuntime(tags))
+			_, err3 := c.Resolve(ctx, "pu1", NewPURuntime("other", 1, "", NewTagStoreFromMap(tags), nil, common.ContainerPU, nil))
+			_, err4 := c.Resolve(ctx, "pu1", NewPURuntime("test", 2, "", NewTagStoreFromMap(tags), nil, common.ContainerPU, nil))
+
+			Convey("All the resolutions should be cache misses", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+				So(err3, ShouldBeNil)
+				So(err4, ShouldBeNil)
+				So(calls, ShouldEqual, 4)
+				So(c.Size(), ShouldEqual, 4)
+			})
+		})
+
+		Convey("When the resolver fails", func() {
+			_, err := c.Resolve(ctx, "pu1", testRuntime(map[string]string{"fail": "true"}))
+
+			Convey("The error should be returned and nothing cached", func() {
+				So(err, ShouldNotBeNil)
+				So(c.Size(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When I invalidate the cache", func() {
+			_, err := c.Resolve(ctx, "pu1", testRuntime(map[string]string{"app": "web"}))
+			So(err, ShouldBeNil)
+			c.Invalidate()
+			_, err = c.Resolve(ctx, "pu1", testRuntime(map[string]string{"app": "web"}))
+			So(err, ShouldBeNil)
+
+			Convey("The policy should be resolved again", func() {
+				So(calls, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When an entry expires", func() {
+			c = NewResolverCache(testCountingResolver(&calls), time.Millisecond)
+			_, err := c.Resolve(ctx, "pu1", testRuntime(map[string]string{"app": "web"}))
+			So(err, ShouldBeNil)
+			time.Sleep(5 * time.Millisecond)
+			_, err = c.Resolve(ctx, "pu1", testRuntime(map[string]string{"app": "web"}))
+			So(err, ShouldBeNil)
+
+			Convey("The policy should be resolved again", func() {
+				So(calls, ShouldEqual, 2)
+				So(c.Size(), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestCachingResolver(t *testing.T) {
+	Convey("Given a caching resolver", t, func() {
+		calls := 0
+		next := &testEventResolver{}
+		applied := []common.Event{}
+		var appliedPolicy *PUPolicy
+		r := NewCachingResolver(next, NewResolverCache(testCountingResolver(&calls), time.Minute), func(ctx context.Context, puID string, event common.Event, policy *PUPolicy, runtime *PURuntime) error {
+			applied = append(applied, event)
+			appliedPolicy = policy
+			return nil
+		})
+		ctx := context.Background()
+		runtime := testRuntime(map[string]string{"app": "web"})
+
+		Convey("When a PU is created, started and updated with the same runtime", func() {
+			So(r.HandlePUEvent(ctx, "pu1", common.EventCreate, runtime), ShouldBeNil)
+			So(r.HandlePUEvent(ctx, "pu1", common.EventStart, runtime), ShouldBeNil)
+			So(r.HandlePUEvent(ctx, "pu1", common.EventUpdate, runtime), ShouldBeNil)
+
+			Convey("All the events should be passed to the next resolver", func() {
+				So(next.events, ShouldResemble, []common.Event{common.EventCreate, common.EventStart, common.EventUpdate})
+			})
+
+			Convey("The policy should be resolved once and applied on start and update", func() {
+				So(calls, ShouldEqual, 1)
+				So(applied, ShouldResemble, []common.Event{common.EventStart, common.EventUpdate})
+				So(appliedPolicy.Identity().GetSlice(), ShouldResemble, []string{"app=web"})
+			})
+		})
+
+		Convey("When the policy cannot be resolved", func() {
+			err := r.HandlePUEvent(ctx, "pu1", common.EventStart, testRuntime(map[string]string{"fail": "true"}))
+
+			Convey("An error should be returned and nothing applied", func() {
+				So(err, ShouldNotBeNil)
+				So(applied, ShouldBeEmpty)
+			})
+		})
+	})
+}
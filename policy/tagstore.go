@@ -78,6 +78,36 @@ func (t *TagStore) Merge(m *TagStore) (merged int) {
 	return merged
 }
 
+// Diff returns the tags of other that are not in the tag store, and the tags
+// of the tag store that are not in other. Duplicate tags are reported once.
+func (t *TagStore) Diff(other *TagStore) (added, removed []string) {
+
+	current := make(map[string]struct{}, len(t.Tags))
+	for _, kv := range t.Tags {
+		current[kv] = struct{}{}
+	}
+
+	next := make(map[string]struct{}, len(other.Tags))
+	for _, kv := range other.Tags {
+		if _, ok := next[kv]; ok {
+			continue
+		}
+		next[kv] = struct{}{}
+		if _, ok := current[kv]; !ok {
+			added = append(added, kv)
+		}
+	}
+
+	for _, kv := range t.Tags {
+		if _, ok := next[kv]; !ok {
+			removed = append(removed, kv)
+			next[kv] = struct{}{}
+		}
+	}
+
+	return added, removed
+}
+
 // AppendKeyValue appends a key and value to the tag store
 func (t *TagStore) AppendKeyValue(key, value string) {
 	t.Tags = append(t.Tags, key+"="+value)
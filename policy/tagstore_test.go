@@ -82,6 +82,42 @@ func TestMergeCollision(t *testing.T) {
 	})
 }
 
+func TestDiff(t *testing.T) {
+	Convey("Given a tag store", t, func() {
+		ts := NewTagStoreFromSlice([]string{"app=web", "image=nginx"})
+
+		Convey("When I diff with the same tags in another order, there should be no change", func() {
+			added, removed := ts.Diff(NewTagStoreFromSlice([]string{"image=nginx", "app=web"}))
+			So(added, ShouldBeEmpty)
+			So(removed, ShouldBeEmpty)
+		})
+
+		Convey("When I diff with added tags, only the added tags should be reported", func() {
+			added, removed := ts.Diff(NewTagStoreFromSlice([]string{"app=web", "image=nginx", "env=prod", "env=prod"}))
+			So(added, ShouldResemble, []string{"env=prod"})
+			So(removed, ShouldBeEmpty)
+		})
+
+		Convey("When I diff with removed tags, only the removed tags should be reported", func() {
+			added, removed := ts.Diff(NewTagStoreFromSlice([]string{"app=web"}))
+			So(added, ShouldBeEmpty)
+			So(removed, ShouldResemble, []string{"image=nginx"})
+		})
+
+		Convey("When I diff with changed tags, both added and removed tags should be reported", func() {
+			added, removed := ts.Diff(NewTagStoreFromSlice([]string{"app=db", "image=nginx", "env=prod"}))
+			So(added, ShouldResemble, []string{"app=db", "env=prod"})
+			So(removed, ShouldResemble, []string{"app=web"})
+		})
+
+		Convey("When I diff with an empty store, all the tags should be removed", func() {
+			added, removed := ts.Diff(NewTagStore())
+			So(added, ShouldBeEmpty)
+			So(removed, ShouldResemble, []string{"app=web", "image=nginx"})
+		})
+	})
+}
+
 func TestAllSettersGetters(t *testing.T) {
 	Convey("When I create a new tagstore from a map", t, func() {
 		ts := NewTagStoreFromMap(map[string]string{
@@ -2,6 +2,8 @@ package policy
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/docker/go-connections/nat"
 	"go.aporeto.io/trireme-lib/common"
@@ -39,6 +41,13 @@ const (
 	KeyExists = "*"
 	// KeyNotExists means that the key doesnt exist in the incoming tags
 	KeyNotExists = "!*"
+	// Matches is the regular expression operator. The values are patterns
+	// that must match the whole value of the tag.
+	Matches = "=~"
+	// Within is the path hierarchy operator. The values are paths that
+	// match themselves and the paths under them: /a/b matches /a/b and
+	// /a/b/c, but not /a/bc.
+	Within = "=/"
 )
 
 // ActionType   is the action that can be applied to a flow.
@@ -152,6 +161,55 @@ const (
 	ObserveApply ObserveActionType = 0x2
 )
 
+// MutualAuthorizationType defines whether the transmitter rules of a PU
+// must be evaluated when a connection is established.
+type MutualAuthorizationType int
+
+const (
+	// MutualAuthorizationDefault uses the setting of the datapath.
+	MutualAuthorizationDefault MutualAuthorizationType = iota
+	// MutualAuthorizationEnabled always evaluates the transmitter rules.
+	MutualAuthorizationEnabled
+	// MutualAuthorizationDisabled never evaluates the transmitter rules.
+	MutualAuthorizationDisabled
+)
+
+// RateLimit defines the rate of new connections that a PU accepts from a
+// single source. A zero rate is unlimited.
+type RateLimit struct {
+	// Rate is the number of new connections per second.
+	Rate float64 `json:"rate,omitempty"`
+	// Burst is the number of new connections accepted at once.
+	Burst int `json:"burst,omitempty"`
+}
+
+// Unlimited returns true if the rate limit does not restrict connections.
+func (r RateLimit) Unlimited() bool {
+	return r.Rate <= 0
+}
+
+// EnforcementDirection is the direction of the traffic that is enforced for a PU.
+type EnforcementDirection int
+
+const (
+	// EnforceBoth enforces both the ingress and the egress traffic.
+	EnforceBoth EnforcementDirection = iota
+	// EnforceIngressOnly enforces only the traffic received by the PU.
+	EnforceIngressOnly
+	// EnforceEgressOnly enforces only the traffic sent by the PU.
+	EnforceEgressOnly
+)
+
+// Ingress returns true if the traffic received by the PU is enforced.
+func (d EnforcementDirection) Ingress() bool {
+	return d != EnforceEgressOnly
+}
+
+// Egress returns true if the traffic sent by the PU is enforced.
+func (d EnforcementDirection) Egress() bool {
+	return d != EnforceIngressOnly
+}
+
 // FlowPolicy captures the policy for a particular flow
 type FlowPolicy struct {
 	ObserveAction ObserveActionType
@@ -159,6 +217,38 @@ type FlowPolicy struct {
 	ServiceID     string
 	PolicyID      string
 	Labels        []string
+	// SelectorID is the ID of the tag selector that matched the flow. It is
+	// set by the policy lookups and not by the policy.
+	SelectorID string
+}
+
+// Copy returns a copy of the flow policy.
+func (f *FlowPolicy) Copy() *FlowPolicy {
+
+	if f == nil {
+		return nil
+	}
+
+	c := *f
+	c.Labels = copyStrings(f.Labels)
+
+	return &c
+}
+
+// Equal returns true if the flow policies have the same actions and
+// identifiers, including the selector ID that is reported with the flows.
+// The labels are informational and are ignored.
+func (f *FlowPolicy) Equal(other *FlowPolicy) bool {
+
+	if f == nil || other == nil {
+		return f == other
+	}
+
+	return f.Action == other.Action &&
+		f.ObserveAction == other.ObserveAction &&
+		f.ServiceID == other.ServiceID &&
+		f.PolicyID == other.PolicyID &&
+		f.SelectorID == other.SelectorID
 }
 
 // LogPrefix is the prefix used in nf-log action. It must be less than
@@ -232,18 +322,32 @@ func EncodedStringToAction(e string) (ActionType, ObserveActionType, error) {
 	return 0, 0, errors.New("Invalid encoding")
 }
 
+// AnyProtocol is the protocol of an IPRule that applies to all protocols
+// and ports of an address. The port of such a rule is ignored.
+const AnyProtocol = "any"
+
 // IPRule holds IP rules to external services
 type IPRule struct {
 	Address  string
 	Port     string
 	Protocol string
-	Policy   *FlowPolicy
+	// SourcePort optionally restricts the rule to a source port or a
+	// min:max range of source ports. An empty value matches any source port.
+	SourcePort string
+	// AddressSet optionally names a set of addresses maintained separately
+	// from the rules, such as a threat feed. The rule then applies to the
+	// addresses of the set instead of Address. The addresses are managed
+	// with UpdateAddressSet and RemoveAddressSet of the controller.
+	AddressSet string
+	Policy     *FlowPolicy
 }
 
 // IPRuleList is a list of IP rules
 type IPRuleList []IPRule
 
-// DNSRule holds the dns names and the assicated ports
+// DNSRule holds the dns names and the assicated ports. Port is either a
+// port used with Protocol, or a comma separated list of port/protocol pairs
+// such as 443/tcp,443/udp.
 type DNSRule struct {
 	Name     string
 	Port     string
@@ -269,6 +373,7 @@ func (l IPRuleList) Copy() IPRuleList {
 	list := make(IPRuleList, len(l))
 	for i, v := range l {
 		list[i] = v
+		list[i].Policy = v.Policy.Copy()
 	}
 	return list
 }
@@ -285,8 +390,93 @@ type KeyValueOperator struct {
 type TagSelector struct {
 	Clause []KeyValueOperator
 	Policy *FlowPolicy
+	// ID identifies the selector in the flow reports. If it is empty, the
+	// selector is identified by the IDs of its clause.
+	ID string
+	// Priority orders the selectors that match the same tags. The selector
+	// with the highest priority wins. Selectors with equal priorities are
+	// ordered by insertion.
+	Priority int
+	// Window restricts the selector to the connections established during
+	// the window. The selector applies at all times if it is nil.
+	Window *TimeWindow
+}
+
+// TimeWindow is a recurring window of time, such as a maintenance window.
+type TimeWindow struct {
+	// Days are the days of the week the window starts. The window starts
+	// every day if it is empty.
+	Days []time.Weekday
+	// Start and End are the times of the day the window starts and ends, as
+	// the duration since midnight. The window ends the next day if End is
+	// before Start, and lasts the whole day if they are equal.
+	Start time.Duration
+	End   time.Duration
+	// Location is the name of the time zone of the window in the IANA time
+	// zone database, such as Europe/Paris. The window is in UTC if it is
+	// empty.
+	Location string
+}
+
+// TimeLocation returns the time zone of the window, or an error if the
+// window is invalid.
+func (w *TimeWindow) TimeLocation() (*time.Location, error) {
+
+	if w.Start < 0 || w.Start > 24*time.Hour || w.End < 0 || w.End > 24*time.Hour {
+		return nil, fmt.Errorf("invalid time window: %s-%s", w.Start, w.End)
+	}
+
+	return time.LoadLocation(w.Location)
+}
+
+// Contains returns true if the time is in the window in the given time zone.
+// The time of the day is read from the wall clock of the time zone, so that
+// the window follows the daylight saving time changes.
+func (w *TimeWindow) Contains(t time.Time, loc *time.Location) bool {
+
+	t = t.In(loc)
+	hour, min, sec := t.Clock()
+	day := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	weekday := t.Weekday()
+
+	switch {
+	case w.Start == w.End:
+	case w.Start < w.End:
+		if day < w.Start || day >= w.End {
+			return false
+		}
+	case day >= w.Start:
+	case day < w.End:
+		// The window started the previous day.
+		weekday = (weekday + 6) % 7
+	default:
+		return false
+	}
+
+	if len(w.Days) == 0 {
+		return true
+	}
+
+	for _, d := range w.Days {
+		if d == weekday {
+			return true
+		}
+	}
+
+	return false
 }
 
+const (
+	// ExecutableRuntimeTag is the runtime tag with the path of the executable
+	// of a Linux process PU. It is set by the monitor from the executable
+	// that the process runs.
+	ExecutableRuntimeTag = "@sys:executable"
+	// ExecutableTag is the identity tag with the path of the executable of a
+	// Linux process PU. It is sent in the claims of the PU, so that selectors
+	// such as executable=/usr/bin/curl match the flows of an executable.
+	ExecutableTag = "executable"
+)
+
 // TagSelectorList defines a list of TagSelectors
 type TagSelectorList []TagSelector
 
@@ -296,6 +486,23 @@ func (t TagSelectorList) Copy() TagSelectorList {
 
 	for i, v := range t {
 		list[i] = v
+		list[i].Policy = v.Policy.Copy()
+
+		if v.Clause != nil {
+			list[i].Clause = make([]KeyValueOperator, len(v.Clause))
+			for j, kv := range v.Clause {
+				list[i].Clause[j] = kv
+				list[i].Clause[j].Value = copyStrings(kv.Value)
+			}
+		}
+
+		if v.Window != nil {
+			window := *v.Window
+			if v.Window.Days != nil {
+				window.Days = append([]time.Weekday{}, v.Window.Days...)
+			}
+			list[i].Window = &window
+		}
 	}
 
 	return list
@@ -341,4 +548,11 @@ type OptionsType struct {
 
 	// PortMap maps container port -> host ports.
 	PortMap map[nat.Port][]string
+
+	// EnforcementDirection is the direction of the traffic that is enforced.
+	EnforcementDirection EnforcementDirection
+
+	// EnforcerSelector selects the enforcer of the PU when several enforcers
+	// can serve its type. The enforcer of the PU type is used if it is empty.
+	EnforcerSelector string
 }
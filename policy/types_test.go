@@ -2,6 +2,7 @@ package policy
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -58,3 +59,111 @@ func TestEncodeDecodePrefix(t *testing.T) {
 		}
 	})
 }
+
+func TestFlowPolicyEqual(t *testing.T) {
+	Convey("Given a flow policy", t, func() {
+		f := &FlowPolicy{
+			Action:        Accept,
+			ObserveAction: ObserveNone,
+			ServiceID:     "service",
+			PolicyID:      "policy",
+			Labels:        []string{"label"},
+		}
+
+		Convey("It should be equal to a policy with the same actions and identifiers", func() {
+			So(f.Equal(&FlowPolicy{
+				Action:        Accept,
+				ObserveAction: ObserveNone,
+				ServiceID:     "service",
+				PolicyID:      "policy",
+			}), ShouldBeTrue)
+		})
+
+		Convey("It should not be equal to a policy with a different action", func() {
+			g := *f
+			g.Action = Reject
+			So(f.Equal(&g), ShouldBeFalse)
+		})
+
+		Convey("It should not be equal to a policy with a different observe action", func() {
+			g := *f
+			g.ObserveAction = ObserveContinue
+			So(f.Equal(&g), ShouldBeFalse)
+		})
+
+		Convey("It should not be equal to a policy with a different service or policy id", func() {
+			g := *f
+			g.ServiceID = "other"
+			So(f.Equal(&g), ShouldBeFalse)
+			g = *f
+			g.PolicyID = "other"
+			So(f.Equal(&g), ShouldBeFalse)
+		})
+
+		Convey("It should not be equal to a policy with a different selector id", func() {
+			g := *f
+			g.SelectorID = "selector"
+			So(f.Equal(&g), ShouldBeFalse)
+		})
+
+		Convey("It should only be equal to nil if it is nil", func() {
+			var n *FlowPolicy
+			So(f.Equal(nil), ShouldBeFalse)
+			So(n.Equal(f), ShouldBeFalse)
+			So(n.Equal(nil), ShouldBeTrue)
+		})
+	})
+}
+
+func TestTimeWindow(t *testing.T) {
+
+	Convey("Given a time window during working hours in New York", t, func() {
+		w := &TimeWindow{
+			Days:     []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+			Start:    9 * time.Hour,
+			End:      17*time.Hour + 30*time.Minute,
+			Location: "America/New_York",
+		}
+		loc, err := w.TimeLocation()
+		So(err, ShouldBeNil)
+
+		Convey("The times in the window should be contained", func() {
+			So(w.Contains(time.Date(2020, 6, 8, 9, 0, 0, 0, loc), loc), ShouldBeTrue)
+			So(w.Contains(time.Date(2020, 6, 12, 17, 29, 59, 0, loc), loc), ShouldBeTrue)
+			So(w.Contains(time.Date(2020, 6, 8, 14, 0, 0, 0, time.UTC), loc), ShouldBeTrue)
+		})
+
+		Convey("The times outside the window should not be contained", func() {
+			So(w.Contains(time.Date(2020, 6, 8, 8, 59, 59, 0, loc), loc), ShouldBeFalse)
+			So(w.Contains(time.Date(2020, 6, 8, 17, 30, 0, 0, loc), loc), ShouldBeFalse)
+			So(w.Contains(time.Date(2020, 6, 13, 12, 0, 0, 0, loc), loc), ShouldBeFalse)
+			So(w.Contains(time.Date(2020, 6, 8, 12, 0, 0, 0, time.UTC), loc), ShouldBeFalse)
+		})
+
+		Convey("The window should follow the daylight saving time changes", func() {
+			// 13:30 UTC is 9:30 in summer and 8:30 in winter in New York.
+			So(w.Contains(time.Date(2020, 6, 8, 13, 30, 0, 0, time.UTC), loc), ShouldBeTrue)
+			So(w.Contains(time.Date(2020, 12, 7, 13, 30, 0, 0, time.UTC), loc), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a time window across midnight every day", t, func() {
+		w := &TimeWindow{Start: 23 * time.Hour, End: time.Hour}
+		loc, err := w.TimeLocation()
+		So(err, ShouldBeNil)
+		So(loc, ShouldEqual, time.UTC)
+
+		So(w.Contains(time.Date(2020, 6, 8, 23, 30, 0, 0, time.UTC), loc), ShouldBeTrue)
+		So(w.Contains(time.Date(2020, 6, 8, 0, 30, 0, 0, time.UTC), loc), ShouldBeTrue)
+		So(w.Contains(time.Date(2020, 6, 8, 12, 0, 0, 0, time.UTC), loc), ShouldBeFalse)
+	})
+
+	Convey("Given invalid time windows", t, func() {
+		_, err := (&TimeWindow{Start: -time.Hour}).TimeLocation()
+		So(err, ShouldNotBeNil)
+		_, err = (&TimeWindow{End: 25 * time.Hour}).TimeLocation()
+		So(err, ShouldNotBeNil)
+		_, err = (&TimeWindow{Location: "Nowhere/Invalid"}).TimeLocation()
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -30,3 +30,10 @@ func (p *allocator) Allocate() string {
 func (p *allocator) Release(item string) {
 	p.allocate <- item
 }
+
+// Stats returns the total number of items, the items in use and the free items
+func (p *allocator) Stats() (total, inUse, free int) {
+	total = cap(p.allocate)
+	free = len(p.allocate)
+	return total, total - free, free
+}
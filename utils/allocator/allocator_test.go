@@ -0,0 +1,45 @@
+package allocator
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAllocatorStats(t *testing.T) {
+
+	Convey("Given a new allocator", t, func() {
+		a := New(5000, 3)
+
+		Convey("All the items should be free", func() {
+			total, inUse, free := a.Stats()
+			So(total, ShouldEqual, 3)
+			So(inUse, ShouldEqual, 0)
+			So(free, ShouldEqual, 3)
+		})
+
+		Convey("When I allocate all the items", func() {
+			items := []string{a.Allocate(), a.Allocate(), a.Allocate()}
+
+			Convey("I should get all the items in order", func() {
+				So(items, ShouldResemble, []string{"5000", "5001", "5002"})
+			})
+
+			Convey("No item should be free", func() {
+				total, inUse, free := a.Stats()
+				So(total, ShouldEqual, 3)
+				So(inUse, ShouldEqual, 3)
+				So(free, ShouldEqual, 0)
+			})
+
+			Convey("When I release an item, it should be free again", func() {
+				a.Release(items[1])
+				total, inUse, free := a.Stats()
+				So(total, ShouldEqual, 3)
+				So(inUse, ShouldEqual, 2)
+				So(free, ShouldEqual, 1)
+				So(a.Allocate(), ShouldEqual, "5001")
+			})
+		})
+	})
+}
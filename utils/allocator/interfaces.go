@@ -8,4 +8,7 @@ type Allocator interface {
 
 	// Release releases a string
 	Release(item string)
+
+	// Stats returns the total number of items, the items in use and the free items
+	Stats() (total, inUse, free int)
 }
@@ -24,6 +24,7 @@ type DataStore interface {
 	LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error)
 	SetTimeOut(u interface{}, timeout time.Duration) (err error)
 	ToString() string
+	KeyList() []interface{}
 }
 
 // Cache is the structure that involves the map of entries. The cache
@@ -123,6 +124,19 @@ func (c *Cache) ToString() string {
 	return fmt.Sprintf("%d/%d", c.max, len(c.data))
 }
 
+// KeyList returns the keys of all the entries in the cache
+func (c *Cache) KeyList() []interface{} {
+	c.RLock()
+	defer c.RUnlock()
+
+	keys := make([]interface{}, 0, len(c.data))
+	for k := range c.data {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
 // Add stores an entry into the cache and updates the timestamp
 func (c *Cache) Add(u interface{}, value interface{}) (err error) {
 
@@ -157,7 +171,10 @@ func (c *Cache) Add(u interface{}, value interface{}) (err error) {
 	return errors.New("item exists: use update")
 }
 
-// GetReset  changes the value of an entry into the cache and updates the timestamp
+// GetReset retrieves the entry from the cache and restarts its expiration
+// timer, either with the given duration or, if the duration is 0, with the
+// lifetime of the cache. It must be used when the read indicates activity
+// that should keep the entry alive. Use Get for read-only probes.
 func (c *Cache) GetReset(u interface{}, duration time.Duration) (interface{}, error) {
 
 	c.Lock()
@@ -267,11 +284,12 @@ func (c *Cache) SetTimeOut(u interface{}, timeout time.Duration) (err error) {
 	return nil
 }
 
-// Get retrieves the entry from the cache
+// Get retrieves the entry from the cache without modifying its expiration
+// timer. Reading an entry with Get never extends its lifetime.
 func (c *Cache) Get(u interface{}) (i interface{}, err error) {
 
-	c.Lock()
-	defer c.Unlock()
+	c.RLock()
+	defer c.RUnlock()
 
 	if _, ok := c.data[u]; !ok {
 		return nil, errors.New("not found")
@@ -378,3 +378,27 @@ func TestRemoveWithDelay(t *testing.T) {
 
 	})
 }
+
+func TestGetDoesNotReset(t *testing.T) {
+
+	t.Parallel()
+
+	Convey("Given that I instantiate 1 object with a 2 second timer", t, func() {
+		c := NewCacheWithExpiration("cache", 2*time.Second)
+		err := c.Add("test", "test")
+		So(err, ShouldBeNil)
+		Convey("When I retrieve the data with get after 1 second", func() {
+			<-time.After(1 * time.Second)
+			d, err := c.Get("test")
+			So(err, ShouldBeNil)
+			So(d.(string), ShouldResemble, "test")
+
+			Convey("If I wait for another 1200ms, the data should be gone", func() {
+				<-time.After(1200 * time.Millisecond)
+				val, err := c.Get("test")
+				So(err, ShouldNotBeNil)
+				So(val, ShouldBeNil)
+			})
+		})
+	})
+}
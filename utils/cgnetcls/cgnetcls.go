@@ -12,7 +12,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"syscall"
 
 	"github.com/kardianos/osext"
@@ -20,24 +20,61 @@ import (
 	"go.uber.org/zap"
 )
 
+// rmdir removes the directory of a cgroup. The kernel removes the files of
+// the cgroup with it.
+var rmdir = os.Remove
+
 //Initialize only ince
 func init() {
 	mountCgroupController()
 }
 
+// validateCgroupPath returns an error if the path has parent references. The
+// names of the cgroups can have several levels, such as uid/pid, but they must
+// stay in the Trireme path.
+func validateCgroupPath(path string) error {
+
+	for _, element := range strings.Split(path, "/") {
+		if element == ".." {
+			return fmt.Errorf("invalid cgroup path %s: parent references are not allowed", path)
+		}
+	}
+
+	return nil
+}
+
+// cgroupPath returns the path of a cgroup in the Trireme path. The names are
+// validated before any file system operation.
+func (s *netCls) cgroupPath(cgroupname string) (string, error) {
+
+	if err := validateCgroupPath(s.TriremePath); err != nil {
+		return "", err
+	}
+
+	if err := validateCgroupPath(cgroupname); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(basePath, s.TriremePath, cgroupname), nil
+}
+
 // Creategroup creates a cgroup/net_cls structure and writes the allocated classid to the file.
 // To add a new process to this cgroup we need to write to the cgroup file
 func (s *netCls) Creategroup(cgroupname string) error {
 
+	cgroupPath, err := s.cgroupPath(cgroupname)
+	if err != nil {
+		return err
+	}
+
 	//Create the directory structure
-	_, err := os.Stat(basePath + procs)
+	_, err = os.Stat(basePath + procs)
 	if os.IsNotExist(err) {
 		if err = syscall.Mount("cgroup", basePath, "cgroup", 0, "net_cls,net_prio"); err != nil {
 			return err
 		}
 	}
 
-	cgroupPath := filepath.Join(basePath, s.TriremePath, cgroupname)
 	if _, err = os.Stat(cgroupPath); err == nil {
 		return nil
 	}
@@ -64,7 +101,7 @@ func (s *netCls) Creategroup(cgroupname string) error {
 			return fmt.Errorf("unable to write to the notify file: %s", err)
 		}
 
-		err = ioutil.WriteFile(filepath.Join(basePath, s.TriremePath, cgroupname, notifyOnReleaseFile), []byte("1"), 0644)
+		err = ioutil.WriteFile(filepath.Join(cgroupPath, notifyOnReleaseFile), []byte("1"), 0644)
 		if err != nil {
 			return fmt.Errorf("unable to write to the notify file: %s", err)
 		}
@@ -77,7 +114,12 @@ func (s *netCls) Creategroup(cgroupname string) error {
 //AssignMark writes the mark value to net_cls.classid file.
 func (s *netCls) AssignMark(cgroupname string, mark uint64) error {
 
-	_, err := os.Stat(filepath.Join(basePath, s.TriremePath, cgroupname))
+	cgroupPath, err := s.cgroupPath(cgroupname)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(cgroupPath)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("cgroup does not exist: %s", err)
 	}
@@ -85,17 +127,50 @@ func (s *netCls) AssignMark(cgroupname string, mark uint64) error {
 	//16 is the base since the mark file expects hexadecimal values
 	markval := "0x" + (strconv.FormatUint(mark, 16))
 
-	if err := ioutil.WriteFile(filepath.Join(basePath, s.TriremePath, cgroupname, markFile), []byte(markval), 0644); err != nil {
+	if err := ioutil.WriteFile(filepath.Join(cgroupPath, markFile), []byte(markval), 0644); err != nil {
 		return fmt.Errorf("failed to write to net_cls.classid file for new cgroup: %s", err)
 	}
 
 	return nil
 }
 
+// AssignPriority writes the network priority of the interface to the
+// net_prio.ifpriomap file of the cgroup.
+func (s *netCls) AssignPriority(cgroupname string, iface string, prio uint32) error {
+
+	cgroupPath, err := s.cgroupPath(cgroupname)
+	if err != nil {
+		return err
+	}
+
+	if iface == "" || strings.ContainsAny(iface, " \t\n/") {
+		return fmt.Errorf("invalid interface name %q", iface)
+	}
+
+	_, err = os.Stat(cgroupPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("cgroup does not exist: %s", err)
+	}
+
+	// The kernel updates the entry of the interface and keeps the others.
+	entry := iface + " " + strconv.FormatUint(uint64(prio), 10)
+
+	if err := ioutil.WriteFile(filepath.Join(cgroupPath, priorityFile), []byte(entry), 0644); err != nil {
+		return fmt.Errorf("failed to write to net_prio.ifpriomap file: %s", err)
+	}
+
+	return nil
+}
+
 // AddProcess adds the process to the net_cls group
 func (s *netCls) AddProcess(cgroupname string, pid int) error {
 
-	_, err := os.Stat(filepath.Join(basePath, s.TriremePath, cgroupname))
+	cgroupPath, err := s.cgroupPath(cgroupname)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(cgroupPath)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("cannot add process. cgroup does not exist: %s", err)
 	}
@@ -105,7 +180,7 @@ func (s *netCls) AddProcess(cgroupname string, pid int) error {
 		return nil
 	}
 
-	if err := ioutil.WriteFile(filepath.Join(basePath, s.TriremePath, cgroupname, procs), PID, 0644); err != nil {
+	if err := ioutil.WriteFile(filepath.Join(cgroupPath, procs), PID, 0644); err != nil {
 		return fmt.Errorf("cannot add process: %s", err)
 	}
 
@@ -116,7 +191,12 @@ func (s *netCls) AddProcess(cgroupname string, pid int) error {
 //top of net_cls cgroup cgroup.procs
 func (s *netCls) RemoveProcess(cgroupname string, pid int) error {
 
-	_, err := os.Stat(filepath.Join(basePath, s.TriremePath, cgroupname))
+	cgroupPath, err := s.cgroupPath(cgroupname)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(cgroupPath)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("cannot clean up process. cgroup does not exist: %s", err)
 	}
@@ -141,13 +221,18 @@ func (s *netCls) RemoveProcess(cgroupname string, pid int) error {
 // Before we try deletion
 func (s *netCls) DeleteCgroup(cgroupname string) error {
 
-	_, err := os.Stat(filepath.Join(basePath, s.TriremePath, cgroupname))
+	cgroupPath, err := s.cgroupPath(cgroupname)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(cgroupPath)
 	if os.IsNotExist(err) {
 		zap.L().Debug("Group already deleted", zap.Error(err))
 		return nil
 	}
 
-	err = os.Remove(filepath.Join(basePath, s.TriremePath, cgroupname))
+	err = rmdir(cgroupPath)
 	if err != nil {
 		return fmt.Errorf("unable to delete cgroup %s: %s", cgroupname, err)
 	}
@@ -155,11 +240,111 @@ func (s *netCls) DeleteCgroup(cgroupname string) error {
 	return nil
 }
 
+// CleanupAllCgroups deletes all the cgroups of the Trireme path and then the
+// Trireme path itself. The processes of the cgroups are moved to the root
+// cgroup first. The cgroups are deleted on a best effort basis, since their
+// processes can race with the cleanup, and the errors are aggregated.
+func (s *netCls) CleanupAllCgroups() error {
+
+	if s.TriremePath == "" {
+		return errors.New("no trireme path to clean up")
+	}
+
+	triremePath, err := s.cgroupPath("")
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(triremePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	errs := s.cleanupCgroups("")
+
+	if len(errs) == 0 {
+		if err := rmdir(triremePath); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Sprintf("unable to delete trireme path: %s", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to clean up cgroups: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// cleanupCgroups deletes the cgroups nested in the parent cgroup and returns
+// the errors.
+func (s *netCls) cleanupCgroups(parent string) []string {
+
+	parentPath, err := s.cgroupPath(parent)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	entries, err := ioutil.ReadDir(parentPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return []string{fmt.Sprintf("unable to list cgroups of %s: %s", parentPath, err)}
+	}
+
+	errs := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		cgroupname := filepath.Join(parent, entry.Name())
+
+		// The cgroup can't be deleted if a nested cgroup is left.
+		if nested := s.cleanupCgroups(cgroupname); len(nested) > 0 {
+			errs = append(errs, nested...)
+			continue
+		}
+
+		s.releaseProcesses(cgroupname)
+
+		if err := s.DeleteCgroup(cgroupname); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	return errs
+}
+
+// releaseProcesses moves the processes of the cgroup to the root cgroup.
+func (s *netCls) releaseProcesses(cgroupname string) {
+
+	pids, err := s.ListCgroupProcesses(cgroupname)
+	if err != nil {
+		return
+	}
+
+	for _, pid := range pids {
+		// The process may have exited.
+		if err := ioutil.WriteFile(filepath.Join(basePath, procs), []byte(pid), 0644); err != nil {
+			zap.L().Debug("Unable to release process",
+				zap.String("cgroup", cgroupname),
+				zap.String("pid", pid),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
 //Deletebasepath removes the base aporeto directory which comes as a separate event when we are not managing any processes
 func (s *netCls) Deletebasepath(cgroupName string) bool {
 
 	if cgroupName == s.TriremePath {
-		if err := os.Remove(filepath.Join(basePath, cgroupName)); err != nil {
+		if err := validateCgroupPath(cgroupName); err != nil {
+			zap.L().Error("Invalid Trireme Base Path", zap.Error(err))
+			return true
+		}
+
+		if err := rmdir(filepath.Join(basePath, cgroupName)); err != nil {
 			zap.L().Error("Error when removing Trireme Base Path", zap.Error(err))
 		}
 		return true
@@ -171,13 +356,18 @@ func (s *netCls) Deletebasepath(cgroupName string) bool {
 // ListCgroupProcesses returns lists of  processes in the cgroup
 func (s *netCls) ListCgroupProcesses(cgroupname string) ([]string, error) {
 
-	_, err := os.Stat(filepath.Join(basePath, s.TriremePath, cgroupname))
+	cgroupPath, err := s.cgroupPath(cgroupname)
+	if err != nil {
+		return []string{}, err
+	}
+
+	_, err = os.Stat(cgroupPath)
 
 	if os.IsNotExist(err) {
 		return []string{}, fmt.Errorf("cgroup %s does not exist: %s", cgroupname, err)
 	}
 
-	data, err := ioutil.ReadFile(filepath.Join(basePath, s.TriremePath, cgroupname, "cgroup.procs"))
+	data, err := ioutil.ReadFile(filepath.Join(cgroupPath, "cgroup.procs"))
 	if err != nil {
 		return []string{}, fmt.Errorf("cannot read procs file: %s", err)
 	}
@@ -193,12 +383,21 @@ func (s *netCls) ListCgroupProcesses(cgroupname string) ([]string, error) {
 	return procs, nil
 }
 
-// ListAllCgroups returns a list of the cgroups that are managed in the Trireme path
-func (s *netCls) ListAllCgroups(path string) []string {
+// ListAllCgroups returns a list of the cgroups that are managed in the Trireme path.
+// The list is empty if the path does not exist.
+func (s *netCls) ListAllCgroups(path string) ([]string, error) {
 
-	cgroups, err := ioutil.ReadDir(filepath.Join(basePath, s.TriremePath, path))
+	cgroupPath, err := s.cgroupPath(path)
 	if err != nil {
-		return []string{}
+		return []string{}, err
+	}
+
+	cgroups, err := ioutil.ReadDir(cgroupPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return []string{}, fmt.Errorf("unable to list cgroups of %s: %s", path, err)
 	}
 
 	names := make([]string, len(cgroups))
@@ -206,7 +405,7 @@ func (s *netCls) ListAllCgroups(path string) []string {
 		names[i] = cgroups[i].Name()
 	}
 
-	return names
+	return names, nil
 }
 
 func mountCgroupController() {
@@ -254,6 +453,10 @@ func mountCgroupController() {
 
 // CgroupMemberCount -- Returns the cound of the number of processes in a cgroup
 func CgroupMemberCount(cgroupName string) int {
+	if err := validateCgroupPath(cgroupName); err != nil {
+		return 0
+	}
+
 	_, err := os.Stat(filepath.Join(basePath, TriremeBasePath, cgroupName))
 	if os.IsNotExist(err) {
 		return 0
@@ -297,7 +500,93 @@ func NewCgroupNetController(triremepath string, releasePath string) Cgroupnetcls
 	return controller
 }
 
-// MarkVal returns a new Mark Value
+// markAllocator allocates the classids of the cgroups. The marks are
+// allocated after the classids of the cgroups that exist when it is first
+// used, so that the marks of the cgroups created before a restart are not
+// reused.
+type markAllocator struct {
+	next uint64
+	once sync.Once
+	sync.Mutex
+}
+
+// marks is the allocator shared by all the controllers, since they use the
+// classids of the same net_cls hierarchy.
+var marks = &markAllocator{}
+
+// reconcile sets the next mark after the classids of the existing cgroups.
+func (m *markAllocator) reconcile() {
+
+	m.next = Initialmarkval + 1
+
+	live, err := GetAllMarks()
+	if err != nil {
+		zap.L().Warn("Unable to read the marks of the existing cgroups", zap.Error(err))
+	}
+
+	for _, mark := range live {
+		if mark >= m.next {
+			m.next = mark + 1
+		}
+	}
+}
+
+// allocate returns a new mark.
+func (m *markAllocator) allocate() uint64 {
+
+	m.once.Do(m.reconcile)
+
+	m.Lock()
+	defer m.Unlock()
+
+	mark := m.next
+	m.next++
+
+	return mark
+}
+
+// peek returns the mark that is allocated next.
+func (m *markAllocator) peek() uint64 {
+
+	m.once.Do(m.reconcile)
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.next
+}
+
+// release returns a mark to the allocator if it is the last mark that was
+// allocated, so that it is allocated again. Other marks are not reused.
+func (m *markAllocator) release(mark uint64) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	if mark != 0 && mark+1 == m.next {
+		m.next = mark
+	}
+}
+
+// MarkVal returns a new mark value for a cgroup of the controller.
+func (s *netCls) MarkVal() uint64 {
+	return marks.allocate()
+}
+
+// MarkVal returns a new mark value. It uses the allocator of the controllers
+// and is kept for the metadata extractors that have no controller.
 func MarkVal() uint64 {
-	return atomic.AddUint64(&markval, 1)
+	return marks.allocate()
+}
+
+// NextMark returns the mark that MarkVal returns next, without allocating it.
+func NextMark() uint64 {
+	return marks.peek()
+}
+
+// ReleaseMark returns a mark obtained with MarkVal that is not used, such
+// as the mark of a runtime extracted for validation. The mark is only reused
+// if no other mark was allocated after it.
+func ReleaseMark(mark uint64) {
+	marks.release(mark)
 }
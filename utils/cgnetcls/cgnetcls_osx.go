@@ -3,6 +3,8 @@
 //Package cgnetcls implements functionality to manage classid for processes belonging to different cgroups
 package cgnetcls
 
+import "context"
+
 //Creategroup creates a cgroup/net_cls structure and writes the allocated classid to the file.
 //To add a new process to this cgroup we need to write to the cgroup file
 func (s *netCls) Creategroup(cgroupname string) error {
@@ -14,6 +16,11 @@ func (s *netCls) AssignMark(cgroupname string, mark uint64) error {
 	return nil
 }
 
+// AssignPriority writes the network priority of the interface
+func (s *netCls) AssignPriority(cgroupname string, iface string, prio uint32) error {
+	return nil
+}
+
 //AddProcess adds the process to the net_cls group
 func (s *netCls) AddProcess(cgroupname string, pid int) error {
 	return nil
@@ -44,8 +51,8 @@ func (s *netCls) ListCgroupProcesses(cgroupname string) ([]string, error) {
 }
 
 // ListAllCgroups returns a list of the cgroups that are managed in the Trireme path
-func (s *netCls) ListAllCgroups(path string) []string {
-	return []string{}
+func (s *netCls) ListAllCgroups(path string) ([]string, error) {
+	return []string{}, nil
 }
 
 //NewCgroupNetController returns a handle to call functions on the cgroup net_cls controller
@@ -58,7 +65,45 @@ func NewDockerCgroupNetController() Cgroupnetcls {
 	return &netCls{}
 }
 
+// MarkVal returns a new mark value for a cgroup of the controller.
+func (s *netCls) MarkVal() uint64 {
+	return 0
+}
+
+// CleanupAllCgroups deletes all the cgroups
+func (s *netCls) CleanupAllCgroups() error {
+	return nil
+}
+
 // MarkVal returns a new Mark
 func MarkVal() uint64 {
 	return 0
 }
+
+// NextMark returns the mark that MarkVal returns next
+func NextMark() uint64 {
+	return 0
+}
+
+// ReleaseMark returns a mark obtained with MarkVal that is not used
+func ReleaseMark(mark uint64) {}
+
+type membershipWatcher struct{}
+
+// NewMembershipWatcher returns a watcher that never notifies
+func NewMembershipWatcher(triremepath string, callback MembershipFunc) MembershipWatcher {
+	return &membershipWatcher{}
+}
+
+// Run starts the watcher
+func (w *membershipWatcher) Run(ctx context.Context) error {
+	return nil
+}
+
+// Watch watches the cgroup
+func (w *membershipWatcher) Watch(cgroupname string) error {
+	return nil
+}
+
+// Unwatch stops watching the cgroup
+func (w *membershipWatcher) Unwatch(cgroupname string) {}
@@ -7,6 +7,7 @@ package cgnetcls
 //This can be tested only on linux since the directory structure will not exist anywhere else
 //Tests here will be skipped if you don't run as root
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -16,6 +17,7 @@ import (
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 )
 
 const (
@@ -296,3 +298,379 @@ func TestListCgroupProcesses(t *testing.T) {
 		t.Errorf("No process found %d", err)
 	}
 }
+
+// setupTestBasePath points the net_cls mount to a temporary directory. The
+// victim directory is outside of the mount.
+func setupTestBasePath(t *testing.T) (string, func()) {
+
+	dir, err := ioutil.TempDir("", "cgnetcls")
+	if err != nil {
+		t.Fatalf("Unable to create directory %s", err)
+	}
+
+	prevBasePath := basePath
+	basePath = filepath.Join(dir, "net_cls")
+
+	if err := os.MkdirAll(filepath.Join(basePath, TriremeBasePath), 0700); err != nil {
+		t.Fatalf("Unable to create directory %s", err)
+	}
+	if err := ioutil.WriteFile(basePath+procs, []byte{}, 0644); err != nil {
+		t.Fatalf("Unable to create file %s", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "victim"), 0700); err != nil {
+		t.Fatalf("Unable to create directory %s", err)
+	}
+
+	return dir, func() {
+		basePath = prevBasePath
+		os.RemoveAll(dir) // nolint errcheck
+	}
+}
+
+func TestInvalidCgroupNames(t *testing.T) {
+
+	dir, cleanup := setupTestBasePath(t)
+	defer cleanup()
+
+	victim := filepath.Join(dir, "victim")
+	cg := NewCgroupNetController(TriremeBasePath, "")
+
+	for _, name := range []string{"../../victim", "test/../../../victim", "..", "../"} {
+		if err := cg.Creategroup(name + "/escape"); err == nil {
+			t.Errorf("Cgroup %s created", name)
+		}
+		if _, err := os.Stat(filepath.Join(victim, "escape")); !os.IsNotExist(err) {
+			t.Errorf("Directory created outside of the Trireme path for %s", name)
+		}
+
+		if err := cg.AssignMark(name, testmark); err == nil {
+			t.Errorf("Mark assigned to cgroup %s", name)
+		}
+		if _, err := os.Stat(filepath.Join(victim, markFile)); !os.IsNotExist(err) {
+			t.Errorf("Mark written outside of the Trireme path for %s", name)
+		}
+
+		if err := cg.AddProcess(name, os.Getpid()); err == nil {
+			t.Errorf("Process added to cgroup %s", name)
+		}
+		if _, err := os.Stat(filepath.Join(victim, procs)); !os.IsNotExist(err) {
+			t.Errorf("Process written outside of the Trireme path for %s", name)
+		}
+
+		if err := cg.RemoveProcess(name, os.Getpid()); err == nil {
+			t.Errorf("Process removed from cgroup %s", name)
+		}
+
+		if err := cg.DeleteCgroup(name); err == nil {
+			t.Errorf("Cgroup %s deleted", name)
+		}
+		if _, err := os.Stat(victim); err != nil {
+			t.Errorf("Directory outside of the Trireme path deleted for %s", name)
+		}
+
+		if _, err := cg.ListCgroupProcesses(name); err == nil {
+			t.Errorf("Processes listed for cgroup %s", name)
+		}
+
+		if cgroups, err := cg.ListAllCgroups(name); err == nil || len(cgroups) != 0 {
+			t.Errorf("Cgroups listed for %s", name)
+		}
+	}
+
+	invalid := NewCgroupNetController("../..", "")
+	if err := invalid.Creategroup("victim"); err == nil {
+		t.Errorf("Cgroup created with an invalid Trireme path")
+	}
+}
+
+func TestNestedCgroupNames(t *testing.T) {
+
+	_, cleanup := setupTestBasePath(t)
+	defer cleanup()
+
+	cg := NewCgroupNetController(TriremeBasePath, "")
+
+	if err := cg.Creategroup("1000/42"); err != nil {
+		t.Errorf("Failed to create nested cgroup %s", err)
+	}
+
+	if cgroups, err := cg.ListAllCgroups("1000"); err != nil || len(cgroups) != 1 || cgroups[0] != "42" {
+		t.Errorf("Nested cgroup not found %v", cgroups)
+	}
+
+	if cgroups, err := cg.ListAllCgroups("2000"); err != nil || len(cgroups) != 0 {
+		t.Errorf("Expected no cgroups without error got %v %v", cgroups, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(basePath, TriremeBasePath, "file"), []byte{}, 0644); err != nil {
+		t.Fatalf("Unable to create file %s", err)
+	}
+	if _, err := cg.ListAllCgroups("file"); err == nil {
+		t.Errorf("Expected an error when the cgroups can't be read")
+	}
+}
+
+func TestMarkAllocator(t *testing.T) {
+
+	_, cleanup := setupTestBasePath(t)
+	defer cleanup()
+
+	empty := &markAllocator{}
+	if mark := empty.allocate(); mark != Initialmarkval+1 {
+		t.Errorf("Expected mark %d got %d", Initialmarkval+1, mark)
+	}
+
+	for path, classid := range map[string]string{
+		filepath.Join(TriremeBasePath, "a"): "0x12c",
+		"docker/b":                          "250\n",
+		"c":                                 "0",
+	} {
+		if err := os.MkdirAll(filepath.Join(basePath, path), 0700); err != nil {
+			t.Fatalf("Unable to create directory %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(basePath, path, markFile), []byte(classid), 0644); err != nil {
+			t.Fatalf("Unable to create file %s", err)
+		}
+	}
+
+	marks, err := GetAllMarks()
+	if err != nil {
+		t.Errorf("Failed to get the marks %s", err)
+	}
+	if len(marks) != 2 {
+		t.Errorf("Expected 2 marks got %v", marks)
+	}
+
+	allocator := &markAllocator{}
+	if mark := allocator.allocate(); mark != 301 {
+		t.Errorf("Expected mark 301 got %d", mark)
+	}
+	if mark := allocator.allocate(); mark != 302 {
+		t.Errorf("Expected mark 302 got %d", mark)
+	}
+
+	// The marks are only reconciled once.
+	if err := ioutil.WriteFile(filepath.Join(basePath, "c", markFile), []byte("1000"), 0644); err != nil {
+		t.Fatalf("Unable to create file %s", err)
+	}
+	if mark := allocator.allocate(); mark != 303 {
+		t.Errorf("Expected mark 303 got %d", mark)
+	}
+
+	// Only the last mark is released.
+	allocator.release(302)
+	if mark := allocator.allocate(); mark != 304 {
+		t.Errorf("Expected mark 304 got %d", mark)
+	}
+	allocator.release(304)
+	if mark := allocator.peek(); mark != 304 {
+		t.Errorf("Expected next mark 304 got %d", mark)
+	}
+	if mark := allocator.allocate(); mark != 304 {
+		t.Errorf("Expected mark 304 got %d", mark)
+	}
+}
+
+func TestMembershipWatcher(t *testing.T) {
+
+	_, cleanup := setupTestBasePath(t)
+	defer cleanup()
+
+	prevInterval := membershipPollInterval
+	defer func() {
+		membershipPollInterval = prevInterval
+	}()
+
+	type change struct {
+		cgroup  string
+		members int
+	}
+
+	changes := make(chan change, 10)
+	callback := func(ctx context.Context, cgroupname string, members int) {
+		changes <- change{cgroup: cgroupname, members: members}
+	}
+
+	expect := func(cgroup string, members int) {
+		select {
+		case c := <-changes:
+			if c.cgroup != cgroup || c.members != members {
+				t.Errorf("Expected %d members for %s got %d for %s", members, cgroup, c.members, c.cgroup)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Expected a change of %s", cgroup)
+		}
+	}
+
+	procsFile := func(cgroup string) string {
+		return filepath.Join(basePath, TriremeBasePath, cgroup, procs)
+	}
+
+	if err := os.MkdirAll(filepath.Join(basePath, TriremeBasePath, "watched"), 0700); err != nil {
+		t.Fatalf("Unable to create directory %s", err)
+	}
+	if err := ioutil.WriteFile(procsFile("watched"), []byte("100\n"), 0644); err != nil {
+		t.Fatalf("Unable to create file %s", err)
+	}
+	// The procs file of the polled cgroup can't be watched.
+	if err := os.MkdirAll(filepath.Join(basePath, TriremeBasePath, "polled"), 0700); err != nil {
+		t.Fatalf("Unable to create directory %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	membershipPollInterval = time.Hour
+	w := NewMembershipWatcher(TriremeBasePath, callback)
+
+	if err := w.Watch("../escape"); err == nil {
+		t.Errorf("Invalid cgroup watched")
+	}
+
+	if err := w.Watch("watched"); err != nil {
+		t.Errorf("Failed to watch cgroup %s", err)
+	}
+	if err := w.Run(ctx); err != nil {
+		t.Errorf("Failed to run the watcher %s", err)
+	}
+	if err := w.Run(ctx); err == nil {
+		t.Errorf("Watcher started twice")
+	}
+
+	if err := ioutil.WriteFile(procsFile("watched"), []byte{}, 0644); err != nil {
+		t.Fatalf("Unable to write file %s", err)
+	}
+	expect("watched", 0)
+
+	w.Unwatch("watched")
+	if err := ioutil.WriteFile(procsFile("watched"), []byte("100\n"), 0644); err != nil {
+		t.Fatalf("Unable to write file %s", err)
+	}
+
+	membershipPollInterval = 10 * time.Millisecond
+	polled := NewMembershipWatcher(TriremeBasePath, callback)
+	if err := polled.Run(ctx); err != nil {
+		t.Errorf("Failed to run the watcher %s", err)
+	}
+	if err := polled.Watch("polled"); err != nil {
+		t.Errorf("Failed to watch cgroup %s", err)
+	}
+
+	if err := ioutil.WriteFile(procsFile("polled"), []byte("100\n200\n"), 0644); err != nil {
+		t.Fatalf("Unable to write file %s", err)
+	}
+	expect("polled", 2)
+
+	if err := ioutil.WriteFile(procsFile("polled"), []byte{}, 0644); err != nil {
+		t.Fatalf("Unable to write file %s", err)
+	}
+	expect("polled", 0)
+
+	select {
+	case c := <-changes:
+		t.Errorf("Unexpected change of %s", c.cgroup)
+	default:
+	}
+}
+
+func TestCleanupAllCgroups(t *testing.T) {
+
+	_, cleanup := setupTestBasePath(t)
+	defer cleanup()
+
+	// The kernel removes the files of the cgroups with their directory.
+	prevRmdir := rmdir
+	defer func() {
+		rmdir = prevRmdir
+	}()
+	rmdir = os.RemoveAll
+
+	triremePath := filepath.Join(basePath, TriremeBasePath)
+	cg := NewCgroupNetController(TriremeBasePath, "")
+
+	for _, name := range []string{"1", "2", "1000/42", "1000/43"} {
+		if err := cg.Creategroup(name); err != nil {
+			t.Fatalf("Failed to create cgroup %s", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(triremePath, "1", procs), []byte("100\n200\n"), 0644); err != nil {
+		t.Fatalf("Unable to create file %s", err)
+	}
+
+	if err := NewDockerCgroupNetController().CleanupAllCgroups(); err == nil {
+		t.Errorf("Cgroups cleaned up without a trireme path")
+	}
+
+	rmdir = func(path string) error {
+		if path == filepath.Join(triremePath, "1000", "43") {
+			return syscall.EBUSY
+		}
+		return os.RemoveAll(path)
+	}
+
+	err := cg.CleanupAllCgroups()
+	if err == nil || !strings.Contains(err.Error(), "1000/43") {
+		t.Errorf("Expected an error for the busy cgroup got %v", err)
+	}
+	for _, name := range []string{"1", "2", "1000/42"} {
+		if _, err := os.Stat(filepath.Join(triremePath, name)); !os.IsNotExist(err) {
+			t.Errorf("Cgroup %s not deleted", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(triremePath, "1000", "43")); err != nil {
+		t.Errorf("Busy cgroup deleted")
+	}
+	if data, err := ioutil.ReadFile(basePath + procs); err != nil || string(data) != "200" {
+		t.Errorf("Processes not released %s", string(data))
+	}
+
+	rmdir = os.RemoveAll
+
+	if err := cg.CleanupAllCgroups(); err != nil {
+		t.Errorf("Failed to clean up the cgroups %s", err)
+	}
+	if _, err := os.Stat(triremePath); !os.IsNotExist(err) {
+		t.Errorf("Trireme path not deleted")
+	}
+	if err := cg.CleanupAllCgroups(); err != nil {
+		t.Errorf("Failed to clean up without cgroups %s", err)
+	}
+}
+
+func TestAssignPriority(t *testing.T) {
+
+	_, cleanup := setupTestBasePath(t)
+	defer cleanup()
+
+	cg := NewCgroupNetController(TriremeBasePath, "")
+
+	if err := cg.AssignPriority("test", "eth0", 5); err == nil {
+		t.Errorf("Priority assigned without a cgroup")
+	}
+
+	if err := cg.Creategroup("test"); err != nil {
+		t.Fatalf("Failed to create cgroup %s", err)
+	}
+
+	for _, iface := range []string{"", "eth0 1", "eth0\nlo", "../eth0"} {
+		if err := cg.AssignPriority("test", iface, 5); err == nil {
+			t.Errorf("Priority assigned to invalid interface %q", iface)
+		}
+	}
+
+	if err := cg.AssignPriority("../escape", "eth0", 5); err == nil {
+		t.Errorf("Priority assigned to an invalid cgroup")
+	}
+
+	if err := cg.AssignPriority("test", "eth0", 5); err != nil {
+		t.Errorf("Failed to assign priority %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(basePath, TriremeBasePath, "test", priorityFile))
+	if err != nil {
+		t.Errorf("Unable to read the priority map %s", err)
+	}
+	if strings.TrimSpace(string(data)) != "eth0 5" {
+		t.Errorf("Expected priority map entry eth0 5 got %s", string(data))
+	}
+}
@@ -11,6 +11,7 @@ const (
 	PortTag = "port"
 
 	markFile             = "/net_cls.classid"
+	priorityFile         = "/net_prio.ifpriomap"
 	procs                = "/cgroup.procs"
 	releaseAgentConfFile = "/release_agent"
 	notifyOnReleaseFile  = "/notify_on_release"
@@ -1,13 +1,29 @@
 package cgnetcls
 
+import "context"
+
 //Cgroupnetcls interface exposing methods that can be called from outside to manage net_cls cgroups
 type Cgroupnetcls interface {
 	Creategroup(cgroupname string) error
 	AssignMark(cgroupname string, mark uint64) error
+	AssignPriority(cgroupname string, iface string, prio uint32) error
 	AddProcess(cgroupname string, pid int) error
 	RemoveProcess(cgroupname string, pid int) error
 	DeleteCgroup(cgroupname string) error
 	Deletebasepath(contextID string) bool
 	ListCgroupProcesses(cgroupname string) ([]string, error)
-	ListAllCgroups(path string) []string
+	ListAllCgroups(path string) ([]string, error)
+	MarkVal() uint64
+	CleanupAllCgroups() error
+}
+
+// MembershipFunc is called with the number of member processes of a cgroup
+// when they change.
+type MembershipFunc func(ctx context.Context, cgroupname string, members int)
+
+// MembershipWatcher notifies the changes of the member processes of the cgroups
+type MembershipWatcher interface {
+	Run(ctx context.Context) error
+	Watch(cgroupname string) error
+	Unwatch(cgroupname string)
 }
@@ -5,6 +5,7 @@
 package mockcgnetcls
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -65,6 +66,20 @@ func (mr *MockCgroupnetclsMockRecorder) AssignMark(cgroupname, mark interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AssignMark", reflect.TypeOf((*MockCgroupnetcls)(nil).AssignMark), cgroupname, mark)
 }
 
+// AssignPriority mocks base method
+// nolint
+func (m *MockCgroupnetcls) AssignPriority(cgroupname, iface string, prio uint32) error {
+	ret := m.ctrl.Call(m, "AssignPriority", cgroupname, iface, prio)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AssignPriority indicates an expected call of AssignPriority
+// nolint
+func (mr *MockCgroupnetclsMockRecorder) AssignPriority(cgroupname, iface, prio interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AssignPriority", reflect.TypeOf((*MockCgroupnetcls)(nil).AssignPriority), cgroupname, iface, prio)
+}
+
 // AddProcess mocks base method
 // nolint
 func (m *MockCgroupnetcls) AddProcess(cgroupname string, pid int) error {
@@ -138,10 +153,11 @@ func (mr *MockCgroupnetclsMockRecorder) ListCgroupProcesses(cgroupname interface
 
 // ListAllCgroups mocks base method
 // nolint
-func (m *MockCgroupnetcls) ListAllCgroups(path string) []string {
+func (m *MockCgroupnetcls) ListAllCgroups(path string) ([]string, error) {
 	ret := m.ctrl.Call(m, "ListAllCgroups", path)
 	ret0, _ := ret[0].([]string)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // ListAllCgroups indicates an expected call of ListAllCgroups
@@ -149,3 +165,98 @@ func (m *MockCgroupnetcls) ListAllCgroups(path string) []string {
 func (mr *MockCgroupnetclsMockRecorder) ListAllCgroups(path interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAllCgroups", reflect.TypeOf((*MockCgroupnetcls)(nil).ListAllCgroups), path)
 }
+
+// MarkVal mocks base method
+// nolint
+func (m *MockCgroupnetcls) MarkVal() uint64 {
+	ret := m.ctrl.Call(m, "MarkVal")
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+// MarkVal indicates an expected call of MarkVal
+// nolint
+func (mr *MockCgroupnetclsMockRecorder) MarkVal() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkVal", reflect.TypeOf((*MockCgroupnetcls)(nil).MarkVal))
+}
+
+// CleanupAllCgroups mocks base method
+// nolint
+func (m *MockCgroupnetcls) CleanupAllCgroups() error {
+	ret := m.ctrl.Call(m, "CleanupAllCgroups")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CleanupAllCgroups indicates an expected call of CleanupAllCgroups
+// nolint
+func (mr *MockCgroupnetclsMockRecorder) CleanupAllCgroups() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CleanupAllCgroups", reflect.TypeOf((*MockCgroupnetcls)(nil).CleanupAllCgroups))
+}
+
+// MockMembershipWatcher is a mock of MembershipWatcher interface
+// nolint
+type MockMembershipWatcher struct {
+	ctrl     *gomock.Controller
+	recorder *MockMembershipWatcherMockRecorder
+}
+
+// MockMembershipWatcherMockRecorder is the mock recorder for MockMembershipWatcher
+// nolint
+type MockMembershipWatcherMockRecorder struct {
+	mock *MockMembershipWatcher
+}
+
+// NewMockMembershipWatcher creates a new mock instance
+// nolint
+func NewMockMembershipWatcher(ctrl *gomock.Controller) *MockMembershipWatcher {
+	mock := &MockMembershipWatcher{ctrl: ctrl}
+	mock.recorder = &MockMembershipWatcherMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+// nolint
+func (m *MockMembershipWatcher) EXPECT() *MockMembershipWatcherMockRecorder {
+	return m.recorder
+}
+
+// Run mocks base method
+// nolint
+func (m *MockMembershipWatcher) Run(ctx context.Context) error {
+	ret := m.ctrl.Call(m, "Run", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Run indicates an expected call of Run
+// nolint
+func (mr *MockMembershipWatcherMockRecorder) Run(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockMembershipWatcher)(nil).Run), ctx)
+}
+
+// Watch mocks base method
+// nolint
+func (m *MockMembershipWatcher) Watch(cgroupname string) error {
+	ret := m.ctrl.Call(m, "Watch", cgroupname)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Watch indicates an expected call of Watch
+// nolint
+func (mr *MockMembershipWatcherMockRecorder) Watch(cgroupname interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Watch", reflect.TypeOf((*MockMembershipWatcher)(nil).Watch), cgroupname)
+}
+
+// Unwatch mocks base method
+// nolint
+func (m *MockMembershipWatcher) Unwatch(cgroupname string) {
+	m.ctrl.Call(m, "Unwatch", cgroupname)
+}
+
+// Unwatch indicates an expected call of Unwatch
+// nolint
+func (mr *MockMembershipWatcherMockRecorder) Unwatch(cgroupname interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unwatch", reflect.TypeOf((*MockMembershipWatcher)(nil).Unwatch), cgroupname)
+}
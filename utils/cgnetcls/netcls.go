@@ -2,7 +2,10 @@ package cgnetcls
 
 import (
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 )
@@ -15,7 +18,6 @@ type netCls struct {
 }
 
 var basePath = "/sys/fs/cgroup/net_cls"
-var markval uint64 = Initialmarkval
 
 // GetCgroupList geta list of all cgroup names
 func GetCgroupList() []string {
@@ -42,3 +44,42 @@ func GetAssignedMarkVal(cgroupName string) string {
 	}
 	return string(mark[:len(mark)-1])
 }
+
+// GetAllMarks returns the classids of all the cgroups of the net_cls
+// hierarchy, including the cgroups that are not in a Trireme path. The
+// cgroups without a classid are ignored.
+func GetAllMarks() ([]uint64, error) {
+
+	marks := []uint64{}
+
+	err := filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The cgroups can be removed during the walk.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() || info.Name() != filepath.Base(markFile) {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+
+		// The kernel returns the classids in decimal, but they are written
+		// in hexadecimal.
+		mark, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 32)
+		if err != nil || mark == 0 {
+			return nil
+		}
+
+		marks = append(marks, mark)
+		return nil
+	})
+
+	return marks, err
+}
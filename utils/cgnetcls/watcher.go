@@ -0,0 +1,227 @@
+// +build linux,!darwin,!windows
+
+package cgnetcls
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// membershipPollInterval is the interval of the polling of the cgroups that
+// can't be watched with inotify.
+var membershipPollInterval = 5 * time.Second
+
+// membershipWatcher notifies the changes of the member processes of the
+// cgroups. The cgroup.procs files of the cgroups are watched with inotify,
+// and the cgroups are polled if inotify is not available.
+type membershipWatcher struct {
+	netcls   *netCls
+	callback MembershipFunc
+	inotify  *fsnotify.Watcher
+	// cgroups holds the last member count of the watched cgroups.
+	cgroups map[string]int
+	// polled holds the cgroups that are not watched with inotify.
+	polled map[string]bool
+	// paths maps the cgroup.procs files to their cgroups.
+	paths   map[string]string
+	running bool
+	sync.Mutex
+}
+
+// NewMembershipWatcher returns a watcher that calls the callback when the
+// member processes of the cgroups of the Trireme path change.
+func NewMembershipWatcher(triremepath string, callback MembershipFunc) MembershipWatcher {
+
+	return &membershipWatcher{
+		netcls: &netCls{
+			TriremePath: triremepath,
+		},
+		callback: callback,
+		cgroups:  map[string]int{},
+		polled:   map[string]bool{},
+		paths:    map[string]string{},
+	}
+}
+
+// Run starts watching the cgroups until the context is cancelled. The cgroups
+// are polled if inotify is not available.
+func (w *membershipWatcher) Run(ctx context.Context) error {
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.running {
+		return fmt.Errorf("membership watcher already running")
+	}
+
+	inotify, err := fsnotify.NewWatcher()
+	if err != nil {
+		zap.L().Warn("Unable to watch the cgroups, polling their processes", zap.Error(err))
+	}
+	w.inotify = inotify
+	w.running = true
+
+	// The cgroups watched before the start are added now.
+	for cgroupname := range w.cgroups {
+		w.add(cgroupname)
+	}
+
+	go w.watch(ctx, membershipPollInterval)
+
+	return nil
+}
+
+// Watch starts watching the member processes of the cgroup.
+func (w *membershipWatcher) Watch(cgroupname string) error {
+
+	path, err := w.netcls.cgroupPath(cgroupname)
+	if err != nil {
+		return err
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if _, ok := w.cgroups[cgroupname]; ok {
+		return nil
+	}
+
+	w.cgroups[cgroupname] = w.members(cgroupname)
+	w.paths[filepath.Join(path, procs)] = cgroupname
+
+	if w.running {
+		w.add(cgroupname)
+	}
+
+	return nil
+}
+
+// Unwatch stops watching the member processes of the cgroup.
+func (w *membershipWatcher) Unwatch(cgroupname string) {
+
+	w.Lock()
+	defer w.Unlock()
+
+	if _, ok := w.cgroups[cgroupname]; !ok {
+		return
+	}
+
+	for path, name := range w.paths {
+		if name != cgroupname {
+			continue
+		}
+		delete(w.paths, path)
+		if w.inotify != nil && !w.polled[cgroupname] {
+			// The watch is already removed if the cgroup was deleted.
+			w.inotify.Remove(path) // nolint errcheck
+		}
+	}
+
+	delete(w.cgroups, cgroupname)
+	delete(w.polled, cgroupname)
+}
+
+// add watches the cgroup with inotify, or polls it if it can't be watched.
+// It must be called with the lock held.
+func (w *membershipWatcher) add(cgroupname string) {
+
+	if w.inotify != nil {
+		for path, name := range w.paths {
+			if name != cgroupname {
+				continue
+			}
+			err := w.inotify.Add(path)
+			if err == nil {
+				return
+			}
+			zap.L().Debug("Unable to watch the cgroup, polling its processes",
+				zap.String("cgroup", cgroupname),
+				zap.Error(err),
+			)
+		}
+	}
+
+	w.polled[cgroupname] = true
+}
+
+// members returns the number of member processes of the cgroup.
+func (w *membershipWatcher) members(cgroupname string) int {
+
+	processes, err := w.netcls.ListCgroupProcesses(cgroupname)
+	if err != nil {
+		return 0
+	}
+
+	return len(processes)
+}
+
+// check calls the callback if the member count of the cgroup has changed.
+func (w *membershipWatcher) check(ctx context.Context, cgroupname string) {
+
+	count := w.members(cgroupname)
+
+	w.Lock()
+	last, ok := w.cgroups[cgroupname]
+	if !ok || last == count {
+		w.Unlock()
+		return
+	}
+	w.cgroups[cgroupname] = count
+	w.Unlock()
+
+	w.callback(ctx, cgroupname, count)
+}
+
+// watch processes the inotify events and polls the cgroups that are not
+// watched with inotify.
+func (w *membershipWatcher) watch(ctx context.Context, interval time.Duration) {
+
+	var events chan fsnotify.Event
+	var errors chan error
+	if w.inotify != nil {
+		defer w.inotify.Close() // nolint errcheck
+		events = w.inotify.Events
+		errors = w.inotify.Errors
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			w.Lock()
+			cgroupname, watched := w.paths[event.Name]
+			w.Unlock()
+			if watched {
+				w.check(ctx, cgroupname)
+			}
+		case err, ok := <-errors:
+			if !ok {
+				return
+			}
+			zap.L().Warn("Error while watching the cgroups", zap.Error(err))
+		case <-ticker.C:
+			w.Lock()
+			polled := make([]string, 0, len(w.polled))
+			for cgroupname := range w.polled {
+				polled = append(polled, cgroupname)
+			}
+			w.Unlock()
+			for _, cgroupname := range polled {
+				w.check(ctx, cgroupname)
+			}
+		}
+	}
+}
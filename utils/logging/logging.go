@@ -0,0 +1,260 @@
+// Package logging configures the global zap logger used by trireme. It allows
+// the log level to be set per named logger and the encoder to be switched
+// between console and JSON at run time.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// FormatConsole is the human readable console encoding
+	FormatConsole = "console"
+	// FormatJSON is the structured JSON encoding
+	FormatJSON = "json"
+)
+
+// Config is the configuration of the logger.
+type Config struct {
+	// Level is the default level of all the loggers
+	Level zapcore.Level
+	// Levels are the levels of specific named loggers. A level applies to
+	// the logger with the given name and all its children.
+	Levels map[string]zapcore.Level
+	// Format is either FormatConsole or FormatJSON
+	Format string
+}
+
+var (
+	levels = newLevelTable()
+	lock   sync.Mutex
+	format = FormatJSON
+	output = zapcore.Lock(os.Stderr)
+
+	namedLock    sync.RWMutex
+	namedLoggers = map[string]namedLogger{}
+)
+
+// namedLogger is a named child of the global logger.
+type namedLogger struct {
+	global *zap.Logger
+	logger *zap.Logger
+}
+
+// Setup configures the global zap logger.
+func Setup(cfg Config) error {
+
+	levels.reset(cfg.Level, cfg.Levels)
+
+	if cfg.Format == "" {
+		cfg.Format = FormatJSON
+	}
+
+	return SetFormat(cfg.Format)
+}
+
+// SetFormat switches the encoder of the global logger.
+func SetFormat(f string) error {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	var encoder zapcore.Encoder
+	switch f {
+	case FormatConsole:
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	case FormatJSON:
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	default:
+		return fmt.Errorf("unsupported log format %s", f)
+	}
+
+	core := &levelCore{
+		Core:   zapcore.NewCore(encoder, output, zapcore.DebugLevel),
+		levels: levels,
+	}
+
+	zap.ReplaceGlobals(zap.New(core, zap.AddCaller()))
+	format = f
+
+	return nil
+}
+
+// Format returns the current format of the global logger.
+func Format() string {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	return format
+}
+
+// Named returns the child of the global logger with the given name, whose
+// level can be set with SetLevel. The child is created once and reused until
+// the global logger is replaced, so that it can be called for every log.
+func Named(name string) *zap.Logger {
+
+	global := zap.L()
+
+	namedLock.RLock()
+	n, ok := namedLoggers[name]
+	namedLock.RUnlock()
+
+	if ok && n.global == global {
+		return n.logger
+	}
+
+	n = namedLogger{
+		global: global,
+		logger: global.Named(name),
+	}
+
+	namedLock.Lock()
+	namedLoggers[name] = n
+	namedLock.Unlock()
+
+	return n.logger
+}
+
+// SetLevel sets the level of the named logger and its children. An empty
+// name sets the default level.
+func SetLevel(name string, level zapcore.Level) {
+	levels.set(name, level)
+}
+
+// ParseLevels parses a comma separated list of name=level pairs, for
+// example "datapath=debug,kubernetes=warn".
+func ParseLevels(s string) (map[string]zapcore.Level, error) {
+
+	m := map[string]zapcore.Level{}
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid log level %s: expected name=level", pair)
+		}
+
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(parts[1])); err != nil {
+			return nil, fmt.Errorf("invalid log level %s: %s", pair, err)
+		}
+
+		m[parts[0]] = level
+	}
+
+	return m, nil
+}
+
+// levelTable holds the default level and the levels of the named loggers.
+type levelTable struct {
+	defaultLevel zapcore.Level
+	named        map[string]zapcore.Level
+	sync.RWMutex
+}
+
+func newLevelTable() *levelTable {
+	return &levelTable{
+		defaultLevel: zapcore.InfoLevel,
+		named:        map[string]zapcore.Level{},
+	}
+}
+
+func (t *levelTable) reset(defaultLevel zapcore.Level, named map[string]zapcore.Level) {
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.defaultLevel = defaultLevel
+	t.named = map[string]zapcore.Level{}
+	for name, level := range named {
+		t.named[name] = level
+	}
+}
+
+func (t *levelTable) set(name string, level zapcore.Level) {
+
+	t.Lock()
+	defer t.Unlock()
+
+	if name == "" {
+		t.defaultLevel = level
+		return
+	}
+
+	t.named[name] = level
+}
+
+// enabled returns true if the level is enabled for the named logger. The
+// level of the closest configured parent applies.
+func (t *levelTable) enabled(name string, level zapcore.Level) bool {
+
+	t.RLock()
+	defer t.RUnlock()
+
+	for name != "" {
+		if l, ok := t.named[name]; ok {
+			return l.Enabled(level)
+		}
+
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+
+	return t.defaultLevel.Enabled(level)
+}
+
+// lowest returns the lowest level enabled by any logger.
+func (t *levelTable) lowest() zapcore.Level {
+
+	t.RLock()
+	defer t.RUnlock()
+
+	lowest := t.defaultLevel
+	for _, l := range t.named {
+		if l < lowest {
+			lowest = l
+		}
+	}
+
+	return lowest
+}
+
+// levelCore filters the entries on the level of their named logger.
+type levelCore struct {
+	zapcore.Core
+	levels *levelTable
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.levels.lowest().Enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{
+		Core:   c.Core.With(fields),
+		levels: c.levels,
+	}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+
+	if !c.levels.enabled(entry.LoggerName, entry.Level) {
+		return ce
+	}
+
+	return ce.AddCore(entry, c)
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseLevels(t *testing.T) {
+
+	Convey("When I parse a valid list of levels", t, func() {
+		m, err := ParseLevels("datapath=debug, kubernetes=warn")
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, map[string]zapcore.Level{
+			"datapath":   zapcore.DebugLevel,
+			"kubernetes": zapcore.WarnLevel,
+		})
+	})
+
+	Convey("When I parse an empty list of levels", t, func() {
+		m, err := ParseLevels("")
+		So(err, ShouldBeNil)
+		So(len(m), ShouldEqual, 0)
+	})
+
+	Convey("When I parse a level without a name", t, func() {
+		_, err := ParseLevels("debug")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When I parse an invalid level", t, func() {
+		_, err := ParseLevels("datapath=verbose")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSetup(t *testing.T) {
+
+	Convey("Given a logger configured with per component levels", t, func() {
+		prevOutput := output
+		buf := &bytes.Buffer{}
+		output = zapcore.AddSync(buf)
+		defer func() {
+			output = prevOutput
+		}()
+
+		err := Setup(Config{
+			Level:  zapcore.InfoLevel,
+			Levels: map[string]zapcore.Level{"datapath": zapcore.DebugLevel},
+			Format: FormatJSON,
+		})
+		So(err, ShouldBeNil)
+		So(Format(), ShouldEqual, FormatJSON)
+
+		Convey("Debug logs should only be written for the datapath and its children", func() {
+			zap.L().Debug("global debug")
+			Named("kubernetes").Debug("kubernetes debug")
+			Named("datapath").Debug("datapath debug")
+			Named("datapath").Named("udp").Debug("udp debug")
+
+			So(buf.String(), ShouldNotContainSubstring, "global debug")
+			So(buf.String(), ShouldNotContainSubstring, "kubernetes debug")
+			So(buf.String(), ShouldContainSubstring, "datapath debug")
+			So(buf.String(), ShouldContainSubstring, "udp debug")
+		})
+
+		Convey("Changing a level at run time should apply to existing loggers", func() {
+			l := Named("kubernetes")
+			SetLevel("kubernetes", zapcore.DebugLevel)
+			l.Debug("kubernetes debug")
+			So(buf.String(), ShouldContainSubstring, "kubernetes debug")
+		})
+
+		Convey("Named loggers should be reused until the global logger is replaced", func() {
+			l := Named("datapath")
+			So(Named("datapath"), ShouldEqual, l)
+			So(Named("kubernetes"), ShouldNotEqual, l)
+
+			So(SetFormat(FormatConsole), ShouldBeNil)
+			So(Named("datapath"), ShouldNotEqual, l)
+			Named("datapath").Debug("replaced datapath debug")
+			So(buf.String(), ShouldContainSubstring, "replaced datapath debug")
+		})
+
+		Convey("Switching to the console format should not write JSON", func() {
+			So(SetFormat(FormatConsole), ShouldBeNil)
+			zap.L().Info("console info")
+			So(buf.String(), ShouldContainSubstring, "console info")
+			So(strings.HasPrefix(buf.String(), "{"), ShouldBeFalse)
+		})
+
+		Convey("Switching to an unknown format should fail", func() {
+			So(SetFormat("xml"), ShouldNotBeNil)
+			So(Format(), ShouldEqual, FormatJSON)
+		})
+	})
+}